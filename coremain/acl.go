@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+)
+
+// ACLConfig restricts which clients may get answers from upstreams, so
+// mosdns won't be an open resolver.
+type ACLConfig struct {
+	// Recursion lists ips and cidrs of clients that are allowed to get
+	// forwarded answers. Other clients get REFUSED, unless their queries
+	// were answered locally (e.g. by the hosts or arbitrary plugins)
+	// before forwarding. Cached answers from upstreams are not returned
+	// to them either, and the xdp fast path of cache cannot be enabled.
+	// Empty means all clients are allowed.
+	Recursion []string `yaml:"recursion"`
+}
+
+// parseRecursion returns nil if no acl is configured.
+func (c *ACLConfig) parseRecursion() (*netlist.List, error) {
+	if len(c.Recursion) == 0 {
+		return nil, nil
+	}
+	l := netlist.NewList()
+	for i, s := range c.Recursion {
+		if err := netlist.LoadFromText(l, s); err != nil {
+			return nil, fmt.Errorf("invalid recursion acl #%d %s, %w", i, s, err)
+		}
+	}
+	l.Sort()
+	return l, nil
+}
+
+// RecursionAllowed reports whether the client addr is allowed to get
+// answers from upstreams. See ACLConfig.Recursion.
+// Queries without a client addr, e.g. internal probes and cache
+// prefetches, are always allowed.
+func (m *Mosdns) RecursionAllowed(addr netip.Addr) bool {
+	if m.recursionACL == nil || !addr.IsValid() {
+		return true
+	}
+	return m.recursionACL.Contains(addr)
+}
+
+// RecursionACLEnabled reports whether the recursion acl is configured.
+func (m *Mosdns) RecursionACLEnabled() bool {
+	return m.recursionACL != nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestMosdns_RecursionAllowed(t *testing.T) {
+	m := new(Mosdns)
+	if !m.RecursionAllowed(netip.MustParseAddr("1.1.1.1")) {
+		t.Fatal("all clients should be allowed without acl")
+	}
+
+	c := ACLConfig{Recursion: []string{"192.168.0.0/16", "fd00::1"}}
+	l, err := c.parseRecursion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.recursionACL = l
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"1.1.1.1", false},
+		{"fd00::2", false},
+	}
+	for _, tt := range tests {
+		if got := m.RecursionAllowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("RecursionAllowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !m.RecursionAllowed(netip.Addr{}) {
+		t.Error("queries without client addr should be allowed")
+	}
+
+	c = ACLConfig{Recursion: []string{"not an ip"}}
+	if _, err := c.parseRecursion(); err == nil {
+		t.Fatal("invalid acl should fail")
+	}
+}
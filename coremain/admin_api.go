@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+// Optional interfaces of plugins that can be managed by the admin api.
+type (
+	// Flusher can be flushed, e.g. a cache.
+	Flusher interface {
+		Flush()
+	}
+
+	// Reloader can reload its data, e.g. rules from files.
+	Reloader interface {
+		Reload() error
+	}
+
+	// UpstreamSwitcher can enable or disable its upstreams by tags.
+	UpstreamSwitcher interface {
+		SetUpstreamEnabled(tag string, enabled bool) error
+	}
+
+	// SubscriptionSwitcher can enable or disable its subscriptions by
+	// names, e.g. blocklists.
+	SubscriptionSwitcher interface {
+		SetSubscriptionEnabled(name string, enabled bool) error
+	}
+
+	// StateInspector reports its runtime state. The state will be
+	// encoded as json.
+	StateInspector interface {
+		State() any
+	}
+
+	// QueryProber executes a query and reports how it was handled, e.g.
+	// a sequence. The report will be encoded as json.
+	QueryProber interface {
+		Probe(ctx context.Context, q *dns.Msg) any
+	}
+)
+
+// probeTimeout is the timeout of a query from the probe api.
+const probeTimeout = time.Second * 10
+
+// adminApi returns the router of admin api. All requests must have
+// the header "Authorization: Bearer <token>". Requests are recorded to
+// m.audit, if it is set.
+func (m *Mosdns) adminApi(token string) *chi.Mux {
+	r := chi.NewRouter()
+	if m.audit != nil {
+		r.Use(m.audit.middleware(m.name))
+	}
+	r.Use(bearerAuth(token))
+
+	r.Post("/reload", func(w http.ResponseWriter, req *http.Request) {
+		if err := m.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	r.Get("/plugins", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, m.pluginInfos())
+	})
+	r.Route("/plugins/{tag}", func(r chi.Router) {
+		r.Get("/", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			si, ok := p.(StateInspector)
+			if !ok {
+				http.Error(w, "plugin does not report its state", http.StatusNotImplemented)
+				return
+			}
+			writeJSON(w, si.State())
+		}))
+		r.Post("/flush", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			f, ok := p.(Flusher)
+			if !ok {
+				http.Error(w, "plugin can not be flushed", http.StatusNotImplemented)
+				return
+			}
+			f.Flush()
+		}))
+		r.Post("/reload", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			rl, ok := p.(Reloader)
+			if !ok {
+				http.Error(w, "plugin can not be reloaded", http.StatusNotImplemented)
+				return
+			}
+			if err := rl.Reload(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}))
+		r.Post("/probe", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			qp, ok := p.(QueryProber)
+			if !ok {
+				http.Error(w, "plugin can not be probed", http.StatusNotImplemented)
+				return
+			}
+			q, err := probeQuery(req.URL.Query().Get("name"), req.URL.Query().Get("type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), probeTimeout)
+			defer cancel()
+			writeJSON(w, qp.Probe(ctx, q))
+		}))
+		r.Post("/upstreams/{upstream}/{action:enable|disable}", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			us, ok := p.(UpstreamSwitcher)
+			if !ok {
+				http.Error(w, "plugin has no switchable upstream", http.StatusNotImplemented)
+				return
+			}
+			enabled := chi.URLParam(req, "action") == "enable"
+			if err := us.SetUpstreamEnabled(chi.URLParam(req, "upstream"), enabled); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+		r.Post("/subscriptions/{name}/{action:enable|disable}", m.adminPluginHandler(func(w http.ResponseWriter, req *http.Request, p any) {
+			ss, ok := p.(SubscriptionSwitcher)
+			if !ok {
+				http.Error(w, "plugin has no switchable subscription", http.StatusNotImplemented)
+				return
+			}
+			enabled := chi.URLParam(req, "action") == "enable"
+			if err := ss.SetSubscriptionEnabled(chi.URLParam(req, "name"), enabled); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+	})
+	return r
+}
+
+// adminPluginHandler calls h with the plugin of url parameter "tag".
+func (m *Mosdns) adminPluginHandler(h func(w http.ResponseWriter, req *http.Request, p any)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !m.ready.Load() {
+			http.Error(w, "plugins are loading", http.StatusServiceUnavailable)
+			return
+		}
+		tag := chi.URLParam(req, "tag")
+		p := m.GetPlugin(tag)
+		if p == nil {
+			http.Error(w, fmt.Sprintf("plugin %s not found", tag), http.StatusNotFound)
+			return
+		}
+		h(w, req, p)
+	}
+}
+
+type pluginInfo struct {
+	Tag          string   `json:"tag"`
+	Type         string   `json:"type"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+func (m *Mosdns) pluginInfos() []pluginInfo {
+	if !m.ready.Load() {
+		return nil
+	}
+	infos := make([]pluginInfo, 0, len(m.plugins))
+	for tag, p := range m.plugins {
+		info := pluginInfo{Tag: tag, Type: strings.TrimPrefix(fmt.Sprintf("%T", p), "*")}
+		if _, ok := p.(StateInspector); ok {
+			info.Capabilities = append(info.Capabilities, "state")
+		}
+		if _, ok := p.(Flusher); ok {
+			info.Capabilities = append(info.Capabilities, "flush")
+		}
+		if _, ok := p.(Reloader); ok {
+			info.Capabilities = append(info.Capabilities, "reload")
+		}
+		if _, ok := p.(UpstreamSwitcher); ok {
+			info.Capabilities = append(info.Capabilities, "upstreams")
+		}
+		if _, ok := p.(SubscriptionSwitcher); ok {
+			info.Capabilities = append(info.Capabilities, "subscriptions")
+		}
+		if _, ok := p.(QueryProber); ok {
+			info.Capabilities = append(info.Capabilities, "probe")
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Tag < infos[j].Tag })
+	return infos
+}
+
+// probeQuery builds a query of name and type. Type defaults to A.
+func probeQuery(name, typ string) (*dns.Msg, error) {
+	if len(name) == 0 {
+		return nil, errors.New("missing query name")
+	}
+	qt := dns.TypeA
+	if len(typ) > 0 {
+		t, ok := dns.StringToType[strings.ToUpper(typ)]
+		if !ok {
+			return nil, fmt.Errorf("invalid query type %s", typ)
+		}
+		qt = t
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), qt)
+	return q, nil
+}
+
+func bearerAuth(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got := []byte(req.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
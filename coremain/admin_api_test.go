@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+type testFlusher struct{ flushed bool }
+
+func (f *testFlusher) Flush() { f.flushed = true }
+
+type testProber struct{ q *dns.Msg }
+
+func (p *testProber) Probe(_ context.Context, q *dns.Msg) any {
+	p.q = q
+	return "ok"
+}
+
+type testSubscriptions map[string]bool
+
+func (s testSubscriptions) SetSubscriptionEnabled(name string, enabled bool) error {
+	if _, ok := s[name]; !ok {
+		return fmt.Errorf("subscription %s not found", name)
+	}
+	s[name] = enabled
+	return nil
+}
+
+func TestMosdns_adminApi(t *testing.T) {
+	f := new(testFlusher)
+	p := new(testProber)
+	subs := testSubscriptions{"ads": true}
+	m := NewTestMosdnsWithPlugins(map[string]any{"cache": f, "seq": p, "lists": subs, "other": struct{}{}})
+	m.ready.Store(true)
+	h := m.adminApi("secret")
+
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if c := do(http.MethodPost, "/plugins/cache/flush", ""); c != http.StatusUnauthorized {
+		t.Fatalf("want 401 without token, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/cache/flush", "wrong"); c != http.StatusUnauthorized {
+		t.Fatalf("want 401 with wrong token, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/cache/flush", "secret"); c != http.StatusOK || !f.flushed {
+		t.Fatalf("want flushed, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/other/flush", "secret"); c != http.StatusNotImplemented {
+		t.Fatalf("want 501, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/missing/flush", "secret"); c != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", c)
+	}
+	if c := do(http.MethodPost, "/reload", "secret"); c != http.StatusBadRequest {
+		t.Fatalf("want 400 if reloading is not supported, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/seq/probe?name=example.com&type=aaaa", "secret"); c != http.StatusOK {
+		t.Fatalf("want probed, got %d", c)
+	}
+	if q := p.q.Question[0]; q.Name != "example.com." || q.Qtype != dns.TypeAAAA {
+		t.Fatalf("unexpected probe query %v", q)
+	}
+	if c := do(http.MethodPost, "/plugins/seq/probe?name=example.com&type=bad", "secret"); c != http.StatusBadRequest {
+		t.Fatalf("want 400 for invalid type, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/other/probe?name=example.com", "secret"); c != http.StatusNotImplemented {
+		t.Fatalf("want 501, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/lists/subscriptions/ads/disable", "secret"); c != http.StatusOK || subs["ads"] {
+		t.Fatalf("want subscription disabled, got %d", c)
+	}
+	if c := do(http.MethodPost, "/plugins/lists/subscriptions/missing/enable", "secret"); c != http.StatusBadRequest {
+		t.Fatalf("want 400 for unknown subscription, got %d", c)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// AuditRecord is a record of an admin api request in the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Instance  string    `json:"instance,omitempty"`
+	Remote    string    `json:"remote"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+
+	// Prev is the hex sha256 of the previous record line. Empty for
+	// the first record.
+	Prev string `json:"prev"`
+}
+
+// auditLog appends AuditRecords to a file as json lines. Each record has
+// the hash of the previous line, so modified, inserted or removed records
+// break the chain. See VerifyAuditLog.
+type auditLog struct {
+	logger *zap.Logger
+	file   string
+
+	m    sync.Mutex
+	f    *os.File
+	prev string
+	refs int // protected by auditLogsMu.
+}
+
+// Audit logs are shared by mosdns instances in this process. When a
+// config is being reloaded, the old and the new mosdns may write the same
+// audit log at the same time. They must not fork the chain.
+var (
+	auditLogsMu sync.Mutex
+	auditLogs   = make(map[string]*auditLog)
+)
+
+// openAuditLog opens the audit log file and continues its chain.
+// It must be closed by auditLog.close.
+func openAuditLog(file string, logger *zap.Logger) (*auditLog, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	auditLogsMu.Lock()
+	defer auditLogsMu.Unlock()
+	if l := auditLogs[file]; l != nil {
+		l.refs++
+		return l, nil
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, last, err := VerifyAuditLog(f)
+	if err != nil {
+		// Don't refuse to start, but the broken chain must be noticed.
+		logger.Error("audit log is broken, a new chain is started", zap.String("file", file), zap.Error(err))
+		last = ""
+	}
+	logger.Info("audit log opened", zap.String("file", file), zap.Int("records", n))
+	l := &auditLog{logger: logger, file: file, f: f, prev: last, refs: 1}
+	auditLogs[file] = l
+	return l, nil
+}
+
+func (l *auditLog) append(r *AuditRecord) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	r.Prev = l.prev
+	b, err := json.Marshal(r)
+	if err != nil {
+		l.logger.Error("failed to encode audit record", zap.Error(err))
+		return
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		l.logger.Error("failed to write audit log", zap.Error(err))
+		return
+	}
+	l.prev = hashAuditLine(b)
+}
+
+func (l *auditLog) close() error {
+	auditLogsMu.Lock()
+	defer auditLogsMu.Unlock()
+	l.refs--
+	if l.refs > 0 {
+		return nil
+	}
+	delete(auditLogs, l.file)
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.f.Close()
+}
+
+func hashAuditLine(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// middleware records every request to next, including rejected ones.
+// instance is the name of the instance of the admin api.
+func (l *auditLog) middleware(instance string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+			start := time.Now()
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				l.append(&AuditRecord{
+					Time:      start,
+					Instance:  instance,
+					Remote:    req.RemoteAddr,
+					UserAgent: req.UserAgent(),
+					Method:    req.Method,
+					URL:       req.URL.RequestURI(),
+					Status:    status,
+				})
+			}()
+			next.ServeHTTP(ww, req)
+		})
+	}
+}
+
+// VerifyAuditLog verifies the hash chain of the audit log in r. It returns
+// the number of records and the hash of the last record.
+// Note that removed records at the end of the log cannot be detected
+// by the chain itself. Compare the last hash with a copy that was kept
+// elsewhere.
+func VerifyAuditLog(r io.Reader) (n int, last string, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		var rec AuditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, last, fmt.Errorf("invalid record #%d, %w", n, err)
+		}
+		if rec.Prev != last {
+			return n, last, fmt.Errorf("record #%d does not follow the previous record", n)
+		}
+		last = hashAuditLine(line)
+	}
+	return n, last, s.Err()
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestMosdns_adminApi_audit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(file, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewTestMosdnsWithPlugins(map[string]any{"cache": new(testFlusher)})
+	m.ready.Store(true)
+	m.audit = audit
+	h := m.adminApi("secret")
+	do := func(token string) {
+		req := httptest.NewRequest(http.MethodPost, "/plugins/cache/flush", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("secret")
+	do("wrong")
+
+	// The chain continues after reopening.
+	if err := audit.close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.audit, err = openAuditLog(file, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	h = m.adminApi("secret")
+	do("secret")
+	_ = m.audit.close()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := VerifyAuditLog(bytes.NewReader(b))
+	if err != nil || n != 3 {
+		t.Fatalf("want 3 verified records, got %d, %v", n, err)
+	}
+	if !bytes.Contains(b, []byte(`"status":401`)) {
+		t.Fatal("rejected request is not recorded")
+	}
+
+	tampered := bytes.Replace(b, []byte(`"status":401`), []byte(`"status":200`), 1)
+	if _, _, err := VerifyAuditLog(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("tampered log should fail")
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	removed := append(lines[0:1:1], lines[2:]...)
+	if _, _, err := VerifyAuditLog(bytes.NewReader(bytes.Join(removed, nil))); err == nil {
+		t.Fatal("log with a removed record should fail")
+	}
+}
@@ -24,10 +24,33 @@ import (
 )
 
 type Config struct {
-	Log     mlog.LogConfig `yaml:"log"`
-	Include []string       `yaml:"include"`
+	Log mlog.LogConfig `yaml:"log"`
+
+	// Include merges other config files into this config. Glob patterns,
+	// e.g. "rules/*.yaml", are supported. See resolveIncludes for how
+	// configs are merged.
+	Include []string `yaml:"include"`
+	// Import is an alias of Include.
+	Import []string `yaml:"import"`
+
 	Plugins []PluginConfig `yaml:"plugins"`
 	API     APIConfig      `yaml:"api"`
+	Tracing TracingConfig  `yaml:"tracing"`
+	Reload  ReloadConfig   `yaml:"reload"`
+
+	Privilege PrivilegeConfig `yaml:"privilege"`
+	Memory    MemoryConfig    `yaml:"memory"`
+	ACL       ACLConfig       `yaml:"acl"`
+
+	MetricsPush []MetricsPushConfig `yaml:"metrics_push"`
+
+	// Instances are isolated groups of plugins in this process. See
+	// InstanceConfig.
+	Instances []InstanceConfig `yaml:"instances"`
+
+	// files are the config files that were merged into this config.
+	files    []string
+	resolved bool // set by resolveIncludes.
 }
 
 // PluginConfig represents a plugin config
@@ -36,15 +59,85 @@ type PluginConfig struct {
 	// be registered with a random tag.
 	Tag string `yaml:"tag"`
 
-	// Type, required.
+	// Type, required if Preset is empty.
 	Type string `yaml:"type"`
 
+	// Preset expands this entry into a set of plugins, e.g. "china_split".
+	// Args are the args of the preset. See RegPreset.
+	Preset string `yaml:"preset"`
+
 	// Args, might be required by some plugins.
 	// The type of Args is depended on RegNewPluginFunc.
 	// If it's a map[string]any, it will be converted by mapstruct.
 	Args any `yaml:"args"`
+
+	file string // included file that defines this plugin, empty for the main config.
+	idx  int    // index in the plugins of its file.
+}
+
+// InstanceConfig configures an instance. An instance has its own plugin
+// namespace. Its plugins cannot refer to the plugins of the main config or
+// other instances, so tags can be reused. Caches, servers and metrics are
+// separated as well. Its api is under "/instances/<name>".
+type InstanceConfig struct {
+	// Name of the instance, required and unique.
+	Name    string         `yaml:"name"`
+	Plugins []PluginConfig `yaml:"plugins"`
 }
 
 type APIConfig struct {
 	HTTP string `yaml:"http"`
+
+	// Debug enables debug api under "/debug", including pprof profiles
+	// (which were always served under "/debug/pprof" in earlier versions),
+	// goroutine dumps, gc stats and heap snapshots.
+	// They may expose sensitive information and affect performance, so
+	// AdminToken is required and requests must carry it as the admin api.
+	Debug bool `yaml:"debug"`
+	// DebugDir is the dir that heap snapshots will be saved into.
+	// Default is os.TempDir().
+	DebugDir string `yaml:"debug_dir"`
+
+	// AdminToken enables admin api under "/admin". Requests must have
+	// the header "Authorization: Bearer <AdminToken>".
+	AdminToken string `yaml:"admin_token"`
+	// AuditLog is the file that every admin api request is recorded to,
+	// including rejected ones. Records are chained by hashes, so the file
+	// is tamper-evident. See VerifyAuditLog. Optional.
+	AuditLog string `yaml:"audit_log"`
+}
+
+// PrivilegeConfig drops the root privilege of mosdns after the plugins,
+// e.g. servers on privileged ports, were loaded. It is enabled if User
+// or Capabilities is set. Linux only.
+type PrivilegeConfig struct {
+	// User to run as. Empty means keeping the current user, only
+	// capabilities are dropped.
+	User string `yaml:"user"`
+	// Group to run as. Default is the primary group of User.
+	Group string `yaml:"group"`
+
+	// Capabilities to retain, e.g. ["net_bind_service", "net_admin"].
+	// Default is net_bind_service, so reloaded configs can still listen on
+	// privileged ports, plus net_admin if ipset, nftset or route plugins
+	// are loaded.
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// MemoryConfig limits the memory usage of mosdns, e.g. on routers with
+// little memory, where the OOM killer would take out DNS otherwise.
+type MemoryConfig struct {
+	// Limit is the memory target of the process, e.g. "256M", "1G" or a
+	// number of bytes. It is set as the soft memory limit of the go
+	// runtime, like GOMEMLIMIT. When the memory usage approaches it,
+	// caches and log buffers of plugins that implement MemoryShrinker
+	// shrink, and grow back when the memory is available again.
+	Limit string `yaml:"limit"`
+}
+
+type ReloadConfig struct {
+	// Watch reloads the config when the main config file or any included
+	// file is changed.
+	// Reloads can also be triggered by SIGHUP or the admin api.
+	Watch bool `yaml:"watch"`
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadConfig_formats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"c.yaml": `
+log:
+  level: error
+plugins:
+  - tag: main
+    type: sequence
+    args:
+      - exec: black_hole 1.2.3.4
+`,
+		"c.json": `{
+  "log": {"level": "error"},
+  "plugins": [
+    {"tag": "main", "type": "sequence", "args": [{"exec": "black_hole 1.2.3.4"}]}
+  ]
+}`,
+		"c.TOML": `
+[log]
+level = "error"
+
+[[plugins]]
+tag = "main"
+type = "sequence"
+args = [{ exec = "black_hole 1.2.3.4" }]
+`,
+	}
+
+	var want *Config
+	for name, s := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := loadConfig(p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want == nil {
+			want = cfg
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Fatalf("%s: want %+v, got %+v", name, want, cfg)
+		}
+	}
+
+	p := filepath.Join(dir, "c.conf")
+	if err := os.WriteFile(p, []byte(files["c.yaml"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig(p); err == nil {
+		t.Fatal("unsupported extension should be an error")
+	}
+
+	// Search config in the working dir.
+	t.Chdir(dir)
+	if _, _, err := loadConfig(""); err == nil {
+		t.Fatal("no config.* in the working dir should be an error")
+	}
+	if err := os.WriteFile("config.json", []byte(files["c.json"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, used, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != "config.json" || !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("want config.json loaded, got %s %+v", used, cfg)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PluginCounters are runtime counters of a plugin (or a plugin instance
+// in a sequence). It is safe for concurrent use.
+type PluginCounters struct {
+	name      string
+	processed atomic.Uint64
+	matched   atomic.Uint64
+	errs      atomic.Uint64
+	latency   atomic.Int64 // sum, in nanoseconds
+}
+
+// Observe records a call that started at start.
+// matched is ignored by executables.
+func (c *PluginCounters) Observe(start time.Time, matched bool, err error) {
+	c.processed.Add(1)
+	if matched {
+		c.matched.Add(1)
+	}
+	if err != nil {
+		c.errs.Add(1)
+	}
+	c.latency.Add(int64(time.Since(start)))
+}
+
+// Name returns the registered name of c.
+func (c *PluginCounters) Name() string {
+	return c.name
+}
+
+// PluginCountersSnapshot is a snapshot of PluginCounters.
+type PluginCountersSnapshot struct {
+	Name      string `json:"name"`
+	Processed uint64 `json:"processed"`
+	Matched   uint64 `json:"matched"`
+	Errors    uint64 `json:"errors"`
+	// Sum of latency in milliseconds.
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+func (c *PluginCounters) Snapshot() PluginCountersSnapshot {
+	return PluginCountersSnapshot{
+		Name:      c.name,
+		Processed: c.processed.Load(),
+		Matched:   c.matched.Load(),
+		Errors:    c.errs.Load(),
+		LatencyMs: float64(c.latency.Load()) / float64(time.Millisecond),
+	}
+}
+
+// CounterCollector is the central registry of PluginCounters.
+// It is also a prometheus.Collector.
+type CounterCollector struct {
+	m        sync.Mutex
+	counters map[string]*PluginCounters
+	names    []string // in registration order
+
+	processedDesc *prometheus.Desc
+	matchedDesc   *prometheus.Desc
+	errsDesc      *prometheus.Desc
+	latencyDesc   *prometheus.Desc
+}
+
+func NewCounterCollector() *CounterCollector {
+	labels := []string{"name"}
+	return &CounterCollector{
+		counters:      make(map[string]*PluginCounters),
+		processedDesc: prometheus.NewDesc("plugin_processed_total", "The total number of calls of the plugin", labels, nil),
+		matchedDesc:   prometheus.NewDesc("plugin_matched_total", "The total number of positive results of the matcher", labels, nil),
+		errsDesc:      prometheus.NewDesc("plugin_errors_total", "The total number of calls that returned an error", labels, nil),
+		latencyDesc:   prometheus.NewDesc("plugin_latency_seconds_total", "The total time spent in the plugin", labels, nil),
+	}
+}
+
+// Register returns the PluginCounters of name. If name has been registered,
+// the existing one will be returned.
+func (c *CounterCollector) Register(name string) *PluginCounters {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if pc, ok := c.counters[name]; ok {
+		return pc
+	}
+	pc := &PluginCounters{name: name}
+	c.counters[name] = pc
+	c.names = append(c.names, name)
+	return pc
+}
+
+// Snapshot returns snapshots of all counters in registration order.
+func (c *CounterCollector) Snapshot() []PluginCountersSnapshot {
+	c.m.Lock()
+	defer c.m.Unlock()
+	s := make([]PluginCountersSnapshot, 0, len(c.names))
+	for _, name := range c.names {
+		s = append(s, c.counters[name].Snapshot())
+	}
+	return s
+}
+
+func (c *CounterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.processedDesc
+	ch <- c.matchedDesc
+	ch <- c.errsDesc
+	ch <- c.latencyDesc
+}
+
+func (c *CounterCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(c.processedDesc, prometheus.CounterValue, float64(s.Processed), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.matchedDesc, prometheus.CounterValue, float64(s.Matched), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.errsDesc, prometheus.CounterValue, float64(s.Errors), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.latencyDesc, prometheus.CounterValue, s.LatencyMs/1000, s.Name)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// debugApi returns the router of debug api. Like the admin api, all
+// requests must have the header "Authorization: Bearer <token>" and are
+// recorded to m.audit, if it is set. Heap snapshots will be saved into dir.
+func (m *Mosdns) debugApi(token, dir string) *chi.Mux {
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+
+	r := chi.NewRouter()
+	if m.audit != nil {
+		r.Use(m.audit.middleware(m.name))
+	}
+	r.Use(bearerAuth(token))
+	r.Route("/pprof", func(r chi.Router) {
+		r.Get("/*", pprof.Index)
+		r.Get("/cmdline", pprof.Cmdline)
+		r.Get("/profile", pprof.Profile)
+		r.Get("/symbol", pprof.Symbol)
+		r.Get("/trace", pprof.Trace)
+	})
+	r.Get("/goroutines", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = rpprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	r.Get("/gc_stats", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(readGCStats())
+	})
+	r.Post("/heap_snapshot", func(w http.ResponseWriter, req *http.Request) {
+		p, err := writeHeapSnapshot(dir)
+		if err != nil {
+			m.logger.Error("failed to write heap snapshot", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.logger.Info("heap snapshot saved", zap.String("file", p))
+		_, _ = fmt.Fprintln(w, p)
+	})
+	r.Post("/free_os_memory", func(w http.ResponseWriter, req *http.Request) {
+		debug.FreeOSMemory()
+	})
+	return r
+}
+
+type gcStats struct {
+	NumGoroutine int              `json:"num_goroutine"`
+	NumGC        int64            `json:"num_gc"`
+	LastGC       time.Time        `json:"last_gc"`
+	PauseTotal   time.Duration    `json:"pause_total_ns"`
+	RecentPauses []time.Duration  `json:"recent_pauses_ns"`
+	MemStats     runtime.MemStats `json:"mem_stats"`
+}
+
+func readGCStats() *gcStats {
+	s := new(gcStats)
+	s.NumGoroutine = runtime.NumGoroutine()
+	gs := debug.GCStats{Pause: make([]time.Duration, 16)}
+	debug.ReadGCStats(&gs)
+	s.NumGC = gs.NumGC
+	s.LastGC = gs.LastGC
+	s.PauseTotal = gs.PauseTotal
+	s.RecentPauses = gs.Pause
+	runtime.ReadMemStats(&s.MemStats)
+	return s
+}
+
+// writeHeapSnapshot runs a gc and writes a heap profile into dir.
+// It returns the path of the profile.
+func writeHeapSnapshot(dir string) (string, error) {
+	p := filepath.Join(dir, fmt.Sprintf("mosdns-heap-%s.pprof", time.Now().Format("20060102-150405.000")))
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := rpprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		return "", err
+	}
+	return p, f.Close()
+}
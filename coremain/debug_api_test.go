@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMosdns_debugApi(t *testing.T) {
+	dir := t.TempDir()
+	m := NewTestMosdnsWithPlugins(nil)
+	m.initHttpMux(APIConfig{Debug: true, DebugDir: dir, AdminToken: "secret"})
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		m.httpMux.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/goroutines", "/debug/gc_stats"} {
+		if c := do(http.MethodGet, path, "").Code; c != http.StatusUnauthorized {
+			t.Fatalf("%s: want 401 without token, got %d", path, c)
+		}
+		if c := do(http.MethodGet, path, "wrong").Code; c != http.StatusUnauthorized {
+			t.Fatalf("%s: want 401 with wrong token, got %d", path, c)
+		}
+		if c := do(http.MethodGet, path, "secret").Code; c != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d", path, c)
+		}
+	}
+	for _, path := range []string{"/debug/heap_snapshot", "/debug/free_os_memory"} {
+		if c := do(http.MethodPost, path, "").Code; c != http.StatusUnauthorized {
+			t.Fatalf("%s: want 401 without token, got %d", path, c)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("unauthorized request wrote %d files", len(entries))
+	}
+
+	w := do(http.MethodPost, "/debug/heap_snapshot", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	p := strings.TrimSpace(w.Body.String())
+	if !strings.HasPrefix(p, dir) {
+		t.Fatalf("snapshot %s is not in %s", p, dir)
+	}
+	if fi, err := os.Stat(p); err != nil || fi.Size() == 0 {
+		t.Fatalf("invalid snapshot, %v", err)
+	}
+}
+
+func TestMosdns_debugApi_disabled(t *testing.T) {
+	m := NewTestMosdnsWithPlugins(nil)
+	m.initHttpMux(APIConfig{AdminToken: "secret"})
+	req := httptest.NewRequest(http.MethodGet, "/debug/gc_stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	m.httpMux.ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Body.String(), "Invalid request") {
+		t.Fatalf("debug api should not be registered, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestNewMosdns_debugRequiresToken(t *testing.T) {
+	cfg := &Config{API: APIConfig{Debug: true}}
+	if _, err := NewMosdns(cfg); err == nil {
+		t.Fatal("debug api without admin token should be refused")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// deps records the plugins that each plugin looks up by Mosdns.GetPlugin
+// while it is being initialized.
+// Plugins can only use the plugins that were initialized before them. So
+// the dependency graph never has cycles.
+type deps struct {
+	sync.Mutex
+	loading string              // tag of the plugin being initialized.
+	uses    map[string][]string // tag -> tags that were found.
+	missing []string            // tags that the last plugin failed to find.
+}
+
+func (d *deps) begin(tag string) {
+	d.Lock()
+	defer d.Unlock()
+	d.loading = tag
+	d.missing = nil
+}
+
+func (d *deps) end() {
+	d.Lock()
+	defer d.Unlock()
+	d.loading = ""
+}
+
+func (d *deps) record(tag string, found bool) {
+	d.Lock()
+	defer d.Unlock()
+	if len(d.loading) == 0 {
+		return
+	}
+	if !found {
+		if !slices.Contains(d.missing, tag) {
+			d.missing = append(d.missing, tag)
+		}
+		return
+	}
+	if d.uses == nil {
+		d.uses = make(map[string][]string)
+	}
+	if !slices.Contains(d.uses[d.loading], tag) {
+		d.uses[d.loading] = append(d.uses[d.loading], tag)
+	}
+}
+
+// PluginDeps returns the tags of plugins that each plugin uses.
+func (m *Mosdns) PluginDeps() map[string][]string {
+	m.deps.Lock()
+	defer m.deps.Unlock()
+	c := make(map[string][]string, len(m.deps.uses))
+	for tag, uses := range m.deps.uses {
+		c[tag] = slices.Clone(uses)
+	}
+	return c
+}
+
+// explainMissingDeps adds the reason of missing plugins to the init
+// error of the last plugin self. later are the plugins after it.
+func (m *Mosdns) explainMissingDeps(err error, self string, later []PluginConfig) error {
+	m.deps.Lock()
+	missing := slices.Clone(m.deps.missing)
+	m.deps.Unlock()
+
+	for _, tag := range missing {
+		defined := slices.ContainsFunc(later, func(pc PluginConfig) bool { return pc.Tag == tag })
+		switch {
+		case tag == self:
+			err = fmt.Errorf("%w (plugin %s can not use itself)", err, tag)
+		case defined:
+			err = fmt.Errorf("%w (plugin %s is defined after it, plugins can only use plugins that are defined before them)", err, tag)
+		default:
+			err = fmt.Errorf("%w (plugin %s is not defined)", err, tag)
+		}
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testDepArgs struct {
+	Use string `yaml:"use"`
+}
+
+func init() {
+	RegNewPluginFunc("test_dep", func(bp *BP, args any) (any, error) {
+		use := args.(*testDepArgs).Use
+		if len(use) > 0 && bp.M().GetPlugin(use) == nil {
+			return nil, errors.New("cannot find " + use)
+		}
+		return struct{}{}, nil
+	}, func() any { return new(testDepArgs) })
+}
+
+func Test_deps(t *testing.T) {
+	pc := func(tag, use string) PluginConfig {
+		return PluginConfig{Tag: tag, Type: "test_dep", Args: &testDepArgs{Use: use}}
+	}
+
+	m := NewTestMosdnsWithPlugins(make(map[string]any))
+	cfg := &Config{Plugins: []PluginConfig{pc("a", ""), pc("b", "a"), pc("c", "b")}}
+	if err := m.loadPluginsFromCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"b": {"a"}, "c": {"b"}}
+	if got := m.PluginDeps(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want deps %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name    string
+		plugins []PluginConfig
+		wantErr string
+	}{
+		{"defined after", []PluginConfig{pc("a", "b"), pc("b", "")}, "plugin b is defined after it"},
+		{"undefined", []PluginConfig{pc("a", "b")}, "plugin b is not defined"},
+		{"self", []PluginConfig{pc("a", "a")}, "plugin a can not use itself"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewTestMosdnsWithPlugins(make(map[string]any))
+			err := m.loadPluginsFromCfg(&Config{Plugins: tt.plugins})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("want err contains %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ReadinessChecker can be implemented by plugins that may not be able
+// to serve queries, e.g. all upstreams of a forwarder are down.
+type ReadinessChecker interface {
+	// Ready returns a non-nil error if the plugin is not ready.
+	Ready() error
+}
+
+// checkReady reports whether all plugins were loaded and all
+// ReadinessChecker plugins, including the ones of instances, are ready.
+func (m *Mosdns) checkReady() error {
+	if !m.ready.Load() {
+		return fmt.Errorf("plugins are loading")
+	}
+	errs := m.notReadyPlugins("")
+	for _, i := range m.instances {
+		errs = append(errs, i.notReadyPlugins(i.name+"/")...)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("plugins are not ready:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// notReadyPlugins returns the errors of the plugins that are not ready.
+// Their tags are prefixed with prefix.
+func (m *Mosdns) notReadyPlugins(prefix string) []string {
+	var errs []string
+	for tag, p := range m.plugins {
+		if rc, ok := p.(ReadinessChecker); ok {
+			if err := rc.Ready(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s%s: %s", prefix, tag, err))
+			}
+		}
+	}
+	return errs
+}
+
+func (m *Mosdns) healthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (m *Mosdns) readyz(w http.ResponseWriter, _ *http.Request) {
+	if err := m.checkReady(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
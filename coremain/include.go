@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"go.uber.org/zap"
+)
+
+// maxIncludeDepth limits nested includes. It also stops include loops.
+const maxIncludeDepth = 8
+
+// resolveIncludes loads the configs included by cfg recursively and
+// returns a copy of cfg that has them merged. cfg is not modified.
+//
+// Included configs are merged in order:
+//   - Plugins of included configs are placed before the plugins of cfg.
+//   - Metrics push targets and instances are appended.
+//   - Other sections, e.g. log, api, acl and memory, are taken from the
+//     first included config that has them, if cfg does not have them.
+//
+// Presets are expanded after configs are merged. See expandPresets.
+func resolveIncludes(cfg *Config) (*Config, error) {
+	if cfg.resolved {
+		return cfg, nil
+	}
+	merged, err := mergeIncludes(cfg, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.expandPresets(); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func mergeIncludes(cfg *Config, file string, depth int) (*Config, error) {
+	if depth > maxIncludeDepth {
+		return nil, errors.New("maximum include depth reached")
+	}
+
+	merged := *cfg
+	merged.Include, merged.Import = nil, nil
+	merged.Plugins = nil
+	merged.MetricsPush = slices.Clone(cfg.MetricsPush)
+	merged.Instances = nil
+	merged.files = nil
+	merged.resolved = true
+
+	for _, pattern := range slices.Concat(cfg.Include, cfg.Import) {
+		files, err := expandInclude(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			sub, path, err := loadConfig(f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read config from %s, %w", f, err)
+			}
+			mlog.L().Info("load config", zap.String("file", path))
+			sub, err = mergeIncludes(sub, f, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load config from %s, %w", f, err)
+			}
+
+			merged.Plugins = append(merged.Plugins, sub.Plugins...)
+			merged.MetricsPush = append(merged.MetricsPush, sub.MetricsPush...)
+			merged.Instances = append(merged.Instances, sub.Instances...)
+			setIfZero(&merged.Log, sub.Log)
+			setIfZero(&merged.API, sub.API)
+			setIfZero(&merged.Tracing, sub.Tracing)
+			setIfZero(&merged.Reload, sub.Reload)
+			setIfZero(&merged.Privilege, sub.Privilege)
+			setIfZero(&merged.ACL, sub.ACL)
+			setIfZero(&merged.Memory, sub.Memory)
+			merged.files = append(merged.files, path)
+			merged.files = append(merged.files, sub.files...)
+		}
+	}
+
+	for i, pc := range cfg.Plugins {
+		pc.file, pc.idx = file, i
+		merged.Plugins = append(merged.Plugins, pc)
+	}
+	for _, ic := range cfg.Instances {
+		ic.Plugins = slices.Clone(ic.Plugins)
+		for i := range ic.Plugins {
+			ic.Plugins[i].file, ic.Plugins[i].idx = file, i
+		}
+		merged.Instances = append(merged.Instances, ic)
+	}
+	return &merged, nil
+}
+
+// expandInclude returns the files of an include pattern in lexical order.
+// A pattern without glob meta characters is a file path, which must exist.
+// A glob pattern can match no file.
+func expandInclude(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %s, %w", pattern, err)
+	}
+	if len(files) == 0 {
+		mlog.L().Warn("include pattern matched no file", zap.String("pattern", pattern))
+	}
+	return files, nil
+}
+
+func setIfZero[T any](dst *T, src T) {
+	if reflect.ValueOf(dst).Elem().IsZero() {
+		*dst = src
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_resolveIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	write("rules/b.yaml", "plugins:\n  - tag: b\n    type: t\nmemory:\n  limit: 256M\n")
+	write("rules/a.yaml", "plugins:\n  - tag: a\n    type: t\napi:\n  http: 127.0.0.1:8080\n")
+	site := write("site.yaml", "include: ["+filepath.Join(dir, "nested.yaml")+"]\nlog:\n  level: debug\nplugins:\n  - tag: site\n    type: t\n")
+	write("nested.yaml", "plugins:\n  - tag: nested\n    type: t\nacl:\n  recursion: [192.168.0.0/16]\n")
+	main := write("main.yaml", strings.Join([]string{
+		"log:",
+		"  level: error",
+		"include:",
+		"  - " + filepath.Join(dir, "rules", "*.yaml"),
+		"  - " + filepath.Join(dir, "empty", "*.yaml"),
+		"import:",
+		"  - " + site,
+		"plugins:",
+		"  - tag: main",
+		"    type: t",
+	}, "\n"))
+
+	cfg, _, err := loadConfig(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := resolveIncludes(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags []string
+	for _, pc := range merged.Plugins {
+		tags = append(tags, pc.Tag)
+	}
+	if want := []string{"a", "b", "nested", "site", "main"}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("want plugins %v, got %v", want, tags)
+	}
+	if merged.Log.Level != "error" {
+		t.Fatalf("log of the main config should be kept, got %s", merged.Log.Level)
+	}
+	if merged.API.HTTP != "127.0.0.1:8080" {
+		t.Fatalf("api should be merged from include, got %s", merged.API.HTTP)
+	}
+	if want := []string{"192.168.0.0/16"}; !reflect.DeepEqual(merged.ACL.Recursion, want) {
+		t.Fatalf("acl should be merged from nested include, got %v", merged.ACL.Recursion)
+	}
+	if merged.Memory.Limit != "256M" {
+		t.Fatalf("memory should be merged from include, got %q", merged.Memory.Limit)
+	}
+	if len(merged.files) != 4 {
+		t.Fatalf("want 4 included files, got %v", merged.files)
+	}
+	if len(cfg.Plugins) != 1 {
+		t.Fatal("cfg should not be modified")
+	}
+	if again, _ := resolveIncludes(merged); again != merged {
+		t.Fatal("resolved config should not be resolved again")
+	}
+
+	// A missing file is an error, a pattern that matches no file is not.
+	if _, err := resolveIncludes(&Config{Include: []string{filepath.Join(dir, "missing.yaml")}}); err == nil {
+		t.Fatal("missing include file should be an error")
+	}
+
+	loop := write("loop.yaml", "include: ["+filepath.Join(dir, "loop.yaml")+"]\n")
+	cfg, _, err = loadConfig(loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveIncludes(cfg); err == nil || !strings.Contains(err.Error(), "maximum include depth") {
+		t.Fatalf("include loop should be an error, got %v", err)
+	}
+}
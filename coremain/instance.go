@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+)
+
+// newInstance loads an isolated instance from c and adds it to m.instances.
+// The instance shares the logger, the signal controller and the reloader
+// with m. Its api is mounted under "/instances/<name>" of m.
+func (m *Mosdns) newInstance(c InstanceConfig, apiCfg APIConfig) error {
+	if len(c.Name) == 0 {
+		return errors.New("missing instance name")
+	}
+	if strings.ContainsAny(c.Name, "/.") {
+		return fmt.Errorf("invalid instance name %s, it cannot contain '/' or '.'", c.Name)
+	}
+	for _, i := range m.instances {
+		if i.name == c.Name {
+			return fmt.Errorf("duplicated instance name %s", c.Name)
+		}
+	}
+
+	i := &Mosdns{
+		logger:     m.logger.Named(c.Name),
+		lg:         m.lg,
+		name:       c.Name,
+		plugins:    make(map[string]any),
+		types:      make(map[string]string),
+		httpMux:    chi.NewRouter(),
+		metricsReg: prometheus.NewRegistry(),
+		counters:   NewCounterCollector(),
+		sc:         m.sc,
+		reloader:   m.reloader,
+		dryRun:     m.dryRun,
+
+		recursionACL: m.recursionACL,
+		audit:        m.audit,
+	}
+	i.GetMetricsReg().MustRegister(i.counters)
+	i.httpMux.Get("/plugins_counters", i.countersHandler)
+	if len(apiCfg.AdminToken) > 0 {
+		i.httpMux.Mount("/admin", i.adminApi(apiCfg.AdminToken))
+	}
+	m.httpMux.Mount("/instances/"+c.Name, i.httpMux)
+	m.instances = append(m.instances, i)
+
+	i.closePluginsOnSignal()
+	if err := i.loadPresetPlugins(); err != nil {
+		return err
+	}
+	if err := i.loadPluginsFromCfg(&Config{Plugins: c.Plugins}); err != nil {
+		return err
+	}
+	i.logger.Info("all plugins of the instance are loaded")
+	i.ready.Store(true)
+	return nil
+}
+
+// Instance returns the instance with the given name. Nil if not found.
+func (m *Mosdns) Instance(name string) *Mosdns {
+	for _, i := range m.instances {
+		if i.name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the instance. Empty for the main mosdns.
+func (m *Mosdns) Name() string {
+	return m.name
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_newInstance(t *testing.T) {
+	pc := func(tag, use string) PluginConfig {
+		return PluginConfig{Tag: tag, Type: "test_dep", Args: &testDepArgs{Use: use}}
+	}
+
+	m := NewTestMosdnsWithPlugins(make(map[string]any))
+	if err := m.loadPluginsFromCfg(&Config{Plugins: []PluginConfig{pc("main", "")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same tags in different instances.
+	for _, name := range []string{"a", "b"} {
+		ic := InstanceConfig{Name: name, Plugins: []PluginConfig{pc("x", ""), pc("y", "x")}}
+		if err := m.newInstance(ic, APIConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.ready.Store(true)
+	a, b := m.Instance("a"), m.Instance("b")
+	if a == nil || b == nil {
+		t.Fatal("missing instance")
+	}
+	if a.GetPlugin("y") == nil || b.GetPlugin("y") == nil {
+		t.Fatal("missing plugins of instances")
+	}
+	if m.GetPlugin("x") != nil || a.GetPlugin("main") != nil {
+		t.Fatal("plugins of instances are not isolated from the main config")
+	}
+
+	tests := []struct {
+		name    string
+		ic      InstanceConfig
+		wantErr string
+	}{
+		{"no name", InstanceConfig{}, "missing instance name"},
+		{"invalid name", InstanceConfig{Name: "a/b"}, "invalid instance name"},
+		{"duplicated name", InstanceConfig{Name: "a"}, "duplicated instance name"},
+		{"main plugin", InstanceConfig{Name: "c", Plugins: []PluginConfig{pc("x", "main")}}, "plugin main is not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.newInstance(tt.ic, APIConfig{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("want err contains %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	a.GetCounterCollector().Register("x_counter").Observe(time.Now(), true, nil)
+	mfs, err := m.gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "instance" && l.GetValue() == "a" {
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		t.Fatal("missing metrics of instance a")
+	}
+
+	w := httptest.NewRecorder()
+	m.httpMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/instances/a/plugins_counters", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "x_counter") {
+		t.Fatalf("unexpected counters response %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MemoryShrinker can be implemented by plugins that trade memory for
+// performance, e.g. caches and log buffers. See MemoryConfig.
+type MemoryShrinker interface {
+	// ShrinkMemory limits the capacity of the plugin to ratio of its
+	// configured capacity. ratio is in (0, 1]. 1 restores the full
+	// capacity.
+	ShrinkMemory(ratio float64)
+}
+
+const (
+	memoryCheckInterval = time.Second
+
+	// Shrinkers shrink by half when the memory usage is above the high
+	// watermark, and grow slowly back when it is below the low one.
+	memoryHighWatermark = 0.9
+	memoryLowWatermark  = 0.7
+	memoryGrowFactor    = 1.25
+	minMemoryRatio      = 1.0 / 64
+)
+
+// defaultMemoryLimit is the soft memory limit at startup, which is set
+// by the GOMEMLIMIT env.
+var defaultMemoryLimit = debug.SetMemoryLimit(-1)
+
+// parseMemorySize parses sizes like "1024", "512K", "256MiB" and "1G".
+// Units are binary.
+func parseMemorySize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	var shift int
+	switch strings.ToLower(strings.TrimSpace(s[i:])) {
+	case "", "b":
+	case "k", "kb", "kib":
+		shift = 10
+	case "m", "mb", "mib":
+		shift = 20
+	case "g", "gb", "gib":
+		shift = 30
+	default:
+		return 0, fmt.Errorf("invalid size unit %q", s[i:])
+	}
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n << shift, nil
+}
+
+// memoryUsage returns the memory that the go runtime obtained from the
+// os and did not release, which approximates the resident memory.
+func memoryUsage() uint64 {
+	s := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(s)
+	return s[0].Value.Uint64() - s[1].Value.Uint64()
+}
+
+type memoryMonitor struct {
+	logger    *zap.Logger
+	limit     uint64
+	usage     func() uint64
+	shrinkers func() []MemoryShrinker
+	ratio     float64
+}
+
+// check adjusts the capacity ratio of shrinkers by the memory usage.
+func (mm *memoryMonitor) check() {
+	u := mm.usage()
+	ratio := mm.ratio
+	switch {
+	case float64(u) > float64(mm.limit)*memoryHighWatermark:
+		ratio = max(ratio/2, minMemoryRatio)
+	case float64(u) < float64(mm.limit)*memoryLowWatermark:
+		ratio = min(ratio*memoryGrowFactor, 1)
+	}
+	if ratio == mm.ratio {
+		return
+	}
+
+	shrunk := ratio < mm.ratio
+	mm.ratio = ratio
+	for _, s := range mm.shrinkers() {
+		s.ShrinkMemory(ratio)
+	}
+	if shrunk {
+		mm.logger.Warn("memory usage is high, shrinking caches", zap.Uint64("usage", u), zap.Uint64("limit", mm.limit), zap.Float64("ratio", ratio))
+		// Return the memory of evicted entries to the os now.
+		debug.FreeOSMemory()
+	} else if ratio == 1 {
+		mm.logger.Info("memory usage is low, caches were restored", zap.Uint64("usage", u), zap.Uint64("limit", mm.limit))
+	}
+}
+
+// memoryShrinkers returns the MemoryShrinker plugins of m and its
+// instances.
+func (m *Mosdns) memoryShrinkers() []MemoryShrinker {
+	var s []MemoryShrinker
+	for _, mi := range append([]*Mosdns{m}, m.instances...) {
+		for _, p := range mi.plugins {
+			if ms, ok := p.(MemoryShrinker); ok {
+				s = append(s, ms)
+			}
+		}
+	}
+	return s
+}
+
+// parseLimit returns the limit in bytes, or 0 if there is no limit.
+func (c MemoryConfig) parseLimit() (uint64, error) {
+	if len(c.Limit) == 0 {
+		return 0, nil
+	}
+	limit, err := parseMemorySize(c.Limit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit, %w", err)
+	}
+	return limit, nil
+}
+
+// startMemoryMonitor sets the soft memory limit of the process and starts
+// a monitor that shrinks plugins when the memory usage approaches it.
+// If limit is 0, the limit is reset to the default one.
+// It must be called after all plugins were loaded.
+func (m *Mosdns) startMemoryMonitor(limit uint64) {
+	if limit == 0 {
+		debug.SetMemoryLimit(defaultMemoryLimit) // The limit may be set by the last config.
+		return
+	}
+	debug.SetMemoryLimit(int64(limit))
+	m.logger.Info("memory limit is set", zap.Uint64("limit", limit))
+
+	mm := &memoryMonitor{
+		logger:    m.logger,
+		limit:     limit,
+		usage:     memoryUsage,
+		shrinkers: m.memoryShrinkers,
+		ratio:     1,
+	}
+	m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+		defer done()
+		ticker := time.NewTicker(memoryCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mm.check()
+			case <-closeSignal:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_parseMemorySize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint64
+		wantErr bool
+	}{
+		{s: "1024", want: 1024},
+		{s: "512K", want: 512 << 10},
+		{s: "256MiB", want: 256 << 20},
+		{s: "1 GB", want: 1 << 30},
+		{s: "1g", want: 1 << 30},
+		{s: "", wantErr: true},
+		{s: "1T", wantErr: true},
+		{s: "-1M", wantErr: true},
+		{s: "99999999999999G", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseMemorySize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMemorySize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+type testShrinker struct {
+	ratios []float64
+}
+
+func (s *testShrinker) ShrinkMemory(ratio float64) {
+	s.ratios = append(s.ratios, ratio)
+}
+
+func Test_memoryMonitor(t *testing.T) {
+	var usage uint64
+	s := new(testShrinker)
+	mm := &memoryMonitor{
+		logger:    zap.NewNop(),
+		limit:     100,
+		usage:     func() uint64 { return usage },
+		shrinkers: func() []MemoryShrinker { return []MemoryShrinker{s} },
+		ratio:     1,
+	}
+
+	for _, u := range []uint64{50, 80, 95, 95, 80, 60, 60, 60, 60} {
+		usage = u
+		mm.check()
+	}
+	want := []float64{0.5, 0.25, 0.3125, 0.390625, 0.48828125, 0.6103515625}
+	if len(s.ratios) != len(want) {
+		t.Fatalf("want ratios %v, got %v", want, s.ratios)
+	}
+	for i := range want {
+		if s.ratios[i] != want[i] {
+			t.Fatalf("want ratios %v, got %v", want, s.ratios)
+		}
+	}
+
+	// The ratio has a floor and a ceiling.
+	usage = 100
+	for i := 0; i < 20; i++ {
+		mm.check()
+	}
+	if mm.ratio != minMemoryRatio {
+		t.Fatalf("want min ratio, got %v", mm.ratio)
+	}
+	usage = 0
+	for i := 0; i < 40; i++ {
+		mm.check()
+	}
+	if mm.ratio != 1 {
+		t.Fatalf("want full ratio, got %v", mm.ratio)
+	}
+}
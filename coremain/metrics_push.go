@@ -0,0 +1,339 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// MetricsPushConfig configures a push exporter that periodically sends
+// all metrics to InfluxDB or statsd.
+type MetricsPushConfig struct {
+	// Format can be "influx" (InfluxDB line protocol) or "statsd"
+	// (with DogStatsD style tags).
+	Format string `yaml:"format"`
+	// Addr is the endpoint.
+	// For "influx", it can be a http(s) url of the write api, e.g.
+	// "http://127.0.0.1:8086/api/v2/write?org=o&bucket=b&precision=ns",
+	// or "udp://host:port".
+	// For "statsd", it is "host:port" of the udp endpoint.
+	Addr string `yaml:"addr"`
+	// Headers of http requests, e.g. {"Authorization": "Token xxx"}.
+	Headers map[string]string `yaml:"headers"`
+	// Interval in seconds. Default is 10.
+	Interval int `yaml:"interval"`
+}
+
+const (
+	pushFormatInflux = "influx"
+	pushFormatStatsd = "statsd"
+
+	pushTimeout       = time.Second * 5
+	maxUDPPayloadSize = 1432
+)
+
+type metricsPusher struct {
+	cfg    MetricsPushConfig
+	g      prometheus.Gatherer
+	logger *zap.Logger
+	client *http.Client
+
+	lastCounters map[string]float64 // for statsd counter deltas
+}
+
+func newMetricsPusher(cfg MetricsPushConfig, g prometheus.Gatherer, logger *zap.Logger) (*metricsPusher, error) {
+	utils.SetDefaultUnsignNum(&cfg.Interval, 10)
+	switch cfg.Format {
+	case pushFormatInflux, pushFormatStatsd:
+	default:
+		return nil, fmt.Errorf("invalid metrics push format [%s]", cfg.Format)
+	}
+	if len(cfg.Addr) == 0 {
+		return nil, errors.New("missing metrics push addr")
+	}
+	return &metricsPusher{
+		cfg:          cfg,
+		g:            g,
+		logger:       logger,
+		client:       &http.Client{Timeout: pushTimeout},
+		lastCounters: make(map[string]float64),
+	}, nil
+}
+
+// startMetricsPush starts pushers in cfgs. They will be stopped when m
+// is closed.
+func (m *Mosdns) startMetricsPush(cfgs []MetricsPushConfig) error {
+	for i, cfg := range cfgs {
+		p, err := newMetricsPusher(cfg, m.gatherer(), m.logger.Named("metrics_push"))
+		if err != nil {
+			return fmt.Errorf("invalid metrics push config #%d, %w", i, err)
+		}
+		m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+			defer done()
+			p.loop(closeSignal)
+		})
+	}
+	return nil
+}
+
+func (p *metricsPusher) loop(closeSignal <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(p.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.push(); err != nil {
+				p.logger.Warn("failed to push metrics", zap.String("addr", p.cfg.Addr), zap.Error(err))
+			}
+		case <-closeSignal:
+			return
+		}
+	}
+}
+
+func (p *metricsPusher) push() error {
+	mfs, err := p.g.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics, %w", err)
+	}
+	var lines []string
+	switch p.cfg.Format {
+	case pushFormatInflux:
+		lines = encodeInflux(mfs, time.Now())
+	case pushFormatStatsd:
+		lines = encodeStatsd(mfs, p.lastCounters)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if p.cfg.Format == pushFormatInflux && strings.HasPrefix(p.cfg.Addr, "http") {
+		return p.postHTTP(lines)
+	}
+	addr := strings.TrimPrefix(p.cfg.Addr, "udp://")
+	return sendUDP(addr, lines)
+}
+
+func (p *metricsPusher) postHTTP(lines []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.Addr, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	for k, v := range p.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendUDP sends lines to addr. Lines are packed into datagrams that are
+// smaller than maxUDPPayloadSize.
+func sendUDP(addr string, lines []string) error {
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	b := new(bytes.Buffer)
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		_, err := c.Write(b.Bytes())
+		b.Reset()
+		return err
+	}
+	for _, l := range lines {
+		if b.Len() > 0 && b.Len()+1+len(l) > maxUDPPayloadSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(l)
+	}
+	return flush()
+}
+
+// sample is a single value of a metric.
+type sample struct {
+	name   string
+	labels []*dto.LabelPair
+	value  float64
+	isCnt  bool // is a counter
+}
+
+// flatten converts metric families to samples. Histograms and summaries
+// are converted to their "_count" (as counters) and "_sum" samples.
+func flatten(mfs []*dto.MetricFamily) []sample {
+	var ss []sample
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			lb := m.GetLabel()
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				ss = append(ss, sample{name: name, labels: lb, value: m.GetCounter().GetValue(), isCnt: true})
+			case dto.MetricType_GAUGE:
+				ss = append(ss, sample{name: name, labels: lb, value: m.GetGauge().GetValue()})
+			case dto.MetricType_UNTYPED:
+				ss = append(ss, sample{name: name, labels: lb, value: m.GetUntyped().GetValue()})
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				ss = append(ss,
+					sample{name: name + "_count", labels: lb, value: float64(h.GetSampleCount()), isCnt: true},
+					sample{name: name + "_sum", labels: lb, value: h.GetSampleSum()},
+				)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				ss = append(ss,
+					sample{name: name + "_count", labels: lb, value: float64(s.GetSampleCount()), isCnt: true},
+					sample{name: name + "_sum", labels: lb, value: s.GetSampleSum()},
+				)
+			}
+		}
+	}
+	return ss
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// encodeInflux encodes metrics to InfluxDB line protocol. Each sample
+// is a measurement with a "value" field.
+func encodeInflux(mfs []*dto.MetricFamily, now time.Time) []string {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	var lines []string
+	for _, s := range flatten(mfs) {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		b := new(strings.Builder)
+		b.WriteString(influxMeasurementEscaper.Replace(s.name))
+		for _, l := range sortedLabels(s.labels) {
+			if len(l.GetValue()) == 0 {
+				continue
+			}
+			b.WriteByte(',')
+			b.WriteString(influxTagEscaper.Replace(l.GetName()))
+			b.WriteByte('=')
+			b.WriteString(influxTagEscaper.Replace(l.GetValue()))
+		}
+		b.WriteString(" value=")
+		b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(ts)
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_")
+
+// encodeStatsd encodes metrics to statsd lines with DogStatsD style tags.
+// Counters are sent as deltas ("|c") since last call, which are tracked
+// in last. Others are sent as gauges ("|g").
+func encodeStatsd(mfs []*dto.MetricFamily, last map[string]float64) []string {
+	var lines []string
+	seen := make(map[string]struct{})
+	for _, s := range flatten(mfs) {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		b := new(strings.Builder)
+		b.WriteString(statsdEscaper.Replace(s.name))
+		b.WriteByte(':')
+		var tags []string
+		for _, l := range sortedLabels(s.labels) {
+			if len(l.GetValue()) == 0 {
+				continue
+			}
+			tags = append(tags, statsdEscaper.Replace(l.GetName())+":"+statsdEscaper.Replace(l.GetValue()))
+		}
+		if s.isCnt {
+			key := s.name + "|" + strings.Join(tags, ",")
+			seen[key] = struct{}{}
+			prev, ok := last[key]
+			last[key] = s.value
+			delta := s.value - prev
+			if !ok || delta < 0 { // first push or counter was reset
+				delta = s.value
+			}
+			if delta == 0 {
+				continue
+			}
+			b.WriteString(strconv.FormatFloat(delta, 'g', -1, 64))
+			b.WriteString("|c")
+		} else {
+			b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			b.WriteString("|g")
+		}
+		if len(tags) > 0 {
+			b.WriteString("|#")
+			b.WriteString(strings.Join(tags, ","))
+		}
+		lines = append(lines, b.String())
+	}
+	for k := range last {
+		if _, ok := seen[k]; !ok {
+			delete(last, k)
+		}
+	}
+	return lines
+}
+
+func sortedLabels(lbs []*dto.LabelPair) []*dto.LabelPair {
+	if sort.SliceIsSorted(lbs, func(i, j int) bool { return lbs[i].GetName() < lbs[j].GetName() }) {
+		return lbs
+	}
+	s := make([]*dto.LabelPair, len(lbs))
+	copy(s, lbs)
+	sort.Slice(s, func(i, j int) bool { return s[i].GetName() < s[j].GetName() })
+	return s
+}
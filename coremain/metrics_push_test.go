@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_encodeMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "query_total", ConstLabels: map[string]string{"tag": "a b"}}, []string{"upstream"})
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "size"})
+	reg.MustRegister(c, g)
+	c.WithLabelValues("u1").Add(3)
+	g.Set(1.5)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := encodeInflux(mfs, time.Unix(1, 0))
+	want := []string{
+		`query_total,tag=a\ b,upstream=u1 value=3 1000000000`,
+		`size value=1.5 1000000000`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("influx: want %q, got %q", want, got)
+	}
+
+	last := make(map[string]float64)
+	got = encodeStatsd(mfs, last)
+	want = []string{
+		`query_total:3|c|#tag:a b,upstream:u1`,
+		`size:1.5|g`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("statsd: want %q, got %q", want, got)
+	}
+
+	// Counters are sent as deltas.
+	c.WithLabelValues("u1").Add(2)
+	mfs, _ = reg.Gather()
+	got = encodeStatsd(mfs, last)
+	want = []string{
+		`query_total:2|c|#tag:a b,upstream:u1`,
+		`size:1.5|g`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("statsd deltas: want %q, got %q", want, got)
+	}
+	mfs, _ = reg.Gather()
+	if got = encodeStatsd(mfs, last); len(got) != 1 {
+		t.Fatalf("unchanged counters should be skipped, got %q", got)
+	}
+}
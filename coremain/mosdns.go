@@ -21,51 +21,138 @@ package coremain
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
 	"github.com/IrineSistiana/mosdns/v5/pkg/safe_close"
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"go.uber.org/zap"
 	"io"
+	"net"
 	"net/http"
-	"net/http/pprof"
+	"slices"
+	"sync/atomic"
+	"time"
 )
 
 type Mosdns struct {
-	logger *zap.Logger // non-nil logger.
+	logger *zap.Logger  // non-nil logger.
+	lg     *mlog.Logger // root logger from config, nil in tests.
+
+	// name of the instance, empty for the main mosdns. See newInstance.
+	name      string
+	instances []*Mosdns
 
 	// Plugins
 	plugins map[string]any
+	order   []string          // tags in loading order.
+	types   map[string]string // tag -> type of plugins from config.
 
 	httpMux    *chi.Mux
 	metricsReg *prometheus.Registry
+	counters   *CounterCollector
 	sc         *safe_close.SafeClose
+
+	ready atomic.Bool // set after all plugins were loaded.
+
+	recursionACL *netlist.List // nil means no acl.
+	audit        *auditLog     // audit log of admin api, maybe nil.
+
+	// dryRun instances only init plugins, see NewDryRunMosdns.
+	dryRun bool
+	deps   deps
+
+	// reloader replaces m with a new mosdns that runs the reloaded
+	// config. Nil if reloading is not supported.
+	reloader func() error
 }
 
 // NewMosdns initializes a mosdns instance and its plugins.
 func NewMosdns(cfg *Config) (*Mosdns, error) {
+	return newMosdns(cfg, nil, false)
+}
+
+// NewDryRunMosdns loads the config file and initializes all plugins
+// from it, without starting the api server, metrics push, tracing and
+// server plugins. It is used to validate configs.
+// The returned mosdns should be closed by CloseWithErr.
+func NewDryRunMosdns(file string) (*Mosdns, error) {
+	cfg, _, err := loadConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("fail to load config, %w", err)
+	}
+	return newMosdns(cfg, nil, true)
+}
+
+// newMosdns initializes a mosdns instance that can be reloaded by
+// reloader. reloader can be nil.
+func newMosdns(cfg *Config, reloader func() error, dryRun bool) (*Mosdns, error) {
+	cfg, err := resolveIncludes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.API.Debug && len(cfg.API.AdminToken) == 0 {
+		return nil, errors.New("api debug requires an admin_token")
+	}
+
 	// Init logger.
 	lg, err := mlog.NewLogger(cfg.Log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init logger: %w", err)
 	}
+	memLimit, err := cfg.Memory.parseLimit()
+	if err != nil {
+		return nil, err
+	}
+	recursionACL, err := cfg.ACL.parseRecursion()
+	if err != nil {
+		return nil, err
+	}
 
 	m := &Mosdns{
-		logger:     lg,
+		logger:     lg.Logger,
+		lg:         lg,
 		plugins:    make(map[string]any),
+		types:      make(map[string]string),
 		httpMux:    chi.NewRouter(),
 		metricsReg: newMetricsReg(),
+		counters:   NewCounterCollector(),
 		sc:         safe_close.NewSafeClose(),
+		reloader:   reloader,
+		dryRun:     dryRun,
+
+		recursionACL: recursionACL,
 	}
-	// This must be called after m.httpMux and m.metricsReg been set.
-	m.initHttpMux()
+	if len(cfg.API.AdminToken) > 0 && len(cfg.API.AuditLog) > 0 && !dryRun {
+		audit, err := openAuditLog(cfg.API.AuditLog, m.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log, %w", err)
+		}
+		m.audit = audit
+		m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+			defer done()
+			<-closeSignal
+			_ = audit.close()
+		})
+	}
+	// This must be called after m.httpMux, m.metricsReg and m.counters been set.
+	m.initHttpMux(cfg.API)
 
 	// Start http api server
-	if httpAddr := cfg.API.HTTP; len(httpAddr) > 0 {
+	if httpAddr := cfg.API.HTTP; len(httpAddr) > 0 && !dryRun {
+		l, err := shared_listener.Listen(new(net.ListenConfig), "tcp", httpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start api http server, %w", err)
+		}
 		httpServer := &http.Server{
 			Addr:    httpAddr,
 			Handler: m.httpMux,
@@ -75,35 +162,41 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 			errChan := make(chan error, 1)
 			go func() {
 				m.logger.Info("starting api http server", zap.String("addr", httpAddr))
-				errChan <- httpServer.ListenAndServe()
+				errChan <- httpServer.Serve(l)
 			}()
 			select {
 			case err := <-errChan:
 				m.sc.SendCloseSignal(err)
 			case <-closeSignal:
+				// Give the request that triggered a reload a chance to
+				// get its response.
+				shared_listener.Release(l)
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				_ = httpServer.Shutdown(ctx)
+				cancel()
 				_ = httpServer.Close()
 			}
 		})
 	}
 
+	if !dryRun {
+		if err := m.startMetricsPush(cfg.MetricsPush); err != nil {
+			m.sc.SendCloseSignal(err)
+			_ = m.sc.WaitClosed()
+			return nil, err
+		}
+		if err := m.initTracing(cfg.Tracing); err != nil {
+			m.sc.SendCloseSignal(err)
+			_ = m.sc.WaitClosed()
+			return nil, err
+		}
+	}
+
 	// Load plugins.
 
 	// Close all plugins on signal.
 	// From here, call m.sc.SendCloseSignal() if any plugin failed to load.
-	m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
-		go func() {
-			defer done()
-			<-closeSignal
-			m.logger.Info("starting shutdown sequences")
-			for tag, p := range m.plugins {
-				if closer, _ := p.(io.Closer); closer != nil {
-					m.logger.Info("closing plugin", zap.String("tag", tag))
-					_ = closer.Close()
-				}
-			}
-			m.logger.Info("all plugins were closed")
-		}()
-	})
+	m.closePluginsOnSignal()
 
 	// Preset plugins
 	if err := m.loadPresetPlugins(); err != nil {
@@ -112,23 +205,59 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 		return nil, err
 	}
 	// Plugins from config.
-	if err := m.loadPluginsFromCfg(cfg, 0); err != nil {
+	if err := m.loadPluginsFromCfg(cfg); err != nil {
 		m.sc.SendCloseSignal(err)
 		_ = m.sc.WaitClosed()
 		return nil, err
 	}
+	for _, ic := range cfg.Instances {
+		if err := m.newInstance(ic, cfg.API); err != nil {
+			err = fmt.Errorf("failed to init instance %s, %w", ic.Name, err)
+			m.sc.SendCloseSignal(err)
+			_ = m.sc.WaitClosed()
+			return nil, err
+		}
+	}
 	m.logger.Info("all plugins are loaded")
+	m.ready.Store(true)
+
+	if !dryRun {
+		m.startMemoryMonitor(memLimit)
+	}
 
 	return m, nil
 }
 
+// closePluginsOnSignal closes all plugins when m.sc receives the close
+// signal. Plugins are closed in the reverse loading order. So servers,
+// which depend on other plugins, are closed and drained first.
+func (m *Mosdns) closePluginsOnSignal() {
+	m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+		go func() {
+			defer done()
+			<-closeSignal
+			m.logger.Info("starting shutdown sequences")
+			for i := len(m.order) - 1; i >= 0; i-- {
+				tag := m.order[i]
+				if closer, _ := m.plugins[tag].(io.Closer); closer != nil {
+					m.logger.Info("closing plugin", zap.String("tag", tag))
+					_ = closer.Close()
+				}
+			}
+			m.logger.Info("all plugins were closed")
+		}()
+	})
+}
+
 // NewTestMosdnsWithPlugins returns a mosdns instance for testing.
 func NewTestMosdnsWithPlugins(p map[string]any) *Mosdns {
 	return &Mosdns{
 		logger:     mlog.Nop(),
 		httpMux:    chi.NewRouter(),
 		plugins:    p,
+		types:      make(map[string]string),
 		metricsReg: newMetricsReg(),
+		counters:   NewCounterCollector(),
 		sc:         safe_close.NewSafeClose(),
 	}
 }
@@ -137,6 +266,19 @@ func (m *Mosdns) GetSafeClose() *safe_close.SafeClose {
 	return m.sc
 }
 
+// Reload loads the config again and starts a new mosdns with it. New
+// queries are handled by the new mosdns, and m is closed after its
+// queries in flight are done.
+// It returns an error if the config is invalid or reloading is not
+// supported. In this case, m keeps running.
+func (m *Mosdns) Reload() error {
+	if m.reloader == nil {
+		return errors.New("reload is not supported")
+	}
+	m.logger.Info("reload requested")
+	return m.reloader()
+}
+
 // CloseWithErr is a shortcut for m.sc.SendCloseSignal
 func (m *Mosdns) CloseWithErr(err error) {
 	m.sc.SendCloseSignal(err)
@@ -147,14 +289,69 @@ func (m *Mosdns) Logger() *zap.Logger {
 	return m.logger
 }
 
+// PluginLogger returns a non-nil logger for the plugin tag.
+// It respects the plugin log level in the config.
+func (m *Mosdns) PluginLogger(tag string) *zap.Logger {
+	if m.lg == nil {
+		return m.logger.Named(tag)
+	}
+	if len(m.name) > 0 {
+		// Plugin levels of instances are configured by "name.tag".
+		return m.lg.PluginLogger(m.name + "." + tag)
+	}
+	return m.lg.PluginLogger(tag)
+}
+
 // GetPlugin returns a plugin.
 func (m *Mosdns) GetPlugin(tag string) any {
-	return m.plugins[tag]
+	p := m.plugins[tag]
+	m.deps.record(tag, p != nil)
+	return p
+}
+
+// PluginTags returns the tags of all loaded plugins in loading order.
+func (m *Mosdns) PluginTags() []string {
+	return slices.Clone(m.order)
+}
+
+// PluginType returns the type of the plugin from config. It returns an
+// empty string for preset plugins.
+func (m *Mosdns) PluginType(tag string) string {
+	return m.types[tag]
+}
+
+// DryRun reports whether m only inits plugins for validating configs.
+// Server plugins should not listen on sockets in a dry run.
+func (m *Mosdns) DryRun() bool {
+	return m.dryRun
 }
 
-// GetMetricsReg returns a prometheus.Registerer with a prefix of "mosdns_"
+// GetMetricsReg returns a prometheus.Registerer with a prefix of "mosdns_".
+// Metrics of instances have an "instance" label.
 func (m *Mosdns) GetMetricsReg() prometheus.Registerer {
-	return prometheus.WrapRegistererWithPrefix("mosdns_", m.metricsReg)
+	var r prometheus.Registerer = m.metricsReg
+	if len(m.name) > 0 {
+		r = prometheus.WrapRegistererWith(prometheus.Labels{"instance": m.name}, r)
+	}
+	return prometheus.WrapRegistererWithPrefix("mosdns_", r)
+}
+
+// gatherer gathers metrics of m and its instances.
+func (m *Mosdns) gatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gs := prometheus.Gatherers{m.metricsReg}
+		if m.ready.Load() {
+			for _, i := range m.instances {
+				gs = append(gs, i.metricsReg)
+			}
+		}
+		return gs.Gather()
+	})
+}
+
+// GetCounterCollector returns the central registry of plugin counters.
+func (m *Mosdns) GetCounterCollector() *CounterCollector {
+	return m.counters
 }
 
 func (m *Mosdns) GetAPIRouter() *chi.Mux {
@@ -172,19 +369,29 @@ func newMetricsReg() *prometheus.Registry {
 	return reg
 }
 
-// initHttpMux initializes api entries. It MUST be called after m.metricsReg being initialized.
-func (m *Mosdns) initHttpMux() {
+// initHttpMux initializes api entries. It MUST be called after m.metricsReg
+// and m.counters being initialized.
+func (m *Mosdns) initHttpMux(apiCfg APIConfig) {
 	// Register metrics.
-	m.httpMux.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(m.metricsReg, promhttp.HandlerOpts{}))
-
-	// Register pprof.
-	m.httpMux.Route("/debug/pprof", func(r chi.Router) {
-		r.Get("/*", pprof.Index)
-		r.Get("/cmdline", pprof.Cmdline)
-		r.Get("/profile", pprof.Profile)
-		r.Get("/symbol", pprof.Symbol)
-		r.Get("/trace", pprof.Trace)
-	})
+	m.GetMetricsReg().MustRegister(m.counters)
+	m.httpMux.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(m.gatherer(), promhttp.HandlerOpts{}))
+
+	// Plugin counters.
+	m.httpMux.Get("/plugins_counters", m.countersHandler)
+
+	// Health checks.
+	m.httpMux.Get("/healthz", m.healthz)
+	m.httpMux.Get("/readyz", m.readyz)
+
+	// Admin api.
+	if len(apiCfg.AdminToken) > 0 {
+		m.httpMux.Mount("/admin", m.adminApi(apiCfg.AdminToken))
+	}
+
+	// Debug api.
+	if apiCfg.Debug {
+		m.httpMux.Mount("/debug", m.debugApi(apiCfg.AdminToken, apiCfg.DebugDir))
+	}
 
 	// A helper page for invalid request.
 	invalidApiReqHelper := func(w http.ResponseWriter, req *http.Request) {
@@ -204,6 +411,11 @@ func (m *Mosdns) initHttpMux() {
 	m.httpMux.MethodNotAllowed(invalidApiReqHelper)
 }
 
+func (m *Mosdns) countersHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(m.counters.Snapshot())
+}
+
 func (m *Mosdns) loadPresetPlugins() error {
 	for tag, f := range LoadNewPersetPluginFuncs() {
 		p, err := f(NewBP(tag, m))
@@ -211,33 +423,21 @@ func (m *Mosdns) loadPresetPlugins() error {
 			return fmt.Errorf("failed to init preset plugin %s, %w", tag, err)
 		}
 		m.plugins[tag] = p
+		m.order = append(m.order, tag)
 	}
 	return nil
 }
 
-// loadPluginsFromCfg loads plugins from this config. It follows include first.
-func (m *Mosdns) loadPluginsFromCfg(cfg *Config, includeDepth int) error {
-	const maxIncludeDepth = 8
-	if includeDepth > maxIncludeDepth {
-		return errors.New("maximum include depth reached")
-	}
-	includeDepth++
-
-	// Follow include first.
-	for _, s := range cfg.Include {
-		subCfg, path, err := loadConfig(s)
-		if err != nil {
-			return fmt.Errorf("failed to read config from %s, %w", s, err)
-		}
-		m.logger.Info("load config", zap.String("file", path))
-		if err := m.loadPluginsFromCfg(subCfg, includeDepth); err != nil {
-			return fmt.Errorf("failed to load config from %s, %w", s, err)
-		}
-	}
-
+// loadPluginsFromCfg loads plugins from this config. Includes of cfg must
+// have been resolved by resolveIncludes.
+func (m *Mosdns) loadPluginsFromCfg(cfg *Config) error {
 	for i, pc := range cfg.Plugins {
 		if err := m.newPlugin(pc); err != nil {
-			return fmt.Errorf("failed to init plugin #%d %s, %w", i, pc.Tag, err)
+			err = fmt.Errorf("failed to init plugin #%d %s, %w", pc.idx, pc.Tag, m.explainMissingDeps(err, pc.Tag, cfg.Plugins[i+1:]))
+			if len(pc.file) > 0 {
+				err = fmt.Errorf("failed to load config from %s, %w", pc.file, err)
+			}
+			return err
 		}
 	}
 	return nil
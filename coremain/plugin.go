@@ -111,11 +111,15 @@ func (m *Mosdns) newPlugin(c PluginConfig) error {
 	}
 
 	m.logger.Info("loading plugin", zap.String("tag", c.Tag), zap.String("type", c.Type))
+	m.deps.begin(c.Tag)
 	p, err := typeInfo.NewPlugin(NewBP(c.Tag, m), args)
+	m.deps.end()
 	if err != nil {
 		return fmt.Errorf("failed to init plugin: %w", err)
 	}
 	m.plugins[c.Tag] = p
+	m.order = append(m.order, c.Tag)
+	m.types[c.Tag] = c.Type
 	return nil
 }
 
@@ -172,7 +176,7 @@ type BP struct {
 func NewBP(tag string, m *Mosdns) *BP {
 	return &BP{
 		tag: tag,
-		l:   m.Logger().Named(tag),
+		l:   m.PluginLogger(tag),
 		m:   m,
 	}
 }
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// PresetFunc expands the args of a preset into plugin configs. tag is the
+// tag of the preset entry. Tags of the expanded plugins should be tag or
+// start with tag, so a config can use a preset more than once.
+type PresetFunc func(tag string, args any) ([]PluginConfig, error)
+
+var presetRegister struct {
+	sync.RWMutex
+	m map[string]PresetFunc
+}
+
+// RegPreset registers a preset. A preset is pure config expansion. It
+// cannot do anything that a config cannot do.
+// If the preset has been registered, RegPreset will panic.
+func RegPreset(name string, f PresetFunc) {
+	presetRegister.Lock()
+	defer presetRegister.Unlock()
+
+	if presetRegister.m == nil {
+		presetRegister.m = make(map[string]PresetFunc)
+	}
+	if _, ok := presetRegister.m[name]; ok {
+		panic(fmt.Sprintf("duplicate preset [%s]", name))
+	}
+	presetRegister.m[name] = f
+}
+
+// GetPreset gets the registered preset.
+func GetPreset(name string) (PresetFunc, bool) {
+	presetRegister.RLock()
+	defer presetRegister.RUnlock()
+
+	f, ok := presetRegister.m[name]
+	return f, ok
+}
+
+// GetAllPresets returns names of all registered presets in order.
+func GetAllPresets() []string {
+	presetRegister.RLock()
+	defer presetRegister.RUnlock()
+
+	var s []string
+	for name := range presetRegister.m {
+		s = append(s, name)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// expandPresets expands the preset entries of the plugins of cfg and its
+// instances.
+func (cfg *Config) expandPresets() error {
+	ps, err := expandPresets(cfg.Plugins)
+	if err != nil {
+		return err
+	}
+	cfg.Plugins = ps
+	for i := range cfg.Instances {
+		ic := &cfg.Instances[i]
+		ps, err := expandPresets(ic.Plugins)
+		if err != nil {
+			return fmt.Errorf("instance %s, %w", ic.Name, err)
+		}
+		ic.Plugins = ps
+	}
+	return nil
+}
+
+// expandPresets replaces preset entries in plugins with the plugins they
+// expand into. The tag of a preset entry defaults to its preset name.
+// An explicitly defined plugin overrides the expanded plugin that has the
+// same tag. It takes the place of the expanded one, so it can be defined
+// anywhere in the config and still be loaded before its users.
+func expandPresets(plugins []PluginConfig) ([]PluginConfig, error) {
+	explicit := make(map[string]int) // tag -> index in plugins
+	for i, pc := range plugins {
+		if len(pc.Preset) == 0 && len(pc.Tag) > 0 {
+			if _, dup := explicit[pc.Tag]; !dup {
+				explicit[pc.Tag] = i
+			}
+		}
+	}
+
+	var expanded []PluginConfig
+	overridden := make(map[int]bool)
+	for i, pc := range plugins {
+		if len(pc.Preset) == 0 {
+			if !overridden[i] {
+				expanded = append(expanded, pc)
+			}
+			continue
+		}
+
+		ps, err := expandPreset(pc)
+		if err != nil {
+			err = fmt.Errorf("failed to expand preset #%d %s, %w", pc.idx, pc.Preset, err)
+			if len(pc.file) > 0 {
+				err = fmt.Errorf("failed to load config from %s, %w", pc.file, err)
+			}
+			return nil, err
+		}
+		for _, p := range ps {
+			if j, ok := explicit[p.Tag]; ok {
+				if !overridden[j] {
+					overridden[j] = true
+					if j < i {
+						// It was defined before the preset. Move it here,
+						// it may use other plugins of the preset.
+						k := slices.IndexFunc(expanded, func(e PluginConfig) bool { return e.Tag == p.Tag })
+						expanded = slices.Delete(expanded, k, k+1)
+					}
+					expanded = append(expanded, plugins[j])
+				}
+				continue
+			}
+			expanded = append(expanded, p)
+		}
+	}
+	return expanded, nil
+}
+
+func expandPreset(pc PluginConfig) ([]PluginConfig, error) {
+	if len(pc.Type) > 0 {
+		return nil, fmt.Errorf("preset entry cannot have a type")
+	}
+	f, ok := GetPreset(pc.Preset)
+	if !ok {
+		return nil, fmt.Errorf("preset %s not defined", pc.Preset)
+	}
+	tag := pc.Tag
+	if len(tag) == 0 {
+		tag = pc.Preset
+	}
+	ps, err := f(tag, pc.Args)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ps {
+		ps[i].file, ps[i].idx = pc.file, pc.idx
+	}
+	return ps, nil
+}
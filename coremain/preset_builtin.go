@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
+
+func init() {
+	RegPreset("china_split", presetChinaSplit)
+	RegPreset("adblock_basic", presetAdblockBasic)
+}
+
+type chinaSplitArgs struct {
+	// Local and Remote are upstream addresses.
+	Local  []string `yaml:"local"`
+	Remote []string `yaml:"remote"`
+
+	// DirectDomains and DirectIPs are domain and ip list files. Domains
+	// in DirectDomains are always forwarded to local upstreams. Other
+	// responses from local upstreams are accepted only if they have
+	// ips in DirectIPs.
+	DirectDomains []string `yaml:"direct_domains"`
+	DirectIPs     []string `yaml:"direct_ips"`
+
+	CacheSize int `yaml:"cache_size"`
+
+	// Jump are sequences to jump to before the query is forwarded,
+	// e.g. an adblock_basic preset.
+	Jump []string `yaml:"jump"`
+
+	// Listen starts udp and tcp servers on this address. Optional.
+	Listen string `yaml:"listen"`
+}
+
+// presetChinaSplit forwards domains and responses in china lists to local
+// upstreams and others to remote upstreams. Plugins:
+//   - <tag>_direct_domains: domain_set
+//   - <tag>_direct_ips: ip_set
+//   - <tag>_local, <tag>_remote: forward
+//   - <tag>_cache: cache
+//   - <tag>: sequence, the entry.
+//   - <tag>_udp, <tag>_tcp: servers, if listen is set.
+func presetChinaSplit(tag string, args any) ([]PluginConfig, error) {
+	a := chinaSplitArgs{
+		Local:         []string{"223.5.5.5", "119.29.29.29"},
+		Remote:        []string{"https://8.8.8.8/dns-query", "https://1.1.1.1/dns-query"},
+		DirectDomains: []string{"geosite_cn.txt"},
+		DirectIPs:     []string{"geoip_cn.txt"},
+		CacheSize:     8192,
+	}
+	if args != nil {
+		if err := utils.WeakDecode(args, &a); err != nil {
+			return nil, fmt.Errorf("unable to decode preset args: %w", err)
+		}
+	}
+
+	var rules []any
+	for _, s := range a.Jump {
+		rules = append(rules, presetRule("", "jump "+s))
+	}
+	rules = append(rules,
+		presetRule("", "$"+tag+"_cache"),
+		presetRule("qname $"+tag+"_direct_domains", "$"+tag+"_local"),
+		presetRule("has_resp", "accept"),
+		presetRule("", "$"+tag+"_local"),
+		presetRule("resp_ip $"+tag+"_direct_ips", "accept"),
+		presetRule("", "$"+tag+"_remote"),
+	)
+
+	ps := []PluginConfig{
+		{Tag: tag + "_direct_domains", Type: "domain_set", Args: map[string]any{"files": a.DirectDomains}},
+		{Tag: tag + "_direct_ips", Type: "ip_set", Args: map[string]any{"files": a.DirectIPs}},
+		{Tag: tag + "_local", Type: "forward", Args: presetForwardArgs(a.Local)},
+		{Tag: tag + "_remote", Type: "forward", Args: presetForwardArgs(a.Remote)},
+		{Tag: tag + "_cache", Type: "cache", Args: map[string]any{"size": a.CacheSize}},
+		{Tag: tag, Type: "sequence", Args: rules},
+	}
+	return append(ps, presetServers(tag, a.Listen)...), nil
+}
+
+type adblockBasicArgs struct {
+	// Files and Exps are domains to be blocked.
+	Files []string `yaml:"files"`
+	Exps  []string `yaml:"exps"`
+
+	// Rcode of responses of blocked queries. Default is NXDOMAIN.
+	Rcode *int `yaml:"rcode"`
+}
+
+// presetAdblockBasic blocks domains in lists. Plugins:
+//   - <tag>_domains: domain_set
+//   - <tag>: sequence. Use it by "jump <tag>".
+func presetAdblockBasic(tag string, args any) ([]PluginConfig, error) {
+	a := adblockBasicArgs{}
+	if args != nil {
+		if err := utils.WeakDecode(args, &a); err != nil {
+			return nil, fmt.Errorf("unable to decode preset args: %w", err)
+		}
+	}
+	if len(a.Files) == 0 && len(a.Exps) == 0 {
+		a.Files = []string{"adblock.txt"}
+	}
+	rcode := 3
+	if a.Rcode != nil {
+		rcode = *a.Rcode
+	}
+
+	return []PluginConfig{
+		{Tag: tag + "_domains", Type: "domain_set", Args: map[string]any{"files": a.Files, "exps": a.Exps}},
+		{Tag: tag, Type: "sequence", Args: []any{
+			presetRule("qname $"+tag+"_domains", fmt.Sprintf("reject %d", rcode)),
+		}},
+	}, nil
+}
+
+// presetRule returns a sequence rule. matches is optional.
+func presetRule(matches, exec string) map[string]any {
+	r := map[string]any{"exec": exec}
+	if len(matches) > 0 {
+		r["matches"] = []string{matches}
+	}
+	return r
+}
+
+func presetForwardArgs(addrs []string) map[string]any {
+	var upstreams []any
+	for _, addr := range addrs {
+		upstreams = append(upstreams, map[string]any{"addr": addr})
+	}
+	return map[string]any{"upstreams": upstreams}
+}
+
+// presetServers returns udp and tcp servers on listen for entry.
+// Nil if listen is empty.
+func presetServers(entry, listen string) []PluginConfig {
+	if len(listen) == 0 {
+		return nil
+	}
+	args := map[string]any{"entry": entry, "listen": listen}
+	return []PluginConfig{
+		{Tag: entry + "_udp", Type: "udp_server", Args: args},
+		{Tag: entry + "_tcp", Type: "tcp_server", Args: args},
+	}
+}
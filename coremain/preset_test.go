@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_expandPresets(t *testing.T) {
+	tags := func(ps []PluginConfig) []string {
+		var s []string
+		for _, pc := range ps {
+			s = append(s, pc.Tag)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		plugins  []PluginConfig
+		wantTags []string
+		wantErr  string
+	}{
+		{
+			name:     "default tag",
+			plugins:  []PluginConfig{{Preset: "adblock_basic"}},
+			wantTags: []string{"adblock_basic_domains", "adblock_basic"},
+		},
+		{
+			name: "override after",
+			plugins: []PluginConfig{
+				{Tag: "ad", Preset: "adblock_basic"},
+				{Tag: "x", Type: "t"},
+				{Tag: "ad_domains", Type: "my_domains"},
+			},
+			wantTags: []string{"ad_domains", "ad", "x"},
+		},
+		{
+			name: "override before",
+			plugins: []PluginConfig{
+				{Tag: "ad", Type: "my_sequence"},
+				{Tag: "x", Type: "t"},
+				{Tag: "ad", Preset: "adblock_basic"},
+			},
+			wantTags: []string{"x", "ad_domains", "ad"},
+		},
+		{
+			name:     "servers",
+			plugins:  []PluginConfig{{Tag: "cs", Preset: "china_split", Args: map[string]any{"listen": ":53"}}},
+			wantTags: []string{"cs_direct_domains", "cs_direct_ips", "cs_local", "cs_remote", "cs_cache", "cs", "cs_udp", "cs_tcp"},
+		},
+		{
+			name:    "undefined",
+			plugins: []PluginConfig{{Preset: "nope"}},
+			wantErr: "preset nope not defined",
+		},
+		{
+			name:    "with type",
+			plugins: []PluginConfig{{Preset: "adblock_basic", Type: "sequence"}},
+			wantErr: "cannot have a type",
+		},
+		{
+			name:    "invalid args",
+			plugins: []PluginConfig{{Preset: "china_split", Args: map[string]any{"unknown": 1}}},
+			wantErr: "unable to decode preset args",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := expandPresets(tt.plugins)
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("want err contains %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tags(ps); !reflect.DeepEqual(got, tt.wantTags) {
+				t.Fatalf("want tags %v, got %v", tt.wantTags, got)
+			}
+			for _, pc := range ps {
+				overridden := slices.ContainsFunc(tt.plugins, func(e PluginConfig) bool {
+					return e.Tag == pc.Tag && strings.HasPrefix(e.Type, "my_")
+				})
+				if overridden && !strings.HasPrefix(pc.Type, "my_") {
+					t.Fatalf("plugin %s is not overridden", pc.Tag)
+				}
+			}
+		})
+	}
+}
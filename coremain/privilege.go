@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// capabilities that can be retained, see capabilities(7).
+var capabilities = map[string]int{
+	"net_bind_service": 10,
+	"net_admin":        12,
+	"net_raw":          13,
+}
+
+// netAdminPluginTypes are plugin types that need CAP_NET_ADMIN.
+var netAdminPluginTypes = []string{"ipset", "nftset", "route"}
+
+var privilege struct {
+	sync.Mutex
+	dropped *PrivilegeConfig
+}
+
+// dropPrivilege drops the privilege of the process as c configures, once.
+// m is the loaded mosdns. Privilege can not be regained, so later calls,
+// e.g. from reloads, only warn if c was changed.
+func dropPrivilege(c PrivilegeConfig, m *Mosdns) error {
+	if len(c.User) == 0 && len(c.Capabilities) == 0 {
+		return nil
+	}
+	privilege.Lock()
+	defer privilege.Unlock()
+	if d := privilege.dropped; d != nil {
+		if d.User != c.User || d.Group != c.Group || !slices.Equal(d.Capabilities, c.Capabilities) {
+			m.logger.Warn("privilege config was changed, restart mosdns to apply it")
+		}
+		return nil
+	}
+
+	caps, err := retainedCaps(c, m)
+	if err != nil {
+		return err
+	}
+	if err := setPrivilege(c.User, c.Group, caps); err != nil {
+		return fmt.Errorf("failed to drop privilege, %w", err)
+	}
+	privilege.dropped = &c
+	m.logger.Info("privilege dropped", zap.String("user", c.User), zap.String("group", c.Group), zap.Strings("capabilities", caps))
+	return nil
+}
+
+// retainedCaps returns the names of capabilities that should be retained.
+func retainedCaps(c PrivilegeConfig, m *Mosdns) ([]string, error) {
+	if len(c.Capabilities) > 0 {
+		var caps []string
+		for _, s := range c.Capabilities {
+			s = strings.TrimPrefix(strings.ToLower(s), "cap_")
+			if _, ok := capabilities[s]; !ok {
+				return nil, fmt.Errorf("unsupported capability %s", s)
+			}
+			caps = append(caps, s)
+		}
+		return caps, nil
+	}
+
+	caps := []string{"net_bind_service"}
+	for _, tag := range m.order {
+		if slices.Contains(netAdminPluginTypes, m.types[tag]) {
+			caps = append(caps, "net_admin")
+			break
+		}
+	}
+	return caps, nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setPrivilege switches to user and group and retains only caps.
+// Empty user keeps the current user.
+func setPrivilege(username, group string, caps []string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("mosdns is not running as root")
+	}
+
+	uid, gid := -1, -1
+	if len(username) > 0 {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("invalid uid %s", u.Uid)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("invalid gid %s", u.Gid)
+		}
+	}
+	if len(group) > 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("invalid gid %s", g.Gid)
+		}
+	}
+
+	var mask uint32
+	for _, c := range caps {
+		mask |= 1 << capabilities[c]
+	}
+
+	// Capabilities are per-thread attributes. They must be set on all
+	// threads of the process.
+	if uid >= 0 && mask != 0 {
+		if err := allThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); err != nil {
+			return fmt.Errorf("failed to keep capabilities, %w", err)
+		}
+	}
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("failed to set groups, %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("failed to set gid, %w", err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("failed to set uid, %w", err)
+		}
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: mask, Permitted: mask}}
+	if err := allThreadsSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); err != nil {
+		return fmt.Errorf("failed to set capabilities, %w", err)
+	}
+	return nil
+}
+
+func allThreadsSyscall(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOTSUP:
+		return errors.New("not supported by binaries that were built with cgo")
+	default:
+		return errno
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_retainedCaps(t *testing.T) {
+	m := NewTestMosdnsWithPlugins(map[string]any{})
+	m.order = []string{"set", "fwd"}
+	m.types = map[string]string{"set": "nftset", "fwd": "forward"}
+
+	tests := []struct {
+		name    string
+		caps    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "auto", want: []string{"net_bind_service", "net_admin"}},
+		{name: "explicit", caps: []string{"CAP_NET_RAW"}, want: []string{"net_raw"}},
+		{name: "unsupported", caps: []string{"sys_admin"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := retainedCaps(PrivilegeConfig{User: "nobody", Capabilities: tt.caps}, m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retainedCaps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	m.types["set"] = "domain_set"
+	if got, _ := retainedCaps(PrivilegeConfig{User: "nobody"}, m); !slices.Equal(got, []string{"net_bind_service"}) {
+		t.Fatalf("net_admin should not be retained, got %v", got)
+	}
+
+	// Disabled.
+	if err := dropPrivilege(PrivilegeConfig{}, m); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -20,6 +20,7 @@
 package coremain
 
 import (
+	"errors"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/kardianos/service"
@@ -29,7 +30,9 @@ import (
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 )
 
@@ -55,22 +58,33 @@ func init() {
 				if err != nil {
 					return fmt.Errorf("failed to init service, %w", err)
 				}
+				if err := teeServiceLog(svc); err != nil {
+					mlog.L().Warn("failed to write logs into the system log", zap.Error(err))
+				}
 				return svc.Run()
 			}
 
-			m, err := NewServer(sf)
-			if err != nil {
+			r := newRunner(sf)
+			if err := r.start(); err != nil {
 				return err
 			}
 
 			go func() {
 				c := make(chan os.Signal, 1)
-				signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-				sig := <-c
-				m.logger.Warn("signal received", zap.Stringer("signal", sig))
-				m.sc.SendCloseSignal(nil)
+				signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+				for sig := range c {
+					mlog.L().Warn("signal received", zap.Stringer("signal", sig))
+					if sig == syscall.SIGHUP {
+						if err := r.reload(); err != nil {
+							mlog.L().Error("failed to reload", zap.Error(err))
+						}
+						continue
+					}
+					r.stop()
+					return
+				}
 			}()
-			return m.GetSafeClose().WaitClosed()
+			return r.wait()
 		},
 		DisableFlagsInUseLine: true,
 		SilenceUsage:          true,
@@ -86,6 +100,10 @@ func init() {
 	serviceCmd := &cobra.Command{
 		Use:   "service",
 		Short: "Manage mosdns as a system service.",
+		Long: `Manage mosdns as a system service, e.g. a systemd unit or a Windows service.
+
+On Windows, the service is restarted if mosdns exits with an error, and
+logs are written into the event log (source "mosdns") as well.`,
 	}
 	serviceCmd.PersistentPreRunE = initService
 	serviceCmd.AddCommand(
@@ -108,6 +126,10 @@ func Run() error {
 }
 
 func NewServer(sf *serverFlags) (*Mosdns, error) {
+	return newServer(sf, nil)
+}
+
+func newServer(sf *serverFlags, reloader func() error) (*Mosdns, error) {
 	if sf.cpu > 0 {
 		runtime.GOMAXPROCS(sf.cpu)
 	}
@@ -120,31 +142,103 @@ func NewServer(sf *serverFlags) (*Mosdns, error) {
 		mlog.L().Info("working directory changed", zap.String("path", sf.dir))
 	}
 
-	cfg, fileUsed, err := loadConfig(sf.c)
+	return loadMosdns(sf.c, reloader)
+}
+
+// loadMosdns loads the config from file and initializes a mosdns with it.
+// If reloader is not nil, the config file and included files will be
+// watched if the config enables it.
+func loadMosdns(file string, reloader func() error) (*Mosdns, error) {
+	cfg, fileUsed, err := loadConfig(file)
 	if err != nil {
 		return nil, fmt.Errorf("fail to load config, %w", err)
 	}
 	mlog.L().Info("main config loaded", zap.String("file", fileUsed))
+	if cfg, err = resolveIncludes(cfg); err != nil {
+		return nil, err
+	}
+
+	m, err := newMosdns(cfg, reloader, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := dropPrivilege(cfg.Privilege, m); err != nil {
+		m.CloseWithErr(err)
+		_ = m.GetSafeClose().WaitClosed()
+		return nil, err
+	}
+	if reloader != nil && cfg.Reload.Watch {
+		if err := m.watchConfig(append([]string{fileUsed}, cfg.files...)); err != nil {
+			m.logger.Warn("failed to watch config file", zap.Error(err))
+		}
+	}
+	return m, nil
+}
+
+// configExts are the supported config file extensions and their formats.
+// The format of a config file is selected by its extension.
+var configExts = []struct{ ext, format string }{
+	{".yaml", "yaml"},
+	{".yml", "yaml"},
+	{".json", "json"},
+	{".toml", "toml"},
+}
 
-	return NewMosdns(cfg)
+func configFormat(filePath string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	for _, e := range configExts {
+		if e.ext == ext {
+			return e.format, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported config file extension %q, supported extensions are .yaml, .yml, .json and .toml", ext)
+}
+
+// findConfig returns the first existing config file named "config" with a
+// supported extension in the current working directory.
+func findConfig() (string, error) {
+	for _, e := range configExts {
+		f := "config" + e.ext
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", errors.New("no config file is specified and no config.{yaml,yml,json,toml} in the working directory")
 }
 
 // loadConfig load a config from a file. If filePath is empty, it will
-// automatically search and load a file which name start with "config".
+// automatically search and load a file which name is "config" with a
+// supported extension.
 func loadConfig(filePath string) (*Config, string, error) {
-	v := viper.New()
-
-	if len(filePath) > 0 {
-		v.SetConfigFile(filePath)
-	} else {
-		v.SetConfigName("config")
-		v.AddConfigPath(".")
+	if len(filePath) == 0 {
+		f, err := findConfig()
+		if err != nil {
+			return nil, "", err
+		}
+		filePath = f
+	}
+	format, err := configFormat(filePath)
+	if err != nil {
+		return nil, "", err
 	}
 
+	v := viper.New()
+	v.SetConfigFile(filePath)
+	v.SetConfigType(format)
 	if err := v.ReadInConfig(); err != nil {
 		return nil, "", fmt.Errorf("failed to read config: %w", err)
 	}
 
+	// Expand variables before the config is decoded. So they also work
+	// in plugin args.
+	settings, err := expandVars(v.AllSettings())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to expand variables in config: %w", err)
+	}
+	if err := v.MergeConfigMap(settings.(map[string]any)); err != nil {
+		return nil, "", fmt.Errorf("failed to expand variables in config: %w", err)
+	}
+
 	decoderOpt := func(cfg *mapstructure.DecoderConfig) {
 		cfg.ErrorUnused = true
 		cfg.TagName = "yaml"
@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// errReload is the close error of a Mosdns that was replaced by a
+// reloaded one. See runner.reload.
+var errReload = errors.New("reload requested")
+
+// runner runs a Mosdns and replaces it when a reload is requested.
+type runner struct {
+	sf *serverFlags
+
+	reloadM sync.Mutex // serializes reloads.
+
+	m       sync.Mutex
+	current *Mosdns
+	stopped bool
+	err     error         // set if a Mosdns could not be restarted.
+	done    chan struct{} // closed when wait returns.
+}
+
+func newRunner(sf *serverFlags) *runner {
+	return &runner{sf: sf, done: make(chan struct{})}
+}
+
+// start starts the first Mosdns.
+func (r *runner) start() error {
+	m, err := newServer(r.sf, r.reload)
+	if err != nil {
+		return err
+	}
+	r.m.Lock()
+	r.current = m
+	r.m.Unlock()
+	return nil
+}
+
+// wait waits until the running Mosdns is closed. If it was replaced by
+// a reload, wait continues with the new one.
+// It must be called after a successful start.
+func (r *runner) wait() error {
+	defer close(r.done)
+	for {
+		r.m.Lock()
+		m := r.current
+		r.m.Unlock()
+
+		err := m.GetSafeClose().WaitClosed()
+
+		r.m.Lock()
+		replaced := r.current != m
+		restartErr := r.err
+		r.m.Unlock()
+		if restartErr != nil {
+			return restartErr
+		}
+		if !replaced {
+			return err
+		}
+	}
+}
+
+// stop closes the running Mosdns. It does not wait.
+func (r *runner) stop() {
+	r.m.Lock()
+	r.stopped = true
+	m := r.current
+	r.m.Unlock()
+	if m != nil {
+		m.sc.SendCloseSignal(nil)
+	}
+}
+
+// reload loads the config and starts a new Mosdns while the running one
+// keeps serving. The new one shares the sockets of the old one. Then the
+// old one is closed after its queries in flight are done.
+// If the new Mosdns cannot be started because its addresses are in use
+// (e.g. sockets cannot be shared on this platform), reload closes the
+// running one first and starts the new one, like a restart.
+// If the config is invalid, the running Mosdns is not affected.
+func (r *runner) reload() error {
+	r.reloadM.Lock()
+	defer r.reloadM.Unlock()
+
+	r.m.Lock()
+	old := r.current
+	r.m.Unlock()
+	if old == nil {
+		return errors.New("mosdns is not running")
+	}
+
+	nm, err := loadMosdns(r.sf.c, r.reload)
+	if err != nil {
+		if !isAddrInUse(err) {
+			return err
+		}
+		old.logger.Warn("failed to reload without restarting, restarting", zap.Error(err))
+		return r.restart(old)
+	}
+
+	r.m.Lock()
+	if r.stopped {
+		r.m.Unlock()
+		nm.sc.SendCloseSignal(nil)
+		return errors.New("mosdns is stopped")
+	}
+	r.current = nm
+	r.m.Unlock()
+
+	nm.logger.Info("config reloaded")
+	old.sc.SendCloseSignal(errReload)
+	return nil
+}
+
+// restart closes old and starts a new Mosdns.
+func (r *runner) restart(old *Mosdns) error {
+	// Hold the lock, so wait will not return when old is closed.
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.stopped {
+		return errors.New("mosdns is stopped")
+	}
+
+	old.sc.SendCloseSignal(errReload)
+	_ = old.sc.WaitClosed()
+	nm, err := loadMosdns(r.sf.c, r.reload)
+	if err != nil {
+		r.err = fmt.Errorf("failed to restart, %w", err)
+		return r.err
+	}
+	r.current = nm
+	return nil
+}
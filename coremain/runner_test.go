@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_runner_reload(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfgFile := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := fmt.Sprintf("log:\n  level: error\napi:\n  http: %s\n", addr)
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRunner(&serverFlags{c: cfgFile})
+	if err := r.start(); err != nil {
+		t.Fatal(err)
+	}
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- r.wait() }()
+
+	healthy := func() {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("want 200, got %d", resp.StatusCode)
+		}
+	}
+	healthy()
+
+	old := r.current
+	if err := old.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.current == old {
+		t.Fatal("mosdns was not replaced")
+	}
+	if err := old.GetSafeClose().WaitClosed(); !errors.Is(err, errReload) {
+		t.Fatalf("want old mosdns closed by reload, got %v", err)
+	}
+	healthy() // The api server of the new mosdns took over the socket.
+
+	// An invalid config does not affect the running mosdns.
+	if err := os.WriteFile(cfgFile, []byte("invalid: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cur := r.current
+	if err := r.reload(); err == nil {
+		t.Fatal("reload with invalid config should fail")
+	}
+	if r.current != cur {
+		t.Fatal("mosdns should not be replaced")
+	}
+	healthy()
+
+	r.stop()
+	select {
+	case err := <-waitErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait timeout")
+	}
+}
@@ -42,31 +42,32 @@ var (
 
 type serverService struct {
 	f *serverFlags
-	m *Mosdns
+	r *runner
 }
 
 func (ss *serverService) Start(s service.Service) error {
 	mlog.L().Info("starting service", zap.String("platform", s.Platform()))
-	m, err := NewServer(ss.f)
-	if err != nil {
+	r := newRunner(ss.f)
+	if err := r.start(); err != nil {
 		return err
 	}
-	ss.m = m
+	ss.r = r
 	go func() {
-		err := m.GetSafeClose().WaitClosed()
+		err := r.wait()
 		if err != nil {
-			m.Logger().Fatal("server exited", zap.Error(err))
+			mlog.L().Fatal("server exited", zap.Error(err))
 		} else {
-			m.Logger().Info("server exited")
+			mlog.L().Info("server exited")
 		}
 	}()
 	return nil
 }
 
 func (ss *serverService) Stop(_ service.Service) error {
-	ss.m.Logger().Info("service is shutting down")
-	ss.m.GetSafeClose().SendCloseSignal(nil)
-	return ss.m.GetSafeClose().WaitClosed()
+	mlog.L().Info("service is shutting down")
+	ss.r.stop()
+	<-ss.r.done
+	return nil
 }
 
 // initService will init svc for sub command "service"
@@ -81,8 +82,9 @@ func initService(_ *cobra.Command, _ []string) error {
 
 func newSvcInstallCmd() *cobra.Command {
 	sf := new(serverFlags)
+	var api string
 	c := &cobra.Command{
-		Use:   "install [-d working_dir] [-c config_file]",
+		Use:   "install [-d working_dir] [-c config_file] [--api addr]",
 		Short: "Install mosdns as a system service.",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -105,13 +107,22 @@ func newSvcInstallCmd() *cobra.Command {
 			if len(sf.c) > 0 {
 				svcCfg.Arguments = append(svcCfg.Arguments, "-c", sf.c)
 			}
-			return svc.Install()
+			if !isProcd() {
+				return svc.Install()
+			}
+			setProcdOptions()
+			if err := svc.Install(); err != nil {
+				return err
+			}
+			mlog.S().Infof("install rpcd plugin %s", rpcdPlugin)
+			return installRpcdPlugin(api)
 		},
 		DisableFlagsInUseLine: true,
 		SilenceUsage:          true,
 	}
 	c.Flags().StringVarP(&sf.dir, "dir", "d", "", "working dir")
 	c.Flags().StringVarP(&sf.c, "config", "c", "", "config path")
+	c.Flags().StringVar(&api, "api", "", "address of the http api, used by the ubus object on OpenWrt")
 	return c
 }
 
@@ -121,7 +132,13 @@ func newSvcUninstallCmd() *cobra.Command {
 		Short: "Uninstall mosdns from system service.",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return svc.Uninstall()
+			if err := svc.Uninstall(); err != nil {
+				return err
+			}
+			if isProcd() {
+				return uninstallRpcdPlugin()
+			}
+			return nil
 		},
 		DisableFlagsInUseLine: true,
 		SilenceUsage:          true,
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"strings"
+
+	"github.com/kardianos/service"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// serviceLogCore writes log entries into the system log of a service,
+// e.g. the event log on Windows.
+type serviceLogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	sl  service.Logger
+}
+
+func newServiceLogCore(sl service.Logger) *serviceLogCore {
+	ec := zap.NewDevelopmentEncoderConfig()
+	ec.TimeKey = "" // The system log has its own timestamps.
+	ec.LevelKey = ""
+	return &serviceLogCore{
+		LevelEnabler: zapcore.InfoLevel,
+		enc:          zapcore.NewConsoleEncoder(ec),
+		sl:           sl,
+	}
+}
+
+func (c *serviceLogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &serviceLogCore{LevelEnabler: c.LevelEnabler, enc: enc, sl: c.sl}
+}
+
+func (c *serviceLogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *serviceLogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	b, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(b.String(), "\n")
+	b.Free()
+	switch {
+	case e.Level >= zapcore.ErrorLevel:
+		return c.sl.Error(msg)
+	case e.Level == zapcore.WarnLevel:
+		return c.sl.Warning(msg)
+	default:
+		return c.sl.Info(msg)
+	}
+}
+
+func (c *serviceLogCore) Sync() error {
+	return nil
+}
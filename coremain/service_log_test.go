@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type testServiceLogger struct {
+	logs []string
+}
+
+func (l *testServiceLogger) Error(v ...any) error {
+	l.logs = append(l.logs, "E "+v[0].(string))
+	return nil
+}
+
+func (l *testServiceLogger) Warning(v ...any) error {
+	l.logs = append(l.logs, "W "+v[0].(string))
+	return nil
+}
+
+func (l *testServiceLogger) Info(v ...any) error {
+	l.logs = append(l.logs, "I "+v[0].(string))
+	return nil
+}
+
+func (l *testServiceLogger) Errorf(format string, a ...any) error   { return nil }
+func (l *testServiceLogger) Warningf(format string, a ...any) error { return nil }
+func (l *testServiceLogger) Infof(format string, a ...any) error    { return nil }
+
+func Test_serviceLogCore(t *testing.T) {
+	sl := new(testServiceLogger)
+	lg := zap.New(newServiceLogCore(sl)).Named("p").With(zap.String("k", "v"))
+	lg.Debug("debug")
+	lg.Info("info")
+	lg.Warn("warn")
+	lg.Error("error", zap.Int("n", 1))
+
+	want := []string{
+		"I p\tinfo\t{\"k\": \"v\"}",
+		"W p\twarn\t{\"k\": \"v\"}",
+		"E p\terror\t{\"k\": \"v\", \"n\": 1}",
+	}
+	if !slices.Equal(sl.logs, want) {
+		t.Fatalf("want %q, got %q", want, sl.logs)
+	}
+}
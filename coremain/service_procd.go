@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kardianos/service"
+)
+
+const (
+	procdPlatform = "linux-procd"
+	rpcdPlugin    = "/usr/libexec/rpcd/mosdns"
+)
+
+// procdScript is the init script for OpenWrt's procd. Besides respawning
+// mosdns, it reloads mosdns by SIGHUP instead of restarting it, and
+// once an interface of netifd is up, so upstreams from dhcp (e.g.
+// "system" upstreams) are resolved again.
+const procdScript = `#!/bin/sh /etc/rc.common
+USE_PROCD=1
+# After network starts
+START=21
+# Before network stops
+STOP=89
+cmd="{{.Path}}{{range .Arguments}} {{.|cmd}}{{end}}"
+name="{{.Name}}"
+pid_file="/var/run/${name}.pid"
+
+start_service() {
+    procd_open_instance
+    procd_set_param command ${cmd}
+    procd_set_param respawn ${respawn_threshold:-3600} ${respawn_timeout:-5} ${respawn_retry:-5}
+    procd_set_param reload_signal HUP
+    procd_set_param stdout 1
+    procd_set_param stderr 1
+    procd_set_param pidfile ${pid_file}
+    procd_close_instance
+}
+
+service_triggers() {
+    procd_add_raw_trigger "interface.*.up" 2000 /etc/init.d/${name} reload
+}
+`
+
+// rpcdScript returns the rpcd plugin that exposes the "mosdns" ubus
+// object. See the "ubus" sub command.
+func rpcdScript(exe, api string) string {
+	s := "#!/bin/sh\nexec " + strconv.Quote(exe) + " ubus --pid-file /var/run/mosdns.pid"
+	if len(api) > 0 {
+		s += " --api " + strconv.Quote(api)
+	}
+	return s + ` "$@"` + "\n"
+}
+
+// isProcd reports whether the system service is managed by procd.
+func isProcd() bool {
+	return svc != nil && svc.Platform() == procdPlatform
+}
+
+// setProcdOptions makes svcCfg use procdScript.
+func setProcdOptions() {
+	if svcCfg.Option == nil {
+		svcCfg.Option = make(service.KeyValue)
+	}
+	svcCfg.Option["SysvScript"] = procdScript
+}
+
+// installRpcdPlugin installs the rpcd plugin. api is the address of the
+// http api of mosdns, optional.
+func installRpcdPlugin(api string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot solve current executable path, %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(rpcdPlugin), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(rpcdPlugin, []byte(rpcdScript(exe, api)), 0755)
+}
+
+func uninstallRpcdPlugin() error {
+	if err := os.Remove(rpcdPlugin); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
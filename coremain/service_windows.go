@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/kardianos/service"
+)
+
+func init() {
+	// Restart the service if mosdns exited with an error.
+	svcCfg.Option = service.KeyValue{
+		service.OnFailure:              service.OnFailureRestart,
+		service.OnFailureDelayDuration: "5s",
+		service.OnFailureResetPeriod:   60,
+	}
+}
+
+// teeServiceLog writes logs into the event log as well, which is
+// installed as a log source by "service install".
+func teeServiceLog(s service.Service) error {
+	sl, err := s.Logger(nil)
+	if err != nil {
+		return fmt.Errorf("failed to open event log, %w", err)
+	}
+	mlog.Tee(newServiceLogCore(sl))
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.uber.org/zap"
+)
+
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP endpoint, e.g. "localhost:4318" or
+	// "https://collector:4318/v1/traces". Tracing is disabled if empty.
+	Endpoint string            `yaml:"endpoint"`
+	Insecure bool              `yaml:"insecure"` // Use http instead of https.
+	Headers  map[string]string `yaml:"headers"`
+
+	// ServiceName, default is "mosdns".
+	ServiceName string `yaml:"service_name"`
+	// SampleRate is the ratio of queries to be traced, in (0, 1].
+	// Default is 1.
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+const tracingShutdownTimeout = time.Second * 5
+
+// initTracing sets the global tracer provider that exports spans
+// via OTLP/HTTP. The provider will be shut down when m is closed.
+func (m *Mosdns) initTracing(cfg TracingConfig) error {
+	if len(cfg.Endpoint) == 0 {
+		return nil
+	}
+	utils.SetDefaultString(&cfg.ServiceName, "mosdns")
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(cfg.Headers)}
+	if strings.HasPrefix(cfg.Endpoint, "http://") || strings.HasPrefix(cfg.Endpoint, "https://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("failed to init otlp exporter, %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+	m.logger.Info("tracing enabled", zap.String("endpoint", cfg.Endpoint), zap.Float64("sample_rate", cfg.SampleRate))
+
+	m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+		go func() {
+			defer done()
+			<-closeSignal
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				m.logger.Warn("failed to shutdown tracer provider", zap.Error(err))
+			}
+		}()
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// varRe matches variable references in config values:
+//   - "${NAME}" is the value of environment variable NAME. It is an error
+//     if NAME is not set.
+//   - "${NAME:-default}" is the value of NAME, or default if NAME is
+//     not set or empty.
+//   - "${file:/path/to/secret}" is the content of the file, without
+//     trailing newlines. E.g. docker and kubernetes secrets.
+//   - "$${" is a literal "${".
+//
+// "$" that is not followed by "{", e.g. "$tag" in sequences, is kept.
+var varRe = regexp.MustCompile(`\$\$\{|\$\{([^{}]*)\}`)
+
+// expandVars returns a copy of v that has variables in all string
+// values expanded. v is a value decoded from a config file.
+func expandVars(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return expandString(v)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			ev, err := expandVars(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = ev
+		}
+		return m, nil
+	case []any:
+		s := make([]any, len(v))
+		for i, e := range v {
+			ev, err := expandVars(e)
+			if err != nil {
+				return nil, fmt.Errorf("#%d: %w", i, err)
+			}
+			s[i] = ev
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+func expandString(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var errs []error
+	r := varRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		val, err := lookupVar(ref[2 : len(ref)-1])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return val
+	})
+	return r, errors.Join(errs...)
+}
+
+func lookupVar(name string) (string, error) {
+	if path, ok := strings.CutPrefix(name, "file:"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file, %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	name, def, hasDef := strings.Cut(name, ":-")
+	if len(name) == 0 {
+		return "", errors.New("empty variable name")
+	}
+	val, ok := os.LookupEnv(name)
+	if hasDef && len(val) == 0 {
+		return def, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return val, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadConfig_vars(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MOSDNS_TEST_UPSTREAM", "https://dns.example/dns-query")
+	t.Setenv("MOSDNS_TEST_EMPTY", "")
+
+	cfgFile := filepath.Join(dir, "config.yaml")
+	cfg := `
+api:
+  admin_token: ${file:` + secret + `}
+plugins:
+  - tag: main
+    type: sequence
+    args:
+      - exec: $forward
+      - exec: "ttl ${MOSDNS_TEST_EMPTY:-300}"
+  - tag: forward
+    type: forward
+    args:
+      upstreams:
+        - addr: ${MOSDNS_TEST_UPSTREAM}
+          name: "$${MOSDNS_TEST_UPSTREAM}"
+`
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := loadConfig(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.API.AdminToken != "s3cret" {
+		t.Fatalf("want token from secret file, got %q", c.API.AdminToken)
+	}
+	wantSeq := []any{
+		map[string]any{"exec": "$forward"},
+		map[string]any{"exec": "ttl 300"},
+	}
+	if !reflect.DeepEqual(c.Plugins[0].Args, wantSeq) {
+		t.Fatalf("want sequence args %v, got %v", wantSeq, c.Plugins[0].Args)
+	}
+	wantUpstreams := []any{map[string]any{
+		"addr": "https://dns.example/dns-query",
+		"name": "${MOSDNS_TEST_UPSTREAM}",
+	}}
+	if got := c.Plugins[1].Args.(map[string]any)["upstreams"]; !reflect.DeepEqual(got, wantUpstreams) {
+		t.Fatalf("want upstreams %v, got %v", wantUpstreams, got)
+	}
+
+	if err := os.WriteFile(cfgFile, []byte("api:\n  http: ${MOSDNS_TEST_UNSET}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig(cfgFile); err == nil {
+		t.Fatal("unset variable should be an error")
+	}
+}
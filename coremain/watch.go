@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// watchDelay merges the events of one config change, e.g. editors
+// that truncate and write the file in multiple steps.
+const watchDelay = time.Millisecond * 500
+
+// watchConfig reloads m when any of files is changed. The watcher is
+// stopped when m is closed.
+func (m *Mosdns) watchConfig(files []string) error {
+	watched := make(map[string]struct{})
+	for _, f := range files {
+		f, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		watched[f] = struct{}{}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to init watcher, %w", err)
+	}
+	// Watch the dirs instead of the files. Some editors replace the file,
+	// which removes the watch on it.
+	for f := range watched {
+		if err := w.Add(filepath.Dir(f)); err != nil {
+			w.Close()
+			return fmt.Errorf("failed to watch %s, %w", f, err)
+		}
+		m.logger.Info("watching config file", zap.String("file", f))
+	}
+
+	m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
+		go func() {
+			defer done()
+			defer w.Close()
+
+			timer := time.NewTimer(watchDelay)
+			timer.Stop()
+			for {
+				select {
+				case e := <-w.Events:
+					if _, ok := watched[filepath.Clean(e.Name)]; !ok || !e.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+						continue
+					}
+					timer.Reset(watchDelay)
+				case err := <-w.Errors:
+					m.logger.Warn("config watcher error", zap.Error(err))
+				case <-timer.C:
+					m.logger.Info("config file changed")
+					// Reload in another goroutine. It may wait for m to be
+					// closed, which waits for this goroutine.
+					go func() {
+						if err := m.Reload(); err != nil {
+							m.logger.Error("failed to reload", zap.Error(err))
+						}
+					}()
+				case <-closeSignal:
+					return
+				}
+			}
+		}()
+	})
+	return nil
+}
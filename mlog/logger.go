@@ -34,8 +34,17 @@ type LogConfig struct {
 	// Default is stderr.
 	File string `yaml:"file"`
 
+	// Format of log entries, can be "console" or "json".
+	// Default is "console".
+	Format string `yaml:"format"`
+
 	// Production enables json output.
+	// Deprecated: Use Format "json" instead.
 	Production bool `yaml:"production"`
+
+	// PluginLevels overwrites the log level of plugins, by plugin tag.
+	// e.g. {"forward_google": "debug"}.
+	PluginLevels map[string]string `yaml:"plugin_levels"`
 }
 
 var (
@@ -45,13 +54,46 @@ var (
 	s      = l.Sugar()
 
 	nop = zap.NewNop()
+
+	// tee is an additional core of all loggers, see Tee.
+	tee zapcore.Core
 )
 
-func NewLogger(lc LogConfig) (*zap.Logger, error) {
+// Logger is the root logger that can create loggers for plugins
+// with their own levels.
+type Logger struct {
+	*zap.Logger
+
+	base   *zap.Logger // with the lowest level of all levels.
+	levels map[string]zapcore.Level
+}
+
+// PluginLogger returns a logger named tag. If tag has its own level in
+// LogConfig.PluginLevels, the logger uses it instead of the root level.
+func (l *Logger) PluginLogger(tag string) *zap.Logger {
+	if pl, ok := l.levels[tag]; ok {
+		return l.base.Named(tag).WithOptions(zap.IncreaseLevel(pl))
+	}
+	return l.Logger.Named(tag)
+}
+
+func NewLogger(lc LogConfig) (*Logger, error) {
 	lvl, err := zapcore.ParseLevel(lc.Level)
 	if err != nil {
 		return nil, fmt.Errorf("invalid log level: %w", err)
 	}
+	minLvl := lvl
+	levels := make(map[string]zapcore.Level, len(lc.PluginLevels))
+	for tag, s := range lc.PluginLevels {
+		pl, err := zapcore.ParseLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level of plugin %s: %w", tag, err)
+		}
+		levels[tag] = pl
+		if pl < minLvl {
+			minLvl = pl
+		}
+	}
 
 	var out zapcore.WriteSyncer
 	if lf := lc.File; len(lf) > 0 {
@@ -64,10 +106,41 @@ func NewLogger(lc LogConfig) (*zap.Logger, error) {
 		out = stderr
 	}
 
-	if lc.Production {
-		return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, lvl)), nil
+	format := lc.Format
+	if len(format) == 0 && lc.Production {
+		format = "json"
 	}
-	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), out, lvl)), nil
+	var enc zapcore.Encoder
+	switch format {
+	case "", "console":
+		enc = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case "json":
+		ec := zap.NewProductionEncoderConfig()
+		ec.EncodeTime = zapcore.ISO8601TimeEncoder
+		enc = zapcore.NewJSONEncoder(ec)
+	default:
+		return nil, fmt.Errorf("invalid log format %s", format)
+	}
+
+	core := zapcore.NewCore(enc, out, minLvl)
+	if tee != nil {
+		core = zapcore.NewTee(core, tee)
+	}
+	base := zap.New(core)
+	root := base
+	if lvl != minLvl {
+		root = base.WithOptions(zap.IncreaseLevel(lvl))
+	}
+	return &Logger{Logger: root, base: base, levels: levels}, nil
+}
+
+// Tee writes entries of the global logger and loggers created by NewLogger
+// afterward into c as well, e.g. the system log. It should be called at
+// startup, before loggers are used.
+func Tee(c zapcore.Core) {
+	tee = c
+	l = zap.New(zapcore.NewTee(l.Core(), c))
+	s = l.Sugar()
 }
 
 // L is a global logger.
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger_PluginLogger(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "log")
+	l, err := NewLogger(LogConfig{
+		Level:        "warn",
+		File:         f,
+		Format:       "json",
+		PluginLevels: map[string]string{"noisy": "debug"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Info("root info")
+	l.Warn("root warn")
+	l.PluginLogger("quiet").Info("quiet info")
+	l.PluginLogger("noisy").Debug("noisy debug")
+	l.PluginLogger("noisy").Named("r0").Debug("noisy sub debug")
+	_ = l.Sync()
+
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		e := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid json line %q: %v", line, err)
+		}
+		got = append(got, e["msg"].(string))
+	}
+	want := []string{"root warn", "noisy debug", "noisy sub debug"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	if _, err := NewLogger(LogConfig{PluginLevels: map[string]string{"p": "bad"}}); err == nil {
+		t.Fatal("invalid plugin level should fail")
+	}
+}
@@ -151,6 +151,12 @@ func (c *Cache[K, V]) Len() int {
 	return c.m.Len()
 }
 
+// SetSize changes the maximum size of this cache. Entries exceeding
+// the new size are removed.
+func (c *Cache[K, V]) SetSize(size int) {
+	c.m.SetMaxSize(size)
+}
+
 // Flush removes all stored entries from this cache.
 func (c *Cache[K, V]) Flush() {
 	c.m.Flush()
@@ -59,6 +59,20 @@ func NewMapCache[K Hashable, V any](size int) *Map[K, V] {
 	return m
 }
 
+// SetMaxSize changes the maximum size of the map. Entries exceeding the
+// new size are evicted. Like NewMapCache, the actual maximum size is
+// MapShardSize*(size / MapShardSize), but at least MapShardSize if
+// size > 0. If size <= 0, the map has no limit.
+func (m *Map[K, V]) SetMaxSize(size int) {
+	sizePreShard := size / MapShardSize
+	if size > 0 && sizePreShard == 0 {
+		sizePreShard = 1
+	}
+	for i := range m.shards {
+		m.shards[i].setMax(sizePreShard)
+	}
+}
+
 func (m *Map[K, V]) getShard(key K) *shard[K, V] {
 	return &m.shards[key.Sum()%MapShardSize]
 }
@@ -136,6 +150,25 @@ func (m *shard[K, V]) set(key K, v V) {
 	m.m[key] = v
 }
 
+func (m *shard[K, V]) setMax(max int) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.max = max
+	if max <= 0 || len(m.m) <= max {
+		return
+	}
+	// Go maps don't release memory after deletions. Copy the remaining
+	// entries into a new map instead.
+	nm := make(map[K]V, max)
+	for k, v := range m.m {
+		if len(nm) >= max {
+			break
+		}
+		nm[k] = v
+	}
+	m.m = nm
+}
+
 func (m *shard[K, V]) del(key K) {
 	m.l.Lock()
 	defer m.l.Unlock()
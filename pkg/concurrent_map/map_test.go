@@ -188,3 +188,38 @@ func Benchmark_RWMutexMap_Get_And_Set(b *testing.B) {
 		}
 	})
 }
+
+func Test_Map_SetMaxSize(t *testing.T) {
+	m := NewMapCache[testMapHashable, int](MapShardSize * 16)
+	for i := 0; i < MapShardSize*16; i++ {
+		m.Set(testMapHashable(i), i)
+	}
+	if l := m.Len(); l != MapShardSize*16 {
+		t.Fatalf("want len %d, got %d", MapShardSize*16, l)
+	}
+
+	m.SetMaxSize(MapShardSize * 4)
+	if l := m.Len(); l != MapShardSize*4 {
+		t.Fatalf("want len %d after shrinking, got %d", MapShardSize*4, l)
+	}
+	for i := 0; i < MapShardSize*16; i++ {
+		m.Set(testMapHashable(i), i)
+	}
+	if l := m.Len(); l > MapShardSize*4 {
+		t.Fatalf("want len <= %d after refilling, got %d", MapShardSize*4, l)
+	}
+
+	// Small sizes still limit the map.
+	m.SetMaxSize(1)
+	if l := m.Len(); l != MapShardSize {
+		t.Fatalf("want len %d, got %d", MapShardSize, l)
+	}
+
+	m.SetMaxSize(0)
+	for i := 0; i < MapShardSize*16; i++ {
+		m.Set(testMapHashable(i), i)
+	}
+	if l := m.Len(); l != MapShardSize*16 {
+		t.Fatalf("want len %d without limit, got %d", MapShardSize*16, l)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_lease
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	dhcpHeaderLen = 236
+	dhcpMagic     = 0x63825363
+
+	optPad          = 0
+	optHostname     = 12
+	optRequestedIP  = 50
+	optLeaseTime    = 51
+	optMsgType      = 53
+	optEnd          = 255
+	msgTypeRequest  = 3
+	msgTypeAck      = 5
+	defaultSnoopTTL = time.Hour
+)
+
+var errNotDHCP = errors.New("not a dhcp packet")
+
+// ParseDHCPPacket learns a lease from a DHCPREQUEST or DHCPACK packet.
+// ok is false if the packet does not contain a hostname binding.
+// If the packet has no lease time option, defaultLeaseTime will be used.
+func ParseDHCPPacket(b []byte, now time.Time, defaultLeaseTime time.Duration) (l Lease, ok bool, err error) {
+	if len(b) < dhcpHeaderLen+4 || binary.BigEndian.Uint32(b[dhcpHeaderLen:]) != dhcpMagic {
+		return Lease{}, false, errNotDHCP
+	}
+	if defaultLeaseTime <= 0 {
+		defaultLeaseTime = defaultSnoopTTL
+	}
+
+	var (
+		msgType     byte
+		hostname    string
+		requestedIP netip.Addr
+		leaseTime   time.Duration
+	)
+	opts := b[dhcpHeaderLen+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if code == optEnd {
+			break
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return Lease{}, false, errors.New("invalid dhcp option")
+		}
+		v := opts[2 : 2+int(opts[1])]
+		opts = opts[2+int(opts[1]):]
+		switch code {
+		case optMsgType:
+			if len(v) == 1 {
+				msgType = v[0]
+			}
+		case optHostname:
+			hostname = string(v)
+		case optRequestedIP:
+			if len(v) == 4 {
+				requestedIP = netip.AddrFrom4([4]byte(v))
+			}
+		case optLeaseTime:
+			if len(v) == 4 {
+				leaseTime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+			}
+		}
+	}
+
+	ciaddr := netip.AddrFrom4([4]byte(b[12:16]))
+	yiaddr := netip.AddrFrom4([4]byte(b[16:20]))
+	var addr netip.Addr
+	switch msgType {
+	case msgTypeRequest:
+		addr = requestedIP
+		if !addr.IsValid() {
+			addr = ciaddr
+		}
+	case msgTypeAck:
+		addr = yiaddr
+	default:
+		return Lease{}, false, nil
+	}
+	if len(hostname) == 0 || !addr.IsValid() || addr.IsUnspecified() {
+		return Lease{}, false, nil
+	}
+	if leaseTime <= 0 {
+		leaseTime = defaultLeaseTime
+	}
+
+	hlen := int(b[2])
+	if hlen > 16 {
+		hlen = 16
+	}
+	return Lease{
+		Hostname: hostname,
+		Addr:     addr,
+		MAC:      net.HardwareAddr(b[28 : 28+hlen]).String(),
+		Expire:   now.Add(leaseTime),
+	}, true, nil
+}
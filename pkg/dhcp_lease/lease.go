@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_lease
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lease is a hostname to ip binding from a dhcp server.
+type Lease struct {
+	Hostname string
+	Addr     netip.Addr
+	MAC      string    // optional
+	Expire   time.Time // zero means the lease never expires
+}
+
+// ParseFunc parses leases from a lease file.
+type ParseFunc func(r io.Reader) ([]Lease, error)
+
+var parsers = map[string]ParseFunc{
+	"dnsmasq": ParseDnsmasq,
+	"odhcpd":  ParseOdhcpd,
+	"kea":     ParseKea,
+	"isc":     ParseISC,
+}
+
+// GetParser returns the ParseFunc of the lease file format.
+func GetParser(format string) (ParseFunc, bool) {
+	f, ok := parsers[format]
+	return f, ok
+}
+
+// ParseDnsmasq parses the dnsmasq lease file. Each line has format:
+// "<expiry> <mac> <ip> <hostname> <client_id>". The expiry is a unix
+// timestamp, 0 means infinite. Unknown hostname is "*".
+// DHCPv6 leases are listed after a "duid" line and use iaid instead of mac.
+func ParseDnsmasq(r io.Reader) ([]Lease, error) {
+	var ls []Lease
+	s := bufio.NewScanner(r)
+	ln := 0
+	for s.Scan() {
+		ln++
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || f[0] == "duid" {
+			continue
+		}
+		if len(f) < 4 {
+			return nil, fmt.Errorf("line %d: invalid lease, expect at least 4 fields, got %d", ln, len(f))
+		}
+		if f[3] == "*" {
+			continue
+		}
+		expire, err := parseUnixTime(f[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry, %w", ln, err)
+		}
+		addr, err := netip.ParseAddr(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ip, %w", ln, err)
+		}
+		ls = append(ls, Lease{Hostname: f[3], Addr: addr, MAC: f[1], Expire: expire})
+	}
+	return ls, s.Err()
+}
+
+// ParseOdhcpd parses the odhcpd lease file. Each lease line has format:
+// "# <iface> <duid|mac> <iaid|ipv4> <hostname> <valid_until> <id> <length> <ip/prefix>...".
+// Unknown hostname is "-". Negative valid_until means the lease is expired.
+func ParseOdhcpd(r io.Reader) ([]Lease, error) {
+	var ls []Lease
+	s := bufio.NewScanner(r)
+	ln := 0
+	for s.Scan() {
+		ln++
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || f[0] != "#" {
+			continue // not a lease line
+		}
+		if len(f) < 9 {
+			return nil, fmt.Errorf("line %d: invalid lease, expect at least 9 fields, got %d", ln, len(f))
+		}
+		hostname := f[4]
+		if hostname == "-" {
+			continue
+		}
+		validUntil, err := strconv.ParseInt(f[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid valid_until, %w", ln, err)
+		}
+		if validUntil < 0 {
+			continue
+		}
+		var expire time.Time
+		if validUntil > 0 {
+			expire = time.Unix(validUntil, 0)
+		}
+		var mac string
+		if f[3] == "ipv4" {
+			mac = f[2]
+		}
+		for _, s := range f[8:] {
+			addr, _, _ := strings.Cut(s, "/")
+			ip, err := netip.ParseAddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid ip, %w", ln, err)
+			}
+			ls = append(ls, Lease{Hostname: hostname, Addr: ip, MAC: mac, Expire: expire})
+		}
+	}
+	return ls, s.Err()
+}
+
+func parseUnixTime(s string) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(n, 0), nil
+}
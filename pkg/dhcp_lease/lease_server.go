@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_lease
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseKea parses the Kea memfile (csv) lease file, both v4 and v6.
+// The file is append-only, so a later record of the same address
+// replaces the earlier one.
+func ParseKea(r io.Reader) ([]Lease, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		col[h] = i
+	}
+	for _, h := range [...]string{"address", "expire", "hostname"} {
+		if _, ok := col[h]; !ok {
+			return nil, fmt.Errorf("missing column %s", h)
+		}
+	}
+	get := func(rec []string, h string) string {
+		i, ok := col[h]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	var d leaseDeduper
+	for {
+		rec, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		addr, err := netip.ParseAddr(get(rec, "address"))
+		if err != nil {
+			ln, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: invalid ip, %w", ln, err)
+		}
+		// State 0 is the default (assigned) state.
+		if st := get(rec, "state"); len(st) > 0 && st != "0" {
+			d.del(addr)
+			continue
+		}
+		hostname := strings.TrimSuffix(get(rec, "hostname"), ".")
+		if len(hostname) == 0 {
+			d.del(addr)
+			continue
+		}
+		expire, err := parseUnixTime(get(rec, "expire"))
+		if err != nil {
+			ln, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: invalid expire, %w", ln, err)
+		}
+		d.put(Lease{Hostname: hostname, Addr: addr, MAC: get(rec, "hwaddr"), Expire: expire})
+	}
+	return d.leases(), nil
+}
+
+// ParseISC parses the ISC dhcpd.leases file.
+func ParseISC(r io.Reader) ([]Lease, error) {
+	var d leaseDeduper
+	s := bufio.NewScanner(r)
+	ln := 0
+	var cur *Lease
+	active := true
+	for s.Scan() {
+		ln++
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if cur == nil {
+			ip, ok := strings.CutPrefix(line, "lease ")
+			if !ok {
+				continue // other declarations, e.g. "server-duid".
+			}
+			ip, _, _ = strings.Cut(ip, " ")
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid ip, %w", ln, err)
+			}
+			cur = &Lease{Addr: addr}
+			active = true
+			continue
+		}
+		if line == "}" {
+			if active && len(cur.Hostname) > 0 {
+				d.put(*cur)
+			} else {
+				d.del(cur.Addr)
+			}
+			cur = nil
+			continue
+		}
+
+		line = strings.TrimSuffix(line, ";")
+		switch {
+		case strings.HasPrefix(line, "ends "):
+			t, err := parseISCTime(strings.TrimPrefix(line, "ends "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid ends, %w", ln, err)
+			}
+			cur.Expire = t
+		case strings.HasPrefix(line, "binding state "):
+			active = strings.TrimPrefix(line, "binding state ") == "active"
+		case strings.HasPrefix(line, "hardware ethernet "):
+			cur.MAC = strings.TrimPrefix(line, "hardware ethernet ")
+		case strings.HasPrefix(line, "client-hostname "):
+			cur.Hostname = strings.Trim(strings.TrimPrefix(line, "client-hostname "), `"`)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return d.leases(), nil
+}
+
+// parseISCTime parses "never", "epoch <unix>" and "<weekday> yyyy/mm/dd hh:mm:ss" (UTC).
+func parseISCTime(s string) (time.Time, error) {
+	if s == "never" {
+		return time.Time{}, nil
+	}
+	if e, ok := strings.CutPrefix(s, "epoch "); ok {
+		e, _, _ = strings.Cut(e, " ")
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(n, 0), nil
+	}
+	_, dt, ok := strings.Cut(s, " ")
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time %s", s)
+	}
+	return time.Parse("2006/01/02 15:04:05", dt)
+}
+
+// leaseDeduper keeps the last lease of each address in order.
+type leaseDeduper struct {
+	idx map[netip.Addr]int
+	ls  []Lease
+}
+
+func (d *leaseDeduper) put(l Lease) {
+	if d.idx == nil {
+		d.idx = make(map[netip.Addr]int)
+	}
+	if i, ok := d.idx[l.Addr]; ok {
+		d.ls[i] = l
+		return
+	}
+	d.idx[l.Addr] = len(d.ls)
+	d.ls = append(d.ls, l)
+}
+
+func (d *leaseDeduper) del(addr netip.Addr) {
+	if i, ok := d.idx[addr]; ok {
+		d.ls[i].Hostname = ""
+	}
+}
+
+func (d *leaseDeduper) leases() []Lease {
+	ls := d.ls[:0]
+	for _, l := range d.ls {
+		if len(l.Hostname) > 0 {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_lease
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDnsmasq(t *testing.T) {
+	data := `1700000000 aa:bb:cc:dd:ee:ff 192.168.1.10 laptop 01:aa:bb:cc:dd:ee:ff
+0 11:22:33:44:55:66 192.168.1.11 * *
+duid 00:01:00:01:2a:2b:2c:2d:aa:bb:cc:dd:ee:ff
+0 1234 fd00::10 laptop 00:01:00:01
+`
+	ls, err := ParseDnsmasq(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 2 {
+		t.Fatalf("expect 2 leases, got %d", len(ls))
+	}
+	if ls[0].Hostname != "laptop" || ls[0].Addr != netip.MustParseAddr("192.168.1.10") || ls[0].Expire.Unix() != 1700000000 {
+		t.Fatalf("unexpected lease %+v", ls[0])
+	}
+	if ls[1].Addr != netip.MustParseAddr("fd00::10") || !ls[1].Expire.IsZero() {
+		t.Fatalf("unexpected lease %+v", ls[1])
+	}
+}
+
+func TestParseOdhcpd(t *testing.T) {
+	data := `# br-lan 000100012a2b2c2daabbccddeeff 1234abcd phone 1700000000 80 128 fd00::20/128 fd00::21/128
+# br-lan aabbccddeeff ipv4 tv 1700000000 1 32 192.168.1.20/32
+# br-lan 000100012a2b2c2d 1234abce - 1700000000 81 128 fd00::22/128
+# br-lan 000100012a2b2c2e 1234abcf old -1 82 128 fd00::23/128
+192.168.1.20 tv.lan
+`
+	ls, err := ParseOdhcpd(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 3 {
+		t.Fatalf("expect 3 leases, got %d", len(ls))
+	}
+	if ls[2].Hostname != "tv" || ls[2].MAC != "aabbccddeeff" || ls[2].Addr != netip.MustParseAddr("192.168.1.20") {
+		t.Fatalf("unexpected lease %+v", ls[2])
+	}
+}
+
+func TestTable(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tb := NewTable([]Lease{
+		{Hostname: "Laptop", Addr: netip.MustParseAddr("192.168.1.10")},
+		{Hostname: "laptop", Addr: netip.MustParseAddr("fd00::10")},
+		{Hostname: "old", Addr: netip.MustParseAddr("192.168.1.11"), Expire: now.Add(-time.Second)},
+	}, "lan.")
+
+	v4, v6 := tb.LookupName("LAPTOP.lan.", now)
+	if len(v4) != 1 || len(v6) != 1 {
+		t.Fatalf("unexpected lookup result %v %v", v4, v6)
+	}
+	if v4, v6 := tb.LookupName("old.lan.", now); len(v4)+len(v6) != 0 {
+		t.Fatal("expired lease should not be returned")
+	}
+	fqdn, ok := tb.LookupAddr(netip.MustParseAddr("fd00::10"), now)
+	if !ok || fqdn != "laptop.lan." {
+		t.Fatalf("unexpected reverse lookup result %s", fqdn)
+	}
+}
+
+func TestParseKea(t *testing.T) {
+	data := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.30,aa:bb:cc:dd:ee:ff,,3600,1700000000,1,0,0,nas,0,
+192.168.1.31,aa:bb:cc:dd:ee:00,,3600,1700000000,1,0,0,printer,0,
+192.168.1.30,aa:bb:cc:dd:ee:ff,,3600,1700003600,1,0,0,nas.,0,
+192.168.1.31,aa:bb:cc:dd:ee:00,,3600,1700000000,1,0,0,printer,2,
+`
+	ls, err := ParseKea(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 {
+		t.Fatalf("expect 1 lease, got %d", len(ls))
+	}
+	if ls[0].Hostname != "nas" || ls[0].Expire.Unix() != 1700003600 {
+		t.Fatalf("unexpected lease %+v", ls[0])
+	}
+}
+
+func TestParseISC(t *testing.T) {
+	data := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+server-duid "\000\001";
+
+lease 192.168.1.40 {
+  starts 4 2023/11/14 20:13:20;
+  ends 4 2023/11/14 22:13:20;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:ff;
+  client-hostname "desktop";
+}
+lease 192.168.1.41 {
+  ends never;
+  binding state free;
+  client-hostname "gone";
+}
+`
+	ls, err := ParseISC(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 {
+		t.Fatalf("expect 1 lease, got %d", len(ls))
+	}
+	want := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	if ls[0].Hostname != "desktop" || !ls[0].Expire.Equal(want) || ls[0].MAC != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("unexpected lease %+v", ls[0])
+	}
+}
+
+func TestParseDHCPPacket(t *testing.T) {
+	b := make([]byte, dhcpHeaderLen+4)
+	b[0] = 1 // BOOTREQUEST
+	b[2] = 6 // hlen
+	copy(b[28:], []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	binary.BigEndian.PutUint32(b[dhcpHeaderLen:], dhcpMagic)
+	b = append(b, optMsgType, 1, msgTypeRequest)
+	b = append(b, optRequestedIP, 4, 192, 168, 1, 50)
+	b = append(b, optHostname, 5, 'p', 'h', 'o', 'n', 'e')
+	b = append(b, optEnd)
+
+	now := time.Now()
+	l, ok, err := ParseDHCPPacket(b, now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("no lease learned")
+	}
+	if l.Hostname != "phone" || l.Addr != netip.MustParseAddr("192.168.1.50") || l.MAC != "aa:bb:cc:dd:ee:ff" || !l.Expire.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected lease %+v", l)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_lease
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Table is a read-only index of leases. It is safe for concurrent use.
+type Table struct {
+	names map[string][]Lease    // fqdn (lower case) -> leases
+	addrs map[netip.Addr]string // addr -> fqdn
+	exps  map[netip.Addr]time.Time
+}
+
+// NewTable builds a Table from leases. Hostnames are appended with
+// domain. If domain is empty, hostnames will be used as fqdn directly.
+// If multiple leases have the same ip, the last one wins.
+func NewTable(leases []Lease, domain string) *Table {
+	t := &Table{
+		names: make(map[string][]Lease),
+		addrs: make(map[netip.Addr]string),
+		exps:  make(map[netip.Addr]time.Time),
+	}
+	for _, l := range leases {
+		fqdn := Fqdn(l.Hostname, domain)
+		t.names[fqdn] = append(t.names[fqdn], l)
+		t.addrs[l.Addr] = fqdn
+		t.exps[l.Addr] = l.Expire
+	}
+	return t
+}
+
+// Fqdn returns the fqdn in lower case of hostname in domain.
+func Fqdn(hostname, domain string) string {
+	hostname = strings.TrimSuffix(hostname, ".")
+	domain = strings.Trim(domain, ".")
+	if len(domain) > 0 {
+		hostname = hostname + "." + domain
+	}
+	return strings.ToLower(dns.Fqdn(hostname))
+}
+
+// LookupName returns the unexpired addresses of fqdn.
+func (t *Table) LookupName(fqdn string, now time.Time) (ipv4, ipv6 []netip.Addr) {
+	for _, l := range t.names[strings.ToLower(fqdn)] {
+		if expired(l.Expire, now) || t.addrs[l.Addr] != strings.ToLower(fqdn) {
+			continue
+		}
+		if l.Addr.Is4() || l.Addr.Is4In6() {
+			ipv4 = append(ipv4, l.Addr.Unmap())
+		} else {
+			ipv6 = append(ipv6, l.Addr)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// LookupAddr returns the fqdn of addr if addr has an unexpired lease.
+func (t *Table) LookupAddr(addr netip.Addr, now time.Time) (string, bool) {
+	fqdn, ok := t.addrs[addr]
+	if !ok || expired(t.exps[addr], now) {
+		return "", false
+	}
+	return fqdn, true
+}
+
+// Range calls f for each lease in the Table.
+func (t *Table) Range(f func(fqdn string, l Lease)) {
+	for fqdn, ls := range t.names {
+		for _, l := range ls {
+			f(fqdn, l)
+		}
+	}
+}
+
+func expired(exp, now time.Time) bool {
+	return !exp.IsZero() && now.After(exp)
+}
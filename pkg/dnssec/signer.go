@@ -0,0 +1,358 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dnssec signs locally generated responses online.
+package dnssec
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/concurrent_lru"
+	"github.com/miekg/dns"
+)
+
+const (
+	// Signatures are valid from sigInception ago to sigValidity later,
+	// and are reused until half of sigValidity is left.
+	sigInception = time.Hour
+	sigValidity  = time.Hour * 24 * 7
+
+	// negativeTtl is the ttl of the SOA and NSEC records in negative
+	// responses.
+	negativeTtl = 300
+
+	sigCacheShards       = 16
+	sigCacheSizePerShard = 256
+)
+
+// Args are the plugin args of the online signer.
+type Args struct {
+	Zone string   `yaml:"zone"`
+	Keys []string `yaml:"keys"` // BIND format public key files.
+}
+
+// NewSignerFromArgs returns a Signer of args, or nil if args.Zone is
+// empty, which means dnssec is disabled.
+func NewSignerFromArgs(args Args) (*Signer, error) {
+	if len(args.Zone) == 0 {
+		return nil, nil
+	}
+	s, err := NewSigner(Opts{Zone: args.Zone, KeyFiles: args.Keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init dnssec signer, %w", err)
+	}
+	return s, nil
+}
+
+type Opts struct {
+	// Zone is the zone that will be signed.
+	Zone string
+
+	// KeyFiles are public key files of the zone in BIND format, e.g.
+	// "Kexample.lan.+013+12345.key". The private key is read from the file
+	// with a ".private" suffix instead of ".key".
+	// Keys with the SEP flag are KSKs, which only sign the DNSKEY rrset.
+	// Others are ZSKs. If there is no ZSK, KSKs sign all rrsets.
+	KeyFiles []string
+}
+
+type key struct {
+	dnskey *dns.DNSKEY
+	tag    uint16
+	priv   crypto.Signer
+}
+
+// Signer signs responses of a zone. It is safe for concurrent use.
+type Signer struct {
+	zone    string
+	ksks    []*key
+	zsks    []*key
+	dnskeys []dns.RR
+	soa     *dns.SOA
+	sigs    *concurrent_lru.ShardedLRU[sigKey, *dns.RRSIG]
+}
+
+func NewSigner(opts Opts) (*Signer, error) {
+	if len(opts.Zone) == 0 {
+		return nil, errors.New("missing zone")
+	}
+	if len(opts.KeyFiles) == 0 {
+		return nil, errors.New("missing keys")
+	}
+	zone := dns.CanonicalName(opts.Zone)
+	s := &Signer{
+		zone: zone,
+		soa: &dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   zone,
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+				Ttl:    negativeTtl,
+			},
+			Ns:      "fake-ns.mosdns.fake.root.",
+			Mbox:    "fake-mbox.mosdns.fake.root.",
+			Serial:  1,
+			Refresh: 1800,
+			Retry:   900,
+			Expire:  604800,
+			Minttl:  negativeTtl,
+		},
+		sigs: concurrent_lru.NewShardedLRU[sigKey, *dns.RRSIG](sigCacheShards, sigCacheSizePerShard, nil),
+	}
+	for _, file := range opts.KeyFiles {
+		k, err := loadKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s, %w", file, err)
+		}
+		if dns.CanonicalName(k.dnskey.Hdr.Name) != zone {
+			return nil, fmt.Errorf("key %s is not for zone %s", file, zone)
+		}
+		if k.dnskey.Flags&dns.SEP != 0 {
+			s.ksks = append(s.ksks, k)
+		} else {
+			s.zsks = append(s.zsks, k)
+		}
+		s.dnskeys = append(s.dnskeys, k.dnskey)
+	}
+	if len(s.zsks) == 0 {
+		s.zsks = s.ksks
+	}
+	if len(s.ksks) == 0 {
+		s.ksks = s.zsks
+	}
+	return s, nil
+}
+
+func loadKey(file string) (*key, error) {
+	pub, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer pub.Close()
+	rr, err := dns.ReadRR(pub, file)
+	if err != nil {
+		return nil, err
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a DNSKEY", rr)
+	}
+	dnskey.Hdr.Ttl = negativeTtl
+
+	privFile := strings.TrimSuffix(file, ".key") + ".private"
+	f, err := os.Open(privFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	priv, err := dnskey.ReadPrivateKey(f, privFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key %s, %w", privFile, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %s", privFile)
+	}
+	return &key{dnskey: dnskey, tag: dnskey.KeyTag(), priv: signer}, nil
+}
+
+// Zone returns the canonical name of the zone.
+func (s *Signer) Zone() string {
+	return s.zone
+}
+
+// InZone reports whether name is in the zone.
+func (s *Signer) InZone(name string) bool {
+	return dns.IsSubDomain(s.zone, dns.CanonicalName(name))
+}
+
+// Reply returns a response for DNSKEY and SOA queries of the zone apex,
+// which are needed by validators. It returns nil for other queries.
+// The response is not signed yet.
+func (s *Signer) Reply(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET || dns.CanonicalName(question.Name) != s.zone {
+		return nil
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+	switch question.Qtype {
+	case dns.TypeDNSKEY:
+		for _, rr := range s.dnskeys {
+			rr = dns.Copy(rr)
+			rr.Header().Name = question.Name
+			r.Answer = append(r.Answer, rr)
+		}
+	case dns.TypeSOA:
+		soa := dns.Copy(s.soa)
+		soa.Header().Name = question.Name
+		r.Answer = append(r.Answer, soa)
+	default:
+		return nil
+	}
+	return r
+}
+
+// Sign signs rrsets in r that are in the zone. r is a response for a
+// query that has the DO bit.
+// If r is a negative response of a name in the zone, its authority
+// section is replaced by a signed SOA and a NSEC record that only
+// denies the query type (a.k.a. "black lies", NXDOMAIN will be changed
+// to NODATA). types are types that exist at the query name, which will
+// be added to the NSEC type bitmap.
+func (s *Signer) Sign(r *dns.Msg, types []uint16) error {
+	if len(r.Question) != 1 {
+		return nil
+	}
+	question := r.Question[0]
+	now := time.Now()
+
+	negative := (r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError) && len(r.Answer) == 0
+	if negative && s.InZone(question.Name) {
+		r.Rcode = dns.RcodeSuccess
+		r.Ns = []dns.RR{dns.Copy(s.soa), s.nsec(question.Name, types)}
+	}
+
+	var err error
+	if r.Answer, err = s.signSection(r.Answer, now); err != nil {
+		return err
+	}
+	if r.Ns, err = s.signSection(r.Ns, now); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Signer) nsec(name string, types []uint16) *dns.NSEC {
+	bitmap := append([]uint16{dns.TypeRRSIG, dns.TypeNSEC}, types...)
+	if dns.CanonicalName(name) == s.zone {
+		bitmap = append(bitmap, dns.TypeSOA, dns.TypeDNSKEY)
+	}
+	slices.Sort(bitmap)
+	bitmap = slices.Compact(bitmap)
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    negativeTtl,
+		},
+		// The immediate successor of name, so the NSEC covers no
+		// other name.
+		NextDomain: "\\000." + name,
+		TypeBitMap: bitmap,
+	}
+}
+
+// signSection appends RRSIGs of rrsets in rrs that are in the zone.
+// Existing RRSIGs are removed.
+func (s *Signer) signSection(rrs []dns.RR, now time.Time) ([]dns.RR, error) {
+	rrs = slices.DeleteFunc(rrs, func(rr dns.RR) bool {
+		return rr.Header().Rrtype == dns.TypeRRSIG
+	})
+	n := len(rrs)
+	for i := 0; i < n; i++ {
+		h := rrs[i].Header()
+		if !s.InZone(h.Name) || s.seen(rrs[:i], h) {
+			continue
+		}
+		var rrset []dns.RR
+		for _, rr := range rrs[i:n] {
+			if sameRRset(rr.Header(), h) {
+				rrset = append(rrset, rr)
+			}
+		}
+		keys := s.zsks
+		if h.Rrtype == dns.TypeDNSKEY {
+			keys = s.ksks
+		}
+		for _, k := range keys {
+			sig, err := s.sign(k, rrset, now)
+			if err != nil {
+				return rrs, err
+			}
+			rrs = append(rrs, sig)
+		}
+	}
+	return rrs, nil
+}
+
+// seen reports whether rrset of h is in rrs.
+func (s *Signer) seen(rrs []dns.RR, h *dns.RR_Header) bool {
+	for _, rr := range rrs {
+		if sameRRset(rr.Header(), h) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameRRset(a, b *dns.RR_Header) bool {
+	return a.Rrtype == b.Rrtype && a.Class == b.Class && strings.EqualFold(a.Name, b.Name)
+}
+
+type sigKey struct {
+	rrset string
+	tag   uint16
+}
+
+var seed = maphash.MakeSeed()
+
+func (k sigKey) Sum() uint64 {
+	return maphash.String(seed, k.rrset) + uint64(k.tag)
+}
+
+// sign returns a copy of the RRSIG of rrset by k. Signatures are cached
+// because signing is expensive and local records rarely change.
+func (s *Signer) sign(k *key, rrset []dns.RR, now time.Time) (*dns.RRSIG, error) {
+	var sb strings.Builder
+	for _, rr := range rrset {
+		sb.WriteString(rr.String())
+		sb.WriteByte('\n')
+	}
+	ck := sigKey{rrset: sb.String(), tag: k.tag}
+	if sig, ok := s.sigs.Get(ck); ok && now.Add(sigValidity/2).Before(time.Unix(int64(sig.Expiration), 0)) {
+		return dns.Copy(sig).(*dns.RRSIG), nil
+	}
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  k.dnskey.Algorithm,
+		Expiration: uint32(now.Add(sigValidity).Unix()),
+		Inception:  uint32(now.Add(-sigInception).Unix()),
+		KeyTag:     k.tag,
+		SignerName: s.zone,
+	}
+	if err := sig.Sign(k.priv, rrset); err != nil {
+		return nil, err
+	}
+	s.sigs.Add(ck, sig)
+	return dns.Copy(sig).(*dns.RRSIG), nil
+}
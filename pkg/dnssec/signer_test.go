@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnssec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// genKey generates a key of zone to dir and returns the public key file.
+func genKey(t *testing.T, dir, zone string, flags uint16) (string, *dns.DNSKEY) {
+	t.Helper()
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	require.NoError(t, err)
+	base := filepath.Join(dir, fmt.Sprintf("K%s+013+%05d", zone, k.KeyTag()))
+	require.NoError(t, os.WriteFile(base+".key", []byte(k.String()+"\n"), 0644))
+	require.NoError(t, os.WriteFile(base+".private", []byte(k.PrivateKeyString(priv)), 0600))
+	return base + ".key", k
+}
+
+// verify verifies all rrsets in rrs that have signatures.
+func verify(t *testing.T, rrs []dns.RR, keys map[uint16]*dns.DNSKEY) {
+	t.Helper()
+	sigs := 0
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		sigs++
+		var rrset []dns.RR
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype == sig.TypeCovered && h.Name == sig.Hdr.Name {
+				rrset = append(rrset, rr)
+			}
+		}
+		k := keys[sig.KeyTag]
+		require.NotNil(t, k, "unknown key tag %d", sig.KeyTag)
+		require.NoError(t, sig.Verify(k, rrset), "bad signature %s", sig)
+		require.True(t, sig.ValidityPeriod(time.Now()))
+	}
+	require.NotZero(t, sigs, "no signature")
+}
+
+func TestSigner(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	kskFile, ksk := genKey(t, dir, "example.lan.", 257)
+	zskFile, zsk := genKey(t, dir, "example.lan.", 256)
+	keys := map[uint16]*dns.DNSKEY{ksk.KeyTag(): ksk, zsk.KeyTag(): zsk}
+
+	s, err := NewSigner(Opts{Zone: "Example.LAN", KeyFiles: []string{kskFile, zskFile}})
+	r.NoError(err)
+
+	// Positive response.
+	q := new(dns.Msg)
+	q.SetQuestion("Host.example.lan.", dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	for _, s := range []string{"Host.example.lan. 10 IN A 192.168.1.1", "Host.example.lan. 10 IN A 192.168.1.2", "outside.com. 10 IN A 1.1.1.1"} {
+		rr, err := dns.NewRR(s)
+		r.NoError(err)
+		resp.Answer = append(resp.Answer, rr)
+	}
+	r.NoError(s.Sign(resp, nil))
+	r.Len(resp.Answer, 4, "rrset out of the zone should not be signed")
+	r.Equal(zsk.KeyTag(), resp.Answer[3].(*dns.RRSIG).KeyTag)
+	verify(t, resp.Answer, keys)
+
+	// Signatures are cached.
+	resp2 := resp.Copy()
+	resp2.Answer = resp2.Answer[:3]
+	r.NoError(s.Sign(resp2, nil))
+	r.Equal(resp.Answer[3].String(), resp2.Answer[3].String())
+
+	// Negative response.
+	q.SetQuestion("host.example.lan.", dns.TypeAAAA)
+	resp = new(dns.Msg)
+	resp.SetRcode(q, dns.RcodeNameError)
+	r.NoError(s.Sign(resp, []uint16{dns.TypeA}))
+	r.Equal(dns.RcodeSuccess, resp.Rcode)
+	verify(t, resp.Ns, keys)
+	var nsec *dns.NSEC
+	for _, rr := range resp.Ns {
+		if v, ok := rr.(*dns.NSEC); ok {
+			nsec = v
+		}
+	}
+	r.NotNil(nsec)
+	r.Equal([]uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}, nsec.TypeBitMap)
+	r.Equal("\\000.host.example.lan.", nsec.NextDomain)
+
+	// DNSKEY is signed by the ksk.
+	q.SetQuestion("example.lan.", dns.TypeDNSKEY)
+	resp = s.Reply(q)
+	r.NotNil(resp)
+	r.Len(resp.Answer, 2)
+	r.NoError(s.Sign(resp, nil))
+	r.Len(resp.Answer, 3)
+	r.Equal(ksk.KeyTag(), resp.Answer[2].(*dns.RRSIG).KeyTag)
+	verify(t, resp.Answer, keys)
+
+	q.SetQuestion("host.example.lan.", dns.TypeDNSKEY)
+	r.Nil(s.Reply(q))
+}
+
+func TestNewSigner_wrongZone(t *testing.T) {
+	file, _ := genKey(t, t.TempDir(), "example.lan.", 257)
+	_, err := NewSigner(Opts{Zone: "other.lan.", KeyFiles: []string{file}})
+	require.Error(t, err)
+}
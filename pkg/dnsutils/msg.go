@@ -70,6 +70,17 @@ func ApplyMinimalTTL(m *dns.Msg, ttl uint32) {
 	applyTTL(m, ttl, false)
 }
 
+// ClampTTL limits ttl to [min, max]. If max is 0, there is no upper limit.
+func ClampTTL(ttl, min, max uint32) uint32 {
+	if ttl < min {
+		ttl = min
+	}
+	if max > 0 && ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
 // SubtractTTL subtract delta from every m's RR.
 // If RR's TTL is smaller than delta, SubtractTTL
 // will return overflowed = true.
@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package dnsutils
+
+import "testing"
+
+func TestClampTTL(t *testing.T) {
+	tests := []struct {
+		name          string
+		ttl, min, max uint32
+		want          uint32
+	}{
+		{"in range", 300, 60, 3600, 300},
+		{"below min", 10, 60, 3600, 60},
+		{"above max", 7200, 60, 3600, 3600},
+		{"no max", 7200, 60, 0, 7200},
+		{"no limit", 0, 0, 0, 0},
+		{"equal bounds", 10, 30, 30, 30},
+		{"min above max", 10, 60, 30, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClampTTL(tt.ttl, tt.min, tt.max); got != tt.want {
+				t.Errorf("ClampTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
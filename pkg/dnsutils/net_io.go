@@ -129,9 +129,11 @@ func ReadMsgFromUDP(c io.Reader, bufSize int) (*dns.Msg, int, error) {
 	return m, n, err
 }
 
+// unpackMsgWithDetailedErr unpacks b into a msg from the pool.
+// See pool.ReleaseMsg.
 func unpackMsgWithDetailedErr(b []byte) (*dns.Msg, error) {
-	m := new(dns.Msg)
-	if err := m.Unpack(b); err != nil {
+	m, err := pool.UnpackMsg(b)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unpack msg [%x], %w", b, err)
 	}
 	return m, nil
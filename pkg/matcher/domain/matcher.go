@@ -20,10 +20,10 @@
 package domain
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"regexp"
-	"strings"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 )
@@ -35,49 +35,52 @@ var _ WriteableMatcher[any] = (*KeywordMatcher[any])(nil)
 var _ WriteableMatcher[any] = (*RegexMatcher[any])(nil)
 
 type SubDomainMatcher[T any] struct {
-	root *labelNode[T]
+	trie *labelTrie[T]
 }
 
 func NewSubDomainMatcher[T any]() *SubDomainMatcher[T] {
-	return &SubDomainMatcher[T]{root: new(labelNode[T])}
+	return &SubDomainMatcher[T]{trie: newLabelTrie[T]()}
 }
 
 func (m *SubDomainMatcher[T]) Match(s string) (T, bool) {
-	s = NormalizeDomain(s)
-	ds := NewReverseDomainScanner(s)
-	currentNode := m.root
-	v, ok := currentNode.getValue()
-	for ds.Scan() {
-		label := ds.NextLabel()
-		if nextNode := currentNode.getChild(label); nextNode != nil {
-			if nextNode.hasValue() {
-				v, ok = nextNode.getValue()
-			}
-			currentNode = nextNode
-		} else {
+	var buf [domainBufSize]byte
+	return m.match(appendNormalizedDomain(buf[:0], s))
+}
+
+// match matches the normalized domain b. Labels are looked up as byte
+// slices, so it does not allocate.
+func (m *SubDomainMatcher[T]) match(b []byte) (T, bool) {
+	var currentNode uint32 // root
+	v, ok := m.trie.getValue(currentNode)
+	for end := len(b); end > 0; {
+		start := bytes.LastIndexByte(b[:end], '.') + 1
+		nextNode, found := m.trie.child(currentNode, b[start:end])
+		if !found {
 			break
 		}
+		if nv, hasV := m.trie.getValue(nextNode); hasV {
+			v, ok = nv, true
+		}
+		currentNode = nextNode
+		end = start - 1
 	}
 	return v, ok
 }
 
 func (m *SubDomainMatcher[T]) Len() int {
-	return m.root.len()
+	return m.trie.len()
 }
 
 func (m *SubDomainMatcher[T]) Add(s string, v T) error {
-	s = NormalizeDomain(s)
-	ds := NewReverseDomainScanner(s)
-	currentNode := m.root
-	for ds.Scan() {
-		label := ds.NextLabel()
-		if child := currentNode.getChild(label); child != nil {
-			currentNode = child
-		} else {
-			currentNode = currentNode.newChild(label)
-		}
+	var buf [domainBufSize]byte
+	b := appendNormalizedDomain(buf[:0], s)
+	var currentNode uint32 // root
+	for end := len(b); end > 0; {
+		start := bytes.LastIndexByte(b[:end], '.') + 1
+		currentNode = m.trie.getOrAddChild(currentNode, b[start:end])
+		end = start - 1
 	}
-	currentNode.storeValue(v)
+	m.trie.storeValue(currentNode, v)
 	return nil
 }
 
@@ -99,8 +102,12 @@ func (m *FullMatcher[T]) Add(s string, v T) error {
 }
 
 func (m *FullMatcher[T]) Match(s string) (v T, ok bool) {
-	s = NormalizeDomain(s)
-	v, ok = m.m[s]
+	var buf [domainBufSize]byte
+	return m.match(appendNormalizedDomain(buf[:0], s))
+}
+
+func (m *FullMatcher[T]) match(b []byte) (v T, ok bool) {
+	v, ok = m.m[string(b)]
 	return
 }
 
@@ -125,9 +132,13 @@ func (m *KeywordMatcher[T]) Add(keyword string, v T) error {
 }
 
 func (m *KeywordMatcher[T]) Match(s string) (v T, ok bool) {
-	s = NormalizeDomain(s)
+	var buf [domainBufSize]byte
+	return m.match(appendNormalizedDomain(buf[:0], s))
+}
+
+func (m *KeywordMatcher[T]) match(b []byte) (v T, ok bool) {
 	for k, v := range m.kws {
-		if strings.Contains(s, k) {
+		if bytes.Contains(b, []byte(k)) {
 			return v, true
 		}
 	}
@@ -171,7 +182,12 @@ func (m *RegexMatcher[T]) Add(expr string, v T) error {
 }
 
 func (m *RegexMatcher[T]) Match(s string) (v T, ok bool) {
-	s = NormalizeDomain(s)
+	return m.match(NormalizeDomain(s))
+}
+
+// match matches the normalized domain s. Unlike other matchers, regexps
+// keep their inputs, so s is a string.
+func (m *RegexMatcher[T]) match(s string) (v T, ok bool) {
 	for _, e := range m.regs {
 		if e.reg.MatchString(s) {
 			return e.v, true
@@ -247,12 +263,20 @@ func (m *MixMatcher[T]) Add(s string, v T) error {
 }
 
 func (m *MixMatcher[T]) Match(s string) (v T, ok bool) {
-	for _, matcher := range [...]Matcher[T]{m.full, m.domain, m.regex, m.keyword} {
-		if v, ok = matcher.Match(s); ok {
+	var buf [domainBufSize]byte
+	b := appendNormalizedDomain(buf[:0], s)
+	if v, ok = m.full.match(b); ok {
+		return v, true
+	}
+	if v, ok = m.domain.match(b); ok {
+		return v, true
+	}
+	if len(m.regex.regs) > 0 {
+		if v, ok = m.regex.match(string(b)); ok {
 			return v, true
 		}
 	}
-	return
+	return m.keyword.match(b)
 }
 
 func (m *MixMatcher[T]) Len() int {
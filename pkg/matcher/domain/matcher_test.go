@@ -20,7 +20,9 @@
 package domain
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -198,3 +200,72 @@ func Test_RegexMatcher(t *testing.T) {
 	expr = "*"
 	add(expr, nil, true)
 }
+
+func Test_MixMatcher_allocs(t *testing.T) {
+	m := NewMixMatcher[struct{}]()
+	m.SetDefaultMatcher(MatcherDomain)
+	// Regexps are not included, they need the domain as a string.
+	for _, s := range []string{"full:a.example.com", "b.example.com", "keyword:kw"} {
+		if err := m.Add(s, struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range []string{"A.Example.COM.", "x.b.example.com.", "a.kw.com.", "not.found."} {
+		if n := testing.AllocsPerRun(100, func() { m.Match(d) }); n != 0 {
+			t.Errorf("%s: want no allocation, got %v", d, n)
+		}
+	}
+	// Non-ascii domains still work.
+	if _, ok := m.Match("Ä.B.EXAMPLE.COM"); !ok {
+		t.Error("non-ascii domain is not matched")
+	}
+}
+
+// newBenchDomains returns n different domains with a few levels of
+// subdomains, like those in large domain lists.
+func newBenchDomains(n int) []string {
+	ds := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ds = append(ds, fmt.Sprintf("s%d.d%d.example%d.com", i%7, i, i%1000))
+	}
+	return ds
+}
+
+func Benchmark_SubDomainMatcher_Match(b *testing.B) {
+	const n = 1 << 20
+	m := NewSubDomainMatcher[struct{}]()
+	ds := newBenchDomains(n)
+	for _, d := range ds {
+		_ = m.Add(d, struct{}{})
+	}
+	qs := make([]string, 0, 1024)
+	for i := 0; i < 1024; i++ {
+		qs = append(qs, "WWW."+strings.ToUpper(ds[i*(n/1024)])+".") // hits
+		qs = append(qs, fmt.Sprintf("www.miss%d.example.com.", i))  // misses
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(qs[i%len(qs)])
+	}
+}
+
+func Benchmark_FullMatcher_Match(b *testing.B) {
+	const n = 1 << 20
+	m := NewFullMatcher[struct{}]()
+	ds := newBenchDomains(n)
+	for _, d := range ds {
+		_ = m.Add(d, struct{}{})
+	}
+	qs := make([]string, 0, 1024)
+	for i := 0; i < 1024; i++ {
+		qs = append(qs, strings.ToUpper(ds[i*(n/1024)])+".")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(qs[i%len(qs)])
+	}
+}
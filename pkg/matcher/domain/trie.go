@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain
+
+import (
+	"bytes"
+	"hash/maphash"
+	"math"
+)
+
+// labelTrie is a trie of domain labels. The root is the top level
+// domain side. Instead of millions of small heap nodes, nodes are stored
+// in a slice, labels in a byte arena, and edges in a single map that has
+// no pointers. So a large trie uses less memory and is cheap for the gc
+// to scan.
+type labelTrie[T any] struct {
+	nodes  []trieNode // nodes[0] is the root.
+	values []T        // values of nodes, indexed by trieNode.v - 1.
+	labels []byte     // labels of all nodes.
+
+	// edges maps the hash of a (parent, label) pair to the child.
+	// Collided pairs are stored at the next probe of the hash.
+	// See probe.
+	edges map[uint64]uint32
+	seed  maphash.Seed
+}
+
+type trieNode struct {
+	parent   uint32
+	labelOff uint32
+	labelLen uint32
+	v        uint32 // 0 means no value.
+}
+
+func newLabelTrie[T any]() *labelTrie[T] {
+	return &labelTrie[T]{
+		nodes: make([]trieNode, 1),
+		edges: make(map[uint64]uint32),
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+func (t *labelTrie[T]) hash(parent uint32, label []byte) uint64 {
+	return maphash.Bytes(t.seed, label) + uint64(parent)*0x9e3779b97f4a7c15
+}
+
+// probe returns the next key to try if key collided.
+func probe(key uint64) uint64 {
+	// A 64-bit LCG, which has a full period.
+	return key*6364136223846793005 + 1442695040888963407
+}
+
+func (t *labelTrie[T]) label(n *trieNode) []byte {
+	return t.labels[n.labelOff : n.labelOff+n.labelLen]
+}
+
+// child returns the child of parent with label. ok is false if there is
+// no such child.
+func (t *labelTrie[T]) child(parent uint32, label []byte) (_ uint32, ok bool) {
+	for key := t.hash(parent, label); ; key = probe(key) {
+		i, ok := t.edges[key]
+		if !ok {
+			return 0, false
+		}
+		if n := &t.nodes[i]; n.parent == parent && bytes.Equal(t.label(n), label) {
+			return i, true
+		}
+	}
+}
+
+// getOrAddChild returns the child of parent with label. It adds the child
+// if it does not exist.
+func (t *labelTrie[T]) getOrAddChild(parent uint32, label []byte) uint32 {
+	key := t.hash(parent, label)
+	for ; ; key = probe(key) {
+		i, ok := t.edges[key]
+		if !ok {
+			break
+		}
+		if n := &t.nodes[i]; n.parent == parent && bytes.Equal(t.label(n), label) {
+			return i
+		}
+	}
+	if uint64(len(t.nodes)) >= math.MaxUint32 || uint64(len(t.labels)+len(label)) > math.MaxUint32 {
+		panic("domain: too many domains in a trie")
+	}
+	i := uint32(len(t.nodes))
+	t.nodes = append(t.nodes, trieNode{
+		parent:   parent,
+		labelOff: uint32(len(t.labels)),
+		labelLen: uint32(len(label)),
+	})
+	t.labels = append(t.labels, label...)
+	t.edges[key] = i
+	return i
+}
+
+func (t *labelTrie[T]) storeValue(i uint32, v T) {
+	n := &t.nodes[i]
+	if n.v == 0 {
+		t.values = append(t.values, v)
+		n.v = uint32(len(t.values))
+		return
+	}
+	t.values[n.v-1] = v
+}
+
+func (t *labelTrie[T]) getValue(i uint32) (v T, ok bool) {
+	if n := t.nodes[i]; n.v != 0 {
+		return t.values[n.v-1], true
+	}
+	return v, false
+}
+
+// len returns the number of nodes that have a value.
+func (t *labelTrie[T]) len() int {
+	return len(t.values)
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain
+
+import (
+	"testing"
+)
+
+func Test_labelTrie_collision(t *testing.T) {
+	tr := newLabelTrie[int]()
+	a := tr.getOrAddChild(0, []byte("a"))
+	// Pretend that "b" collides with "a".
+	tr.edges[tr.hash(0, []byte("b"))] = a
+
+	b := tr.getOrAddChild(0, []byte("b"))
+	if b == a {
+		t.Fatal("collided label should get a new node")
+	}
+	if got := tr.getOrAddChild(0, []byte("b")); got != b {
+		t.Fatalf("want node %d, got %d", b, got)
+	}
+	for label, want := range map[string]uint32{"a": a, "b": b} {
+		if got, ok := tr.child(0, []byte(label)); !ok || got != want {
+			t.Fatalf("%s: want node %d, got %d, %v", label, want, got, ok)
+		}
+	}
+	if _, ok := tr.child(a, []byte("b")); ok {
+		t.Fatal("b should not be a child of a")
+	}
+}
+
+func Test_SubDomainMatcher_large(t *testing.T) {
+	m := NewSubDomainMatcher[int]()
+	ds := newBenchDomains(1 << 14)
+	for i, d := range ds {
+		_ = m.Add(d, i)
+	}
+	assertInt(t, len(ds), m.Len())
+	for i, d := range ds {
+		if v, ok := m.Match("sub." + d); !ok || v != i {
+			t.Fatalf("%s: want %d, got %d, %v", d, i, v, ok)
+		}
+	}
+	if _, ok := m.Match("d1.example1.com"); ok {
+		t.Fatal("parent domain should not be matched")
+	}
+}
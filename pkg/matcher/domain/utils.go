@@ -21,8 +21,13 @@ package domain
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
+// domainBufSize is the size of buffers on the stack for normalized
+// domains. It fits all domains without escaped characters.
+const domainBufSize = 256
+
 type ReverseDomainScanner struct {
 	s string // not fqdn
 	p int
@@ -62,6 +67,25 @@ func NormalizeDomain(s string) string {
 	return strings.ToLower(TrimDot(s))
 }
 
+// appendNormalizedDomain appends the normalized domain s to b.
+// It is the same as NormalizeDomain but does not allocate if b has
+// enough capacity.
+func appendNormalizedDomain(b []byte, s string) []byte {
+	s = TrimDot(s)
+	n := len(b)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf { // Not an ascii domain. Let strings do it.
+			return append(b[:n], strings.ToLower(s)...)
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
 // TrimDot trims suffix '.'
 func TrimDot(s string) string {
 	if len(s) >= 1 && s[len(s)-1] == '.' {
@@ -69,48 +93,3 @@ func TrimDot(s string) string {
 	}
 	return s
 }
-
-// labelNode can store dns labels.
-type labelNode[T any] struct {
-	children map[string]*labelNode[T] // lazy init
-
-	v    T
-	hasV bool
-}
-
-func (n *labelNode[T]) storeValue(v T) {
-	n.v = v
-	n.hasV = true
-}
-
-func (n *labelNode[T]) getValue() (T, bool) {
-	return n.v, n.hasV
-}
-
-func (n *labelNode[T]) hasValue() bool {
-	return n.hasV
-}
-
-func (n *labelNode[T]) newChild(key string) *labelNode[T] {
-	if n.children == nil {
-		n.children = make(map[string]*labelNode[T])
-	}
-	node := new(labelNode[T])
-	n.children[key] = node
-	return node
-}
-
-func (n *labelNode[T]) getChild(key string) *labelNode[T] {
-	return n.children[key]
-}
-
-func (n *labelNode[T]) len() int {
-	l := 0
-	for _, node := range n.children {
-		l += node.len()
-		if node.hasValue() {
-			l++
-		}
-	}
-	return l
-}
@@ -40,6 +40,8 @@ var (
 // The table that contains this set must be an inet family table.
 // If the set has a 'interval' flag, the prefix from netip.Prefix will be
 // applied.
+// If the set has a 'timeout' flag, elements will be added with
+// HandlerOpts.Timeout or the timeout given in AddElemsWithTimeout.
 type NftSetHandler struct {
 	opts HandlerOpts
 
@@ -56,6 +58,10 @@ type HandlerOpts struct {
 	TableFamily nftables.TableFamily
 	TableName   string
 	SetName     string
+
+	// Timeout of new elements. Zero means using the set's default timeout.
+	// It is ignored if the set does not have a 'timeout' flag.
+	Timeout time.Duration
 }
 
 // NewNtSetHandler inits NftSetHandler.
@@ -87,6 +93,12 @@ func (h *NftSetHandler) getSetLocked() (*nftables.Set, error) {
 
 // AddElems adds netip.Prefix(s) to set in a single batch.
 func (h *NftSetHandler) AddElems(es ...netip.Prefix) error {
+	return h.AddElemsWithTimeout(h.opts.Timeout, es...)
+}
+
+// AddElemsWithTimeout adds netip.Prefix(s) to set in a single batch with
+// the given timeout. Zero timeout means using the set's default timeout.
+func (h *NftSetHandler) AddElemsWithTimeout(timeout time.Duration, es ...netip.Prefix) error {
 	h.m.Lock()
 	defer h.m.Unlock()
 
@@ -107,6 +119,10 @@ func (h *NftSetHandler) AddElems(es ...netip.Prefix) error {
 		return fmt.Errorf("failed to get set, %w", err)
 	}
 
+	if !set.HasTimeout {
+		timeout = 0
+	}
+
 	var elems []nftables.SetElement
 	if set.Interval {
 		elems = make([]nftables.SetElement, 0, 2*len(es))
@@ -120,14 +136,14 @@ func (h *NftSetHandler) AddElems(es ...netip.Prefix) error {
 		}
 		if set.Interval {
 			start := e.Masked().Addr()
-			elems = append(elems, nftables.SetElement{Key: start.AsSlice(), IntervalEnd: false})
-			
+			elems = append(elems, nftables.SetElement{Key: start.AsSlice(), IntervalEnd: false, Timeout: timeout})
+
 			end := netipx.PrefixLastIP(e).Next() // may be invalid if end is overflowed
 			if end.IsValid() {
 				elems = append(elems, nftables.SetElement{Key: end.AsSlice(), IntervalEnd: true})
 			}
 		} else {
-			elems = append(elems, nftables.SetElement{Key: e.Addr().AsSlice()})
+			elems = append(elems, nftables.SetElement{Key: e.Addr().AsSlice(), Timeout: timeout})
 		}
 	}
 
@@ -27,6 +27,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 func skipCI(t *testing.T) {
@@ -36,6 +37,10 @@ func skipCI(t *testing.T) {
 }
 
 func prepareSet(t testing.TB, tableName, setName string, interval bool) {
+	prepareSetWithTimeout(t, tableName, setName, interval, false)
+}
+
+func prepareSetWithTimeout(t testing.TB, tableName, setName string, interval, timeout bool) {
 	t.Helper()
 	nc, err := nftables.New()
 	if err != nil {
@@ -44,7 +49,7 @@ func prepareSet(t testing.TB, tableName, setName string, interval bool) {
 
 	table := &nftables.Table{Name: tableName, Family: nftables.TableFamilyINet}
 	nc.AddTable(table)
-	if err := nc.AddSet(&nftables.Set{Name: setName, Table: table, KeyType: nftables.TypeIPAddr, Interval: interval}, nil); err != nil {
+	if err := nc.AddSet(&nftables.Set{Name: setName, Table: table, KeyType: nftables.TypeIPAddr, Interval: interval, HasTimeout: timeout}, nil); err != nil {
 		t.Fatal(err)
 	}
 	if err := nc.Flush(); err != nil {
@@ -94,3 +99,32 @@ func Test_AddElems(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func Test_AddElemsWithTimeout(t *testing.T) {
+	skipCI(t)
+	n := "test_timeout"
+	prepareSetWithTimeout(t, n, n, false, true)
+
+	h := NewNtSetHandler(HandlerOpts{
+		TableFamily: nftables.TableFamilyINet,
+		TableName:   n,
+		SetName:     n,
+	})
+	h.disableSetCache = true
+
+	if err := h.AddElemsWithTimeout(time.Minute, netip.MustParsePrefix("127.0.0.1/32")); err != nil {
+		t.Fatal(err)
+	}
+
+	nc, err := nftables.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elems, err := nc.GetSetElements(h.set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elems) != 1 || elems[0].Timeout != time.Minute {
+		t.Fatalf("unexpected elems %v", elems)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Msg pool.
+//
+// Ownership rules:
+//   - A msg from GetMsg or UnpackMsg is owned by the caller. The owner can
+//     pass the ownership to others. E.g. query_context.NewContext and
+//     Context.SetResponse take the ownership of their msg.
+//   - Only the owner can release a msg by calling ReleaseMsg, at most once.
+//     The msg and its section slices MUST NOT be used after that. Anyone
+//     that needs a msg after its owner is done with it, e.g. in another
+//     goroutine, must keep a copy.
+//   - Section slices of a msg MUST NOT be shared with other msgs. Append
+//     the RRs instead.
+//   - RRs are never reused. They can be shared freely.
+//
+// A msg that is not released is simply collected by the GC. So releasing
+// is an optimization, only do it if the ownership is clear.
+var msgPool = sync.Pool{New: func() any { return new(dns.Msg) }}
+
+// maxPooledSectionCap limits the capacity of section slices that are kept
+// by ReleaseMsg. Slices of large msgs are dropped instead.
+const maxPooledSectionCap = 64
+
+// GetMsg returns an empty msg from the pool.
+func GetMsg() *dns.Msg {
+	return msgPool.Get().(*dns.Msg)
+}
+
+// ReleaseMsg resets m and puts it back to the pool. m can be nil.
+// See the ownership rules above.
+func ReleaseMsg(m *dns.Msg) {
+	if m == nil {
+		return
+	}
+	*m = dns.Msg{
+		Question: resetSection(m.Question),
+		Answer:   resetSection(m.Answer),
+		Ns:       resetSection(m.Ns),
+		Extra:    resetSection(m.Extra),
+	}
+	msgPool.Put(m)
+}
+
+func resetSection[T any](s []T) []T {
+	if cap(s) > maxPooledSectionCap {
+		return nil
+	}
+	clear(s[:cap(s)]) // Don't hold the RRs.
+	return s[:0]
+}
+
+var errUnpackOverflow = errors.New("overflow unpacking msg")
+
+// UnpackMsg unpacks b into a msg from the pool. Section slices of the
+// pooled msg are reused. The result is the same as dns.Msg.Unpack.
+func UnpackMsg(b []byte) (*dns.Msg, error) {
+	m := GetMsg()
+	if err := unpackMsg(m, b); err != nil {
+		ReleaseMsg(m)
+		return nil, err
+	}
+	return m, nil
+}
+
+// UnpackQuestion is like UnpackMsg, but only unpacks the header and the
+// first question of b. Other sections are left empty. off is the offset
+// of the end of the question. Header counts of b are not checked.
+func UnpackQuestion(b []byte) (m *dns.Msg, off int, err error) {
+	m = GetMsg()
+	if err := unpackHeader(m, b); err != nil {
+		ReleaseMsg(m)
+		return nil, 0, err
+	}
+	question, off, err := unpackQuestion(b, 12)
+	if err != nil {
+		ReleaseMsg(m)
+		return nil, 0, err
+	}
+	m.Question = append(m.Question, question)
+	return m, off, nil
+}
+
+// unpackHeader unpacks the header of b into m. Section slices of m are
+// kept but emptied.
+func unpackHeader(m *dns.Msg, b []byte) error {
+	if len(b) < 12 {
+		return errUnpackOverflow
+	}
+	// Let dns.Msg.Unpack parse the header only. It resets the sections.
+	q, an, ns, ex := m.Question, m.Answer, m.Ns, m.Extra
+	if err := m.Unpack(b[:12]); err != nil {
+		return err
+	}
+	m.Question, m.Answer, m.Ns, m.Extra = q[:0], an[:0], ns[:0], ex[:0]
+	return nil
+}
+
+// unpackQuestion unpacks a question like dns.Msg.Unpack. A question
+// that is truncated right after its name or its qtype is not an error,
+// missing fields are left zero.
+func unpackQuestion(b []byte, off int) (dns.Question, int, error) {
+	var question dns.Question
+	var err error
+	question.Name, off, err = dns.UnpackDomainName(b, off)
+	if err != nil {
+		return question, off, fmt.Errorf("bad question name: %w", err)
+	}
+	if off == len(b) {
+		return question, off, nil
+	}
+	if off+2 > len(b) {
+		return question, len(b), fmt.Errorf("bad question qtype: %w", errUnpackOverflow)
+	}
+	question.Qtype = uint16(b[off])<<8 | uint16(b[off+1])
+	off += 2
+	if off == len(b) {
+		return question, off, nil
+	}
+	if off+2 > len(b) {
+		return question, len(b), fmt.Errorf("bad question qclass: %w", errUnpackOverflow)
+	}
+	question.Qclass = uint16(b[off])<<8 | uint16(b[off+1])
+	return question, off + 2, nil
+}
+
+func unpackMsg(m *dns.Msg, b []byte) error {
+	if err := unpackHeader(m, b); err != nil {
+		return err
+	}
+	q, an, ns, ex := m.Question, m.Answer, m.Ns, m.Extra
+	m.Question, m.Answer, m.Ns, m.Extra = nil, nil, nil, nil
+	off := 12
+	if off == len(b) {
+		return nil
+	}
+
+	qdCount := int(b[4])<<8 | int(b[5])
+	anCount := int(b[6])<<8 | int(b[7])
+	nsCount := int(b[8])<<8 | int(b[9])
+	arCount := int(b[10])<<8 | int(b[11])
+
+	// Counts are attacker controlled. Never pre-allocate by them.
+	for i := 0; i < qdCount && off < len(b); i++ {
+		question, off1, err := unpackQuestion(b, off)
+		if err != nil {
+			return err
+		}
+		if off1 == off { // qdCount is a lie
+			break
+		}
+		off = off1
+		q = append(q, question)
+	}
+	m.Question = q
+
+	var err error
+	if m.Answer, off, err = unpackRRs(an, anCount, b, off); err != nil {
+		return err
+	}
+	if m.Ns, off, err = unpackRRs(ns, nsCount, b, off); err != nil {
+		return err
+	}
+	if m.Extra, _, err = unpackRRs(ex, arCount, b, off); err != nil {
+		return err
+	}
+
+	// Set extended Rcode
+	if opt := m.IsEdns0(); opt != nil {
+		m.Rcode |= opt.ExtendedRcode()
+	}
+	return nil
+}
+
+// unpackRRs appends up to n RRs from b to s.
+func unpackRRs(s []dns.RR, n int, b []byte, off int) ([]dns.RR, int, error) {
+	for i := 0; i < n && off < len(b); i++ {
+		rr, off1, err := dns.UnpackRR(b, off)
+		if err != nil {
+			return s, len(b), err
+		}
+		if off1 == off { // n is a lie
+			break
+		}
+		off = off1
+		s = append(s, rr)
+	}
+	return s, off, nil
+}
@@ -47,6 +47,26 @@ func PackBuffer(m *dns.Msg) (*[]byte, error) {
 	return msgBuf, nil
 }
 
+// CopyBuffer copies the msg b in wire format to a buf.
+// Callers should release the buf by calling ReleaseBuf.
+// It has the same signature as PackBuffer for msgs in wire format.
+func CopyBuffer(b []byte) (*[]byte, error) {
+	msgBuf := GetBuf(len(b))
+	copy(*msgBuf, b)
+	return msgBuf, nil
+}
+
+// CopyTCPBuffer is like CopyBuffer, with two bytes length header.
+func CopyTCPBuffer(b []byte) (*[]byte, error) {
+	if len(b) > dns.MaxMsgSize {
+		return nil, fmt.Errorf("dns payload size %d is too large", len(b))
+	}
+	msgBuf := GetBuf(2 + len(b))
+	binary.BigEndian.PutUint16(*msgBuf, uint16(len(b)))
+	copy((*msgBuf)[2:], b)
+	return msgBuf, nil
+}
+
 // PackBuffer packs the dns msg m to wire format, with to bytes length header.
 // Callers should release the buf by calling ReleaseBuf.
 func PackTCPBuffer(m *dns.Msg) (*[]byte, error) {
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package pool
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func Test_UnpackMsg(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(1232, true)
+	q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.IPv4(1, 2, 3, 0).To4(),
+	})
+
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+	for i := 0; i < 3; i++ {
+		rr, _ := dns.NewRR("example.com. 300 IN CNAME target.example.com.")
+		r.Answer = append(r.Answer, rr)
+	}
+	ns, _ := dns.NewRR("example.com. 300 IN NS ns.example.com.")
+	r.Ns = append(r.Ns, ns)
+	r.SetEdns0(1232, false)
+	r.Rcode = dns.RcodeBadCookie // extended rcode
+
+	empty := new(dns.Msg)
+	empty.Id = 1
+
+	for _, m := range []*dns.Msg{q, r, empty} {
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := new(dns.Msg)
+		if err := want.Unpack(b); err != nil {
+			t.Fatal(err)
+		}
+
+		// Twice, the second msg is from the pool.
+		for i := 0; i < 2; i++ {
+			got, err := UnpackMsg(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() || got.MsgHdr != want.MsgHdr {
+				t.Fatalf("want msg:\n%s\ngot:\n%s", want, got)
+			}
+			ReleaseMsg(got)
+		}
+
+		// Truncated msgs, e.g. a question without qclass, are unpacked
+		// the same way.
+		for i := range b {
+			if i == 12 {
+				continue // header only.
+			}
+			want := new(dns.Msg)
+			wantErr := want.Unpack(b[:i])
+			got, err := UnpackMsg(b[:i])
+			if wantErr != nil {
+				if err == nil {
+					t.Fatalf("want err for truncated msg %x", b[:i])
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("truncated msg %x: %v", b[:i], err)
+			}
+			if got.String() != want.String() || got.MsgHdr != want.MsgHdr {
+				t.Fatalf("truncated msg %x: want msg:\n%s\ngot:\n%s", b[:i], want, got)
+			}
+			ReleaseMsg(got)
+		}
+	}
+}
+
+func Test_UnpackQuestion(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeAAAA)
+	q.RecursionDesired = true
+	q.SetEdns0(1232, true)
+	b, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, off, err := UnpackQuestion(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseMsg(m)
+	if m.MsgHdr != q.MsgHdr || len(m.Question) != 1 || m.Question[0] != q.Question[0] || len(m.Extra) != 0 {
+		t.Fatalf("want header and question of:\n%s\ngot:\n%s", q, m)
+	}
+	if wantOff := 12 + len("example.com.") + 1 + 4; off != wantOff {
+		t.Fatalf("want off %d, got %d", wantOff, off)
+	}
+
+	if _, _, err := UnpackQuestion(b[:off-1]); err == nil {
+		t.Fatal("want err for truncated question")
+	}
+}
+
+func Test_ReleaseMsg(t *testing.T) {
+	m := GetMsg()
+	m.Id = 1
+	rr, _ := dns.NewRR("example.com. 300 IN A 1.2.3.4")
+	m.Answer = append(m.Answer, rr, rr)
+	m.Answer = m.Answer[:1]
+	ReleaseMsg(m)
+	if m.Id != 0 || len(m.Answer) != 0 {
+		t.Fatal("msg is not reset")
+	}
+	if m.Answer[:cap(m.Answer)][1] != nil {
+		t.Fatal("released msg still holds rr")
+	}
+	ReleaseMsg(nil)
+}
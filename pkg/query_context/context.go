@@ -20,9 +20,12 @@
 package query_context
 
 import (
+	"bytes"
+	"errors"
 	"sync/atomic"
 	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
@@ -33,6 +36,10 @@ const (
 	edns0Size = 1200
 )
 
+// ErrDropQuery can be returned by plugins to indicate that the query
+// should be dropped silently. Servers will not send any response.
+var ErrDropQuery = errors.New("query dropped")
+
 // Context is a query context that pass through plugins.
 // All Context funcs are not safe for concurrent use.
 type Context struct {
@@ -49,6 +56,10 @@ type Context struct {
 	respOpt     *dns.OPT // nil if clientOpt == nil
 	upstreamOpt *dns.OPT // may be nil
 
+	// Wire format passthrough. See NewWireContext.
+	rawQuery []byte  // not owned by the Context.
+	rawResp  *[]byte // from pool. nil if resp != nil.
+
 	// lazy init.
 	kv    map[uint32]any
 	marks map[uint32]struct{}
@@ -60,7 +71,8 @@ type ServerMeta = server.QueryMeta
 
 // NewContext creates a new query Context.
 // q must have one question.
-// NewContext takes the ownership of q.
+// NewContext takes the ownership of q. Msgs of a Context may be released
+// to the pool once the query is done. See pool.ReleaseMsg.
 func NewContext(q *dns.Msg) *Context {
 	ctx := &Context{
 		id:        contextUid.Add(1),
@@ -80,6 +92,52 @@ func NewContext(q *dns.Msg) *Context {
 	return ctx
 }
 
+// NewWireContext creates a new query Context for a query in wire format.
+// q is the header and the question of wire, e.g. from pool.UnpackQuestion.
+// Q() has no EDNS0 OPT and ClientOpt() is nil. So it is only for
+// pipelines that only read the question and forward the query as is.
+// See RawQuery.
+// NewWireContext takes the ownership of q. wire must be valid until the
+// query is done.
+func NewWireContext(q *dns.Msg, wire []byte) *Context {
+	return &Context{
+		id:        contextUid.Add(1),
+		startTime: time.Now(),
+		query:     q,
+		rawQuery:  wire,
+	}
+}
+
+// RawQuery returns the query in wire format if the Context was created by
+// NewWireContext. Otherwise, it returns nil. Like NewContext, the OPT from
+// the client has been replaced by a new one without options.
+// Plugins that forward queries can send it as is and set the response by
+// SetRawResponse. Caller MUST NOT modify it.
+func (ctx *Context) RawQuery() []byte {
+	return ctx.rawQuery
+}
+
+// SetRawResponse sets b as response in wire format. It takes the ownership
+// of b, which is from pool.GetBuf. It removes the existing response.
+// The response is relayed to the client with only its id, the RA bit and
+// its OPT patched.
+func (ctx *Context) SetRawResponse(b *[]byte) {
+	ctx.SetResponse(nil)
+	ctx.rawResp = b
+}
+
+// RawResponse returns the response set by SetRawResponse. It might be nil.
+// Caller MUST NOT keep it.
+func (ctx *Context) RawResponse() *[]byte {
+	return ctx.rawResp
+}
+
+// HasResponse reports whether the Context has a response, no matter
+// whether it is in wire format.
+func (ctx *Context) HasResponse() bool {
+	return ctx.resp != nil || ctx.rawResp != nil
+}
+
 // Id returns the Context id.
 // Note: This id is not the dns msg id.
 // It's a unique uint32 growing with the number of query.
@@ -125,7 +183,12 @@ func (ctx *Context) ClientOpt() *dns.OPT {
 
 // SetResponse sets m as response. It takes the ownership of m.
 // If m is nil. It removes existing response.
+// The replaced response is not released, caller may still use it.
 func (ctx *Context) SetResponse(m *dns.Msg) {
+	if ctx.rawResp != nil {
+		pool.ReleaseBuf(ctx.rawResp)
+		ctx.rawResp = nil
+	}
 	ctx.resp = m
 	if m == nil {
 		ctx.upstreamOpt = nil
@@ -189,6 +252,12 @@ func (ctx *Context) CopyTo(d *Context) *Context {
 	}
 	d.upstreamOpt = ctx.upstreamOpt
 
+	d.rawQuery = bytes.Clone(ctx.rawQuery)
+	d.rawResp = nil
+	if ctx.rawResp != nil {
+		d.rawResp, _ = pool.CopyBuffer(*ctx.rawResp)
+	}
+
 	d.kv = copyMap(ctx.kv)
 	d.marks = copyMap(ctx.marks)
 	return d
@@ -240,6 +309,9 @@ func (ctx *Context) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 	if clientAddr := ctx.ServerMeta.ClientAddr; clientAddr.IsValid() {
 		zap.Stringer("client", clientAddr).AddTo(encoder)
 	}
+	if clientID := ctx.ServerMeta.ClientID; len(clientID) > 0 {
+		encoder.AddString("client_id", clientID)
+	}
 
 	question := ctx.query.Question[0]
 	encoder.AddString("qname", question.Name)
@@ -248,6 +320,8 @@ func (ctx *Context) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 
 	if r := ctx.resp; r != nil {
 		encoder.AddInt("rcode", r.Rcode)
+	} else if r := ctx.rawResp; r != nil {
+		encoder.AddInt("rcode", int((*r)[3]&0xf))
 	}
 	encoder.AddDuration("elapsed", time.Since(ctx.startTime))
 	return nil
@@ -33,3 +33,7 @@ func RegKey() uint32 {
 	}
 	return i
 }
+
+// KeyUpstream is the key of the name (string) of the upstream that
+// answered the query. It is set by forward plugins.
+var KeyUpstream = RegKey()
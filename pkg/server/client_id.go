@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ClientIDFromPath returns the client id in the path suffix after base.
+// e.g. base "/dns-query", path "/dns-query/phone-abc" -> "phone-abc".
+// It returns an empty string if path has no valid id.
+func ClientIDFromPath(base, path string) string {
+	base = strings.TrimSuffix(base, "/")
+	s, ok := strings.CutPrefix(path, base+"/")
+	if !ok {
+		return ""
+	}
+	s = strings.TrimSuffix(s, "/")
+	if !validClientID(s) {
+		return ""
+	}
+	return s
+}
+
+// ClientIDFromServerName returns the leftmost label of serverName if
+// serverName is a direct subdomain of domain.
+// e.g. domain "dns.example.com", serverName "phone-abc.dns.example.com"
+// -> "phone-abc".
+func ClientIDFromServerName(domain, serverName string) string {
+	domain = strings.Trim(domain, ".")
+	serverName = strings.TrimSuffix(serverName, ".")
+	if len(domain) == 0 || len(serverName) <= len(domain)+1 {
+		return ""
+	}
+	suffix := serverName[len(serverName)-len(domain)-1:]
+	if suffix[0] != '.' || !strings.EqualFold(suffix[1:], domain) {
+		return ""
+	}
+	s := serverName[:len(serverName)-len(domain)-1]
+	if !validClientID(s) {
+		return ""
+	}
+	return strings.ToLower(s)
+}
+
+// validClientID checks that s is a single dns label that only contains
+// letters, digits and hyphens.
+func validClientID(s string) bool {
+	if len(s) == 0 || len(s) > 63 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ClientIDHandler sets QueryMeta.ClientID from the server name (SNI) before
+// passing queries to the next Handler. Ids that have been set (e.g. from
+// a DoH path) are kept.
+type ClientIDHandler struct {
+	Next   Handler
+	Domain string
+}
+
+var _ WireHandler = (*ClientIDHandler)(nil)
+
+func (h *ClientIDHandler) Handle(ctx context.Context, q *dns.Msg, meta QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	if len(meta.ClientID) == 0 && len(meta.ServerName) > 0 {
+		meta.ClientID = ClientIDFromServerName(h.Domain, meta.ServerName)
+	}
+	return h.Next.Handle(ctx, q, meta, packMsgPayload)
+}
+
+// HandleWire implements WireHandler. q is not handled if Next is not
+// a WireHandler.
+func (h *ClientIDHandler) HandleWire(
+	ctx context.Context,
+	q []byte,
+	meta QueryMeta,
+	packMsgPayload func(m *dns.Msg) (*[]byte, error),
+	copyPayload func(b []byte) (*[]byte, error),
+) (*[]byte, bool) {
+	next, ok := h.Next.(WireHandler)
+	if !ok {
+		return nil, false
+	}
+	if len(meta.ClientID) == 0 && len(meta.ServerName) > 0 {
+		meta.ClientID = ClientIDFromServerName(h.Domain, meta.ServerName)
+	}
+	return next.HandleWire(ctx, q, meta, packMsgPayload, copyPayload)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import "testing"
+
+func TestClientIDFromPath(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"/dns-query", "/dns-query/phone-abc", "phone-abc"},
+		{"/dns-query/", "/dns-query/phone-abc/", "phone-abc"},
+		{"/dns-query", "/dns-query", ""},
+		{"/dns-query", "/dns-queryx/abc", ""},
+		{"/dns-query", "/dns-query/a/b", ""},
+		{"/dns-query", "/dns-query/a_b", ""},
+	}
+	for _, tt := range tests {
+		if got := ClientIDFromPath(tt.base, tt.path); got != tt.want {
+			t.Errorf("ClientIDFromPath(%s, %s) = %s, want %s", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestClientIDFromServerName(t *testing.T) {
+	tests := []struct {
+		domain, sni, want string
+	}{
+		{"dns.example.com", "phone-abc.dns.example.com", "phone-abc"},
+		{"dns.example.com.", "Phone-ABC.DNS.example.com", "phone-abc"},
+		{"dns.example.com", "dns.example.com", ""},
+		{"dns.example.com", "a.b.dns.example.com", ""},
+		{"dns.example.com", "phone.xdns.example.com", ""},
+		{"", "phone.dns.example.com", ""},
+	}
+	for _, tt := range tests {
+		if got := ClientIDFromServerName(tt.domain, tt.sni); got != tt.want {
+			t.Errorf("ClientIDFromServerName(%s, %s) = %s, want %s", tt.domain, tt.sni, got, tt.want)
+		}
+	}
+}
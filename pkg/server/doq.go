@@ -100,17 +100,18 @@ func ServeDoQ(l *quic.Listener, h Handler, opts DoQServerOpts) error {
 					}()
 					// Avoid fragmentation attack.
 					stream.SetReadDeadline(time.Now().Add(streamReadTimeout))
-					req, _, err := dnsutils.ReadMsgFromTCP(stream)
+					req, err := dnsutils.ReadRawMsgFromTCP(stream)
 					if err != nil {
 						return
 					}
+					defer pool.ReleaseBuf(req)
 					queryMeta := QueryMeta{
 						ClientAddr: clientAddr,
 						ServerName: c.ConnectionState().TLS.ServerName,
 					}
 
-					resp := h.Handle(connCtx, req, queryMeta, pool.PackTCPBuffer)
-					if resp == nil {
+					resp, err := handleWire(connCtx, h, *req, queryMeta, pool.PackTCPBuffer, pool.CopyTCPBuffer)
+					if err != nil || resp == nil {
 						return
 					}
 					if _, err := stream.Write(*resp); err != nil {
@@ -38,15 +38,20 @@ type HttpHandlerOpts struct {
 	// e.g. "X-Forwarded-For".
 	GetSrcIPFromHeader string
 
+	// ClientIDPath enables parsing client ids from path suffixes after
+	// ClientIDPath. e.g. "/dns-query". Optional.
+	ClientIDPath string
+
 	// Logger specifies the logger which Handler writes its log to.
 	// Default is a nop logger.
 	Logger *zap.Logger
 }
 
 type HttpHandler struct {
-	dnsHandler  Handler
-	logger      *zap.Logger
-	srcIPHeader string
+	dnsHandler   Handler
+	logger       *zap.Logger
+	srcIPHeader  string
+	clientIDPath string
 }
 
 var _ http.Handler = (*HttpHandler)(nil)
@@ -55,6 +60,7 @@ func NewHttpHandler(h Handler, opts HttpHandlerOpts) *HttpHandler {
 	hh := new(HttpHandler)
 	hh.dnsHandler = h
 	hh.srcIPHeader = opts.GetSrcIPFromHeader
+	hh.clientIDPath = opts.ClientIDPath
 	hh.logger = opts.Logger
 	if hh.logger == nil {
 		hh.logger = nopLogger
@@ -87,7 +93,7 @@ func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// read msg
-	q, err := ReadMsgFromReq(req)
+	q, err := ReadRawMsgFromReq(req)
 	if err != nil {
 		h.warnErr(req, "invalid request", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -99,11 +105,20 @@ func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	if u := req.URL; u != nil {
 		queryMeta.UrlPath = u.Path
+		if len(h.clientIDPath) > 0 {
+			queryMeta.ClientID = ClientIDFromPath(h.clientIDPath, u.Path)
+		}
 	}
 	if tlsStat := req.TLS; tlsStat != nil {
 		queryMeta.ServerName = tlsStat.ServerName
 	}
-	resp := h.dnsHandler.Handle(req.Context(), q, queryMeta, pool.PackBuffer)
+	defer pool.ReleaseBuf(q)
+	resp, err := handleWire(req.Context(), h.dnsHandler, *q, queryMeta, pool.PackBuffer, pool.CopyBuffer)
+	if err != nil {
+		h.warnErr(req, "invalid request", fmt.Errorf("failed to unpack msg [%x], %w", *q, err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if resp == nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -128,6 +143,22 @@ var errInvalidMediaType = errors.New("missing or invalid media type header")
 var bufPool = pool.NewBytesBufPool(512)
 
 func ReadMsgFromReq(req *http.Request) (*dns.Msg, error) {
+	b, err := ReadRawMsgFromReq(req)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseBuf(b)
+
+	m, err := pool.UnpackMsg(*b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack msg [%x], %w", *b, err)
+	}
+	return m, nil
+}
+
+// ReadRawMsgFromReq reads the msg in wire format from req.
+// Callers should release the buf by calling pool.ReleaseBuf.
+func ReadRawMsgFromReq(req *http.Request) (*[]byte, error) {
 	var b []byte
 
 	switch req.Method {
@@ -169,9 +200,5 @@ func ReadMsgFromReq(req *http.Request) (*dns.Msg, error) {
 		return nil, fmt.Errorf("unsupported method: %s", req.Method)
 	}
 
-	m := new(dns.Msg)
-	if err := m.Unpack(b); err != nil {
-		return nil, fmt.Errorf("failed to unpack msg [%x], %w", b, err)
-	}
-	return m, nil
+	return pool.CopyBuffer(b)
 }
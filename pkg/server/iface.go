@@ -15,6 +15,8 @@ import (
 // tcp/dot: close the connection immediately.
 // doh: send a 500 response.
 // doq: close the stream immediately.
+// Handle takes the ownership of q, which is usually from pool.UnpackMsg.
+// Caller MUST NOT use q after Handle returns.
 type Handler interface {
 	Handle(ctx context.Context, q *dns.Msg, meta QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) (respPayload *[]byte)
 }
@@ -26,4 +28,24 @@ type QueryMeta struct {
 	ClientAddr netip.Addr
 	ServerName string
 	UrlPath    string
+	// ClientID identifies a device, parsed from the DoH path or the
+	// TLS server name. See ClientIDFromPath and ClientIDFromServerName.
+	ClientID string
+}
+
+// WireHandler is a Handler that can handle queries in wire format without
+// unpacking them, e.g. if it only forwards queries to upstreams.
+// q is only valid until HandleWire returns. Responses in wire format are
+// copied to the payload by copyPayload, others are packed by packMsgPayload.
+// If ok is false, q was not handled and caller should unpack it and call
+// Handle instead. Otherwise, respPayload is the same as the one from Handle.
+type WireHandler interface {
+	Handler
+	HandleWire(
+		ctx context.Context,
+		q []byte,
+		meta QueryMeta,
+		packMsgPayload func(m *dns.Msg) (*[]byte, error),
+		copyPayload func(b []byte) (*[]byte, error),
+	) (respPayload *[]byte, ok bool)
 }
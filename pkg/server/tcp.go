@@ -83,7 +83,7 @@ func ServeTCP(l net.Listener, h Handler, opts TCPServerOpts) error {
 				} else {
 					c.SetReadDeadline(time.Now().Add(idleTimeout))
 				}
-				req, _, err := dnsutils.ReadMsgFromTCP(c)
+				req, err := dnsutils.ReadRawMsgFromTCP(c)
 				if err != nil {
 					return // read err, close the connection
 				}
@@ -101,8 +101,9 @@ func ServeTCP(l net.Listener, h Handler, opts TCPServerOpts) error {
 					if ok {
 						clientAddr = ta.AddrPort().Addr()
 					}
-					r := h.Handle(tcpConnCtx, req, QueryMeta{ClientAddr: clientAddr, ServerName: serverName}, pool.PackTCPBuffer)
-					if r == nil {
+					defer pool.ReleaseBuf(req)
+					r, err := handleWire(tcpConnCtx, h, *req, QueryMeta{ClientAddr: clientAddr, ServerName: serverName}, pool.PackTCPBuffer, pool.CopyTCPBuffer)
+					if err != nil || r == nil {
 						c.Close() // abort the connection
 						return
 					}
@@ -21,8 +21,10 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/miekg/dns"
@@ -33,7 +35,8 @@ type UDPServerOpts struct {
 	Logger *zap.Logger
 }
 
-// ServeUDP starts a server at c. It returns if c had a read error.
+// ServeUDP starts a server at c. It returns if c had a read error,
+// including a read deadline exceeded.
 // It always returns a non-nil error.
 // h is required. logger is optional.
 func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
@@ -42,9 +45,6 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 		logger = nopLogger
 	}
 
-	listenerCtx, cancel := context.WithCancelCause(context.Background())
-	defer cancel(errListenerCtxCanceled)
-
 	rb := pool.GetBuf(dns.MaxMsgSize)
 	defer pool.ReleaseBuf(rb)
 
@@ -62,8 +62,9 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 	for {
 		n, oobn, _, remoteAddr, err := c.ReadMsgUDPAddrPort(*rb, ob)
 		if err != nil {
-			if n == 0 {
-				// Err with zero read. Most likely because c was closed.
+			if n == 0 || errors.Is(err, os.ErrDeadlineExceeded) {
+				// Err with zero read. Most likely because c was closed,
+				// or the server is stopping by a read deadline.
 				return fmt.Errorf("unexpected read err: %w", err)
 			}
 			// Temporary err.
@@ -71,11 +72,7 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 			continue
 		}
 
-		q := new(dns.Msg)
-		if err := q.Unpack((*rb)[:n]); err != nil {
-			logger.Warn("invalid msg", zap.Error(err), zap.Binary("msg", (*rb)[:n]), zap.Stringer("from", remoteAddr))
-			continue
-		}
+		q, _ := pool.CopyBuffer((*rb)[:n])
 
 		var dstIpFromCm net.IP
 		if oobReader != nil {
@@ -88,7 +85,14 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 
 		// handle query
 		go func() {
-			payload := h.Handle(listenerCtx, q, QueryMeta{ClientAddr: remoteAddr.Addr(), FromUDP: true}, pool.PackBuffer)
+			defer pool.ReleaseBuf(q)
+			// There is no connection in udp. Queries are only limited
+			// by their own deadline.
+			payload, err := handleWire(context.Background(), h, *q, QueryMeta{ClientAddr: remoteAddr.Addr(), FromUDP: true}, pool.PackBuffer, pool.CopyBuffer)
+			if err != nil {
+				logger.Warn("invalid msg", zap.Error(err), zap.Binary("msg", *q), zap.Stringer("from", remoteAddr))
+				return
+			}
 			if payload == nil {
 				return
 			}
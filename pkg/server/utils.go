@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"errors"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/miekg/dns"
 	"go.uber.org/zap"
 )
 
@@ -14,3 +17,47 @@ var (
 var (
 	nopLogger = zap.NewNop()
 )
+
+// WithoutServerCancel returns a copy of ctx that is not canceled when the
+// server of ctx is closing, so queries in flight can be drained. But it is
+// still canceled if the client connection of ctx is gone, e.g. the tcp
+// connection was closed, so abandoned queries are aborted.
+// The returned cancel func must be called to release resources.
+func WithoutServerCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Done() == nil {
+		return ctx, func() {}
+	}
+	qCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if cause := context.Cause(ctx); !errors.Is(cause, errListenerCtxCanceled) {
+			cancel(cause)
+		}
+	})
+	return qCtx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// handleWire handles query q in wire format by h. If h is not a WireHandler
+// or h did not handle q, q is unpacked and handled by h.Handle.
+// It returns an error if q is invalid.
+func handleWire(
+	ctx context.Context,
+	h Handler,
+	q []byte,
+	meta QueryMeta,
+	packMsgPayload func(m *dns.Msg) (*[]byte, error),
+	copyPayload func(b []byte) (*[]byte, error),
+) (*[]byte, error) {
+	if wh, ok := h.(WireHandler); ok {
+		if resp, ok := wh.HandleWire(ctx, q, meta, packMsgPayload, copyPayload); ok {
+			return resp, nil
+		}
+	}
+	m, err := pool.UnpackMsg(q)
+	if err != nil {
+		return nil, err
+	}
+	return h.Handle(ctx, m, meta, packMsgPayload), nil
+}
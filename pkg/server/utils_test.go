@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithoutServerCancel(t *testing.T) {
+	listenerCtx, cancelListener := context.WithCancelCause(context.Background())
+	connCtx, cancelConn := context.WithCancelCause(listenerCtx)
+
+	ctx, cancel := WithoutServerCancel(connCtx)
+	defer cancel()
+	cancelListener(errListenerCtxCanceled)
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("ctx should not be canceled by the listener, got %v", err)
+	}
+
+	connCtx2, cancelConn2 := context.WithCancelCause(context.Background())
+	ctx2, cancel2 := WithoutServerCancel(connCtx2)
+	defer cancel2()
+	cancelConn2(errConnectionCtxCanceled)
+	<-ctx2.Done()
+	if cause := context.Cause(ctx2); !errors.Is(cause, errConnectionCtxCanceled) {
+		t.Fatalf("want cause %v, got %v", errConnectionCtxCanceled, cause)
+	}
+	cancelConn(errConnectionCtxCanceled)
+}
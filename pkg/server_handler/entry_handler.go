@@ -21,19 +21,25 @@ package server_handler
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/tracing"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
 const (
 	defaultQueryTimeout = time.Second * 5
+	dnsHeaderLen        = 12
 )
 
 var (
@@ -60,6 +66,10 @@ type EntryHandlerOpts struct {
 	// QueryTimeout limits the timeout value of each query.
 	// Default is defaultQueryTimeout.
 	QueryTimeout time.Duration
+
+	// Passthrough enables handling queries in wire format, see HandleWire.
+	// Entry must be wire safe, see sequence.IsWireSafe.
+	Passthrough bool
 }
 
 func (opts *EntryHandlerOpts) init() {
@@ -73,7 +83,7 @@ type EntryHandler struct {
 	opts EntryHandlerOpts
 }
 
-var _ server.Handler = (*EntryHandler)(nil)
+var _ server.WireHandler = (*EntryHandler)(nil)
 
 func NewEntryHandler(opts EntryHandlerOpts) *EntryHandler {
 	opts.init()
@@ -83,24 +93,166 @@ func NewEntryHandler(opts EntryHandlerOpts) *EntryHandler {
 // ServeDNS implements server.Handler.
 // If entry returns an error, a SERVFAIL response will be returned.
 // If entry returns without a response, a REFUSED response will be returned.
+// The query and the response of the query context are released to the pool
+// after the response is packed. Plugins that need them after entry returns
+// must keep a copy. See pool.ReleaseMsg.
 func (h *EntryHandler) Handle(ctx context.Context, q *dns.Msg, serverMeta server.QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
 	// basic query check.
 	if q.Response || len(q.Question) != 1 || len(q.Answer)+len(q.Ns) > 0 || len(q.Extra) > 1 {
+		pool.ReleaseMsg(q)
+		return nil
+	}
+
+	qCtx := query_context.NewContext(q)
+	qCtx.ServerMeta = serverMeta
+	// Deferred first, so it runs after everything else.
+	defer releaseContext(qCtx)
+
+	err := h.exec(ctx, qCtx)
+	if h.dropped(qCtx, err) {
 		return nil
 	}
+	return h.packResp(qCtx, err != nil, packMsgPayload)
+}
+
+// HandleWire implements server.WireHandler. Queries are only handled in
+// wire format if EntryHandlerOpts.Passthrough is set. Like Handle, EDNS0
+// options are not forwarded. The OPT of the query is replaced before it is
+// sent to upstreams, and the OPT of the response is replaced before it is
+// relayed to the client. Otherwise, responses in wire format are relayed
+// with only their id and the RA bit patched, unless they need to be
+// truncated.
+func (h *EntryHandler) HandleWire(
+	ctx context.Context,
+	wire []byte,
+	serverMeta server.QueryMeta,
+	packMsgPayload func(m *dns.Msg) (*[]byte, error),
+	copyPayload func(b []byte) (*[]byte, error),
+) (*[]byte, bool) {
+	if !h.opts.Passthrough || !isBasicWireQuery(wire) {
+		return nil, false
+	}
+	q, off, err := pool.UnpackQuestion(wire)
+	if err != nil {
+		return nil, false
+	}
+	clientOpt, ok := parseWireOpt(wire, off)
+	if !ok {
+		pool.ReleaseMsg(q)
+		return nil, false
+	}
+	nq := normalizeWireQuery(wire, off)
+	defer pool.ReleaseBuf(nq)
+
+	qCtx := query_context.NewWireContext(q, *nq)
+	qCtx.ServerMeta = serverMeta
+	defer releaseContext(qCtx)
+
+	err = h.exec(ctx, qCtx)
+	if h.dropped(qCtx, err) {
+		return nil, true
+	}
+
+	raw := qCtx.RawResponse()
+	if err == nil && raw != nil {
+		nr, nerr := normalizeWireResp(*raw, clientOpt)
+		if nerr != nil {
+			h.opts.Logger.Warn("invalid resp msg", qCtx.InfoField(), zap.Error(nerr))
+			err = nerr
+		} else {
+			qCtx.SetRawResponse(nr)
+			raw = nr
+		}
+	}
+	if err != nil || raw == nil {
+		// Responses that were not from upstreams (e.g. errors and
+		// rejections) need the full query for the EDNS0 options.
+		fq, uerr := pool.UnpackMsg(wire)
+		if uerr != nil {
+			h.opts.Logger.Warn("invalid msg", qCtx.InfoField(), zap.Error(uerr))
+			return nil, true
+		}
+		fqCtx := query_context.NewContext(fq)
+		fqCtx.ServerMeta = serverMeta
+		defer releaseContext(fqCtx)
+		if r := qCtx.R(); r != nil {
+			qCtx.SetResponse(nil)
+			fqCtx.SetResponse(r)
+		}
+		return h.packResp(fqCtx, err != nil, packMsgPayload), true
+	}
 
+	b := *raw
+	b[0], b[1] = wire[0], wire[1]
+	b[3] |= 0x80 // We assume that our server is a forwarder.
+	if serverMeta.FromUDP && len(b) > dns.MinMsgSize {
+		if udpSize := clientOpt.udpSize; len(b) > udpSize {
+			r, err := pool.UnpackMsg(b)
+			if err != nil {
+				h.opts.Logger.Error("internal err: failed to unpack resp msg", qCtx.InfoField(), zap.Error(err))
+				return nil, true
+			}
+			defer pool.ReleaseMsg(r)
+			r.Truncate(udpSize)
+			return h.pack(qCtx, r, packMsgPayload), true
+		}
+	}
+	payload, err := copyPayload(b)
+	if err != nil {
+		h.opts.Logger.Error("internal err: failed to copy resp msg", qCtx.InfoField(), zap.Error(err))
+		return nil, true
+	}
+	return payload, true
+}
+
+// exec executes the entry within the query timeout.
+func (h *EntryHandler) exec(ctx context.Context, qCtx *query_context.Context) (err error) {
 	ddl := time.Now().Add(h.opts.QueryTimeout)
 	ctx, cancel := context.WithDeadline(ctx, ddl)
 	defer cancel()
 
-	qCtx := query_context.NewContext(q)
-	qCtx.ServerMeta = serverMeta
+	ctx, span := tracing.Start(ctx, "dns.query", func() []attribute.KeyValue {
+		question := qCtx.QQuestion()
+		return []attribute.KeyValue{
+			attribute.Int64("dns.qid", int64(qCtx.Id())),
+			attribute.String("dns.qname", question.Name),
+			attribute.String("dns.qtype", dns.TypeToString[question.Qtype]),
+			attribute.String("dns.qclass", dns.ClassToString[question.Qclass]),
+			attribute.String("client.address", qCtx.ServerMeta.ClientAddr.String()),
+		}
+	})
+	defer func() {
+		if span.IsRecording() {
+			if r := qCtx.R(); r != nil {
+				span.SetAttributes(attribute.String("dns.rcode", dns.RcodeToString[r.Rcode]))
+			} else if raw := qCtx.RawResponse(); raw != nil {
+				span.SetAttributes(attribute.String("dns.rcode", dns.RcodeToString[int((*raw)[3]&0xf)]))
+			}
+		}
+		tracing.End(span, err)
+	}()
+	return h.opts.Entry.Exec(ctx, qCtx)
+}
 
-	// exec entry
-	err := h.opts.Entry.Exec(ctx, qCtx)
-	var resp *dns.Msg
+// dropped logs err from the entry. It reports whether the query should
+// be dropped.
+func (h *EntryHandler) dropped(qCtx *query_context.Context, err error) bool {
+	if errors.Is(err, query_context.ErrDropQuery) {
+		h.opts.Logger.Debug("query dropped", qCtx.InfoField())
+		return true
+	}
 	if err != nil {
 		h.opts.Logger.Warn("entry err", qCtx.InfoField(), zap.Error(err))
+	}
+	return false
+}
+
+// packResp packs the response of qCtx. If failed, or qCtx has no response,
+// an error response is packed instead.
+func (h *EntryHandler) packResp(qCtx *query_context.Context, failed bool, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	q := qCtx.Q()
+	var resp *dns.Msg
+	if failed {
 		resp = new(dns.Msg)
 		resp.SetReply(q)
 		resp.Rcode = dns.RcodeServerFailure
@@ -121,11 +273,14 @@ func (h *EntryHandler) Handle(ctx context.Context, q *dns.Msg, serverMeta server
 		resp.Extra = append(resp.Extra, respOpt)
 	}
 
-	if serverMeta.FromUDP {
+	if qCtx.ServerMeta.FromUDP {
 		udpSize := getValidUDPSize(qCtx.ClientOpt())
 		resp.Truncate(udpSize)
 	}
+	return h.pack(qCtx, resp, packMsgPayload)
+}
 
+func (h *EntryHandler) pack(qCtx *query_context.Context, resp *dns.Msg, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
 	payload, err := packMsgPayload(resp)
 	if err != nil {
 		h.opts.Logger.Error("internal err: failed to pack resp msg", qCtx.InfoField(), zap.Error(err))
@@ -134,6 +289,26 @@ func (h *EntryHandler) Handle(ctx context.Context, q *dns.Msg, serverMeta server
 	return payload
 }
 
+// releaseContext releases the query and the response of qCtx to the pool.
+func releaseContext(qCtx *query_context.Context) {
+	pool.ReleaseMsg(qCtx.R())
+	qCtx.SetResponse(nil) // Releases the response in wire format.
+	pool.ReleaseMsg(qCtx.Q())
+}
+
+// isBasicWireQuery does the basic query check of Handle on the header of
+// query b in wire format.
+func isBasicWireQuery(b []byte) bool {
+	if len(b) < dnsHeaderLen || b[2]&0x80 != 0 { // QR bit
+		return false
+	}
+	qd := binary.BigEndian.Uint16(b[4:])
+	an := binary.BigEndian.Uint16(b[6:])
+	ns := binary.BigEndian.Uint16(b[8:])
+	ar := binary.BigEndian.Uint16(b[10:])
+	return qd == 1 && an == 0 && ns == 0 && ar <= 1
+}
+
 // opt can be nil.
 func getValidUDPSize(opt *dns.OPT) int {
 	var s uint16
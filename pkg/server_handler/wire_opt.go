@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_handler
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/miekg/dns"
+)
+
+const (
+	// wireOptLen is the length of an OPT record without options.
+	wireOptLen = 11
+	// wireOptUDPSize is the udp size of the OPT sent to upstreams. It is
+	// the same as the OPT of query_context.NewContext.
+	wireOptUDPSize = 1200
+)
+
+var errBadWireMsg = errors.New("bad msg")
+
+// wireOpt is the EDNS0 OPT of a query in wire format.
+type wireOpt struct {
+	present bool // the query has an OPT.
+	udpSize int  // valid udp size, see getValidUDPSize.
+	do      bool
+}
+
+// parseWireOpt parses the OPT of query b in wire format, which is the only
+// record after the question. off is the end of the question.
+// ok is false if the record is not an OPT.
+func parseWireOpt(b []byte, off int) (opt wireOpt, ok bool) {
+	opt.udpSize = dns.MinMsgSize
+	if binary.BigEndian.Uint16(b[10:]) == 0 {
+		return opt, true
+	}
+	// An OPT record has a root owner name, a type, a udp size (the class),
+	// an extended rcode, a version, flags and options.
+	if len(b) < off+wireOptLen || b[off] != 0 || binary.BigEndian.Uint16(b[off+1:]) != dns.TypeOPT {
+		return opt, false
+	}
+	opt.present = true
+	if s := int(binary.BigEndian.Uint16(b[off+3:])); s > dns.MinMsgSize {
+		opt.udpSize = s
+	}
+	opt.do = b[off+7]&0x80 != 0
+	return opt, true
+}
+
+// putWireOpt writes an OPT record without options into b.
+func putWireOpt(b []byte, udpSize uint16, extRcode uint8, do bool) {
+	b[0] = 0 // root
+	binary.BigEndian.PutUint16(b[1:], dns.TypeOPT)
+	binary.BigEndian.PutUint16(b[3:], udpSize)
+	b[5], b[6], b[7], b[8] = extRcode, 0, 0, 0
+	if do {
+		b[7] = 0x80
+	}
+	binary.BigEndian.PutUint16(b[9:], 0)
+}
+
+// normalizeWireQuery returns the query that is sent to upstreams for query
+// b in wire format. Like query_context.NewContext, the OPT from the client
+// is replaced by a new one without options. off is the end of the question.
+// The returned buffer is from the pool.
+func normalizeWireQuery(b []byte, off int) *[]byte {
+	buf := pool.GetBuf(off + wireOptLen)
+	nb := *buf
+	copy(nb, b[:off])
+	binary.BigEndian.PutUint16(nb[10:], 1)
+	putWireOpt(nb[off:], wireOptUDPSize, 0, false)
+	return buf
+}
+
+// normalizeWireResp returns response b in wire format with its OPT
+// replaced, like the response of EntryHandler.Handle. Options from the
+// upstream are removed. The response has an OPT with the DO bit of the
+// client only if the client sent an OPT. The returned buffer is from
+// the pool.
+func normalizeWireResp(b []byte, client wireOpt) (*[]byte, error) {
+	if len(b) < dnsHeaderLen {
+		return nil, errBadWireMsg
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	ar := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := dnsHeaderLen
+	var err error
+	for i := 0; i < qd; i++ {
+		if off, err = skipWireName(b, off); err != nil {
+			return nil, err
+		}
+		if off += 4; off > len(b) {
+			return nil, errBadWireMsg
+		}
+	}
+	for i := 0; i < an+ns; i++ {
+		if off, _, err = skipWireRR(b, off); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := pool.GetBuf(len(b) + wireOptLen)
+	nb := *buf
+	n := copy(nb, b[:off])
+	nar := 0
+	var extRcode uint8
+	for i := 0; i < ar; i++ {
+		start := off
+		var typ uint16
+		if off, typ, err = skipWireRR(b, off); err != nil {
+			pool.ReleaseBuf(buf)
+			return nil, err
+		}
+		if typ == dns.TypeOPT {
+			nameEnd, _ := skipWireName(b, start)
+			extRcode = b[nameEnd+4] // the first byte of ttl.
+			continue
+		}
+		n += copy(nb[n:], b[start:off])
+		nar++
+	}
+	if client.present {
+		putWireOpt(nb[n:], wireOptUDPSize, extRcode, client.do)
+		n += wireOptLen
+		nar++
+	}
+	binary.BigEndian.PutUint16(nb[10:], uint16(nar))
+	*buf = nb[:n]
+	return buf, nil
+}
+
+// skipWireName returns the end of the domain name at off.
+func skipWireName(b []byte, off int) (int, error) {
+	for {
+		if off >= len(b) {
+			return 0, errBadWireMsg
+		}
+		c := int(b[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				return off + 1, nil
+			}
+			off += 1 + c
+		case 0xc0: // pointer
+			if off+2 > len(b) {
+				return 0, errBadWireMsg
+			}
+			return off + 2, nil
+		default:
+			return 0, errBadWireMsg
+		}
+	}
+}
+
+// skipWireRR returns the end and the type of the resource record at off.
+func skipWireRR(b []byte, off int) (int, uint16, error) {
+	off, err := skipWireName(b, off)
+	if err != nil {
+		return 0, 0, err
+	}
+	// type, class, ttl and rdlength.
+	if off+10 > len(b) {
+		return 0, 0, errBadWireMsg
+	}
+	typ := binary.BigEndian.Uint16(b[off:])
+	end := off + 10 + int(binary.BigEndian.Uint16(b[off+8:]))
+	if end > len(b) {
+		return 0, 0, errBadWireMsg
+	}
+	return end, typ, nil
+}
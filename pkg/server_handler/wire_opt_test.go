@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_handler
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/miekg/dns"
+)
+
+// testUpstream answers every query with an A record and an OPT that has
+// a cookie and padding. It records the query it received.
+type testUpstream struct {
+	q *dns.Msg
+}
+
+func (u *testUpstream) response(q *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+	r.SetEdns0(4096, true)
+	r.IsEdns0().Option = append(r.IsEdns0().Option,
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708abcdefabcdefabcd"},
+		&dns.EDNS0_PADDING{Padding: make([]byte, 16)},
+	)
+	return r
+}
+
+func (u *testUpstream) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if raw := qCtx.RawQuery(); raw != nil {
+		u.q = new(dns.Msg)
+		if err := u.q.Unpack(raw); err != nil {
+			return err
+		}
+		b, err := pool.PackBuffer(u.response(u.q))
+		if err != nil {
+			return err
+		}
+		qCtx.SetRawResponse(b)
+		return nil
+	}
+	u.q = qCtx.Q().Copy()
+	r := u.response(u.q)
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestEntryHandler_HandleWire_opt(t *testing.T) {
+	newQuery := func(edns bool) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		if edns {
+			q.SetEdns0(1232, true)
+			q.IsEdns0().Option = append(q.IsEdns0().Option,
+				&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+				&dns.EDNS0_PADDING{Padding: make([]byte, 8)},
+			)
+		}
+		return q
+	}
+	pack := func(m *dns.Msg) (*[]byte, error) { return pool.PackBuffer(m) }
+	meta := server.QueryMeta{FromUDP: true}
+
+	for _, edns := range []bool{false, true} {
+		u := new(testUpstream)
+		h := NewEntryHandler(EntryHandlerOpts{Entry: u, Passthrough: true})
+
+		q := newQuery(edns)
+		wire, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, ok := h.HandleWire(context.Background(), wire, meta, pack, pool.CopyBuffer)
+		if !ok || b == nil {
+			t.Fatalf("edns %v: query should be handled in wire format", edns)
+		}
+		wireUpstreamQ := u.q
+		wireResp := new(dns.Msg)
+		if err := wireResp.Unpack(*b); err != nil {
+			t.Fatal(err)
+		}
+
+		b = h.Handle(context.Background(), newQuery(edns), meta, pack)
+		if b == nil {
+			t.Fatalf("edns %v: no response", edns)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(*b); err != nil {
+			t.Fatal(err)
+		}
+
+		wireUpstreamQ.Id, u.q.Id = 0, 0
+		wireResp.Id, resp.Id = 0, 0
+		if wireUpstreamQ.String() != u.q.String() {
+			t.Fatalf("edns %v: upstream queries differ, wire:\n%s\nmsg:\n%s", edns, wireUpstreamQ, u.q)
+		}
+		if wireResp.String() != resp.String() {
+			t.Fatalf("edns %v: responses differ, wire:\n%s\nmsg:\n%s", edns, wireResp, resp)
+		}
+	}
+}
+
+func Test_normalizeWireResp_invalid(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	r.SetEdns0(1232, false)
+	b, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := dnsHeaderLen; i < len(b); i++ {
+		if nb, err := normalizeWireResp(b[:i], wireOpt{present: true}); err == nil {
+			pool.ReleaseBuf(nb)
+			t.Fatalf("want err for truncated msg %x", b[:i])
+		}
+	}
+}
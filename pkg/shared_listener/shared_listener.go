@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package shared_listener creates listeners that can be taken over by
+// another listener in the same process.
+//
+// When mosdns reloads its config, the new servers are started before the old
+// ones are closed. Listening on the same address would fail with "address
+// already in use". Instead, the new listener shares the socket of the old
+// one, so no query is refused while the old servers are draining.
+package shared_listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+var registry = struct {
+	sync.Mutex
+	m map[string][]filer // key: network/addr
+}{m: make(map[string][]filer)}
+
+func key(network, addr string) string {
+	return network + "/" + addr
+}
+
+// shareable reports whether sockets of network/addr can be shared.
+// Unix sockets are excluded because closing a unix listener removes
+// the socket file. Random ports are excluded because two listeners on
+// port 0 are not the same address.
+func shareable(network, addr string) bool {
+	if !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+		return false
+	}
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != "0" && port != ""
+}
+
+// dup duplicates the socket of a registered listener of network/addr.
+// It returns nil if no such listener exists or the socket cannot be
+// duplicated, e.g. on windows.
+func dup(network, addr string) *os.File {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, l := range registry.m[key(network, addr)] {
+		f, err := l.File()
+		if err == nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func register(network, addr string, l filer) {
+	registry.Lock()
+	defer registry.Unlock()
+	k := key(network, addr)
+	registry.m[k] = append(registry.m[k], l)
+}
+
+// Listen is like lc.Listen but reuses the socket of an open listener
+// created by Listen with the same network and addr.
+func Listen(lc *net.ListenConfig, network, addr string) (net.Listener, error) {
+	if !shareable(network, addr) {
+		return lc.Listen(context.Background(), network, addr)
+	}
+
+	if f := dup(network, addr); f != nil {
+		defer f.Close()
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to share listener, %w", err)
+		}
+		register(network, addr, l.(filer))
+		return l, nil
+	}
+
+	l, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if fl, ok := l.(filer); ok {
+		register(network, addr, fl)
+	}
+	return l, nil
+}
+
+// ListenUDP is like lc.ListenPacket but reuses the socket of an open conn
+// created by ListenUDP with the same network and addr.
+func ListenUDP(lc *net.ListenConfig, network, addr string) (*net.UDPConn, error) {
+	var (
+		c   net.PacketConn
+		f   *os.File
+		err error
+	)
+	if shareable(network, addr) {
+		f = dup(network, addr)
+	}
+	if f != nil {
+		defer f.Close()
+		c, err = net.FilePacketConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to share socket, %w", err)
+		}
+	} else {
+		c, err = lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("socket of %s is a %T, not an udp socket", network, c)
+	}
+	if shareable(network, addr) {
+		register(network, addr, uc)
+	}
+	return uc, nil
+}
+
+// Release stops sharing the socket of l. It should be called before l is
+// closed. l is a listener or conn returned by Listen or ListenUDP.
+func Release(l any) {
+	registry.Lock()
+	defer registry.Unlock()
+	for k, ls := range registry.m {
+		for i, e := range ls {
+			if e == l {
+				ls = append(ls[:i], ls[i+1:]...)
+				if len(ls) == 0 {
+					delete(registry.m, k)
+				} else {
+					registry.m[k] = ls
+				}
+				return
+			}
+		}
+	}
+}
+
+// IsListening reports whether addr is served by an open listener or conn
+// created by Listen or ListenUDP, e.g. a query sent to addr would be
+// received by this process. Listeners on an unspecified address serve
+// all local addresses of their port.
+func IsListening(addr netip.AddrPort) bool {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap().WithZone(""), addr.Port())
+	wildcard := false
+	registry.Lock()
+	for _, ls := range registry.m {
+		for _, l := range ls {
+			la, ok := localAddr(l)
+			if !ok || la.Port() != addr.Port() {
+				continue
+			}
+			if la.Addr() == addr.Addr() {
+				registry.Unlock()
+				return true
+			}
+			wildcard = wildcard || la.Addr().IsUnspecified()
+		}
+	}
+	registry.Unlock()
+
+	if !wildcard {
+		return false
+	}
+	if addr.Addr().IsLoopback() || addr.Addr().IsUnspecified() {
+		return true
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range ifAddrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(n.IP); ok && ip.Unmap() == addr.Addr() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func localAddr(l filer) (netip.AddrPort, bool) {
+	var a net.Addr
+	switch l := l.(type) {
+	case net.Listener:
+		a = l.Addr()
+	case net.PacketConn:
+		a = l.LocalAddr()
+	default:
+		return netip.AddrPort{}, false
+	}
+	var ap netip.AddrPort
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	default:
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap().WithZone(""), ap.Port()), true
+}
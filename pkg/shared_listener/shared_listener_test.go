@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package shared_listener
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func Test_Listen_share(t *testing.T) {
+	addr := net.JoinHostPort("127.0.0.1", freePort(t))
+	lc := new(net.ListenConfig)
+
+	l1, err := Listen(lc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := Listen(lc, "tcp", addr)
+	if err != nil {
+		t.Fatalf("second listener should share the socket, %v", err)
+	}
+	defer func() {
+		Release(l2)
+		l2.Close()
+	}()
+
+	// The socket is still open after the first listener is closed.
+	Release(l1)
+	if err := l1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = l2.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second))
+	ac, err := l2.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.Close()
+
+	// Released listeners are not shared.
+	Release(l2)
+	l2.Close()
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Fatal("socket should be closed")
+	}
+}
+
+func Test_ListenUDP_share(t *testing.T) {
+	lc := new(net.ListenConfig)
+	c1, err := ListenUDP(lc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	addr := c1.LocalAddr().String()
+
+	// Random ports are not shared.
+	c0, err := ListenUDP(lc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c0.Close()
+	if c0.LocalAddr().String() == addr {
+		t.Fatal("random port should not be shared")
+	}
+
+	Release(c1)
+	c1.Close()
+	c1, err = ListenUDP(lc, "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := ListenUDP(lc, "udp", addr)
+	if err != nil {
+		t.Fatalf("second conn should share the socket, %v", err)
+	}
+	defer c2.Close()
+	Release(c1)
+	c1.Close()
+
+	if _, err := c2.WriteTo([]byte("ping"), c2.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	_ = c2.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 8)
+	n, _, err := c2.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "ping" {
+		t.Fatalf("want ping, got %q", b[:n])
+	}
+}
+
+func Test_IsListening(t *testing.T) {
+	port := freePort(t)
+	l, err := Listen(new(net.ListenConfig), "tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		Release(l)
+		l.Close()
+	}()
+	u, err := ListenUDP(new(net.ListenConfig), "udp", net.JoinHostPort("", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		Release(u)
+		u.Close()
+	}()
+
+	p, _ := strconv.Atoi(port)
+	addr := func(ip string, port int) netip.AddrPort {
+		return netip.AddrPortFrom(netip.MustParseAddr(ip), uint16(port))
+	}
+	tests := []struct {
+		addr netip.AddrPort
+		want bool
+	}{
+		{addr("127.0.0.1", p), true},
+		{addr("::ffff:127.0.0.1", p), true},
+		{addr("127.0.0.2", p), true}, // served by the wildcard udp socket
+		{addr("::1", p), true},
+		{addr("192.0.2.1", p), false}, // not a local address
+		{addr("127.0.0.1", p+1), false},
+	}
+	for _, tt := range tests {
+		if got := IsListening(tt.addr); got != tt.want {
+			t.Errorf("IsListening(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	Release(u)
+	if IsListening(addr("127.0.0.2", p)) {
+		t.Fatal("released conn should not be listening")
+	}
+}
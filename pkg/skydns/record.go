@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package skydns parses SkyDNS-style records stored in key-value stores
+// like etcd and Consul. A record for "www.example.com" is stored under
+// key "<prefix>/com/example/www" with a json value like
+// {"host":"192.0.2.1","ttl":60}.
+package skydns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Record is a SkyDNS service record.
+type Record struct {
+	Host     string `json:"host,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Mail     bool   `json:"mail,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+
+	// Key is the fqdn of the key that this record is stored under.
+	Key string `json:"-"`
+}
+
+// Addr returns the ip address in Host. ok is false if Host is not an ip.
+func (r *Record) Addr() (addr netip.Addr, ok bool) {
+	addr, err := netip.ParseAddr(r.Host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// KeyToFqdn converts a key under prefix to a fqdn in lower case.
+// e.g. "/skydns/com/example/www" with prefix "/skydns" is "www.example.com.".
+func KeyToFqdn(prefix, key string) (string, error) {
+	p := strings.Trim(prefix, "/")
+	k := strings.Trim(key, "/")
+	if len(p) > 0 {
+		var ok bool
+		k, ok = strings.CutPrefix(k, p)
+		if !ok || (len(k) > 0 && k[0] != '/') {
+			return "", fmt.Errorf("key %s is not under prefix %s", key, prefix)
+		}
+		k = strings.TrimPrefix(k, "/")
+	}
+	if len(k) == 0 {
+		return "", fmt.Errorf("empty key %s", key)
+	}
+	labels := strings.Split(k, "/")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	fqdn := strings.ToLower(dns.Fqdn(strings.Join(labels, ".")))
+	if _, ok := dns.IsDomainName(fqdn); !ok {
+		return "", fmt.Errorf("key %s is not a valid domain name", key)
+	}
+	return fqdn, nil
+}
+
+// ParseRecord parses the json value of a key.
+func ParseRecord(prefix, key string, value []byte) (*Record, error) {
+	fqdn, err := KeyToFqdn(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Record)
+	if err := json.Unmarshal(value, r); err != nil {
+		return nil, fmt.Errorf("invalid value of key %s, %w", key, err)
+	}
+	r.Key = fqdn
+	return r, nil
+}
+
+// Table is a read-only index of records. It is safe for concurrent use.
+type Table struct {
+	names map[string][]*Record // fqdn -> records of the fqdn and its subdomains
+}
+
+// NewTable builds a Table from records. Like SkyDNS, a record is also
+// returned for queries of its parent domains, so services can be
+// registered as "<prefix>/local/svc/1", "<prefix>/local/svc/2", and be
+// queried as "svc.local".
+func NewTable(records []*Record) *Table {
+	t := &Table{names: make(map[string][]*Record)}
+	for _, r := range records {
+		name := r.Key
+		for {
+			t.names[name] = append(t.names[name], r)
+			off, end := dns.NextLabel(name, 0)
+			if end || name[off:] == "" {
+				break
+			}
+			name = name[off:]
+			if name == "." {
+				break
+			}
+		}
+	}
+	return t
+}
+
+// Lookup returns records of fqdn. ok is false if fqdn does not exist.
+func (t *Table) Lookup(fqdn string) (records []*Record, ok bool) {
+	records, ok = t.names[strings.ToLower(fqdn)]
+	return records, ok
+}
+
+// Len returns the number of names in the Table.
+func (t *Table) Len() int {
+	return len(t.names)
+}
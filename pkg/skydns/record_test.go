@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package skydns
+
+import (
+	"testing"
+)
+
+func TestKeyToFqdn(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"/skydns", "/skydns/com/example/www", "www.example.com.", false},
+		{"skydns/", "skydns/com/Example", "example.com.", false},
+		{"", "/com/example", "example.com.", false},
+		{"/skydns", "/skydns", "", true},
+		{"/skydns", "/skydnsx/com", "", true},
+		{"/skydns", "/other/com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := KeyToFqdn(tt.prefix, tt.key)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("KeyToFqdn(%s, %s) = %s, %v, want %s, wantErr %v", tt.prefix, tt.key, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	var records []*Record
+	for _, kv := range [][2]string{
+		{"/skydns/local/svc/1", `{"host":"192.0.2.1","port":80}`},
+		{"/skydns/local/svc/2", `{"host":"2001:db8::1","port":80}`},
+		{"/skydns/local/web", `{"host":"svc.local"}`},
+	} {
+		r, err := ParseRecord("/skydns", kv[0], []byte(kv[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	table := NewTable(records)
+
+	if rs, ok := table.Lookup("1.SVC.local."); !ok || len(rs) != 1 || rs[0].Host != "192.0.2.1" {
+		t.Fatalf("unexpected records %v", rs)
+	}
+	if rs, ok := table.Lookup("svc.local."); !ok || len(rs) != 2 {
+		t.Fatalf("unexpected records %v", rs)
+	}
+	if rs, ok := table.Lookup("local."); !ok || len(rs) != 3 {
+		t.Fatalf("unexpected records %v", rs)
+	}
+	if _, ok := table.Lookup("example.com."); ok {
+		t.Fatal("unexpected name")
+	}
+	if addr, ok := records[1].Addr(); !ok || !addr.Is6() {
+		t.Fatalf("unexpected addr %v", addr)
+	}
+	if _, ok := records[2].Addr(); ok {
+		t.Fatal("host should not be an addr")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Hub broadcasts events to its subscribers, and serves them as
+// server-sent events. Each subscriber has its own buffer. Events are
+// dropped for subscribers that are too slow.
+type Hub[T any] struct {
+	buffer         int
+	maxSubscribers int
+
+	m    sync.Mutex
+	subs map[chan T]struct{}
+	n    atomic.Int32 // number of subscribers, for fast path.
+
+	// bufLimit limits the buffered events of each subscriber. It is
+	// buffer unless it was shrunk by SetBufferLimit.
+	bufLimit atomic.Int32
+}
+
+// NewHub returns a Hub that buffers buffer events for each subscriber
+// and accepts at most maxSubscribers subscribers.
+func NewHub[T any](buffer, maxSubscribers int) *Hub[T] {
+	h := &Hub[T]{
+		buffer:         buffer,
+		maxSubscribers: maxSubscribers,
+		subs:           make(map[chan T]struct{}),
+	}
+	h.bufLimit.Store(int32(buffer))
+	return h
+}
+
+// Len returns the number of subscribers. Publishers can skip building
+// events if it is 0.
+func (h *Hub[T]) Len() int {
+	return int(h.n.Load())
+}
+
+// SetBufferLimit limits the buffered events of each subscriber to n.
+// n is capped to the buffer of the Hub.
+func (h *Hub[T]) SetBufferLimit(n int) {
+	h.bufLimit.Store(int32(min(max(n, 1), h.buffer)))
+}
+
+// Publish sends e to all subscribers. It does not block.
+func (h *Hub[T]) Publish(e T) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	bufLimit := int(h.bufLimit.Load())
+	for c := range h.subs {
+		if len(c) >= bufLimit {
+			continue // subscriber is too slow, drop this event.
+		}
+		select {
+		case c <- e:
+		default: // subscriber is too slow, drop this event.
+		}
+	}
+}
+
+// Subscribe returns a channel that receives events. It returns nil if
+// there are too many subscribers.
+func (h *Hub[T]) Subscribe() chan T {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if len(h.subs) >= h.maxSubscribers {
+		return nil
+	}
+	c := make(chan T, h.buffer)
+	h.subs[c] = struct{}{}
+	h.n.Add(1)
+	return c
+}
+
+func (h *Hub[T]) Unsubscribe(c chan T) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if _, ok := h.subs[c]; ok {
+		delete(h.subs, c)
+		h.n.Add(-1)
+	}
+}
+
+// Serve subscribes to h and writes events that match (nil matches all)
+// to w in json as server-sent events, until the request is done.
+func (h *Hub[T]) Serve(w http.ResponseWriter, req *http.Request, match func(e T) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	c := h.Subscribe()
+	if c == nil {
+		http.Error(w, "too many subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.Unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e := <-c:
+			if match != nil && !match(e) {
+				continue
+			}
+			if _, err := w.Write([]byte("data: ")); err != nil {
+				return
+			}
+			// Encode appends a "\n", with another one to end the event.
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
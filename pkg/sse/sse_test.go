@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub[int](2, 1)
+	c := h.Subscribe()
+	if c == nil || h.Len() != 1 {
+		t.Fatal("failed to subscribe")
+	}
+	if h.Subscribe() != nil {
+		t.Fatal("subscribers should be limited")
+	}
+
+	// Events are dropped once the buffer is full.
+	for i := 0; i < 3; i++ {
+		h.Publish(i)
+	}
+	if len(c) != 2 || <-c != 0 || <-c != 1 {
+		t.Fatal("unexpected buffered events")
+	}
+
+	h.SetBufferLimit(1)
+	h.Publish(1)
+	h.Publish(2)
+	if len(c) != 1 {
+		t.Fatalf("want 1 buffered event, got %d", len(c))
+	}
+
+	h.Unsubscribe(c)
+	h.Unsubscribe(c)
+	if h.Len() != 0 {
+		t.Fatalf("want no subscriber, got %d", h.Len())
+	}
+}
+
+func TestHub_Serve(t *testing.T) {
+	h := NewHub[int](16, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.Serve(w, req, func(e int) bool { return e%2 == 0 })
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	deadline := time.Now().Add(time.Second)
+	for h.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber is not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	h.Publish(1) // filtered
+	h.Publish(2)
+
+	r := bufio.NewReader(resp.Body)
+	for _, want := range []string{"data: 2\n", "\n"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Fatalf("want %q, got %q", want, line)
+		}
+	}
+}
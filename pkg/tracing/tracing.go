@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package tracing is a thin wrapper of OpenTelemetry tracing API.
+// Spans are created from the global tracer provider, which is a noop
+// provider unless tracing is enabled in the config.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ScopeName = "github.com/IrineSistiana/mosdns/v5"
+
+// Start starts a span from the global tracer provider.
+// Attributes are only evaluated if the span is recording, so attrs
+// can be a func to avoid unnecessary allocations.
+func Start(ctx context.Context, name string, attrs func() []attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(ScopeName).Start(ctx, name)
+	if attrs != nil && span.IsRecording() {
+		span.SetAttributes(attrs()...)
+	}
+	return ctx, span
+}
+
+// End records err (if any) to span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil && span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -29,6 +29,9 @@ type Event int
 const (
 	EventConnOpen Event = iota
 	EventConnClose
+	// EventFallback is emitted when a query is retried by a fallback
+	// protocol, e.g. a truncated udp response is retried over tcp.
+	EventFallback
 )
 
 type EventObserver interface {
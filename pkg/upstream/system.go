@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
+	"go.uber.org/zap"
+)
+
+const (
+	systemServersRefreshInterval = time.Second * 5
+	systemServersCloseDelay      = time.Second * 10
+)
+
+// systemUpstream sends queries to the resolvers configured in the os.
+// It follows changes of the system configuration (e.g. dhcp renewals,
+// vpn up/down). Servers are tried in order. Servers that would send
+// queries back to mosdns (its own listen addresses, the stub of
+// systemd-resolved) are skipped.
+type systemUpstream struct {
+	path string // path of resolv.conf, maybe empty. Not used on windows.
+	opt  Opt
+
+	m       sync.Mutex
+	servers []netip.AddrPort
+	us      []Upstream
+
+	// Servers of mosdns may not be listening yet when u is created.
+	recheckOnce sync.Once
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+func newSystemUpstream(path string, opt Opt) (*systemUpstream, error) {
+	u := &systemUpstream{
+		path:        path,
+		opt:         opt,
+		closeNotify: make(chan struct{}),
+	}
+	if err := u.refresh(); err != nil {
+		return nil, fmt.Errorf("failed to load system resolvers, %w", err)
+	}
+	go u.refreshLoop()
+	return u, nil
+}
+
+func (u *systemUpstream) refreshLoop() {
+	ticker := time.NewTicker(systemServersRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := u.refresh(); err != nil {
+				u.opt.Logger.Warn("failed to reload system resolvers", zap.Error(err))
+			}
+		case <-u.closeNotify:
+			return
+		}
+	}
+}
+
+func (u *systemUpstream) refresh() error {
+	servers, err := loadSystemServers(u.path)
+	if err != nil {
+		return err
+	}
+	var skipped []netip.AddrPort
+	servers = slices.DeleteFunc(servers, func(s netip.AddrPort) bool {
+		if isLoopServer(s) {
+			skipped = append(skipped, s)
+			return true
+		}
+		return false
+	})
+
+	u.m.Lock()
+	defer u.m.Unlock()
+	if slices.Equal(servers, u.servers) {
+		return nil
+	}
+	us := make([]Upstream, 0, len(servers))
+	for _, s := range servers {
+		// Zone of link-local addresses must be escaped in url.
+		su, err := NewUpstream(strings.Replace(s.String(), "%", "%25", 1), Opt{
+			SoMark:        u.opt.SoMark,
+			BindToDevice:  u.opt.BindToDevice,
+			IdleTimeout:   u.opt.IdleTimeout,
+			Logger:        u.opt.Logger,
+			EventObserver: u.opt.EventObserver,
+		})
+		if err != nil {
+			for _, su := range us {
+				su.Close()
+			}
+			return fmt.Errorf("failed to init upstream %s, %w", s, err)
+		}
+		us = append(us, su)
+	}
+
+	// Close old upstreams later. There may be queries still using them.
+	oldUs := u.us
+	if len(oldUs) > 0 {
+		time.AfterFunc(systemServersCloseDelay, func() {
+			for _, su := range oldUs {
+				su.Close()
+			}
+		})
+	}
+	u.servers = servers
+	u.us = us
+	u.opt.Logger.Info("system resolvers updated", zap.Stringers("servers", servers), zap.Stringers("skipped", skipped))
+	return nil
+}
+
+// resolvedStubs are the stub addresses of systemd-resolved, which may
+// forward queries to mosdns.
+var resolvedStubs = [...]netip.Addr{
+	netip.AddrFrom4([4]byte{127, 0, 0, 53}),
+	netip.AddrFrom4([4]byte{127, 0, 0, 54}),
+}
+
+// isLoopServer reports whether queries sent to s may come back to
+// mosdns.
+func isLoopServer(s netip.AddrPort) bool {
+	addr := s.Addr().Unmap()
+	return slices.Contains(resolvedStubs[:], addr) || shared_listener.IsListening(s)
+}
+
+func (u *systemUpstream) ExchangeContext(ctx context.Context, q []byte) (*[]byte, error) {
+	u.recheckOnce.Do(func() {
+		if err := u.refresh(); err != nil {
+			u.opt.Logger.Warn("failed to reload system resolvers", zap.Error(err))
+		}
+	})
+
+	u.m.Lock()
+	us := u.us
+	u.m.Unlock()
+
+	if len(us) == 0 {
+		return nil, errors.New("no system resolver is available")
+	}
+	var errs []error
+	for _, su := range us {
+		r, err := su.ExchangeContext(ctx, q)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (u *systemUpstream) Close() error {
+	u.closeOnce.Do(func() {
+		close(u.closeNotify)
+		u.m.Lock()
+		defer u.m.Unlock()
+		for _, su := range u.us {
+			su.Close()
+		}
+		u.us = nil
+	})
+	return nil
+}
+
+// parseResolvConf returns the nameservers in a resolv.conf file.
+func parseResolvConf(r io.Reader) ([]netip.AddrPort, error) {
+	var servers []netip.AddrPort
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fs := strings.Fields(line)
+		if len(fs) < 2 || fs[0] != "nameserver" {
+			continue
+		}
+		addr, err := netip.ParseAddr(fs[1])
+		if err != nil {
+			continue
+		}
+		servers = append(servers, netip.AddrPortFrom(addr, 53))
+	}
+	return servers, s.Err()
+}
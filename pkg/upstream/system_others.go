@@ -0,0 +1,46 @@
+//go:build !windows
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upstream
+
+import (
+	"net/netip"
+	"os"
+)
+
+// systemdResolvConf lists the actual upstream servers of systemd-resolved.
+// /etc/resolv.conf only has its stub address in this case.
+const systemdResolvConf = "/run/systemd/resolve/resolv.conf"
+
+func loadSystemServers(path string) ([]netip.AddrPort, error) {
+	if len(path) == 0 {
+		path = "/etc/resolv.conf"
+		if _, err := os.Stat(systemdResolvConf); err == nil {
+			path = systemdResolvConf
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
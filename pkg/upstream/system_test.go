@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upstream
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_parseResolvConf(t *testing.T) {
+	conf := `# generated by NetworkManager
+search lan
+nameserver 192.168.1.1
+nameserver fe80::1%eth0 ; link local
+nameserver invalid
+options edns0
+`
+	servers, err := parseResolvConf(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("192.168.1.1:53"),
+		netip.MustParseAddrPort("[fe80::1%eth0]:53"),
+	}
+	if !slices.Equal(servers, want) {
+		t.Fatalf("want %v, got %v", want, servers)
+	}
+}
+
+func Test_systemUpstream_skipLoopServers(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "resolv.conf")
+	data := "nameserver 127.0.0.53\nnameserver 192.0.2.1\nnameserver 127.0.0.54\n"
+	if err := os.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u, err := newSystemUpstream(conf, Opt{Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	u.m.Lock()
+	servers := u.servers
+	u.m.Unlock()
+	want := []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:53")}
+	if !slices.Equal(servers, want) {
+		t.Fatalf("want %v, got %v", want, servers)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build windows
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upstream
+
+import (
+	"net/netip"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+var systemInterfaceKeys = []string{
+	`SYSTEM\CurrentControlSet\Services\Tcpip\Parameters\Interfaces`,
+	`SYSTEM\CurrentControlSet\Services\Tcpip6\Parameters\Interfaces`,
+}
+
+// loadSystemServers reads nameservers of all interfaces from the registry.
+// Statically configured servers take precedence over dhcp provided ones.
+func loadSystemServers(_ string) ([]netip.AddrPort, error) {
+	var servers []netip.AddrPort
+	seen := make(map[netip.AddrPort]struct{})
+	for _, keyPath := range systemInterfaceKeys {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, keyPath, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		ifaces, err := k.ReadSubKeyNames(-1)
+		k.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			ik, err := registry.OpenKey(registry.LOCAL_MACHINE, keyPath+`\`+iface, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+			for _, name := range [...]string{"NameServer", "DhcpNameServer"} {
+				v, _, err := ik.GetStringValue(name)
+				if err != nil || len(v) == 0 {
+					continue
+				}
+				for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' }) {
+					addr, err := netip.ParseAddr(s)
+					if err != nil {
+						continue
+					}
+					ap := netip.AddrPortFrom(addr, 53)
+					if _, dup := seen[ap]; dup {
+						continue
+					}
+					seen[ap] = struct{}{}
+					servers = append(servers, ap)
+				}
+				break
+			}
+			ik.Close()
+		}
+	}
+	return servers, nil
+}
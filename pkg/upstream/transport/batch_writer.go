@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package transport
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxSpareBufSize is the maximum size of a buffer that will be kept
+// by batchWriter for reuse.
+const maxSpareBufSize = 64 * 1024
+
+// batchWriter writes dns msgs with length headers to a stream connection.
+// Msgs that are written while another write is in progress are coalesced
+// into one write call. So at high qps, many msgs share one syscall (and
+// one tls record).
+type batchWriter struct {
+	w io.Writer
+
+	// delay is how long the flusher waits for more msgs before writing
+	// a busy connection.
+	delay time.Duration
+
+	m        sync.Mutex
+	buf      []byte
+	spare    []byte // buf that is not in use, to avoid allocations.
+	flushing bool   // there is a flusher.
+	err      error  // the first write error.
+}
+
+func newBatchWriter(w io.Writer, delay time.Duration) *batchWriter {
+	return &batchWriter{w: w, delay: delay}
+}
+
+// writeMsg writes m with its id replaced by id.
+// If there is a flusher, m is queued, and writeMsg returns immediately.
+// Otherwise, the caller becomes the flusher and writes all queued msgs.
+// If busy is true, the flusher waits for batchWriter.delay first to
+// collect more msgs.
+// Only the flusher will see the write error. Other callers should be
+// notified by closing the connection.
+func (bw *batchWriter) writeMsg(m []byte, id uint16, busy bool) error {
+	l := len(m)
+	if l > dns.MaxMsgSize {
+		return ErrPayloadOverFlow
+	}
+	if l < 2 {
+		return dns.ErrShortRead
+	}
+
+	bw.m.Lock()
+	if bw.err != nil {
+		err := bw.err
+		bw.m.Unlock()
+		return err
+	}
+	bw.buf = binary.BigEndian.AppendUint16(bw.buf, uint16(l))
+	bw.buf = binary.BigEndian.AppendUint16(bw.buf, id)
+	bw.buf = append(bw.buf, m[2:]...)
+	if bw.flushing {
+		bw.m.Unlock()
+		return nil
+	}
+	bw.flushing = true
+	bw.m.Unlock()
+
+	if busy && bw.delay > 0 {
+		time.Sleep(bw.delay)
+	}
+	return bw.flush()
+}
+
+// flush writes queued msgs until the queue is empty.
+func (bw *batchWriter) flush() error {
+	bw.m.Lock()
+	defer bw.m.Unlock()
+	for len(bw.buf) > 0 {
+		b := bw.buf
+		bw.buf = bw.spare[:0]
+		bw.spare = nil
+		bw.m.Unlock()
+		_, err := bw.w.Write(b)
+		bw.m.Lock()
+		if cap(b) <= maxSpareBufSize {
+			bw.spare = b[:0]
+		}
+		if err != nil {
+			bw.err = err
+			bw.buf = nil
+			bw.flushing = false
+			return err
+		}
+	}
+	bw.flushing = false
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/stretchr/testify/require"
+)
+
+// slowWriter records writes, each write takes some time.
+type slowWriter struct {
+	m      sync.Mutex
+	buf    bytes.Buffer
+	writes int
+	err    error
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.writes++
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.buf.Write(b)
+}
+
+func Test_batchWriter(t *testing.T) {
+	r := require.New(t)
+	w := new(slowWriter)
+	bw := newBatchWriter(w, time.Millisecond)
+
+	const n = 256
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := make([]byte, 13+i)
+			m[len(m)-1] = byte(i)
+			r.NoError(bw.writeMsg(m, uint16(i), true))
+		}(i)
+	}
+	wg.Wait()
+	r.NoError(bw.flush())
+
+	r.Less(w.writes, n, "writes should be coalesced")
+	seen := make(map[uint16]bool)
+	for i := 0; i < n; i++ {
+		m, err := dnsutils.ReadRawMsgFromTCP(&w.buf)
+		r.NoError(err)
+		id := binary.BigEndian.Uint16(*m)
+		r.Len(*m, 13+int(id))
+		r.Equal(byte(id), (*m)[len(*m)-1])
+		seen[id] = true
+	}
+	r.Len(seen, n)
+	r.Zero(w.buf.Len())
+
+	w.err = errors.New("write err")
+	r.ErrorIs(bw.writeMsg(make([]byte, 12), 0, false), w.err)
+	r.ErrorIs(bw.writeMsg(make([]byte, 12), 0, false), w.err, "the first write err should be kept")
+}
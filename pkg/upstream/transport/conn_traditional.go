@@ -46,6 +46,7 @@ type TraditionalDnsConn struct {
 	isTcp       bool
 	idleTimeout time.Duration
 	maxCq       int
+	bw          *batchWriter // for tcp only.
 
 	closeOnce   sync.Once
 	closeNotify chan struct{}
@@ -75,6 +76,11 @@ type TraditionalDnsConnOpts struct {
 	// MaxConcurrentQuery limits the number of maximum concurrent queries
 	// in the connection. Default is defaultTdcMaxConcurrentQuery.
 	MaxConcurrentQuery int
+
+	// WriteDelay is how long a busy connection waits to coalesce
+	// queries into one write. It only works with WithLengthHeader.
+	// Default is defaultTdcWriteDelay.
+	WriteDelay time.Duration
 }
 
 func NewDnsConn(opt TraditionalDnsConnOpts, conn NetConn) *TraditionalDnsConn {
@@ -86,6 +92,11 @@ func NewDnsConn(opt TraditionalDnsConnOpts, conn NetConn) *TraditionalDnsConn {
 	}
 	setDefaultGZ(&dc.idleTimeout, opt.IdleTimeout, defaultIdleTimeout)
 	setDefaultGZ(&dc.maxCq, opt.MaxConcurrentQuery, defaultTdcMaxConcurrentQuery)
+	if dc.isTcp {
+		var writeDelay time.Duration
+		setDefaultGZ(&writeDelay, opt.WriteDelay, defaultTdcWriteDelay)
+		dc.bw = newBatchWriter(conn, writeDelay)
+	}
 
 	go dc.readLoop()
 	return dc
@@ -152,18 +163,16 @@ wait:
 }
 
 func (dc *TraditionalDnsConn) writeQuery(q []byte, assignedQid uint16) error {
-	var payload *[]byte
 	if dc.isTcp {
-		var err error
-		payload, err = copyMsgWithLenHdr(q)
-		if err != nil {
-			return err
-		}
-		binary.BigEndian.PutUint16((*payload)[2:], assignedQid)
-	} else {
-		payload = copyMsg(q)
-		binary.BigEndian.PutUint16(*payload, assignedQid)
+		// The connection is busy if other queries are waiting for their
+		// replies. Wait a little bit to coalesce more queries.
+		dc.queueMu.RLock()
+		busy := len(dc.queue) > 1
+		dc.queueMu.RUnlock()
+		return dc.bw.writeMsg(q, assignedQid, busy)
 	}
+	payload := copyMsg(q)
+	binary.BigEndian.PutUint16(*payload, assignedQid)
 	_, err := dc.c.Write(*payload)
 	pool.ReleaseBuf(payload)
 	return err
@@ -22,8 +22,12 @@ package transport
 import (
 	"context"
 	"errors"
+	"math/rand/v2"
 	"net"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
@@ -34,21 +38,34 @@ import (
 const (
 	// Most servers will send SERVFAIL after 3~5s. If no resp, connection may be dead.
 	reuseConnQueryTimeout = time.Second * 6
+
+	maxIdleShards = 32
 )
 
 // ReuseConnTransport is for old tcp protocol. (no pipelining)
+// Idle connections are kept in shards, so concurrent queries don't
+// contend on a single lock.
 type ReuseConnTransport struct {
 	dialFunc    func(ctx context.Context) (NetConn, error)
 	dialTimeout time.Duration
 	idleTimeout time.Duration
+	maxConns    int32
 	logger      *zap.Logger // non-nil
 	ctx         context.Context
 	ctxCancel   context.CancelCauseFunc
 
-	m         sync.Mutex // protect following fields
-	closed    bool
-	idleConns map[*reusableConn]struct{}
-	conns     map[*reusableConn]struct{}
+	closed    atomic.Bool
+	idle      []idleShard
+	nIdle     atomic.Int32  // number of idle connections in all shards.
+	nextShard atomic.Uint32 // for assigning shards to new connections.
+	nConns    atomic.Int32  // number of connections, including dialing ones.
+	// connFreed is notified if a connection is idle or closed. It has a
+	// slot for every connection, so notifications of connections that
+	// are freed at once are not lost before waiters wake up.
+	connFreed chan struct{}
+
+	m     sync.Mutex // protect following fields
+	conns map[*reusableConn]struct{}
 
 	// for testing
 	testWaitRespTimeout time.Duration
@@ -66,6 +83,10 @@ type ReuseConnOpts struct {
 	// Default is defaultIdleTimeout
 	IdleTimeout time.Duration
 
+	// MaxConns limits the number of connections. If the limit is reached,
+	// queries wait for an idle connection. Default is no limit.
+	MaxConns int
+
 	Logger *zap.Logger
 }
 
@@ -74,12 +95,16 @@ func NewReuseConnTransport(opt ReuseConnOpts) *ReuseConnTransport {
 	t := &ReuseConnTransport{
 		ctx:       ctx,
 		ctxCancel: cancel,
-		idleConns: make(map[*reusableConn]struct{}),
+		idle:      make([]idleShard, min(runtime.GOMAXPROCS(0), maxIdleShards)),
 		conns:     make(map[*reusableConn]struct{}),
 	}
 	t.dialFunc = opt.DialContext
 	setDefaultGZ(&t.dialTimeout, opt.DialTimeout, defaultDialTimeout)
 	setDefaultGZ(&t.idleTimeout, opt.IdleTimeout, defaultIdleTimeout)
+	if opt.MaxConns > 0 {
+		t.maxConns = int32(min(opt.MaxConns, 1<<16))
+		t.connFreed = make(chan struct{}, t.maxConns)
+	}
 	setNonNilLogger(&t.logger, opt.Logger)
 
 	return t
@@ -96,6 +121,17 @@ func (t *ReuseConnTransport) ExchangeContext(ctx context.Context, m []byte) (*[]
 			return nil, err
 		}
 		if c == nil {
+			if !t.reserveConn() {
+				// Too many connections. Wait for an idle one.
+				select {
+				case <-t.connFreed:
+					continue
+				case <-ctx.Done():
+					return nil, context.Cause(ctx)
+				case <-t.ctx.Done():
+					return nil, context.Cause(t.ctx)
+				}
+			}
 			isNewConn = true
 			c, err = t.getNewConn(ctx)
 			if err != nil {
@@ -110,6 +146,11 @@ func (t *ReuseConnTransport) ExchangeContext(ctx context.Context, m []byte) (*[]
 
 		resp, err := c.exchange(ctx, queryPayload)
 		if err != nil {
+			// The caller is gone, e.g. another upstream won. Don't send
+			// the query to other connections.
+			if ctx.Err() != nil {
+				return nil, err
+			}
 			if !isNewConn && retry <= maxRetry {
 				retry++
 				continue // retry if c is a reused connection.
@@ -120,7 +161,42 @@ func (t *ReuseConnTransport) ExchangeContext(ctx context.Context, m []byte) (*[]
 	}
 }
 
-// getNewConn dial a *reusableConn.
+// reserveConn reserves a new connection in t.nConns. It reports false if
+// there are too many connections.
+func (t *ReuseConnTransport) reserveConn() bool {
+	if t.maxConns <= 0 {
+		t.nConns.Add(1)
+		return true
+	}
+	for {
+		n := t.nConns.Load()
+		if n >= t.maxConns {
+			return false
+		}
+		if t.nConns.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// releaseConn releases a connection reserved by reserveConn.
+func (t *ReuseConnTransport) releaseConn() {
+	t.nConns.Add(-1)
+	t.notifyConnFreed()
+}
+
+func (t *ReuseConnTransport) notifyConnFreed() {
+	if t.maxConns <= 0 {
+		return
+	}
+	select {
+	case t.connFreed <- struct{}{}:
+	default:
+	}
+}
+
+// getNewConn dial a *reusableConn. A connection must have been reserved
+// by reserveConn. It will be released if getNewConn failed to dial.
 // The caller must call releaseReusableConn to release the reusableConn.
 func (t *ReuseConnTransport) getNewConn(ctx context.Context) (*reusableConn, error) {
 	callCtx, cancel := context.WithCancel(ctx)
@@ -148,6 +224,9 @@ func (t *ReuseConnTransport) getNewConn(ctx context.Context) (*reusableConn, err
 				err = ErrClosedTransport
 			}
 		}
+		if rc == nil {
+			t.releaseConn()
+		}
 
 		select {
 		case dialChan <- dialRes{c: rc, err: err}:
@@ -168,14 +247,57 @@ func (t *ReuseConnTransport) getNewConn(ctx context.Context) (*reusableConn, err
 	}
 }
 
+// idleShard is a stack of idle connections. The most recently used
+// connection is reused first, so the others can reach their idle timeout
+// if there are more idle connections than needed.
+type idleShard struct {
+	m     sync.Mutex
+	conns []*reusableConn
+}
+
+func (s *idleShard) push(c *reusableConn) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if c.removed {
+		return false
+	}
+	s.conns = append(s.conns, c)
+	return true
+}
+
+func (s *idleShard) pop() *reusableConn {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.conns) == 0 {
+		return nil
+	}
+	c := s.conns[len(s.conns)-1]
+	s.conns[len(s.conns)-1] = nil
+	s.conns = s.conns[:len(s.conns)-1]
+	return c
+}
+
+// remove removes c from s, and c will not be pushed to s again.
+// It reports whether c was idle.
+func (s *idleShard) remove(c *reusableConn) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	c.removed = true
+	i := slices.Index(s.conns, c)
+	if i < 0 {
+		return false
+	}
+	s.conns = slices.Delete(s.conns, i, i+1)
+	return true
+}
+
 func (t *ReuseConnTransport) setIdle(c *reusableConn) {
-	t.m.Lock()
-	defer t.m.Unlock()
-	if t.closed {
+	if t.closed.Load() {
 		return
 	}
-	if _, ok := t.conns[c]; ok {
-		t.idleConns[c] = struct{}{}
+	if c.shard.push(c) {
+		t.nIdle.Add(1)
+		t.notifyConnFreed()
 	}
 }
 
@@ -183,15 +305,20 @@ func (t *ReuseConnTransport) setIdle(c *reusableConn) {
 // is idle.
 // The caller must call releaseReusableConn to release the reusableConn.
 func (t *ReuseConnTransport) getIdleConn() (*reusableConn, error) {
-	t.m.Lock()
-	defer t.m.Unlock()
-	if t.closed {
+	if t.closed.Load() {
 		return nil, ErrClosedTransport
 	}
+	if t.nIdle.Load() <= 0 {
+		return nil, nil
+	}
 
-	for c := range t.idleConns {
-		delete(t.idleConns, c)
-		return c, nil
+	n := len(t.idle)
+	start := rand.IntN(n)
+	for i := 0; i < n; i++ {
+		if c := t.idle[(start+i)%n].pop(); c != nil {
+			t.nIdle.Add(-1)
+			return c, nil
+		}
 	}
 	return nil, nil
 }
@@ -199,24 +326,37 @@ func (t *ReuseConnTransport) getIdleConn() (*reusableConn, error) {
 // Close closes ReuseConnTransport and all its connections.
 // It always returns a nil error.
 func (t *ReuseConnTransport) Close() error {
-	t.m.Lock()
-	defer t.m.Unlock()
-	if t.closed {
+	if t.closed.Swap(true) {
 		return nil
 	}
-	t.closed = true
+	t.m.Lock()
 	for c := range t.conns {
 		delete(t.conns, c)
-		delete(t.idleConns, c)
 		c.closeWithErrByTransport(ErrClosedTransport)
 	}
+	t.m.Unlock()
+	for i := range t.idle {
+		s := &t.idle[i]
+		s.m.Lock()
+		for _, c := range s.conns {
+			c.removed = true
+		}
+		t.nIdle.Add(-int32(len(s.conns)))
+		s.conns = nil
+		s.m.Unlock()
+	}
 	t.ctxCancel(ErrClosedTransport)
 	return nil
 }
 
 type reusableConn struct {
-	c NetConn
-	t *ReuseConnTransport
+	c     NetConn
+	t     *ReuseConnTransport
+	shard *idleShard // The shard that c will be put in when it is idle.
+
+	// Protected by shard.m. If true, c has been closed and removed from
+	// the shard.
+	removed bool
 
 	m           sync.Mutex
 	waitingResp chan *[]byte
@@ -231,11 +371,12 @@ func (t *ReuseConnTransport) newReusableConn(c NetConn) *reusableConn {
 	rc := &reusableConn{
 		c:           c,
 		t:           t,
+		shard:       &t.idle[t.nextShard.Add(1)%uint32(len(t.idle))],
 		closeNotify: make(chan struct{}),
 	}
 
 	t.m.Lock()
-	if t.closed { // t was closed.
+	if t.closed.Load() { // t was closed.
 		t.m.Unlock()
 		return nil
 	}
@@ -287,14 +428,17 @@ func (c *reusableConn) closeWithErr(err error) {
 		err = net.ErrClosed
 	}
 	c.closeOnce.Do(func() {
+		if c.shard.remove(c) {
+			c.t.nIdle.Add(-1)
+		}
 		c.t.m.Lock()
 		delete(c.t.conns, c)
-		delete(c.t.idleConns, c)
 		c.t.m.Unlock()
 
 		c.closeErr = err
 		c.c.Close()
 		close(c.closeNotify)
+		c.t.releaseConn()
 	})
 }
 
@@ -306,6 +450,7 @@ func (c *reusableConn) closeWithErrByTransport(err error) {
 		c.closeErr = err
 		c.c.Close()
 		close(c.closeNotify)
+		c.t.releaseConn()
 	})
 }
 
@@ -21,14 +21,32 @@ package transport
 
 import (
 	"context"
+	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
 
+// connNum returns the number of connections and idle connections of t.
+func (t *ReuseConnTransport) connNum() (conns, idle int) {
+	t.m.Lock()
+	conns = len(t.conns)
+	t.m.Unlock()
+	for i := range t.idle {
+		s := &t.idle[i]
+		s.m.Lock()
+		idle += len(s.conns)
+		s.m.Unlock()
+	}
+	return conns, idle
+}
+
 func Test_ReuseConnTransport(t *testing.T) {
 	const idleTimeout = time.Second * 5
 	r := require.New(t)
@@ -67,10 +85,7 @@ func Test_ReuseConnTransport(t *testing.T) {
 		}
 	}
 
-	rt.m.Lock()
-	connNum := len(rt.conns)
-	idledConnNum := len(rt.idleConns)
-	rt.m.Unlock()
+	connNum, idledConnNum := rt.connNum()
 
 	r.Equal(0, connNum-idledConnNum, "there should be no active conn")
 	r.Equal(concurrentQueryNum, connNum)
@@ -111,10 +126,7 @@ func Test_ReuseConnTransport_Read_err_and_close(t *testing.T) {
 	}
 	wg.Wait()
 
-	rt.m.Lock()
-	connNum := len(rt.conns)
-	idledConnNum := len(rt.idleConns)
-	rt.m.Unlock()
+	connNum, idledConnNum := rt.connNum()
 
 	r.Equal(0, connNum)
 	r.Equal(0, idledConnNum)
@@ -143,12 +155,178 @@ func Test_ReuseConnTransport_conn_lose_and_close(t *testing.T) {
 
 	time.Sleep(time.Millisecond * 100)
 
-	rt.m.Lock()
-	connNum := len(rt.conns)
-	idledConnNum := len(rt.idleConns)
-	rt.m.Unlock()
+	connNum, idledConnNum := rt.connNum()
 
 	// connection should be closed and removed
 	r.Equal(0, connNum)
 	r.Equal(0, idledConnNum)
 }
+
+func Test_ReuseConnTransport_max_conns(t *testing.T) {
+	r := require.New(t)
+
+	const maxConns = 3
+	var dialed atomic.Int32
+	po := ReuseConnOpts{
+		DialContext: func(ctx context.Context) (NetConn, error) {
+			dialed.Add(1)
+			return newDummyEchoNetConn(0, time.Millisecond*10, 0), nil
+		},
+		MaxConns: maxConns,
+	}
+	rt := NewReuseConnTransport(po)
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	q := new(dns.Msg)
+	q.SetQuestion("test.", dns.TypeA)
+	queryPayload, err := q.Pack()
+	r.NoError(err)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.ExchangeContext(ctx, queryPayload)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pool.ReleaseBuf(resp)
+		}()
+	}
+	wg.Wait()
+
+	connNum, idledConnNum := rt.connNum()
+	r.LessOrEqual(dialed.Load(), int32(maxConns))
+	r.Equal(int(dialed.Load()), connNum)
+	r.Equal(connNum, idledConnNum, "all conn should be in idle status")
+}
+
+// newSlowEchoNetConn returns a NetConn that echoes queries after latency.
+func newSlowEchoNetConn(latency *atomic.Int64) NetConn {
+	c1, c2 := net.Pipe()
+	go func() {
+		defer c2.Close()
+		for {
+			m, err := dnsutils.ReadRawMsgFromTCP(c2)
+			if err != nil {
+				return
+			}
+			go func() {
+				defer pool.ReleaseBuf(m)
+				time.Sleep(time.Duration(latency.Load()))
+				_, _ = dnsutils.WriteRawMsgToTCP(c2, *m)
+			}()
+		}
+	}()
+	return c1
+}
+
+// Test_ReuseConnTransport_canceled_no_retry checks that a canceled query,
+// e.g. the loser of concurrent upstreams, is not retried on other idle
+// connections, which would make them busy until the server responds.
+func Test_ReuseConnTransport_canceled_no_retry(t *testing.T) {
+	r := require.New(t)
+
+	const conns = 3
+	var latency atomic.Int64
+	po := ReuseConnOpts{
+		DialContext: func(ctx context.Context) (NetConn, error) {
+			return newSlowEchoNetConn(&latency), nil
+		},
+	}
+	rt := NewReuseConnTransport(po)
+	defer rt.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion("test.", dns.TypeA)
+	queryPayload, err := q.Pack()
+	r.NoError(err)
+
+	// Fill the pool with idle connections.
+	latency.Store(int64(time.Millisecond * 50))
+	wg := new(sync.WaitGroup)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.ExchangeContext(context.Background(), queryPayload)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pool.ReleaseBuf(resp)
+		}()
+	}
+	wg.Wait()
+	connNum, idledConnNum := rt.connNum()
+	r.Equal(conns, connNum)
+	r.Equal(conns, idledConnNum)
+
+	// Cancel a query in flight.
+	latency.Store(int64(time.Millisecond * 200))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err = rt.ExchangeContext(ctx, queryPayload)
+	r.Error(err)
+
+	_, idledConnNum = rt.connNum()
+	r.Equal(conns-1, idledConnNum, "only one connection should be busy")
+}
+
+// Test_ReuseConnTransport_max_conns_waiters checks that every waiter is
+// woken up if several connections are freed at once.
+func Test_ReuseConnTransport_max_conns_waiters(t *testing.T) {
+	r := require.New(t)
+
+	const maxConns = 4
+	var latency atomic.Int64
+	po := ReuseConnOpts{
+		DialContext: func(ctx context.Context) (NetConn, error) {
+			return newSlowEchoNetConn(&latency), nil
+		},
+		MaxConns: maxConns,
+	}
+	rt := NewReuseConnTransport(po)
+	defer rt.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion("test.", dns.TypeA)
+	queryPayload, err := q.Pack()
+	r.NoError(err)
+
+	// Reserve all connections, so the queries below have to wait.
+	for i := 0; i < maxConns; i++ {
+		r.True(rt.reserveConn())
+	}
+	// The queries can only finish in time if waiters are woken up as
+	// soon as connections are freed.
+	latency.Store(int64(time.Millisecond * 100))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < maxConns*4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.ExchangeContext(ctx, queryPayload)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pool.ReleaseBuf(resp)
+		}()
+	}
+	time.Sleep(time.Millisecond * 50) // wait for the queries to block.
+
+	// Free all connections at once.
+	for i := 0; i < maxConns; i++ {
+		c := rt.newReusableConn(newSlowEchoNetConn(&latency))
+		r.NotNil(c)
+		rt.setIdle(c)
+	}
+	wg.Wait()
+}
@@ -43,6 +43,7 @@ const (
 	waitingReplyTimeout = time.Second * 10
 
 	defaultTdcMaxConcurrentQuery = 32
+	defaultTdcWriteDelay         = time.Microsecond * 100
 	defaultMaxLazyConnQueue      = 16
 )
 
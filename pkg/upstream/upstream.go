@@ -96,6 +96,10 @@ type Opt struct {
 	// Note: There is no fallback. Make sure the server supports it.
 	EnablePipeline bool
 
+	// MaxConns limits the number of connections of TCP, DoT upstreams
+	// without pipelining. Default is no limit.
+	MaxConns int
+
 	// EnableHTTP3 will use HTTP/3 protocol to connect a DoH upstream. (aka DoH3).
 	// Note: There is no fallback. Make sure the server supports it.
 	EnableHTTP3 bool
@@ -116,8 +120,9 @@ type Opt struct {
 	// Logger specifies the logger that the upstream will use.
 	Logger *zap.Logger
 
-	// EventObserver can observe connection events.
-	// Not implemented for quic based protocol (DoH3, DoQ).
+	// EventObserver can observe connection and fallback events.
+	// Connection events are not implemented for quic based protocol
+	// (DoH3, DoQ).
 	EventObserver EventObserver
 }
 
@@ -310,7 +315,8 @@ func NewUpstream(addr string, opt Opt) (_ Upstream, err error) {
 				MaxConcurrentQueryWhileDialing: maxConcurrentQueryPreConn,
 				Logger:                         opt.Logger,
 			}),
-			t: transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialTcpNetConn}),
+			t:  transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialTcpNetConn}),
+			ob: opt.EventObserver,
 		}, nil
 	case "tcp":
 		const defaultPort = 53
@@ -349,7 +355,7 @@ func NewUpstream(addr string, opt Opt) (_ Upstream, err error) {
 				Logger:                         opt.Logger,
 			}), nil
 		}
-		return transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialNetConn, IdleTimeout: idleTimeout}), nil
+		return transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialNetConn, IdleTimeout: idleTimeout, MaxConns: opt.MaxConns}), nil
 	case "tls":
 		const defaultPort = 853
 		tlsConfig := opt.TLSConfig.Clone()
@@ -398,7 +404,7 @@ func NewUpstream(addr string, opt Opt) (_ Upstream, err error) {
 				Logger:                         opt.Logger,
 			}), nil
 		}
-		return transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialNetConn}), nil
+		return transport.NewReuseConnTransport(transport.ReuseConnOpts{DialContext: dialNetConn, MaxConns: opt.MaxConns}), nil
 	case "https":
 		const defaultPort = 443
 
@@ -548,14 +554,18 @@ func NewUpstream(addr string, opt Opt) (_ Upstream, err error) {
 			MaxConcurrentQueryWhileDialing: 90,
 			Logger:                         opt.Logger,
 		}), nil
+	case "system":
+		// Path of resolv.conf is optional. e.g. "system:///etc/resolv.conf".
+		return newSystemUpstream(addrURL.Path, opt)
 	default:
 		return nil, fmt.Errorf("unsupported protocol [%s]", addrURL.Scheme)
 	}
 }
 
 type udpWithFallback struct {
-	u *transport.PipelineTransport
-	t *transport.ReuseConnTransport
+	u  *transport.PipelineTransport
+	t  *transport.ReuseConnTransport
+	ob EventObserver
 }
 
 func (u *udpWithFallback) ExchangeContext(ctx context.Context, q []byte) (*[]byte, error) {
@@ -565,6 +575,7 @@ func (u *udpWithFallback) ExchangeContext(ctx context.Context, q []byte) (*[]byt
 	}
 	if msgTruncated(*r) {
 		pool.ReleaseBuf(r)
+		u.ob.OnEvent(EventFallback)
 		return u.t.ExchangeContext(ctx, q)
 	}
 	return r, nil
@@ -0,0 +1,408 @@
+//go:build linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package xdp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/miekg/dns"
+)
+
+const (
+	xdpDrop = 1
+	xdpPass = 2
+	xdpTx   = 3
+
+	ipOff   = 14
+	udpOff  = ipOff + 20
+	dnsOff  = udpOff + 8
+	nameOff = dnsOff + 12
+
+	optLen      = 11
+	ednsUDPSize = 1232
+
+	// stack slots
+	stackKey     = -keySize - 4
+	stackMaxSize = stackKey - 8  // u64, max response size of the query
+	stackDNSLen  = stackKey - 16 // u64, length of the dns response
+	stackLen     = stackKey - 24 // u64, length of the entry data
+	stackClient  = stackKey - 32 // lpm key of the source address
+)
+
+// be16 returns the value of a big endian uint16 v that is loaded by
+// a native endian load instruction.
+func be16(v uint16) int32 {
+	return int32(binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v)))
+}
+
+// instructions returns the xdp program that answers udp ipv4 queries
+// to port from answers. If clients is not nil, only queries from the
+// addresses in clients are answered.
+//
+// Registers that are kept across helper calls:
+// r6: ctx, r7: map value, r8: 1 if the query has an OPT, r9: wire
+// length of qname.
+func instructions(port uint16, answers, clients *ebpf.Map) asm.Instructions {
+	ins := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMem(asm.R2, asm.R6, 0, asm.Word), // data
+		asm.LoadMem(asm.R3, asm.R6, 4, asm.Word), // data_end
+		asm.Mov.Reg(asm.R4, asm.R2),
+		asm.Add.Imm(asm.R4, nameOff),
+		asm.JGT.Reg(asm.R4, asm.R3, "pass"),
+
+		// ipv4 without options, not a fragment
+		asm.LoadMem(asm.R4, asm.R2, 12, asm.Half),
+		asm.JNE.Imm(asm.R4, be16(0x0800), "pass"),
+		asm.LoadMem(asm.R4, asm.R2, ipOff, asm.Byte),
+		asm.JNE.Imm(asm.R4, 0x45, "pass"),
+		asm.LoadMem(asm.R4, asm.R2, ipOff+6, asm.Half),
+		asm.And.Imm(asm.R4, be16(0x3fff)),
+		asm.JNE.Imm(asm.R4, 0, "pass"),
+		asm.LoadMem(asm.R4, asm.R2, ipOff+9, asm.Byte),
+		asm.JNE.Imm(asm.R4, 17, "pass"),
+		asm.LoadMem(asm.R4, asm.R2, udpOff+2, asm.Half),
+		asm.JNE.Imm(asm.R4, be16(port), "pass"),
+	}
+
+	if clients != nil {
+		lookupClient := asm.LoadMapPtr(asm.R1, 0)
+		if err := lookupClient.AssociateMap(clients); err != nil {
+			panic(err) // clients is always a valid map
+		}
+		ins = append(ins,
+			// lpm key: u32 prefix length, source address
+			asm.StoreImm(asm.RFP, stackClient, 32, asm.Word),
+			asm.LoadMem(asm.R4, asm.R2, ipOff+12, asm.Word),
+			asm.StoreMem(asm.RFP, stackClient+4, asm.R4, asm.Word),
+			lookupClient,
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, stackClient),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, "pass"),
+
+			// Packet pointers are lost after the helper call.
+			asm.LoadMem(asm.R2, asm.R6, 0, asm.Word),
+			asm.LoadMem(asm.R3, asm.R6, 4, asm.Word),
+			asm.Mov.Reg(asm.R4, asm.R2),
+			asm.Add.Imm(asm.R4, nameOff),
+			asm.JGT.Reg(asm.R4, asm.R3, "pass"),
+		)
+	}
+
+	ins = append(ins,
+		// A standard query without AA, TC, AD, CD bits. One question,
+		// no records except an optional OPT.
+		asm.LoadMem(asm.R4, asm.R2, dnsOff+2, asm.Byte),
+		asm.And.Imm(asm.R4, 0xfe),
+		asm.JNE.Imm(asm.R4, 0, "pass"),
+		asm.LoadMem(asm.R4, asm.R2, dnsOff+3, asm.Byte),
+		asm.And.Imm(asm.R4, 0x30),
+		asm.JNE.Imm(asm.R4, 0, "pass"),
+		asm.LoadMem(asm.R4, asm.R2, dnsOff+4, asm.Half),
+		asm.JNE.Imm(asm.R4, be16(1), "pass"),
+		asm.LoadMem(asm.R4, asm.R2, dnsOff+6, asm.Word),
+		asm.JNE.Imm(asm.R4, 0, "pass"),
+		asm.LoadMem(asm.R8, asm.R2, dnsOff+10, asm.Half),
+		asm.JEq.Imm(asm.R8, 0, "name_len"),
+		asm.JNE.Imm(asm.R8, be16(1), "pass"),
+		asm.Mov.Imm(asm.R8, 1),
+
+		// The qname length is derived from the udp length rather than
+		// the end of qname, so the verifier sees one bounded length
+		// instead of one constant per possible length.
+		asm.LoadMem(asm.R9, asm.R2, udpOff+4, asm.Half).WithSymbol("name_len"),
+		asm.HostTo(asm.BE, asm.R9, asm.Half),
+		asm.Mov.Reg(asm.R4, asm.R8),
+		asm.Mul.Imm(asm.R4, optLen),
+		asm.Add.Imm(asm.R4, 8+12+4),
+		asm.Sub.Reg(asm.R9, asm.R4),
+		asm.JGT.Imm(asm.R9, MaxNameLen, "pass"),
+		asm.JEq.Imm(asm.R9, 0, "pass"),
+	)
+
+	for off := 0; off < keySize; off += 4 {
+		ins = append(ins, asm.StoreImm(asm.RFP, int16(stackKey+off), 0, asm.Word))
+	}
+
+	// Copy the lower case qname to the key. Queries that have a zero
+	// byte in their labels produce keys that are not valid names, which
+	// never match.
+	for i := 0; i < MaxNameLen; i++ {
+		store := fmt.Sprintf("name_store_%d", i)
+		ins = append(ins,
+			asm.Mov.Reg(asm.R4, asm.R2),
+			asm.Add.Imm(asm.R4, int32(nameOff+i+1)),
+			asm.JGT.Reg(asm.R4, asm.R3, "pass"),
+			asm.LoadMem(asm.R4, asm.R2, int16(nameOff+i), asm.Byte),
+			asm.JEq.Imm(asm.R4, 0, fmt.Sprintf("name_end_%d", i)),
+			asm.JLT.Imm(asm.R4, 'A', store),
+			asm.JGT.Imm(asm.R4, 'Z', store),
+			asm.Or.Imm(asm.R4, 0x20),
+			asm.StoreMem(asm.RFP, int16(stackKey+i), asm.R4, asm.Byte).WithSymbol(store),
+		)
+	}
+	ins = append(ins, asm.Ja.Label("pass"))
+	for i := 0; i < MaxNameLen; i++ {
+		ins = append(ins,
+			asm.Mov.Imm(asm.R0, int32(i+1)).WithSymbol(fmt.Sprintf("name_end_%d", i)),
+			asm.Ja.Label("name_done"),
+		)
+	}
+
+	lookup := asm.LoadMapPtr(asm.R1, 0).WithSymbol("lookup")
+	if err := lookup.AssociateMap(answers); err != nil {
+		panic(err) // answers is always a valid map
+	}
+
+	ins = append(ins,
+		// The end of qname must match r9. Xor doesn't narrow r9 into
+		// a constant.
+		asm.Xor.Reg(asm.R0, asm.R9).WithSymbol("name_done"),
+		asm.JNE.Imm(asm.R0, 0, "pass"),
+
+		// r4: qtype
+		asm.Mov.Reg(asm.R4, asm.R2),
+		asm.Add.Imm(asm.R4, nameOff),
+		asm.Add.Reg(asm.R4, asm.R9),
+		asm.Mov.Reg(asm.R5, asm.R4),
+		asm.Add.Imm(asm.R5, 4),
+		asm.JGT.Reg(asm.R5, asm.R3, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 2, asm.Half),
+		asm.JNE.Imm(asm.R5, be16(dns.ClassINET), "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 0, asm.Half),
+		asm.StoreMem(asm.RFP, stackKey+MaxNameLen, asm.R5, asm.Half),
+		asm.JNE.Imm(asm.R8, 0, "opt"),
+
+		// The response is built in place. Queries with trailing data
+		// (e.g. ethernet padding) are passed.
+		asm.Mov.Reg(asm.R5, asm.R4),
+		asm.Add.Imm(asm.R5, 4),
+		asm.JLT.Reg(asm.R5, asm.R3, "pass"),
+		asm.Mov.Imm(asm.R5, dns.MinMsgSize),
+		asm.StoreMem(asm.RFP, stackMaxSize, asm.R5, asm.DWord),
+		asm.Ja.Label("lookup"),
+
+		// A minimal OPT without options, DO bit and extended rcode.
+		asm.Mov.Reg(asm.R5, asm.R4).WithSymbol("opt"),
+		asm.Add.Imm(asm.R5, 4+optLen),
+		asm.JGT.Reg(asm.R5, asm.R3, "pass"),
+		asm.JLT.Reg(asm.R5, asm.R3, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 4, asm.Byte),
+		asm.JNE.Imm(asm.R5, 0, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 5, asm.Half),
+		asm.JNE.Imm(asm.R5, be16(dns.TypeOPT), "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 9, asm.Half),
+		asm.JNE.Imm(asm.R5, 0, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 11, asm.Byte),
+		asm.And.Imm(asm.R5, 0x80),
+		asm.JNE.Imm(asm.R5, 0, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 13, asm.Half),
+		asm.JNE.Imm(asm.R5, 0, "pass"),
+		asm.LoadMem(asm.R5, asm.R4, 7, asm.Half),
+		asm.HostTo(asm.BE, asm.R5, asm.Half),
+		asm.JGE.Imm(asm.R5, dns.MinMsgSize, "opt_size"),
+		asm.Mov.Imm(asm.R5, dns.MinMsgSize),
+		asm.StoreMem(asm.RFP, stackMaxSize, asm.R5, asm.DWord).WithSymbol("opt_size"),
+
+		lookup,
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, stackKey),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "pass"),
+		asm.Mov.Reg(asm.R7, asm.R0),
+		asm.FnKtimeGetNs.Call(),
+		asm.LoadMem(asm.R1, asm.R7, valueExpireOff, asm.DWord),
+		asm.JGT.Reg(asm.R0, asm.R1, "pass"),
+
+		// r1: data length, r2: dns response length
+		asm.LoadMem(asm.R1, asm.R7, valueLenOff, asm.Half),
+		asm.JGT.Imm(asm.R1, MaxAnswerLen, "pass"),
+		asm.StoreMem(asm.RFP, stackLen, asm.R1, asm.DWord),
+		asm.Mov.Reg(asm.R2, asm.R1),
+		asm.Add.Reg(asm.R2, asm.R9),
+		asm.Add.Imm(asm.R2, 12+4),
+		asm.JEq.Imm(asm.R8, 0, "check_size"),
+		asm.Add.Imm(asm.R2, optLen),
+		asm.LoadMem(asm.R3, asm.RFP, stackMaxSize, asm.DWord).WithSymbol("check_size"),
+		asm.JGT.Reg(asm.R2, asm.R3, "pass"),
+		asm.StoreMem(asm.RFP, stackDNSLen, asm.R2, asm.DWord),
+
+		// Grow the packet for the data. The OPT of the query, if any,
+		// is replaced by our own after the data.
+		asm.Mov.Reg(asm.R2, asm.R1),
+		asm.Mov.Reg(asm.R1, asm.R6),
+		asm.FnXdpAdjustTail.Call(),
+		asm.JNE.Imm(asm.R0, 0, "pass"),
+		asm.LoadMem(asm.R2, asm.R6, 0, asm.Word),
+		asm.LoadMem(asm.R3, asm.R6, 4, asm.Word),
+		asm.LoadMem(asm.R1, asm.RFP, stackLen, asm.DWord),
+	)
+
+	// Packet is modified from here. Errors drop the packet.
+	for i := 0; i < MaxAnswerLen/8; i++ {
+		ins = append(ins,
+			asm.JLT.Imm(asm.R1, int32(i*8+8), "copy_tail"),
+			asm.Mov.Reg(asm.R4, asm.R2),
+			asm.Add.Imm(asm.R4, int32(nameOff+4+i*8)),
+			asm.Add.Reg(asm.R4, asm.R9),
+			asm.Mov.Reg(asm.R5, asm.R4),
+			asm.Add.Imm(asm.R5, 8),
+			asm.JGT.Reg(asm.R5, asm.R3, "drop"),
+			asm.LoadMem(asm.R5, asm.R7, int16(valueDataOff+i*8), asm.DWord),
+			asm.StoreMem(asm.R4, 0, asm.R5, asm.DWord),
+		)
+	}
+	// r4: offset of the tail, r5: length of the tail
+	ins = append(ins,
+		asm.Mov.Reg(asm.R4, asm.R1).WithSymbol("copy_tail"),
+		asm.And.Imm(asm.R4, 0x1f8),
+		asm.Mov.Reg(asm.R5, asm.R1),
+		asm.And.Imm(asm.R5, 7),
+	)
+	for i := 0; i < 7; i++ {
+		ins = append(ins,
+			asm.JLE.Imm(asm.R5, int32(i), "copy_done"),
+			asm.Mov.Reg(asm.R0, asm.R2),
+			asm.Add.Imm(asm.R0, int32(nameOff+4+i)),
+			asm.Add.Reg(asm.R0, asm.R9),
+			asm.Add.Reg(asm.R0, asm.R4),
+			asm.Mov.Reg(asm.R1, asm.R0),
+			asm.Add.Imm(asm.R1, 1),
+			asm.JGT.Reg(asm.R1, asm.R3, "drop"),
+			asm.Mov.Reg(asm.R1, asm.R7),
+			asm.Add.Reg(asm.R1, asm.R4),
+			asm.LoadMem(asm.R1, asm.R1, int16(valueDataOff+i), asm.Byte),
+			asm.StoreMem(asm.R0, 0, asm.R1, asm.Byte),
+		)
+	}
+
+	hits := asm.StoreXAdd(asm.R7, asm.R1, asm.DWord)
+	hits.Offset = valueHitsOff
+
+	ins = append(ins,
+		asm.JEq.Imm(asm.R8, 0, "headers").WithSymbol("copy_done"),
+		asm.LoadMem(asm.R1, asm.RFP, stackLen, asm.DWord),
+		asm.Mov.Reg(asm.R4, asm.R2),
+		asm.Add.Imm(asm.R4, nameOff+4),
+		asm.Add.Reg(asm.R4, asm.R9),
+		asm.Add.Reg(asm.R4, asm.R1),
+		asm.Mov.Reg(asm.R5, asm.R4),
+		asm.Add.Imm(asm.R5, optLen),
+		asm.JGT.Reg(asm.R5, asm.R3, "drop"),
+		asm.StoreImm(asm.R4, 0, 0, asm.Byte),
+		asm.StoreImm(asm.R4, 1, int64(be16(dns.TypeOPT)), asm.Half),
+		asm.StoreImm(asm.R4, 3, int64(be16(ednsUDPSize)), asm.Half),
+		asm.StoreImm(asm.R4, 5, 0, asm.Word),
+		asm.StoreImm(asm.R4, 9, 0, asm.Half),
+
+		asm.Mov.Reg(asm.R4, asm.R2).WithSymbol("headers"),
+		asm.Add.Imm(asm.R4, nameOff),
+		asm.JGT.Reg(asm.R4, asm.R3, "drop"),
+
+		// swap mac
+		asm.LoadMem(asm.R4, asm.R2, 0, asm.Word),
+		asm.LoadMem(asm.R5, asm.R2, 4, asm.Half),
+		asm.LoadMem(asm.R0, asm.R2, 6, asm.Word),
+		asm.LoadMem(asm.R1, asm.R2, 10, asm.Half),
+		asm.StoreMem(asm.R2, 0, asm.R0, asm.Word),
+		asm.StoreMem(asm.R2, 4, asm.R1, asm.Half),
+		asm.StoreMem(asm.R2, 6, asm.R4, asm.Word),
+		asm.StoreMem(asm.R2, 10, asm.R5, asm.Half),
+
+		// swap ip, set total length, ttl and checksum
+		asm.LoadMem(asm.R4, asm.R2, ipOff+12, asm.Word),
+		asm.LoadMem(asm.R5, asm.R2, ipOff+16, asm.Word),
+		asm.StoreMem(asm.R2, ipOff+12, asm.R5, asm.Word),
+		asm.StoreMem(asm.R2, ipOff+16, asm.R4, asm.Word),
+		asm.LoadMem(asm.R1, asm.RFP, stackDNSLen, asm.DWord),
+		asm.Mov.Reg(asm.R4, asm.R1),
+		asm.Add.Imm(asm.R4, 20+8),
+		asm.HostTo(asm.BE, asm.R4, asm.Half),
+		asm.StoreMem(asm.R2, ipOff+2, asm.R4, asm.Half),
+		asm.StoreImm(asm.R2, ipOff+8, 64, asm.Byte),
+		asm.StoreImm(asm.R2, ipOff+10, 0, asm.Half),
+		asm.Mov.Imm(asm.R4, 0),
+	)
+	for i := 0; i < 10; i++ {
+		ins = append(ins,
+			asm.LoadMem(asm.R5, asm.R2, int16(ipOff+i*2), asm.Half),
+			asm.Add.Reg(asm.R4, asm.R5),
+		)
+	}
+	for i := 0; i < 2; i++ {
+		ins = append(ins,
+			asm.Mov.Reg(asm.R5, asm.R4),
+			asm.RSh.Imm(asm.R5, 16),
+			asm.And.Imm(asm.R4, 0xffff),
+			asm.Add.Reg(asm.R4, asm.R5),
+		)
+	}
+	ins = append(ins,
+		asm.Xor.Imm(asm.R4, 0xffff),
+		asm.StoreMem(asm.R2, ipOff+10, asm.R4, asm.Half),
+
+		// swap udp ports, set length, no checksum
+		asm.LoadMem(asm.R4, asm.R2, udpOff, asm.Half),
+		asm.LoadMem(asm.R5, asm.R2, udpOff+2, asm.Half),
+		asm.StoreMem(asm.R2, udpOff, asm.R5, asm.Half),
+		asm.StoreMem(asm.R2, udpOff+2, asm.R4, asm.Half),
+		asm.Mov.Reg(asm.R4, asm.R1),
+		asm.Add.Imm(asm.R4, 8),
+		asm.HostTo(asm.BE, asm.R4, asm.Half),
+		asm.StoreMem(asm.R2, udpOff+4, asm.R4, asm.Half),
+		asm.StoreImm(asm.R2, udpOff+6, 0, asm.Half),
+
+		// dns header, id and question are kept
+		asm.LoadMem(asm.R4, asm.R2, dnsOff+2, asm.Byte),
+		asm.And.Imm(asm.R4, 0x01),
+		asm.LoadMem(asm.R5, asm.R7, valueFlags2Off, asm.Byte),
+		asm.Or.Reg(asm.R4, asm.R5),
+		asm.StoreMem(asm.R2, dnsOff+2, asm.R4, asm.Byte),
+		asm.LoadMem(asm.R4, asm.R7, valueFlags3Off, asm.Byte),
+		asm.StoreMem(asm.R2, dnsOff+3, asm.R4, asm.Byte),
+		asm.LoadMem(asm.R4, asm.R7, valueAnOff, asm.Half),
+		asm.HostTo(asm.BE, asm.R4, asm.Half),
+		asm.StoreMem(asm.R2, dnsOff+6, asm.R4, asm.Half),
+		asm.LoadMem(asm.R4, asm.R7, valueNsOff, asm.Half),
+		asm.HostTo(asm.BE, asm.R4, asm.Half),
+		asm.StoreMem(asm.R2, dnsOff+8, asm.R4, asm.Half),
+		asm.LoadMem(asm.R4, asm.R7, valueArOff, asm.Half),
+		asm.Add.Reg(asm.R4, asm.R8),
+		asm.HostTo(asm.BE, asm.R4, asm.Half),
+		asm.StoreMem(asm.R2, dnsOff+10, asm.R4, asm.Half),
+
+		asm.Mov.Imm(asm.R1, 1),
+		hits,
+		asm.Mov.Imm(asm.R0, xdpTx),
+		asm.Return(),
+
+		asm.Mov.Imm(asm.R0, xdpPass).WithSymbol("pass"),
+		asm.Return(),
+		asm.Mov.Imm(asm.R0, xdpDrop).WithSymbol("drop"),
+		asm.Return(),
+	)
+	return ins
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package xdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// MaxNameLen is the maximum wire length of a qname that can be
+	// answered by the xdp program.
+	MaxNameLen = 64
+	// MaxAnswerLen is the maximum length of the packed answer, authority
+	// and additional sections of an entry.
+	MaxAnswerLen = 504
+
+	keySize   = MaxNameLen + 4 // name + qtype + padding
+	valueSize = valueDataOff + MaxAnswerLen + 8
+
+	// value layout
+	valueExpireOff = 0  // u64, CLOCK_MONOTONIC ns
+	valueHitsOff   = 8  // u64, updated by the program
+	valueFlags2Off = 16 // u8, 3rd header byte, without RD
+	valueFlags3Off = 17 // u8, 4th header byte
+	valueAnOff     = 18 // u16
+	valueNsOff     = 20 // u16
+	valueArOff     = 22 // u16, without OPT
+	valueLenOff    = 24 // u16, length of data
+	valueDataOff   = 32
+
+	defaultMaxEntries = 128
+)
+
+var (
+	errNameTooLong = errors.New("qname is too long")
+	errRespTooLong = errors.New("packed response is too long")
+	errInvalidName = errors.New("qname contains unsupported characters")
+)
+
+type Opts struct {
+	// Iface is the name of the network interface that the program
+	// will be attached to. Required.
+	Iface string
+
+	// Port is the udp destination port of the queries. Default is 53.
+	Port uint16
+
+	// MaxEntries is the maximum number of entries. Default is 128.
+	MaxEntries int
+
+	// Clients, if not empty, restricts the program to answer queries from
+	// these ipv4 prefixes only. Queries from other clients are passed.
+	// Empty means all clients.
+	Clients []netip.Prefix
+}
+
+func (opts *Opts) init() {
+	if opts.Port == 0 {
+		opts.Port = 53
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultMaxEntries
+	}
+}
+
+// Key is the question of an Entry.
+type Key struct {
+	Name  string // lower case fqdn
+	Qtype uint16
+}
+
+// Entry is an answer that will be served by the xdp program.
+type Entry struct {
+	Key
+
+	// Resp is the response. Its question section is ignored. The ttls
+	// of its records are served as is until Expire.
+	Resp *dns.Msg
+
+	// Expire is the time after which the program stops answering
+	// this entry and passes the queries to userspace.
+	Expire time.Time
+}
+
+// packKey packs the map key of name and qtype.
+func packKey(k Key) ([]byte, error) {
+	if !isSimpleName(k.Name) {
+		return nil, errInvalidName
+	}
+	b := make([]byte, keySize)
+	n, err := dns.PackDomainName(k.Name, b[:MaxNameLen], 0, nil, false)
+	if err != nil {
+		return nil, errNameTooLong
+	}
+	if n > MaxNameLen {
+		return nil, errNameTooLong
+	}
+	binary.BigEndian.PutUint16(b[MaxNameLen:], k.Qtype)
+	return b, nil
+}
+
+// isSimpleName reports whether name is a lower case fqdn that only
+// contains letters, digits, '-', '_' and '*'. A zero byte can never
+// appear in the labels of such a name, which is what the xdp program
+// relies on to find the end of the qname.
+func isSimpleName(name string) bool {
+	if !dns.IsFqdn(name) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '*', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// packValue packs the map value of e. expire is the expiration time in
+// CLOCK_MONOTONIC ns.
+func packValue(e Entry, expire uint64) ([]byte, error) {
+	r := new(dns.Msg)
+	r.MsgHdr = e.Resp.MsgHdr
+	r.Id = 0
+	r.Response = true
+	r.RecursionAvailable = true
+	r.Question = []dns.Question{{Name: e.Name, Qtype: e.Qtype, Qclass: dns.ClassINET}}
+	r.Answer = e.Resp.Answer
+	r.Ns = e.Resp.Ns
+	for _, rr := range e.Resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			r.Extra = append(r.Extra, rr)
+		}
+	}
+	r.Compress = true
+	b, err := r.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack response, %w", err)
+	}
+
+	// Compression pointers in the answers are relative to the packet
+	// start. They stay valid in the responses built by the program
+	// because the question in the query has the same length.
+	qEnd := 12 + len(e.Name) + 1 + 4
+	if e.Name == "." {
+		qEnd = 12 + 1 + 4
+	}
+	data := b[qEnd:]
+	if len(data) > MaxAnswerLen {
+		return nil, errRespTooLong
+	}
+
+	v := make([]byte, valueSize)
+	binary.NativeEndian.PutUint64(v[valueExpireOff:], expire)
+	v[valueFlags2Off] = b[2] &^ 0x01 // RD is copied from the query
+	v[valueFlags3Off] = b[3]
+	binary.NativeEndian.PutUint16(v[valueAnOff:], uint16(len(r.Answer)))
+	binary.NativeEndian.PutUint16(v[valueNsOff:], uint16(len(r.Ns)))
+	binary.NativeEndian.PutUint16(v[valueArOff:], uint16(len(r.Extra)))
+	binary.NativeEndian.PutUint16(v[valueLenOff:], uint16(len(data)))
+	copy(v[valueDataOff:], data)
+	return v, nil
+}
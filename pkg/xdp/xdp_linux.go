@@ -0,0 +1,268 @@
+//go:build linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package xdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// Responder answers queries from its entries in the kernel. Queries
+// that cannot be answered are passed to the network stack.
+// Only udp queries over ipv4 are supported.
+type Responder struct {
+	maxEntries int
+	answers    *ebpf.Map
+	clients    *ebpf.Map // nil if all clients are allowed
+	prog       *ebpf.Program
+	ifindex    int // 0 if not attached
+
+	m    sync.Mutex
+	keys map[Key][]byte // current entries and their map keys
+}
+
+// attachment is an xdp link and the Responder whose program is running
+// on it.
+type attachment struct {
+	link  link.Link
+	owner *Responder
+}
+
+var (
+	attachedM sync.Mutex
+	attached  = make(map[int]*attachment) // by ifindex
+)
+
+// NewResponder loads the xdp program and attaches it to opts.Iface.
+// If another Responder is attached to the interface (e.g. the old
+// instance during a hot reload), its link is taken over and updated
+// to run the new program.
+// It requires linux 5.9+ and CAP_BPF, CAP_NET_ADMIN.
+func NewResponder(opts Opts) (*Responder, error) {
+	if len(opts.Iface) == 0 {
+		return nil, errors.New("missing interface")
+	}
+	iface, err := net.InterfaceByName(opts.Iface)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newResponder(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	attachedM.Lock()
+	defer attachedM.Unlock()
+	if a := attached[iface.Index]; a != nil {
+		if err := a.link.Update(r.prog); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to replace xdp program, %w", err)
+		}
+		a.owner = r
+	} else {
+		l, err := link.AttachXDP(link.XDPOptions{Program: r.prog, Interface: iface.Index})
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to attach xdp program, %w", err)
+		}
+		attached[iface.Index] = &attachment{link: l, owner: r}
+	}
+	r.ifindex = iface.Index
+	return r, nil
+}
+
+// newResponder loads the program without attaching it.
+func newResponder(opts Opts) (*Responder, error) {
+	opts.init()
+	answers, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "mosdns_answers",
+		Type:       ebpf.Hash,
+		KeySize:    keySize,
+		ValueSize:  valueSize,
+		MaxEntries: uint32(opts.MaxEntries),
+		// Values are read by the program without a lock. Updated
+		// values must not be reused before the readers are done.
+		Flags: unix.BPF_F_NO_PREALLOC,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create map, %w", err)
+	}
+	var clients *ebpf.Map
+	if len(opts.Clients) > 0 {
+		clients, err = newClientsMap(opts.Clients)
+		if err != nil {
+			answers.Close()
+			return nil, err
+		}
+	}
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "mosdns_xdp",
+		Type:         ebpf.XDP,
+		License:      "GPL",
+		Instructions: instructions(opts.Port, answers, clients),
+	})
+	if err != nil {
+		answers.Close()
+		if clients != nil {
+			clients.Close()
+		}
+		return nil, fmt.Errorf("failed to load xdp program, %w", err)
+	}
+	return &Responder{
+		maxEntries: opts.MaxEntries,
+		answers:    answers,
+		clients:    clients,
+		prog:       prog,
+		keys:       make(map[Key][]byte),
+	}, nil
+}
+
+// newClientsMap creates a lpm trie of ipv4 prefixes.
+func newClientsMap(prefixes []netip.Prefix) (*ebpf.Map, error) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "mosdns_clients",
+		Type:       ebpf.LPMTrie,
+		KeySize:    8,
+		ValueSize:  1,
+		MaxEntries: uint32(len(prefixes)),
+		Flags:      unix.BPF_F_NO_PREALLOC,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clients map, %w", err)
+	}
+	for _, p := range prefixes {
+		if !p.Addr().Is4() {
+			m.Close()
+			return nil, fmt.Errorf("invalid client prefix %s, only ipv4 is supported", p)
+		}
+		k := binary.NativeEndian.AppendUint32(make([]byte, 0, 8), uint32(p.Bits()))
+		k = append(k, p.Masked().Addr().AsSlice()...)
+		if err := m.Put(k, []byte{1}); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to update clients map, %w", err)
+		}
+	}
+	return m, nil
+}
+
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	_ = unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return uint64(ts.Nano())
+}
+
+// Update replaces all entries with entries. Entries that cannot be
+// answered by the program (e.g. names or responses are too long) and
+// expired entries are skipped. If there are too many entries, the
+// tailing entries are skipped. If entries have duplicated Key, the
+// first one is used.
+// It returns the number of entries that were loaded.
+func (r *Responder) Update(entries []Entry) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	now := time.Now()
+	monoNow := monotonicNow()
+	keys := make(map[Key][]byte, len(entries))
+	for _, e := range entries {
+		if len(keys) >= r.maxEntries {
+			break
+		}
+		if _, dup := keys[e.Key]; dup {
+			continue
+		}
+		ttl := e.Expire.Sub(now)
+		if ttl <= 0 {
+			continue
+		}
+		k, err := packKey(e.Key)
+		if err != nil {
+			continue
+		}
+		v, err := packValue(e, monoNow+uint64(ttl))
+		if err != nil {
+			continue
+		}
+		if err := r.answers.Put(k, v); err != nil {
+			return len(keys), fmt.Errorf("failed to update map, %w", err)
+		}
+		keys[e.Key] = k
+	}
+
+	for key, k := range r.keys {
+		if _, ok := keys[key]; !ok {
+			if err := r.answers.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				return len(keys), fmt.Errorf("failed to delete map key, %w", err)
+			}
+		}
+	}
+	r.keys = keys
+	return len(keys), nil
+}
+
+// Hits returns the number of queries that were answered by each entry
+// since its last Update.
+func (r *Responder) Hits() map[Key]uint64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	m := make(map[Key]uint64)
+	v := make([]byte, valueSize)
+	for key, k := range r.keys {
+		if err := r.answers.Lookup(k, v); err != nil {
+			continue
+		}
+		if n := binary.NativeEndian.Uint64(v[valueHitsOff:]); n > 0 {
+			m[key] = n
+		}
+	}
+	return m
+}
+
+// Close detaches and unloads the program. The program is not detached
+// if its link was taken over by a newer Responder.
+func (r *Responder) Close() error {
+	var err error
+	if r.ifindex != 0 {
+		attachedM.Lock()
+		if a := attached[r.ifindex]; a != nil && a.owner == r {
+			err = a.link.Close()
+			delete(attached, r.ifindex)
+		}
+		attachedM.Unlock()
+	}
+	r.prog.Close()
+	r.answers.Close()
+	if r.clients != nil {
+		r.clients.Close()
+	}
+	return err
+}
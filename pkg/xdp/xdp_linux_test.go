@@ -0,0 +1,351 @@
+//go:build linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package xdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/miekg/dns"
+)
+
+func checksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return ^uint16(s)
+}
+
+func buildPacket(t *testing.T, q *dns.Msg, port uint16) []byte {
+	t.Helper()
+	m, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, nameOff-12, nameOff-12+len(m))
+	copy(b[0:6], []byte{2, 0, 0, 0, 0, 1})  // dst mac
+	copy(b[6:12], []byte{2, 0, 0, 0, 0, 2}) // src mac
+	binary.BigEndian.PutUint16(b[12:], 0x0800)
+	ip := b[ipOff:udpOff]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+8+len(m)))
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:16], net.IPv4(192, 168, 1, 2).To4())
+	copy(ip[16:20], net.IPv4(192, 168, 1, 1).To4())
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	udp := b[udpOff:dnsOff]
+	binary.BigEndian.PutUint16(udp[0:], 40000)
+	binary.BigEndian.PutUint16(udp[2:], port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(m)))
+	return append(b, m...)
+}
+
+func skipIfNotSupported(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, ebpf.ErrNotSupported) || errors.Is(err, os.ErrPermission) {
+		t.Skipf("xdp is not available, %s", err)
+	}
+}
+
+func newTestResponder(t *testing.T, opts Opts) *Responder {
+	t.Helper()
+	r, err := newResponder(opts)
+	if err != nil {
+		skipIfNotSupported(t, err)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func run(t *testing.T, r *Responder, pkt []byte) (uint32, []byte) {
+	t.Helper()
+	opts := &ebpf.RunOptions{Data: pkt, DataOut: make([]byte, 4096)}
+	ret, err := r.prog.Run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret, opts.DataOut
+}
+
+func Test_Responder(t *testing.T) {
+	r := newTestResponder(t, Opts{})
+
+	resp := new(dns.Msg)
+	resp.SetQuestion("example.com.", dns.TypeA)
+	resp.Response = true
+	resp.Authoritative = true
+	for i := 0; i < 3; i++ {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(1, 2, 3, byte(i)),
+		})
+	}
+	nx := new(dns.Msg)
+	nx.Rcode = dns.RcodeNameError
+	nx.Ns = []dns.RR{&dns.SOA{
+		Hdr:  dns.RR_Header{Name: "com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:   "a.gtld-servers.net.",
+		Mbox: "nstld.verisign-grs.com.",
+	}}
+	expire := time.Now().Add(time.Hour)
+	n, err := r.Update([]Entry{
+		{Key: Key{Name: "example.com.", Qtype: dns.TypeA}, Resp: resp, Expire: expire},
+		{Key: Key{Name: "nx.com.", Qtype: dns.TypeAAAA}, Resp: nx, Expire: expire},
+		{Key: Key{Name: "expired.com.", Qtype: dns.TypeA}, Resp: resp, Expire: time.Now().Add(-time.Second)},
+		{Key: Key{Name: "Invalid.com.", Qtype: dns.TypeA}, Resp: resp, Expire: expire},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("want 2 entries loaded, got %d", n)
+	}
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		edns    bool
+		do      bool
+		cd      bool
+		wantTx  bool
+		wantR   *dns.Msg
+		wantOpt bool
+	}{
+		{name: "hit", qname: "example.com.", qtype: dns.TypeA, wantTx: true, wantR: resp},
+		{name: "hit mixed case", qname: "ExAmple.COM.", qtype: dns.TypeA, wantTx: true, wantR: resp},
+		{name: "hit edns", qname: "example.com.", qtype: dns.TypeA, edns: true, wantTx: true, wantR: resp, wantOpt: true},
+		{name: "hit nxdomain", qname: "nx.com.", qtype: dns.TypeAAAA, wantTx: true, wantR: nx},
+		{name: "do bit", qname: "example.com.", qtype: dns.TypeA, edns: true, do: true},
+		{name: "cd bit", qname: "example.com.", qtype: dns.TypeA, cd: true},
+		{name: "qtype miss", qname: "example.com.", qtype: dns.TypeAAAA},
+		{name: "name miss", qname: "example.org.", qtype: dns.TypeA},
+		{name: "sub domain miss", qname: "a.example.com.", qtype: dns.TypeA},
+		{name: "expired", qname: "expired.com.", qtype: dns.TypeA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qname, tt.qtype)
+			q.Id = 0x1234
+			q.CheckingDisabled = tt.cd
+			if tt.edns {
+				q.SetEdns0(4096, tt.do)
+			}
+			ret, out := run(t, r, buildPacket(t, q, 53))
+			if !tt.wantTx {
+				if ret != xdpPass {
+					t.Fatalf("want pass, got %d", ret)
+				}
+				return
+			}
+			if ret != xdpTx {
+				t.Fatalf("want tx, got %d", ret)
+			}
+
+			ip := out[ipOff:udpOff]
+			if checksum(ip) != 0 {
+				t.Fatal("invalid ip checksum")
+			}
+			if !net.IP(ip[12:16]).Equal(net.IPv4(192, 168, 1, 1)) || !net.IP(ip[16:20]).Equal(net.IPv4(192, 168, 1, 2)) {
+				t.Fatal("ip addresses are not swapped")
+			}
+			if int(binary.BigEndian.Uint16(ip[2:])) != len(out)-ipOff {
+				t.Fatal("invalid ip total length")
+			}
+			udp := out[udpOff:dnsOff]
+			if binary.BigEndian.Uint16(udp[0:]) != 53 || binary.BigEndian.Uint16(udp[2:]) != 40000 {
+				t.Fatal("udp ports are not swapped")
+			}
+			if int(binary.BigEndian.Uint16(udp[4:])) != len(out)-udpOff {
+				t.Fatal("invalid udp length")
+			}
+
+			m := new(dns.Msg)
+			if err := m.Unpack(out[dnsOff:]); err != nil {
+				t.Fatal(err)
+			}
+			if m.Id != q.Id || !m.Response || !m.RecursionDesired || !m.RecursionAvailable {
+				t.Fatalf("invalid header, %s", m.MsgHdr.String())
+			}
+			if m.Question[0] != q.Question[0] {
+				t.Fatalf("question changed, %v", m.Question[0])
+			}
+			if m.Rcode != tt.wantR.Rcode || m.Authoritative != tt.wantR.Authoritative {
+				t.Fatalf("invalid rcode or aa bit, %s", m.MsgHdr.String())
+			}
+			if len(m.Answer) != len(tt.wantR.Answer) || len(m.Ns) != len(tt.wantR.Ns) {
+				t.Fatalf("invalid response, %s", m)
+			}
+			for i := range m.Answer {
+				m.Answer[i].Header().Name = dns.CanonicalName(m.Answer[i].Header().Name)
+				if !dns.IsDuplicate(m.Answer[i], tt.wantR.Answer[i]) {
+					t.Fatalf("invalid answer, want %s, got %s", tt.wantR.Answer[i], m.Answer[i])
+				}
+			}
+			if (m.IsEdns0() != nil) != tt.wantOpt {
+				t.Fatalf("want opt %v, got %s", tt.wantOpt, m)
+			}
+		})
+	}
+
+	hits := r.Hits()
+	if hits[Key{Name: "example.com.", Qtype: dns.TypeA}] != 3 || hits[Key{Name: "nx.com.", Qtype: dns.TypeAAAA}] != 1 {
+		t.Fatalf("invalid hits, %v", hits)
+	}
+
+	if _, err := r.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	if ret, _ := run(t, r, buildPacket(t, q, 53)); ret != xdpPass {
+		t.Fatalf("want pass after entries are removed, got %d", ret)
+	}
+}
+
+func Test_Responder_udpSize(t *testing.T) {
+	r := newTestResponder(t, Opts{})
+
+	const name = "a-rather-long-name-for-testing.example.com."
+
+	resp := new(dns.Msg)
+	for i := 0; i < 30; i++ {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(1, 2, 3, byte(i)),
+		})
+	}
+	if _, err := r.Update([]Entry{{Key: Key{Name: name, Qtype: dns.TypeA}, Resp: resp, Expire: time.Now().Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeA)
+	if ret, _ := run(t, r, buildPacket(t, q, 53)); ret != xdpPass {
+		t.Fatalf("response is bigger than 512 bytes, want pass, got %d", ret)
+	}
+	q.SetEdns0(1232, false)
+	ret, out := run(t, r, buildPacket(t, q, 53))
+	if ret != xdpTx {
+		t.Fatalf("want tx, got %d", ret)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(out[dnsOff:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answer) != 30 {
+		t.Fatalf("want 30 answers, got %d", len(m.Answer))
+	}
+}
+
+func Test_Responder_clients(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(1, 2, 3, 4),
+	}}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Queries in buildPacket are sent from 192.168.1.2.
+	tests := []struct {
+		clients []netip.Prefix
+		wantTx  bool
+	}{
+		{nil, true},
+		{[]netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}, true},
+		{[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.2/32")}, true},
+		{[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, false},
+		{[]netip.Prefix{netip.MustParsePrefix("192.168.1.3/32")}, false},
+	}
+	for _, tt := range tests {
+		r := newTestResponder(t, Opts{Clients: tt.clients})
+		if _, err := r.Update([]Entry{{Key: Key{Name: "example.com.", Qtype: dns.TypeA}, Resp: resp, Expire: time.Now().Add(time.Hour)}}); err != nil {
+			t.Fatal(err)
+		}
+		want := uint32(xdpPass)
+		if tt.wantTx {
+			want = xdpTx
+		}
+		if ret, _ := run(t, r, buildPacket(t, q, 53)); ret != want {
+			t.Errorf("clients %v: want %d, got %d", tt.clients, want, ret)
+		}
+	}
+
+	if _, err := newResponder(Opts{Clients: []netip.Prefix{netip.MustParsePrefix("fd00::/8")}}); err == nil {
+		t.Fatal("ipv6 prefix should be rejected")
+	}
+}
+
+func Test_NewResponder_reload(t *testing.T) {
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+	r1, err := NewResponder(Opts{Iface: iface.Name})
+	if err != nil {
+		skipIfNotSupported(t, err)
+		t.Fatal(err)
+	}
+	defer r1.Close()
+
+	// A new instance takes over the link instead of failing with EBUSY.
+	r2, err := NewResponder(Opts{Iface: iface.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	// Closing the old instance must not detach the new program.
+	if err := r1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attachedM.Lock()
+	a := attached[iface.Index]
+	attachedM.Unlock()
+	if a == nil || a.owner != r2 {
+		t.Fatal("program was detached by the old instance")
+	}
+
+	if err := r2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attachedM.Lock()
+	a = attached[iface.Index]
+	attachedM.Unlock()
+	if a != nil {
+		t.Fatal("program was not detached")
+	}
+}
@@ -28,7 +28,8 @@ import (
 )
 
 type Matcher struct {
-	m map[dns.Question][]dns.RR
+	m     map[dns.Question][]dns.RR
+	types map[string][]uint16 // lower case name -> types of the name
 }
 
 func (m *Matcher) LoadFile(s string) error {
@@ -44,6 +45,7 @@ func (m *Matcher) LoadFile(s string) error {
 func (m *Matcher) Load(r io.Reader) error {
 	if m.m == nil {
 		m.m = make(map[dns.Question][]dns.RR)
+		m.types = make(map[string][]uint16)
 	}
 
 	parser := dns.NewZoneParser(r, "", "")
@@ -59,6 +61,9 @@ func (m *Matcher) Load(r io.Reader) error {
 			Qtype:  h.Rrtype,
 			Qclass: h.Class,
 		}
+		if _, ok := m.m[q]; !ok {
+			m.types[q.Name] = append(m.types[q.Name], q.Qtype)
+		}
 		m.m[q] = append(m.m[q], rr)
 	}
 	return parser.Err()
@@ -69,6 +74,11 @@ func (m *Matcher) Search(q dns.Question) []dns.RR {
 	return m.m[q]
 }
 
+// Types returns the types of records that exist at name.
+func (m *Matcher) Types(name string) []uint16 {
+	return m.types[strings.ToLower(name)]
+}
+
 func (m *Matcher) Reply(q *dns.Msg) *dns.Msg {
 	var r *dns.Msg
 	for _, question := range q.Question {
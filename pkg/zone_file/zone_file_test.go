@@ -57,4 +57,11 @@ func TestMatcher(t *testing.T) {
 	if got := r.Answer[0].(*dns.AAAA).AAAA.String(); got != "2001:db8:10::1" {
 		t.Fatalf("want ip 2001:db8:10::1, got %s", got)
 	}
+
+	if got := m.Types("1.EXAMPLE.com."); len(got) != 1 || got[0] != dns.TypeAAAA {
+		t.Fatalf("want types [AAAA], got %v", got)
+	}
+	if got := m.Types("2.example.com."); got != nil {
+		t.Fatalf("want no types, got %v", got)
+	}
 }
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package data_provider
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrLoading is returned by BackgroundLoader.Ready if the data is loading.
+var ErrLoading = errors.New("data is still loading in background")
+
+// BackgroundLoader loads data in background, so large data sets don't
+// block the startup. Providers should serve their previous (or empty)
+// data until the loading is done.
+// It implements coremain.ReadinessChecker, so the server is not ready
+// until the data is loaded.
+type BackgroundLoader struct {
+	loading atomic.Bool
+	err     atomic.Pointer[error]
+}
+
+// Start calls load in a new goroutine. load should atomically swap in
+// the loaded data.
+func (l *BackgroundLoader) Start(logger *zap.Logger, load func() error) {
+	l.loading.Store(true)
+	go func() {
+		defer l.loading.Store(false)
+		start := time.Now()
+		if err := load(); err != nil {
+			logger.Error("failed to load data in background", zap.Error(err))
+			l.err.Store(&err)
+			return
+		}
+		l.err.Store(nil)
+		logger.Info("data loaded in background", zap.Duration("elapsed", time.Since(start)))
+	}()
+}
+
+// Ready returns an error if the data is loading, or failed to load.
+func (l *BackgroundLoader) Ready() error {
+	if l.loading.Load() {
+		return ErrLoading
+	}
+	if err := l.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Loaded clears the error of the background loading. Providers call it
+// if their data was loaded by other means, e.g. a successful reload.
+func (l *BackgroundLoader) Loaded() {
+	l.err.Store(nil)
+}
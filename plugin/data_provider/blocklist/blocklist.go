@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"go.uber.org/zap"
+)
+
+const PluginType = "blocklist"
+
+const maxListSize = 64 << 20
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	Lists []ListArgs `yaml:"lists"`
+	// Dir keeps the last downloaded lists. They are used at startup and
+	// if downloads failed. Optional.
+	Dir string `yaml:"dir"`
+}
+
+// ListArgs is a subscription. One of URL and Path is required.
+type ListArgs struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	Path string `yaml:"path"`
+	// Format can be "domain" (default), "hosts", "adblock" or "mosdns".
+	Format string `yaml:"format"`
+	// Interval in seconds to refresh the list. Default is 86400.
+	Interval int `yaml:"interval"`
+	// Disabled lists are still refreshed but not matched. They can be
+	// enabled by the admin api.
+	Disabled bool `yaml:"disabled"`
+}
+
+func (a *ListArgs) init() {
+	utils.SetDefaultString(&a.Format, FormatDomain)
+	utils.SetDefaultUnsignNum(&a.Interval, 86400)
+}
+
+var _ data_provider.DomainMatcherProvider = (*Blocklist)(nil)
+var _ coremain.Reloader = (*Blocklist)(nil)
+var _ coremain.StateInspector = (*Blocklist)(nil)
+var _ coremain.SubscriptionSwitcher = (*Blocklist)(nil)
+
+// Blocklist manages blocklist subscriptions. Entries of enabled lists
+// are deduplicated and compiled into one matcher, which is rebuilt once
+// a list is refreshed, enabled or disabled. A list that failed to
+// refresh keeps its last entries.
+type Blocklist struct {
+	dir    string
+	logger *zap.Logger
+	client *http.Client
+
+	subs   []*subscription
+	byName map[string]*subscription
+
+	rebuildM sync.Mutex
+	compiled atomic.Pointer[compiled]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type compiled struct {
+	m     *domain.MixMatcher[struct{}]
+	total int
+}
+
+type subscription struct {
+	args    ListArgs
+	enabled atomic.Bool
+
+	m         sync.Mutex
+	entries   []string
+	skipped   int
+	updatedAt time.Time
+	lastErr   error
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewBlocklist(args.(*Args), bp.L())
+}
+
+func NewBlocklist(args *Args, logger *zap.Logger) (*Blocklist, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Blocklist{
+		dir:    args.Dir,
+		logger: logger,
+		client: &http.Client{Timeout: time.Second * 30},
+		byName: make(map[string]*subscription),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := range args.Lists {
+		la := args.Lists[i]
+		la.init()
+		if err := validName(la.Name); err != nil {
+			cancel()
+			return nil, err
+		}
+		if _, dup := p.byName[la.Name]; dup {
+			cancel()
+			return nil, fmt.Errorf("duplicated list name %s", la.Name)
+		}
+		if (len(la.URL) == 0) == (len(la.Path) == 0) {
+			cancel()
+			return nil, fmt.Errorf("list %s needs one of url and path", la.Name)
+		}
+		if !validFormat(la.Format) {
+			cancel()
+			return nil, fmt.Errorf("list %s has an unsupported format %s", la.Name, la.Format)
+		}
+		s := &subscription{args: la}
+		s.enabled.Store(!la.Disabled)
+		p.subs = append(p.subs, s)
+		p.byName[la.Name] = s
+	}
+	if len(p.dir) > 0 {
+		if err := os.MkdirAll(p.dir, 0755); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	// Lists with a copy in dir are used now and downloaded in background,
+	// so the startup is not blocked by slow downloads.
+	for _, s := range p.subs {
+		cached := p.loadCache(s)
+		if !cached {
+			if err := p.refresh(s); err != nil {
+				logger.Warn("failed to load list", zap.String("list", s.args.Name), zap.Error(err))
+			}
+		}
+		p.wg.Add(1)
+		go p.refreshLoop(s, cached)
+	}
+	p.rebuild()
+	return p, nil
+}
+
+func (p *Blocklist) GetDomainMatcher() domain.Matcher[struct{}] {
+	return domainMatcher{p: p}
+}
+
+type domainMatcher struct{ p *Blocklist }
+
+func (m domainMatcher) Match(s string) (struct{}, bool) {
+	return m.p.compiled.Load().m.Match(s)
+}
+
+// cachePath returns the path of the copy of s in dir. Empty if s does
+// not need one.
+func (p *Blocklist) cachePath(s *subscription) string {
+	if len(p.dir) == 0 || len(s.args.URL) == 0 {
+		return ""
+	}
+	return filepath.Join(p.dir, s.args.Name+".txt")
+}
+
+// loadCache loads s from its copy in dir. It reports whether s is loaded.
+func (p *Blocklist) loadCache(s *subscription) bool {
+	f := p.cachePath(s)
+	if len(f) == 0 {
+		return false
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return false
+	}
+	entries, skipped, err := parseList(b, s.args.Format)
+	if err != nil {
+		return false
+	}
+	var updatedAt time.Time
+	if fi, err := os.Stat(f); err == nil {
+		updatedAt = fi.ModTime()
+	}
+	s.m.Lock()
+	s.entries, s.skipped, s.updatedAt = entries, skipped, updatedAt
+	s.m.Unlock()
+	return true
+}
+
+// refresh loads s from its url or path. If it failed, s keeps its
+// entries and the error is recorded.
+func (p *Blocklist) refresh(s *subscription) error {
+	entries, skipped, err := p.fetch(s)
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.lastErr = err
+	if err != nil {
+		return err
+	}
+	s.entries, s.skipped, s.updatedAt = entries, skipped, time.Now()
+	p.logger.Info("list loaded", zap.String("list", s.args.Name), zap.Int("entries", len(entries)), zap.Int("skipped", skipped))
+	return nil
+}
+
+func (p *Blocklist) fetch(s *subscription) ([]string, int, error) {
+	if len(s.args.Path) > 0 {
+		b, err := os.ReadFile(s.args.Path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return parseList(b, s.args.Format)
+	}
+
+	b, err := p.download(s.args.URL)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, skipped, err := parseList(b, s.args.Format)
+	if err != nil {
+		return nil, 0, err
+	}
+	if f := p.cachePath(s); len(f) > 0 {
+		if err := writeFile(f, b); err != nil {
+			p.logger.Warn("failed to save list", zap.String("list", s.args.Name), zap.Error(err))
+		}
+	}
+	return entries, skipped, nil
+}
+
+func (p *Blocklist) download(url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxListSize {
+		return nil, errors.New("list is too large")
+	}
+	return b, nil
+}
+
+// writeFile writes b to f atomically.
+func writeFile(f string, b []byte) error {
+	tmp := f + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f)
+}
+
+func (p *Blocklist) refreshLoop(s *subscription, now bool) {
+	defer p.wg.Done()
+	refresh := func() {
+		if err := p.refresh(s); err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			p.logger.Warn("failed to refresh list", zap.String("list", s.args.Name), zap.Error(err))
+			return
+		}
+		p.rebuild()
+	}
+	if now {
+		refresh()
+	}
+	ticker := time.NewTicker(time.Duration(s.args.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// rebuild compiles the entries of enabled lists into a new matcher.
+func (p *Blocklist) rebuild() {
+	p.rebuildM.Lock()
+	defer p.rebuildM.Unlock()
+
+	set := make(map[string]struct{})
+	for _, s := range p.subs {
+		if !s.enabled.Load() {
+			continue
+		}
+		s.m.Lock()
+		for _, e := range s.entries {
+			set[e] = struct{}{}
+		}
+		s.m.Unlock()
+	}
+	m := domain.NewDomainMixMatcher()
+	for e := range set {
+		_ = m.Add(e, struct{}{}) // entries were validated by parseList.
+	}
+	p.compiled.Store(&compiled{m: m, total: len(set)})
+}
+
+// Reload implements coremain.Reloader. It refreshes all lists now.
+func (p *Blocklist) Reload() error {
+	var errs []error
+	for _, s := range p.subs {
+		if err := p.refresh(s); err != nil {
+			errs = append(errs, fmt.Errorf("list %s: %w", s.args.Name, err))
+		}
+	}
+	p.rebuild()
+	return errors.Join(errs...)
+}
+
+// SetSubscriptionEnabled implements coremain.SubscriptionSwitcher.
+func (p *Blocklist) SetSubscriptionEnabled(name string, enabled bool) error {
+	s, ok := p.byName[name]
+	if !ok {
+		return fmt.Errorf("list %s not found", name)
+	}
+	if s.enabled.Swap(enabled) != enabled {
+		p.rebuild()
+		p.logger.Info("list switched", zap.String("list", name), zap.Bool("enabled", enabled))
+	}
+	return nil
+}
+
+type State struct {
+	// Total is the number of unique entries of enabled lists.
+	Total int         `json:"total"`
+	Lists []ListState `json:"lists"`
+}
+
+type ListState struct {
+	Name      string     `json:"name"`
+	URL       string     `json:"url,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	Format    string     `json:"format"`
+	Enabled   bool       `json:"enabled"`
+	Entries   int        `json:"entries"`
+	Skipped   int        `json:"skipped"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// State implements coremain.StateInspector.
+func (p *Blocklist) State() any {
+	st := State{Total: p.compiled.Load().total}
+	for _, s := range p.subs {
+		s.m.Lock()
+		ls := ListState{
+			Name:    s.args.Name,
+			URL:     s.args.URL,
+			Path:    s.args.Path,
+			Format:  s.args.Format,
+			Enabled: s.enabled.Load(),
+			Entries: len(s.entries),
+			Skipped: s.skipped,
+		}
+		if !s.updatedAt.IsZero() {
+			t := s.updatedAt
+			ls.UpdatedAt = &t
+		}
+		if s.lastErr != nil {
+			ls.LastError = s.lastErr.Error()
+		}
+		s.m.Unlock()
+		st.Lists = append(st.Lists, ls)
+	}
+	return st
+}
+
+func (p *Blocklist) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return nil
+}
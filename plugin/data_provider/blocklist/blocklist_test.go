@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_parseList(t *testing.T) {
+	tests := []struct {
+		format  string
+		list    string
+		want    []string
+		skipped int
+	}{
+		{FormatDomain, "# comment\nAds.com.\n*.track.com # inline\nnot a domain\n", []string{"domain:ads.com", "domain:track.com"}, 1},
+		{FormatHosts, "127.0.0.1 localhost\n0.0.0.0 ads.com www.ads.com\n::1 ip6-localhost\nbad line\n", []string{"full:ads.com", "full:www.ads.com"}, 1},
+		{FormatAdblock, "! comment\n[Adblock Plus]\n||ads.com^\n||x.com^$important\n@@||ok.com^\n||y.com^$third-party\n##.banner\n", []string{"domain:ads.com", "domain:x.com"}, 3},
+		{FormatMosdns, "full:a.com\nregexp:[\n# c\nkeyword:ad\n", []string{"full:a.com", "keyword:ad"}, 1},
+	}
+	for _, tt := range tests {
+		got, skipped, err := parseList([]byte(tt.list), tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) || skipped != tt.skipped {
+			t.Fatalf("%s: want %v skipped %d, got %v skipped %d", tt.format, tt.want, tt.skipped, got, skipped)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s: want %v, got %v", tt.format, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	lists := map[string]string{
+		"/ads.txt":   "ads.com\ntrack.com\n",
+		"/hosts.txt": "0.0.0.0 www.ads.com\n0.0.0.0 malware.com\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(l))
+	}))
+	defer srv.Close()
+
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, []byte("full:ads.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	args := &Args{
+		Dir: dir,
+		Lists: []ListArgs{
+			{Name: "ads", URL: srv.URL + "/ads.txt"},
+			{Name: "hosts", URL: srv.URL + "/hosts.txt", Format: FormatHosts},
+			{Name: "local", Path: local, Format: FormatMosdns, Disabled: true},
+			{Name: "missing", URL: srv.URL + "/missing.txt"},
+		},
+	}
+	p, err := NewBlocklist(args, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	m := p.GetDomainMatcher()
+	for _, name := range []string{"ads.com.", "sub.track.com.", "malware.com."} {
+		if _, ok := m.Match(name); !ok {
+			t.Fatalf("%s should be matched", name)
+		}
+	}
+	if _, ok := m.Match("sub.malware.com."); ok {
+		t.Fatal("hosts entries should only match full names")
+	}
+
+	st := p.State().(State)
+	if st.Total != 4 || len(st.Lists) != 4 {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	if l := st.Lists[2]; l.Enabled || l.Entries != 1 {
+		t.Fatalf("unexpected state of the disabled list %+v", l)
+	}
+	if l := st.Lists[3]; len(l.LastError) == 0 || l.UpdatedAt != nil {
+		t.Fatalf("want error of the missing list, got %+v", l)
+	}
+
+	// Duplicated entries are counted once.
+	if err := p.SetSubscriptionEnabled("local", true); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.State().(State); st.Total != 5 {
+		t.Fatalf("unexpected total %d", st.Total)
+	}
+	if err := p.SetSubscriptionEnabled("ads", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Match("sub.track.com."); ok {
+		t.Fatal("disabled list should not be matched")
+	}
+	if err := p.SetSubscriptionEnabled("unknown", false); err == nil {
+		t.Fatal("unknown list should fail")
+	}
+
+	// Downloaded lists are kept in dir.
+	srv.Close()
+	p2, err := NewBlocklist(args, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	if _, ok := p2.GetDomainMatcher().Match("malware.com."); !ok {
+		t.Fatal("lists should be loaded from dir")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/miekg/dns"
+)
+
+// Formats of lists.
+const (
+	// FormatDomain is one domain per line. Subdomains are also matched.
+	FormatDomain = "domain"
+	// FormatHosts is a hosts file. Only the names are matched.
+	FormatHosts = "hosts"
+	// FormatAdblock is the basic adblock syntax, e.g. "||example.com^".
+	// Rules with modifiers and exceptions are skipped.
+	FormatAdblock = "adblock"
+	// FormatMosdns is the expressions of domain_set, e.g.
+	// "full:example.com" or "regexp:...".
+	FormatMosdns = "mosdns"
+)
+
+func validFormat(f string) bool {
+	switch f {
+	case FormatDomain, FormatHosts, FormatAdblock, FormatMosdns:
+		return true
+	}
+	return false
+}
+
+// Names in hosts files that are not blocked.
+var hostsLocalNames = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
+
+// parseList parses b in format to domain_set expressions. Lines that
+// are not supported are counted as skipped.
+func parseList(b []byte, format string) (entries []string, skipped int, err error) {
+	var validator *domain.MixMatcher[struct{}]
+	if format == FormatMosdns {
+		validator = domain.NewDomainMixMatcher()
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var es []string
+		var ok bool
+		switch format {
+		case FormatDomain:
+			es, ok = parseDomainLine(line)
+		case FormatHosts:
+			es, ok = parseHostsLine(line)
+		case FormatAdblock:
+			es, ok = parseAdblockLine(line)
+		case FormatMosdns:
+			line = stripComment(line, "#")
+			ok = len(line) == 0 || validator.Add(line, struct{}{}) == nil
+			if len(line) > 0 && ok {
+				es = []string{line}
+			}
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		entries = append(entries, es...)
+	}
+	return entries, skipped, s.Err()
+}
+
+func stripComment(line, mark string) string {
+	if i := strings.Index(line, mark); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// normDomain returns the lower case domain without the trailing dot. It
+// reports false if s is not a domain.
+func normDomain(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if len(s) == 0 || strings.ContainsAny(s, "*/: \t") {
+		return "", false
+	}
+	if _, err := netip.ParseAddr(s); err == nil {
+		return "", false
+	}
+	if _, ok := dns.IsDomainName(s); !ok {
+		return "", false
+	}
+	return s, true
+}
+
+// parseDomainLine parses "example.com" or "*.example.com". Comments
+// (empty lines) are valid and return no entry.
+func parseDomainLine(line string) ([]string, bool) {
+	line = stripComment(line, "#")
+	if len(line) == 0 {
+		return nil, true
+	}
+	d, ok := normDomain(strings.TrimPrefix(line, "*."))
+	if !ok {
+		return nil, false
+	}
+	return []string{"domain:" + d}, true
+}
+
+// parseHostsLine parses "0.0.0.0 example.com [alias...]".
+func parseHostsLine(line string) ([]string, bool) {
+	fs := strings.Fields(stripComment(line, "#"))
+	if len(fs) == 0 {
+		return nil, true
+	}
+	if _, err := netip.ParseAddr(fs[0]); err != nil || len(fs) < 2 {
+		return nil, false
+	}
+	var es []string
+	for _, f := range fs[1:] {
+		if _, ok := hostsLocalNames[strings.ToLower(f)]; ok {
+			continue
+		}
+		d, ok := normDomain(f)
+		if !ok {
+			continue
+		}
+		es = append(es, "full:"+d)
+	}
+	return es, true
+}
+
+// parseAdblockLine parses "||example.com^" and "||example.com^$important".
+func parseAdblockLine(line string) ([]string, bool) {
+	switch {
+	case strings.HasPrefix(line, "!"), strings.HasPrefix(line, "["):
+		return nil, true // comments and headers
+	case !strings.HasPrefix(line, "||"):
+		return nil, false // exceptions, cosmetic and url rules
+	}
+	d, rest, ok := strings.Cut(strings.TrimPrefix(line, "||"), "^")
+	if !ok || (len(rest) > 0 && rest != "$important") {
+		return nil, false
+	}
+	d, ok = normDomain(d)
+	if !ok {
+		return nil, false
+	}
+	return []string{"domain:" + d}, true
+}
+
+func validName(name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("missing list name")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid list name %s, it can only contain letters, digits, '-' and '_'", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clash_provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+const PluginType = "clash_provider"
+
+const maxPayloadSize = 64 << 20
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Config is the path of a Clash (or mihomo) config. If it is set,
+	// the rule-provider named Provider in its "rule-providers" is used.
+	// So mosdns and Clash always use the same rules. Relative paths of
+	// the rule-provider are relative to the dir of Config.
+	Config   string `yaml:"config"`
+	Provider string `yaml:"provider"`
+
+	// RuleProvider is used if Config is not set.
+	RuleProvider `yaml:",squash"`
+
+	// OnError is the policy if the rules failed to load at startup or
+	// to refresh. Default is failing the startup. Failed refreshes keep
+	// the last rules unless it is "match_none" or "match_all".
+	OnError data_provider.OnError `yaml:"on_error"`
+}
+
+// RuleProvider is a rule-provider in the same format as Clash.
+// Type can be "http" or "file". Behavior can be "domain", "ipcidr" or
+// "classical". Format can be "yaml" (default) or "text".
+// Rules are refreshed every Interval seconds if it is not 0. If an http
+// provider failed to download, the file at Path (e.g. downloaded by
+// Clash) is used.
+type RuleProvider struct {
+	Type     string `yaml:"type"`
+	Behavior string `yaml:"behavior"`
+	Format   string `yaml:"format"`
+	URL      string `yaml:"url"`
+	Path     string `yaml:"path"`
+	Interval int    `yaml:"interval"`
+}
+
+var _ data_provider.DomainMatcherProvider = (*ClashProvider)(nil)
+var _ data_provider.IPMatcherProvider = (*ClashProvider)(nil)
+var _ coremain.Reloader = (*ClashProvider)(nil)
+
+// ClashProvider provides domain and ip matchers from a rule-provider of
+// Clash. Domain rules are matched by GetDomainMatcher and ip rules
+// (from "ipcidr" or "IP-CIDR" of "classical") by GetIPMatcher.
+type ClashProvider struct {
+	rp      RuleProvider
+	onError data_provider.OnError
+	logger  *zap.Logger
+	client  *http.Client
+
+	rs  atomic.Pointer[ruleSet]
+	all atomic.Bool // set if the rules failed to load with data_provider.OnErrorMatchAll
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	loopDone chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewClashProvider(args.(*Args), bp.L())
+}
+
+func NewClashProvider(args *Args, logger *zap.Logger) (*ClashProvider, error) {
+	if err := args.OnError.Validate(); err != nil {
+		return nil, err
+	}
+	rp := args.RuleProvider
+	if len(args.Config) > 0 {
+		var err error
+		rp, err = loadRuleProvider(args.Config, args.Provider)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch rp.Type {
+	case "file":
+		if len(rp.Path) == 0 {
+			return nil, errors.New("missing path of the file provider")
+		}
+	case "http":
+		if len(rp.URL) == 0 {
+			return nil, errors.New("missing url of the http provider")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported provider type %s", rp.Type)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &ClashProvider{
+		rp:       rp,
+		onError:  args.OnError,
+		logger:   logger,
+		client:   &http.Client{Timeout: time.Second * 30},
+		ctx:      ctx,
+		cancel:   cancel,
+		loopDone: make(chan struct{}),
+	}
+	p.rs.Store(&ruleSet{domains: domain.NewDomainMixMatcher(), ips: netlist.NewList()})
+	if err := p.onError.Tolerate(logger, p.Reload()); err != nil {
+		cancel()
+		return nil, err
+	}
+	go p.refreshLoop()
+	return p, nil
+}
+
+// loadRuleProvider loads the rule-provider name from the Clash config.
+func loadRuleProvider(config, name string) (RuleProvider, error) {
+	b, err := os.ReadFile(config)
+	if err != nil {
+		return RuleProvider{}, err
+	}
+	var c struct {
+		RuleProviders map[string]RuleProvider `yaml:"rule-providers"`
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return RuleProvider{}, fmt.Errorf("invalid clash config, %w", err)
+	}
+	rp, ok := c.RuleProviders[name]
+	if !ok {
+		return RuleProvider{}, fmt.Errorf("cannot find rule-provider %s in %s", name, config)
+	}
+	if len(rp.Path) > 0 && !filepath.IsAbs(rp.Path) {
+		rp.Path = filepath.Join(filepath.Dir(config), rp.Path)
+	}
+	return rp, nil
+}
+
+func (p *ClashProvider) GetDomainMatcher() domain.Matcher[struct{}] {
+	return domainMatcher{p: p}
+}
+
+func (p *ClashProvider) GetIPMatcher() netlist.Matcher {
+	return ipMatcher{p: p}
+}
+
+type domainMatcher struct{ p *ClashProvider }
+
+func (m domainMatcher) Match(s string) (struct{}, bool) {
+	if m.p.all.Load() {
+		return struct{}{}, true
+	}
+	return m.p.rs.Load().domains.Match(s)
+}
+
+type ipMatcher struct{ p *ClashProvider }
+
+func (m ipMatcher) Match(addr netip.Addr) bool {
+	return m.p.all.Load() || m.p.rs.Load().ips.Match(addr)
+}
+
+// Reload implements coremain.Reloader. It loads the rules again. If it
+// failed, the rules are handled by the on_error policy and the error
+// is returned.
+func (p *ClashProvider) Reload() error {
+	rs, err := p.load()
+	if err != nil {
+		switch p.onError {
+		case data_provider.OnErrorMatchNone:
+			p.rs.Store(&ruleSet{domains: domain.NewDomainMixMatcher(), ips: netlist.NewList()})
+			p.all.Store(false)
+		case data_provider.OnErrorMatchAll:
+			p.all.Store(true)
+		}
+		return err
+	}
+	p.rs.Store(rs)
+	p.all.Store(false)
+	p.logger.Info(
+		"rules loaded",
+		zap.Int("domains", rs.domains.Len()),
+		zap.Int("ips", rs.ips.Len()),
+		zap.Int("skipped", rs.skipped),
+	)
+	return nil
+}
+
+func (p *ClashProvider) load() (*ruleSet, error) {
+	var b []byte
+	var err error
+	switch p.rp.Type {
+	case "file":
+		b, err = os.ReadFile(p.rp.Path)
+	case "http":
+		b, err = p.download()
+		if err != nil && len(p.rp.Path) > 0 {
+			var fileErr error
+			if b, fileErr = os.ReadFile(p.rp.Path); fileErr == nil {
+				p.logger.Warn("failed to download rules, using the local file", zap.String("path", p.rp.Path), zap.Error(err))
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parsePayload(b, p.rp.Format, p.rp.Behavior)
+}
+
+func (p *ClashProvider) download() ([]byte, error) {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, p.rp.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxPayloadSize {
+		return nil, errors.New("payload is too large")
+	}
+	return b, nil
+}
+
+func (p *ClashProvider) refreshLoop() {
+	defer close(p.loopDone)
+	if p.rp.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(p.rp.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Reload(); err != nil {
+				if p.ctx.Err() != nil {
+					return
+				}
+				p.logger.Warn("failed to refresh rules", zap.Error(err))
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *ClashProvider) Close() error {
+	p.cancel()
+	<-p.loopDone
+	return nil
+}
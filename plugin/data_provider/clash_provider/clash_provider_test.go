@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clash_provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"go.uber.org/zap"
+)
+
+func Test_parsePayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		format   string
+		behavior string
+		match    []string
+		notMatch []string
+	}{
+		{
+			name:     "domain",
+			payload:  "payload:\n  - '+.a.com'\n  - '.b.com'\n  - '*.c.com'\n  - 'd.com'\n",
+			behavior: "domain",
+			match:    []string{"a.com.", "x.a.com.", "x.y.b.com.", "x.c.com.", "D.com."},
+			notMatch: []string{"b.com.", "c.com.", "x.y.c.com.", "x.d.com."},
+		},
+		{
+			name:     "classical text",
+			payload:  "# comment\nDOMAIN-SUFFIX,a.com\nDOMAIN-KEYWORD,kw\nDOMAIN,d.com\nPROCESS-NAME,curl\nIP-CIDR,10.0.0.0/8,no-resolve\n",
+			format:   "text",
+			behavior: "classical",
+			match:    []string{"x.a.com.", "xkwx.org.", "d.com.", "10.1.2.3"},
+			notMatch: []string{"x.d.com.", "11.0.0.1"},
+		},
+		{
+			name:     "ipcidr",
+			payload:  "payload:\n  - 192.168.0.0/16\n  - 2001:db8::1\n",
+			behavior: "ipcidr",
+			match:    []string{"192.168.1.1", "2001:db8::1"},
+			notMatch: []string{"192.169.0.1", "2001:db8::2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := parsePayload([]byte(tt.payload), tt.format, tt.behavior)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched := func(s string) bool {
+				if addr, err := netip.ParseAddr(s); err == nil {
+					return rs.ips.Match(addr)
+				}
+				_, ok := rs.domains.Match(s)
+				return ok
+			}
+			for _, s := range tt.match {
+				if !matched(s) {
+					t.Errorf("%s should be matched", s)
+				}
+			}
+			for _, s := range tt.notMatch {
+				if matched(s) {
+					t.Errorf("%s should not be matched", s)
+				}
+			}
+		})
+	}
+
+	if _, err := parsePayload([]byte("payload:\n  - bad\n"), "", "ipcidr"); err == nil {
+		t.Fatal("invalid ip should fail")
+	}
+}
+
+func TestClashProvider(t *testing.T) {
+	var payload atomic.Value
+	payload.Store("payload:\n  - '+.a.com'\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := payload.Load().(string)
+		if len(s) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(s))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	c := "rule-providers:\n  Remote:\n    type: http\n    behavior: domain\n    url: " + srv.URL + "\n    path: ./ruleset/remote.yaml\n    interval: 86400\n"
+	if err := os.WriteFile(config, []byte(c), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newProvider := func(onError data_provider.OnError) (*ClashProvider, error) {
+		return NewClashProvider(&Args{Config: config, Provider: "Remote", OnError: onError}, zap.NewNop())
+	}
+	p, err := newProvider("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	dm := p.GetDomainMatcher()
+	if _, ok := dm.Match("x.a.com."); !ok {
+		t.Fatal("downloaded rules should be matched")
+	}
+
+	payload.Store("payload:\n  - '+.b.com'\n")
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dm.Match("x.a.com."); ok {
+		t.Fatal("old rules should be removed")
+	}
+
+	// Download failed. The rules are kept.
+	payload.Store("")
+	if err := p.Reload(); err == nil {
+		t.Fatal("reload should fail")
+	}
+	if _, ok := dm.Match("b.com."); !ok {
+		t.Fatal("failed refresh should keep the last rules")
+	}
+
+	// The file downloaded by Clash is used.
+	if err := os.MkdirAll(filepath.Join(dir, "ruleset"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ruleset", "remote.yaml"), []byte("payload:\n  - 'c.com'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dm.Match("c.com."); !ok {
+		t.Fatal("rules from the local file should be matched")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "ruleset", "remote.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newProvider(""); err == nil {
+		t.Fatal("provider should fail to start without rules")
+	}
+	p2, err := newProvider(data_provider.OnErrorMatchAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+	if !p2.GetIPMatcher().Match(netip.MustParseAddr("1.1.1.1")) {
+		t.Fatal("match_all should match everything")
+	}
+
+	if _, err := NewClashProvider(&Args{Config: config, Provider: "remote"}, zap.NewNop()); err == nil {
+		t.Fatal("provider names are case sensitive")
+	}
+}
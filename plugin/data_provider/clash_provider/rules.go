@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package clash_provider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"go.yaml.in/yaml/v3"
+)
+
+// ruleSet is the parsed payload of a rule-provider.
+type ruleSet struct {
+	domains *domain.MixMatcher[struct{}]
+	ips     *netlist.List
+	skipped int // number of rules that are not supported, e.g. "PROCESS-NAME".
+}
+
+// parsePayload parses b in format "yaml" (a "payload" list) or "text"
+// (one rule per line, "#" for comments) with behavior "domain",
+// "ipcidr" or "classical".
+func parsePayload(b []byte, format, behavior string) (*ruleSet, error) {
+	var rules []string
+	switch format {
+	case "", "yaml":
+		var v struct {
+			Payload []string `yaml:"payload"`
+		}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("invalid yaml payload, %w", err)
+		}
+		rules = v.Payload
+	case "text":
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			if l := strings.TrimSpace(s.Text()); len(l) > 0 && !strings.HasPrefix(l, "#") {
+				rules = append(rules, l)
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+
+	rs := &ruleSet{domains: domain.NewDomainMixMatcher(), ips: netlist.NewList()}
+	for i, r := range rules {
+		r = strings.TrimSpace(r)
+		var err error
+		switch behavior {
+		case "domain":
+			err = rs.addDomain(r)
+		case "ipcidr":
+			err = rs.addIP(r)
+		case "classical":
+			err = rs.addClassical(r)
+		default:
+			return nil, fmt.Errorf("unsupported behavior %s", behavior)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule #%d %s, %w", i, r, err)
+		}
+	}
+	rs.ips.Sort()
+	return rs, nil
+}
+
+// addDomain adds a rule of behavior "domain". "+.a.com" matches a.com
+// and its subdomains, ".a.com" only matches subdomains, "*" matches
+// exactly one label and others are full matches.
+func (rs *ruleSet) addDomain(r string) error {
+	r = strings.ToLower(strings.TrimSuffix(r, "."))
+	switch {
+	case strings.HasPrefix(r, "+."):
+		return rs.domains.Add("domain:"+r[2:], struct{}{})
+	case strings.HasPrefix(r, "."):
+		return rs.domains.Add("regexp:^.+"+regexp.QuoteMeta(r)+"$", struct{}{})
+	case strings.Contains(r, "*"):
+		labels := strings.Split(r, ".")
+		for i, l := range labels {
+			if l == "*" {
+				labels[i] = "[^.]+"
+			} else {
+				labels[i] = regexp.QuoteMeta(l)
+			}
+		}
+		return rs.domains.Add("regexp:^"+strings.Join(labels, `\.`)+"$", struct{}{})
+	default:
+		return rs.domains.Add("full:"+r, struct{}{})
+	}
+}
+
+func (rs *ruleSet) addIP(r string) error {
+	p, err := netip.ParsePrefix(r)
+	if err != nil {
+		addr, err2 := netip.ParseAddr(r)
+		if err2 != nil {
+			return err
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	rs.ips.Append(p.Masked())
+	return nil
+}
+
+// addClassical adds a rule of behavior "classical", e.g.
+// "DOMAIN-SUFFIX,a.com". Rules that are not about domains or
+// destination ips are skipped.
+func (rs *ruleSet) addClassical(r string) error {
+	typ, payload, _ := strings.Cut(r, ",")
+	payload, _, _ = strings.Cut(payload, ",") // options, e.g. "no-resolve"
+	payload = strings.TrimSpace(payload)
+	switch strings.ToUpper(strings.TrimSpace(typ)) {
+	case "DOMAIN":
+		return rs.domains.Add("full:"+payload, struct{}{})
+	case "DOMAIN-SUFFIX":
+		return rs.domains.Add("domain:"+payload, struct{}{})
+	case "DOMAIN-KEYWORD":
+		return rs.domains.Add("keyword:"+payload, struct{}{})
+	case "DOMAIN-REGEX":
+		return rs.domains.Add("regexp:"+payload, struct{}{})
+	case "IP-CIDR", "IP-CIDR6":
+		return rs.addIP(payload)
+	default:
+		rs.skipped++
+		return nil
+	}
+}
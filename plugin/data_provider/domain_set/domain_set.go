@@ -21,11 +21,15 @@ package domain_set
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
 	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 	"os"
+	"sync/atomic"
 )
 
 const PluginType = "domain_set"
@@ -39,6 +43,9 @@ func Init(bp *coremain.BP, args any) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := m.RegMetricsTo(prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg())); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
@@ -46,12 +53,44 @@ type Args struct {
 	Exps  []string `yaml:"exps"`
 	Sets  []string `yaml:"sets"`
 	Files []string `yaml:"files"`
+
+	// Background loads files in background. Rules from files won't be
+	// matched until they are loaded, and the server is not ready
+	// (see /readyz) until then.
+	Background bool `yaml:"background"`
+
+	// OnError is the policy if a file failed to load or to parse.
+	// Can be "match_none", "match_all" or "keep". Default is failing
+	// the startup or the reload. See data_provider.OnError.
+	OnError data_provider.OnError `yaml:"on_error"`
 }
 
 var _ data_provider.DomainMatcherProvider = (*DomainSet)(nil)
+var _ coremain.Reloader = (*DomainSet)(nil)
+var _ coremain.ReadinessChecker = (*DomainSet)(nil)
 
 type DomainSet struct {
-	mg []domain.Matcher[struct{}]
+	mg         []domain.Matcher[struct{}]
+	exps       *domain.MixMatcher[struct{}]
+	files      []*fileSource
+	matchTotal *prometheus.CounterVec
+	bg         data_provider.BackgroundLoader
+	onError    data_provider.OnError
+	logger     *zap.Logger
+}
+
+// fileSource is a source loaded from a file. It can be reloaded.
+type fileSource struct {
+	path string
+	m    atomic.Pointer[domain.MixMatcher[struct{}]]
+	all  atomic.Bool // set if the file failed to load with data_provider.OnErrorMatchAll
+}
+
+func (fs *fileSource) Match(s string) (struct{}, bool) {
+	if fs.all.Load() {
+		return struct{}{}, true
+	}
+	return fs.m.Load().Match(s)
 }
 
 func (d *DomainSet) GetDomainMatcher() domain.Matcher[struct{}] {
@@ -59,15 +98,41 @@ func (d *DomainSet) GetDomainMatcher() domain.Matcher[struct{}] {
 }
 
 // NewDomainSet inits a DomainSet from given args.
+// Each source (expressions, a file or a set) is matched separately so
+// matches can be counted by source. Files can be reloaded by Reload.
 func NewDomainSet(bp *coremain.BP, args *Args) (*DomainSet, error) {
-	ds := &DomainSet{}
+	if err := args.OnError.Validate(); err != nil {
+		return nil, err
+	}
+	ds := &DomainSet{
+		onError: args.OnError,
+		logger:  bp.L(),
+		matchTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "match_total",
+			Help:        "The total number of domains that matched a source of this set",
+			ConstLabels: map[string]string{"tag": bp.Tag()},
+		}, []string{"source"}),
+	}
 
 	m := domain.NewDomainMixMatcher()
-	if err := LoadExpsAndFiles(args.Exps, args.Files, m); err != nil {
+	if err := LoadExps(args.Exps, m); err != nil {
 		return nil, err
 	}
 	if m.Len() > 0 {
-		ds.mg = append(ds.mg, m)
+		ds.exps = m
+		ds.mg = append(ds.mg, countedMatcher{m: m, c: ds.matchTotal.WithLabelValues("exps")})
+	}
+	for _, f := range args.Files {
+		fs := &fileSource{path: f}
+		fs.m.Store(domain.NewDomainMixMatcher())
+		ds.files = append(ds.files, fs)
+		ds.mg = append(ds.mg, countedMatcher{m: fs, c: ds.matchTotal.WithLabelValues("file:" + f)})
+	}
+	background := args.Background && len(ds.files) > 0
+	if !background {
+		if err := ds.onError.Tolerate(ds.logger, ds.Reload()); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, tag := range args.Sets {
@@ -75,12 +140,102 @@ func NewDomainSet(bp *coremain.BP, args *Args) (*DomainSet, error) {
 		if provider == nil {
 			return nil, fmt.Errorf("%s is not a DomainMatcherProvider", tag)
 		}
-		m := provider.GetDomainMatcher()
-		ds.mg = append(ds.mg, m)
+		ds.mg = append(ds.mg, countedMatcher{m: provider.GetDomainMatcher(), c: ds.matchTotal.WithLabelValues("set:" + tag)})
+	}
+
+	if background {
+		ds.bg.Start(bp.L(), func() error {
+			return ds.onError.Tolerate(ds.logger, ds.Reload())
+		})
 	}
 	return ds, nil
 }
 
+// Reload implements coremain.Reloader. It reloads all files. If any
+// file fails to load, no file will be updated, unless an on_error policy
+// is set. Then, the failed files are handled by the policy, others are
+// updated, and the errors are still returned.
+func (d *DomainSet) Reload() error {
+	if d.onError != data_provider.OnErrorFail {
+		return d.reloadEach()
+	}
+	ms := make([]*domain.MixMatcher[struct{}], 0, len(d.files))
+	for i, fs := range d.files {
+		m := domain.NewDomainMixMatcher()
+		if err := LoadFile(fs.path, m); err != nil {
+			return fmt.Errorf("failed to load file #%d %s, %w", i, fs.path, err)
+		}
+		ms = append(ms, m)
+	}
+	for i, fs := range d.files {
+		fs.m.Store(ms[i])
+		fs.all.Store(false)
+	}
+	d.bg.Loaded()
+	return nil
+}
+
+func (d *DomainSet) reloadEach() error {
+	var errs []error
+	for i, fs := range d.files {
+		m := domain.NewDomainMixMatcher()
+		if err := LoadFile(fs.path, m); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load file #%d %s, %w", i, fs.path, err))
+			switch d.onError {
+			case data_provider.OnErrorMatchNone:
+				fs.m.Store(domain.NewDomainMixMatcher())
+				fs.all.Store(false)
+			case data_provider.OnErrorMatchAll:
+				fs.all.Store(true)
+			}
+			continue
+		}
+		fs.m.Store(m)
+		fs.all.Store(false)
+	}
+	d.bg.Loaded()
+	return errors.Join(errs...)
+}
+
+// Len returns the number of rules from expressions and files. Rules of
+// other sets are not counted.
+func (d *DomainSet) Len() int {
+	n := 0
+	if d.exps != nil {
+		n += d.exps.Len()
+	}
+	for _, fs := range d.files {
+		n += fs.m.Load().Len()
+	}
+	return n
+}
+
+// Ready implements coremain.ReadinessChecker. DomainSet is not ready
+// if its files are loading in background.
+func (d *DomainSet) Ready() error {
+	return d.bg.Ready()
+}
+
+func (d *DomainSet) RegMetricsTo(r prometheus.Registerer) error {
+	return r.Register(d.matchTotal)
+}
+
+// countedMatcher counts matches of m. Note that sources are matched in
+// order and the matching stops at the first match. So a domain is only
+// counted by the first source that matched it.
+type countedMatcher struct {
+	m domain.Matcher[struct{}]
+	c prometheus.Counter
+}
+
+func (cm countedMatcher) Match(s string) (struct{}, bool) {
+	v, ok := cm.m.Match(s)
+	if ok {
+		cm.c.Inc()
+	}
+	return v, ok
+}
+
 func LoadExpsAndFiles(exps []string, fs []string, m *domain.MixMatcher[struct{}]) error {
 	if err := LoadExps(exps, m); err != nil {
 		return err
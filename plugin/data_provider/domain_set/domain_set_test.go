@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain_set
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDomainSet_matchTotal(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(f, []byte("b.com\nc.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := coremain.NewTestMosdnsWithPlugins(make(map[string]any))
+	ds, err := NewDomainSet(coremain.NewBP("test", m), &Args{Exps: []string{"a.com"}, Files: []string{f}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm := ds.GetDomainMatcher()
+	for _, d := range []string{"a.com.", "b.com.", "www.c.com.", "d.com."} {
+		dm.Match(d)
+	}
+	if v := testutil.ToFloat64(ds.matchTotal.WithLabelValues("exps")); v != 1 {
+		t.Fatalf("want 1 match from exps, got %v", v)
+	}
+	if v := testutil.ToFloat64(ds.matchTotal.WithLabelValues("file:" + f)); v != 2 {
+		t.Fatalf("want 2 matches from file, got %v", v)
+	}
+
+	if err := os.WriteFile(f, []byte("d.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dm.Match("d.com."); !ok {
+		t.Fatal("reloaded domain should be matched")
+	}
+	if n := ds.Len(); n != 2 {
+		t.Fatalf("want 2 rules, got %d", n)
+	}
+	if _, ok := dm.Match("b.com."); ok {
+		t.Fatal("removed domain should not be matched")
+	}
+	if err := os.Remove(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Reload(); err == nil {
+		t.Fatal("reloading a missing file should fail")
+	}
+	if _, ok := dm.Match("d.com."); !ok {
+		t.Fatal("failed reload should keep old rules")
+	}
+}
+
+func TestDomainSet_background(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(f, []byte("b.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := coremain.NewTestMosdnsWithPlugins(make(map[string]any))
+	newSet := func(files ...string) *DomainSet {
+		ds, err := NewDomainSet(coremain.NewBP("test", m), &Args{Exps: []string{"a.com"}, Files: files, Background: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ds.GetDomainMatcher().Match("a.com."); !ok {
+			t.Fatal("exps should be loaded immediately")
+		}
+		return ds
+	}
+	waitLoaded := func(ds *DomainSet) error {
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			err := ds.Ready()
+			if !errors.Is(err, data_provider.ErrLoading) || time.Now().After(deadline) {
+				return err
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	ds := newSet(f)
+	if err := waitLoaded(ds); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ds.GetDomainMatcher().Match("b.com."); !ok {
+		t.Fatal("domains from the file should be matched after loading")
+	}
+
+	ds = newSet(filepath.Join(t.TempDir(), "missing"))
+	if err := waitLoaded(ds); err == nil {
+		t.Fatal("set with a missing file should not be ready")
+	}
+}
+
+func TestDomainSet_onError(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(good, []byte("a.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("b.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := coremain.NewTestMosdnsWithPlugins(make(map[string]any))
+
+	tests := []struct {
+		onError data_provider.OnError
+		wantB   bool // whether b.com. is matched after the bad file failed to reload
+		wantC   bool // whether c.com. is matched
+	}{
+		{onError: data_provider.OnErrorMatchNone, wantB: false, wantC: false},
+		{onError: data_provider.OnErrorMatchAll, wantB: true, wantC: true},
+		{onError: data_provider.OnErrorKeep, wantB: true, wantC: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.onError), func(t *testing.T) {
+			if err := os.WriteFile(bad, []byte("b.com\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ds, err := NewDomainSet(coremain.NewBP("test", m), &Args{Files: []string{good, bad}, OnError: tt.onError})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm := ds.GetDomainMatcher()
+			if err := os.WriteFile(bad, []byte("invalid_type:b.com\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ds.Reload(); err == nil {
+				t.Fatal("reload should still return the error")
+			}
+			if _, ok := dm.Match("a.com."); !ok {
+				t.Fatal("good file should be loaded")
+			}
+			if _, ok := dm.Match("b.com."); ok != tt.wantB {
+				t.Fatalf("b.com. want matched %v, got %v", tt.wantB, ok)
+			}
+			if _, ok := dm.Match("c.com."); ok != tt.wantC {
+				t.Fatalf("c.com. want matched %v, got %v", tt.wantC, ok)
+			}
+		})
+	}
+
+	if _, err := NewDomainSet(coremain.NewBP("test", m), &Args{Files: []string{filepath.Join(dir, "missing")}}); err == nil {
+		t.Fatal("missing file should fail the startup by default")
+	}
+	ds, err := NewDomainSet(coremain.NewBP("test", m), &Args{Files: []string{filepath.Join(dir, "missing")}, OnError: data_provider.OnErrorMatchAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ds.GetDomainMatcher().Match("c.com."); !ok {
+		t.Fatal("missing file should match everything with match_all")
+	}
+	if _, err := NewDomainSet(coremain.NewBP("test", m), &Args{OnError: "bad"}); err == nil {
+		t.Fatal("invalid policy should fail")
+	}
+}
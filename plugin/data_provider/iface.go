@@ -20,6 +20,8 @@
 package data_provider
 
 import (
+	"net/netip"
+
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
 )
@@ -31,3 +33,8 @@ type DomainMatcherProvider interface {
 type IPMatcherProvider interface {
 	GetIPMatcher() netlist.Matcher
 }
+
+// HostnameProvider resolves a client address to its hostname (fqdn).
+type HostnameProvider interface {
+	LookupHostname(addr netip.Addr) (string, bool)
+}
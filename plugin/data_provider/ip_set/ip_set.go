@@ -21,6 +21,7 @@ package ip_set
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
@@ -28,6 +29,7 @@ import (
 	"net/netip"
 	"os"
 	"strings"
+	"sync/atomic"
 )
 
 const PluginType = "ip_set"
@@ -44,12 +46,44 @@ type Args struct {
 	IPs   []string `yaml:"ips"`
 	Sets  []string `yaml:"sets"`
 	Files []string `yaml:"files"`
+
+	// Background loads files in background. Ips from files won't be
+	// matched until they are loaded, and the server is not ready
+	// (see /readyz) until then.
+	Background bool `yaml:"background"`
+
+	// OnError is the policy if a file failed to load or to parse.
+	// Can be "match_none", "match_all" or "keep". Default is failing
+	// the startup. See data_provider.OnError.
+	OnError data_provider.OnError `yaml:"on_error"`
 }
 
 var _ data_provider.IPMatcherProvider = (*IPSet)(nil)
+var _ coremain.ReadinessChecker = (*IPSet)(nil)
 
 type IPSet struct {
-	mg []netlist.Matcher
+	mg      []netlist.Matcher
+	files   []*fileList
+	bg      data_provider.BackgroundLoader
+	onError data_provider.OnError
+}
+
+// Ready implements coremain.ReadinessChecker. IPSet is not ready if its
+// files are loading in background.
+func (d *IPSet) Ready() error {
+	return d.bg.Ready()
+}
+
+// fileList is a list loaded from a file after the IPSet was created,
+// either in background or with an on_error policy.
+type fileList struct {
+	path string
+	l    atomic.Pointer[netlist.List]
+	all  atomic.Bool // set if the file failed to load with data_provider.OnErrorMatchAll
+}
+
+func (fl *fileList) Match(addr netip.Addr) bool {
+	return fl.all.Load() || fl.l.Load().Match(addr)
 }
 
 func (d *IPSet) GetIPMatcher() netlist.Matcher {
@@ -57,10 +91,24 @@ func (d *IPSet) GetIPMatcher() netlist.Matcher {
 }
 
 func NewIPSet(bp *coremain.BP, args *Args) (*IPSet, error) {
-	p := &IPSet{}
+	if err := args.OnError.Validate(); err != nil {
+		return nil, err
+	}
+	p := &IPSet{onError: args.OnError}
+
+	files := args.Files
+	if (args.Background || args.OnError != data_provider.OnErrorFail) && len(files) > 0 {
+		files = nil
+		for _, f := range args.Files {
+			fl := &fileList{path: f}
+			fl.l.Store(newEmptyList())
+			p.files = append(p.files, fl)
+			p.mg = append(p.mg, fl)
+		}
+	}
 
 	l := netlist.NewList()
-	if err := LoadFromIPsAndFiles(args.IPs, args.Files, l); err != nil {
+	if err := LoadFromIPsAndFiles(args.IPs, files, l); err != nil {
 		return nil, err
 	}
 	l.Sort()
@@ -74,9 +122,57 @@ func NewIPSet(bp *coremain.BP, args *Args) (*IPSet, error) {
 		}
 		p.mg = append(p.mg, provider.GetIPMatcher())
 	}
+
+	if len(p.files) > 0 {
+		load := func() error { return p.onError.Tolerate(bp.L(), p.loadFiles()) }
+		if args.Background {
+			p.bg.Start(bp.L(), load)
+		} else if err := load(); err != nil {
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
+// loadFiles loads p.files. If any file fails to load, no file will be
+// updated, unless an on_error policy is set. Then, the failed files are
+// handled by the policy, and others are updated.
+func (d *IPSet) loadFiles() error {
+	ls := make([]*netlist.List, len(d.files))
+	var errs []error
+	for i, fl := range d.files {
+		l := netlist.NewList()
+		if err := LoadFromFile(fl.path, l); err != nil {
+			err = fmt.Errorf("failed to load file #%d %s, %w", i, fl.path, err)
+			if d.onError == data_provider.OnErrorFail {
+				return err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		l.Sort()
+		ls[i] = l
+	}
+	for i, fl := range d.files {
+		switch {
+		case ls[i] != nil:
+			fl.l.Store(ls[i])
+			fl.all.Store(false)
+		case d.onError == data_provider.OnErrorMatchNone:
+			fl.l.Store(newEmptyList())
+		case d.onError == data_provider.OnErrorMatchAll:
+			fl.all.Store(true)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newEmptyList() *netlist.List {
+	l := netlist.NewList()
+	l.Sort()
+	return l
+}
+
 func parseNetipPrefix(s string) (netip.Prefix, error) {
 	if strings.ContainsRune(s, '/') {
 		return netip.ParsePrefix(s)
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package data_provider
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// OnError is the policy of a provider if the data of a source (e.g. a
+// file) failed to load or to parse.
+type OnError string
+
+const (
+	// OnErrorFail fails the loading. The provider fails to start, or
+	// keeps all its old data if it failed to reload. It is the default.
+	OnErrorFail OnError = ""
+	// OnErrorMatchNone makes the failed source match nothing (fail-open).
+	OnErrorMatchNone OnError = "match_none"
+	// OnErrorMatchAll makes the failed source match everything (fail-closed).
+	OnErrorMatchAll OnError = "match_all"
+	// OnErrorKeep keeps the last good data of the failed source. There is
+	// no good data at startup, so the source matches nothing until it
+	// is loaded successfully.
+	OnErrorKeep OnError = "keep"
+)
+
+func (p OnError) Validate() error {
+	switch p {
+	case OnErrorFail, OnErrorMatchNone, OnErrorMatchAll, OnErrorKeep:
+		return nil
+	default:
+		return fmt.Errorf("invalid on_error policy %s", p)
+	}
+}
+
+// Tolerate logs err and returns nil if the policy is not OnErrorFail.
+// Otherwise, it returns err.
+func (p OnError) Tolerate(logger *zap.Logger, err error) error {
+	if err == nil || p == OnErrorFail {
+		return err
+	}
+	logger.Warn("failed to load data, handled by the on_error policy", zap.String("on_error", string(p)), zap.Error(err))
+	return nil
+}
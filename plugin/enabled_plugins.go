@@ -22,11 +22,14 @@ package plugin
 // data providers
 import (
 	// data provider
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/data_provider/blocklist"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/data_provider/clash_provider"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/data_provider/domain_set"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/data_provider/ip_set"
 
 	// matcher
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/client_ip"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/client_name"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/cname"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/env"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/has_ecs"
@@ -42,27 +45,50 @@ import (
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/matcher/string_exp"
 
 	// executable
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ab_compare"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/agh_api"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/arbitrary"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/black_hole"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/cache"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/chaos"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/debug_print"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dhcp_leases"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dns64"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/domain_ips"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/drop_resp"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dual_selector"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dyn_update"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ecs_handler"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ext_process"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/forward"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/forward_edns0opt"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/hosts"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ipset"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/kv_records"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/llmnr"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/mdns"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/metrics_collector"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/mirror"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/nftset"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/nx_redirect"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_stats"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_stream"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_summary"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/rate_limiter"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/redirect"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/remote_plugin"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/reverse_lookup"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/route"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sanitize"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/fallback"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/parallel"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sleep"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/slow_log"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/split_dns"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/tailscale"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ttl"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/webhook"
 
 	// executable and matcher
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/mark"
@@ -70,6 +96,7 @@ import (
 	// server
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/server/http_server"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/server/quic_server"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/server/resolved"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/server/tcp_server"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/server/udp_server"
 )
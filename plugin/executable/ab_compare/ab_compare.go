@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ab_compare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const PluginType = "ab_compare"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// A and B are tags of executable plugins, typically sequences.
+	A string `yaml:"a"`
+	B string `yaml:"b"`
+	// Use is the pipeline whose result is returned to the client.
+	// Can be "a" or "b". Default is "a".
+	Use string `yaml:"use"`
+	// Timeout in milliseconds of the other pipeline. Default is 5000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Use, "a")
+	utils.SetDefaultUnsignNum(&a.Timeout, 5000)
+}
+
+var _ sequence.Executable = (*ABCompare)(nil)
+
+// ABCompare sends each query through two pipelines, returns the result
+// of one of them and logs differences between them. The other pipeline
+// runs in background and never delays the query.
+type ABCompare struct {
+	logger  *zap.Logger
+	use     sequence.Executable
+	other   sequence.Executable
+	swapped bool // use is b
+	timeout time.Duration
+
+	comparedTotal prometheus.Counter
+	diffTotal     prometheus.Counter
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	p, err := NewABCompare(bp, args.(*Args), bp.Tag())
+	if err != nil {
+		return nil, err
+	}
+	if err := p.RegMetricsTo(prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg())); err != nil {
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	return p, nil
+}
+
+func NewABCompare(bq sequence.BQ, args *Args, metricsTag string) (*ABCompare, error) {
+	args.init()
+	if len(args.A) == 0 || len(args.B) == 0 {
+		return nil, errors.New("args missing a or b")
+	}
+	a := sequence.ToExecutable(bq.M().GetPlugin(args.A))
+	if a == nil {
+		return nil, fmt.Errorf("can not find executable %s", args.A)
+	}
+	b := sequence.ToExecutable(bq.M().GetPlugin(args.B))
+	if b == nil {
+		return nil, fmt.Errorf("can not find executable %s", args.B)
+	}
+
+	lb := map[string]string{"tag": metricsTag}
+	p := &ABCompare{
+		logger:  bq.L(),
+		timeout: time.Duration(args.Timeout) * time.Millisecond,
+		comparedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "compared_total",
+			Help:        "The total number of compared queries",
+			ConstLabels: lb,
+		}),
+		diffTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "diff_total",
+			Help:        "The total number of queries that have different results",
+			ConstLabels: lb,
+		}),
+	}
+	switch args.Use {
+	case "a":
+		p.use, p.other = a, b
+	case "b":
+		p.use, p.other = b, a
+		p.swapped = true
+	default:
+		return nil, fmt.Errorf("invalid use [%s]", args.Use)
+	}
+	return p, nil
+}
+
+func (p *ABCompare) RegMetricsTo(r prometheus.Registerer) error {
+	for _, collector := range [...]prometheus.Collector{p.comparedTotal, p.diffTotal} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type result struct {
+	r       *dns.Msg
+	err     error
+	latency time.Duration
+}
+
+func (p *ABCompare) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	otherRes := make(chan result, 1)
+	qCtxO := qCtx.Copy()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		start := time.Now()
+		err := p.other.Exec(ctx, qCtxO)
+		otherRes <- result{r: qCtxO.R(), err: err, latency: time.Since(start)}
+	}()
+
+	start := time.Now()
+	err := p.use.Exec(ctx, qCtx)
+	useRes := result{r: qCtx.R(), err: err, latency: time.Since(start)}
+	if useRes.r != nil {
+		useRes.r = useRes.r.Copy() // qCtx's response may be modified later.
+	}
+
+	info := qCtxO.InfoField() // qCtx may be released once this returns.
+	go func() {
+		o := <-otherRes
+		a, b := useRes, o
+		if p.swapped {
+			a, b = b, a
+		}
+		p.compare(info, a, b)
+	}()
+	return err
+}
+
+func (p *ABCompare) compare(info zap.Field, a, b result) {
+	p.comparedTotal.Inc()
+	diffs := diff(a, b)
+	if len(diffs) == 0 {
+		return
+	}
+	p.diffTotal.Inc()
+	p.logger.Info(
+		"results are different",
+		info,
+		zap.Strings("diffs", diffs),
+		zap.Duration("latency_a", a.latency),
+		zap.Duration("latency_b", b.latency),
+		zap.Duration("latency_delta", b.latency-a.latency),
+	)
+}
+
+// diff returns descriptions of differences between a and b.
+// TTLs and the order of answers are ignored.
+func diff(a, b result) []string {
+	var diffs []string
+	if (a.err == nil) != (b.err == nil) {
+		diffs = append(diffs, fmt.Sprintf("error: %v / %v", a.err, b.err))
+	}
+	if (a.r == nil) != (b.r == nil) {
+		diffs = append(diffs, fmt.Sprintf("has response: %v / %v", a.r != nil, b.r != nil))
+	}
+	if a.r == nil || b.r == nil {
+		return diffs
+	}
+	if a.r.Rcode != b.r.Rcode {
+		diffs = append(diffs, fmt.Sprintf("rcode: %s / %s", dns.RcodeToString[a.r.Rcode], dns.RcodeToString[b.r.Rcode]))
+	}
+	as, bs := answerSet(a.r), answerSet(b.r)
+	if !slices.Equal(as, bs) {
+		diffs = append(diffs, fmt.Sprintf("answers: %v / %v", as, bs))
+	}
+	return diffs
+}
+
+// answerSet returns sorted answers without ttl.
+func answerSet(m *dns.Msg) []string {
+	s := make([]string, 0, len(m.Answer))
+	for _, rr := range m.Answer {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		s = append(s, rr.String())
+	}
+	slices.Sort(s)
+	return s
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ab_compare
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func Test_diff(t *testing.T) {
+	newMsg := func(rcode int, rrs ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.Rcode = rcode
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name      string
+		a, b      result
+		wantDiffs int
+	}{
+		{
+			name: "same answers in different order and ttl",
+			a:    result{r: newMsg(0, "example.com. 60 IN A 1.1.1.1", "example.com. 60 IN A 2.2.2.2")},
+			b:    result{r: newMsg(0, "example.com. 300 IN A 2.2.2.2", "example.com. 300 IN A 1.1.1.1")},
+		},
+		{
+			name:      "different answers",
+			a:         result{r: newMsg(0, "example.com. 60 IN A 1.1.1.1")},
+			b:         result{r: newMsg(0, "example.com. 60 IN A 2.2.2.2")},
+			wantDiffs: 1,
+		},
+		{
+			name:      "different rcode",
+			a:         result{r: newMsg(dns.RcodeNameError)},
+			b:         result{r: newMsg(dns.RcodeServerFailure)},
+			wantDiffs: 1,
+		},
+		{
+			name:      "error and no response",
+			a:         result{r: newMsg(0)},
+			b:         result{err: errors.New("timeout")},
+			wantDiffs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(tt.a, tt.b); len(got) != tt.wantDiffs {
+				t.Errorf("diff() = %v, want %d diffs", got, tt.wantDiffs)
+			}
+		})
+	}
+}
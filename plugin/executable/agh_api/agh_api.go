@@ -0,0 +1,292 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package agh_api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/query_stats"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/go-chi/chi/v5"
+)
+
+const PluginType = "agh_api"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Filters are tags of domain_set plugins that are reported as the
+	// filter lists by "/control/filtering/status".
+	Filters []string `yaml:"filters"`
+	// QueryLogSize is the number of the latest queries that are kept
+	// in the query log. Default is 1000.
+	QueryLogSize int `yaml:"query_log_size"`
+	// BlockedMark, Top and MaxKeys are the same as query_stats.
+	BlockedMark uint32 `yaml:"blocked_mark"`
+	Top         int    `yaml:"top"`
+	MaxKeys     int    `yaml:"max_keys"`
+	// MountRoot also mounts the api to "/control" of the http api, so
+	// apps for AdGuard Home can use the address of the http api as is.
+	// Only one plugin can do this.
+	MountRoot bool `yaml:"mount_root"`
+	// Version is reported by "/control/status". Some apps check it
+	// to enable their features. Default is "v0.107.0".
+	Version string `yaml:"version"`
+	// Users are accepted basic auth credentials in "user:password"
+	// format, like the login of AdGuard Home. The api serves the query
+	// log and clients, so at least one user is required.
+	Users []string `yaml:"users"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.QueryLogSize, 1000)
+	utils.SetDefaultString(&a.Version, "v0.107.0")
+}
+
+var _ sequence.RecursiveExecutable = (*AGHApi)(nil)
+
+// AGHApi records queries that passed through it, and serves a subset
+// of the AdGuard Home api ("/control/status", "/control/stats",
+// "/control/querylog" and "/control/filtering/status") from them.
+// So apps and dashboards that were built for AdGuard Home can be used
+// with mosdns.
+type AGHApi struct {
+	args    *Args
+	stats   *query_stats.QueryStats
+	log     *queryLog
+	filters []filter
+}
+
+// filter is a domain_set that is reported as a filter list.
+type filter struct {
+	tag string
+	p   any // rules are counted if p has a Len() int method.
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	p, err := NewAGHApi(a)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range a.Filters {
+		fp := bp.M().GetPlugin(tag)
+		if fp == nil {
+			return nil, fmt.Errorf("cannot find filter %s", tag)
+		}
+		p.filters = append(p.filters, filter{tag: tag, p: fp})
+	}
+	bp.RegAPI(p.Api())
+	if a.MountRoot {
+		r := bp.M().GetAPIRouter()
+		if r.Match(chi.NewRouteContext(), http.MethodGet, "/control/status") {
+			return nil, errors.New("/control has been mounted by another plugin")
+		}
+		r.Mount("/control", p.controlApi())
+	}
+	return p, nil
+}
+
+func NewAGHApi(args *Args) (*AGHApi, error) {
+	args.init()
+	if len(args.Users) == 0 {
+		return nil, errors.New("no user is configured")
+	}
+	for _, u := range args.Users {
+		if !strings.Contains(u, ":") {
+			return nil, errors.New("user must be in \"user:password\" format")
+		}
+	}
+	return &AGHApi{
+		args: args,
+		stats: query_stats.NewQueryStats(&query_stats.Args{
+			Top:         args.Top,
+			MaxKeys:     args.MaxKeys,
+			BlockedMark: args.BlockedMark,
+		}),
+		log: newQueryLog(args.QueryLogSize),
+	}, nil
+}
+
+func (p *AGHApi) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	p.stats.Add(qCtx, err)
+	p.log.add(newLogEntry(qCtx, err, p.stats.IsBlocked(qCtx, err)))
+	return err
+}
+
+// Api returns the api that is mounted to "/plugins/<tag>". The AdGuard
+// Home api is under its "/control".
+func (p *AGHApi) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Mount("/control", p.controlApi())
+	return r
+}
+
+func (p *AGHApi) controlApi() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(basicAuth(p.args.Users))
+	r.Get("/status", p.serveStatus)
+	r.Get("/stats", p.serveStats)
+	r.Get("/querylog", p.serveQueryLog)
+	r.Get("/filtering/status", p.serveFilteringStatus)
+	return r
+}
+
+// basicAuth rejects requests that have no basic auth credential in users.
+func basicAuth(users []string) func(http.Handler) http.Handler {
+	wants := make([][]byte, 0, len(users))
+	for _, u := range users {
+		wants = append(wants, []byte(u))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			user, password, _ := req.BasicAuth()
+			got := []byte(user + ":" + password)
+			found := 0
+			for _, want := range wants {
+				found |= subtle.ConstantTimeCompare(got, want)
+			}
+			if found != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="AdGuard Home"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type statusResp struct {
+	Version           string `json:"version"`
+	Running           bool   `json:"running"`
+	ProtectionEnabled bool   `json:"protection_enabled"`
+	Language          string `json:"language"`
+}
+
+func (p *AGHApi) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, statusResp{Version: p.args.Version, Running: true, ProtectionEnabled: true})
+}
+
+// statsResp is the stats of the last 24 hours. mosdns has no safe
+// browsing, safe search, parental control and upstream stats, they are
+// always empty.
+type statsResp struct {
+	TimeUnits               string               `json:"time_units"`
+	NumDNSQueries           uint64               `json:"num_dns_queries"`
+	NumBlockedFiltering     uint64               `json:"num_blocked_filtering"`
+	NumReplacedSafebrowsing uint64               `json:"num_replaced_safebrowsing"`
+	NumReplacedSafesearch   uint64               `json:"num_replaced_safesearch"`
+	NumReplacedParental     uint64               `json:"num_replaced_parental"`
+	AvgProcessingTime       float64              `json:"avg_processing_time"` // in seconds
+	DNSQueries              []uint64             `json:"dns_queries"`
+	BlockedFiltering        []uint64             `json:"blocked_filtering"`
+	ReplacedSafebrowsing    []uint64             `json:"replaced_safebrowsing"`
+	ReplacedParental        []uint64             `json:"replaced_parental"`
+	TopQueriedDomains       []map[string]uint64  `json:"top_queried_domains"`
+	TopClients              []map[string]uint64  `json:"top_clients"`
+	TopBlockedDomains       []map[string]uint64  `json:"top_blocked_domains"`
+	TopUpstreamsResponses   []map[string]uint64  `json:"top_upstreams_responses"`
+	TopUpstreamsAvgTime     []map[string]float64 `json:"top_upstreams_avg_time"`
+}
+
+func (p *AGHApi) serveStats(w http.ResponseWriter, _ *http.Request) {
+	s, _ := p.stats.Stats("day")
+	n := len(s.Series)
+	resp := statsResp{
+		TimeUnits:             "hours",
+		NumDNSQueries:         s.Total,
+		NumBlockedFiltering:   s.Blocked,
+		AvgProcessingTime:     s.AvgElapsedMs / 1000,
+		DNSQueries:            make([]uint64, 0, n),
+		BlockedFiltering:      make([]uint64, 0, n),
+		ReplacedSafebrowsing:  make([]uint64, n),
+		ReplacedParental:      make([]uint64, n),
+		TopQueriedDomains:     toTopList(s.TopDomains),
+		TopClients:            toTopList(s.TopClients),
+		TopBlockedDomains:     toTopList(s.TopBlockedDomains),
+		TopUpstreamsResponses: []map[string]uint64{},
+		TopUpstreamsAvgTime:   []map[string]float64{},
+	}
+	for _, point := range s.Series {
+		resp.DNSQueries = append(resp.DNSQueries, point.Total)
+		resp.BlockedFiltering = append(resp.BlockedFiltering, point.Blocked)
+	}
+	writeJSON(w, resp)
+}
+
+// toTopList converts es to the format of AdGuard Home, which is a list
+// of single entry objects.
+func toTopList(es []query_stats.Entry) []map[string]uint64 {
+	l := make([]map[string]uint64, 0, len(es))
+	for _, e := range es {
+		l = append(l, map[string]uint64{e.Name: e.Count})
+	}
+	return l
+}
+
+type filteringStatusResp struct {
+	Enabled          bool         `json:"enabled"`
+	Interval         int          `json:"interval"`
+	Filters          []filterResp `json:"filters"`
+	WhitelistFilters []filterResp `json:"whitelist_filters"`
+	UserRules        []string     `json:"user_rules"`
+}
+
+type filterResp struct {
+	ID         int    `json:"id"`
+	Enabled    bool   `json:"enabled"`
+	URL        string `json:"url"`
+	Name       string `json:"name"`
+	RulesCount int    `json:"rules_count"`
+}
+
+func (p *AGHApi) serveFilteringStatus(w http.ResponseWriter, _ *http.Request) {
+	resp := filteringStatusResp{
+		Enabled:          true,
+		Filters:          make([]filterResp, 0, len(p.filters)),
+		WhitelistFilters: []filterResp{},
+		UserRules:        []string{},
+	}
+	for i, f := range p.filters {
+		fr := filterResp{ID: i + 1, Enabled: true, URL: f.tag, Name: f.tag}
+		if l, ok := f.p.(interface{ Len() int }); ok {
+			fr.RulesCount = l.Len()
+		}
+		resp.Filters = append(resp.Filters, fr)
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package agh_api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+func TestAGHApi(t *testing.T) {
+	if _, err := NewAGHApi(&Args{}); err == nil {
+		t.Fatal("api without users should be rejected")
+	}
+	p, err := NewAGHApi(&Args{QueryLogSize: 2, Users: []string{"admin:secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.Api()
+
+	for _, user := range []string{"", "admin:wrong", "other:secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/control/querylog", nil)
+		if u, pw, ok := strings.Cut(user, ":"); ok {
+			req.SetBasicAuth(u, pw)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("user %q: want 401, got %d", user, w.Code)
+		}
+	}
+
+	var next sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		ip := net.IPv4(1, 2, 3, 4)
+		if qCtx.QQuestion().Name == "blocked.com." {
+			ip = net.IPv4zero
+		}
+		r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: qCtx.QQuestion().Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: ip})
+		qCtx.SetResponse(r)
+		return nil
+	}
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	for _, name := range []string{"dropped.com.", "a.com.", "blocked.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		if err := p.Exec(context.Background(), query_context.NewContext(q), cw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string, v any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("admin", "secret")
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", path, w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	stats := new(statsResp)
+	get("/control/stats", stats)
+	if stats.NumDNSQueries != 3 || stats.NumBlockedFiltering != 1 || len(stats.DNSQueries) != 24 || stats.DNSQueries[23] != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(stats.TopBlockedDomains) != 1 || stats.TopBlockedDomains[0]["blocked.com"] != 1 {
+		t.Fatalf("unexpected top blocked domains %v", stats.TopBlockedDomains)
+	}
+
+	// The log only keeps the latest 2 entries.
+	ql := new(queryLogResp)
+	get("/control/querylog", ql)
+	if len(ql.Data) != 2 || ql.Data[0].Question.Name != "blocked.com" || ql.Data[1].Question.Name != "a.com" {
+		t.Fatalf("unexpected query log %+v", ql.Data)
+	}
+	if ql.Data[0].Reason != "FilteredBlackList" || ql.Data[1].Reason != "NotFilteredNotFound" {
+		t.Fatalf("unexpected reasons %+v", ql.Data)
+	}
+	if a := ql.Data[1].Answer; len(a) != 1 || a[0].Value != "1.2.3.4" || a[0].TTL != 300 {
+		t.Fatalf("unexpected answer %+v", a)
+	}
+
+	get("/control/querylog?response_status=processed", ql)
+	if len(ql.Data) != 1 || ql.Data[0].Question.Name != "a.com" {
+		t.Fatalf("unexpected processed query log %+v", ql.Data)
+	}
+	get("/control/querylog?limit=1&search=BLOCKED", ql)
+	if len(ql.Data) != 1 || ql.Data[0].Question.Name != "blocked.com" {
+		t.Fatalf("unexpected searched query log %+v", ql.Data)
+	}
+	get("/control/querylog?older_than="+ql.Oldest, ql)
+	if len(ql.Data) != 1 || ql.Data[0].Question.Name != "a.com" {
+		t.Fatalf("unexpected older query log %+v", ql.Data)
+	}
+
+	status := new(statusResp)
+	get("/control/status", status)
+	if !status.Running || status.Version != "v0.107.0" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	fs := new(filteringStatusResp)
+	get("/control/filtering/status", fs)
+	if !fs.Enabled || fs.Filters == nil {
+		t.Fatalf("unexpected filtering status %+v", fs)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package agh_api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+// logEntry is a query log entry in the format of AdGuard Home.
+type logEntry struct {
+	Answer      []answer   `json:"answer,omitempty"`
+	Client      string     `json:"client"`
+	ClientID    string     `json:"client_id,omitempty"`
+	ClientProto string     `json:"client_proto"`
+	ElapsedMs   string     `json:"elapsedMs"`
+	Question    question   `json:"question"`
+	Reason      string     `json:"reason"`
+	Rules       []struct{} `json:"rules"`
+	Status      string     `json:"status"`
+	Time        time.Time  `json:"time"`
+	Upstream    string     `json:"upstream"`
+
+	blocked bool
+}
+
+type answer struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+type question struct {
+	Class string `json:"class"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+}
+
+func newLogEntry(qCtx *query_context.Context, err error, blocked bool) *logEntry {
+	q := qCtx.QQuestion()
+	e := &logEntry{
+		ClientID:  qCtx.ServerMeta.ClientID,
+		ElapsedMs: strconv.FormatFloat(float64(time.Since(qCtx.StartTime()))/float64(time.Millisecond), 'f', 3, 64),
+		Question: question{
+			Class: dns.ClassToString[q.Qclass],
+			Name:  strings.TrimSuffix(q.Name, "."),
+			Type:  dns.TypeToString[q.Qtype],
+		},
+		Reason:  "NotFilteredNotFound",
+		Rules:   []struct{}{},
+		Time:    qCtx.StartTime(),
+		blocked: blocked,
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		e.Client = addr.String()
+	}
+	// Only DoH can be told from the server meta.
+	if len(qCtx.ServerMeta.UrlPath) > 0 {
+		e.ClientProto = "doh"
+	}
+	if blocked {
+		e.Reason = "FilteredBlackList"
+	}
+	r := qCtx.R()
+	switch {
+	case err != nil:
+		e.Status = "SERVFAIL"
+	case r != nil:
+		e.Status = dns.RcodeToString[r.Rcode]
+		for _, rr := range r.Answer {
+			e.Answer = append(e.Answer, answer{
+				Type:  dns.TypeToString[rr.Header().Rrtype],
+				Value: strings.TrimPrefix(rr.String(), rr.Header().String()),
+				TTL:   rr.Header().Ttl,
+			})
+		}
+	}
+	if v, ok := qCtx.GetValue(query_context.KeyUpstream); ok {
+		e.Upstream, _ = v.(string)
+	}
+	return e
+}
+
+// queryLog keeps the latest entries in a ring buffer.
+type queryLog struct {
+	m       sync.Mutex
+	entries []*logEntry
+	next    int
+}
+
+func newQueryLog(size int) *queryLog {
+	return &queryLog{entries: make([]*logEntry, size)}
+}
+
+func (l *queryLog) add(e *logEntry) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// list returns at most limit entries that are older than olderThan
+// (if it's not zero) and accepted by f, from the newest to the oldest.
+func (l *queryLog) list(olderThan time.Time, limit int, f func(e *logEntry) bool) []*logEntry {
+	l.m.Lock()
+	defer l.m.Unlock()
+	es := make([]*logEntry, 0, min(limit, len(l.entries)))
+	for i := 1; i <= len(l.entries) && len(es) < limit; i++ {
+		e := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if e == nil {
+			break
+		}
+		if !olderThan.IsZero() && !e.Time.Before(olderThan) {
+			continue
+		}
+		if f(e) {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+type queryLogResp struct {
+	Data   []*logEntry `json:"data"`
+	Oldest string      `json:"oldest"`
+}
+
+// serveQueryLog serves the query log. It supports url query parameters
+// "older_than", "limit", "search" and "response_status".
+func (p *AGHApi) serveQueryLog(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	var olderThan time.Time
+	if s := params.Get("older_than"); len(s) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			http.Error(w, "invalid older_than", http.StatusBadRequest)
+			return
+		}
+		olderThan = t
+	}
+	limit := 500
+	if s := params.Get("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	search := strings.ToLower(params.Get("search"))
+
+	var matchStatus func(e *logEntry) bool
+	switch params.Get("response_status") {
+	case "", "all":
+		matchStatus = func(*logEntry) bool { return true }
+	case "filtered", "blocked":
+		matchStatus = func(e *logEntry) bool { return e.blocked }
+	case "processed":
+		matchStatus = func(e *logEntry) bool { return !e.blocked }
+	default:
+		// Other statuses, e.g. "blocked_parental", never happen in mosdns.
+		matchStatus = func(*logEntry) bool { return false }
+	}
+
+	es := p.log.list(olderThan, limit, func(e *logEntry) bool {
+		if len(search) > 0 &&
+			!strings.Contains(strings.ToLower(e.Question.Name), search) &&
+			!strings.Contains(e.Client, search) {
+			return false
+		}
+		return matchStatus(e)
+	})
+	resp := queryLogResp{Data: es}
+	if len(es) > 0 {
+		resp.Oldest = es[len(es)-1].Time.Format(time.RFC3339Nano)
+	}
+	writeJSON(w, resp)
+}
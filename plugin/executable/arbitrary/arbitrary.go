@@ -24,9 +24,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnssec"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/zone_file"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
 	"os"
 	"strings"
 )
@@ -40,12 +42,16 @@ func init() {
 type Args struct {
 	Rules []string `yaml:"rules"`
 	Files []string `yaml:"files"`
+
+	// DNSSEC signs responses of the zone online. Optional.
+	DNSSEC dnssec.Args `yaml:"dnssec"`
 }
 
 var _ sequence.Executable = (*Arbitrary)(nil)
 
 type Arbitrary struct {
-	m *zone_file.Matcher
+	m      *zone_file.Matcher
+	signer *dnssec.Signer // maybe nil
 }
 
 func NewArbitrary(args *Args) (*Arbitrary, error) {
@@ -64,13 +70,39 @@ func NewArbitrary(args *Args) (*Arbitrary, error) {
 			return nil, fmt.Errorf("failed to load rr file #%d [%s], %w", i, file, err)
 		}
 	}
+	signer, err := dnssec.NewSignerFromArgs(args.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
 	return &Arbitrary{
-		m: m,
+		m:      m,
+		signer: signer,
 	}, nil
 }
 
 func (a *Arbitrary) Exec(_ context.Context, qCtx *query_context.Context) error {
-	if r := a.m.Reply(qCtx.Q()); r != nil {
+	q := qCtx.Q()
+	r := a.m.Reply(q)
+	if a.signer != nil {
+		name := qCtx.QQuestion().Name
+		types := a.m.Types(name)
+		if r == nil {
+			r = a.signer.Reply(q)
+		}
+		if r == nil && len(types) > 0 && a.signer.InZone(name) {
+			// NODATA of a name in the zone, so it can be signed.
+			r = new(dns.Msg)
+			r.SetReply(q)
+			r.Authoritative = true
+		}
+		if opt := qCtx.ClientOpt(); r != nil && opt != nil && opt.Do() {
+			// The NSEC of NODATA must not deny types that exist.
+			if err := a.signer.Sign(r, types); err != nil {
+				return fmt.Errorf("failed to sign response, %w", err)
+			}
+		}
+	}
+	if r != nil {
 		qCtx.SetResponse(r)
 	}
 	return nil
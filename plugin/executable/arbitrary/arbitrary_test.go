@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package arbitrary
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnssec"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestArbitrary_dnssec_nodata(t *testing.T) {
+	dir := t.TempDir()
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.lan.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("Kexample.lan.+013+%05d", k.KeyTag()))
+	if err := os.WriteFile(base+".key", []byte(k.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".private", []byte(k.PrivateKeyString(priv)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewArbitrary(&Args{
+		Rules: []string{
+			"host.example.lan. IN A 192.168.1.1",
+			"host.example.lan. IN TXT hello",
+		},
+		DNSSEC: dnssec.Args{Zone: "example.lan", Keys: []string{base + ".key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("host.example.lan.", dns.TypeAAAA)
+	q.SetEdns0(1232, true)
+	qCtx := query_context.NewContext(q)
+	if err := a.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	r := qCtx.R()
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 {
+		t.Fatalf("want NODATA, got %v", r)
+	}
+	var nsec *dns.NSEC
+	for _, rr := range r.Ns {
+		if rr, ok := rr.(*dns.NSEC); ok {
+			nsec = rr
+		}
+	}
+	if nsec == nil {
+		t.Fatalf("missing NSEC, %v", r)
+	}
+	for _, typ := range []uint16{dns.TypeA, dns.TypeTXT} {
+		if !slices.Contains(nsec.TypeBitMap, typ) {
+			t.Fatalf("NSEC denies existing type %s, %v", dns.TypeToString[typ], nsec)
+		}
+	}
+	if slices.Contains(nsec.TypeBitMap, dns.TypeAAAA) {
+		t.Fatalf("NSEC should deny AAAA, %v", nsec)
+	}
+}
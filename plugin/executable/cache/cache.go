@@ -20,23 +20,30 @@
 package cache
 
 import (
+	"cmp"
 	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/xdp"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/go-chi/chi/v5"
 	"github.com/klauspost/compress/gzip"
@@ -67,17 +74,43 @@ const (
 )
 
 var _ sequence.RecursiveExecutable = (*Cache)(nil)
+var _ coremain.Flusher = (*Cache)(nil)
+var _ coremain.StateInspector = (*Cache)(nil)
+var _ coremain.MemoryShrinker = (*Cache)(nil)
 
 type Args struct {
 	Size         int    `yaml:"size"`
 	LazyCacheTTL int    `yaml:"lazy_cache_ttl"`
 	DumpFile     string `yaml:"dump_file"`
 	DumpInterval int    `yaml:"dump_interval"`
+
+	// XDP answers the hottest cached queries in the kernel.
+	XDP XDPArgs `yaml:"xdp"`
+}
+
+// XDPArgs configures the xdp fast path. Linux only. Only plain udp ipv4
+// queries (no AD, CD, DO bits and ECS) for NOERROR and NXDOMAIN responses
+// are answered by the kernel. Others are passed to mosdns as usual.
+//
+// Queries answered by the kernel never reach any plugin, so everything
+// in front of the cache (e.g. client based matching, blocking and
+// logging) is skipped for them. The kernel only answers the clients in
+// Clients. Use "0.0.0.0/0" to answer all clients, which is only correct
+// if the cache is the first node of the entry sequence.
+type XDPArgs struct {
+	Iface          string   `yaml:"iface"`           // Network interface. Empty disables xdp.
+	Port           int      `yaml:"port"`            // Default is 53.
+	TopN           int      `yaml:"top_n"`           // Default is 128.
+	UpdateInterval int      `yaml:"update_interval"` // In seconds. Default is 2.
+	Clients        []string `yaml:"clients"`         // Ipv4 addresses or prefixes. Required.
 }
 
 func (a *Args) init() {
 	utils.SetDefaultUnsignNum(&a.Size, 1024)
 	utils.SetDefaultUnsignNum(&a.DumpInterval, 600)
+	utils.SetDefaultUnsignNum(&a.XDP.Port, 53)
+	utils.SetDefaultUnsignNum(&a.XDP.TopN, 128)
+	utils.SetDefaultUnsignNum(&a.XDP.UpdateInterval, 2)
 }
 
 type Cache struct {
@@ -89,17 +122,50 @@ type Cache struct {
 	closeOnce    sync.Once
 	closeNotify  chan struct{}
 	updatedKey   atomic.Uint64
+	sizeLimit    atomic.Int64 // current max size, may be shrunk by ShrinkMemory.
+	xdp          *xdp.Responder
+
+	recursionAllowed func(addr netip.Addr) bool // maybe nil
 
 	queryTotal   prometheus.Counter
 	hitTotal     prometheus.Counter
 	lazyHitTotal prometheus.Counter
+	missTotal    prometheus.Counter
+	xdpHitTotal  prometheus.Counter
 	size         prometheus.GaugeFunc
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	c := NewCache(args.(*Args), Opts{
-		Logger:     bp.L(),
-		MetricsTag: bp.Tag(),
+	a := args.(*Args)
+	var x *xdp.Responder
+	if len(a.XDP.Iface) > 0 {
+		// The kernel cannot check clients against the acl.
+		if bp.M().RecursionACLEnabled() {
+			return nil, errors.New("xdp cannot be used with the recursion acl")
+		}
+		a.init()
+		if a.XDP.Port > 65535 {
+			return nil, fmt.Errorf("invalid xdp port %d", a.XDP.Port)
+		}
+		clients, err := parseXDPClients(a.XDP.Clients)
+		if err != nil {
+			return nil, err
+		}
+		x, err = xdp.NewResponder(xdp.Opts{
+			Iface:      a.XDP.Iface,
+			Port:       uint16(a.XDP.Port),
+			MaxEntries: a.XDP.TopN,
+			Clients:    clients,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to init xdp, %w", err)
+		}
+	}
+	c := NewCache(a, Opts{
+		Logger:           bp.L(),
+		MetricsTag:       bp.Tag(),
+		XDP:              x,
+		RecursionAllowed: bp.M().RecursionAllowed,
 	})
 
 	if err := c.RegMetricsTo(prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg())); err != nil {
@@ -121,12 +187,22 @@ func quickSetupCache(bq sequence.BQ, s string) (any, error) {
 		size = i
 	}
 	// Don't register metrics in quick setup.
-	return NewCache(&Args{Size: size}, Opts{Logger: bq.L()}), nil
+	return NewCache(&Args{Size: size}, Opts{Logger: bq.L(), RecursionAllowed: bq.M().RecursionAllowed}), nil
 }
 
 type Opts struct {
 	Logger     *zap.Logger
 	MetricsTag string
+
+	// XDP, if not nil, is periodically loaded with the hottest entries.
+	// Cache takes its ownership and closes it in Close.
+	XDP *xdp.Responder
+
+	// RecursionAllowed reports whether the client is allowed to get
+	// answers from upstreams. Cached responses from upstreams are not
+	// returned to other clients, so the recursion acl cannot be
+	// bypassed by cache hits. Nil means all clients are allowed.
+	RecursionAllowed func(addr netip.Addr) bool
 }
 
 func NewCache(args *Args, opts Opts) *Cache {
@@ -144,6 +220,9 @@ func NewCache(args *Args, opts Opts) *Cache {
 		logger:      logger,
 		backend:     backend,
 		closeNotify: make(chan struct{}),
+		xdp:         opts.XDP,
+
+		recursionAllowed: opts.RecursionAllowed,
 
 		queryTotal: prometheus.NewCounter(prometheus.CounterOpts{
 			Name:        "query_total",
@@ -160,6 +239,16 @@ func NewCache(args *Args, opts Opts) *Cache {
 			Help:        "The total number of queries that hit the expired cache",
 			ConstLabels: lb,
 		}),
+		missTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "miss_total",
+			Help:        "The total number of cacheable queries that missed the cache",
+			ConstLabels: lb,
+		}),
+		xdpHitTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "xdp_hit_total",
+			Help:        "The total number of queries that were answered by the xdp program",
+			ConstLabels: lb,
+		}),
 		size: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Name:        "size_current",
 			Help:        "Current cache size in records",
@@ -169,16 +258,19 @@ func NewCache(args *Args, opts Opts) *Cache {
 		}),
 	}
 
+	p.sizeLimit.Store(int64(args.Size))
+
 	if err := p.loadDump(); err != nil {
 		p.logger.Error("failed to load cache dump", zap.Error(err))
 	}
 	p.startDumpLoop()
+	p.startXDPLoop()
 
 	return p
 }
 
 func (c *Cache) RegMetricsTo(r prometheus.Registerer) error {
-	for _, collector := range [...]prometheus.Collector{c.queryTotal, c.hitTotal, c.lazyHitTotal, c.size} {
+	for _, collector := range [...]prometheus.Collector{c.queryTotal, c.hitTotal, c.lazyHitTotal, c.missTotal, c.xdpHitTotal, c.size} {
 		if err := r.Register(collector); err != nil {
 			return err
 		}
@@ -195,7 +287,11 @@ func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequ
 		return next.ExecNext(ctx, qCtx)
 	}
 
-	cachedResp, lazyHit := getRespFromCache(msgKey, c.backend, c.args.LazyCacheTTL > 0, expiredMsgTtl)
+	cachedResp, lazyHit, forwarded := getRespFromCache(msgKey, c.backend, c.args.LazyCacheTTL > 0, expiredMsgTtl)
+	if cachedResp != nil && forwarded && c.recursionAllowed != nil && !c.recursionAllowed(qCtx.ServerMeta.ClientAddr) {
+		// Let the next nodes (e.g. forward) refuse this query.
+		cachedResp, lazyHit = nil, false
+	}
 	if lazyHit {
 		c.lazyHitTotal.Inc()
 		c.doLazyUpdate(msgKey, qCtx, next)
@@ -204,17 +300,25 @@ func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequ
 		c.hitTotal.Inc()
 		cachedResp.Id = q.Id // change msg id
 		qCtx.SetResponse(cachedResp)
+	} else {
+		c.missTotal.Inc()
 	}
 
 	err := next.ExecNext(ctx, qCtx)
 
 	if r := qCtx.R(); r != nil && cachedResp != r { // pointer compare. r is not cachedResp
-		saveRespToCache(msgKey, r, c.backend, c.args.LazyCacheTTL)
+		saveRespToCache(msgKey, r, isForwarded(qCtx), c.backend, c.args.LazyCacheTTL)
 		c.updatedKey.Add(1)
 	}
 	return err
 }
 
+// isForwarded reports whether the response of qCtx was from upstreams.
+func isForwarded(qCtx *query_context.Context) bool {
+	_, ok := qCtx.GetValue(query_context.KeyUpstream)
+	return ok
+}
+
 // doLazyUpdate starts a new goroutine to execute next node and update the cache in the background.
 // It has an inner singleflight.Group to de-duplicate same msgKey.
 func (c *Cache) doLazyUpdate(msgKey string, qCtx *query_context.Context, next sequence.ChainWalker) {
@@ -234,7 +338,7 @@ func (c *Cache) doLazyUpdate(msgKey string, qCtx *query_context.Context, next se
 
 		r := qCtx.R()
 		if r != nil {
-			saveRespToCache(msgKey, r, c.backend, c.args.LazyCacheTTL)
+			saveRespToCache(msgKey, r, isForwarded(qCtx), c.backend, c.args.LazyCacheTTL)
 			c.updatedKey.Add(1)
 		}
 		c.logger.Debug("lazy cache updated", qCtx.InfoField())
@@ -249,6 +353,11 @@ func (c *Cache) Close() error {
 	}
 	c.closeOnce.Do(func() {
 		close(c.closeNotify)
+		if c.xdp != nil {
+			if err := c.xdp.Close(); err != nil {
+				c.logger.Error("failed to close xdp", zap.Error(err))
+			}
+		}
 	})
 	return c.backend.Close()
 }
@@ -298,6 +407,107 @@ func (c *Cache) startDumpLoop() {
 	}()
 }
 
+// parseXDPClients parses the xdp client allowlist.
+func parseXDPClients(ss []string) ([]netip.Prefix, error) {
+	if len(ss) == 0 {
+		return nil, errors.New("xdp clients are required, use 0.0.0.0/0 to allow all clients")
+	}
+	prefixes := make([]netip.Prefix, 0, len(ss))
+	for _, s := range ss {
+		var p netip.Prefix
+		if strings.ContainsRune(s, '/') {
+			var err error
+			if p, err = netip.ParsePrefix(s); err != nil {
+				return nil, fmt.Errorf("invalid xdp client %s, %w", s, err)
+			}
+		} else {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid xdp client %s, %w", s, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("invalid xdp client %s, only ipv4 is supported", s)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// startXDPLoop starts a loop in another goroutine that loads the hottest
+// entries to the xdp program. It does not block.
+func (c *Cache) startXDPLoop() {
+	if c.xdp == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(c.args.XDP.UpdateInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.updateXDP()
+			case <-c.closeNotify:
+				return
+			}
+		}
+	}()
+}
+
+// updateXDP replaces the xdp entries with the top n entries that have
+// the most hits. Queries answered by the xdp program count as hits of
+// their entries. Hits decay by half on every update, so recent hits
+// weigh more and idle entries are evicted after a few updates.
+func (c *Cache) updateXDP() {
+	xdpHits := c.xdp.Hits()
+	var xdpHitSum uint64
+	for _, n := range xdpHits {
+		xdpHitSum += n
+	}
+	c.xdpHitTotal.Add(float64(xdpHitSum))
+
+	type hotItem struct {
+		k    xdp.Key
+		v    *item
+		hits uint64
+	}
+	var hot []hotItem
+	now := time.Now()
+	_ = c.backend.Range(func(k key, v *item, _ time.Time) error {
+		hits := uint64(v.hits.Swap(0))
+		if hits == 0 && len(xdpHits) == 0 {
+			return nil
+		}
+		xk, ok := getXDPKey(k)
+		if !ok {
+			return nil
+		}
+		hits += xdpHits[xk]
+		delete(xdpHits, xk)
+		v.hits.Add(uint32(min(hits/2, math.MaxUint32)))
+		if hits == 0 || !now.Before(v.expirationTime) {
+			return nil
+		}
+		if rcode := v.resp.Rcode; rcode != dns.RcodeSuccess && rcode != dns.RcodeNameError {
+			return nil
+		}
+		hot = append(hot, hotItem{k: xk, v: v, hits: hits})
+		return nil
+	})
+
+	slices.SortFunc(hot, func(a, b hotItem) int { return cmp.Compare(b.hits, a.hits) })
+	entries := make([]xdp.Entry, 0, min(len(hot), c.args.XDP.TopN))
+	for _, h := range hot[:min(len(hot), c.args.XDP.TopN)] {
+		r := h.v.resp.Copy()
+		dnsutils.SubtractTTL(r, uint32(now.Sub(h.v.storedTime).Seconds()))
+		entries = append(entries, xdp.Entry{Key: h.k, Resp: r, Expire: h.v.expirationTime})
+	}
+	if _, err := c.xdp.Update(entries); err != nil {
+		c.logger.Warn("failed to update xdp entries", zap.Error(err))
+	}
+}
+
 func (c *Cache) dumpCache() error {
 	if len(c.args.DumpFile) == 0 {
 		return nil
@@ -317,10 +527,37 @@ func (c *Cache) dumpCache() error {
 	return nil
 }
 
+// Flush implements coremain.Flusher.
+func (c *Cache) Flush() {
+	c.backend.Flush()
+	if c.xdp != nil {
+		if _, err := c.xdp.Update(nil); err != nil {
+			c.logger.Warn("failed to flush xdp entries", zap.Error(err))
+		}
+	}
+}
+
+// ShrinkMemory implements coremain.MemoryShrinker.
+func (c *Cache) ShrinkMemory(ratio float64) {
+	size := max(int(float64(c.args.Size)*ratio), 1)
+	c.sizeLimit.Store(int64(size))
+	c.backend.SetSize(size)
+}
+
+// State implements coremain.StateInspector.
+func (c *Cache) State() any {
+	return map[string]any{
+		"size":      c.backend.Len(),
+		"max_size":  c.sizeLimit.Load(),
+		"lazy_ttl":  c.args.LazyCacheTTL,
+		"dump_file": c.args.DumpFile,
+	}
+}
+
 func (c *Cache) Api() *chi.Mux {
 	r := chi.NewRouter()
 	r.Get("/flush", func(w http.ResponseWriter, req *http.Request) {
-		c.backend.Flush()
+		c.Flush()
 	})
 	r.Get("/dump", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("content-type", "application/octet-stream")
@@ -458,6 +695,7 @@ func (c *Cache) readDump(r io.Reader) (int, error) {
 				resp:           resp,
 				storedTime:     storedTime,
 				expirationTime: msgExpTime,
+				forwarded:      true, // unknown, dumps do not have it.
 			}
 			c.backend.Store(key(entry.GetKey()), i, cacheExpTime)
 		}
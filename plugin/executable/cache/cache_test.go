@@ -21,6 +21,7 @@ package cache
 
 import (
 	"bytes"
+	"github.com/IrineSistiana/mosdns/v5/pkg/xdp"
 	"github.com/miekg/dns"
 	"strconv"
 	"testing"
@@ -60,3 +61,50 @@ func Test_cachePlugin_Dump(t *testing.T) {
 		t.Fatalf("read err, wrote %d entries, read %d", enw, enr)
 	}
 }
+
+func Test_getXDPKey(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("Example.COM.", dns.TypeAAAA)
+	k, ok := getXDPKey(key(getMsgKey(q)))
+	if !ok || k != (xdp.Key{Name: "example.com.", Qtype: dns.TypeAAAA}) {
+		t.Fatalf("getXDPKey() = %v, %v", k, ok)
+	}
+
+	q.CheckingDisabled = true
+	if _, ok := getXDPKey(key(getMsgKey(q))); ok {
+		t.Fatal("query with CD bit should not have a xdp key")
+	}
+
+	q.CheckingDisabled = false
+	opt := q.SetEdns0(1232, false).IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{1, 2, 3}})
+	if _, ok := getXDPKey(key(getMsgKey(q))); ok {
+		t.Fatal("query with ECS should not have a xdp key")
+	}
+}
+
+func Test_parseXDPClients(t *testing.T) {
+	tests := []struct {
+		clients []string
+		want    []string
+		wantErr bool
+	}{
+		{clients: nil, wantErr: true},
+		{clients: []string{"0.0.0.0/0"}, want: []string{"0.0.0.0/0"}},
+		{clients: []string{"192.168.1.0/24", "10.0.0.1"}, want: []string{"192.168.1.0/24", "10.0.0.1/32"}},
+		{clients: []string{"fd00::/8"}, wantErr: true},
+		{clients: []string{"not an ip"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseXDPClients(tt.clients)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseXDPClients(%v) error = %v, wantErr %v", tt.clients, err, tt.wantErr)
+			continue
+		}
+		for i, p := range got {
+			if p.String() != tt.want[i] {
+				t.Errorf("parseXDPClients(%v) = %v, want %v", tt.clients, got, tt.want)
+			}
+		}
+	}
+}
@@ -21,11 +21,14 @@ package cache
 
 import (
 	"hash/maphash"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
 	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/xdp"
 	"github.com/miekg/dns"
 	"golang.org/x/exp/constraints"
 )
@@ -105,10 +108,32 @@ func getMsgKey(q *dns.Msg) string {
 	return utils.BytesToStringUnsafe(buf)
 }
 
+// getXDPKey returns the xdp key of k. Only keys of plain queries that
+// have no AD, CD, DO bits and ECS can be answered by xdp.
+func getXDPKey(k key) (xdp.Key, bool) {
+	if len(k) < 5 || k[0] != 0 {
+		return xdp.Key{}, false
+	}
+	l := int(k[3])
+	if len(k) != 4+l+1 || k[4+l] != 0 {
+		return xdp.Key{}, false
+	}
+	return xdp.Key{
+		Name:  strings.ToLower(string(k[4 : 4+l])),
+		Qtype: uint16(k[1])<<8 | uint16(k[2]),
+	}, true
+}
+
 type item struct {
 	resp           *dns.Msg
 	storedTime     time.Time
 	expirationTime time.Time
+	hits           atomic.Uint32 // decayed by xdp updates
+
+	// forwarded is set if resp was from upstreams. Those responses are
+	// not returned to clients that are not allowed to recursion.
+	// See coremain.ACLConfig.
+	forwarded bool
 }
 
 func copyNoOpt(m *dns.Msg) *dns.Msg {
@@ -162,9 +187,10 @@ func min[T constraints.Ordered](a, b T) T {
 
 // getRespFromCache returns the cached response from cache.
 // The ttl of returned msg will be changed properly.
-// Returned bool indicates whether this response is hit by lazy cache.
+// Returned lazyHit indicates whether this response is hit by lazy cache,
+// and forwarded whether it was from upstreams.
 // Note: Caller SHOULD change the msg id because it's not same as query's.
-func getRespFromCache(msgKey string, backend *cache.Cache[key, *item], lazyCacheEnabled bool, lazyTtl int) (*dns.Msg, bool) {
+func getRespFromCache(msgKey string, backend *cache.Cache[key, *item], lazyCacheEnabled bool, lazyTtl int) (r *dns.Msg, lazyHit bool, forwarded bool) {
 	// Lookup cache
 	v, _, _ := backend.Get(key(msgKey))
 
@@ -174,9 +200,10 @@ func getRespFromCache(msgKey string, backend *cache.Cache[key, *item], lazyCache
 
 		// Not expired.
 		if now.Before(v.expirationTime) {
+			v.hits.Add(1)
 			r := v.resp.Copy()
 			dnsutils.SubtractTTL(r, uint32(now.Sub(v.storedTime).Seconds()))
-			return r, false
+			return r, false, v.forwarded
 		}
 
 		// Msg expired but cache isn't. This is a lazy cache enabled entry.
@@ -184,17 +211,18 @@ func getRespFromCache(msgKey string, backend *cache.Cache[key, *item], lazyCache
 		if lazyCacheEnabled {
 			r := v.resp.Copy()
 			dnsutils.SetTTL(r, uint32(lazyTtl))
-			return r, true
+			return r, true, v.forwarded
 		}
 	}
 
 	// cache miss
-	return nil, false
+	return nil, false, false
 }
 
-// saveRespToCache saves r to cache backend. It returns false if r
-// should not be cached and was skipped.
-func saveRespToCache(msgKey string, r *dns.Msg, backend *cache.Cache[key, *item], lazyCacheTtl int) bool {
+// saveRespToCache saves r to cache backend. forwarded indicates whether r
+// was from upstreams. It returns false if r should not be cached and
+// was skipped.
+func saveRespToCache(msgKey string, r *dns.Msg, forwarded bool, backend *cache.Cache[key, *item], lazyCacheTtl int) bool {
 	if r.Truncated != false {
 		return false
 	}
@@ -232,6 +260,7 @@ func saveRespToCache(msgKey string, r *dns.Msg, backend *cache.Cache[key, *item]
 		resp:           copyNoOpt(r),
 		storedTime:     now,
 		expirationTime: now.Add(msgTtl),
+		forwarded:      forwarded,
 	}
 	backend.Store(key(msgKey), v, now.Add(cacheTtl))
 	return true
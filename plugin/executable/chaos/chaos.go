@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package chaos
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "chaos"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+type Args struct {
+	// Version for "version.bind" and "version.server".
+	// Default is "mosdns" with the build version.
+	Version string `yaml:"version"`
+	// Hostname for "hostname.bind". Default is the os hostname.
+	Hostname string `yaml:"hostname"`
+	// ID for "id.server". Default is Hostname.
+	ID string `yaml:"id"`
+	// Refuse refuses these queries instead of answering them.
+	Refuse bool `yaml:"refuse"`
+}
+
+func (a *Args) init() {
+	if len(a.Version) == 0 {
+		a.Version = "mosdns"
+		if bi, ok := debug.ReadBuildInfo(); ok && len(bi.Main.Version) > 0 && bi.Main.Version != "(devel)" {
+			a.Version += " " + bi.Main.Version
+		}
+	}
+	if len(a.Hostname) == 0 {
+		a.Hostname, _ = os.Hostname()
+	}
+	if len(a.ID) == 0 {
+		a.ID = a.Hostname
+	}
+}
+
+var _ sequence.Executable = (*Chaos)(nil)
+
+// Chaos answers "version.bind", "version.server", "hostname.bind" and
+// "id.server" TXT queries in CHAOS class. Other queries are ignored.
+type Chaos struct {
+	values map[string]string // fqdn -> txt
+	refuse bool
+}
+
+func Init(_ *coremain.BP, args any) (any, error) {
+	return NewChaos(args.(*Args)), nil
+}
+
+// QuickSetup format: [refuse]
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	return NewChaos(&Args{Refuse: strings.TrimSpace(s) == "refuse"}), nil
+}
+
+func NewChaos(args *Args) *Chaos {
+	args.init()
+	return &Chaos{
+		values: map[string]string{
+			"version.bind.":   args.Version,
+			"version.server.": args.Version,
+			"hostname.bind.":  args.Hostname,
+			"id.server.":      args.ID,
+		},
+		refuse: args.Refuse,
+	}
+}
+
+func (c *Chaos) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	question := q.Question[0]
+	if question.Qclass != dns.ClassCHAOS {
+		return nil
+	}
+	v, ok := c.values[strings.ToLower(question.Name)]
+	if !ok {
+		return nil
+	}
+
+	r := new(dns.Msg)
+	if c.refuse {
+		r.SetRcode(q, dns.RcodeRefused)
+		qCtx.SetResponse(r)
+		return nil
+	}
+	r.SetReply(q)
+	r.Authoritative = true
+	if question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeANY {
+		r.Answer = append(r.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+			Txt: []string{v},
+		})
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
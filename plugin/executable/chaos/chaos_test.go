@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package chaos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestChaos_Exec(t *testing.T) {
+	c := NewChaos(&Args{Version: "v1", Hostname: "h1"})
+	exec := func(c *Chaos, name string, class uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeTXT)
+		q.Question[0].Qclass = class
+		qCtx := query_context.NewContext(q)
+		if err := c.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx.R()
+	}
+
+	for name, want := range map[string]string{
+		"version.bind.":   "v1",
+		"VERSION.server.": "v1",
+		"hostname.bind.":  "h1",
+		"id.server.":      "h1",
+	} {
+		r := exec(c, name, dns.ClassCHAOS)
+		if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.TXT).Txt[0] != want {
+			t.Fatalf("%s: want %s, got %v", name, want, r)
+		}
+	}
+	if r := exec(c, "version.bind.", dns.ClassINET); r != nil {
+		t.Fatal("IN class query should be ignored")
+	}
+	if r := exec(c, "example.com.", dns.ClassCHAOS); r != nil {
+		t.Fatal("unknown name should be ignored")
+	}
+
+	c = NewChaos(&Args{Refuse: true})
+	if r := exec(c, "version.bind.", dns.ClassCHAOS); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want refused, got %v", r)
+	}
+}
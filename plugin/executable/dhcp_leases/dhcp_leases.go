@@ -0,0 +1,371 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_leases
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dhcp_lease"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dhcp_leases"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	Files []FileArgs `yaml:"files"`
+	// Domain that hostnames will be served under. Default is "lan".
+	Domain string `yaml:"domain"`
+	// TTL of records. Default is 60.
+	TTL int `yaml:"ttl"`
+	// Interval in seconds to check the files for changes. Default is 5.
+	Interval int `yaml:"interval"`
+
+	// Snoop is the network interface to snoop DHCP packets on, e.g.
+	// "br-lan", or "any" for all interfaces. Hostname bindings in
+	// DHCPREQUEST/DHCPACK packets will be learned. It can be used without
+	// any lease file. Packets are copied by a packet socket, so they are
+	// still received by the DHCP server. Linux only, requires CAP_NET_RAW.
+	// Optional.
+	Snoop string `yaml:"snoop"`
+	// SnoopLeaseTime in seconds is the lease time of a learned binding if
+	// the packet has no lease time option. Default is 3600.
+	SnoopLeaseTime int `yaml:"snoop_lease_time"`
+}
+
+type FileArgs struct {
+	Path string `yaml:"path"`
+	// Format of the lease file. Can be "dnsmasq" or "odhcpd".
+	Format string `yaml:"format"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Domain, "lan")
+	utils.SetDefaultUnsignNum(&a.TTL, 60)
+	utils.SetDefaultUnsignNum(&a.Interval, 5)
+	utils.SetDefaultUnsignNum(&a.SnoopLeaseTime, 3600)
+}
+
+var _ sequence.Executable = (*Leases)(nil)
+var _ data_provider.HostnameProvider = (*Leases)(nil)
+
+// Leases serves A/AAAA and PTR records from dhcp lease files and
+// bindings learned from dhcp packets.
+type Leases struct {
+	args   *Args
+	logger *zap.Logger
+	files  []leaseFile
+	snoopC io.ReadCloser // nil if snooping is disabled
+
+	m           sync.Mutex
+	fileLeases  []dhcp_lease.Lease
+	snooped     map[netip.Addr]dhcp_lease.Lease
+	table       atomic.Pointer[dhcp_lease.Table]
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+type leaseFile struct {
+	path    string
+	parse   dhcp_lease.ParseFunc
+	modTime time.Time
+	size    int64
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewLeases(args.(*Args), bp.L())
+}
+
+// NewLeases loads the lease files and starts watching them.
+func NewLeases(args *Args, logger *zap.Logger) (*Leases, error) {
+	args.init()
+	if len(args.Files) == 0 && len(args.Snoop) == 0 {
+		return nil, errors.New("no lease file or snoop address is configured")
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	l := &Leases{
+		args:        args,
+		logger:      logger,
+		snooped:     make(map[netip.Addr]dhcp_lease.Lease),
+		closeNotify: make(chan struct{}),
+	}
+	for i, f := range args.Files {
+		parse, ok := dhcp_lease.GetParser(f.Format)
+		if !ok {
+			return nil, fmt.Errorf("file #%d has an invalid format [%s]", i, f.Format)
+		}
+		l.files = append(l.files, leaseFile{path: f.Path, parse: parse})
+	}
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+	if len(args.Snoop) > 0 {
+		c, err := listenSnoop(args.Snoop)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open snoop socket, %w", err)
+		}
+		l.snoopC = c
+		logger.Info("dhcp snooping started", zap.String("iface", args.Snoop))
+		go l.snoop()
+	}
+	go l.watch()
+	return l, nil
+}
+
+// reload reloads all files if any of them was changed.
+func (l *Leases) reload() (bool, error) {
+	changed := false
+	for i := range l.files {
+		f := &l.files[i]
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) { // dhcp server may not create the file yet.
+				changed = changed || f.size != -1
+				f.modTime, f.size = time.Time{}, -1
+				continue
+			}
+			return false, err
+		}
+		if !fi.ModTime().Equal(f.modTime) || fi.Size() != f.size {
+			changed = true
+			f.modTime, f.size = fi.ModTime(), fi.Size()
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var leases []dhcp_lease.Lease
+	for _, f := range l.files {
+		if f.size < 0 {
+			continue
+		}
+		b, err := os.ReadFile(f.path)
+		if err != nil {
+			return false, err
+		}
+		ls, err := f.parse(bytes.NewReader(b))
+		if err != nil {
+			return false, fmt.Errorf("failed to parse lease file %s, %w", f.path, err)
+		}
+		leases = append(leases, ls...)
+	}
+
+	l.m.Lock()
+	l.fileLeases = leases
+	l.rebuildLocked()
+	l.m.Unlock()
+	return true, nil
+}
+
+// rebuildLocked rebuilds the table from file leases and snooped leases.
+// Snooped leases have higher priority.
+func (l *Leases) rebuildLocked() {
+	leases := make([]dhcp_lease.Lease, 0, len(l.fileLeases)+len(l.snooped))
+	leases = append(leases, l.fileLeases...)
+	for _, sl := range l.snooped {
+		leases = append(leases, sl)
+	}
+	l.table.Store(dhcp_lease.NewTable(leases, l.args.Domain))
+}
+
+func (l *Leases) snoop() {
+	b := make([]byte, 65535)
+	defaultLeaseTime := time.Duration(l.args.SnoopLeaseTime) * time.Second
+	for {
+		n, err := l.snoopC.Read(b)
+		if err != nil {
+			select {
+			case <-l.closeNotify:
+			default:
+				l.logger.Error("dhcp snooping exited", zap.Error(err))
+			}
+			return
+		}
+		payload, ok := dhcpPayload(b[:n])
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		sl, ok, err := dhcp_lease.ParseDHCPPacket(payload, now, defaultLeaseTime)
+		if err != nil || !ok {
+			continue
+		}
+		l.learn(sl, now)
+	}
+}
+
+// dhcpPayload returns the udp payload of the ipv4 packet b if it was
+// sent to the dhcp server or client port.
+func dhcpPayload(b []byte) ([]byte, bool) {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return nil, false
+	}
+	ihl := int(b[0]&0xf) * 4
+	if ihl < 20 || len(b) < ihl+8 || b[9] != 17 { // udp
+		return nil, false
+	}
+	udp := b[ihl:]
+	if dst := binary.BigEndian.Uint16(udp[2:]); dst != 67 && dst != 68 {
+		return nil, false
+	}
+	l := int(binary.BigEndian.Uint16(udp[4:]))
+	if l < 8 || l > len(udp) {
+		return nil, false
+	}
+	return udp[8:l], true
+}
+
+// learn adds a snooped lease to the table. Expired snooped leases are
+// removed.
+func (l *Leases) learn(sl dhcp_lease.Lease, now time.Time) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if old, dup := l.snooped[sl.Addr]; dup && old.Hostname == sl.Hostname && !old.Expire.Before(sl.Expire) {
+		return
+	}
+	for addr, old := range l.snooped { // clean up expired bindings
+		if now.After(old.Expire) {
+			delete(l.snooped, addr)
+		}
+	}
+	l.snooped[sl.Addr] = sl
+	l.rebuildLocked()
+	l.logger.Debug("dhcp binding learned", zap.String("hostname", sl.Hostname), zap.Stringer("addr", sl.Addr))
+}
+
+func (l *Leases) watch() {
+	ticker := time.NewTicker(time.Duration(l.args.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closeNotify:
+			return
+		case <-ticker.C:
+			changed, err := l.reload()
+			if err != nil {
+				l.logger.Warn("failed to reload lease files", zap.Error(err))
+			} else if changed {
+				l.logger.Info("lease files reloaded")
+			}
+		}
+	}
+}
+
+// Table returns the current lease table.
+func (l *Leases) Table() *dhcp_lease.Table {
+	return l.table.Load()
+}
+
+// LookupHostname implements data_provider.HostnameProvider.
+func (l *Leases) LookupHostname(addr netip.Addr) (string, bool) {
+	return l.table.Load().LookupAddr(addr.Unmap(), time.Now())
+}
+
+func (l *Leases) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if r := l.response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+func (l *Leases) response(q *dns.Msg) *dns.Msg {
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET {
+		return nil
+	}
+	t := l.table.Load()
+	now := time.Now()
+	hdr := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(l.args.TTL),
+	}
+
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+	switch question.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		ipv4, ipv6 := t.LookupName(question.Name, now)
+		if len(ipv4)+len(ipv6) == 0 {
+			return nil
+		}
+		if question.Qtype == dns.TypeA {
+			for _, addr := range ipv4 {
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+			}
+		} else {
+			for _, addr := range ipv6 {
+				r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+			}
+		}
+		if len(r.Answer) == 0 {
+			r.Ns = []dns.RR{dnsutils.FakeSOA(question.Name)}
+		}
+	case dns.TypePTR:
+		addr, err := dnsutils.ParsePTRQName(question.Name)
+		if err != nil {
+			return nil
+		}
+		fqdn, ok := t.LookupAddr(addr, now)
+		if !ok {
+			return nil
+		}
+		r.Answer = append(r.Answer, &dns.PTR{Hdr: hdr, Ptr: fqdn})
+	default:
+		return nil
+	}
+	return r
+}
+
+func (l *Leases) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeNotify)
+		if l.snoopC != nil {
+			_ = l.snoopC.Close()
+		}
+	})
+	return nil
+}
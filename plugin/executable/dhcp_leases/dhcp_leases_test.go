@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package dhcp_leases
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/dhcp_lease"
+	"github.com/miekg/dns"
+)
+
+func TestLeases_response(t *testing.T) {
+	now := time.Now()
+	data := fmt.Sprintf(`%d aa:bb:cc:dd:ee:ff 192.168.1.10 laptop *
+%d 11:22:33:44:55:66 192.168.1.11 old *
+duid 00:01:00:01:2a:2b:2c:2d:aa:bb:cc:dd:ee:ff
+0 1234 fd00::10 laptop 00:01:00:01
+`, now.Add(time.Hour).Unix(), now.Add(-time.Hour).Unix())
+	file := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLeases(&Args{Files: []FileArgs{{Path: file, Format: "dnsmasq"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  string // empty if no response
+	}{
+		{"laptop.lan.", dns.TypeA, "192.168.1.10"},
+		{"LAPTOP.lan.", dns.TypeAAAA, "fd00::10"},
+		{"10.1.168.192.in-addr.arpa.", dns.TypePTR, "laptop.lan."},
+		{"old.lan.", dns.TypeA, ""},
+		{"11.1.168.192.in-addr.arpa.", dns.TypePTR, ""},
+		{"unknown.lan.", dns.TypeA, ""},
+		{"laptop.lan.", dns.TypeTXT, ""},
+	}
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(tt.name, tt.qtype)
+		r := l.response(q)
+		if len(tt.want) == 0 {
+			if r != nil {
+				t.Errorf("%s %d: want no response, got %v", tt.name, tt.qtype, r)
+			}
+			continue
+		}
+		if r == nil || len(r.Answer) != 1 {
+			t.Errorf("%s %d: want one answer, got %v", tt.name, tt.qtype, r)
+			continue
+		}
+		var got string
+		switch rr := r.Answer[0].(type) {
+		case *dns.A:
+			got = rr.A.String()
+		case *dns.AAAA:
+			got = rr.AAAA.String()
+		case *dns.PTR:
+			got = rr.Ptr
+		}
+		if got != tt.want {
+			t.Errorf("%s %d: want %s, got %s", tt.name, tt.qtype, tt.want, got)
+		}
+	}
+}
+
+func TestLeases_learn(t *testing.T) {
+	l, err := NewLeases(&Args{Files: []FileArgs{{Path: filepath.Join(t.TempDir(), "none"), Format: "dnsmasq"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	now := time.Now()
+	addr1, addr2 := netip.MustParseAddr("192.168.1.20"), netip.MustParseAddr("192.168.1.21")
+	l.learn(dhcp_lease.Lease{Hostname: "tv", Addr: addr1, Expire: now.Add(time.Minute)}, now)
+	if name, ok := l.LookupHostname(addr1); !ok || name != "tv.lan." {
+		t.Fatalf("want tv.lan., got %s, %v", name, ok)
+	}
+
+	// The first binding expired, it should be removed when a new one is learned.
+	now = now.Add(time.Hour)
+	l.learn(dhcp_lease.Lease{Hostname: "phone", Addr: addr2, Expire: now.Add(time.Minute)}, now)
+	if _, ok := l.snooped[addr1]; ok {
+		t.Fatal("expired binding was not removed")
+	}
+	if _, ok := l.snooped[addr2]; !ok {
+		t.Fatal("binding was not learned")
+	}
+}
+
+func Test_dhcpPayload(t *testing.T) {
+	packet := func(proto byte, dstPort uint16, payload []byte) []byte {
+		b := make([]byte, 20+8+len(payload))
+		b[0] = 0x45
+		b[9] = proto
+		udp := b[20:]
+		binary.BigEndian.PutUint16(udp[0:], 68)
+		binary.BigEndian.PutUint16(udp[2:], dstPort)
+		binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+		copy(udp[8:], payload)
+		return b
+	}
+	payload := []byte("dhcp")
+
+	tests := []struct {
+		name string
+		b    []byte
+		ok   bool
+	}{
+		{"server port", packet(17, 67, payload), true},
+		{"client port", packet(17, 68, payload), true},
+		{"padded", append(packet(17, 67, payload), 0, 0), true},
+		{"other port", packet(17, 53, payload), false},
+		{"tcp", packet(6, 67, payload), false},
+		{"ipv6", append([]byte{0x60}, packet(17, 67, payload)[1:]...), false},
+		{"truncated", packet(17, 67, payload)[:30], false},
+		{"short", []byte{0x45}, false},
+	}
+	for _, tt := range tests {
+		got, ok := dhcpPayload(tt.b)
+		if ok != tt.ok {
+			t.Errorf("%s: want ok %v, got %v", tt.name, tt.ok, ok)
+			continue
+		}
+		if ok && string(got) != string(payload) {
+			t.Errorf("%s: want payload %q, got %q", tt.name, payload, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dhcp_leases
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// snoopFilter only accepts unfragmented ipv4 udp packets to port 67 or 68.
+// Packets of packet sockets in SOCK_DGRAM mode start at the ip header.
+var snoopFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 9, Size: 1},                                      // 0: ip protocol
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_UDP, SkipTrue: 6}, // 1
+	bpf.LoadAbsolute{Off: 6, Size: 2},                                      // 2: flags and fragment offset
+	bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 4},            // 3
+	bpf.LoadMemShift{Off: 0},                                               // 4: x = ip header length
+	bpf.LoadIndirect{Off: 2, Size: 2},                                      // 5: udp dst port
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 67, SkipTrue: 2},                  // 6
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 68, SkipTrue: 1},                  // 7
+	bpf.RetConstant{Val: 0},                                                // 8: drop
+	bpf.RetConstant{Val: 0xffff},                                           // 9: accept
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// listenSnoop opens a packet socket that receives copies of dhcp packets
+// on iface, or on all interfaces if iface is "any". Unlike a udp socket
+// bound to port 67, it does not take packets from the dhcp server.
+// Reads return ip packets. It requires CAP_NET_RAW.
+func listenSnoop(iface string) (io.ReadCloser, error) {
+	ifIndex := 0
+	if iface != "any" {
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		ifIndex = i.Index
+	}
+
+	raw, err := bpf.Assemble(snoopFilter)
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, 0, len(raw))
+	for _, ins := range raw {
+		filter = append(filter, unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K})
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, int(htons(unix.ETH_P_IP)))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_IP), Ifindex: ifIndex}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// fd is non-blocking, so reads use the runtime poller and Close
+	// unblocks them.
+	return os.NewFile(uintptr(fd), fmt.Sprintf("packet:%s", iface)), nil
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	ipv4OnlyArpa     = "ipv4only.arpa."
+	discoveryTimeout = time.Second * 5
+
+	raHeaderLen   = 16
+	optionPref64  = 38
+	pref64OptSize = 16
+)
+
+// Well-known ipv4 addresses of "ipv4only.arpa". See RFC 7050 2.
+var wellKnownIPv4 = []netip.Addr{
+	netip.AddrFrom4([4]byte{192, 0, 0, 170}),
+	netip.AddrFrom4([4]byte{192, 0, 0, 171}),
+}
+
+// prefixLens are the prefix lengths that RFC 7050 3 searches in order.
+var prefixLens = []int{32, 40, 48, 56, 64, 96}
+
+// pref64Lens are the prefix lengths of PLC values. See RFC 8781 4.
+var pref64Lens = []int{96, 64, 56, 48, 40, 32}
+
+func (p *DNS64) discoverLoop() {
+	ticker := time.NewTicker(time.Duration(p.args.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.discover()
+		case <-p.closeNotify:
+			return
+		}
+	}
+}
+
+// discover resolves "ipv4only.arpa" to learn the prefix. See RFC 7050.
+func (p *DNS64) discover() {
+	pfx, err := p.resolveIPv4Only()
+	if err != nil {
+		if p.dnsPrefix.Swap(nil) != nil {
+			p.logger.Warn("nat64 prefix is lost", zap.Error(err))
+		}
+		return
+	}
+	if old := p.dnsPrefix.Swap(&pfx); old == nil || *old != pfx {
+		p.logger.Info("nat64 prefix discovered by ipv4only.arpa", zap.Stringer("prefix", pfx))
+	}
+}
+
+func (p *DNS64) resolveIPv4Only() (netip.Prefix, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(ipv4OnlyArpa, dns.TypeAAAA)
+	b, err := pool.PackBuffer(q)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	defer pool.ReleaseBuf(b)
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	rb, err := p.u.ExchangeContext(ctx, *b)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	defer pool.ReleaseBuf(rb)
+	r := new(dns.Msg)
+	if err := r.Unpack(*rb); err != nil {
+		return netip.Prefix{}, err
+	}
+	for _, rr := range r.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(aaaa.AAAA)
+		if !ok {
+			continue
+		}
+		if pfx, ok := prefixOf(addr); ok {
+			return pfx, nil
+		}
+	}
+	return netip.Prefix{}, errors.New("no nat64 prefix in the response of ipv4only.arpa")
+}
+
+// prefixOf returns the prefix of addr if a well-known ipv4 is embedded
+// in it.
+func prefixOf(addr netip.Addr) (netip.Prefix, bool) {
+	for _, bits := range prefixLens {
+		v4 := extract(addr, bits)
+		for _, wka := range wellKnownIPv4 {
+			if v4 == wka {
+				return netip.PrefixFrom(addr, bits).Masked(), true
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// listenRA listens router advertisements for the PREF64 option and
+// solicits routers to send them now.
+func (p *DNS64) listenRA() error {
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return err
+	}
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	if err := c.IPv6PacketConn().SetICMPFilter(&f); err != nil {
+		p.logger.Warn("failed to set icmp filter", zap.Error(err))
+	}
+	p.raConn = c
+	go p.readRA(c)
+	p.solicit(c)
+	return nil
+}
+
+func (p *DNS64) readRA(c *icmp.PacketConn) {
+	b := make([]byte, 1500)
+	for {
+		n, src, err := c.ReadFrom(b)
+		if err != nil {
+			return // closed
+		}
+		// RFC 4861 6.1.2: Routers advertise from link-local addresses.
+		if ip, ok := src.(*net.IPAddr); !ok || !ip.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		pfx, lifetime, ok := parsePref64(b[:n])
+		if !ok {
+			continue
+		}
+		if lifetime == 0 {
+			if p.raPrefix.Swap(nil) != nil {
+				p.logger.Info("nat64 prefix is withdrawn by router", zap.Stringer("prefix", pfx))
+			}
+			continue
+		}
+		old := p.raPrefix.Swap(&pref64{prefix: pfx, expire: time.Now().Add(lifetime)})
+		if old == nil || old.prefix != pfx {
+			p.logger.Info("nat64 prefix discovered by router advertisement", zap.Stringer("prefix", pfx), zap.Duration("lifetime", lifetime))
+		}
+	}
+}
+
+// solicit sends router solicitations on all multicast interfaces.
+func (p *DNS64) solicit(c *icmp.PacketConn) {
+	m := icmp.Message{Type: ipv6.ICMPTypeRouterSolicitation, Body: &icmp.RawBody{Data: make([]byte, 4)}}
+	b, err := m.Marshal(nil)
+	if err != nil {
+		return
+	}
+	_ = c.IPv6PacketConn().SetMulticastHopLimit(255)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		p.logger.Warn("failed to list interfaces", zap.Error(err))
+		return
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		dst := &net.IPAddr{IP: net.ParseIP("ff02::2"), Zone: iface.Name}
+		if _, err := c.WriteTo(b, dst); err != nil {
+			p.logger.Debug("failed to send router solicitation", zap.String("interface", iface.Name), zap.Error(err))
+		}
+	}
+}
+
+// parsePref64 parses the PREF64 option (RFC 8781) of a router
+// advertisement b, which starts with the icmp header.
+func parsePref64(b []byte) (netip.Prefix, time.Duration, bool) {
+	if len(b) < raHeaderLen || b[0] != byte(ipv6.ICMPTypeRouterAdvertisement) {
+		return netip.Prefix{}, 0, false
+	}
+	opts := b[raHeaderLen:]
+	for len(opts) >= 2 {
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			break
+		}
+		if opts[0] == optionPref64 && l == pref64OptSize {
+			v := binary.BigEndian.Uint16(opts[2:4])
+			plc := int(v & 0x7)
+			if plc < len(pref64Lens) {
+				var a [16]byte
+				copy(a[:12], opts[4:16])
+				pfx := netip.PrefixFrom(netip.AddrFrom16(a), pref64Lens[plc]).Masked()
+				return pfx, time.Duration(v>>3) * 8 * time.Second, true
+			}
+		}
+		opts = opts[l:]
+	}
+	return netip.Prefix{}, 0, false
+}
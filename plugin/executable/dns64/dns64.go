@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+)
+
+const PluginType = "dns64"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Prefix is the NAT64 prefix, e.g. "64:ff9b::/96". Its length must be
+	// 32, 40, 48, 56, 64 or 96. If it is empty, the prefix is discovered
+	// from router advertisements (RFC 8781) and by resolving
+	// "ipv4only.arpa" (RFC 7050).
+	Prefix string `yaml:"prefix"`
+	// Resolver resolves "ipv4only.arpa". It should be the dns server of
+	// the network, which knows the NAT64 prefix. Default is "system://".
+	Resolver string `yaml:"resolver"`
+	// RA listens router advertisements for the PREF64 option. It needs
+	// the CAP_NET_RAW capability.
+	RA bool `yaml:"ra"`
+	// Interval in seconds to resolve "ipv4only.arpa" again. Default is 600.
+	Interval int `yaml:"interval"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Resolver, "system://")
+	utils.SetDefaultUnsignNum(&a.Interval, 600)
+}
+
+var _ sequence.RecursiveExecutable = (*DNS64)(nil)
+
+// DNS64 synthesizes AAAA records from A records (RFC 6147) for names
+// that have no AAAA record, so ipv6-only clients can reach them through
+// NAT64. It does nothing until the NAT64 prefix is known.
+type DNS64 struct {
+	args   *Args
+	logger *zap.Logger
+
+	static    netip.Prefix // valid if it is configured
+	u         upstream.Upstream
+	dnsPrefix atomic.Pointer[netip.Prefix]
+	raPrefix  atomic.Pointer[pref64]
+	raConn    *icmp.PacketConn
+
+	closeNotify chan struct{}
+}
+
+type pref64 struct {
+	prefix netip.Prefix
+	expire time.Time
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewDNS64(args.(*Args), bp.L())
+}
+
+func NewDNS64(args *Args, logger *zap.Logger) (*DNS64, error) {
+	args.init()
+	p := &DNS64{
+		args:        args,
+		logger:      logger,
+		closeNotify: make(chan struct{}),
+	}
+	if len(args.Prefix) > 0 {
+		pfx, err := netip.ParsePrefix(args.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix, %w", err)
+		}
+		if !pfx.Addr().Is6() || pfx.Addr().Is4In6() || !validPrefixLen(pfx.Bits()) {
+			return nil, fmt.Errorf("invalid nat64 prefix %s", pfx)
+		}
+		p.static = pfx.Masked()
+		return p, nil
+	}
+
+	u, err := upstream.NewUpstream(args.Resolver, upstream.Opt{Logger: logger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init resolver, %w", err)
+	}
+	p.u = u
+	if args.RA {
+		if err := p.listenRA(); err != nil {
+			u.Close()
+			return nil, fmt.Errorf("failed to listen router advertisements, %w", err)
+		}
+	}
+	p.discover()
+	go p.discoverLoop()
+	return p, nil
+}
+
+// Prefix returns the NAT64 prefix in use. A configured prefix is
+// preferred over the one from router advertisements, which is preferred
+// over the one from "ipv4only.arpa".
+func (p *DNS64) Prefix() (netip.Prefix, bool) {
+	if p.static.IsValid() {
+		return p.static, true
+	}
+	if ra := p.raPrefix.Load(); ra != nil && time.Now().Before(ra.expire) {
+		return ra.prefix, true
+	}
+	if pfx := p.dnsPrefix.Load(); pfx != nil {
+		return *pfx, true
+	}
+	return netip.Prefix{}, false
+}
+
+func (p *DNS64) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	question := qCtx.QQuestion()
+	pfx, ok := p.Prefix()
+	if !ok || question.Qtype != dns.TypeAAAA || question.Qclass != dns.ClassINET {
+		return next.ExecNext(ctx, qCtx)
+	}
+
+	qCtxA := qCtx.Copy()
+	if err := next.ExecNext(ctx, qCtx); err != nil {
+		return err
+	}
+	r := qCtx.R()
+	// RFC 6147 5.1.2: Only NOERROR responses without AAAA are synthesized.
+	if r == nil || r.Rcode != dns.RcodeSuccess || hasRR(r.Answer, dns.TypeAAAA) {
+		return nil
+	}
+
+	qCtxA.Q().Question[0].Qtype = dns.TypeA
+	if err := next.ExecNext(ctx, qCtxA); err != nil {
+		p.logger.Warn("failed to resolve A for synthesis", qCtx.InfoField(), zap.Error(err))
+		return nil
+	}
+	ra := qCtxA.R()
+	if ra == nil || ra.Rcode != dns.RcodeSuccess || !hasRR(ra.Answer, dns.TypeA) {
+		return nil
+	}
+
+	// RFC 6147 5.1.7: The ttl is the minimum of the A ttl and the
+	// negative ttl of the AAAA response.
+	maxTTL := ^uint32(0)
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			maxTTL = min(soa.Hdr.Ttl, soa.Minttl)
+		}
+	}
+	answer := make([]dns.RR, 0, len(ra.Answer))
+	for _, rr := range ra.Answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			answer = append(answer, dns.Copy(rr))
+		case *dns.A:
+			v4, ok := netip.AddrFromSlice(rr.A.To4())
+			if !ok {
+				continue
+			}
+			answer = append(answer, &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   rr.Hdr.Name,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    min(rr.Hdr.Ttl, maxTTL),
+				},
+				AAAA: synthesize(pfx, v4).AsSlice(),
+			})
+		}
+	}
+	r.Answer = answer
+	r.Ns = nil
+	return nil
+}
+
+func hasRR(rrs []dns.RR, t uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DNS64) Close() error {
+	if p.u == nil {
+		return nil
+	}
+	close(p.closeNotify)
+	if p.raConn != nil {
+		p.raConn.Close()
+	}
+	return p.u.Close()
+}
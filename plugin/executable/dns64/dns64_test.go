@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func Test_synthesize(t *testing.T) {
+	// Examples from RFC 6052 2.4.
+	v4 := netip.MustParseAddr("192.0.2.33")
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::c000:221"},
+	}
+	for _, tt := range tests {
+		pfx := netip.MustParsePrefix(tt.prefix)
+		got := synthesize(pfx, v4)
+		if got != netip.MustParseAddr(tt.want) {
+			t.Errorf("%s: want %s, got %s", tt.prefix, tt.want, got)
+		}
+		if e := extract(got, pfx.Bits()); e != v4 {
+			t.Errorf("%s: extracted %s", tt.prefix, e)
+		}
+	}
+
+	pfx, ok := prefixOf(netip.MustParseAddr("2001:db8:122:344:c0:0:aa00:0"))
+	if !ok || pfx != netip.MustParsePrefix("2001:db8:122:344::/64") {
+		t.Fatalf("unexpected prefix %s", pfx)
+	}
+}
+
+func Test_parsePref64(t *testing.T) {
+	ra := make([]byte, raHeaderLen)
+	ra[0] = 134
+	// A source link-layer address option, then PREF64.
+	ra = append(ra, 1, 1, 0, 1, 2, 3, 4, 5)
+	opt := []byte{optionPref64, 2, 0, 0}
+	binary.BigEndian.PutUint16(opt[2:], 600/8<<3|1) // lifetime 600s, /64
+	opt = append(opt, 0x20, 0x01, 0x0d, 0xb8, 0, 0x64, 0, 0, 0, 0, 0, 0)
+	pfx, lifetime, ok := parsePref64(append(ra, opt...))
+	if !ok || pfx != netip.MustParsePrefix("2001:db8:64::/64") || lifetime != time.Second*600 {
+		t.Fatalf("unexpected pref64 %s %s %v", pfx, lifetime, ok)
+	}
+	if _, _, ok := parsePref64(ra); ok {
+		t.Fatal("ra without pref64 should not be parsed")
+	}
+}
+
+// fakeResolver answers ipv4only.arpa with pfx.
+func fakeResolver(t *testing.T, pfx netip.Prefix) string {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			q := new(dns.Msg)
+			if q.Unpack(b[:n]) != nil {
+				continue
+			}
+			r := new(dns.Msg)
+			r.SetReply(q)
+			if q.Question[0].Name == ipv4OnlyArpa {
+				r.Answer = append(r.Answer, &dns.AAAA{
+					Hdr:  dns.RR_Header{Name: ipv4OnlyArpa, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+					AAAA: synthesize(pfx, wellKnownIPv4[0]).AsSlice(),
+				})
+			}
+			rb, _ := r.Pack()
+			c.WriteTo(rb, addr)
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func TestDNS64(t *testing.T) {
+	pfx := netip.MustParsePrefix("64:ff9b::/96")
+	p, err := NewDNS64(&Args{Resolver: fakeResolver(t, pfx)}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if got, ok := p.Prefix(); !ok || got != pfx {
+		t.Fatalf("prefix is not discovered, got %s", got)
+	}
+
+	var next sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
+		q := qCtx.Q()
+		r := new(dns.Msg)
+		r.SetReply(q)
+		name := q.Question[0].Name
+		switch {
+		case name == "v6.com." && q.Question[0].Qtype == dns.TypeAAAA:
+			r.Answer = append(r.Answer, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 300}, AAAA: net.ParseIP("2001:db8::1")})
+		case q.Question[0].Qtype == dns.TypeA:
+			r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.IPv4(192, 0, 2, 1)})
+		default:
+			r.Ns = append(r.Ns, &dns.SOA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 100}, Minttl: 60})
+		}
+		qCtx.SetResponse(r)
+		return nil
+	}
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	exec := func(name string) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeAAAA)
+		qCtx := query_context.NewContext(q)
+		if err := p.Exec(context.Background(), qCtx, cw); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx.R()
+	}
+
+	r := exec("v4.com.")
+	if len(r.Answer) != 1 || len(r.Ns) != 0 {
+		t.Fatalf("unexpected response %v", r)
+	}
+	aaaa := r.Answer[0].(*dns.AAAA)
+	if aaaa.AAAA.String() != "64:ff9b::c000:201" || aaaa.Hdr.Ttl != 60 {
+		t.Fatalf("unexpected synthesized record %v", aaaa)
+	}
+	r = exec("v6.com.")
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.AAAA).AAAA.String() != "2001:db8::1" {
+		t.Fatalf("real AAAA should not be synthesized, got %v", r)
+	}
+}
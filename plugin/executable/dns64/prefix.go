@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dns64
+
+import (
+	"net/netip"
+)
+
+func validPrefixLen(bits int) bool {
+	switch bits {
+	case 32, 40, 48, 56, 64, 96:
+		return true
+	}
+	return false
+}
+
+// synthesize embeds v4 into prefix pfx. See RFC 6052 2.2. Bits 64 to 71
+// (the "u" octet) are always zero.
+func synthesize(pfx netip.Prefix, v4 netip.Addr) netip.Addr {
+	b := pfx.Masked().Addr().As16()
+	a := v4.As4()
+	switch pfx.Bits() {
+	case 32:
+		copy(b[4:8], a[:])
+	case 40:
+		copy(b[5:8], a[:3])
+		b[9] = a[3]
+	case 48:
+		copy(b[6:8], a[:2])
+		copy(b[9:11], a[2:])
+	case 56:
+		b[7] = a[0]
+		copy(b[9:12], a[1:])
+	case 64:
+		copy(b[9:13], a[:])
+	case 96:
+		copy(b[12:16], a[:])
+	}
+	return netip.AddrFrom16(b)
+}
+
+// extract extracts the ipv4 embedded in addr with prefix length bits.
+// See synthesize.
+func extract(addr netip.Addr, bits int) netip.Addr {
+	b := addr.As16()
+	var a [4]byte
+	switch bits {
+	case 32:
+		copy(a[:], b[4:8])
+	case 40:
+		copy(a[:3], b[5:8])
+		a[3] = b[9]
+	case 48:
+		copy(a[:2], b[6:8])
+		copy(a[2:], b[9:11])
+	case 56:
+		a[0] = b[7]
+		copy(a[1:], b[9:12])
+	case 64:
+		copy(a[:], b[9:13])
+	case 96:
+		copy(a[:], b[12:16])
+	}
+	return netip.AddrFrom4(a)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain_ips
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+func (p *DomainIPs) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/mappings", p.serveMappings)
+	r.Get("/stream", p.serveStream)
+	return r
+}
+
+// parseFilter parses the optional url query parameters "domain" and "ip".
+func parseFilter(req *http.Request) (string, netip.Addr, error) {
+	domain := req.URL.Query().Get("domain")
+	if len(domain) > 0 {
+		domain = dns.Fqdn(strings.ToLower(domain))
+	}
+	var ip netip.Addr
+	if s := req.URL.Query().Get("ip"); len(s) > 0 {
+		var err error
+		if ip, err = netip.ParseAddr(s); err != nil {
+			return "", netip.Addr{}, err
+		}
+	}
+	return domain, ip, nil
+}
+
+// serveMappings writes the table as a json array of Mapping, sorted by
+// domain. Optional url query parameters "domain" and "ip" filter the
+// mappings by domain suffix and ip.
+func (p *DomainIPs) serveMappings(w http.ResponseWriter, req *http.Request) {
+	domain, ip, err := parseFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ms := p.Lookup(domain, ip, time.Now())
+	slices.SortFunc(ms, func(a, b Mapping) int { return strings.Compare(a.Domain, b.Domain) })
+	for _, m := range ms {
+		slices.SortFunc(m.IPs, func(a, b IPTTL) int { return a.IP.Compare(b.IP) })
+	}
+	if ms == nil {
+		ms = []Mapping{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ms)
+}
+
+// serveStream streams Event as server-sent events. Optional url query
+// parameters "domain" and "ip" filter events by domain suffix and ip.
+// Subscribers should read "/mappings" after the stream is established
+// to get the initial table.
+func (p *DomainIPs) serveStream(w http.ResponseWriter, req *http.Request) {
+	domain, ip, err := parseFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.hub.Serve(w, req, func(e *Event) bool {
+		if len(domain) > 0 && !dns.IsSubDomain(domain, e.Domain) {
+			return false
+		}
+		return !ip.IsValid() || e.IP == ip
+	})
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain_ips
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/sse"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "domain_ips"
+
+const cleanInterval = time.Second * 30
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// MinTTL and MaxTTL (in seconds) clamp the lifetime of mappings that
+	// is derived from the records' TTL. Default MinTTL is 60. Default
+	// MaxTTL (0) is no limit.
+	MinTTL int `yaml:"min_ttl"`
+	MaxTTL int `yaml:"max_ttl"`
+	// MaxEntries limits the number of domain and ip pairs in the table.
+	// New pairs are ignored if the table is full. Default is 65536.
+	MaxEntries int `yaml:"max_entries"`
+
+	// Sync are tags of executables, e.g. ipset or nftset, that will be
+	// executed with the query once its response added or renewed mappings.
+	// Errors of them are logged and do not fail the query.
+	Sync []string `yaml:"sync"`
+
+	// Buffer is the number of events buffered for each subscriber of the
+	// api "/stream". Default is 256.
+	Buffer int `yaml:"buffer"`
+	// MaxSubscribers limits the number of concurrent subscribers.
+	// Default is 16.
+	MaxSubscribers int `yaml:"max_subscribers"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.MinTTL, 60)
+	utils.SetDefaultUnsignNum(&a.MaxEntries, 65536)
+	utils.SetDefaultUnsignNum(&a.Buffer, 256)
+	utils.SetDefaultUnsignNum(&a.MaxSubscribers, 16)
+}
+
+// Event types of Event.
+const (
+	EventAdd    = "add"
+	EventRenew  = "renew"
+	EventExpire = "expire"
+)
+
+// Event is a change of the table that will be sent to subscribers.
+type Event struct {
+	Type   string     `json:"type"`
+	Domain string     `json:"domain"`
+	IP     netip.Addr `json:"ip"`
+	Expire time.Time  `json:"expire"`
+}
+
+var _ sequence.Executable = (*DomainIPs)(nil)
+var _ coremain.StateInspector = (*DomainIPs)(nil)
+
+// DomainIPs keeps a live table of the domains of queries that passed
+// through it and the ips in their responses. Mappings expire with the
+// TTL of the records. The table can be read from its api "/mappings" and
+// its changes are streamed by "/stream", so external firewalls or
+// controllers can steer traffic by domains resolved by mosdns.
+// It should be placed after the response is made, e.g. after forward,
+// and typically behind a matcher that selects the domains of interest.
+type DomainIPs struct {
+	args   *Args
+	sync   []sequence.Executable
+	logger *zap.Logger
+
+	m     sync.Mutex
+	table map[string]map[netip.Addr]time.Time // fqdn in lower case -> ip -> expire
+	n     int                                 // number of pairs in table
+
+	hub *sse.Hub[*Event]
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	var syncs []sequence.Executable
+	for _, tag := range a.Sync {
+		e := sequence.ToExecutable(bp.M().GetPlugin(tag))
+		if e == nil {
+			return nil, fmt.Errorf("cannot find executable %s", tag)
+		}
+		syncs = append(syncs, e)
+	}
+	p := NewDomainIPs(a, syncs, bp.L())
+	bp.RegAPI(p.Api())
+	return p, nil
+}
+
+func NewDomainIPs(args *Args, syncs []sequence.Executable, logger *zap.Logger) *DomainIPs {
+	args.init()
+	p := &DomainIPs{
+		args:        args,
+		sync:        syncs,
+		logger:      logger,
+		table:       make(map[string]map[netip.Addr]time.Time),
+		hub:         sse.NewHub[*Event](args.Buffer, args.MaxSubscribers),
+		closeNotify: make(chan struct{}),
+	}
+	go p.cleanLoop()
+	return p
+}
+
+func (p *DomainIPs) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	r := qCtx.R()
+	if r == nil || r.Rcode != dns.RcodeSuccess {
+		return nil
+	}
+	domain := strings.ToLower(qCtx.QQuestion().Name)
+	events := p.record(domain, r.Answer, time.Now())
+	if len(events) == 0 {
+		return nil
+	}
+	if p.hub.Len() > 0 {
+		for _, e := range events {
+			p.hub.Publish(e)
+		}
+	}
+	for i, e := range p.sync {
+		if err := e.Exec(ctx, qCtx); err != nil {
+			p.logger.Warn(
+				"failed to sync mappings",
+				zap.String("sync", p.args.Sync[i]),
+				qCtx.InfoField(),
+				zap.Error(err),
+			)
+		}
+	}
+	return nil
+}
+
+// record adds ips of A and AAAA records in rrs to the table and returns
+// the changes. An existing mapping is only reported as renewed if less
+// than half of its new lifetime was remaining, so subscribers are not
+// flooded by queries of popular domains.
+func (p *DomainIPs) record(domain string, rrs []dns.RR, now time.Time) []*Event {
+	var events []*Event
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, rr := range rrs {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		}
+		if !addr.IsValid() {
+			continue
+		}
+		expire := now.Add(p.lifetime(rr.Header().Ttl))
+		ips := p.table[domain]
+		old, ok := ips[addr]
+		switch {
+		case !ok:
+			if p.n >= p.args.MaxEntries {
+				continue
+			}
+			if ips == nil {
+				ips = make(map[netip.Addr]time.Time)
+				p.table[domain] = ips
+			}
+			ips[addr] = expire
+			p.n++
+			events = append(events, &Event{Type: EventAdd, Domain: domain, IP: addr, Expire: expire})
+		case expire.After(old):
+			ips[addr] = expire
+			if old.Sub(now) < expire.Sub(now)/2 {
+				events = append(events, &Event{Type: EventRenew, Domain: domain, IP: addr, Expire: expire})
+			}
+		}
+	}
+	return events
+}
+
+func (p *DomainIPs) lifetime(ttl uint32) time.Duration {
+	t := max(int(ttl), p.args.MinTTL)
+	if p.args.MaxTTL > 0 {
+		t = min(t, p.args.MaxTTL)
+	}
+	return time.Duration(t) * time.Second
+}
+
+// clean removes expired mappings and returns them.
+func (p *DomainIPs) clean(now time.Time) []*Event {
+	var events []*Event
+	p.m.Lock()
+	defer p.m.Unlock()
+	for domain, ips := range p.table {
+		for addr, expire := range ips {
+			if now.Before(expire) {
+				continue
+			}
+			delete(ips, addr)
+			p.n--
+			events = append(events, &Event{Type: EventExpire, Domain: domain, IP: addr, Expire: expire})
+		}
+		if len(ips) == 0 {
+			delete(p.table, domain)
+		}
+	}
+	return events
+}
+
+func (p *DomainIPs) cleanLoop() {
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			events := p.clean(now)
+			if p.hub.Len() > 0 {
+				for _, e := range events {
+					p.hub.Publish(e)
+				}
+			}
+		case <-p.closeNotify:
+			return
+		}
+	}
+}
+
+// Mapping is a domain and its ips in the table.
+type Mapping struct {
+	Domain string  `json:"domain"`
+	IPs    []IPTTL `json:"ips"`
+}
+
+type IPTTL struct {
+	IP     netip.Addr `json:"ip"`
+	Expire time.Time  `json:"expire"`
+}
+
+// Lookup returns unexpired mappings in the table. If domain is not empty,
+// only the domain and its subdomains are returned. If ip is valid, only
+// mappings that include it are returned.
+func (p *DomainIPs) Lookup(domain string, ip netip.Addr, now time.Time) []Mapping {
+	if len(domain) > 0 {
+		domain = dns.Fqdn(strings.ToLower(domain))
+	}
+	var ms []Mapping
+	p.m.Lock()
+	defer p.m.Unlock()
+	for d, ips := range p.table {
+		if len(domain) > 0 && !dns.IsSubDomain(domain, d) {
+			continue
+		}
+		if ip.IsValid() {
+			if expire, ok := ips[ip]; !ok || !now.Before(expire) {
+				continue
+			}
+		}
+		m := Mapping{Domain: d}
+		for addr, expire := range ips {
+			if now.Before(expire) {
+				m.IPs = append(m.IPs, IPTTL{IP: addr, Expire: expire})
+			}
+		}
+		if len(m.IPs) > 0 {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+type State struct {
+	Domains     int `json:"domains"`
+	Entries     int `json:"entries"`
+	Subscribers int `json:"subscribers"`
+}
+
+// State implements coremain.StateInspector.
+func (p *DomainIPs) State() any {
+	p.m.Lock()
+	s := State{Domains: len(p.table), Entries: p.n}
+	p.m.Unlock()
+	s.Subscribers = p.hub.Len()
+	return s
+}
+
+func (p *DomainIPs) Close() error {
+	p.closeOnce.Do(func() { close(p.closeNotify) })
+	return nil
+}
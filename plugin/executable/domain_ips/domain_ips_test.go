@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package domain_ips
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestDomainIPs(t *testing.T) {
+	synced := 0
+	var sync sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
+		synced++
+		return nil
+	}
+	p := NewDomainIPs(&Args{MinTTL: 10, MaxEntries: 3}, []sequence.Executable{sync}, zap.NewNop())
+	defer p.Close()
+
+	exec := func(name string, ttl uint32, ips ...string) {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		r := new(dns.Msg)
+		r.SetReply(q)
+		for _, s := range ips {
+			hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: ttl}
+			if ip := net.ParseIP(s); ip.To4() != nil {
+				hdr.Rrtype = dns.TypeA
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: ip})
+			} else {
+				hdr.Rrtype = dns.TypeAAAA
+				r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
+		}
+		qCtx := query_context.NewContext(q)
+		qCtx.SetResponse(r)
+		if err := p.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec("WWW.Example.com.", 300, "1.1.1.1", "2001:db8::1")
+	exec("www.example.com.", 300, "1.1.1.1")      // not renewed
+	exec("example.org.", 1, "2.2.2.2", "3.3.3.3") // 3.3.3.3 exceeds max_entries
+	if synced != 2 {
+		t.Fatalf("want 2 syncs, got %d", synced)
+	}
+	if s := p.State().(State); s.Domains != 2 || s.Entries != 3 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+
+	now := time.Now()
+	if ms := p.Lookup("example.com", netip.Addr{}, now); len(ms) != 1 || ms[0].Domain != "www.example.com." || len(ms[0].IPs) != 2 {
+		t.Fatalf("unexpected mappings %+v", ms)
+	}
+	if ms := p.Lookup("", netip.MustParseAddr("2.2.2.2"), now); len(ms) != 1 || ms[0].Domain != "example.org." {
+		t.Fatalf("unexpected mappings %+v", ms)
+	}
+
+	// example.org. expires after min_ttl.
+	events := p.clean(now.Add(time.Second * 11))
+	if len(events) != 1 || events[0].Type != EventExpire || events[0].IP != netip.MustParseAddr("2.2.2.2") {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if events := p.record("www.example.com.", []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 300}, A: net.IPv4(1, 1, 1, 1)}}, now.Add(time.Second*200)); len(events) != 1 || events[0].Type != EventRenew {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	w := httptest.NewRecorder()
+	p.Api().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mappings?ip=2001:db8::1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	var ms []Mapping
+	if err := json.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || len(ms[0].IPs) != 2 || ms[0].IPs[0].IP != netip.MustParseAddr("1.1.1.1") {
+		t.Fatalf("unexpected mappings %+v", ms)
+	}
+}
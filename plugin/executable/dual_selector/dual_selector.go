@@ -111,7 +111,7 @@ func (s *Selector) Exec(ctx context.Context, qCtx *query_context.Context, next s
 	shouldPass := make(chan struct{})
 	go func() {
 		qCtx := qCtxPreferred
-		ctx, cancel := context.WithDeadline(context.Background(), ddl)
+		ctx, cancel := context.WithDeadline(ctx, ddl)
 		defer cancel()
 		err := next.ExecNext(ctx, qCtx)
 		if err != nil {
@@ -133,7 +133,7 @@ func (s *Selector) Exec(ctx context.Context, qCtx *query_context.Context, next s
 	qCtxOrg := qCtx.Copy()
 	go func() {
 		qCtx := qCtxOrg
-		ctx, cancel := context.WithDeadline(context.Background(), ddl)
+		ctx, cancel := context.WithDeadline(ctx, ddl)
 		defer cancel()
 		doneChan <- next.ExecNext(ctx, qCtx)
 	}()
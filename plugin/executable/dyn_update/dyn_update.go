@@ -0,0 +1,332 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dyn_update
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dyn_update"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Zones that accept updates, e.g. "home.arpa" or
+	// "1.168.192.in-addr.arpa".
+	Zones []string `yaml:"zones"`
+	// Keys are TSIG keys. Updates must be signed by one of them.
+	Keys []KeyArgs `yaml:"keys"`
+	// File persists the records, so they are kept across restarts.
+	// Optional.
+	File string `yaml:"file"`
+
+	// Secondaries are addresses or prefixes of secondary servers that can
+	// transfer the zones by AXFR over tcp. Only the records of Zones from
+	// dynamic updates are transferred. Records from other plugins, e.g.
+	// hosts or arbitrary, are not. A zone must fit in one 64KiB message,
+	// larger zones are answered with SERVFAIL. Optional.
+	Secondaries []string `yaml:"secondaries"`
+	// Notify are addresses ("ip" or "ip:port") of secondary servers that
+	// are sent a NOTIFY (RFC 1996) once a zone is updated. Optional.
+	Notify []string `yaml:"notify"`
+}
+
+type KeyArgs struct {
+	Name string `yaml:"name"`
+	// Algorithm can be "hmac-sha1", "hmac-sha224", "hmac-sha256",
+	// "hmac-sha384" or "hmac-sha512". Default is "hmac-sha256".
+	Algorithm string `yaml:"algorithm"`
+	// Secret is the base64 encoded key.
+	Secret string `yaml:"secret"`
+}
+
+var tsigAlgorithms = map[string]struct{}{
+	dns.HmacSHA1:   {},
+	dns.HmacSHA224: {},
+	dns.HmacSHA256: {},
+	dns.HmacSHA384: {},
+	dns.HmacSHA512: {},
+}
+
+type tsigKey struct {
+	algorithm string // fqdn
+	secret    string // base64
+}
+
+var _ sequence.Executable = (*DynUpdate)(nil)
+var _ server_utils.Updater = (*DynUpdate)(nil)
+
+// DynUpdate accepts dns UPDATE messages (RFC 2136) that are signed by
+// TSIG (RFC 8945) for its zones, and answers queries from the updated
+// records. Names that have no record are passed through, so other
+// plugins can still answer them. Servers need its tag as their "update"
+// arg to accept UPDATE messages.
+// Zones can be transferred to secondaries by AXFR. See axfr.
+type DynUpdate struct {
+	zones       []string // fqdn, lower case
+	keys        map[string]tsigKey
+	file        string
+	secondaries []netip.Prefix
+	notify      []string // ip:port
+	logger      *zap.Logger
+
+	m       sync.RWMutex
+	records map[string][]dns.RR // by lower case fqdn. Copy on write.
+	serials map[string]uint32   // by zone
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	notifyWg sync.WaitGroup
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewDynUpdate(args.(*Args), bp.L())
+}
+
+func NewDynUpdate(args *Args, logger *zap.Logger) (*DynUpdate, error) {
+	p := &DynUpdate{
+		keys:    make(map[string]tsigKey),
+		file:    args.File,
+		logger:  logger,
+		records: make(map[string][]dns.RR),
+		serials: make(map[string]uint32),
+	}
+	if len(args.Zones) == 0 {
+		return nil, errors.New("no zone is configured")
+	}
+	for _, z := range args.Zones {
+		z = strings.ToLower(dns.Fqdn(z))
+		if _, ok := dns.IsDomainName(z); !ok {
+			return nil, fmt.Errorf("invalid zone %s", z)
+		}
+		p.zones = append(p.zones, z)
+		// Serials are not persisted. Restarts are slower than updates, so
+		// the unix time still increases the serial.
+		p.serials[z] = uint32(time.Now().Unix())
+	}
+	if len(args.Keys) == 0 {
+		return nil, errors.New("no tsig key is configured")
+	}
+	for i, k := range args.Keys {
+		alg := strings.ToLower(dns.Fqdn(k.Algorithm))
+		if len(k.Algorithm) == 0 {
+			alg = dns.HmacSHA256
+		}
+		if _, ok := tsigAlgorithms[alg]; !ok {
+			return nil, fmt.Errorf("key #%d has an unsupported algorithm %s", i, k.Algorithm)
+		}
+		if _, err := base64.StdEncoding.DecodeString(k.Secret); err != nil || len(k.Secret) == 0 {
+			return nil, fmt.Errorf("key #%d has an invalid secret", i)
+		}
+		p.keys[strings.ToLower(dns.Fqdn(k.Name))] = tsigKey{algorithm: alg, secret: k.Secret}
+	}
+	for _, s := range args.Secondaries {
+		pfx, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secondary %s, %w", s, err)
+		}
+		p.secondaries = append(p.secondaries, pfx)
+	}
+	for _, s := range args.Notify {
+		addr, err := parseNotifyAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notify address %s, %w", s, err)
+		}
+		p.notify = append(p.notify, addr)
+	}
+	if len(p.file) > 0 {
+		if err := p.load(); err != nil {
+			return nil, fmt.Errorf("failed to load records from file, %w", err)
+		}
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p, nil
+}
+
+// Close stops sending NOTIFY.
+func (p *DynUpdate) Close() error {
+	p.cancel()
+	p.notifyWg.Wait()
+	return nil
+}
+
+// load loads records from p.file. A missing file is not an error.
+func (p *DynUpdate) load() error {
+	b, err := os.ReadFile(p.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	zp := dns.NewZoneParser(bytes.NewReader(b), ".", p.file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		p.records[name] = append(p.records[name], rr)
+	}
+	return zp.Err()
+}
+
+// save writes records to p.file atomically.
+func (p *DynUpdate) save(records map[string][]dns.RR) error {
+	if len(p.file) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	for _, rrs := range records {
+		for _, rr := range rrs {
+			b.WriteString(rr.String())
+			b.WriteByte('\n')
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.file), filepath.Base(p.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.file)
+}
+
+func (p *DynUpdate) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if qCtx.QQuestion().Qtype == dns.TypeAXFR {
+		if r := p.axfr(qCtx.Q(), qCtx.ServerMeta); r != nil {
+			qCtx.SetResponse(r)
+		}
+		return nil
+	}
+	if r := p.response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+// response returns the answer of q from the records. It returns nil if
+// the name of q has no record.
+func (p *DynUpdate) response(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET {
+		return nil
+	}
+	name := strings.ToLower(question.Name)
+	p.m.RLock()
+	rrs := p.records[name]
+	if question.Qtype == dns.TypeSOA && p.hasZone(name) {
+		rrs = append([]dns.RR{p.soa(name)}, rrs...)
+	}
+	p.m.RUnlock()
+	if len(rrs) == 0 {
+		return nil
+	}
+
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+	for _, rr := range rrs {
+		t := rr.Header().Rrtype
+		if t == question.Qtype || t == dns.TypeCNAME {
+			rr = dns.Copy(rr)
+			rr.Header().Name = question.Name
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	return r
+}
+
+// Update implements server_utils.Updater.
+func (p *DynUpdate) Update(_ context.Context, b []byte, meta server.QueryMeta) []byte {
+	q := new(dns.Msg)
+	if err := q.Unpack(b); err != nil {
+		return nil
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+
+	t := q.IsTsig()
+	if t == nil {
+		r.Rcode = dns.RcodeRefused
+		return pack(r)
+	}
+	key, ok := p.keys[strings.ToLower(t.Hdr.Name)]
+	if !ok || !strings.EqualFold(t.Algorithm, key.algorithm) {
+		r.Rcode = dns.RcodeNotAuth
+		return pack(r)
+	}
+	if err := dns.TsigVerify(b, key.secret, "", false); err != nil {
+		p.logger.Warn("invalid tsig of update", zap.Stringer("client", meta.ClientAddr), zap.Error(err))
+		r.Rcode = dns.RcodeNotAuth
+		return pack(r)
+	}
+
+	r.Rcode = p.update(q)
+	if r.Rcode == dns.RcodeSuccess {
+		p.logger.Info(
+			"zone updated",
+			zap.String("zone", q.Question[0].Name),
+			zap.String("key", t.Hdr.Name),
+			zap.Stringer("client", meta.ClientAddr),
+		)
+		p.sendNotify(strings.ToLower(q.Question[0].Name))
+	}
+	r.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	out, _, err := dns.TsigGenerate(r, key.secret, t.MAC, false)
+	if err != nil {
+		p.logger.Error("failed to sign update response", zap.Error(err))
+		return nil
+	}
+	return out
+}
+
+func pack(m *dns.Msg) []byte {
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
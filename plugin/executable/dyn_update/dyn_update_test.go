@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dyn_update
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	testKey    = "dhcp."
+	testSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestDynUpdate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "records")
+	args := &Args{
+		Zones: []string{"home.arpa"},
+		Keys:  []KeyArgs{{Name: "dhcp", Secret: testSecret}},
+		File:  file,
+	}
+	p, err := NewDynUpdate(args, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// send signs m with secret if it is not empty, and returns the rcode.
+	send := func(m *dns.Msg, secret string) int {
+		t.Helper()
+		var b []byte
+		var mac string
+		if len(secret) > 0 {
+			m.SetTsig(testKey, dns.HmacSHA256, 300, time.Now().Unix())
+			b, mac, err = dns.TsigGenerate(m, secret, "", false)
+		} else {
+			b, err = m.Pack()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := p.Update(context.Background(), b, server.QueryMeta{})
+		r := new(dns.Msg)
+		if err := r.Unpack(out); err != nil {
+			t.Fatal(err)
+		}
+		// miekg/dns does not verify responses with NOTAUTH rcode.
+		if secret == testSecret && r.Rcode != dns.RcodeNotAuth {
+			if err := dns.TsigVerify(out, testSecret, mac, false); err != nil {
+				t.Fatalf("invalid response tsig, %v", err)
+			}
+		}
+		return r.Rcode
+	}
+	lookup := func(p *DynUpdate, name string, qt uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qt)
+		return p.response(q)
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	m.Insert([]dns.RR{mustRR(t, "pc.home.arpa. 300 IN A 192.168.1.2")})
+	if rcode := send(m.Copy(), ""); rcode != dns.RcodeRefused {
+		t.Fatalf("unsigned update, want REFUSED, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode := send(m.Copy(), "d3Jvbmc="); rcode != dns.RcodeNotAuth {
+		t.Fatalf("bad signature, want NOTAUTH, got %s", dns.RcodeToString[rcode])
+	}
+	if r := lookup(p, "pc.home.arpa.", dns.TypeA); r != nil {
+		t.Fatal("rejected update is applied")
+	}
+	if rcode := send(m.Copy(), testSecret); rcode != dns.RcodeSuccess {
+		t.Fatalf("want NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+	r := lookup(p, "pc.home.arpa.", dns.TypeA)
+	if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.168.1.2" {
+		t.Fatalf("unexpected response %v", r)
+	}
+	if r := lookup(p, "pc.home.arpa.", dns.TypeAAAA); r == nil || len(r.Answer) != 0 {
+		t.Fatalf("want empty answer, got %v", r)
+	}
+	if r := lookup(p, "other.home.arpa.", dns.TypeA); r != nil {
+		t.Fatal("unknown name should be passed through")
+	}
+
+	// Zone that is not configured.
+	m = new(dns.Msg)
+	m.SetUpdate("example.com.")
+	m.Insert([]dns.RR{mustRR(t, "pc.example.com. 300 IN A 192.168.1.2")})
+	if rcode := send(m, testSecret); rcode != dns.RcodeNotAuth {
+		t.Fatalf("want NOTAUTH, got %s", dns.RcodeToString[rcode])
+	}
+
+	// Prerequisite: name is not in use.
+	m = new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	m.NameNotUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "pc.home.arpa."}}})
+	m.Insert([]dns.RR{mustRR(t, "pc.home.arpa. 300 IN A 192.168.1.3")})
+	if rcode := send(m, testSecret); rcode != dns.RcodeYXDomain {
+		t.Fatalf("want YXDOMAIN, got %s", dns.RcodeToString[rcode])
+	}
+
+	// Replace the A record and add a PTR.
+	m = new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	m.RemoveRRset([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "pc.home.arpa.", Rrtype: dns.TypeA}}})
+	m.Insert([]dns.RR{
+		mustRR(t, "pc.home.arpa. 300 IN A 192.168.1.4"),
+		mustRR(t, "pc.home.arpa. 300 IN AAAA fd00::4"),
+	})
+	if rcode := send(m, testSecret); rcode != dns.RcodeSuccess {
+		t.Fatalf("want NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+	if r := lookup(p, "PC.home.arpa.", dns.TypeA); r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.168.1.4" {
+		t.Fatalf("unexpected response %v", r)
+	}
+
+	// Records are loaded from the file.
+	p2, err := NewDynUpdate(args, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := lookup(p2, "pc.home.arpa.", dns.TypeAAAA); r == nil || len(r.Answer) != 1 {
+		t.Fatalf("records are not persisted, %v", r)
+	}
+
+	// Delete the name.
+	m = new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "pc.home.arpa."}}})
+	if rcode := send(m, testSecret); rcode != dns.RcodeSuccess {
+		t.Fatalf("want NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+	if r := lookup(p, "pc.home.arpa.", dns.TypeA); r != nil {
+		t.Fatalf("name is not deleted, %v", r)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dyn_update
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// update checks and applies the UPDATE message q. It returns the rcode
+// of the response. See RFC 2136 3.
+func (p *DynUpdate) update(q *dns.Msg) int {
+	// 3.1 Zone section.
+	if len(q.Question) != 1 || q.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+	zone := strings.ToLower(q.Question[0].Name)
+	if q.Question[0].Qclass != dns.ClassINET || !p.hasZone(zone) {
+		return dns.RcodeNotAuth
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if rcode := p.checkPrerequisites(zone, q.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if rcode := prescan(zone, q.Ns); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	next := maps.Clone(p.records)
+	for _, rr := range q.Ns {
+		apply(next, zone, rr)
+	}
+	if err := p.save(next); err != nil {
+		p.logger.Error("failed to save records", zap.String("file", p.file), zap.Error(err))
+		return dns.RcodeServerFailure
+	}
+	p.records = next
+	p.serials[zone] = nextSerial(p.serials[zone])
+	return dns.RcodeSuccess
+}
+
+func (p *DynUpdate) hasZone(zone string) bool {
+	for _, z := range p.zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrerequisites checks the prerequisite section. See RFC 2136 3.2.
+// p.m must be held.
+func (p *DynUpdate) checkPrerequisites(zone string, prs []dns.RR) int {
+	type rrset struct {
+		name  string
+		rtype uint16
+	}
+	var exact map[rrset][]dns.RR
+	for _, rr := range prs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(p.records[name]) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if len(rrsOfType(p.records[name], h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(p.records[name]) != 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if len(rrsOfType(p.records[name], h.Rrtype)) != 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			if exact == nil {
+				exact = make(map[rrset][]dns.RR)
+			}
+			k := rrset{name: name, rtype: h.Rrtype}
+			exact[k] = append(exact[k], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	// Value dependent RRsets must match exactly.
+	for k, want := range exact {
+		got := rrsOfType(p.records[k.name], k.rtype)
+		if len(got) != len(want) {
+			return dns.RcodeNXRrset
+		}
+		for _, rr := range want {
+			if indexOf(got, rr) < 0 {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func isMetaType(t uint16) bool {
+	switch t {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG:
+		return true
+	}
+	return false
+}
+
+// prescan checks the update section. See RFC 2136 3.4.1.
+func prescan(zone string, ups []dns.RR) int {
+	for _, rr := range ups {
+		h := rr.Header()
+		if !dns.IsSubDomain(zone, strings.ToLower(h.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || (isMetaType(h.Rrtype) && h.Rrtype != dns.TypeANY) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || isMetaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// apply applies one RR of the update section to records. Slices in
+// records are never modified in place. See RFC 2136 3.4.2.
+func apply(records map[string][]dns.RR, zone string, rr dns.RR) {
+	h := rr.Header()
+	name := strings.ToLower(h.Name)
+	old := records[name]
+	switch h.Class {
+	case dns.ClassINET:
+		if h.Rrtype == dns.TypeSOA {
+			return
+		}
+		hasCNAME := len(rrsOfType(old, dns.TypeCNAME)) != 0
+		if (h.Rrtype == dns.TypeCNAME) != hasCNAME && len(old) != 0 {
+			return // CNAME cannot coexist with other data.
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		var n []dns.RR
+		for _, o := range old {
+			// A new CNAME replaces the old one. A duplicated RR replaces the
+			// old one, so its ttl is updated.
+			if o.Header().Rrtype == dns.TypeCNAME && h.Rrtype == dns.TypeCNAME || dns.IsDuplicate(o, rr) {
+				continue
+			}
+			n = append(n, o)
+		}
+		records[name] = append(n, rr)
+	case dns.ClassANY:
+		var n []dns.RR
+		for _, o := range old {
+			t := o.Header().Rrtype
+			// NS of the zone apex are kept.
+			if name == zone && t == dns.TypeNS {
+				n = append(n, o)
+				continue
+			}
+			if h.Rrtype != dns.TypeANY && t != h.Rrtype {
+				n = append(n, o)
+			}
+		}
+		set(records, name, n)
+	case dns.ClassNONE:
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		if i := indexOf(old, rr); i >= 0 {
+			n := make([]dns.RR, 0, len(old)-1)
+			n = append(n, old[:i]...)
+			set(records, name, append(n, old[i+1:]...))
+		}
+	}
+}
+
+func set(records map[string][]dns.RR, name string, rrs []dns.RR) {
+	if len(rrs) == 0 {
+		delete(records, name)
+		return
+	}
+	records[name] = rrs
+}
+
+func rrsOfType(rrs []dns.RR, t uint16) []dns.RR {
+	var n []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == t {
+			n = append(n, rr)
+		}
+	}
+	return n
+}
+
+func indexOf(rrs []dns.RR, rr dns.RR) int {
+	for i, o := range rrs {
+		if dns.IsDuplicate(o, rr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dyn_update
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	notifyTimeout = time.Second * 2
+	notifyRetry   = 3
+
+	// maxXFRSize is the max size of an axfr response. It leaves some room
+	// for the edns0 and tsig records that may be added later.
+	maxXFRSize = dns.MaxMsgSize - 1024
+)
+
+// soa returns the synthesized SOA of zone. p.m must be held.
+func (p *DynUpdate) soa(zone string) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  p.serials[zone],
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	}
+}
+
+func nextSerial(s uint32) uint32 {
+	if n := uint32(time.Now().Unix()); n > s {
+		return n
+	}
+	return s + 1
+}
+
+// zoneOf returns the closest configured zone of name. Empty if name is
+// not in any zone.
+func (p *DynUpdate) zoneOf(name string) string {
+	var zone string
+	for _, z := range p.zones {
+		if len(z) > len(zone) && dns.IsSubDomain(z, name) {
+			zone = z
+		}
+	}
+	return zone
+}
+
+// axfr returns the whole zone of q (RFC 5936), which is sent in one
+// message, so the zone must fit in 64KiB. Larger zones are answered with
+// SERVFAIL. Transfers are refused unless they are over tcp from one of
+// the secondaries. It returns nil if q is not for a configured zone.
+func (p *DynUpdate) axfr(q *dns.Msg, meta server.QueryMeta) *dns.Msg {
+	zone := strings.ToLower(q.Question[0].Name)
+	if !p.hasZone(zone) {
+		return nil
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	if meta.FromUDP || !p.isSecondary(meta.ClientAddr) {
+		p.logger.Warn("zone transfer refused", zap.String("zone", zone), zap.Stringer("client", meta.ClientAddr))
+		r.Rcode = dns.RcodeRefused
+		return r
+	}
+
+	r.Authoritative = true
+	p.m.RLock()
+	soa := p.soa(zone)
+	r.Answer = append(r.Answer, soa)
+	for _, name := range slices.Sorted(maps.Keys(p.records)) {
+		if p.zoneOf(name) != zone {
+			continue
+		}
+		for _, rr := range p.records[name] {
+			r.Answer = append(r.Answer, dns.Copy(rr))
+		}
+	}
+	p.m.RUnlock()
+	r.Answer = append(r.Answer, dns.Copy(soa))
+	r.Compress = true
+	if l := r.Len(); l > maxXFRSize {
+		p.logger.Error("zone is too large to be transferred in one message", zap.String("zone", zone), zap.Int("size", l), zap.Int("records", len(r.Answer)))
+		r.Answer = nil
+		r.Authoritative = false
+		r.Rcode = dns.RcodeServerFailure
+	}
+	return r
+}
+
+func (p *DynUpdate) isSecondary(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, pfx := range p.secondaries {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses an address or a prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseNotifyAddr parses "ip" or "ip:port" to "ip:port".
+func parseNotifyAddr(s string) (string, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(addr, 53).String(), nil
+	}
+	addr, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// sendNotify sends NOTIFY of zone to all notify addresses in background.
+func (p *DynUpdate) sendNotify(zone string) {
+	for _, addr := range p.notify {
+		p.notifyWg.Add(1)
+		go func() {
+			defer p.notifyWg.Done()
+			var err error
+			for i := 0; i < notifyRetry; i++ {
+				if err = p.notifyOnce(zone, addr); err == nil {
+					return
+				}
+				if p.ctx.Err() != nil {
+					return
+				}
+			}
+			p.logger.Warn("failed to notify secondary", zap.String("zone", zone), zap.String("addr", addr), zap.Error(err))
+		}()
+	}
+}
+
+// notifyOnce sends a NOTIFY to addr over udp and waits for its response.
+func (p *DynUpdate) notifyOnce(zone, addr string) error {
+	ctx, cancel := context.WithTimeout(p.ctx, notifyTimeout)
+	defer cancel()
+	d := net.Dialer{}
+	c, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+	go func() {
+		<-ctx.Done()
+		c.SetDeadline(time.Now())
+	}()
+
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	b, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Write(b); err != nil {
+		return err
+	}
+	rb := pool.GetBuf(dns.MinMsgSize)
+	defer pool.ReleaseBuf(rb)
+	for {
+		n, err := c.Read(*rb)
+		if err != nil {
+			return err
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack((*rb)[:n]); err != nil || r.Id != m.Id || !r.Response {
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("notify rejected with rcode %s", dns.RcodeToString[r.Rcode])
+		}
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dyn_update
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestDynUpdate_axfr(t *testing.T) {
+	// A secondary that receives NOTIFY.
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	notified := make(chan string, 1)
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if err := m.Unpack(b[:n]); err != nil || m.Opcode != dns.OpcodeNotify {
+				continue
+			}
+			r := new(dns.Msg)
+			r.SetReply(m)
+			rb, _ := r.Pack()
+			c.WriteTo(rb, addr)
+			notified <- m.Question[0].Name
+		}
+	}()
+
+	p, err := NewDynUpdate(&Args{
+		Zones:       []string{"home.arpa", "sub.home.arpa"},
+		Keys:        []KeyArgs{{Name: "dhcp", Secret: testSecret}},
+		Secondaries: []string{"127.0.0.0/8"},
+		Notify:      []string{c.LocalAddr().String()},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	m := new(dns.Msg)
+	m.SetUpdate("home.arpa.")
+	m.Insert([]dns.RR{
+		mustRR(t, "pc.home.arpa. 300 IN A 192.168.1.2"),
+		mustRR(t, "nas.home.arpa. 300 IN A 192.168.1.3"),
+		mustRR(t, "x.sub.home.arpa. 300 IN A 192.168.1.4"),
+	})
+	m.SetTsig(testKey, dns.HmacSHA256, 300, time.Now().Unix())
+	b, _, err := dns.TsigGenerate(m, testSecret, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(p.Update(context.Background(), b, server.QueryMeta{})); err != nil || r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("update failed, %v, %v", err, r)
+	}
+	select {
+	case zone := <-notified:
+		if zone != "home.arpa." {
+			t.Fatalf("unexpected notified zone %s", zone)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("secondary is not notified")
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("home.arpa.", dns.TypeAXFR)
+	r = p.axfr(q, server.QueryMeta{ClientAddr: netip.MustParseAddr("127.0.0.1")})
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 4 {
+		t.Fatalf("unexpected axfr response %v", r)
+	}
+	first, ok1 := r.Answer[0].(*dns.SOA)
+	last, ok2 := r.Answer[3].(*dns.SOA)
+	if !ok1 || !ok2 || first.Serial != last.Serial {
+		t.Fatalf("axfr must start and end with the soa, %v", r)
+	}
+	if r.Answer[1].Header().Name != "nas.home.arpa." || r.Answer[2].Header().Name != "pc.home.arpa." {
+		t.Fatalf("unexpected axfr records %v", r.Answer)
+	}
+
+	// The serial from a SOA query.
+	q.SetQuestion("home.arpa.", dns.TypeSOA)
+	if r := p.response(q); r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.SOA).Serial != first.Serial {
+		t.Fatalf("unexpected soa response %v", r)
+	}
+
+	q.SetQuestion("home.arpa.", dns.TypeAXFR)
+	if r := p.axfr(q, server.QueryMeta{ClientAddr: netip.MustParseAddr("192.168.1.2")}); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want refused for unknown client, got %v", r)
+	}
+	if r := p.axfr(q, server.QueryMeta{ClientAddr: netip.MustParseAddr("127.0.0.1"), FromUDP: true}); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want refused over udp, got %v", r)
+	}
+	q.SetQuestion("example.com.", dns.TypeAXFR)
+	if r := p.axfr(q, server.QueryMeta{ClientAddr: netip.MustParseAddr("127.0.0.1")}); r != nil {
+		t.Fatalf("other zones should be passed through, got %v", r)
+	}
+}
+
+func TestDynUpdate_axfr_tooLarge(t *testing.T) {
+	p, err := NewDynUpdate(&Args{
+		Zones:       []string{"home.arpa"},
+		Keys:        []KeyArgs{{Name: "dhcp", Secret: testSecret}},
+		Secondaries: []string{"127.0.0.0/8"},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion("home.arpa.", dns.TypeAXFR)
+	meta := server.QueryMeta{ClientAddr: netip.MustParseAddr("127.0.0.1")}
+
+	setRecords := func(n int) {
+		records := make(map[string][]dns.RR)
+		for i := 0; i < n; i++ {
+			rr := mustRR(t, fmt.Sprintf("host-with-a-long-name-%d.home.arpa. 300 IN TXT %q", i, strings.Repeat("x", 32)))
+			records[rr.Header().Name] = []dns.RR{rr}
+		}
+		p.m.Lock()
+		p.records = records
+		p.m.Unlock()
+	}
+
+	setRecords(4096)
+	if r := p.axfr(q, meta); r == nil || r.Rcode != dns.RcodeServerFailure || len(r.Answer) != 0 {
+		t.Fatalf("want servfail for a zone over 64KiB, got %v", r)
+	}
+
+	setRecords(16)
+	r := p.axfr(q, meta)
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 18 {
+		t.Fatalf("unexpected axfr response %v", r)
+	}
+	if _, err := pool.PackTCPBuffer(r); err != nil {
+		t.Fatal(err)
+	}
+}
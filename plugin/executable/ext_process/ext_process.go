@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ext_process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "ext_process"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+	sequence.MustRegMatchQuickSetup(PluginType, QuickSetupMatch)
+}
+
+const defaultTimeout = time.Second
+
+type Args struct {
+	// Cmd is the command and its args. The process will be started once
+	// and receives all queries from its stdin.
+	Cmd []string `yaml:"cmd"`
+	// Socket is a unix socket path. A new connection will be opened for
+	// each query. Cannot be used with Cmd.
+	Socket string `yaml:"socket"`
+	// Timeout in milliseconds for each query. Default is 1000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Timeout, int(defaultTimeout.Milliseconds()))
+}
+
+var _ sequence.Executable = (*ExtProcess)(nil)
+var _ sequence.Matcher = (*ExtProcess)(nil)
+
+// ExtProcess sends queries to an external process and uses
+// its reply as the response or the verdict.
+type ExtProcess struct {
+	t       transport
+	timeout time.Duration
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewExtProcess(args.(*Args), bp.L())
+}
+
+// QuickSetup format: [unix:socket_path|command [args]...]
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	return NewExtProcess(parseQuickSetupArgs(s), bq.L())
+}
+
+// QuickSetupMatch has the same format as QuickSetup.
+func QuickSetupMatch(bq sequence.BQ, s string) (sequence.Matcher, error) {
+	return NewExtProcess(parseQuickSetupArgs(s), bq.L())
+}
+
+func parseQuickSetupArgs(s string) *Args {
+	args := new(Args)
+	if p, ok := strings.CutPrefix(s, "unix:"); ok {
+		args.Socket = p
+	} else {
+		args.Cmd = strings.Fields(s)
+	}
+	return args
+}
+
+// NewExtProcess creates a new ExtProcess. logger can be nil.
+func NewExtProcess(args *Args, logger *zap.Logger) (*ExtProcess, error) {
+	args.init()
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	p := &ExtProcess{timeout: time.Duration(args.Timeout) * time.Millisecond}
+	switch {
+	case len(args.Cmd) > 0 && len(args.Socket) > 0:
+		return nil, errors.New("cmd and socket cannot be both set")
+	case len(args.Cmd) > 0:
+		p.t = newProcTransport(args.Cmd, logger)
+	case len(args.Socket) > 0:
+		p.t = &sockTransport{addr: args.Socket}
+	default:
+		return nil, errors.New("missing cmd or socket")
+	}
+	return p, nil
+}
+
+func (p *ExtProcess) exchange(ctx context.Context, qCtx *query_context.Context) (ReplyMeta, *dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.t.exchange(ctx, newRequestMeta(qCtx), qCtx.Q())
+}
+
+// Exec implements sequence.Executable.
+func (p *ExtProcess) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	rm, r, err := p.exchange(ctx, qCtx)
+	if err != nil {
+		return fmt.Errorf("ext_process: %w", err)
+	}
+
+	switch rm.Action {
+	case "", ActionPass:
+	case ActionResponse:
+		if r == nil {
+			return errors.New("ext_process: response action without msg")
+		}
+		r.Id = qCtx.Q().Id
+		qCtx.SetResponse(r)
+	case ActionReject:
+		rcode := dns.RcodeRefused
+		if rm.Rcode != nil {
+			rcode = *rm.Rcode
+		}
+		if rcode < 0 || rcode > 0xfff {
+			return fmt.Errorf("ext_process: invalid rcode %d", rcode)
+		}
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Rcode = rcode
+		qCtx.SetResponse(r)
+	default:
+		return fmt.Errorf("ext_process: unknown action %s", rm.Action)
+	}
+	return nil
+}
+
+// Match implements sequence.Matcher.
+func (p *ExtProcess) Match(ctx context.Context, qCtx *query_context.Context) (bool, error) {
+	rm, _, err := p.exchange(ctx, qCtx)
+	if err != nil {
+		return false, fmt.Errorf("ext_process: %w", err)
+	}
+	return rm.Match, nil
+}
+
+func (p *ExtProcess) Close() error {
+	return p.t.Close()
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ext_process
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func startTestServer(t *testing.T, handle func(meta RequestMeta, q *dns.Msg) (ReplyMeta, *dns.Msg)) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "ext.sock")
+	l, err := net.Listen("unix", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var meta RequestMeta
+				q, err := readFrame(bufio.NewReader(c), &meta)
+				if err != nil {
+					return
+				}
+				rm, r := handle(meta, q)
+				_ = writeFrame(c, rm, r)
+			}()
+		}
+	}()
+	return p
+}
+
+func TestExtProcess_Socket(t *testing.T) {
+	sock := startTestServer(t, func(meta RequestMeta, q *dns.Msg) (ReplyMeta, *dns.Msg) {
+		switch meta.Qname {
+		case "reject.":
+			rcode := dns.RcodeNameError
+			return ReplyMeta{Action: ActionReject, Rcode: &rcode}, nil
+		case "nodata.":
+			rcode := dns.RcodeSuccess
+			return ReplyMeta{Action: ActionReject, Rcode: &rcode}, nil
+		case "refused.":
+			return ReplyMeta{Action: ActionReject}, nil
+		case "resp.":
+			r := new(dns.Msg)
+			r.SetReply(q)
+			r.Answer = append(r.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: meta.Qname, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+				A:   net.IPv4(1, 2, 3, 4),
+			})
+			return ReplyMeta{Action: ActionResponse}, r
+		default:
+			return ReplyMeta{Match: true}, nil
+		}
+	})
+
+	p, err := NewExtProcess(&Args{Socket: sock}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	newCtx := func(name string) *query_context.Context {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		return query_context.NewContext(q)
+	}
+
+	qCtx := newCtx("reject.")
+	if err := p.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeNameError {
+		t.Fatalf("unexpected reject resp %v", r)
+	}
+
+	qCtx = newCtx("nodata.")
+	if err := p.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 {
+		t.Fatalf("want an empty NOERROR resp, got %v", r)
+	}
+
+	qCtx = newCtx("refused.")
+	if err := p.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want REFUSED without rcode, got %v", r)
+	}
+
+	qCtx = newCtx("resp.")
+	if err := p.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r == nil || len(r.Answer) != 1 || r.Id != qCtx.Q().Id {
+		t.Fatalf("unexpected resp %v", r)
+	}
+
+	qCtx = newCtx("pass.")
+	if err := p.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if qCtx.R() != nil {
+		t.Fatal("pass action should not set a response")
+	}
+	ok, err := p.Match(context.Background(), qCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("should match")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ext_process
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+// Frame layout (both directions):
+//
+//	[4 bytes meta length][meta json][2 bytes msg length][msg in wire format]
+//
+// The msg length of a reply can be 0, which means the reply has no msg.
+
+const maxMetaLength = 64 * 1024
+
+var errMetaTooLarge = errors.New("meta is too large")
+
+// RequestMeta is the json metadata sent to the external process along with
+// the query.
+type RequestMeta struct {
+	Id         uint32 `json:"id"`
+	Client     string `json:"client,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	UrlPath    string `json:"url_path,omitempty"`
+	FromUDP    bool   `json:"from_udp,omitempty"`
+	Qname      string `json:"qname"`
+	Qtype      uint16 `json:"qtype"`
+	Qclass     uint16 `json:"qclass"`
+	HasResp    bool   `json:"has_resp,omitempty"`
+}
+
+const (
+	ActionPass     = "pass"     // Do nothing.
+	ActionResponse = "response" // Use the msg in the reply as the response.
+	ActionReject   = "reject"   // Reply with Rcode.
+)
+
+// ReplyMeta is the json metadata sent back by the external process.
+type ReplyMeta struct {
+	// Action is one of ActionPass, ActionResponse and ActionReject.
+	// Empty Action is ActionPass.
+	Action string `json:"action"`
+	// Rcode for ActionReject. Default (omitted or null) is REFUSED.
+	// 0 (NOERROR) rejects the query with an empty answer.
+	Rcode *int `json:"rcode,omitempty"`
+	// Match is the result when this plugin is used as a matcher.
+	Match bool `json:"match"`
+}
+
+func newRequestMeta(qCtx *query_context.Context) RequestMeta {
+	question := qCtx.QQuestion()
+	m := RequestMeta{
+		Id:         qCtx.Id(),
+		ServerName: qCtx.ServerMeta.ServerName,
+		UrlPath:    qCtx.ServerMeta.UrlPath,
+		FromUDP:    qCtx.ServerMeta.FromUDP,
+		Qname:      question.Name,
+		Qtype:      question.Qtype,
+		Qclass:     question.Qclass,
+		HasResp:    qCtx.R() != nil,
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		m.Client = addr.String()
+	}
+	return m
+}
+
+// writeFrame writes a frame to w. m can be nil.
+func writeFrame(w io.Writer, meta any, m *dns.Msg) error {
+	mb, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal meta, %w", err)
+	}
+	if len(mb) > maxMetaLength {
+		return errMetaTooLarge
+	}
+
+	var wire []byte
+	if m != nil {
+		b, err := pool.PackBuffer(m)
+		if err != nil {
+			return fmt.Errorf("failed to pack msg, %w", err)
+		}
+		defer pool.ReleaseBuf(b)
+		wire = *b
+	}
+	if len(wire) > dns.MaxMsgSize {
+		return fmt.Errorf("msg size %d is too large", len(wire))
+	}
+
+	buf := pool.GetBuf(4 + len(mb) + 2 + len(wire))
+	defer pool.ReleaseBuf(buf)
+	b := *buf
+	binary.BigEndian.PutUint32(b, uint32(len(mb)))
+	copy(b[4:], mb)
+	binary.BigEndian.PutUint16(b[4+len(mb):], uint16(len(wire)))
+	copy(b[4+len(mb)+2:], wire)
+	_, err = w.Write(b)
+	return err
+}
+
+// readFrame reads a frame from r and decodes its meta into meta.
+// The returned msg is nil if the frame has no msg.
+func readFrame(r io.Reader, meta any) (*dns.Msg, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	ml := binary.BigEndian.Uint32(h[:])
+	if ml > maxMetaLength {
+		return nil, errMetaTooLarge
+	}
+	mb := make([]byte, ml)
+	if _, err := io.ReadFull(r, mb); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mb, meta); err != nil {
+		return nil, fmt.Errorf("invalid meta, %w", err)
+	}
+
+	if _, err := io.ReadFull(r, h[:2]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint16(h[:2])
+	if l == 0 {
+		return nil, nil
+	}
+	b := pool.GetBuf(int(l))
+	defer pool.ReleaseBuf(b)
+	if _, err := io.ReadFull(r, *b); err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(*b); err != nil {
+		return nil, fmt.Errorf("invalid msg, %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ext_process
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+type transport interface {
+	exchange(ctx context.Context, meta RequestMeta, q *dns.Msg) (ReplyMeta, *dns.Msg, error)
+	Close() error
+}
+
+var errClosed = errors.New("transport closed")
+
+// procTransport talks to a long-running subprocess via its stdin and stdout.
+// Queries are serialized. The subprocess will be restarted on the next query
+// if any io error occurred.
+type procTransport struct {
+	name   string
+	args   []string
+	logger *zap.Logger
+
+	m      sync.Mutex
+	closed bool
+	cmd    *exec.Cmd
+	w      *os.File
+	r      *os.File
+	br     *bufio.Reader
+}
+
+func newProcTransport(cmdArgs []string, logger *zap.Logger) *procTransport {
+	return &procTransport{
+		name:   cmdArgs[0],
+		args:   cmdArgs[1:],
+		logger: logger,
+	}
+}
+
+func (t *procTransport) startLocked() error {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		_ = stdinR.Close()
+		_ = stdinW.Close()
+		return err
+	}
+
+	cmd := exec.Command(t.name, t.args...)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	// Child ends are not needed anymore.
+	_ = stdinR.Close()
+	_ = stdoutW.Close()
+	if err != nil {
+		_ = stdinW.Close()
+		_ = stdoutR.Close()
+		return err
+	}
+	t.logger.Info("external process started", zap.String("cmd", t.name), zap.Int("pid", cmd.Process.Pid))
+
+	t.cmd = cmd
+	t.w = stdinW
+	t.r = stdoutR
+	t.br = bufio.NewReader(stdoutR)
+	return nil
+}
+
+func (t *procTransport) stopLocked() {
+	if t.cmd == nil {
+		return
+	}
+	_ = t.w.Close()
+	_ = t.r.Close()
+	_ = t.cmd.Process.Kill()
+	err := t.cmd.Wait()
+	t.logger.Info("external process stopped", zap.String("cmd", t.name), zap.Error(err))
+	t.cmd, t.w, t.r, t.br = nil, nil, nil, nil
+}
+
+func (t *procTransport) exchange(ctx context.Context, meta RequestMeta, q *dns.Msg) (ReplyMeta, *dns.Msg, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	var rm ReplyMeta
+	if t.closed {
+		return rm, nil, errClosed
+	}
+	if t.cmd == nil {
+		if err := t.startLocked(); err != nil {
+			return rm, nil, fmt.Errorf("failed to start external process, %w", err)
+		}
+	}
+
+	// Pipes may not support deadline on some platforms. Ignore the error.
+	if ddl, ok := ctx.Deadline(); ok {
+		_ = t.w.SetWriteDeadline(ddl)
+		_ = t.r.SetReadDeadline(ddl)
+	}
+
+	r, err := t.exchangeLocked(meta, q, &rm)
+	if err != nil {
+		// The stream is in an unknown state. Restart the process next time.
+		t.stopLocked()
+		return rm, nil, err
+	}
+	return rm, r, nil
+}
+
+func (t *procTransport) exchangeLocked(meta RequestMeta, q *dns.Msg, rm *ReplyMeta) (*dns.Msg, error) {
+	if err := writeFrame(t.w, meta, q); err != nil {
+		return nil, fmt.Errorf("failed to write query, %w", err)
+	}
+	r, err := readFrame(t.br, rm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reply, %w", err)
+	}
+	return r, nil
+}
+
+func (t *procTransport) Close() error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.closed = true
+	t.stopLocked()
+	return nil
+}
+
+// sockTransport dials a new unix socket connection for each query.
+type sockTransport struct {
+	addr string
+}
+
+func (t *sockTransport) exchange(ctx context.Context, meta RequestMeta, q *dns.Msg) (ReplyMeta, *dns.Msg, error) {
+	var rm ReplyMeta
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", t.addr)
+	if err != nil {
+		return rm, nil, fmt.Errorf("failed to dial socket, %w", err)
+	}
+	defer c.Close()
+
+	if ddl, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(ddl)
+	} else {
+		_ = c.SetDeadline(time.Now().Add(defaultTimeout))
+	}
+	if err := writeFrame(c, meta, q); err != nil {
+		return rm, nil, fmt.Errorf("failed to write query, %w", err)
+	}
+	r, err := readFrame(bufio.NewReader(c), &rm)
+	if err != nil {
+		return rm, nil, fmt.Errorf("failed to read reply, %w", err)
+	}
+	return rm, r, nil
+}
+
+func (t *sockTransport) Close() error {
+	return nil
+}
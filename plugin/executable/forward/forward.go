@@ -25,7 +25,9 @@ import (
 	"errors"
 	"fmt"
 	"math/rand/v2"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -55,6 +57,11 @@ type Args struct {
 	Upstreams  []UpstreamConfig `yaml:"upstreams"`
 	Concurrent int              `yaml:"concurrent"`
 
+	// Merge sends A/AAAA queries to all upstreams and returns the union
+	// of their answers. Duplicated addresses are removed and ttls are
+	// set to the minimum one. Concurrent is ignored in this mode.
+	Merge bool `yaml:"merge"`
+
 	// Global options.
 	Socks5       string `yaml:"socks5"`
 	SoMark       int    `yaml:"so_mark"`
@@ -65,12 +72,12 @@ type Args struct {
 
 type UpstreamConfig struct {
 	Tag         string `yaml:"tag"`
-	Addr        string `yaml:"addr"` // Required.
+	Addr        string `yaml:"addr"` // Required, unless Provider is set.
 	DialAddr    string `yaml:"dial_addr"`
 	IdleTimeout int    `yaml:"idle_timeout"`
 
-	// Deprecated: This option has no affect.
-	// TODO: (v6) Remove this option.
+	// MaxConns limits the number of connections of tcp/dot upstreams
+	// without pipelining. Default is no limit.
 	MaxConns           int  `yaml:"max_conns"`
 	EnablePipeline     bool `yaml:"enable_pipeline"`
 	EnableHTTP3        bool `yaml:"enable_http3"`
@@ -81,10 +88,20 @@ type UpstreamConfig struct {
 	BindToDevice string `yaml:"bind_to_device"`
 	Bootstrap    string `yaml:"bootstrap"`
 	BootstrapVer int    `yaml:"bootstrap_version"`
+
+	// Provider is a managed resolver service, "nextdns", "controld" or
+	// "adguard". If it is set, Addr is derived from ProfileID, DeviceName
+	// and Protocol ("doh" (default), "dot" or "doq"), and the service's
+	// anycast address is dialed unless DialAddr or Bootstrap is set.
+	// BootstrapVer 6 selects its ipv6 address.
+	Provider   string `yaml:"provider"`
+	ProfileID  string `yaml:"profile_id"`
+	DeviceName string `yaml:"device_name"`
+	Protocol   string `yaml:"protocol"`
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	f, err := NewForward(args.(*Args), Opts{Logger: bp.L(), MetricsTag: bp.Tag()})
+	f, err := NewForward(args.(*Args), Opts{Logger: bp.L(), MetricsTag: bp.Tag(), RecursionAllowed: bp.M().RecursionAllowed})
 	if err != nil {
 		return nil, err
 	}
@@ -97,18 +114,29 @@ func Init(bp *coremain.BP, args any) (any, error) {
 
 var _ sequence.Executable = (*Forward)(nil)
 var _ sequence.QuickConfigurableExec = (*Forward)(nil)
+var _ coremain.ReadinessChecker = (*Forward)(nil)
+var _ coremain.UpstreamSwitcher = (*Forward)(nil)
+var _ coremain.StateInspector = (*Forward)(nil)
+var _ sequence.WireSafe = (*Forward)(nil)
 
 type Forward struct {
 	args *Args
 
-	logger       *zap.Logger
-	us           []*upstreamWrapper
-	tag2Upstream map[string]*upstreamWrapper // for fast tag lookup only.
+	logger           *zap.Logger
+	recursionAllowed func(addr netip.Addr) bool // maybe nil
+	us               []*upstreamWrapper
+	tag2Upstream     map[string]*upstreamWrapper // for fast tag lookup only.
+	nDisabled        atomic.Int32                // number of disabled upstreams.
 }
 
 type Opts struct {
 	Logger     *zap.Logger
 	MetricsTag string
+
+	// RecursionAllowed reports whether the client is allowed to get
+	// answers from upstreams. Queries of other clients are REFUSED.
+	// Nil means all clients are allowed. See coremain.ACLConfig.
+	RecursionAllowed func(addr netip.Addr) bool
 }
 
 // NewForward inits a Forward from given args.
@@ -122,9 +150,10 @@ func NewForward(args *Args, opt Opts) (*Forward, error) {
 	}
 
 	f := &Forward{
-		args:         args,
-		logger:       opt.Logger,
-		tag2Upstream: make(map[string]*upstreamWrapper),
+		args:             args,
+		logger:           opt.Logger,
+		recursionAllowed: opt.RecursionAllowed,
+		tag2Upstream:     make(map[string]*upstreamWrapper),
 	}
 
 	applyGlobal := func(c *UpstreamConfig) {
@@ -136,31 +165,17 @@ func NewForward(args *Args, opt Opts) (*Forward, error) {
 	}
 
 	for i, c := range args.Upstreams {
+		if err := applyProvider(&c); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("#%d upstream invalid provider args, %w", i, err)
+		}
 		if len(c.Addr) == 0 {
 			return nil, fmt.Errorf("#%d upstream invalid args, addr is required", i)
 		}
 		applyGlobal(&c)
 
 		uw := newWrapper(i, c, opt.MetricsTag)
-		uOpt := upstream.Opt{
-			DialAddr:       c.DialAddr,
-			Socks5:         c.Socks5,
-			SoMark:         c.SoMark,
-			BindToDevice:   c.BindToDevice,
-			IdleTimeout:    time.Duration(c.IdleTimeout) * time.Second,
-			EnablePipeline: c.EnablePipeline,
-			EnableHTTP3:    c.EnableHTTP3,
-			Bootstrap:      c.Bootstrap,
-			BootstrapVer:   c.BootstrapVer,
-			TLSConfig: &tls.Config{
-				InsecureSkipVerify: c.InsecureSkipVerify,
-				ClientSessionCache: tls.NewLRUClientSessionCache(4),
-			},
-			Logger:        opt.Logger,
-			EventObserver: uw,
-		}
-
-		u, err := upstream.NewUpstream(c.Addr, uOpt)
+		u, err := NewUpstream(c, opt.Logger, uw)
 		if err != nil {
 			_ = f.Close()
 			return nil, fmt.Errorf("failed to init upstream #%d: %w", i, err)
@@ -180,6 +195,29 @@ func NewForward(args *Args, opt Opts) (*Forward, error) {
 	return f, nil
 }
 
+// NewUpstream inits the upstream of c. Global options of Args must have
+// been applied to c. ob can be nil.
+func NewUpstream(c UpstreamConfig, logger *zap.Logger, ob upstream.EventObserver) (upstream.Upstream, error) {
+	return upstream.NewUpstream(c.Addr, upstream.Opt{
+		DialAddr:       c.DialAddr,
+		Socks5:         c.Socks5,
+		SoMark:         c.SoMark,
+		BindToDevice:   c.BindToDevice,
+		IdleTimeout:    time.Duration(c.IdleTimeout) * time.Second,
+		MaxConns:       c.MaxConns,
+		EnablePipeline: c.EnablePipeline,
+		EnableHTTP3:    c.EnableHTTP3,
+		Bootstrap:      c.Bootstrap,
+		BootstrapVer:   c.BootstrapVer,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: c.InsecureSkipVerify,
+			ClientSessionCache: tls.NewLRUClientSessionCache(4),
+		},
+		Logger:        logger,
+		EventObserver: ob,
+	})
+}
+
 func (f *Forward) RegisterMetricsTo(r prometheus.Registerer) error {
 	for _, wu := range f.us {
 		// Only register metrics for upstream that has a tag.
@@ -194,12 +232,27 @@ func (f *Forward) RegisterMetricsTo(r prometheus.Registerer) error {
 }
 
 func (f *Forward) Exec(ctx context.Context, qCtx *query_context.Context) (err error) {
-	r, err := f.exchange(ctx, qCtx, f.us)
-	if err != nil {
-		return err
-	}
-	qCtx.SetResponse(r)
-	return nil
+	return f.exec(ctx, qCtx, f.us)
+}
+
+// WireSafe implements sequence.WireSafe. Forward relays queries in wire
+// format as is, unless the merge mode is enabled.
+func (f *Forward) WireSafe() bool {
+	return !f.args.Merge
+}
+
+// quickExec is the executable from QuickConfigureExec.
+type quickExec struct {
+	f  *Forward
+	us []*upstreamWrapper
+}
+
+func (e *quickExec) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	return e.f.exec(ctx, qCtx, e.us)
+}
+
+func (e *quickExec) WireSafe() bool {
+	return e.f.WireSafe()
 }
 
 // QuickConfigureExec format: [upstream_tag]...
@@ -216,15 +269,89 @@ func (f *Forward) QuickConfigureExec(args string) (any, error) {
 			us = append(us, u)
 		}
 	}
-	var execFunc sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
-		r, err := f.exchange(ctx, qCtx, us)
-		if err != nil {
-			return err
+	return &quickExec{f: f, us: us}, nil
+}
+
+// Ready implements coremain.ReadinessChecker. Forward is ready if at
+// least one enabled upstream is healthy.
+func (f *Forward) Ready() error {
+	for _, u := range f.us {
+		if !u.disabled.Load() && u.healthy() {
+			return nil
 		}
-		qCtx.SetResponse(r)
-		return nil
 	}
-	return execFunc, nil
+	return errors.New("all upstreams are unhealthy or disabled")
+}
+
+// SetUpstreamEnabled implements coremain.UpstreamSwitcher.
+func (f *Forward) SetUpstreamEnabled(tag string, enabled bool) error {
+	u := f.tag2Upstream[tag]
+	if u == nil {
+		return fmt.Errorf("cannot find upstream by tag %s", tag)
+	}
+	if u.disabled.Swap(!enabled) != !enabled {
+		if enabled {
+			f.nDisabled.Add(-1)
+		} else {
+			f.nDisabled.Add(1)
+		}
+	}
+	f.logger.Info("upstream switched", zap.String("upstream", tag), zap.Bool("enabled", enabled))
+	return nil
+}
+
+type upstreamState struct {
+	Tag     string `json:"tag,omitempty"`
+	Addr    string `json:"addr"`
+	Enabled bool   `json:"enabled"`
+	Healthy bool   `json:"healthy"`
+}
+
+// State implements coremain.StateInspector.
+func (f *Forward) State() any {
+	s := make([]upstreamState, 0, len(f.us))
+	for _, u := range f.us {
+		s = append(s, upstreamState{
+			Tag:     u.cfg.Tag,
+			Addr:    u.cfg.Addr,
+			Enabled: !u.disabled.Load(),
+			Healthy: u.healthy(),
+		})
+	}
+	return map[string]any{"upstreams": s}
+}
+
+// UpstreamConfigs returns the configs of all upstreams, with global
+// options applied. They can be used by NewUpstream.
+func (f *Forward) UpstreamConfigs() []UpstreamConfig {
+	cs := make([]UpstreamConfig, 0, len(f.us))
+	for _, u := range f.us {
+		cs = append(cs, u.cfg)
+	}
+	return cs
+}
+
+// Upstreams returns the addresses of all upstreams.
+func (f *Forward) Upstreams() []string {
+	addrs := make([]string, 0, len(f.us))
+	for _, u := range f.us {
+		addrs = append(addrs, u.cfg.Addr)
+	}
+	return addrs
+}
+
+// enabledUpstreams returns enabled upstreams in us.
+func (f *Forward) enabledUpstreams(us []*upstreamWrapper) []*upstreamWrapper {
+	if f.nDisabled.Load() == 0 {
+		return us
+	}
+	enabled := make([]*upstreamWrapper, 0, len(us))
+	for _, u := range us {
+		if !u.disabled.Load() {
+			enabled = append(enabled, u)
+		}
+	}
+	return enabled
 }
 
 func (f *Forward) Close() error {
@@ -234,14 +361,51 @@ func (f *Forward) Close() error {
 	return nil
 }
 
-func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (*dns.Msg, error) {
+func (f *Forward) exec(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) error {
+	if f.recursionAllowed != nil && !f.recursionAllowed(qCtx.ServerMeta.ClientAddr) {
+		r := new(dns.Msg)
+		r.SetRcode(qCtx.Q(), dns.RcodeRefused)
+		qCtx.SetResponse(r)
+		return nil
+	}
+	r, raw, err := f.doExchange(ctx, qCtx, us)
+	if err != nil {
+		return err
+	}
+	if raw != nil {
+		qCtx.SetRawResponse(raw)
+	} else {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+// doExchange exchanges the query of qCtx with us. If qCtx has a query in
+// wire format (see query_context.Context.RawQuery), the query is sent as is
+// and the response is returned in wire format as raw.
+func (f *Forward) doExchange(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (r *dns.Msg, raw *[]byte, err error) {
+	us = f.enabledUpstreams(us)
+	if f.args.Merge {
+		return f.exchangeMerge(ctx, qCtx, us)
+	}
+	return f.exchange(ctx, qCtx, us)
+}
+
+func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (*dns.Msg, *[]byte, error) {
 	if len(us) == 0 {
-		return nil, errors.New("no upstream to exchange")
+		return nil, nil, errors.New("no upstream to exchange")
 	}
 
-	queryPayload, err := pool.PackBuffer(qCtx.Q())
+	rawQuery := qCtx.RawQuery()
+	var queryPayload *[]byte
+	var err error
+	if rawQuery != nil {
+		queryPayload, err = pool.CopyBuffer(rawQuery)
+	} else {
+		queryPayload, err = pool.PackBuffer(qCtx.Q())
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer pool.ReleaseBuf(queryPayload)
 
@@ -254,8 +418,10 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 	}
 
 	type res struct {
-		r   *dns.Msg
-		err error
+		r        *dns.Msg
+		raw      *[]byte
+		err      error
+		upstream string
 	}
 
 	resChan := make(chan res)
@@ -269,10 +435,13 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 		go func(uqid uint32, question dns.Question) {
 			defer pool.ReleaseBuf(qc)
 			// Give each upstream a fixed timeout to finish the query.
-			upstreamCtx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+			// It is also canceled with the query ctx, e.g. the client
+			// is gone, so abandoned exchanges don't hold upstream conns.
+			upstreamCtx, cancel := context.WithTimeout(ctx, queryTimeout)
 			defer cancel()
 
 			var r *dns.Msg
+			var raw *[]byte
 			respPayload, err := u.ExchangeContext(upstreamCtx, *qc)
 			if err != nil {
 				f.logger.Warn(
@@ -284,17 +453,24 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 					zap.String("upstream", u.name()),
 					zap.Error(err),
 				)
+			} else if rawQuery != nil {
+				if len(*respPayload) < dnsHeaderLen {
+					err = dns.ErrShortRead
+					pool.ReleaseBuf(respPayload)
+				} else {
+					raw = respPayload
+				}
 			} else {
-				r = new(dns.Msg)
-				err = r.Unpack(*respPayload)
+				r, err = pool.UnpackMsg(*respPayload)
 				pool.ReleaseBuf(respPayload)
-				if err != nil {
-					r = nil
-				}
 			}
 			select {
-			case resChan <- res{r: r, err: err}:
-			case <-done:
+			case resChan <- res{r: r, raw: raw, err: err, upstream: u.name()}:
+			case <-done: // Not chosen.
+				pool.ReleaseMsg(r)
+				if raw != nil {
+					pool.ReleaseBuf(raw)
+				}
 			}
 		}(qCtx.Id(), qCtx.QQuestion())
 	}
@@ -302,21 +478,33 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 	for i := 0; i < concurrent; i++ {
 		select {
 		case res := <-resChan:
-			r, err := res.r, res.err
+			r, raw, err := res.r, res.raw, res.err
 			if err != nil {
 				continue
 			}
 
+			var rcode int
+			if raw != nil {
+				rcode = rawRcode(*raw)
+			} else {
+				rcode = r.Rcode
+			}
+
 			// Retry until the last
-			if i < concurrent-1 && r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			if i < concurrent-1 && rcode != dns.RcodeSuccess && rcode != dns.RcodeNameError {
+				pool.ReleaseMsg(r)
+				if raw != nil {
+					pool.ReleaseBuf(raw)
+				}
 				continue
 			}
-			return r, nil
+			qCtx.StoreValue(query_context.KeyUpstream, res.upstream)
+			return r, raw, nil
 		case <-ctx.Done():
-			return nil, context.Cause(ctx)
+			return nil, nil, context.Cause(ctx)
 		}
 	}
-	return nil, errors.New("all upstream servers failed")
+	return nil, nil, errors.New("all upstream servers failed")
 }
 
 func quickSetup(bq sequence.BQ, s string) (any, error) {
@@ -325,5 +513,5 @@ func quickSetup(bq sequence.BQ, s string) (any, error) {
 	for _, u := range strings.Fields(s) {
 		args.Upstreams = append(args.Upstreams, UpstreamConfig{Addr: u})
 	}
-	return NewForward(args, Opts{Logger: bq.L()})
+	return NewForward(args, Opts{Logger: bq.L(), RecursionAllowed: bq.M().RecursionAllowed})
 }
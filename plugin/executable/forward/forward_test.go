@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/cache"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestForward_Ready(t *testing.T) {
+	u1, u2 := new(upstreamWrapper), new(upstreamWrapper)
+	f := &Forward{us: []*upstreamWrapper{u1, u2}}
+	if err := f.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	u1.consecutiveErrs.Store(maxConsecutiveErrs)
+	if err := f.Ready(); err != nil {
+		t.Fatal("forward should be ready if one upstream is healthy")
+	}
+	u2.consecutiveErrs.Store(maxConsecutiveErrs)
+	if err := f.Ready(); err == nil {
+		t.Fatal("forward should not be ready if all upstreams are unhealthy")
+	}
+}
+
+// blockingUpstream blocks until the exchange ctx is canceled.
+type blockingUpstream struct {
+	canceled chan struct{}
+}
+
+func (u *blockingUpstream) ExchangeContext(ctx context.Context, _ []byte) (*[]byte, error) {
+	<-ctx.Done()
+	close(u.canceled)
+	return nil, context.Cause(ctx)
+}
+
+func (u *blockingUpstream) Close() error {
+	return nil
+}
+
+func TestForward_exchange_cancel(t *testing.T) {
+	for _, merge := range []bool{false, true} {
+		f := &Forward{args: &Args{Merge: merge, Concurrent: 2}, logger: zap.NewNop()}
+		var us []*blockingUpstream
+		for i := 0; i < 2; i++ {
+			u := &blockingUpstream{canceled: make(chan struct{})}
+			uw := newWrapper(i, UpstreamConfig{}, "")
+			uw.u = u
+			us = append(us, u)
+			f.us = append(f.us, uw)
+		}
+
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		ctx, cancel := context.WithCancelCause(context.Background())
+		errAbandoned := errors.New("abandoned")
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			cancel(errAbandoned)
+		}()
+		_, _, err := f.doExchange(ctx, query_context.NewContext(q), f.us)
+		if !errors.Is(err, errAbandoned) {
+			t.Fatalf("merge %v: want err %v, got %v", merge, errAbandoned, err)
+		}
+		for _, u := range us {
+			select {
+			case <-u.canceled:
+			case <-time.After(time.Second):
+				t.Fatalf("merge %v: upstream exchange was not canceled", merge)
+			}
+		}
+	}
+}
+
+func TestForward_Exec_refused(t *testing.T) {
+	u := &blockingUpstream{canceled: make(chan struct{})}
+	uw := newWrapper(0, UpstreamConfig{}, "")
+	uw.u = u
+	f := &Forward{
+		args:             new(Args),
+		logger:           zap.NewNop(),
+		recursionAllowed: func(addr netip.Addr) bool { return addr == netip.MustParseAddr("127.0.0.1") },
+		us:               []*upstreamWrapper{uw},
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(q)
+	qCtx.ServerMeta.ClientAddr = netip.MustParseAddr("192.0.2.1")
+	if err := f.Exec(context.Background(), qCtx); err != nil {
+		t.Fatal(err)
+	}
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want REFUSED, got %v", r)
+	}
+}
+
+// answerUpstream answers A queries with 192.0.2.53.
+type answerUpstream struct{}
+
+func (u answerUpstream) ExchangeContext(_ context.Context, b []byte) (*[]byte, error) {
+	q := new(dns.Msg)
+	if err := q.Unpack(b); err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   netip.MustParseAddr("192.0.2.53").AsSlice(),
+	})
+	return pool.PackBuffer(r)
+}
+
+func (u answerUpstream) Close() error {
+	return nil
+}
+
+func TestForward_Exec_refused_cache(t *testing.T) {
+	allowed := func(addr netip.Addr) bool { return addr == netip.MustParseAddr("127.0.0.1") }
+	uw := newWrapper(0, UpstreamConfig{}, "")
+	uw.u = answerUpstream{}
+	f := &Forward{
+		args:             new(Args),
+		logger:           zap.NewNop(),
+		recursionAllowed: allowed,
+		us:               []*upstreamWrapper{uw},
+	}
+	c := cache.NewCache(&cache.Args{}, cache.Opts{RecursionAllowed: allowed})
+	defer c.Close()
+	next := sequence.NewChainWalker([]*sequence.ChainNode{{E: f}}, nil)
+
+	exec := func(client string) *dns.Msg {
+		t.Helper()
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		qCtx.ServerMeta.ClientAddr = netip.MustParseAddr(client)
+		if err := c.Exec(context.Background(), qCtx, next); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx.R()
+	}
+
+	// The allowed client fills the cache.
+	if r := exec("127.0.0.1"); r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("want an answer, got %v", r)
+	}
+	// Other clients are refused, even if the cache has the answer.
+	if r := exec("192.0.2.1"); r == nil || r.Rcode != dns.RcodeRefused {
+		t.Fatalf("want REFUSED, got %v", r)
+	}
+	// Cached answer is still valid for allowed clients.
+	if r := exec("127.0.0.1"); r == nil || r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("want an answer, got %v", r)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// exchangeMerge sends the query to all upstreams in us, waits for their
+// responses and merges A/AAAA answers of successful responses.
+// Queries of other types are exchanged normally.
+// Queries in wire format are never merged, see Forward.WireSafe.
+func (f *Forward) exchangeMerge(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (*dns.Msg, *[]byte, error) {
+	if len(us) == 0 {
+		return nil, nil, errors.New("no upstream to exchange")
+	}
+	qt := qCtx.QQuestion().Qtype
+	if len(us) == 1 || (qt != dns.TypeA && qt != dns.TypeAAAA) || qCtx.RawQuery() != nil {
+		return f.exchange(ctx, qCtx, us)
+	}
+
+	queryPayload, err := pool.PackBuffer(qCtx.Q())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pool.ReleaseBuf(queryPayload)
+
+	type res struct {
+		i int
+		r *dns.Msg
+	}
+	resChan := make(chan res, len(us))
+	for i, u := range us {
+		qc := copyPayload(queryPayload)
+		go func(uqid uint32, question dns.Question) {
+			defer pool.ReleaseBuf(qc)
+			upstreamCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+			defer cancel()
+
+			var r *dns.Msg
+			respPayload, err := u.ExchangeContext(upstreamCtx, *qc)
+			if err != nil {
+				f.logger.Warn(
+					"upstream error",
+					zap.Uint32("uqid", uqid),
+					zap.String("qname", question.Name),
+					zap.Uint16("qclass", question.Qclass),
+					zap.Uint16("qtype", question.Qtype),
+					zap.String("upstream", u.name()),
+					zap.Error(err),
+				)
+			} else {
+				r, _ = pool.UnpackMsg(*respPayload)
+				pool.ReleaseBuf(respPayload)
+			}
+			resChan <- res{i: i, r: r}
+		}(qCtx.Id(), qCtx.QQuestion())
+	}
+
+	rs := make([]*dns.Msg, len(us))
+	for range us {
+		select {
+		case res := <-resChan:
+			rs[res.i] = res.r
+		case <-ctx.Done():
+			return nil, nil, context.Cause(ctx)
+		}
+	}
+	r := mergeAddrResponses(rs, qt)
+	for _, e := range rs {
+		if e != r {
+			pool.ReleaseMsg(e)
+		}
+	}
+	if r != nil {
+		var names []string
+		for i, r := range rs {
+			if r != nil {
+				names = append(names, us[i].name())
+			}
+		}
+		qCtx.StoreValue(query_context.KeyUpstream, strings.Join(names, ","))
+		return r, nil, nil
+	}
+	return nil, nil, errors.New("all upstream servers failed")
+}
+
+// mergeAddrResponses merges address records of type qt from rs.
+// The first successful response (in slice order) that contains address
+// records is used as the base. Addresses from other successful responses
+// are appended to it with the owner name of the base's address records.
+// Duplicated addresses are removed and all address records share the
+// minimum ttl of the merged records.
+// If no response contains address records, the first non-nil response
+// with a success or NXDOMAIN rcode, or else the first non-nil response,
+// is returned. It returns nil if all responses are nil.
+func mergeAddrResponses(rs []*dns.Msg, qt uint16) *dns.Msg {
+	var base *dns.Msg
+	var owner string
+	for _, r := range rs {
+		if r == nil || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == qt {
+				base, owner = r, rr.Header().Name
+				break
+			}
+		}
+		if base != nil {
+			break
+		}
+	}
+	if base == nil {
+		var first *dns.Msg
+		for _, r := range rs {
+			if r == nil {
+				continue
+			}
+			if r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError {
+				return r
+			}
+			if first == nil {
+				first = r
+			}
+		}
+		return first
+	}
+
+	merged := base.Copy()
+	seen := make(map[netip.Addr]struct{})
+	var minTTL uint32
+	var addrRRs []dns.RR
+	addAddr := func(rr dns.RR) {
+		addr, ok := rrAddr(rr)
+		if !ok {
+			return
+		}
+		ttl := rr.Header().Ttl
+		if len(addrRRs) == 0 || ttl < minTTL {
+			minTTL = ttl
+		}
+		if _, dup := seen[addr]; dup {
+			return
+		}
+		seen[addr] = struct{}{}
+		rr = dns.Copy(rr)
+		rr.Header().Name = owner
+		addrRRs = append(addrRRs, rr)
+	}
+
+	answer := merged.Answer[:0]
+	for _, rr := range merged.Answer {
+		if rr.Header().Rrtype == qt {
+			addAddr(rr)
+			continue
+		}
+		answer = append(answer, rr)
+	}
+	for _, r := range rs {
+		if r == nil || r == base || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == qt {
+				addAddr(rr)
+			}
+		}
+	}
+	for _, rr := range addrRRs {
+		rr.Header().Ttl = minTTL
+	}
+	merged.Answer = append(answer, addrRRs...)
+	return merged
+}
+
+func rrAddr(rr dns.RR) (netip.Addr, bool) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		addr, ok := netip.AddrFromSlice(rr.A.To4())
+		return addr, ok
+	case *dns.AAAA:
+		addr, ok := netip.AddrFromSlice(rr.AAAA.To16())
+		return addr, ok
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func Test_mergeAddrResponses(t *testing.T) {
+	resp := func(rcode int, rrs ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		m.Response = true
+		m.Rcode = rcode
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+
+	rs := []*dns.Msg{
+		nil,
+		resp(dns.RcodeServerFailure),
+		resp(dns.RcodeSuccess, "example.com. 300 IN CNAME cdn.example.net.", "cdn.example.net. 300 IN A 192.0.2.1"),
+		resp(dns.RcodeSuccess, "example.com. 60 IN CNAME cdn2.example.net.", "cdn2.example.net. 60 IN A 192.0.2.2", "cdn2.example.net. 60 IN A 192.0.2.1"),
+	}
+	r := mergeAddrResponses(rs, dns.TypeA)
+	if r == nil {
+		t.Fatal("nil response")
+	}
+	if len(r.Answer) != 3 {
+		t.Fatalf("want 3 rrs, got %v", r.Answer)
+	}
+	if _, ok := r.Answer[0].(*dns.CNAME); !ok {
+		t.Fatalf("cname should be kept, got %v", r.Answer[0])
+	}
+	want := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}
+	for i, rr := range r.Answer[1:] {
+		a := rr.(*dns.A)
+		if !a.A.Equal(want[i]) || a.Hdr.Name != "cdn.example.net." || a.Hdr.Ttl != 60 {
+			t.Fatalf("unexpected rr #%d: %v", i, a)
+		}
+	}
+	if rs[2].Answer[1].Header().Ttl != 300 {
+		t.Fatal("base response should not be modified")
+	}
+
+	nx := resp(dns.RcodeNameError)
+	if r := mergeAddrResponses([]*dns.Msg{resp(dns.RcodeServerFailure), nx}, dns.TypeA); r != nx {
+		t.Fatalf("want nxdomain response, got %v", r)
+	}
+	if r := mergeAddrResponses([]*dns.Msg{nil, nil}, dns.TypeA); r != nil {
+		t.Fatalf("want nil, got %v", r)
+	}
+}
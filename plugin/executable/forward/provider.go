@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// resolverProvider is a managed resolver service. Its endpoints are
+// derived from the profile id and the device name of the user.
+type resolverProvider struct {
+	// dohAddr returns the doh url. device may be empty. It is the
+	// original device name.
+	dohAddr func(id, device string) string
+	// tlsHost returns the server name of dot and doq. device may be
+	// empty. It has been encoded by encodeDevice.
+	tlsHost func(id, device string) string
+	// encodeDevice encodes the device name into a dns label. Nil if the
+	// provider does not support device names.
+	encodeDevice func(device string) string
+	// ipv4 and ipv6 are the anycast addresses of the service. They are
+	// used as the dial address, so no bootstrap is needed.
+	ipv4, ipv6 string
+}
+
+var resolverProviders = map[string]resolverProvider{
+	// https://help.nextdns.io
+	"nextdns": {
+		dohAddr: func(id, device string) string {
+			if len(device) > 0 {
+				return "https://dns.nextdns.io/" + id + "/" + url.PathEscape(device)
+			}
+			return "https://dns.nextdns.io/" + id
+		},
+		tlsHost: func(id, device string) string {
+			if len(device) > 0 {
+				return device + "-" + id + ".dns.nextdns.io"
+			}
+			return id + ".dns.nextdns.io"
+		},
+		// Spaces are encoded as "--".
+		encodeDevice: func(device string) string { return strings.ReplaceAll(device, " ", "--") },
+		ipv4:         "45.90.28.0",
+		ipv6:         "2a07:a8c0::",
+	},
+	// https://docs.controld.com
+	"controld": {
+		dohAddr: func(id, device string) string {
+			if len(device) > 0 {
+				return "https://dns.controld.com/" + id + "/" + controldClientID(device)
+			}
+			return "https://dns.controld.com/" + id
+		},
+		tlsHost: func(id, device string) string {
+			if len(device) > 0 {
+				return id + "-" + device + ".dns.controld.com"
+			}
+			return id + ".dns.controld.com"
+		},
+		encodeDevice: controldClientID,
+		ipv4:         "76.76.2.22",
+		ipv6:         "2606:1a40::22",
+	},
+	// https://adguard-dns.io/kb/private-dns/connect-devices/
+	// The profile id is the device id. There is no device name.
+	"adguard": {
+		dohAddr: func(id, _ string) string { return "https://d.adguard-dns.com/dns-query/" + id },
+		tlsHost: func(id, _ string) string { return id + ".d.adguard-dns.com" },
+		ipv4:    "94.140.14.49",
+		ipv6:    "2a10:50c0::ad1:ff",
+	},
+}
+
+// controldClientID encodes device as a client id of ControlD, which is
+// in lower case with spaces encoded as "-".
+func controldClientID(device string) string {
+	return strings.ToLower(strings.ReplaceAll(device, " ", "-"))
+}
+
+var (
+	validProfileID = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	validLabel     = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// applyProvider derives the addr and the dial addr of c from its
+// provider. It does nothing if c has no provider.
+func applyProvider(c *UpstreamConfig) error {
+	if len(c.Provider) == 0 {
+		return nil
+	}
+	p, ok := resolverProviders[c.Provider]
+	if !ok {
+		return fmt.Errorf("unknown provider %s", c.Provider)
+	}
+	if len(c.Addr) > 0 {
+		return errors.New("addr cannot be set with provider")
+	}
+	if !validProfileID.MatchString(c.ProfileID) {
+		return fmt.Errorf("invalid profile id [%s]", c.ProfileID)
+	}
+
+	var label string // encoded device name
+	if len(c.DeviceName) > 0 {
+		if p.encodeDevice == nil {
+			return fmt.Errorf("provider %s does not support device names", c.Provider)
+		}
+		label = p.encodeDevice(c.DeviceName)
+		if !validLabel.MatchString(label) {
+			return fmt.Errorf("invalid device name [%s]", c.DeviceName)
+		}
+	}
+
+	switch c.Protocol {
+	case "", "doh":
+		c.Addr = p.dohAddr(c.ProfileID, c.DeviceName)
+	case "dot":
+		c.Addr = "tls://" + p.tlsHost(c.ProfileID, label)
+	case "doq":
+		c.Addr = "quic://" + p.tlsHost(c.ProfileID, label)
+	default:
+		return fmt.Errorf("invalid protocol %s", c.Protocol)
+	}
+
+	if len(c.DialAddr) == 0 && len(c.Bootstrap) == 0 {
+		c.DialAddr = p.ipv4
+		if c.BootstrapVer == 6 {
+			c.DialAddr = p.ipv6
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import "testing"
+
+func Test_applyProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		c            UpstreamConfig
+		wantAddr     string
+		wantDialAddr string
+		wantErr      bool
+	}{
+		{
+			name:         "nextdns doh",
+			c:            UpstreamConfig{Provider: "nextdns", ProfileID: "abc123", DeviceName: "My iPhone"},
+			wantAddr:     "https://dns.nextdns.io/abc123/My%20iPhone",
+			wantDialAddr: "45.90.28.0",
+		},
+		{
+			name:         "nextdns dot",
+			c:            UpstreamConfig{Provider: "nextdns", ProfileID: "abc123", DeviceName: "My iPhone", Protocol: "dot", BootstrapVer: 6},
+			wantAddr:     "tls://My--iPhone-abc123.dns.nextdns.io",
+			wantDialAddr: "2a07:a8c0::",
+		},
+		{
+			name:         "controld doq",
+			c:            UpstreamConfig{Provider: "controld", ProfileID: "abcd1234", DeviceName: "Living Room", Protocol: "doq"},
+			wantAddr:     "quic://abcd1234-living-room.dns.controld.com",
+			wantDialAddr: "76.76.2.22",
+		},
+		{
+			name:         "controld doh",
+			c:            UpstreamConfig{Provider: "controld", ProfileID: "abcd1234", DeviceName: "Living Room"},
+			wantAddr:     "https://dns.controld.com/abcd1234/living-room",
+			wantDialAddr: "76.76.2.22",
+		},
+		{
+			name:     "adguard with bootstrap",
+			c:        UpstreamConfig{Provider: "adguard", ProfileID: "1a2b3c4d", Protocol: "dot", Bootstrap: "1.1.1.1"},
+			wantAddr: "tls://1a2b3c4d.d.adguard-dns.com",
+		},
+		{name: "no provider", c: UpstreamConfig{Addr: "1.1.1.1"}, wantAddr: "1.1.1.1"},
+		{name: "adguard device", c: UpstreamConfig{Provider: "adguard", ProfileID: "1a2b3c4d", DeviceName: "a"}, wantErr: true},
+		{name: "invalid device", c: UpstreamConfig{Provider: "nextdns", ProfileID: "abc123", DeviceName: "a.b", Protocol: "dot"}, wantErr: true},
+		{name: "invalid profile", c: UpstreamConfig{Provider: "nextdns", ProfileID: "abc/123"}, wantErr: true},
+		{name: "with addr", c: UpstreamConfig{Provider: "nextdns", ProfileID: "abc123", Addr: "1.1.1.1"}, wantErr: true},
+		{name: "unknown", c: UpstreamConfig{Provider: "unknown", ProfileID: "abc123"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.c
+			err := applyProvider(&c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.Addr != tt.wantAddr || c.DialAddr != tt.wantDialAddr {
+				t.Fatalf("want %s %s, got %s %s", tt.wantAddr, tt.wantDialAddr, c.Addr, c.DialAddr)
+			}
+		})
+	}
+}
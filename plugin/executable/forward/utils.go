@@ -21,12 +21,15 @@ package fastforward
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/tracing"
 	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -41,6 +44,23 @@ type upstreamWrapper struct {
 
 	connOpened prometheus.Counter
 	connClosed prometheus.Counter
+	fallbacks  prometheus.Counter
+
+	// consecutiveErrs is the number of errors since the last successful
+	// exchange.
+	consecutiveErrs atomic.Int32
+	// disabled upstreams will not be used. See Forward.SetUpstreamEnabled.
+	disabled atomic.Bool
+}
+
+// maxConsecutiveErrs is the number of consecutive errors after which an
+// upstream is considered unhealthy.
+const maxConsecutiveErrs = 3
+
+// healthy reports whether the upstream has less than maxConsecutiveErrs
+// consecutive errors.
+func (uw *upstreamWrapper) healthy() bool {
+	return uw.consecutiveErrs.Load() < maxConsecutiveErrs
 }
 
 func (uw *upstreamWrapper) OnEvent(typ upstream.Event) {
@@ -49,6 +69,8 @@ func (uw *upstreamWrapper) OnEvent(typ upstream.Event) {
 		uw.connOpened.Inc()
 	case upstream.EventConnClose:
 		uw.connClosed.Inc()
+	case upstream.EventFallback:
+		uw.fallbacks.Inc()
 	}
 }
 
@@ -90,6 +112,11 @@ func newWrapper(idx int, cfg UpstreamConfig, pluginTag string) *upstreamWrapper
 			Help:        "The total number of connections that are closed",
 			ConstLabels: lb,
 		}),
+		fallbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "fallback_total",
+			Help:        "The total number of queries that were retried by a fallback protocol",
+			ConstLabels: lb,
+		}),
 	}
 }
 
@@ -101,6 +128,7 @@ func (uw *upstreamWrapper) registerMetricsTo(r prometheus.Registerer) error {
 		uw.responseLatency,
 		uw.connOpened,
 		uw.connClosed,
+		uw.fallbacks,
 	} {
 		if err := r.Register(collector); err != nil {
 			return err
@@ -118,18 +146,25 @@ func (uw *upstreamWrapper) name() string {
 	return uw.cfg.Addr
 }
 
-func (uw *upstreamWrapper) ExchangeContext(ctx context.Context, m []byte) (*[]byte, error) {
+func (uw *upstreamWrapper) ExchangeContext(ctx context.Context, m []byte) (r *[]byte, err error) {
+	ctx, span := tracing.Start(ctx, "upstream.exchange", func() []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("upstream", uw.name())}
+	})
+	defer func() { tracing.End(span, err) }()
+
 	uw.queryTotal.Inc()
 
 	start := time.Now()
 	uw.thread.Inc()
-	r, err := uw.u.ExchangeContext(ctx, m)
+	r, err = uw.u.ExchangeContext(ctx, m)
 	uw.thread.Dec()
 
 	if err != nil {
 		uw.errTotal.Inc()
+		uw.consecutiveErrs.Add(1)
 	} else {
 		uw.responseLatency.Observe(float64(time.Since(start).Milliseconds()))
+		uw.consecutiveErrs.Store(0)
 	}
 	return r, err
 }
@@ -157,3 +192,11 @@ func copyPayload(b *[]byte) *[]byte {
 	copy(*bc, *b)
 	return bc
 }
+
+const dnsHeaderLen = 12
+
+// rawRcode returns the rcode of msg b in wire format. len(b) must be
+// at least dnsHeaderLen.
+func rawRcode(b []byte) int {
+	return int(b[3] & 0xf)
+}
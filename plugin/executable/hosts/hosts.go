@@ -24,6 +24,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnssec"
 	"github.com/IrineSistiana/mosdns/v5/pkg/hosts"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
@@ -43,10 +44,14 @@ var _ sequence.Executable = (*Hosts)(nil)
 type Args struct {
 	Entries []string `yaml:"entries"`
 	Files   []string `yaml:"files"`
+
+	// DNSSEC signs responses of the zone online. Optional.
+	DNSSEC dnssec.Args `yaml:"dnssec"`
 }
 
 type Hosts struct {
-	h *hosts.Hosts
+	h      *hosts.Hosts
+	signer *dnssec.Signer // maybe nil
 }
 
 func Init(_ *coremain.BP, args any) (any, error) {
@@ -71,8 +76,14 @@ func NewHosts(args *Args) (*Hosts, error) {
 		}
 	}
 
+	signer, err := dnssec.NewSignerFromArgs(args.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Hosts{
-		h: hosts.NewHosts(m),
+		h:      hosts.NewHosts(m),
+		signer: signer,
 	}, nil
 }
 
@@ -81,9 +92,33 @@ func (h *Hosts) Response(q *dns.Msg) *dns.Msg {
 }
 
 func (h *Hosts) Exec(_ context.Context, qCtx *query_context.Context) error {
-	r := h.h.LookupMsg(qCtx.Q())
+	q := qCtx.Q()
+	r := h.h.LookupMsg(q)
+	if h.signer != nil {
+		if r == nil {
+			r = h.signer.Reply(q)
+		}
+		if opt := qCtx.ClientOpt(); r != nil && opt != nil && opt.Do() {
+			if err := h.signer.Sign(r, h.types(qCtx.QQuestion().Name)); err != nil {
+				return fmt.Errorf("failed to sign response, %w", err)
+			}
+		}
+	}
 	if r != nil {
 		qCtx.SetResponse(r)
 	}
 	return nil
 }
+
+// types returns types that exist at name.
+func (h *Hosts) types(name string) []uint16 {
+	var types []uint16
+	ipv4, ipv6 := h.h.Lookup(name)
+	if len(ipv4) > 0 {
+		types = append(types, dns.TypeA)
+	}
+	if len(ipv6) > 0 {
+		types = append(types, dns.TypeAAAA)
+	}
+	return types
+}
@@ -21,6 +21,7 @@ package ipset
 
 import (
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"strconv"
 	"strings"
@@ -29,6 +30,7 @@ import (
 const PluginType = "ipset"
 
 func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -37,10 +39,22 @@ type Args struct {
 	SetName6 string `yaml:"set_name6"`
 	Mask4    int    `yaml:"mask4"` // default 24
 	Mask6    int    `yaml:"mask6"` // default 32
+
+	// TTLTimeout derives the timeout of new entries from the records' TTL.
+	// The set must be created with the timeout option.
+	TTLTimeout bool `yaml:"ttl_timeout"`
+	// MinTimeout and MaxTimeout (in seconds) clamp the derived timeout.
+	// Default MinTimeout is 60. Default MaxTimeout (0) is no limit.
+	MinTimeout int `yaml:"min_timeout"`
+	MaxTimeout int `yaml:"max_timeout"`
 }
 
 var _ sequence.Executable = (*ipSetPlugin)(nil)
 
+func Init(_ *coremain.BP, args any) (any, error) {
+	return newIpSetPlugin(args.(*Args))
+}
+
 // QuickSetup format: [set_name,{inet|inet6},mask] *2
 // e.g. "my_set,inet,24 my_set6,inet6,48"
 func QuickSetup(_ sequence.BQ, s string) (any, error) {
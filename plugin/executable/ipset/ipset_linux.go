@@ -23,8 +23,11 @@ package ipset
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/miekg/dns"
 	"github.com/nadoo/ipset"
 	"net/netip"
@@ -42,6 +45,10 @@ func newIpSetPlugin(args *Args) (*ipSetPlugin, error) {
 	if args.Mask6 == 0 {
 		args.Mask6 = 32
 	}
+	utils.SetDefaultUnsignNum(&args.MinTimeout, 60)
+	if args.MaxTimeout < 0 {
+		return nil, errors.New("invalid negative max_timeout")
+	}
 
 	nl, err := ipset.Init()
 	if err != nil {
@@ -68,6 +75,15 @@ func (p *ipSetPlugin) Close() error {
 	return p.nl.Close()
 }
 
+// opts returns the options for adding the ip from rr.
+func (p *ipSetPlugin) opts(rr dns.RR) []ipset.Option {
+	if !p.args.TTLTimeout {
+		return nil
+	}
+	timeout := dnsutils.ClampTTL(rr.Header().Ttl, uint32(p.args.MinTimeout), uint32(p.args.MaxTimeout))
+	return []ipset.Option{ipset.OptTimeout(timeout)}
+}
+
 func (p *ipSetPlugin) addIPSet(r *dns.Msg) error {
 	for i := range r.Answer {
 		switch rr := r.Answer[i].(type) {
@@ -79,7 +95,7 @@ func (p *ipSetPlugin) addIPSet(r *dns.Msg) error {
 			if !ok {
 				return fmt.Errorf("invalid A record with ip: %s", rr.A)
 			}
-			if err := ipset.AddPrefix(p.nl, p.args.SetName4, netip.PrefixFrom(addr, p.args.Mask4)); err != nil {
+			if err := ipset.AddPrefix(p.nl, p.args.SetName4, netip.PrefixFrom(addr, p.args.Mask4), p.opts(rr)...); err != nil {
 				return err
 			}
 
@@ -91,7 +107,7 @@ func (p *ipSetPlugin) addIPSet(r *dns.Msg) error {
 			if !ok {
 				return fmt.Errorf("invalid AAAA record with ip: %s", rr.AAAA)
 			}
-			if err := ipset.AddPrefix(p.nl, p.args.SetName6, netip.PrefixFrom(addr, p.args.Mask6)); err != nil {
+			if err := ipset.AddPrefix(p.nl, p.args.SetName6, netip.PrefixFrom(addr, p.args.Mask6), p.opts(rr)...); err != nil {
 				return err
 			}
 		default:
@@ -0,0 +1,270 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kv_records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/skydns"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "kv_records"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Backend can be "etcd" or "consul".
+	Backend string `yaml:"backend"`
+	// Addr is the http endpoint of the backend,
+	// e.g. "http://127.0.0.1:2379" or "http://127.0.0.1:8500".
+	Addr string `yaml:"addr"`
+	// Prefix of SkyDNS-style keys. Default is "/skydns".
+	Prefix string `yaml:"prefix"`
+	// Token is sent as the "Authorization" header (etcd) or
+	// the "X-Consul-Token" header (consul). Optional.
+	Token string `yaml:"token"`
+	// TTL of records that have no ttl. Default is 300.
+	TTL int `yaml:"ttl"`
+	// Interval in seconds to poll etcd for changes. Default is 5.
+	// Consul uses blocking queries and does not poll.
+	Interval int `yaml:"interval"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Prefix, "/skydns")
+	utils.SetDefaultUnsignNum(&a.TTL, 300)
+	utils.SetDefaultUnsignNum(&a.Interval, 5)
+}
+
+var _ sequence.Executable = (*KVRecords)(nil)
+
+// KVRecords serves records stored in etcd or consul.
+type KVRecords struct {
+	args   *Args
+	logger *zap.Logger
+	src    source
+
+	table    atomic.Pointer[skydns.Table]
+	ctx      context.Context
+	cancel   context.CancelFunc
+	loopDone chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewKVRecords(args.(*Args), bp.L())
+}
+
+func NewKVRecords(args *Args, logger *zap.Logger) (*KVRecords, error) {
+	args.init()
+	if len(args.Addr) == 0 {
+		return nil, errors.New("missing backend addr")
+	}
+
+	var src source
+	switch args.Backend {
+	case "etcd":
+		src = &etcdSource{
+			client:   &http.Client{Timeout: time.Second * 10},
+			addr:     args.Addr,
+			prefix:   args.Prefix,
+			token:    args.Token,
+			interval: time.Duration(args.Interval) * time.Second,
+		}
+	case "consul":
+		const wait = time.Minute * 5
+		src = &consulSource{
+			client: &http.Client{Timeout: wait + time.Second*30},
+			addr:   args.Addr,
+			prefix: args.Prefix,
+			token:  args.Token,
+			wait:   wait,
+		}
+	default:
+		return nil, fmt.Errorf("invalid backend [%s]", args.Backend)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &KVRecords{
+		args:     args,
+		logger:   logger,
+		src:      src,
+		ctx:      ctx,
+		cancel:   cancel,
+		loopDone: make(chan struct{}),
+	}
+	p.table.Store(skydns.NewTable(nil))
+
+	// Fetch records once so that they are available on startup.
+	// Errors are not fatal. The backend may be unavailable for now.
+	kvs, index, err := src.fetch(ctx, 0)
+	if err != nil {
+		logger.Warn("failed to fetch records", zap.Error(err))
+		index = 0
+	} else {
+		p.update(kvs)
+	}
+	go p.watch(index)
+	return p, nil
+}
+
+func (p *KVRecords) watch(lastIndex uint64) {
+	defer close(p.loopDone)
+	retryDelay := time.Duration(p.args.Interval) * time.Second
+	for {
+		kvs, index, err := p.src.fetch(p.ctx, lastIndex)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			p.logger.Warn("failed to fetch records", zap.Error(err))
+			select {
+			case <-time.After(retryDelay):
+			case <-p.ctx.Done():
+				return
+			}
+			continue
+		}
+		if index == lastIndex && lastIndex != 0 {
+			continue
+		}
+		p.update(kvs)
+		lastIndex = index
+	}
+}
+
+func (p *KVRecords) update(kvs []kv) {
+	records := make([]*skydns.Record, 0, len(kvs))
+	for _, e := range kvs {
+		r, err := skydns.ParseRecord(p.args.Prefix, e.key, e.value)
+		if err != nil {
+			p.logger.Warn("invalid record", zap.Error(err))
+			continue
+		}
+		records = append(records, r)
+	}
+	p.table.Store(skydns.NewTable(records))
+	p.logger.Info("records updated", zap.Int("length", len(records)))
+}
+
+func (p *KVRecords) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if r := p.response(qCtx.Q()); r != nil {
+		qCtx.SetResponse(r)
+	}
+	return nil
+}
+
+func (p *KVRecords) response(q *dns.Msg) *dns.Msg {
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET {
+		return nil
+	}
+	records, ok := p.table.Load().Lookup(question.Name)
+	if !ok {
+		return nil
+	}
+
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+	hdr := func(rr *skydns.Record, name string, t uint16) dns.RR_Header {
+		ttl := rr.TTL
+		if ttl == 0 {
+			ttl = uint32(p.args.TTL)
+		}
+		return dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	for _, rr := range records {
+		addr, isAddr := rr.Addr()
+		switch question.Qtype {
+		case dns.TypeA:
+			if isAddr && addr.Is4() {
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr(rr, question.Name, dns.TypeA), A: addr.AsSlice()})
+			}
+		case dns.TypeAAAA:
+			if isAddr && addr.Is6() {
+				r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr(rr, question.Name, dns.TypeAAAA), AAAA: addr.AsSlice()})
+			}
+		case dns.TypeSRV:
+			if len(rr.Host) == 0 {
+				continue
+			}
+			target := dns.Fqdn(rr.Host)
+			if isAddr { // use the key as target and add a glue record
+				target = rr.Key
+				if addr.Is4() {
+					r.Extra = append(r.Extra, &dns.A{Hdr: hdr(rr, target, dns.TypeA), A: addr.AsSlice()})
+				} else {
+					r.Extra = append(r.Extra, &dns.AAAA{Hdr: hdr(rr, target, dns.TypeAAAA), AAAA: addr.AsSlice()})
+				}
+			}
+			r.Answer = append(r.Answer, &dns.SRV{
+				Hdr:      hdr(rr, question.Name, dns.TypeSRV),
+				Priority: rr.Priority,
+				Weight:   rr.Weight,
+				Port:     rr.Port,
+				Target:   target,
+			})
+		case dns.TypeMX:
+			if rr.Mail && len(rr.Host) > 0 && !isAddr {
+				r.Answer = append(r.Answer, &dns.MX{Hdr: hdr(rr, question.Name, dns.TypeMX), Preference: rr.Priority, Mx: dns.Fqdn(rr.Host)})
+			}
+		case dns.TypeTXT:
+			if len(rr.Text) > 0 {
+				r.Answer = append(r.Answer, &dns.TXT{Hdr: hdr(rr, question.Name, dns.TypeTXT), Txt: []string{rr.Text}})
+			}
+		}
+	}
+
+	// A single record with a domain name host at the exact name is a CNAME.
+	if len(r.Answer) == 0 && len(records) == 1 && strings.EqualFold(records[0].Key, question.Name) && len(records[0].Host) > 0 {
+		if _, isAddr := records[0].Addr(); !isAddr && question.Qtype != dns.TypeSRV && question.Qtype != dns.TypeMX {
+			r.Answer = append(r.Answer, &dns.CNAME{Hdr: hdr(records[0], question.Name, dns.TypeCNAME), Target: dns.Fqdn(records[0].Host)})
+		}
+	}
+	if len(r.Answer) == 0 {
+		r.Ns = []dns.RR{dnsutils.FakeSOA(question.Name)}
+	}
+	return r
+}
+
+func (p *KVRecords) Close() error {
+	p.cancel()
+	<-p.loopDone
+	return nil
+}
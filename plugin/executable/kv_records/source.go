@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kv_records
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type kv struct {
+	key   string
+	value []byte
+}
+
+// source fetches all key-values under a prefix from a kv store.
+type source interface {
+	// fetch returns kvs and the index of the store. If lastIndex is not 0,
+	// fetch may block until the store changes or a timeout occurs.
+	fetch(ctx context.Context, lastIndex uint64) (kvs []kv, index uint64, err error)
+}
+
+// consulSource uses consul's blocking queries to watch changes.
+type consulSource struct {
+	client *http.Client
+	addr   string
+	prefix string
+	token  string
+	wait   time.Duration
+}
+
+type consulKV struct {
+	Key   string
+	Value []byte // base64 decoded by encoding/json
+}
+
+func (s *consulSource) fetch(ctx context.Context, lastIndex uint64) ([]kv, uint64, error) {
+	q := url.Values{}
+	q.Set("recurse", "true")
+	if lastIndex > 0 {
+		q.Set("index", strconv.FormatUint(lastIndex, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(s.wait.Seconds())))
+	}
+	u := strings.TrimSuffix(s.addr, "/") + "/v1/kv/" + strings.Trim(s.prefix, "/") + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(s.token) > 0 {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound: // prefix has no key
+		return nil, index, nil
+	default:
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var ckvs []consulKV
+	if err := json.NewDecoder(resp.Body).Decode(&ckvs); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response, %w", err)
+	}
+	kvs := make([]kv, 0, len(ckvs))
+	for _, e := range ckvs {
+		if len(e.Value) == 0 { // folder
+			continue
+		}
+		kvs = append(kvs, kv{key: e.Key, value: e.Value})
+	}
+	return kvs, index, nil
+}
+
+// etcdSource uses etcd v3's json gateway. It polls the store every
+// interval and uses the revision to detect changes.
+type etcdSource struct {
+	client   *http.Client
+	addr     string
+	prefix   string
+	token    string
+	interval time.Duration
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+func (s *etcdSource) fetch(ctx context.Context, lastIndex uint64) ([]kv, uint64, error) {
+	if lastIndex > 0 {
+		t := time.NewTimer(s.interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(s.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd([]byte(s.prefix))),
+	})
+	u := strings.TrimSuffix(s.addr, "/") + "/v3/kv/range"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.token) > 0 {
+		req.Header.Set("Authorization", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("unexpected status %s, %s", resp.Status, b)
+	}
+
+	var r etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response, %w", err)
+	}
+	index, err := strconv.ParseUint(r.Header.Revision, 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("invalid revision in response")
+	}
+	kvs := make([]kv, 0, len(r.Kvs))
+	for _, e := range r.Kvs {
+		kvs = append(kvs, kv{key: string(e.Key), value: e.Value})
+	}
+	return kvs, index, nil
+}
+
+// prefixEnd returns the range end to get all keys with prefix p.
+func prefixEnd(p []byte) []byte {
+	end := bytes.Clone(p)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // all keys
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package kv_records
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSources(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/skydns", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("recurse") != "true" || r.Header.Get("X-Consul-Token") != "token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "10")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"Key": "skydns/local/", "Value": nil},
+			{"Key": "skydns/local/a", "Value": []byte(`{"host":"192.0.2.1"}`)},
+		})
+	})
+	mux.HandleFunc("/v3/kv/range", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["key"] != base64.StdEncoding.EncodeToString([]byte("/skydns")) ||
+			req["range_end"] != base64.StdEncoding.EncodeToString([]byte("/skydnt")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"header": map[string]any{"revision": "7"},
+			"kvs": []map[string]any{
+				{"key": []byte("/skydns/local/a"), "value": []byte(`{"host":"192.0.2.1"}`)},
+			},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sources := map[string]source{
+		"consul": &consulSource{client: srv.Client(), addr: srv.URL, prefix: "/skydns/", token: "token", wait: time.Second},
+		"etcd":   &etcdSource{client: srv.Client(), addr: srv.URL, prefix: "/skydns", interval: time.Millisecond},
+	}
+	wantIndex := map[string]uint64{"consul": 10, "etcd": 7}
+	for name, src := range sources {
+		kvs, index, err := src.fetch(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if index != wantIndex[name] {
+			t.Fatalf("%s: want index %d, got %d", name, wantIndex[name], index)
+		}
+		if len(kvs) != 1 || string(kvs[0].value) != `{"host":"192.0.2.1"}` {
+			t.Fatalf("%s: unexpected kvs %v", name, kvs)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package llmnr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "llmnr"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+var (
+	llmnrGroup       = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 252), Port: 5355}
+	netbiosBroadcast = &net.UDPAddr{IP: net.IPv4bcast, Port: 137}
+)
+
+type Args struct {
+	// Suffixes are domain suffixes of the lan, e.g. "lan" or "home.arpa".
+	// With suffix "lan", "nas.lan" is resolved as the single-label name
+	// "nas". Single-label names are always resolved.
+	Suffixes []string `yaml:"suffixes"`
+	// NetBIOS sends a NetBIOS name query (RFC 1002) if LLMNR got no
+	// answer. Only A queries can be resolved by NetBIOS.
+	NetBIOS bool `yaml:"netbios"`
+	// Timeout in milliseconds to wait for replies of each protocol.
+	// Default is 1000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Timeout, 1000)
+}
+
+var _ sequence.Executable = (*LLMNR)(nil)
+
+// LLMNR resolves single-label names (e.g. Windows hostnames) by sending
+// LLMNR (RFC 4795) queries, and optionally NetBIOS name queries, on the
+// lan. It only works if the query was not resolved yet, so it should
+// be placed after the forward, e.g. "exec $forward" then "exec $llmnr".
+type LLMNR struct {
+	suffixes []string // fqdn, lower case.
+	netbios  bool
+	timeout  time.Duration
+	logger   *zap.Logger
+
+	// Destinations of the queries. They are only changed by tests.
+	llmnrAddr   *net.UDPAddr
+	netbiosAddr *net.UDPAddr
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewLLMNR(args.(*Args), bp.L())
+}
+
+// QuickSetup format: [suffix]...
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	return NewLLMNR(&Args{Suffixes: strings.Fields(s)}, bq.L())
+}
+
+func NewLLMNR(args *Args, logger *zap.Logger) (*LLMNR, error) {
+	args.init()
+	p := &LLMNR{
+		netbios:     args.NetBIOS,
+		timeout:     time.Duration(args.Timeout) * time.Millisecond,
+		logger:      logger,
+		llmnrAddr:   llmnrGroup,
+		netbiosAddr: netbiosBroadcast,
+	}
+	for _, s := range args.Suffixes {
+		s = strings.ToLower(dns.Fqdn(s))
+		if _, ok := dns.IsDomainName(s); !ok || s == "." {
+			return nil, fmt.Errorf("invalid suffix %s", s)
+		}
+		p.suffixes = append(p.suffixes, s)
+	}
+	return p, nil
+}
+
+func (p *LLMNR) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	if (question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA) || question.Qclass != dns.ClassINET {
+		return nil
+	}
+	if r := qCtx.R(); r != nil && r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0 {
+		return nil // resolved by normal dns
+	}
+	label, ok := p.toLabel(question.Name)
+	if !ok {
+		return nil
+	}
+
+	rrs, err := p.resolve(ctx, label, question.Qtype)
+	if err != nil {
+		p.logger.Debug("llmnr/netbios query failed", qCtx.InfoField(), zap.Error(err))
+		return nil
+	}
+	if len(rrs) == 0 {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	for _, rr := range rrs {
+		rr.Header().Name = question.Name
+		resp.Answer = append(resp.Answer, rr)
+	}
+	qCtx.SetResponse(resp)
+	return nil
+}
+
+// toLabel returns the single label name that should be resolved.
+// ok is false if fqdn is not a single label name, or a single label
+// under any of the suffixes.
+func (p *LLMNR) toLabel(fqdn string) (string, bool) {
+	lower := strings.ToLower(fqdn)
+	if dns.CountLabel(lower) == 1 {
+		return strings.TrimSuffix(lower, "."), true
+	}
+	for _, s := range p.suffixes {
+		if len(lower) > len(s) && strings.HasSuffix(lower, s) && lower[len(lower)-len(s)-1] == '.' {
+			label := lower[:len(lower)-len(s)-1]
+			if !strings.Contains(label, ".") {
+				return label, true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolve resolves label by LLMNR, then by NetBIOS if it is enabled.
+func (p *LLMNR) resolve(ctx context.Context, label string, qtype uint16) ([]dns.RR, error) {
+	rrs, err := p.exchangeLLMNR(ctx, label, qtype)
+	if len(rrs) > 0 || !p.netbios || qtype != dns.TypeA || len(label) > netbiosNameLen {
+		return rrs, err
+	}
+	return p.exchangeNetBIOS(ctx, label)
+}
+
+// exchangeLLMNR sends a LLMNR query and waits for the first reply that
+// has answers. It returns nil if no reply was received before timeout.
+func (p *LLMNR) exchangeLLMNR(ctx context.Context, label string, qtype uint16) ([]dns.RR, error) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	name := label + "."
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.RecursionDesired = false
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.WriteTo(b, p.llmnrAddr); err != nil {
+		return nil, err
+	}
+	p.setReadDeadline(ctx, c)
+
+	rb := pool.GetBuf(dns.MaxMsgSize)
+	defer pool.ReleaseBuf(rb)
+	for {
+		n, _, err := c.ReadFrom(*rb)
+		if err != nil {
+			return nil, ignoreTimeout(err)
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack((*rb)[:n]); err != nil {
+			continue
+		}
+		if r.Id != q.Id || !r.Response || r.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		var rrs []dns.RR
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+				rrs = append(rrs, rr)
+			}
+		}
+		if len(rrs) > 0 {
+			return rrs, nil
+		}
+	}
+}
+
+func (p *LLMNR) setReadDeadline(ctx context.Context, c net.Conn) {
+	deadline := time.Now().Add(p.timeout)
+	if ddl, ok := ctx.Deadline(); ok && ddl.Before(deadline) {
+		deadline = ddl
+	}
+	_ = c.SetReadDeadline(deadline)
+}
+
+// ignoreTimeout returns nil if err is a timeout error.
+func ignoreTimeout(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return err
+}
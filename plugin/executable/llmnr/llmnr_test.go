@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package llmnr
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestLLMNR_toLabel(t *testing.T) {
+	p, err := NewLLMNR(&Args{Suffixes: []string{"lan", "home.arpa"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fqdn   string
+		want   string
+		wantOk bool
+	}{
+		{"NAS.", "nas", true},
+		{"nas.lan.", "nas", true},
+		{"nas.home.arpa.", "nas", true},
+		{"a.nas.lan.", "", false},
+		{"lan.", "lan", true},
+		{"example.com.", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := p.toLabel(tt.fqdn)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("toLabel(%s) = %s, %v, want %s, %v", tt.fqdn, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+// serveUDP serves one request on a local udp socket with h.
+func serveUDP(t *testing.T, h func(b []byte) []byte) *net.UDPAddr {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		b := make([]byte, 1500)
+		n, from, err := c.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		if r := h(b[:n]); r != nil {
+			_, _ = c.WriteToUDP(r, from)
+		}
+	}()
+	return c.LocalAddr().(*net.UDPAddr)
+}
+
+func TestLLMNR_Exec(t *testing.T) {
+	p, err := NewLLMNR(&Args{Suffixes: []string{"lan"}, NetBIOS: true, Timeout: 200}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := func(name string, prev *dns.Msg) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		if prev != nil {
+			qCtx.SetResponse(prev)
+		}
+		if err := p.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		return qCtx.R()
+	}
+
+	// Answered by LLMNR.
+	p.llmnrAddr = serveUDP(t, func(b []byte) []byte {
+		q := new(dns.Msg)
+		if err := q.Unpack(b); err != nil || q.Question[0].Name != "pc1." || q.RecursionDesired {
+			return nil
+		}
+		r := new(dns.Msg)
+		r.SetReply(q)
+		r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: "pc1.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}, A: net.IPv4(192, 168, 1, 2)})
+		rb, _ := r.Pack()
+		return rb
+	})
+	nx := new(dns.Msg)
+	nx.SetQuestion("pc1.lan.", dns.TypeA)
+	nx.Rcode = dns.RcodeNameError
+	r := exec("pc1.lan.", nx)
+	if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.168.1.2" || r.Answer[0].Header().Name != "pc1.lan." {
+		t.Fatalf("unexpected llmnr response %v", r)
+	}
+
+	// LLMNR has no answer, answered by NetBIOS.
+	p.llmnrAddr = serveUDP(t, func([]byte) []byte { return nil })
+	p.netbiosAddr = serveUDP(t, func(b []byte) []byte {
+		if len(b) != 50 || string(b[13:45]) != string(appendNetBIOSName(nil, "pc2")[1:33]) {
+			return nil
+		}
+		r := append([]byte(nil), b[:2]...)
+		r = append(r, 0x85, 0x00, 0, 0, 0, 1, 0, 0, 0, 0)
+		r = appendNetBIOSName(r, "pc2")
+		r = append(r, 0, 0x20, 0, 1, 0, 0, 0x01, 0x2c, 0, 6, 0, 0, 10, 0, 0, 3)
+		return r
+	})
+	r = exec("pc2.", nil)
+	if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "10.0.0.3" || r.Answer[0].Header().Ttl != 300 {
+		t.Fatalf("unexpected netbios response %v", r)
+	}
+
+	// Resolved by normal dns.
+	ok := new(dns.Msg)
+	ok.SetQuestion("pc3.", dns.TypeA)
+	ok.Answer = append(ok.Answer, &dns.A{Hdr: dns.RR_Header{Name: "pc3.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(1, 1, 1, 1)})
+	if r := exec("pc3.", ok); r != ok {
+		t.Fatal("resolved query should not be changed")
+	}
+}
+
+func Test_parseNetBIOSResponse(t *testing.T) {
+	if _, _, err := parseNetBIOSResponse([]byte{0, 1, 0x85, 0}, 1); err == nil {
+		t.Fatal("short msg should fail")
+	}
+	// A negative response.
+	b := []byte{0, 1, 0x85, 0x03, 0, 0, 0, 1, 0, 0, 0, 0}
+	b = appendNetBIOSName(b, "pc")
+	b = append(b, 0, 0x20, 0, 1, 0, 0, 0, 0, 0, 0)
+	if _, _, err := parseNetBIOSResponse(b, 1); err == nil {
+		t.Fatal("negative response should fail")
+	}
+	binary.BigEndian.PutUint16(b[2:], 0x8500)
+	if _, _, err := parseNetBIOSResponse(b, 2); err == nil {
+		t.Fatal("response with a wrong id should fail")
+	}
+}
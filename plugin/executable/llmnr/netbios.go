@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package llmnr
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	netbiosNameLen = 15 // without the suffix byte.
+	netbiosTypeNB  = 0x0020
+	netbiosClassIN = 0x0001
+)
+
+// exchangeNetBIOS broadcasts a NetBIOS name query and returns A records
+// from the first positive response. It returns nil if no response was
+// received before timeout.
+func (p *LLMNR) exchangeNetBIOS(ctx context.Context, label string) ([]dns.RR, error) {
+	lc := net.ListenConfig{Control: broadcastControl()}
+	pc, err := lc.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	c := pc.(*net.UDPConn)
+	defer c.Close()
+
+	id := uint16(rand.Uint32())
+	if _, err := c.WriteTo(packNetBIOSQuery(id, label), p.netbiosAddr); err != nil {
+		return nil, err
+	}
+	p.setReadDeadline(ctx, c)
+
+	b := make([]byte, 576)
+	for {
+		n, _, err := c.ReadFrom(b)
+		if err != nil {
+			return nil, ignoreTimeout(err)
+		}
+		addrs, ttl, err := parseNetBIOSResponse(b[:n], id)
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		rrs := make([]dns.RR, 0, len(addrs))
+		for _, addr := range addrs {
+			rrs = append(rrs, &dns.A{
+				Hdr: dns.RR_Header{Name: label + ".", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   addr.AsSlice(),
+			})
+		}
+		return rrs, nil
+	}
+}
+
+// packNetBIOSQuery packs a broadcast name query of the workstation
+// service of name. See RFC 1002 4.2.12.
+func packNetBIOSQuery(id uint16, name string) []byte {
+	b := make([]byte, 0, 50)
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, 0x0110) // opcode query, recursion desired, broadcast
+	b = binary.BigEndian.AppendUint16(b, 1)      // qdcount
+	b = append(b, 0, 0, 0, 0, 0, 0)              // ancount, nscount, arcount
+	b = appendNetBIOSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, netbiosTypeNB)
+	b = binary.BigEndian.AppendUint16(b, netbiosClassIN)
+	return b
+}
+
+// appendNetBIOSName appends the first-level encoded name with suffix
+// 0x00 (workstation) and an empty scope. See RFC 1001 14.1.
+func appendNetBIOSName(b []byte, name string) []byte {
+	var n [netbiosNameLen + 1]byte
+	copy(n[:netbiosNameLen], strings.ToUpper(name))
+	for i := len(name); i < netbiosNameLen; i++ {
+		n[i] = ' '
+	}
+	b = append(b, byte(len(n)*2))
+	for _, c := range n {
+		b = append(b, 'A'+c>>4, 'A'+c&0xf)
+	}
+	return append(b, 0)
+}
+
+var errInvalidNetBIOSMsg = errors.New("invalid netbios msg")
+
+// parseNetBIOSResponse returns ipv4 addresses and the ttl of the first
+// answer of a positive name query response b. See RFC 1002 4.2.13.
+func parseNetBIOSResponse(b []byte, id uint16) ([]netip.Addr, uint32, error) {
+	if len(b) < 12 || binary.BigEndian.Uint16(b) != id {
+		return nil, 0, errInvalidNetBIOSMsg
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	if flags&0x8000 == 0 || flags>>11&0xf != 0 || flags&0xf != 0 {
+		return nil, 0, errInvalidNetBIOSMsg // not a positive query response
+	}
+	qdCount := binary.BigEndian.Uint16(b[4:])
+	if binary.BigEndian.Uint16(b[6:]) == 0 {
+		return nil, 0, errInvalidNetBIOSMsg // no answer
+	}
+
+	off := 12
+	var ok bool
+	for i := 0; i < int(qdCount); i++ {
+		if off, ok = skipNetBIOSName(b, off); !ok || off+4 > len(b) {
+			return nil, 0, errInvalidNetBIOSMsg
+		}
+		off += 4
+	}
+	if off, ok = skipNetBIOSName(b, off); !ok || off+10 > len(b) {
+		return nil, 0, errInvalidNetBIOSMsg
+	}
+	if binary.BigEndian.Uint16(b[off:]) != netbiosTypeNB {
+		return nil, 0, errInvalidNetBIOSMsg
+	}
+	ttl := binary.BigEndian.Uint32(b[off+4:])
+	rdLen := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+rdLen > len(b) || rdLen%6 != 0 {
+		return nil, 0, errInvalidNetBIOSMsg
+	}
+
+	var addrs []netip.Addr
+	for i := off; i < off+rdLen; i += 6 {
+		// Two bytes of NB_FLAGS and the address.
+		addrs = append(addrs, netip.AddrFrom4([4]byte(b[i+2:i+6])))
+	}
+	return addrs, ttl, nil
+}
+
+// skipNetBIOSName returns the offset after the name at off. A name is
+// labels ending with a zero byte, or a compression pointer.
+func skipNetBIOSName(b []byte, off int) (int, bool) {
+	for off < len(b) {
+		l := int(b[off])
+		switch {
+		case l == 0:
+			return off + 1, true
+		case l&0xc0 == 0xc0:
+			return off + 2, off+2 <= len(b)
+		default:
+			off += 1 + l
+		}
+	}
+	return 0, false
+}
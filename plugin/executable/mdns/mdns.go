@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+)
+
+const PluginType = "mdns"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+const mdnsSuffix = "local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type Args struct {
+	// Suffixes are extra domain suffixes that will be resolved via mDNS.
+	// The suffix is replaced by "local" before the query is sent.
+	// e.g. with suffix "home.arpa", "printer.home.arpa" is resolved as
+	// "printer.local". "local" is always resolved.
+	Suffixes []string `yaml:"suffixes"`
+	// Interfaces to send mDNS queries. Default is the system default
+	// multicast interface.
+	Interfaces []string `yaml:"interfaces"`
+	// Timeout in milliseconds to wait for replies. Default is 1000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Timeout, 1000)
+}
+
+var _ sequence.Executable = (*MDNS)(nil)
+
+// MDNS answers queries for .local names by sending one-shot multicast
+// DNS queries (RFC 6762 section 5.1) and relaying the first reply.
+type MDNS struct {
+	suffixes []string // fqdn, lower case, "local." is always the first one.
+	ifaces   []*net.Interface
+	timeout  time.Duration
+	logger   *zap.Logger
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewMDNS(args.(*Args), bp.L())
+}
+
+// QuickSetup format: [suffix]...
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	return NewMDNS(&Args{Suffixes: strings.Fields(s)}, bq.L())
+}
+
+func NewMDNS(args *Args, logger *zap.Logger) (*MDNS, error) {
+	args.init()
+	m := &MDNS{
+		suffixes: []string{mdnsSuffix},
+		timeout:  time.Duration(args.Timeout) * time.Millisecond,
+		logger:   logger,
+	}
+	for _, s := range args.Suffixes {
+		s = strings.ToLower(dns.Fqdn(s))
+		if _, ok := dns.IsDomainName(s); !ok {
+			return nil, fmt.Errorf("invalid suffix %s", s)
+		}
+		if s != mdnsSuffix {
+			m.suffixes = append(m.suffixes, s)
+		}
+	}
+	for _, name := range args.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s, %w", name, err)
+		}
+		m.ifaces = append(m.ifaces, iface)
+	}
+	return m, nil
+}
+
+func (m *MDNS) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	if len(q.Question) != 1 {
+		return nil
+	}
+	question := q.Question[0]
+	name, ok := m.toMDNSName(question.Name)
+	if !ok {
+		return nil
+	}
+
+	rrs, err := m.exchange(ctx, name, question.Qtype)
+	if err != nil {
+		m.logger.Debug("mdns query failed", qCtx.InfoField(), zap.Error(err))
+		return nil
+	}
+	qCtx.SetResponse(reply(q, name, rrs))
+	return nil
+}
+
+// reply returns the response of q with mDNS answers rrs of name.
+// No answers (no responder replied in time) is NODATA instead of
+// NXDOMAIN, because the name may have other types, or its responder
+// may just be offline.
+func reply(q *dns.Msg, name string, rrs []dns.RR) *dns.Msg {
+	question := q.Question[0]
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		hdr.Class &^= 1 << 15 // clear the cache-flush bit
+		if strings.EqualFold(hdr.Name, name) {
+			hdr.Name = question.Name
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+// answers returns the answers in r whose name and type match the
+// question. mDNS responders may add other records of the host to
+// the answer section.
+func answers(r *dns.Msg, name string, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, rr := range r.Answer {
+		hdr := rr.Header()
+		if !strings.EqualFold(hdr.Name, name) {
+			continue
+		}
+		if qtype != dns.TypeANY && hdr.Rrtype != qtype {
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// toMDNSName returns the name that should be queried via mDNS.
+// ok is false if fqdn is not under any of the suffixes.
+func (m *MDNS) toMDNSName(fqdn string) (string, bool) {
+	lower := strings.ToLower(fqdn)
+	for _, s := range m.suffixes {
+		if len(lower) > len(s) && strings.HasSuffix(lower, s) && lower[len(lower)-len(s)-1] == '.' {
+			return lower[:len(lower)-len(s)] + mdnsSuffix, true
+		}
+	}
+	return "", false
+}
+
+// exchange sends a one-shot mDNS query and waits for the first reply that
+// has matched answers, and returns them. It returns nil if no reply was
+// received before timeout.
+func (m *MDNS) exchange(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	q.RecursionDesired = false
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	pc := ipv4.NewPacketConn(c)
+	_ = pc.SetMulticastTTL(255)
+	if len(m.ifaces) == 0 {
+		if _, err := c.WriteTo(b, mdnsGroup); err != nil {
+			return nil, err
+		}
+	} else {
+		var sent int
+		var lastErr error
+		for _, iface := range m.ifaces {
+			if err := pc.SetMulticastInterface(iface); err != nil {
+				lastErr = err
+				continue
+			}
+			if _, err := pc.WriteTo(b, nil, mdnsGroup); err != nil {
+				lastErr = err
+				continue
+			}
+			sent++
+		}
+		if sent == 0 {
+			return nil, fmt.Errorf("failed to send query on any interface, %w", lastErr)
+		}
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	if ddl, ok := ctx.Deadline(); ok && ddl.Before(deadline) {
+		deadline = ddl
+	}
+	_ = c.SetReadDeadline(deadline)
+
+	rb := pool.GetBuf(dns.MaxMsgSize)
+	defer pool.ReleaseBuf(rb)
+	for {
+		n, _, err := c.ReadFrom(*rb)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack((*rb)[:n]); err != nil {
+			continue
+		}
+		if r.Id != q.Id || !r.Response {
+			continue
+		}
+		if rrs := answers(r, name, qtype); len(rrs) > 0 {
+			return rrs, nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func TestMDNS_toMDNSName(t *testing.T) {
+	m, err := NewMDNS(&Args{Suffixes: []string{"home.arpa", "Lan"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fqdn   string
+		want   string
+		wantOk bool
+	}{
+		{"printer.local.", "printer.local.", true},
+		{"Printer.LOCAL.", "printer.local.", true},
+		{"printer.home.arpa.", "printer.local.", true},
+		{"a.printer.lan.", "a.printer.local.", true},
+		{"local.", "", false},
+		{"xlocal.", "", false},
+		{"example.com.", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := m.toMDNSName(tt.fqdn)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("toMDNSName(%s) = %s, %v, want %s, %v", tt.fqdn, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func Test_answers_reply(t *testing.T) {
+	r := new(dns.Msg)
+	for _, s := range []string{
+		"printer.local. 120 IN A 192.168.1.2",
+		"printer.local. 120 IN TXT hello",
+		"other.local. 120 IN A 192.168.1.3",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Answer = append(r.Answer, rr)
+	}
+	r.Answer[0].Header().Class |= 1 << 15 // cache-flush
+
+	if rrs := answers(r, "printer.local.", dns.TypeAAAA); len(rrs) != 0 {
+		t.Fatalf("want no answer, got %v", rrs)
+	}
+	rrs := answers(r, "Printer.local.", dns.TypeA)
+	if len(rrs) != 1 {
+		t.Fatalf("want 1 answer, got %v", rrs)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("printer.home.arpa.", dns.TypeA)
+	resp := reply(q, "printer.local.", rrs)
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Name != "printer.home.arpa." || resp.Answer[0].Header().Class != dns.ClassINET {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	// No reply is NODATA, not NXDOMAIN.
+	q.SetQuestion("printer.home.arpa.", dns.TypeAAAA)
+	if resp := reply(q, "printer.local.", nil); resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Fatalf("want NODATA, got %v", resp)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const PluginType = "mirror"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Exec is the tag of an executable plugin (e.g. a sequence) that
+	// receives mirrored queries.
+	Exec string `yaml:"exec"`
+	// Upstream is the address of an upstream that receives mirrored queries.
+	// Exactly one of Exec and Upstream must be set.
+	Upstream string `yaml:"upstream"`
+	// SampleRate in (0, 1] is the fraction of queries that will be mirrored.
+	// Default is 1.
+	SampleRate float64 `yaml:"sample_rate"`
+	// MaxInflight is the maximum number of concurrent mirrored queries.
+	// Queries will be dropped if it is reached. Default is 64.
+	MaxInflight int `yaml:"max_inflight"`
+	// Timeout in milliseconds of mirrored queries. Default is 5000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	if a.SampleRate <= 0 || a.SampleRate > 1 {
+		a.SampleRate = 1
+	}
+	utils.SetDefaultUnsignNum(&a.MaxInflight, 64)
+	utils.SetDefaultUnsignNum(&a.Timeout, 5000)
+}
+
+var _ sequence.Executable = (*Mirror)(nil)
+
+// Mirror asynchronously copies queries to a secondary upstream or
+// pipeline. Results are ignored and never affect the original query.
+type Mirror struct {
+	args   *Args
+	logger *zap.Logger
+	e      sequence.Executable // nil if u is used
+	u      upstream.Upstream   // nil if e is used
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mirroredTotal prometheus.Counter
+	droppedTotal  prometheus.Counter
+	errTotal      prometheus.Counter
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	m, err := NewMirror(bp, args.(*Args), bp.Tag())
+	if err != nil {
+		return nil, err
+	}
+	if err := m.RegMetricsTo(prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg())); err != nil {
+		_ = m.Close()
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	return m, nil
+}
+
+func NewMirror(bq sequence.BQ, args *Args, metricsTag string) (*Mirror, error) {
+	args.init()
+	if (len(args.Exec) == 0) == (len(args.Upstream) == 0) {
+		return nil, errors.New("exactly one of exec and upstream must be set")
+	}
+
+	lb := map[string]string{"tag": metricsTag}
+	m := &Mirror{
+		args:   args,
+		logger: bq.L(),
+		sem:    make(chan struct{}, args.MaxInflight),
+		mirroredTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "mirrored_total",
+			Help:        "The total number of mirrored queries",
+			ConstLabels: lb,
+		}),
+		droppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "dropped_total",
+			Help:        "The total number of queries that were not mirrored because of max_inflight",
+			ConstLabels: lb,
+		}),
+		errTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "err_total",
+			Help:        "The total number of mirrored queries that failed",
+			ConstLabels: lb,
+		}),
+	}
+	if len(args.Exec) > 0 {
+		m.e = sequence.ToExecutable(bq.M().GetPlugin(args.Exec))
+		if m.e == nil {
+			return nil, fmt.Errorf("can not find executable %s", args.Exec)
+		}
+	} else {
+		u, err := upstream.NewUpstream(args.Upstream, upstream.Opt{Logger: bq.L()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to init upstream, %w", err)
+		}
+		m.u = u
+	}
+	return m, nil
+}
+
+func (m *Mirror) RegMetricsTo(r prometheus.Registerer) error {
+	for _, collector := range [...]prometheus.Collector{m.mirroredTotal, m.droppedTotal, m.errTotal} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) Exec(_ context.Context, qCtx *query_context.Context) error {
+	if m.args.SampleRate < 1 && rand.Float64() >= m.args.SampleRate {
+		return nil
+	}
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		m.droppedTotal.Inc()
+		return nil
+	}
+
+	m.mirroredTotal.Inc()
+	qCtxM := qCtx.Copy()
+	qCtxM.SetResponse(nil)
+	m.wg.Add(1)
+	go func() {
+		defer func() {
+			<-m.sem
+			m.wg.Done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.args.Timeout)*time.Millisecond)
+		defer cancel()
+		if err := m.mirror(ctx, qCtxM); err != nil {
+			m.errTotal.Inc()
+			m.logger.Debug("mirrored query failed", qCtxM.InfoField(), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (m *Mirror) mirror(ctx context.Context, qCtx *query_context.Context) error {
+	if m.e != nil {
+		return m.e.Exec(ctx, qCtx)
+	}
+	b, err := pool.PackBuffer(qCtx.Q())
+	if err != nil {
+		return err
+	}
+	defer pool.ReleaseBuf(b)
+	r, err := m.u.ExchangeContext(ctx, *b)
+	if err != nil {
+		return err
+	}
+	pool.ReleaseBuf(r)
+	return nil
+}
+
+// Close waits for all mirrored queries to finish.
+func (m *Mirror) Close() error {
+	m.wg.Wait()
+	if m.u != nil {
+		return m.u.Close()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mirror
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+type blockingExec struct {
+	n       atomic.Int32
+	release chan struct{}
+}
+
+func (e *blockingExec) Exec(_ context.Context, qCtx *query_context.Context) error {
+	e.n.Add(1)
+	<-e.release
+	qCtx.SetResponse(new(dns.Msg))
+	return nil
+}
+
+func TestMirror_Exec(t *testing.T) {
+	be := &blockingExec{release: make(chan struct{})}
+	cm := coremain.NewTestMosdnsWithPlugins(map[string]any{"shadow": be})
+	m, err := NewMirror(coremain.NewBP("test", cm), &Args{Exec: "shadow", MaxInflight: 2}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < 3; i++ {
+		qCtx := query_context.NewContext(q)
+		if err := m.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		if qCtx.R() != nil {
+			t.Fatal("original query should not be affected")
+		}
+	}
+	close(be.release)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := be.n.Load(); n != 2 {
+		t.Fatalf("want 2 mirrored queries, got %d", n)
+	}
+
+	if _, err := NewMirror(coremain.NewBP("test", cm), &Args{}, "test"); err == nil {
+		t.Fatal("missing target should be rejected")
+	}
+}
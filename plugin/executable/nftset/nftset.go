@@ -21,6 +21,7 @@ package nftset
 
 import (
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"strconv"
 	"strings"
@@ -29,6 +30,7 @@ import (
 const PluginType = "nftset"
 
 func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -44,10 +46,26 @@ type SetArgs struct {
 	Table       string `yaml:"table_name"`
 	Set         string `yaml:"set_name"`
 	Mask        int    `yaml:"mask"`
+
+	// Timeout of new elements in seconds. Only works if the set has
+	// a 'timeout' flag. Default (0) is using the set's default timeout.
+	Timeout int `yaml:"timeout"`
+
+	// TTLTimeout derives the timeout of new elements from the records' TTL.
+	// It overrides Timeout and only works if the set has a 'timeout' flag.
+	TTLTimeout bool `yaml:"ttl_timeout"`
+	// MinTimeout and MaxTimeout (in seconds) clamp the derived timeout.
+	// Default MinTimeout is 60. Default MaxTimeout (0) is no limit.
+	MinTimeout int `yaml:"min_timeout"`
+	MaxTimeout int `yaml:"max_timeout"`
 }
 
-// QuickSetup format: [{ip|ip6|inet},table_name,set_name,{ipv4_addr|ipv6_addr},mask] *2 (can repeat once)
-// e.g. "inet,my_table,my_set,ipv4_addr,24 inet,my_table,my_set,ipv6_addr,48"
+func Init(_ *coremain.BP, args any) (any, error) {
+	return newNftSetPlugin(args.(*Args))
+}
+
+// QuickSetup format: [{ip|ip6|inet},table_name,set_name,{ipv4_addr|ipv6_addr},mask[,timeout]] *2 (can repeat once)
+// e.g. "inet,my_table,my_set,ipv4_addr,24 inet,my_table,my_set,ipv6_addr,48,600"
 func QuickSetup(_ sequence.BQ, s string) (any, error) {
 	fs := strings.Fields(s)
 	if len(fs) > 2 {
@@ -57,8 +75,8 @@ func QuickSetup(_ sequence.BQ, s string) (any, error) {
 	args := new(Args)
 	for _, argsStr := range fs {
 		ss := strings.Split(argsStr, ",")
-		if len(ss) != 5 {
-			return nil, fmt.Errorf("invalid args, expect 5 fields, got %d", len(ss))
+		if len(ss) != 5 && len(ss) != 6 {
+			return nil, fmt.Errorf("invalid args, expect 5 or 6 fields, got %d", len(ss))
 		}
 
 		m, err := strconv.Atoi(ss[4])
@@ -71,6 +89,13 @@ func QuickSetup(_ sequence.BQ, s string) (any, error) {
 			Set:         ss[2],
 			Mask:        m,
 		}
+		if len(ss) == 6 {
+			timeout, err := strconv.Atoi(ss[5])
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout, %w", err)
+			}
+			sa.Timeout = timeout
+		}
 		switch ss[3] {
 		case "ipv4_addr":
 			args.IPv4 = sa
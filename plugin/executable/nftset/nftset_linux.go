@@ -23,9 +23,12 @@ package nftset
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/netip"
+	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/nftset_utils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
@@ -48,6 +51,14 @@ func newNftSetPlugin(args *Args) (*nftSetPlugin, error) {
 	if m := args.IPv6.Mask; m > 128 {
 		return nil, fmt.Errorf("invalid ipv6 mask %d", m)
 	}
+	if args.IPv4.Timeout < 0 || args.IPv6.Timeout < 0 {
+		return nil, errors.New("invalid negative timeout")
+	}
+	utils.SetDefaultUnsignNum(&args.IPv4.MinTimeout, 60)
+	utils.SetDefaultUnsignNum(&args.IPv6.MinTimeout, 60)
+	if args.IPv4.MaxTimeout < 0 || args.IPv6.MaxTimeout < 0 {
+		return nil, errors.New("invalid negative max_timeout")
+	}
 
 	p := &nftSetPlugin{
 		args: args,
@@ -65,6 +76,7 @@ func newNftSetPlugin(args *Args) (*nftSetPlugin, error) {
 			TableFamily: f,
 			TableName:   sa.Table,
 			SetName:     sa.Set,
+			Timeout:     time.Duration(sa.Timeout) * time.Second,
 		}), nil
 	}
 	var err error
@@ -91,8 +103,8 @@ func (p *nftSetPlugin) Exec(_ context.Context, qCtx *query_context.Context) erro
 }
 
 func (p *nftSetPlugin) addElems(r *dns.Msg) error {
-	var v4Elems []netip.Prefix
-	var v6Elems []netip.Prefix
+	// Elements are grouped by their timeout.
+	var v4Groups, v6Groups []elemGroup
 
 	for i := range r.Answer {
 		switch rr := r.Answer[i].(type) {
@@ -105,7 +117,8 @@ func (p *nftSetPlugin) addElems(r *dns.Msg) error {
 			if !ok || !addr.Is4() {
 				return fmt.Errorf("internel: dns.A record [%s] is not a ipv4 address", rr.A)
 			}
-			v4Elems = append(v4Elems, netip.PrefixFrom(addr, p.args.IPv4.Mask))
+			timeout := elemTimeout(p.args.IPv4, rr)
+			v4Groups = appendElem(v4Groups, timeout, netip.PrefixFrom(addr, p.args.IPv4.Mask))
 
 		case *dns.AAAA:
 			if p.v6Handler == nil {
@@ -118,21 +131,60 @@ func (p *nftSetPlugin) addElems(r *dns.Msg) error {
 			if addr.Is4() {
 				addr = netip.AddrFrom16(addr.As16())
 			}
-			v6Elems = append(v6Elems, netip.PrefixFrom(addr, p.args.IPv6.Mask))
+			timeout := elemTimeout(p.args.IPv6, rr)
+			v6Groups = appendElem(v6Groups, timeout, netip.PrefixFrom(addr, p.args.IPv6.Mask))
 		default:
 			continue
 		}
 	}
 
-	if p.v4Handler != nil && len(v4Elems) > 0 {
-		if err := p.v4Handler.AddElems(v4Elems...); err != nil {
-			return fmt.Errorf("failed to add ipv4 elems %s: %w", v4Elems, err)
+	if err := addElemGroups(p.v4Handler, p.args.IPv4, v4Groups); err != nil {
+		return fmt.Errorf("failed to add ipv4 elems: %w", err)
+	}
+	if err := addElemGroups(p.v6Handler, p.args.IPv6, v6Groups); err != nil {
+		return fmt.Errorf("failed to add ipv6 elems: %w", err)
+	}
+	return nil
+}
+
+// elemTimeout returns the timeout derived from rr's ttl if
+// sa.TTLTimeout is set. Otherwise, it returns 0.
+func elemTimeout(sa SetArgs, rr dns.RR) time.Duration {
+	if !sa.TTLTimeout {
+		return 0
+	}
+	ttl := dnsutils.ClampTTL(rr.Header().Ttl, uint32(sa.MinTimeout), uint32(sa.MaxTimeout))
+	return time.Duration(ttl) * time.Second
+}
+
+// elemGroup is a group of elements that have the same timeout.
+type elemGroup struct {
+	timeout time.Duration
+	elems   []netip.Prefix
+}
+
+// appendElem appends e to the group of timeout in gs. A response only
+// has a few distinct ttls, so a linear search is cheaper than a map.
+func appendElem(gs []elemGroup, timeout time.Duration, e netip.Prefix) []elemGroup {
+	for i := range gs {
+		if gs[i].timeout == timeout {
+			gs[i].elems = append(gs[i].elems, e)
+			return gs
 		}
 	}
+	return append(gs, elemGroup{timeout: timeout, elems: []netip.Prefix{e}})
+}
 
-	if p.v6Handler != nil && len(v6Elems) > 0 {
-		if err := p.v6Handler.AddElems(v6Elems...); err != nil {
-			return fmt.Errorf("failed to add ipv6 elems %s: %w", v6Elems, err)
+func addElemGroups(h *nftset_utils.NftSetHandler, sa SetArgs, groups []elemGroup) error {
+	for _, g := range groups {
+		var err error
+		if sa.TTLTimeout {
+			err = h.AddElemsWithTimeout(g.timeout, g.elems...)
+		} else {
+			err = h.AddElems(g.elems...)
+		}
+		if err != nil {
+			return fmt.Errorf("%s, %w", g.elems, err)
 		}
 	}
 	return nil
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package nftset
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func Test_elemTimeout(t *testing.T) {
+	rr := func(ttl uint32) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}}
+	}
+	tests := []struct {
+		name string
+		sa   SetArgs
+		ttl  uint32
+		want time.Duration
+	}{
+		{"disabled", SetArgs{Timeout: 30, MinTimeout: 60}, 300, 0},
+		{"ttl", SetArgs{TTLTimeout: true, MinTimeout: 60}, 300, 300 * time.Second},
+		{"min", SetArgs{TTLTimeout: true, MinTimeout: 60}, 10, 60 * time.Second},
+		{"max", SetArgs{TTLTimeout: true, MinTimeout: 60, MaxTimeout: 600}, 3600, 600 * time.Second},
+		{"no limit", SetArgs{TTLTimeout: true}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := elemTimeout(tt.sa, rr(tt.ttl)); got != tt.want {
+				t.Errorf("elemTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendElem(t *testing.T) {
+	p1 := netip.MustParsePrefix("192.0.2.1/32")
+	p2 := netip.MustParsePrefix("192.0.2.2/32")
+	p3 := netip.MustParsePrefix("192.0.2.3/32")
+
+	var gs []elemGroup
+	gs = appendElem(gs, time.Minute, p1)
+	gs = appendElem(gs, time.Hour, p2)
+	gs = appendElem(gs, time.Minute, p3)
+	if len(gs) != 2 {
+		t.Fatalf("want 2 groups, got %d", len(gs))
+	}
+	if gs[0].timeout != time.Minute || len(gs[0].elems) != 2 || gs[0].elems[0] != p1 || gs[0].elems[1] != p3 {
+		t.Fatalf("unexpected group %+v", gs[0])
+	}
+	if gs[1].timeout != time.Hour || len(gs[1].elems) != 1 || gs[1].elems[0] != p2 {
+		t.Fatalf("unexpected group %+v", gs[1])
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package nx_redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "nx_redirect"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+type Args struct {
+	// IPs of the landing page. At least one ipv4 or ipv6 is required.
+	IPs []string `yaml:"ips"`
+	// TTL of redirected answers. Default is 60.
+	TTL int `yaml:"ttl"`
+	// NoData also redirects NOERROR responses that have no answer
+	// of the queried type.
+	NoData bool `yaml:"nodata"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.TTL, 60)
+}
+
+var _ sequence.Executable = (*NXRedirect)(nil)
+
+// NXRedirect rewrites NXDOMAIN (and optionally NODATA) responses of A/AAAA
+// queries to the landing ips. Redirected responses are marked with the
+// extended dns error "Forged Answer" if the client supports EDNS0.
+// It should be placed after the upstream, like ttl.
+type NXRedirect struct {
+	ipv4   []netip.Addr
+	ipv6   []netip.Addr
+	ttl    uint32
+	noData bool
+}
+
+func Init(_ *coremain.BP, args any) (any, error) {
+	return NewNXRedirect(args.(*Args))
+}
+
+// QuickSetup format: ip... [ttl=<ttl>] [nodata]
+// e.g. "192.0.2.1 2001:db8::1 ttl=30 nodata"
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	for _, f := range strings.Fields(s) {
+		switch {
+		case f == "nodata":
+			args.NoData = true
+		case strings.HasPrefix(f, "ttl="):
+			n, err := strconv.Atoi(strings.TrimPrefix(f, "ttl="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid ttl, %w", err)
+			}
+			args.TTL = n
+		default:
+			args.IPs = append(args.IPs, f)
+		}
+	}
+	return NewNXRedirect(args)
+}
+
+func NewNXRedirect(args *Args) (*NXRedirect, error) {
+	args.init()
+	p := &NXRedirect{
+		ttl:    uint32(args.TTL),
+		noData: args.NoData,
+	}
+	for _, s := range args.IPs {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %s, %w", s, err)
+		}
+		if addr.Is4() || addr.Is4In6() {
+			p.ipv4 = append(p.ipv4, addr.Unmap())
+		} else {
+			p.ipv6 = append(p.ipv6, addr)
+		}
+	}
+	if len(p.ipv4)+len(p.ipv6) == 0 {
+		return nil, errors.New("no landing ip is configured")
+	}
+	return p, nil
+}
+
+func (p *NXRedirect) Exec(_ context.Context, qCtx *query_context.Context) error {
+	r := qCtx.R()
+	if r == nil || !p.shouldRedirect(qCtx.QQuestion(), r) {
+		return nil
+	}
+
+	question := qCtx.QQuestion()
+	var addrs []netip.Addr
+	if question.Qtype == dns.TypeA {
+		addrs = p.ipv4
+	} else {
+		addrs = p.ipv6
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	nr := new(dns.Msg)
+	nr.SetReply(qCtx.Q())
+	nr.RecursionAvailable = r.RecursionAvailable
+	hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: p.ttl}
+	for _, addr := range addrs {
+		if question.Qtype == dns.TypeA {
+			nr.Answer = append(nr.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		} else {
+			nr.Answer = append(nr.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+	qCtx.SetResponse(nr)
+	if opt := qCtx.RespOpt(); opt != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+			InfoCode:  dns.ExtendedErrorCodeForgedAnswer,
+			ExtraText: "redirected",
+		})
+	}
+	return nil
+}
+
+func (p *NXRedirect) shouldRedirect(question dns.Question, r *dns.Msg) bool {
+	if question.Qclass != dns.ClassINET || (question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA) {
+		return false
+	}
+	switch r.Rcode {
+	case dns.RcodeNameError:
+		return true
+	case dns.RcodeSuccess:
+		if !p.noData {
+			return false
+		}
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == question.Qtype {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
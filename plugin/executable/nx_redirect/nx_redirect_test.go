@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package nx_redirect
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestNXRedirect_Exec(t *testing.T) {
+	p, err := QuickSetup(nil, "192.0.2.1 ttl=30 nodata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		qtype     uint16
+		rcode     int
+		answer    string
+		wantRedir bool
+	}{
+		{"nxdomain", dns.TypeA, dns.RcodeNameError, "", true},
+		{"nodata", dns.TypeA, dns.RcodeSuccess, "", true},
+		{"cname only", dns.TypeA, dns.RcodeSuccess, "example.com. 60 IN CNAME a.example.com.", true},
+		{"has answer", dns.TypeA, dns.RcodeSuccess, "example.com. 60 IN A 1.1.1.1", false},
+		{"no ipv6", dns.TypeAAAA, dns.RcodeNameError, "", false},
+		{"servfail", dns.TypeA, dns.RcodeServerFailure, "", false},
+		{"other type", dns.TypeTXT, dns.RcodeNameError, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.SetQuestion("example.com.", tt.qtype)
+			q.SetEdns0(1232, false)
+			qCtx := query_context.NewContext(q)
+			r := new(dns.Msg)
+			r.SetRcode(q, tt.rcode)
+			if len(tt.answer) > 0 {
+				rr, _ := dns.NewRR(tt.answer)
+				r.Answer = append(r.Answer, rr)
+			}
+			qCtx.SetResponse(r)
+
+			if err := p.(*NXRedirect).Exec(context.Background(), qCtx); err != nil {
+				t.Fatal(err)
+			}
+			var redirected bool
+			if ans := qCtx.R().Answer; len(ans) == 1 {
+				a, ok := ans[0].(*dns.A)
+				redirected = ok && a.A.String() == "192.0.2.1"
+			}
+			if redirected != tt.wantRedir {
+				t.Fatalf("want redirected %v, got %v", tt.wantRedir, qCtx.R())
+			}
+			if redirected {
+				if ttl := qCtx.R().Answer[0].Header().Ttl; ttl != 30 {
+					t.Fatalf("want ttl 30, got %d", ttl)
+				}
+				if len(qCtx.RespOpt().Option) == 0 {
+					t.Fatal("missing ede")
+				}
+			}
+		})
+	}
+}
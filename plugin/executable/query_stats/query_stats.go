@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "query_stats"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Top is the number of entries in each top list. Default is 20.
+	Top int `yaml:"top"`
+	// MaxKeys limits the number of domains/clients that are tracked
+	// in each time bucket. Default is 10000.
+	MaxKeys int `yaml:"max_keys"`
+	// BlockedMark, if set, counts queries that have this mark as blocked.
+	// Queries that were answered with unspecified addresses
+	// (0.0.0.0 or ::) are always counted as blocked.
+	BlockedMark uint32 `yaml:"blocked_mark"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Top, 20)
+	utils.SetDefaultUnsignNum(&a.MaxKeys, 10000)
+}
+
+var _ sequence.RecursiveExecutable = (*QueryStats)(nil)
+
+// QueryStats maintains rolling statistics of the last hour and day
+// of queries that passed through it. Statistics are served by its api
+// "/stats?period=hour|day".
+type QueryStats struct {
+	args *Args
+
+	m    sync.Mutex
+	hour *window
+	day  *window
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	p := NewQueryStats(args.(*Args))
+	bp.RegAPI(p.Api())
+	return p, nil
+}
+
+func NewQueryStats(args *Args) *QueryStats {
+	args.init()
+	return &QueryStats{
+		args: args,
+		hour: newWindow(time.Minute, 60, args.MaxKeys),
+		day:  newWindow(time.Hour, 24, args.MaxKeys),
+	}
+}
+
+func (p *QueryStats) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	p.Add(qCtx, err)
+	return err
+}
+
+// Add adds the query to the statistics. err is the error of the query.
+// It is for plugins that record statistics by themselves.
+func (p *QueryStats) Add(qCtx *query_context.Context, err error) {
+	p.add(time.Now(), p.newRecord(qCtx, err))
+}
+
+// IsBlocked reports whether the query is counted as blocked.
+func (p *QueryStats) IsBlocked(qCtx *query_context.Context, err error) bool {
+	if m := p.args.BlockedMark; m > 0 && qCtx.HasMark(m) {
+		return true
+	}
+	resp := qCtx.R()
+	return err == nil && resp != nil && hasUnspecifiedAddr(resp)
+}
+
+func (p *QueryStats) newRecord(qCtx *query_context.Context, err error) record {
+	r := record{
+		domain:  strings.ToLower(strings.TrimSuffix(qCtx.QQuestion().Name, ".")),
+		blocked: p.IsBlocked(qCtx, err),
+		elapsed: time.Since(qCtx.StartTime()),
+	}
+	if len(r.domain) == 0 {
+		r.domain = "."
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		r.client = addr.String()
+	}
+	resp := qCtx.R()
+	switch {
+	case err != nil:
+		r.rcode = "error"
+	case resp == nil:
+		r.rcode = "no_response"
+	default:
+		r.rcode = dns.RcodeToString[resp.Rcode]
+	}
+	return r
+}
+
+func hasUnspecifiedAddr(r *dns.Msg) bool {
+	for _, rr := range r.Answer {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		}
+		if addr.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *QueryStats) add(now time.Time, r record) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.hour.add(now, r)
+	p.day.add(now, r)
+}
+
+// Stats returns statistics of period "hour" or "day".
+// ok is false if period is invalid.
+func (p *QueryStats) Stats(period string) (s Stats, ok bool) {
+	now := time.Now()
+	p.m.Lock()
+	defer p.m.Unlock()
+	switch period {
+	case "hour":
+		s = p.hour.stats(now, p.args.Top)
+	case "day":
+		s = p.day.stats(now, p.args.Top)
+	default:
+		return Stats{}, false
+	}
+	s.Period = period
+	return s, true
+}
+
+func (p *QueryStats) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/stats", func(w http.ResponseWriter, req *http.Request) {
+		period := req.URL.Query().Get("period")
+		if len(period) == 0 {
+			period = "hour"
+		}
+		s, ok := p.Stats(period)
+		if !ok {
+			http.Error(w, "invalid period, must be 'hour' or 'day'", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s)
+	})
+	return r
+}
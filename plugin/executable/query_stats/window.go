@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_stats
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyOthers collects counts of new keys after a bucket is full.
+const keyOthers = "(others)"
+
+type record struct {
+	domain  string
+	client  string
+	rcode   string
+	blocked bool
+	elapsed time.Duration
+}
+
+type bucket struct {
+	idx            int64 // unix time / window width
+	total, blocked uint64
+	elapsed        time.Duration
+	domains        map[string]uint64
+	blockedDomains map[string]uint64
+	clients        map[string]uint64
+	rcodes         map[string]uint64
+}
+
+func newBucket(idx int64) *bucket {
+	return &bucket{
+		idx:            idx,
+		domains:        make(map[string]uint64),
+		blockedDomains: make(map[string]uint64),
+		clients:        make(map[string]uint64),
+		rcodes:         make(map[string]uint64),
+	}
+}
+
+// window is a rolling window that consists of n buckets with width.
+// It is not concurrent safe.
+type window struct {
+	width   time.Duration
+	buckets []*bucket // ring buffer, nil if unused.
+	maxKeys int
+}
+
+func newWindow(width time.Duration, n int, maxKeys int) *window {
+	return &window{width: width, buckets: make([]*bucket, n), maxKeys: maxKeys}
+}
+
+func (w *window) add(now time.Time, r record) {
+	idx := now.UnixNano() / int64(w.width)
+	i := int(idx % int64(len(w.buckets)))
+	b := w.buckets[i]
+	if b == nil || b.idx != idx {
+		b = newBucket(idx)
+		w.buckets[i] = b
+	}
+
+	b.total++
+	b.elapsed += r.elapsed
+	incKey(b.domains, r.domain, w.maxKeys)
+	if len(r.client) > 0 {
+		incKey(b.clients, r.client, w.maxKeys)
+	}
+	incKey(b.rcodes, r.rcode, w.maxKeys)
+	if r.blocked {
+		b.blocked++
+		incKey(b.blockedDomains, r.domain, w.maxKeys)
+	}
+}
+
+func incKey(m map[string]uint64, k string, maxKeys int) {
+	if _, ok := m[k]; !ok && len(m) >= maxKeys {
+		k = keyOthers
+	}
+	m[k]++
+}
+
+// Stats is the summary of a window.
+type Stats struct {
+	Period            string            `json:"period"`
+	Total             uint64            `json:"total"`
+	Blocked           uint64            `json:"blocked"`
+	Rcodes            map[string]uint64 `json:"rcodes"`
+	TopDomains        []Entry           `json:"top_domains"`
+	TopBlockedDomains []Entry           `json:"top_blocked_domains"`
+	TopClients        []Entry           `json:"top_clients"`
+	AvgElapsedMs      float64           `json:"avg_elapsed_ms"`
+	// Series are the counts of each bucket, from the oldest to the newest.
+	Series []Point `json:"series"`
+}
+
+type Point struct {
+	Total   uint64 `json:"total"`
+	Blocked uint64 `json:"blocked"`
+}
+
+type Entry struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// stats sums buckets that are still in the window and returns top n
+// entries of each aggregate.
+func (w *window) stats(now time.Time, n int) Stats {
+	minIdx := now.UnixNano()/int64(w.width) - int64(len(w.buckets)) + 1
+	s := Stats{Rcodes: make(map[string]uint64), Series: make([]Point, len(w.buckets))}
+	var elapsed time.Duration
+	domains := make(map[string]uint64)
+	blockedDomains := make(map[string]uint64)
+	clients := make(map[string]uint64)
+	for _, b := range w.buckets {
+		if b == nil || b.idx < minIdx {
+			continue
+		}
+		s.Total += b.total
+		s.Blocked += b.blocked
+		elapsed += b.elapsed
+		s.Series[b.idx-minIdx] = Point{Total: b.total, Blocked: b.blocked}
+		sumTo(s.Rcodes, b.rcodes)
+		sumTo(domains, b.domains)
+		sumTo(blockedDomains, b.blockedDomains)
+		sumTo(clients, b.clients)
+	}
+	if s.Total > 0 {
+		s.AvgElapsedMs = float64(elapsed) / float64(s.Total) / float64(time.Millisecond)
+	}
+	s.TopDomains = topN(domains, n)
+	s.TopBlockedDomains = topN(blockedDomains, n)
+	s.TopClients = topN(clients, n)
+	return s
+}
+
+func sumTo(dst, src map[string]uint64) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// topN returns n entries with the largest counts, in descending order.
+func topN(m map[string]uint64, n int) []Entry {
+	es := make([]Entry, 0, len(m))
+	for k, v := range m {
+		es = append(es, Entry{Name: k, Count: v})
+	}
+	slices.SortFunc(es, func(a, b Entry) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(es) > n {
+		es = es[:n]
+	}
+	return es
+}
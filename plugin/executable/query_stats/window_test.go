@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_stats
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_window(t *testing.T) {
+	w := newWindow(time.Minute, 3, 2)
+	t0 := time.Unix(0, 0)
+	w.add(t0, record{domain: "a.com", client: "c1", rcode: "NOERROR", elapsed: 4 * time.Millisecond})
+	w.add(t0.Add(time.Minute), record{domain: "a.com", client: "c2", rcode: "NOERROR"})
+	w.add(t0.Add(time.Minute), record{domain: "b.com", client: "c1", rcode: "NXDOMAIN", blocked: true})
+	w.add(t0.Add(time.Minute), record{domain: "c.com", client: "c1", rcode: "NOERROR"}) // over max keys
+
+	s := w.stats(t0.Add(2*time.Minute), 10)
+	if s.Total != 4 || s.Blocked != 1 || s.Rcodes["NOERROR"] != 3 || s.Rcodes["NXDOMAIN"] != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.AvgElapsedMs != 1 {
+		t.Fatalf("want avg elapsed 1ms, got %v", s.AvgElapsedMs)
+	}
+	if len(s.Series) != 3 || s.Series[0] != (Point{Total: 1}) || s.Series[1] != (Point{Total: 3, Blocked: 1}) || s.Series[2] != (Point{}) {
+		t.Fatalf("unexpected series %v", s.Series)
+	}
+	if s.TopDomains[0] != (Entry{Name: "a.com", Count: 2}) {
+		t.Fatalf("unexpected top domains %v", s.TopDomains)
+	}
+	if len(s.TopDomains) != 3 || s.TopDomains[1] != (Entry{Name: keyOthers, Count: 1}) {
+		t.Fatalf("new keys should go to others when the bucket is full, %v", s.TopDomains)
+	}
+	if s.TopClients[0] != (Entry{Name: "c1", Count: 3}) {
+		t.Fatalf("unexpected top clients %v", s.TopClients)
+	}
+	if len(s.TopBlockedDomains) != 1 || s.TopBlockedDomains[0].Name != "b.com" {
+		t.Fatalf("unexpected top blocked domains %v", s.TopBlockedDomains)
+	}
+
+	// The first bucket expired.
+	s = w.stats(t0.Add(3*time.Minute), 1)
+	if s.Total != 3 || len(s.TopDomains) != 1 {
+		t.Fatalf("unexpected stats after expiration %+v", s)
+	}
+
+	// Bucket of t0 is reused.
+	w.add(t0.Add(3*time.Minute), record{domain: "d.com", rcode: "NOERROR"})
+	s = w.stats(t0.Add(3*time.Minute), 10)
+	if s.Total != 4 {
+		t.Fatalf("unexpected stats after rotation %+v", s)
+	}
+}
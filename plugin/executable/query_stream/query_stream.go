@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_stream
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/sse"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "query_stream"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Buffer is the number of events buffered for each subscriber.
+	// Events will be dropped if the subscriber is too slow.
+	// Default is 256.
+	Buffer int `yaml:"buffer"`
+	// MaxSubscribers limits the number of concurrent subscribers.
+	// Default is 16.
+	MaxSubscribers int `yaml:"max_subscribers"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Buffer, 256)
+	utils.SetDefaultUnsignNum(&a.MaxSubscribers, 16)
+}
+
+// Event is a query event that will be sent to subscribers.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	QName    string    `json:"qname"`
+	QType    string    `json:"qtype"`
+	// Verdict is the rcode of the response, or "error" if the query
+	// failed, or "no_response" if no response was made.
+	Verdict   string   `json:"verdict"`
+	Answers   []string `json:"answers,omitempty"`
+	Upstream  string   `json:"upstream,omitempty"`
+	LatencyMs float64  `json:"latency_ms"`
+}
+
+var _ sequence.RecursiveExecutable = (*QueryStream)(nil)
+var _ coremain.MemoryShrinker = (*QueryStream)(nil)
+
+// QueryStream sends events of queries that passed through it to
+// subscribers of its api "/stream" as server-sent events.
+type QueryStream struct {
+	args *Args
+	hub  *sse.Hub[*Event]
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	p := NewQueryStream(args.(*Args))
+	bp.RegAPI(p.Api())
+	return p, nil
+}
+
+func NewQueryStream(args *Args) *QueryStream {
+	args.init()
+	return &QueryStream{
+		args: args,
+		hub:  sse.NewHub[*Event](args.Buffer, args.MaxSubscribers),
+	}
+}
+
+func (p *QueryStream) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	if p.hub.Len() > 0 {
+		p.hub.Publish(newEvent(qCtx, err))
+	}
+	return err
+}
+
+func newEvent(qCtx *query_context.Context, err error) *Event {
+	question := qCtx.QQuestion()
+	e := &Event{
+		Time:      qCtx.StartTime(),
+		ClientID:  qCtx.ServerMeta.ClientID,
+		QName:     question.Name,
+		QType:     dns.TypeToString[question.Qtype],
+		LatencyMs: float64(time.Since(qCtx.StartTime())) / float64(time.Millisecond),
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		e.Client = addr.String()
+	}
+	r := qCtx.R()
+	switch {
+	case err != nil:
+		e.Verdict = "error"
+	case r == nil:
+		e.Verdict = "no_response"
+	default:
+		e.Verdict = dns.RcodeToString[r.Rcode]
+		for _, rr := range r.Answer {
+			e.Answers = append(e.Answers, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	if v, ok := qCtx.GetValue(query_context.KeyUpstream); ok {
+		e.Upstream, _ = v.(string)
+	}
+	return e
+}
+
+// ShrinkMemory implements coremain.MemoryShrinker.
+func (p *QueryStream) ShrinkMemory(ratio float64) {
+	p.hub.SetBufferLimit(int(float64(p.args.Buffer) * ratio))
+}
+
+func (p *QueryStream) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/stream", p.serveStream)
+	return r
+}
+
+// serveStream streams events as server-sent events. Optional url query
+// parameters "client" and "qname" filter events by client address and
+// qname suffix.
+func (p *QueryStream) serveStream(w http.ResponseWriter, req *http.Request) {
+	client := req.URL.Query().Get("client")
+	qname := dns.Fqdn(strings.ToLower(req.URL.Query().Get("qname")))
+	p.hub.Serve(w, req, func(e *Event) bool {
+		if len(client) > 0 && e.Client != client {
+			return false
+		}
+		return qname == "." || dns.IsSubDomain(qname, strings.ToLower(e.QName))
+	})
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+func TestQueryStream(t *testing.T) {
+	p := NewQueryStream(&Args{})
+	srv := httptest.NewServer(p.Api())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream?qname=example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	var next sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetRcode(qCtx.Q(), dns.RcodeNameError)
+		qCtx.SetResponse(r)
+		qCtx.StoreValue(query_context.KeyUpstream, "u1")
+		return nil
+	}
+	cw := sequence.NewChainWalker([]*sequence.ChainNode{{E: next}}, nil)
+	exec := func(name string) {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		if err := p.Exec(context.Background(), query_context.NewContext(q), cw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.hub.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber is not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	exec("example.org.") // filtered
+	exec("www.example.com.")
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := new(Event)
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), e); err != nil {
+		t.Fatalf("invalid event %q, %v", line, err)
+	}
+	if e.QName != "www.example.com." || e.Verdict != "NXDOMAIN" || e.Upstream != "u1" || e.QType != "A" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote_plugin
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a simple circuit breaker. It opens after threshold
+// consecutive failures and rejects calls for cooldown. After that,
+// it lets one call through to probe the remote. Only the result of the
+// probe closes or re-opens the breaker. Results of calls that were
+// allowed before the breaker opened are ignored while it is open.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	m        sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// ticket is returned by allow. It must be passed to done or release
+// when the call ends.
+type ticket struct {
+	probe bool // the call is the probe of an open breaker.
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call is allowed now.
+func (b *breaker) allow(now time.Time) (ticket, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.failures < b.threshold {
+		return ticket{}, true
+	}
+	if now.Sub(b.openedAt) < b.cooldown || b.probing {
+		return ticket{}, false
+	}
+	b.probing = true
+	return ticket{probe: true}, true
+}
+
+// release ends a call that was allowed by allow without recording
+// its result, e.g. the call was canceled by the caller.
+func (b *breaker) release(t ticket) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if t.probe {
+		b.probing = false
+	}
+}
+
+// done records the result of a call that was allowed by allow.
+func (b *breaker) done(t ticket, ok bool, now time.Time) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if t.probe {
+		b.probing = false
+		if ok {
+			b.failures = 0
+		} else {
+			b.openedAt = now
+		}
+		return
+	}
+	if b.failures >= b.threshold { // opened by other calls, wait for the probe.
+		return
+	}
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = now
+	}
+}
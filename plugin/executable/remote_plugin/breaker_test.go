@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote_plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_breaker(t *testing.T) {
+	b := newBreaker(2, time.Second)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		tk, ok := b.allow(now)
+		if !ok {
+			t.Fatal("breaker should be closed")
+		}
+		b.done(tk, false, now)
+	}
+	if _, ok := b.allow(now); ok {
+		t.Fatal("breaker should be open")
+	}
+
+	now = now.Add(time.Second)
+	probe, ok := b.allow(now)
+	if !ok || !probe.probe {
+		t.Fatal("breaker should allow a probe")
+	}
+	if _, ok := b.allow(now); ok {
+		t.Fatal("breaker should allow only one probe")
+	}
+	b.done(probe, false, now)
+	if _, ok := b.allow(now); ok {
+		t.Fatal("failed probe should re-open the breaker")
+	}
+
+	now = now.Add(time.Second)
+	probe, ok = b.allow(now)
+	if !ok {
+		t.Fatal("breaker should allow a probe")
+	}
+	b.done(probe, true, now)
+	if tk, ok := b.allow(now); !ok || tk.probe {
+		t.Fatal("breaker should be closed after a successful probe")
+	}
+}
+
+func Test_breaker_release(t *testing.T) {
+	b := newBreaker(1, time.Second)
+	now := time.Now()
+	tk, _ := b.allow(now)
+	b.done(tk, false, now)
+
+	now = now.Add(time.Second)
+	probe, ok := b.allow(now)
+	if !ok {
+		t.Fatal("breaker should allow a probe")
+	}
+	b.release(probe)
+	probe, ok = b.allow(now)
+	if !ok {
+		t.Fatal("released probe should allow another probe")
+	}
+	b.done(probe, false, now)
+	if _, ok := b.allow(now); ok {
+		t.Fatal("breaker should be open")
+	}
+}
+
+// Calls that were allowed before the breaker opened must not unlock
+// extra probes, close the breaker or extend its cooldown.
+func Test_breaker_staleCalls(t *testing.T) {
+	b := newBreaker(1, time.Second)
+	start := time.Now()
+
+	const n = 16
+	stale := make([]ticket, n)
+	for i := range stale {
+		tk, ok := b.allow(start)
+		if !ok {
+			t.Fatal("breaker should be closed")
+		}
+		stale[i] = tk
+	}
+	b.done(stale[0], false, start) // opens the breaker.
+
+	// Stale failures after the cooldown would push it out.
+	now := start.Add(time.Second)
+	probe, ok := b.allow(now)
+	if !ok {
+		t.Fatal("breaker should allow a probe")
+	}
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	extraProbes := 0
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			switch i % 3 {
+			case 0:
+				b.done(stale[i], true, now)
+			case 1:
+				b.done(stale[i], false, now)
+			default:
+				b.release(stale[i])
+			}
+			if _, ok := b.allow(now); ok {
+				m.Lock()
+				extraProbes++
+				m.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if extraProbes != 0 {
+		t.Fatalf("stale calls unlocked %d extra probes", extraProbes)
+	}
+
+	b.done(probe, false, now)
+	if _, ok := b.allow(now.Add(time.Second - time.Nanosecond)); ok {
+		t.Fatal("failed probe should re-open the breaker")
+	}
+	if _, ok := b.allow(now.Add(time.Second)); !ok {
+		t.Fatal("cooldown should start from the probe failure")
+	}
+}
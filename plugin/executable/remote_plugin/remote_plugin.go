@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote_plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const PluginType = "remote_plugin"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type Args struct {
+	// Addr is a gRPC target, e.g. "127.0.0.1:50051", "unix:///run/policy.sock".
+	Addr string `yaml:"addr"`
+	// Timeout in milliseconds for each call. Default is 500.
+	Timeout            int  `yaml:"timeout"`
+	TLS                bool `yaml:"tls"`
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// FailureThreshold is the number of consecutive failures that opens
+	// the circuit breaker. Default is 5.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Cooldown in seconds before the opened circuit breaker allows a probe call.
+	// Default is 10.
+	Cooldown int `yaml:"cooldown"`
+	// FailOpen ignores errors (and the opened circuit breaker) and
+	// lets the query continue as if the remote returned ACTION_PASS.
+	FailOpen bool `yaml:"fail_open"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Timeout, 500)
+	utils.SetDefaultUnsignNum(&a.FailureThreshold, 5)
+	utils.SetDefaultUnsignNum(&a.Cooldown, 10)
+}
+
+var _ sequence.Executable = (*RemotePlugin)(nil)
+var _ sequence.Matcher = (*RemotePlugin)(nil)
+
+// RemotePlugin calls a remote RemotePluginServer for each query.
+type RemotePlugin struct {
+	args   *Args
+	logger *zap.Logger
+	conn   *grpc.ClientConn
+	client RemotePluginClient
+	b      *breaker
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewRemotePlugin(args.(*Args), bp.L())
+}
+
+// NewRemotePlugin creates a new RemotePlugin. logger can be nil.
+// The connection is lazily established.
+func NewRemotePlugin(args *Args, logger *zap.Logger) (*RemotePlugin, error) {
+	args.init()
+	if len(args.Addr) == 0 {
+		return nil, errors.New("missing addr")
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	creds := insecure.NewCredentials()
+	if args.TLS {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: args.InsecureSkipVerify})
+	}
+	conn, err := grpc.NewClient(args.Addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to init grpc client, %w", err)
+	}
+	return &RemotePlugin{
+		args:   args,
+		logger: logger,
+		conn:   conn,
+		client: NewRemotePluginClient(conn),
+		b:      newBreaker(args.FailureThreshold, time.Duration(args.Cooldown)*time.Second),
+	}, nil
+}
+
+// call calls the remote. Only errors of the remote are counted by the
+// circuit breaker. Local errors and calls canceled by ctx are not.
+func (p *RemotePlugin) call(ctx context.Context, qCtx *query_context.Context) (*ExecResponse, error) {
+	req, err := newExecRequest(qCtx)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.b.allow(time.Now())
+	if !ok {
+		return nil, errCircuitOpen
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, time.Duration(p.args.Timeout)*time.Millisecond)
+	defer cancel()
+	resp, err := p.client.Exec(callCtx, req)
+	if err != nil && ctx.Err() != nil {
+		p.b.release(t)
+		return nil, err
+	}
+	p.b.done(t, err == nil, time.Now())
+	return resp, err
+}
+
+func newExecRequest(qCtx *query_context.Context) (*ExecRequest, error) {
+	q, err := qCtx.Q().Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query, %w", err)
+	}
+	req := &ExecRequest{
+		Id:         qCtx.Id(),
+		Query:      q,
+		ServerName: qCtx.ServerMeta.ServerName,
+		UrlPath:    qCtx.ServerMeta.UrlPath,
+		FromUdp:    qCtx.ServerMeta.FromUDP,
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		req.ClientAddr = addr.String()
+	}
+	if r := qCtx.R(); r != nil {
+		b, err := pool.PackBuffer(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pack response, %w", err)
+		}
+		req.Response = append([]byte(nil), *b...)
+		pool.ReleaseBuf(b)
+	}
+	return req, nil
+}
+
+// Exec implements sequence.Executable.
+func (p *RemotePlugin) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	resp, err := p.call(ctx, qCtx)
+	if err != nil {
+		if p.args.FailOpen {
+			p.logger.Warn("remote call failed", qCtx.InfoField(), zap.Error(err))
+			return nil
+		}
+		return fmt.Errorf("remote_plugin: %w", err)
+	}
+
+	switch resp.GetAction() {
+	case Action_ACTION_PASS:
+	case Action_ACTION_RESPONSE:
+		r := new(dns.Msg)
+		if err := r.Unpack(resp.GetResponse()); err != nil {
+			return fmt.Errorf("remote_plugin: invalid response msg, %w", err)
+		}
+		r.Id = qCtx.Q().Id
+		qCtx.SetResponse(r)
+	case Action_ACTION_REJECT:
+		rcode := int(resp.GetRcode())
+		if rcode == 0 {
+			rcode = dns.RcodeRefused
+		}
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Rcode = rcode
+		qCtx.SetResponse(r)
+	default:
+		return fmt.Errorf("remote_plugin: unknown action %s", resp.GetAction())
+	}
+	return nil
+}
+
+// Match implements sequence.Matcher.
+func (p *RemotePlugin) Match(ctx context.Context, qCtx *query_context.Context) (bool, error) {
+	resp, err := p.call(ctx, qCtx)
+	if err != nil {
+		if p.args.FailOpen {
+			p.logger.Warn("remote call failed", qCtx.InfoField(), zap.Error(err))
+			return false, nil
+		}
+		return false, fmt.Errorf("remote_plugin: %w", err)
+	}
+	return resp.GetMatch(), nil
+}
+
+func (p *RemotePlugin) Close() error {
+	return p.conn.Close()
+}
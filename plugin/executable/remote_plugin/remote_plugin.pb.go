@@ -0,0 +1,321 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: plugin/executable/remote_plugin/remote_plugin.proto
+
+package remote_plugin
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Action int32
+
+const (
+	// Do nothing.
+	Action_ACTION_PASS Action = 0
+	// Use ExecResponse.response as the response.
+	Action_ACTION_RESPONSE Action = 1
+	// Reply with ExecResponse.rcode.
+	Action_ACTION_REJECT Action = 2
+)
+
+// Enum value maps for Action.
+var (
+	Action_name = map[int32]string{
+		0: "ACTION_PASS",
+		1: "ACTION_RESPONSE",
+		2: "ACTION_REJECT",
+	}
+	Action_value = map[string]int32{
+		"ACTION_PASS":     0,
+		"ACTION_RESPONSE": 1,
+		"ACTION_REJECT":   2,
+	}
+)
+
+func (x Action) Enum() *Action {
+	p := new(Action)
+	*p = x
+	return p
+}
+
+func (x Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_plugin_executable_remote_plugin_remote_plugin_proto_enumTypes[0].Descriptor()
+}
+
+func (Action) Type() protoreflect.EnumType {
+	return &file_plugin_executable_remote_plugin_remote_plugin_proto_enumTypes[0]
+}
+
+func (x Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Action.Descriptor instead.
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+type ExecRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Query in wire format.
+	Query []byte `protobuf:"bytes,2,opt,name=query,proto3" json:"query,omitempty"`
+	// Current response in wire format. Empty if there is no response yet.
+	Response      []byte `protobuf:"bytes,3,opt,name=response,proto3" json:"response,omitempty"`
+	ClientAddr    string `protobuf:"bytes,4,opt,name=client_addr,json=clientAddr,proto3" json:"client_addr,omitempty"`
+	ServerName    string `protobuf:"bytes,5,opt,name=server_name,json=serverName,proto3" json:"server_name,omitempty"`
+	UrlPath       string `protobuf:"bytes,6,opt,name=url_path,json=urlPath,proto3" json:"url_path,omitempty"`
+	FromUdp       bool   `protobuf:"varint,7,opt,name=from_udp,json=fromUdp,proto3" json:"from_udp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecRequest) Reset() {
+	*x = ExecRequest{}
+	mi := &file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecRequest) ProtoMessage() {}
+
+func (x *ExecRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecRequest.ProtoReflect.Descriptor instead.
+func (*ExecRequest) Descriptor() ([]byte, []int) {
+	return file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ExecRequest) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ExecRequest) GetQuery() []byte {
+	if x != nil {
+		return x.Query
+	}
+	return nil
+}
+
+func (x *ExecRequest) GetResponse() []byte {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *ExecRequest) GetClientAddr() string {
+	if x != nil {
+		return x.ClientAddr
+	}
+	return ""
+}
+
+func (x *ExecRequest) GetServerName() string {
+	if x != nil {
+		return x.ServerName
+	}
+	return ""
+}
+
+func (x *ExecRequest) GetUrlPath() string {
+	if x != nil {
+		return x.UrlPath
+	}
+	return ""
+}
+
+func (x *ExecRequest) GetFromUdp() bool {
+	if x != nil {
+		return x.FromUdp
+	}
+	return false
+}
+
+type ExecResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Action Action                 `protobuf:"varint,1,opt,name=action,proto3,enum=remote_plugin.Action" json:"action,omitempty"`
+	// Response in wire format. Required by ACTION_RESPONSE.
+	Response []byte `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	// Rcode for ACTION_REJECT. Default is REFUSED.
+	Rcode int32 `protobuf:"varint,3,opt,name=rcode,proto3" json:"rcode,omitempty"`
+	// Result when this plugin is used as a matcher.
+	Match         bool `protobuf:"varint,4,opt,name=match,proto3" json:"match,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecResponse) Reset() {
+	*x = ExecResponse{}
+	mi := &file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecResponse) ProtoMessage() {}
+
+func (x *ExecResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecResponse.ProtoReflect.Descriptor instead.
+func (*ExecResponse) Descriptor() ([]byte, []int) {
+	return file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ExecResponse) GetAction() Action {
+	if x != nil {
+		return x.Action
+	}
+	return Action_ACTION_PASS
+}
+
+func (x *ExecResponse) GetResponse() []byte {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *ExecResponse) GetRcode() int32 {
+	if x != nil {
+		return x.Rcode
+	}
+	return 0
+}
+
+func (x *ExecResponse) GetMatch() bool {
+	if x != nil {
+		return x.Match
+	}
+	return false
+}
+
+var File_plugin_executable_remote_plugin_remote_plugin_proto protoreflect.FileDescriptor
+
+const file_plugin_executable_remote_plugin_remote_plugin_proto_rawDesc = "" +
+	"\n" +
+	"3plugin/executable/remote_plugin/remote_plugin.proto\x12\rremote_plugin\"\xc7\x01\n" +
+	"\vExecRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\rR\x02id\x12\x14\n" +
+	"\x05query\x18\x02 \x01(\fR\x05query\x12\x1a\n" +
+	"\bresponse\x18\x03 \x01(\fR\bresponse\x12\x1f\n" +
+	"\vclient_addr\x18\x04 \x01(\tR\n" +
+	"clientAddr\x12\x1f\n" +
+	"\vserver_name\x18\x05 \x01(\tR\n" +
+	"serverName\x12\x19\n" +
+	"\burl_path\x18\x06 \x01(\tR\aurlPath\x12\x19\n" +
+	"\bfrom_udp\x18\a \x01(\bR\afromUdp\"\x85\x01\n" +
+	"\fExecResponse\x12-\n" +
+	"\x06action\x18\x01 \x01(\x0e2\x15.remote_plugin.ActionR\x06action\x12\x1a\n" +
+	"\bresponse\x18\x02 \x01(\fR\bresponse\x12\x14\n" +
+	"\x05rcode\x18\x03 \x01(\x05R\x05rcode\x12\x14\n" +
+	"\x05match\x18\x04 \x01(\bR\x05match*A\n" +
+	"\x06Action\x12\x0f\n" +
+	"\vACTION_PASS\x10\x00\x12\x13\n" +
+	"\x0fACTION_RESPONSE\x10\x01\x12\x11\n" +
+	"\rACTION_REJECT\x10\x022O\n" +
+	"\fRemotePlugin\x12?\n" +
+	"\x04Exec\x12\x1a.remote_plugin.ExecRequest\x1a\x1b.remote_plugin.ExecResponseB!Z\x1fplugin/executable/remote_pluginb\x06proto3"
+
+var (
+	file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescOnce sync.Once
+	file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescData []byte
+)
+
+func file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescGZIP() []byte {
+	file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescOnce.Do(func() {
+		file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_plugin_executable_remote_plugin_remote_plugin_proto_rawDesc), len(file_plugin_executable_remote_plugin_remote_plugin_proto_rawDesc)))
+	})
+	return file_plugin_executable_remote_plugin_remote_plugin_proto_rawDescData
+}
+
+var file_plugin_executable_remote_plugin_remote_plugin_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_plugin_executable_remote_plugin_remote_plugin_proto_goTypes = []any{
+	(Action)(0),          // 0: remote_plugin.Action
+	(*ExecRequest)(nil),  // 1: remote_plugin.ExecRequest
+	(*ExecResponse)(nil), // 2: remote_plugin.ExecResponse
+}
+var file_plugin_executable_remote_plugin_remote_plugin_proto_depIdxs = []int32{
+	0, // 0: remote_plugin.ExecResponse.action:type_name -> remote_plugin.Action
+	1, // 1: remote_plugin.RemotePlugin.Exec:input_type -> remote_plugin.ExecRequest
+	2, // 2: remote_plugin.RemotePlugin.Exec:output_type -> remote_plugin.ExecResponse
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_plugin_executable_remote_plugin_remote_plugin_proto_init() }
+func file_plugin_executable_remote_plugin_remote_plugin_proto_init() {
+	if File_plugin_executable_remote_plugin_remote_plugin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_plugin_executable_remote_plugin_remote_plugin_proto_rawDesc), len(file_plugin_executable_remote_plugin_remote_plugin_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_plugin_executable_remote_plugin_remote_plugin_proto_goTypes,
+		DependencyIndexes: file_plugin_executable_remote_plugin_remote_plugin_proto_depIdxs,
+		EnumInfos:         file_plugin_executable_remote_plugin_remote_plugin_proto_enumTypes,
+		MessageInfos:      file_plugin_executable_remote_plugin_remote_plugin_proto_msgTypes,
+	}.Build()
+	File_plugin_executable_remote_plugin_remote_plugin_proto = out.File
+	file_plugin_executable_remote_plugin_remote_plugin_proto_goTypes = nil
+	file_plugin_executable_remote_plugin_remote_plugin_proto_depIdxs = nil
+}
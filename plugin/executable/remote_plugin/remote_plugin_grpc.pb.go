@@ -0,0 +1,125 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: plugin/executable/remote_plugin/remote_plugin.proto
+
+package remote_plugin
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RemotePlugin_Exec_FullMethodName = "/remote_plugin.RemotePlugin/Exec"
+)
+
+// RemotePluginClient is the client API for RemotePlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// RemotePlugin is implemented by an external policy engine.
+type RemotePluginClient interface {
+	Exec(ctx context.Context, in *ExecRequest, opts ...grpc.CallOption) (*ExecResponse, error)
+}
+
+type remotePluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRemotePluginClient(cc grpc.ClientConnInterface) RemotePluginClient {
+	return &remotePluginClient{cc}
+}
+
+func (c *remotePluginClient) Exec(ctx context.Context, in *ExecRequest, opts ...grpc.CallOption) (*ExecResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExecResponse)
+	err := c.cc.Invoke(ctx, RemotePlugin_Exec_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RemotePluginServer is the server API for RemotePlugin service.
+// All implementations must embed UnimplementedRemotePluginServer
+// for forward compatibility.
+//
+// RemotePlugin is implemented by an external policy engine.
+type RemotePluginServer interface {
+	Exec(context.Context, *ExecRequest) (*ExecResponse, error)
+	mustEmbedUnimplementedRemotePluginServer()
+}
+
+// UnimplementedRemotePluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRemotePluginServer struct{}
+
+func (UnimplementedRemotePluginServer) Exec(context.Context, *ExecRequest) (*ExecResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Exec not implemented")
+}
+func (UnimplementedRemotePluginServer) mustEmbedUnimplementedRemotePluginServer() {}
+func (UnimplementedRemotePluginServer) testEmbeddedByValue()                      {}
+
+// UnsafeRemotePluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RemotePluginServer will
+// result in compilation errors.
+type UnsafeRemotePluginServer interface {
+	mustEmbedUnimplementedRemotePluginServer()
+}
+
+func RegisterRemotePluginServer(s grpc.ServiceRegistrar, srv RemotePluginServer) {
+	// If the following call pancis, it indicates UnimplementedRemotePluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RemotePlugin_ServiceDesc, srv)
+}
+
+func _RemotePlugin_Exec_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExecRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RemotePluginServer).Exec(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RemotePlugin_Exec_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RemotePluginServer).Exec(ctx, req.(*ExecRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RemotePlugin_ServiceDesc is the grpc.ServiceDesc for RemotePlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RemotePlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "remote_plugin.RemotePlugin",
+	HandlerType: (*RemotePluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Exec",
+			Handler:    _RemotePlugin_Exec_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin/executable/remote_plugin/remote_plugin.proto",
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package remote_plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// fakeClient fails all calls with err, or blocks until the call is
+// canceled if err is nil.
+type fakeClient struct {
+	err   error
+	calls int
+}
+
+func (c *fakeClient) Exec(ctx context.Context, _ *ExecRequest, _ ...grpc.CallOption) (*ExecResponse, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func newTestPlugin(c RemotePluginClient) *RemotePlugin {
+	args := &Args{Addr: "127.0.0.1:1", FailureThreshold: 1}
+	args.init()
+	return &RemotePlugin{
+		args:   args,
+		logger: zap.NewNop(),
+		client: c,
+		b:      newBreaker(args.FailureThreshold, time.Hour),
+	}
+}
+
+func TestRemotePlugin_call_breaker(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Calls canceled by the caller are not remote failures.
+	c := new(fakeClient)
+	p := newTestPlugin(c)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := p.call(ctx, query_context.NewContext(q)); err == nil {
+		t.Fatal("canceled call should fail")
+	}
+	if _, ok := p.b.allow(time.Now()); !ok {
+		t.Fatal("canceled call should not open the breaker")
+	}
+
+	// Local errors are not remote failures and the remote is not called.
+	c = new(fakeClient)
+	p = newTestPlugin(c)
+	bad := new(dns.Msg)
+	bad.SetQuestion(strings.Repeat("a", 64)+".com.", dns.TypeA)
+	if _, err := p.call(context.Background(), query_context.NewContext(bad)); err == nil {
+		t.Fatal("query that cannot be packed should fail")
+	}
+	if _, ok := p.b.allow(time.Now()); c.calls != 0 || !ok {
+		t.Fatal("local error should not be counted")
+	}
+
+	// Remote errors are.
+	c = &fakeClient{err: errors.New("unavailable")}
+	p = newTestPlugin(c)
+	if _, err := p.call(context.Background(), query_context.NewContext(q)); err == nil {
+		t.Fatal("remote error should fail")
+	}
+	if _, err := p.call(context.Background(), query_context.NewContext(q)); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("want errCircuitOpen, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "route"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+var _ sequence.Executable = (*routePlugin)(nil)
+
+type Args struct {
+	// Table is the routing table id. Default is the main table (254).
+	Table int `yaml:"table"`
+	// Device is the output interface, e.g. "wg0". Required if Gateway is empty.
+	Device string `yaml:"device"`
+	// Gateway is the next hop address. Optional. If it is set, only ips
+	// of the same family as the gateway are routed.
+	Gateway string `yaml:"gateway"`
+	// Metric (priority) of the routes. Optional.
+	Metric int `yaml:"metric"`
+	// Timeout in seconds. Routes that were not refreshed by
+	// responses in timeout will be removed. Default (0) is never.
+	Timeout int `yaml:"timeout"`
+	// Flush removes all routes installed by this plugin on exit.
+	Flush bool `yaml:"flush"`
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return newRoutePlugin(args.(*Args), bp.L())
+}
+
+// parseGateway validates args and returns the gateway, which is invalid
+// if args.Gateway is empty.
+func parseGateway(args *Args) (netip.Addr, error) {
+	if len(args.Device) == 0 && len(args.Gateway) == 0 {
+		return netip.Addr{}, errors.New("device or gateway is required")
+	}
+	if args.Timeout < 0 {
+		return netip.Addr{}, errors.New("invalid negative timeout")
+	}
+	if len(args.Gateway) == 0 {
+		return netip.Addr{}, nil
+	}
+	gw, err := netip.ParseAddr(args.Gateway)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid gateway, %w", err)
+	}
+	return gw.Unmap(), nil
+}
+
+// routeAddrs returns ips in A and AAAA records of rrs that can be routed
+// via gw. An ipv4 gateway cannot route ipv6 ips and vice versa. All ips
+// are routable via a device if gw is invalid.
+func routeAddrs(rrs []dns.RR, gw netip.Addr) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range rrs {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A)
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+		addr = addr.Unmap()
+		if !addr.IsValid() || (gw.IsValid() && addr.Is4() != gw.Is4()) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
@@ -0,0 +1,178 @@
+//go:build linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package route
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const gcInterval = time.Second * 30
+
+type routePlugin struct {
+	args      *Args
+	logger    *zap.Logger
+	linkIndex int
+	gwAddr    netip.Addr // invalid if no gateway
+	gw        net.IP
+
+	m           sync.Mutex
+	routes      map[netip.Addr]time.Time // installed routes and their expiration time
+	closed      bool
+	closeNotify chan struct{}
+}
+
+func newRoutePlugin(args *Args, logger *zap.Logger) (*routePlugin, error) {
+	gw, err := parseGateway(args)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &routePlugin{
+		args:        args,
+		gwAddr:      gw,
+		logger:      logger,
+		routes:      make(map[netip.Addr]time.Time),
+		closeNotify: make(chan struct{}),
+	}
+	if len(args.Device) > 0 {
+		l, err := netlink.LinkByName(args.Device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find device %s, %w", args.Device, err)
+		}
+		p.linkIndex = l.Attrs().Index
+	}
+	if gw.IsValid() {
+		p.gw = gw.AsSlice()
+	}
+
+	if args.Timeout > 0 {
+		go p.gcLoop()
+	}
+	return p, nil
+}
+
+// Exec adds routes for ips in the response. Errors (e.g. missing
+// CAP_NET_ADMIN) are logged, so they won't fail the query.
+func (p *routePlugin) Exec(_ context.Context, qCtx *query_context.Context) error {
+	r := qCtx.R()
+	if r == nil {
+		return nil
+	}
+	for _, addr := range routeAddrs(r.Answer, p.gwAddr) {
+		if err := p.addRoute(addr); err != nil {
+			p.logger.Warn("failed to add route", zap.Stringer("addr", addr), qCtx.InfoField(), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (p *routePlugin) newRoute(addr netip.Addr) *netlink.Route {
+	rt := &netlink.Route{
+		LinkIndex: p.linkIndex,
+		Dst:       &net.IPNet{IP: addr.AsSlice(), Mask: net.CIDRMask(addr.BitLen(), addr.BitLen())},
+		Gw:        p.gw,
+		Priority:  p.args.Metric,
+		Table:     p.args.Table,
+		Family:    unix.AF_INET,
+	}
+	if addr.Is6() {
+		rt.Family = unix.AF_INET6
+	}
+	if p.gw == nil {
+		rt.Scope = netlink.SCOPE_LINK
+	}
+	return rt
+}
+
+func (p *routePlugin) addRoute(addr netip.Addr) error {
+	var expire time.Time
+	if p.args.Timeout > 0 {
+		expire = time.Now().Add(time.Duration(p.args.Timeout) * time.Second)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return nil
+	}
+	if _, ok := p.routes[addr]; ok {
+		p.routes[addr] = expire
+		return nil
+	}
+	if err := netlink.RouteReplace(p.newRoute(addr)); err != nil {
+		return err
+	}
+	p.routes[addr] = expire
+	return nil
+}
+
+func (p *routePlugin) gcLoop() {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeNotify:
+			return
+		case now := <-ticker.C:
+			p.m.Lock()
+			for addr, expire := range p.routes {
+				if now.After(expire) {
+					p.delRouteLocked(addr)
+				}
+			}
+			p.m.Unlock()
+		}
+	}
+}
+
+func (p *routePlugin) delRouteLocked(addr netip.Addr) {
+	delete(p.routes, addr)
+	if err := netlink.RouteDel(p.newRoute(addr)); err != nil {
+		p.logger.Warn("failed to delete route", zap.Stringer("addr", addr), zap.Error(err))
+	}
+}
+
+func (p *routePlugin) Close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.closeNotify)
+	if p.args.Flush {
+		for addr := range p.routes {
+			p.delRouteLocked(addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package route
+
+import (
+	"context"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"go.uber.org/zap"
+)
+
+type routePlugin struct{}
+
+func newRoutePlugin(args *Args, _ *zap.Logger) (*routePlugin, error) {
+	if _, err := parseGateway(args); err != nil {
+		return nil, err
+	}
+	return &routePlugin{}, nil
+}
+
+func (p *routePlugin) Exec(_ context.Context, _ *query_context.Context) error {
+	return nil
+}
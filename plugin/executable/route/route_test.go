@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package route
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func Test_parseGateway(t *testing.T) {
+	tests := []struct {
+		args    Args
+		want    netip.Addr
+		wantErr bool
+	}{
+		{Args{Device: "wg0"}, netip.Addr{}, false},
+		{Args{Gateway: "192.168.1.1"}, netip.MustParseAddr("192.168.1.1"), false},
+		{Args{Gateway: "::ffff:192.168.1.1"}, netip.MustParseAddr("192.168.1.1"), false},
+		{Args{Gateway: "fd00::1", Device: "wg0"}, netip.MustParseAddr("fd00::1"), false},
+		{Args{}, netip.Addr{}, true},
+		{Args{Gateway: "not an ip"}, netip.Addr{}, true},
+		{Args{Device: "wg0", Timeout: -1}, netip.Addr{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseGateway(&tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseGateway(%+v) = %v, %v, want %v, err %v", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func Test_routeAddrs(t *testing.T) {
+	var rrs []dns.RR
+	for _, s := range []string{
+		"example.com. 60 IN CNAME a.example.com.",
+		"a.example.com. 60 IN A 192.0.2.1",
+		"a.example.com. 60 IN AAAA 2001:db8::1",
+		"a.example.com. 60 IN AAAA ::ffff:192.0.2.2",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	v4 := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}
+	v6 := []netip.Addr{netip.MustParseAddr("2001:db8::1")}
+
+	tests := []struct {
+		gw   string
+		want []netip.Addr
+	}{
+		{"", []netip.Addr{v4[0], v6[0], v4[1]}},
+		{"192.168.1.1", v4},
+		{"fd00::1", v6},
+	}
+	for _, tt := range tests {
+		var gw netip.Addr
+		if len(tt.gw) > 0 {
+			gw = netip.MustParseAddr(tt.gw)
+		}
+		if got := routeAddrs(rrs, gw); !slices.Equal(got, tt.want) {
+			t.Errorf("routeAddrs(gw %s) = %v, want %v", tt.gw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sanitize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+const PluginType = "sanitize"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+// Actions for malformed queries.
+const (
+	ActionPass    = "pass"
+	ActionDrop    = "drop"
+	ActionRefuse  = "refuse"
+	ActionFormErr = "formerr"
+	ActionNotImp  = "notimp"
+)
+
+type Args struct {
+	// Actions can be "pass", "drop", "refuse", "formerr" or "notimp".
+
+	// InvalidQname is the action for qnames that have illegal characters,
+	// empty labels or exceed length limits. Default is "formerr".
+	InvalidQname string `yaml:"invalid_qname"`
+	// UnsupportedClass is the action for queries whose class is not
+	// in AllowedClasses. Default is "refuse".
+	UnsupportedClass string `yaml:"unsupported_class"`
+	// AllowedClasses, e.g. "IN", "CH". Default is ["IN", "CH"].
+	AllowedClasses []string `yaml:"allowed_classes"`
+	// NonQueryOpcode is the action for queries whose opcode is not QUERY.
+	// Default is "notimp".
+	NonQueryOpcode string `yaml:"non_query_opcode"`
+	// AllowUnderscore allows "_" in qname labels (e.g. SRV records).
+	// Default is true.
+	AllowUnderscore *bool `yaml:"allow_underscore"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.InvalidQname, ActionFormErr)
+	utils.SetDefaultString(&a.UnsupportedClass, ActionRefuse)
+	utils.SetDefaultString(&a.NonQueryOpcode, ActionNotImp)
+	if len(a.AllowedClasses) == 0 {
+		a.AllowedClasses = []string{"IN", "CH"}
+	}
+	if a.AllowUnderscore == nil {
+		t := true
+		a.AllowUnderscore = &t
+	}
+}
+
+var _ sequence.Executable = (*Sanitize)(nil)
+
+// Sanitize drops or refuses malformed queries. It should be placed at
+// the beginning of the entry sequence.
+// Queries that do not have exactly one question never reach it, they
+// are dropped by the server.
+type Sanitize struct {
+	invalidQname     string
+	unsupportedClass string
+	nonQueryOpcode   string
+	allowedClasses   map[uint16]struct{}
+	allowUnderscore  bool
+}
+
+func Init(_ *coremain.BP, args any) (any, error) {
+	return NewSanitize(args.(*Args))
+}
+
+// QuickSetup format: [key=action]...
+// e.g. "invalid_qname=drop non_query_opcode=refuse"
+func QuickSetup(_ sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	for _, f := range strings.Fields(s) {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid arg [%s]", f)
+		}
+		switch k {
+		case "invalid_qname":
+			args.InvalidQname = v
+		case "unsupported_class":
+			args.UnsupportedClass = v
+		case "non_query_opcode":
+			args.NonQueryOpcode = v
+		default:
+			return nil, fmt.Errorf("invalid key [%s]", k)
+		}
+	}
+	return NewSanitize(args)
+}
+
+func NewSanitize(args *Args) (*Sanitize, error) {
+	args.init()
+	for _, a := range [...]string{args.InvalidQname, args.UnsupportedClass, args.NonQueryOpcode} {
+		switch a {
+		case ActionPass, ActionDrop, ActionRefuse, ActionFormErr, ActionNotImp:
+		default:
+			return nil, fmt.Errorf("invalid action [%s]", a)
+		}
+	}
+	s := &Sanitize{
+		invalidQname:     args.InvalidQname,
+		unsupportedClass: args.UnsupportedClass,
+		nonQueryOpcode:   args.NonQueryOpcode,
+		allowedClasses:   make(map[uint16]struct{}),
+		allowUnderscore:  *args.AllowUnderscore,
+	}
+	for _, c := range args.AllowedClasses {
+		class, ok := dns.StringToClass[strings.ToUpper(c)]
+		if !ok {
+			return nil, fmt.Errorf("invalid class [%s]", c)
+		}
+		s.allowedClasses[class] = struct{}{}
+	}
+	return s, nil
+}
+
+func (s *Sanitize) Exec(_ context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	action := ActionPass
+	switch {
+	case q.Opcode != dns.OpcodeQuery:
+		action = s.nonQueryOpcode
+	case len(q.Question) != 1: // dropped by the server, just in case
+		return nil
+	default:
+		question := q.Question[0]
+		if _, ok := s.allowedClasses[question.Qclass]; !ok {
+			action = s.unsupportedClass
+		} else if !s.validQname(question.Name) {
+			action = s.invalidQname
+		}
+	}
+
+	var rcode int
+	switch action {
+	case ActionPass:
+		return nil
+	case ActionDrop:
+		return query_context.ErrDropQuery
+	case ActionRefuse:
+		rcode = dns.RcodeRefused
+	case ActionFormErr:
+		rcode = dns.RcodeFormatError
+	case ActionNotImp:
+		rcode = dns.RcodeNotImplemented
+	}
+	r := new(dns.Msg)
+	r.SetRcode(q, rcode)
+	qCtx.SetResponse(r)
+	return nil
+}
+
+// validQname checks that name is a fqdn that only contains letters,
+// digits, hyphens (and underscores) with valid label lengths.
+func (s *Sanitize) validQname(name string) bool {
+	if name == "." {
+		return true
+	}
+	if len(name) > 254 || !strings.HasSuffix(name, ".") {
+		return false
+	}
+	labelLen := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '.':
+			if labelLen == 0 {
+				return false
+			}
+			labelLen = 0
+			continue
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		case c == '_' && s.allowUnderscore:
+		case c == '*' && labelLen == 0 && i+1 < len(name) && name[i+1] == '.':
+		default:
+			return false
+		}
+		labelLen++
+		if labelLen > 63 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sanitize
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func TestSanitize_Exec(t *testing.T) {
+	p, err := QuickSetup(nil, "invalid_qname=drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p.(*Sanitize)
+
+	tests := []struct {
+		name      string
+		qname     string
+		qclass    uint16
+		opcode    int
+		wantDrop  bool
+		wantRcode int // -1 means no response
+	}{
+		{"valid", "www.example.com.", dns.ClassINET, dns.OpcodeQuery, false, -1},
+		{"srv", "_sip._tcp.example.com.", dns.ClassINET, dns.OpcodeQuery, false, -1},
+		{"root", ".", dns.ClassINET, dns.OpcodeQuery, false, -1},
+		{"wildcard", "*.example.com.", dns.ClassINET, dns.OpcodeQuery, false, -1},
+		{"chaos", "version.bind.", dns.ClassCHAOS, dns.OpcodeQuery, false, -1},
+		{"illegal char", "a b.example.com.", dns.ClassINET, dns.OpcodeQuery, true, -1},
+		{"escaped", `a\000.example.com.`, dns.ClassINET, dns.OpcodeQuery, true, -1},
+		{"long label", strings.Repeat("a", 64) + ".com.", dns.ClassINET, dns.OpcodeQuery, true, -1},
+		{"class", "example.com.", dns.ClassHESIOD, dns.OpcodeQuery, false, dns.RcodeRefused},
+		{"opcode", "example.com.", dns.ClassINET, dns.OpcodeNotify, false, dns.RcodeNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qname, dns.TypeA)
+			q.Question[0].Qclass = tt.qclass
+			q.Opcode = tt.opcode
+			qCtx := query_context.NewContext(q)
+			err := s.Exec(context.Background(), qCtx)
+			if drop := errors.Is(err, query_context.ErrDropQuery); drop != tt.wantDrop {
+				t.Fatalf("want drop %v, got err %v", tt.wantDrop, err)
+			}
+			rcode := -1
+			if r := qCtx.R(); r != nil {
+				rcode = r.Rcode
+			}
+			if rcode != tt.wantRcode {
+				t.Fatalf("want rcode %d, got %d", tt.wantRcode, rcode)
+			}
+		})
+	}
+}
+
+func TestQuickSetup(t *testing.T) {
+	for _, s := range []string{"invalid_qname=refuse", "unsupported_class=drop non_query_opcode=pass"} {
+		if _, err := QuickSetup(nil, s); err != nil {
+			t.Errorf("QuickSetup(%q) = %v", s, err)
+		}
+	}
+	// Multiple questions are dropped by the server, there is no such option.
+	for _, s := range []string{"multiple_questions=pass", "invalid_qname=bad", "invalid_qname"} {
+		if _, err := QuickSetup(nil, s); err == nil {
+			t.Errorf("QuickSetup(%q) should fail", s)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"io"
 )
@@ -169,6 +170,7 @@ func (s *Sequence) newMatcher(bq BQ, mc MatchConfig, ri, mi int) (Matcher, error
 	if mc.Reverse {
 		m = reverseMatcher(m)
 	}
+	m = &countedMatcher{m: m, c: s.counters(bq, fmt.Sprintf("r%d.m%d", ri, mi), mc.Tag, mc.Type)}
 	return m, nil
 }
 
@@ -211,7 +213,21 @@ func (s *Sequence) newExec(bq BQ, rc RuleConfig, ri int) (Executable, RecursiveE
 	if re == nil && e == nil {
 		return nil, nil, errors.New("invalid args, initialized object is not executable")
 	}
-	return e, re, nil
+	c := s.counters(bq, fmt.Sprintf("r%d", ri), rc.Tag, rc.Type)
+	if e != nil {
+		return &countedExec{e: e, c: c}, nil, nil
+	}
+	return nil, &countedRecursiveExec{re: re, c: c}, nil
+}
+
+// counters registers the counters of a node. The name will be like
+// "<sequence>.r0.m1:<tag or type>".
+func (s *Sequence) counters(bq BQ, node, tag, typ string) *coremain.PluginCounters {
+	label := tag
+	if len(label) == 0 {
+		label = typ
+	}
+	return bq.M().GetCounterCollector().Register(s.name + "." + node + ":" + label)
 }
 
 func closePlugin(p any) {
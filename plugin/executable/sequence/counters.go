@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Wrappers that record runtime counters, tracing spans and stages
+// (see StageRecorder) of nodes in the chain.
+// Note: latency (and span) of a RecursiveExecutable includes its
+// following nodes.
+
+type countedExec struct {
+	e Executable
+	c *coremain.PluginCounters
+}
+
+func (ce *countedExec) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	ctx, span := tracing.Start(ctx, ce.c.Name(), nil)
+	start := time.Now()
+	err := ce.e.Exec(ctx, qCtx)
+	ce.c.Observe(start, false, err)
+	if r := getStageRecorder(qCtx); r != nil {
+		r.add(ce.c.Name(), start, err)
+	}
+	tracing.End(span, err)
+	return err
+}
+
+type countedRecursiveExec struct {
+	re RecursiveExecutable
+	c  *coremain.PluginCounters
+}
+
+func (cre *countedRecursiveExec) Exec(ctx context.Context, qCtx *query_context.Context, next ChainWalker) error {
+	ctx, span := tracing.Start(ctx, cre.c.Name(), nil)
+	start := time.Now()
+	err := cre.re.Exec(ctx, qCtx, next)
+	cre.c.Observe(start, false, err)
+	if r := getStageRecorder(qCtx); r != nil {
+		r.add(cre.c.Name(), start, err)
+	}
+	tracing.End(span, err)
+	return err
+}
+
+type countedMatcher struct {
+	m Matcher
+	c *coremain.PluginCounters
+}
+
+func (cm *countedMatcher) Match(ctx context.Context, qCtx *query_context.Context) (bool, error) {
+	ctx, span := tracing.Start(ctx, cm.c.Name(), nil)
+	start := time.Now()
+	ok, err := cm.m.Match(ctx, qCtx)
+	cm.c.Observe(start, ok, err)
+	if r := getStageRecorder(qCtx); r != nil {
+		r.addMatch(cm.c.Name(), start, ok, err)
+	}
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Bool("matched", ok))
+	}
+	tracing.End(span, err)
+	return ok, err
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
@@ -50,6 +51,10 @@ type fallback struct {
 	secondary            sequence.Executable
 	fastFallbackDuration time.Duration
 	alwaysStandby        bool
+
+	health      *health // nil if probe is disabled
+	probeQ      *dns.Msg
+	closeNotify chan struct{}
 }
 
 type Args struct {
@@ -63,6 +68,31 @@ type Args struct {
 
 	// AlwaysStandby: secondary should always stand by in fallback.
 	AlwaysStandby bool `yaml:"always_standby"`
+
+	// ProbeInterval in seconds. If set, the primary will be probed in
+	// background. While the primary is unhealthy, queries go to the
+	// secondary directly without waiting for the primary.
+	ProbeInterval int `yaml:"probe_interval"`
+	// ProbeDomain is the domain of A queries sent to the primary for probing.
+	// Default is "dns.google".
+	ProbeDomain string `yaml:"probe_domain"`
+	// ProbeTimeout in milliseconds. Default is 2000.
+	ProbeTimeout int `yaml:"probe_timeout"`
+	// ErrorWindow is the number of recent queries used to calculate the
+	// error rate of the primary. If the error rate exceeds MaxErrorRate,
+	// the primary is unhealthy until a probe succeeds.
+	// Requires ProbeInterval. Default is 0 (disabled).
+	ErrorWindow int `yaml:"error_window"`
+	// MaxErrorRate in (0, 1]. Default is 0.5.
+	MaxErrorRate float64 `yaml:"max_error_rate"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.ProbeDomain, "dns.google")
+	utils.SetDefaultUnsignNum(&a.ProbeTimeout, 2000)
+	if a.MaxErrorRate <= 0 || a.MaxErrorRate > 1 {
+		a.MaxErrorRate = 0.5
+	}
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
@@ -70,6 +100,7 @@ func Init(bp *coremain.BP, args any) (any, error) {
 }
 
 func newFallbackPlugin(bp *coremain.BP, args *Args) (*fallback, error) {
+	args.init()
 	if len(args.Primary) == 0 || len(args.Secondary) == 0 {
 		return nil, errors.New("args missing primary or secondary")
 	}
@@ -93,10 +124,52 @@ func newFallbackPlugin(bp *coremain.BP, args *Args) (*fallback, error) {
 		secondary:            se,
 		fastFallbackDuration: threshold,
 		alwaysStandby:        args.AlwaysStandby,
+		closeNotify:          make(chan struct{}),
+	}
+	if args.ProbeInterval > 0 {
+		s.health = newHealth(args.ErrorWindow, args.MaxErrorRate)
+		s.probeQ = new(dns.Msg)
+		s.probeQ.SetQuestion(dns.Fqdn(args.ProbeDomain), dns.TypeA)
+		go s.probeLoop(time.Duration(args.ProbeInterval)*time.Second, time.Duration(args.ProbeTimeout)*time.Millisecond)
 	}
 	return s, nil
 }
 
+func (f *fallback) probeLoop(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.probe(timeout)
+		case <-f.closeNotify:
+			return
+		}
+	}
+}
+
+func (f *fallback) probe(timeout time.Duration) {
+	q := f.probeQ.Copy()
+	q.Id = dns.Id()
+	qCtx := query_context.NewContext(q)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := f.primary.Exec(ctx, qCtx)
+	ok := err == nil && qCtx.R() != nil
+	if f.health.probeResult(ok) {
+		if ok {
+			f.logger.Info("primary is healthy again")
+		} else {
+			f.logger.Warn("primary is unhealthy, probe failed", zap.Error(err))
+		}
+	}
+}
+
+func (f *fallback) Close() error {
+	close(f.closeNotify)
+	return nil
+}
+
 var (
 	ErrFailed = errors.New("no valid response from both primary and secondary")
 )
@@ -108,6 +181,10 @@ func (f *fallback) Exec(ctx context.Context, qCtx *query_context.Context) error
 }
 
 func (f *fallback) doFallback(ctx context.Context, qCtx *query_context.Context) error {
+	if f.health != nil && !f.health.isHealthy() {
+		return f.execSecondaryOnly(ctx, qCtx)
+	}
+
 	respChan := make(chan *dns.Msg, 2) // resp could be nil.
 	primFailed := make(chan struct{})
 	primDone := make(chan struct{})
@@ -124,6 +201,11 @@ func (f *fallback) doFallback(ctx context.Context, qCtx *query_context.Context)
 		}
 
 		r := qCtx.R()
+		// Don't blame the primary if the query was abandoned, e.g.
+		// the client is gone or the secondary response was chosen.
+		if f.health != nil && !errors.Is(ctx.Err(), context.Canceled) {
+			f.health.record(err != nil || r == nil)
+		}
 		if err != nil || r == nil {
 			close(primFailed)
 			respChan <- nil
@@ -187,10 +269,25 @@ func (f *fallback) doFallback(ctx context.Context, qCtx *query_context.Context)
 	return ErrFailed
 }
 
+// execSecondaryOnly is used when the primary is unhealthy.
+func (f *fallback) execSecondaryOnly(ctx context.Context, qCtx *query_context.Context) error {
+	ctx, cancel := makeDdlCtx(ctx, defaultParallelTimeout)
+	defer cancel()
+	if err := f.secondary.Exec(ctx, qCtx); err != nil {
+		f.logger.Warn("secondary error", qCtx.InfoField(), zap.Error(err))
+		return ErrFailed
+	}
+	if qCtx.R() == nil {
+		return ErrFailed
+	}
+	return nil
+}
+
+// makeDdlCtx returns a child ctx of ctx that has a deadline. If ctx has
+// no deadline, timeout is used.
 func makeDdlCtx(ctx context.Context, timeout time.Duration) (context.Context, func()) {
-	ddl, ok := ctx.Deadline()
-	if !ok {
-		ddl = time.Now().Add(timeout)
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
 	}
-	return context.WithDeadline(context.Background(), ddl)
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fallback
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// health tracks the health of the primary. The primary becomes unhealthy
+// if a probe failed or the error rate of recent queries exceeds the
+// threshold. It becomes healthy again once a probe succeeded.
+type health struct {
+	healthy atomic.Bool
+
+	maxErrRate float64
+	m          sync.Mutex
+	window     []bool // ring buffer of recent results, true means failed. Maybe empty.
+	p          int
+	n          int // number of results in window
+	failed     int // number of failed results in window
+}
+
+func newHealth(windowSize int, maxErrRate float64) *health {
+	h := &health{
+		maxErrRate: maxErrRate,
+		window:     make([]bool, windowSize),
+	}
+	h.healthy.Store(true)
+	return h
+}
+
+func (h *health) isHealthy() bool {
+	return h.healthy.Load()
+}
+
+// record records the result of a query. The window must be full before
+// the error rate is checked.
+func (h *health) record(failed bool) {
+	if len(h.window) == 0 {
+		return
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.n == len(h.window) {
+		if h.window[h.p] {
+			h.failed--
+		}
+	} else {
+		h.n++
+	}
+	h.window[h.p] = failed
+	if failed {
+		h.failed++
+	}
+	h.p = (h.p + 1) % len(h.window)
+
+	if h.n == len(h.window) && float64(h.failed)/float64(h.n) > h.maxErrRate {
+		h.healthy.Store(false)
+	}
+}
+
+// probeResult updates the health by the result of a probe.
+// A successful probe also resets the window.
+func (h *health) probeResult(ok bool) (changed bool) {
+	if ok {
+		h.m.Lock()
+		h.p, h.n, h.failed = 0, 0, 0
+		h.m.Unlock()
+	}
+	return h.healthy.Swap(ok) != ok
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fallback
+
+import "testing"
+
+func Test_health(t *testing.T) {
+	h := newHealth(4, 0.5)
+	h.record(true)
+	h.record(true)
+	h.record(true)
+	if !h.isHealthy() {
+		t.Fatal("window is not full, should be healthy")
+	}
+	h.record(false) // 3/4 failed
+	if h.isHealthy() {
+		t.Fatal("should be unhealthy")
+	}
+	if !h.probeResult(true) || !h.isHealthy() {
+		t.Fatal("should be healthy after a successful probe")
+	}
+	for i := 0; i < 4; i++ {
+		h.record(i%2 == 0) // 2/4 failed, not exceeding 0.5
+	}
+	if !h.isHealthy() {
+		t.Fatal("should be healthy")
+	}
+	h.record(true) // replaces a failed result, still 2/4
+	h.record(true) // 3/4 failed
+	if h.isHealthy() {
+		t.Fatal("should be unhealthy")
+	}
+	if h.probeResult(false) {
+		t.Fatal("health should not be changed")
+	}
+
+	// Window disabled.
+	h = newHealth(0, 0.5)
+	h.record(true)
+	if !h.isHealthy() {
+		t.Fatal("should be healthy")
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "parallel"
+
+const defaultBranchTimeout = time.Second * 5
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const (
+	// PolicyFirstSuccess uses the first successful response from any branch.
+	PolicyFirstSuccess = "first_success"
+	// PolicyBranchOrder uses the successful response of the first branch
+	// in config order. Later branches are only used if former ones failed.
+	PolicyBranchOrder = "branch_order"
+	// PolicyUnion waits all branches and merges answers of successful
+	// responses.
+	PolicyUnion = "union"
+)
+
+type Args struct {
+	Branches []BranchArgs `yaml:"branches"`
+	// Policy to merge responses of branches. Can be "first_success",
+	// "branch_order" or "union". Default is "first_success".
+	Policy string `yaml:"policy"`
+}
+
+type BranchArgs struct {
+	// Exec is the tag of an executable plugin, typically a sequence.
+	Exec string `yaml:"exec"`
+	// Timeout in milliseconds. Default is 5000.
+	Timeout int `yaml:"timeout"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Policy, PolicyFirstSuccess)
+	for i := range a.Branches {
+		utils.SetDefaultUnsignNum(&a.Branches[i].Timeout, int(defaultBranchTimeout/time.Millisecond))
+	}
+}
+
+type branch struct {
+	tag     string
+	e       sequence.Executable
+	timeout time.Duration
+}
+
+var _ sequence.Executable = (*Parallel)(nil)
+
+// Parallel executes its branches concurrently with copies of the query
+// context and merges their responses.
+type Parallel struct {
+	logger   *zap.Logger
+	branches []branch
+	policy   string
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewParallel(bp, args.(*Args))
+}
+
+func NewParallel(bq sequence.BQ, args *Args) (*Parallel, error) {
+	args.init()
+	if len(args.Branches) == 0 {
+		return nil, errors.New("no branch is configured")
+	}
+	switch args.Policy {
+	case PolicyFirstSuccess, PolicyBranchOrder, PolicyUnion:
+	default:
+		return nil, fmt.Errorf("invalid policy [%s]", args.Policy)
+	}
+
+	p := &Parallel{
+		logger: bq.L(),
+		policy: args.Policy,
+	}
+	for i, ba := range args.Branches {
+		e := sequence.ToExecutable(bq.M().GetPlugin(ba.Exec))
+		if e == nil {
+			return nil, fmt.Errorf("can not find executable %s for branch #%d", ba.Exec, i)
+		}
+		p.branches = append(p.branches, branch{
+			tag:     ba.Exec,
+			e:       e,
+			timeout: time.Duration(ba.Timeout) * time.Millisecond,
+		})
+	}
+	return p, nil
+}
+
+var ErrFailed = errors.New("no valid response from any branch")
+
+type result struct {
+	i int
+	r *dns.Msg // nil if failed
+}
+
+func (p *Parallel) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	resChan := make(chan result, len(p.branches))
+	// Branches will be canceled once a result is chosen.
+	branchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i := range p.branches {
+		b := p.branches[i]
+		qCtxB := qCtx.Copy()
+		go func() {
+			ctx, cancel := context.WithTimeout(branchCtx, b.timeout)
+			defer cancel()
+			err := b.e.Exec(ctx, qCtxB)
+			if err != nil {
+				p.logger.Warn("branch error", qCtxB.InfoField(), zap.String("branch", b.tag), zap.Error(err))
+			}
+			r := qCtxB.R()
+			if err != nil || r == nil {
+				r = nil
+			}
+			resChan <- result{i: i, r: r}
+		}()
+	}
+
+	var r *dns.Msg
+	var err error
+	switch p.policy {
+	case PolicyFirstSuccess:
+		r, err = p.firstSuccess(ctx, resChan)
+	case PolicyBranchOrder:
+		r, err = p.branchOrder(ctx, resChan)
+	case PolicyUnion:
+		r, err = p.union(ctx, resChan)
+	}
+	if err != nil {
+		return err
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func isSuccess(r *dns.Msg) bool {
+	return r != nil && r.Rcode == dns.RcodeSuccess
+}
+
+// firstSuccess returns the first response that has a success rcode.
+// If no branch succeeded, the first non-nil response in branch order
+// will be returned.
+func (p *Parallel) firstSuccess(ctx context.Context, resChan <-chan result) (*dns.Msg, error) {
+	rs := make([]*dns.Msg, len(p.branches))
+	for range p.branches {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case res := <-resChan:
+			if isSuccess(res.r) {
+				return res.r, nil
+			}
+			rs[res.i] = res.r
+		}
+	}
+	return firstNonNil(rs)
+}
+
+// branchOrder returns the response of the first successful branch in
+// config order.
+func (p *Parallel) branchOrder(ctx context.Context, resChan <-chan result) (*dns.Msg, error) {
+	rs := make([]*dns.Msg, len(p.branches))
+	done := make([]bool, len(p.branches))
+	next := 0 // the first branch that has not been decided
+	for range p.branches {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case res := <-resChan:
+			rs[res.i] = res.r
+			done[res.i] = true
+		}
+		for next < len(p.branches) && done[next] {
+			if isSuccess(rs[next]) {
+				return rs[next], nil
+			}
+			next++
+		}
+	}
+	return firstNonNil(rs)
+}
+
+// union waits all branches and merges answers of successful responses
+// into the first successful one in branch order.
+func (p *Parallel) union(ctx context.Context, resChan <-chan result) (*dns.Msg, error) {
+	rs := make([]*dns.Msg, len(p.branches))
+	for range p.branches {
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case res := <-resChan:
+			rs[res.i] = res.r
+		}
+	}
+
+	var merged *dns.Msg
+	for _, r := range rs {
+		if !isSuccess(r) {
+			continue
+		}
+		if merged == nil {
+			merged = r.Copy()
+			continue
+		}
+		for _, rr := range r.Answer {
+			if !containsRR(merged.Answer, rr) {
+				merged.Answer = append(merged.Answer, dns.Copy(rr))
+			}
+		}
+	}
+	if merged != nil {
+		return merged, nil
+	}
+	return firstNonNil(rs)
+}
+
+func containsRR(rrs []dns.RR, rr dns.RR) bool {
+	for _, e := range rrs {
+		if dns.IsDuplicate(e, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonNil(rs []*dns.Msg) (*dns.Msg, error) {
+	for _, r := range rs {
+		if r != nil {
+			return r, nil
+		}
+	}
+	return nil, ErrFailed
+}
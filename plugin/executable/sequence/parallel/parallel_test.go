@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+type dummy struct {
+	delay time.Duration
+	ip    string // answer, empty for no answer
+	rcode int
+	err   bool
+}
+
+func (d *dummy) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	select {
+	case <-time.After(d.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if d.err {
+		return errors.New("err")
+	}
+	r := new(dns.Msg)
+	r.SetRcode(qCtx.Q(), d.rcode)
+	if len(d.ip) > 0 {
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(d.ip),
+		})
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+func TestParallel_Exec(t *testing.T) {
+	ps := map[string]any{
+		"fast":     &dummy{delay: time.Millisecond, ip: "1.1.1.1"},
+		"slow":     &dummy{delay: time.Millisecond * 50, ip: "2.2.2.2"},
+		"slow_dup": &dummy{delay: time.Millisecond * 50, ip: "1.1.1.1"},
+		"nx":       &dummy{rcode: dns.RcodeNameError},
+		"err":      &dummy{err: true},
+		"hang":     &dummy{delay: time.Hour},
+	}
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+
+	tests := []struct {
+		name     string
+		policy   string
+		branches []string
+		timeout  int
+		wantErr  bool
+		wantIPs  []string
+		wantCode int
+	}{
+		{"first success", PolicyFirstSuccess, []string{"slow", "fast"}, 0, false, []string{"1.1.1.1"}, 0},
+		{"first success skip nx", PolicyFirstSuccess, []string{"nx", "slow"}, 0, false, []string{"2.2.2.2"}, 0},
+		{"first success all failed", PolicyFirstSuccess, []string{"err", "nx"}, 0, false, nil, dns.RcodeNameError},
+		{"branch order", PolicyBranchOrder, []string{"slow", "fast"}, 0, false, []string{"2.2.2.2"}, 0},
+		{"branch order first failed", PolicyBranchOrder, []string{"err", "slow", "fast"}, 0, false, []string{"2.2.2.2"}, 0},
+		{"branch order timeout", PolicyBranchOrder, []string{"hang", "fast"}, 20, false, []string{"1.1.1.1"}, 0},
+		{"union", PolicyUnion, []string{"fast", "slow", "slow_dup", "nx"}, 0, false, []string{"1.1.1.1", "2.2.2.2"}, 0},
+		{"all failed", PolicyUnion, []string{"err", "hang"}, 20, true, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := &Args{Policy: tt.policy}
+			for _, b := range tt.branches {
+				args.Branches = append(args.Branches, BranchArgs{Exec: b, Timeout: tt.timeout})
+			}
+			p, err := NewParallel(sequence.BQ(coremain.NewBP("test", m)), args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := new(dns.Msg)
+			q.SetQuestion("example.com.", dns.TypeA)
+			qCtx := query_context.NewContext(q)
+			err = p.Exec(context.Background(), qCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exec() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			r := qCtx.R()
+			if r.Rcode != tt.wantCode {
+				t.Fatalf("want rcode %d, got %d", tt.wantCode, r.Rcode)
+			}
+			var ips []string
+			for _, rr := range r.Answer {
+				ips = append(ips, rr.(*dns.A).A.String())
+			}
+			if len(ips) != len(tt.wantIPs) {
+				t.Fatalf("want ips %v, got %v", tt.wantIPs, ips)
+			}
+			for i := range ips {
+				if ips[i] != tt.wantIPs[i] {
+					t.Fatalf("want ips %v, got %v", tt.wantIPs, ips)
+				}
+			}
+		})
+	}
+}
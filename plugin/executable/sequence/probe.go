@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+// ProbeResult reports how a query was handled. It is encoded as json by
+// the admin api.
+type ProbeResult struct {
+	Stages   []ProbeStage  `json:"stages"`
+	Upstream string        `json:"upstream,omitempty"`
+	Rcode    string        `json:"rcode,omitempty"` // empty if there is no response.
+	Answer   []string      `json:"answer,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Took     time.Duration `json:"took"`
+}
+
+// ProbeStage is a Stage that can be encoded as json.
+type ProbeStage struct {
+	Name     string        `json:"name"`
+	Start    time.Duration `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Matched  *bool         `json:"matched,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Probe executes q by e and records the nodes of sequences that were
+// executed.
+func Probe(ctx context.Context, e Executable, q *dns.Msg) *ProbeResult {
+	qCtx := query_context.NewContext(q)
+	r := RecordStages(qCtx)
+	start := time.Now()
+	err := e.Exec(ctx, qCtx)
+
+	res := &ProbeResult{Took: time.Since(start)}
+	for _, s := range r.Stages() {
+		ps := ProbeStage{Name: s.Name, Start: s.Start, Duration: s.Duration, Matched: s.Matched}
+		if s.Err != nil {
+			ps.Error = s.Err.Error()
+		}
+		res.Stages = append(res.Stages, ps)
+	}
+	if v, ok := qCtx.GetValue(query_context.KeyUpstream); ok {
+		res.Upstream, _ = v.(string)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if resp := qCtx.R(); resp != nil {
+		res.Rcode = dns.RcodeToString[resp.Rcode]
+		for _, rr := range resp.Answer {
+			res.Answer = append(res.Answer, rr.String())
+		}
+	}
+	return res
+}
+
+// Probe implements coremain.QueryProber.
+func (s *Sequence) Probe(ctx context.Context, q *dns.Msg) any {
+	return Probe(ctx, s, q)
+}
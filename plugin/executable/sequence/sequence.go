@@ -40,7 +40,9 @@ func init() {
 }
 
 type Sequence struct {
+	name             string // used as the prefix of counter names
 	chain            []*ChainNode
+	rules            []RuleConfig
 	anonymousPlugins []any
 }
 
@@ -58,7 +60,10 @@ func Init(bp *coremain.BP, args any) (any, error) {
 }
 
 func NewSequence(bq BQ, ra []RuleArgs) (*Sequence, error) {
-	s := &Sequence{}
+	s := &Sequence{name: PluginType}
+	if t, ok := bq.(interface{ Tag() string }); ok {
+		s.name = t.Tag()
+	}
 
 	var rc []RuleConfig
 	for _, ra := range ra {
@@ -68,9 +73,15 @@ func NewSequence(bq BQ, ra []RuleArgs) (*Sequence, error) {
 		_ = s.Close()
 		return nil, err
 	}
+	s.rules = rc
 	return s, nil
 }
 
+// Rules returns the parsed rules of the chain, one for each node.
+func (s *Sequence) Rules() []RuleConfig {
+	return s.rules
+}
+
 func (s *Sequence) Exec(ctx context.Context, qCtx *query_context.Context) error {
 	walker := NewChainWalker(s.chain, nil)
 	return walker.ExecNext(ctx, qCtx)
@@ -22,11 +22,17 @@ package sequence
 import (
 	"context"
 	"errors"
+	"slices"
 	"testing"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type dummy struct {
@@ -197,3 +203,144 @@ func Test_sequence_Exec(t *testing.T) {
 		})
 	}
 }
+
+func Test_sequence_counters(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := NewSequence(coremain.NewBP("seq", m), []RuleArgs{
+		{Matches: []string{"$true", "$false"}, Exec: "$nop"},
+		{Exec: "$err"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Exec(context.Background(), query_context.NewContext(new(dns.Msg)))
+
+	got := make(map[string]coremain.PluginCountersSnapshot)
+	for _, c := range m.GetCounterCollector().Snapshot() {
+		got[c.Name] = c
+	}
+	want := map[string][3]uint64{ // processed, matched, errors
+		"seq.r0.m0:true":  {1, 1, 0},
+		"seq.r0.m1:false": {1, 0, 0},
+		"seq.r0:nop":      {0, 0, 0},
+		"seq.r1:err":      {1, 0, 1},
+	}
+	for name, w := range want {
+		c, ok := got[name]
+		if !ok {
+			t.Fatalf("missing counters %s, got %v", name, got)
+		}
+		if c.Processed != w[0] || c.Matched != w[1] || c.Errors != w[2] {
+			t.Errorf("counters %s: want %v, got %+v", name, w, c)
+		}
+	}
+}
+
+func Test_sequence_tracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := NewSequence(coremain.NewBP("seq", m), []RuleArgs{
+		{Matches: []string{"$true"}, Exec: "$nop"},
+		{Exec: "$err"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+	_ = s.Exec(ctx, query_context.NewContext(new(dns.Msg)))
+	root.End()
+
+	got := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range sr.Ended() {
+		got[span.Name()] = span
+	}
+	// Recursive executables contain their following nodes.
+	for name, parent := range map[string]string{
+		"seq.r0.m0:true": "",
+		"seq.r0:nop":     "",
+		"seq.r1:err":     "seq.r0:nop",
+	} {
+		span, ok := got[name]
+		if !ok {
+			t.Fatalf("missing span %s", name)
+		}
+		wantParent := root.SpanContext().SpanID()
+		if len(parent) > 0 {
+			wantParent = got[parent].SpanContext().SpanID()
+		}
+		if span.Parent().SpanID() != wantParent {
+			t.Errorf("span %s has a wrong parent", name)
+		}
+	}
+	if got["seq.r1:err"].Status().Code != codes.Error {
+		t.Error("error is not recorded")
+	}
+}
+
+func Test_sequence_stages(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := NewSequence(coremain.NewBP("seq", m), []RuleArgs{
+		{Matches: []string{"$true"}, Exec: "$nop"},
+		{Matches: []string{"$false"}, Exec: "$nop"},
+		{Exec: "$err"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qCtx := query_context.NewContext(new(dns.Msg))
+	r := RecordStages(qCtx)
+	_ = s.Exec(context.Background(), qCtx)
+
+	var got []string
+	for _, st := range r.Stages() {
+		got = append(got, st.Name)
+	}
+	want := []string{"seq.r0.m0:true", "seq.r0:nop", "seq.r1.m0:false", "seq.r2:err"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("want stages %v, got %v", want, got)
+	}
+	if st := r.Stages()[3]; st.Err == nil {
+		t.Fatal("error is not recorded")
+	}
+	if st := r.Stages(); st[0].Matched == nil || !*st[0].Matched || st[2].Matched == nil || *st[2].Matched || st[1].Matched != nil {
+		t.Fatal("matcher results are not recorded")
+	}
+}
+
+func Test_Probe(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := NewSequence(coremain.NewBP("seq", m), []RuleArgs{
+		{Matches: []string{"$false"}, Exec: "$err"},
+		{Exec: "reject 3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	res := s.Probe(context.Background(), q).(*ProbeResult)
+
+	var got []string
+	for _, st := range res.Stages {
+		got = append(got, st.Name)
+	}
+	want := []string{"seq.r0.m0:false", "seq.r1:reject"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("want stages %v, got %v", want, got)
+	}
+	if res.Rcode != "NXDOMAIN" || len(res.Error) > 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"go.uber.org/zap/zapcore"
+)
+
+var stageRecorderKey = query_context.RegKey()
+
+// Stage is a node that was executed in a sequence.
+type Stage struct {
+	Name     string
+	Start    time.Duration // offset from the start of the recorder.
+	Duration time.Duration
+	Err      error
+	Matched  *bool // result of a matcher, nil if the node is an executable.
+}
+
+// StageRecorder records nodes that were executed for a query.
+// It is safe for concurrent use, since copies of a query context share
+// the same recorder.
+type StageRecorder struct {
+	start time.Time
+
+	m      sync.Mutex
+	stages []Stage
+}
+
+// RecordStages attaches a new StageRecorder to qCtx. Nodes of sequences
+// that execute qCtx afterward will be recorded into it.
+func RecordStages(qCtx *query_context.Context) *StageRecorder {
+	r := &StageRecorder{start: time.Now()}
+	qCtx.StoreValue(stageRecorderKey, r)
+	return r
+}
+
+func getStageRecorder(qCtx *query_context.Context) *StageRecorder {
+	v, _ := qCtx.GetValue(stageRecorderKey)
+	r, _ := v.(*StageRecorder)
+	return r
+}
+
+func (r *StageRecorder) add(name string, start time.Time, err error) {
+	r.addStage(Stage{Name: name, Start: start.Sub(r.start), Duration: time.Since(start), Err: err})
+}
+
+func (r *StageRecorder) addMatch(name string, start time.Time, ok bool, err error) {
+	r.addStage(Stage{Name: name, Start: start.Sub(r.start), Duration: time.Since(start), Err: err, Matched: &ok})
+}
+
+func (r *StageRecorder) addStage(s Stage) {
+	r.m.Lock()
+	r.stages = append(r.stages, s)
+	r.m.Unlock()
+}
+
+// Stages returns recorded stages, sorted by their start time.
+func (r *StageRecorder) Stages() []Stage {
+	r.m.Lock()
+	s := slices.Clone(r.stages)
+	r.m.Unlock()
+	slices.SortStableFunc(s, func(a, b Stage) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return s
+}
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (r *StageRecorder) MarshalLogArray(encoder zapcore.ArrayEncoder) error {
+	for _, s := range r.Stages() {
+		if err := encoder.AppendObject(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (s Stage) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddString("name", s.Name)
+	encoder.AddDuration("start", s.Start)
+	encoder.AddDuration("duration", s.Duration)
+	if s.Matched != nil {
+		encoder.AddBool("matched", *s.Matched)
+	}
+	if s.Err != nil {
+		encoder.AddString("error", s.Err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+// WireSafe can be implemented by plugins that only read the header, the
+// question and the server meta of a query, never modify the query, and
+// either set a response by themselves or forward the query as is (see
+// query_context.Context.RawQuery).
+// If all plugins of an entry are wire safe, servers can pass queries to it
+// in wire format, and relay responses from upstreams without unpacking
+// and packing them. See IsWireSafe.
+type WireSafe interface {
+	WireSafe() bool
+}
+
+// IsWireSafe reports whether p is a WireSafe plugin that is wire safe.
+// For sequences, it checks all plugins in the chain, including the
+// sequences they jump to.
+func IsWireSafe(p any) bool {
+	ws, ok := p.(WireSafe)
+	return ok && ws.WireSafe()
+}
+
+// MarkWireSafe marks m as wire safe.
+func MarkWireSafe(m Matcher) Matcher {
+	return wireSafeMatcher{Matcher: m}
+}
+
+// WireSafeMatchQuickSetup returns a MatchQuickSetupFunc that marks the
+// matchers from f as wire safe.
+func WireSafeMatchQuickSetup(f MatchQuickSetupFunc) MatchQuickSetupFunc {
+	return func(bq BQ, args string) (Matcher, error) {
+		m, err := f(bq, args)
+		if err != nil {
+			return nil, err
+		}
+		return MarkWireSafe(m), nil
+	}
+}
+
+type wireSafeMatcher struct {
+	Matcher
+}
+
+func (wireSafeMatcher) WireSafe() bool { return true }
+
+func chainIsWireSafe(chain []*ChainNode) bool {
+	for _, n := range chain {
+		for _, m := range n.Matches {
+			if !IsWireSafe(m) {
+				return false
+			}
+		}
+		if n.E != nil {
+			if !IsWireSafe(n.E) {
+				return false
+			}
+		} else if !IsWireSafe(n.RE) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sequence) WireSafe() bool        { return chainIsWireSafe(s.chain) }
+func (a *ActionJump) WireSafe() bool      { return chainIsWireSafe(a.To) }
+func (a ActionGoto) WireSafe() bool       { return chainIsWireSafe(a.To) }
+func (ActionAccept) WireSafe() bool       { return true }
+func (ActionReject) WireSafe() bool       { return true }
+func (ActionReturn) WireSafe() bool       { return true }
+func (MatchAlwaysTrue) WireSafe() bool    { return true }
+func (MatchAlwaysFalse) WireSafe() bool   { return true }
+func (r reverseMatch) WireSafe() bool     { return IsWireSafe(r.m) }
+func (cm *countedMatcher) WireSafe() bool { return IsWireSafe(cm.m) }
+func (ce *countedExec) WireSafe() bool    { return IsWireSafe(ce.e) }
+
+func (cre *countedRecursiveExec) WireSafe() bool { return IsWireSafe(cre.re) }
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+)
+
+type wireSafeDummy struct {
+	dummy
+}
+
+func (d *wireSafeDummy) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	return nil
+}
+
+func (d *wireSafeDummy) WireSafe() bool { return true }
+
+func Test_IsWireSafe(t *testing.T) {
+	tests := []struct {
+		name string
+		args []RuleArgs
+		want bool
+	}{
+		{name: "safe", args: []RuleArgs{
+			{Matches: []string{"$safe", "!$safe"}, Exec: "$safe"},
+			{Exec: "reject 3"},
+			{Exec: "accept"},
+		}, want: true},
+		{name: "unsafe matcher", args: []RuleArgs{
+			{Matches: []string{"$safe", "$true"}, Exec: "$safe"},
+		}, want: false},
+		{name: "unsafe reversed matcher", args: []RuleArgs{
+			{Matches: []string{"!$true"}, Exec: "$safe"},
+		}, want: false},
+		{name: "unsafe exec", args: []RuleArgs{
+			{Exec: "$safe"},
+			{Exec: "$nop"},
+		}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := make(map[string]any)
+			m := coremain.NewTestMosdnsWithPlugins(ps)
+			preparePlugins(ps)
+			ps["safe"] = &wireSafeDummy{dummy: dummy{matched: true}}
+			s, err := NewSequence(coremain.NewBP("seq", m), tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := IsWireSafe(s); got != tt.want {
+				t.Errorf("IsWireSafe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package slow_log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+const PluginType = "slow_log"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+const defaultThreshold = time.Millisecond * 500
+
+type Args struct {
+	// Threshold in milliseconds. Queries that took longer than it will
+	// be logged. Default is 500.
+	Threshold int `yaml:"threshold"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.Threshold, int(defaultThreshold/time.Millisecond))
+}
+
+var _ sequence.RecursiveExecutable = (*SlowLog)(nil)
+
+// SlowLog logs queries that took longer than the threshold to be
+// processed by its following nodes, with the upstream and timings of
+// every executed sequence node.
+type SlowLog struct {
+	l         *zap.Logger
+	threshold time.Duration
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewSlowLog(bp.L(), args.(*Args)), nil
+}
+
+// QuickSetup format: [threshold_ms]
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	args := new(Args)
+	if s = strings.TrimSpace(s); len(s) > 0 {
+		i, err := strconv.Atoi(s)
+		if err != nil || i <= 0 {
+			return nil, fmt.Errorf("invalid threshold %s", s)
+		}
+		args.Threshold = i
+	}
+	return NewSlowLog(bq.L(), args), nil
+}
+
+// NewSlowLog returns a SlowLog that logs slow queries into l.
+// l cannot be nil.
+func NewSlowLog(l *zap.Logger, args *Args) *SlowLog {
+	args.init()
+	return &SlowLog{
+		l:         l,
+		threshold: time.Duration(args.Threshold) * time.Millisecond,
+	}
+}
+
+func (s *SlowLog) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	start := time.Now()
+	stages := sequence.RecordStages(qCtx)
+	err := next.ExecNext(ctx, qCtx)
+	if elapsed := time.Since(start); elapsed > s.threshold {
+		var upstream string
+		if v, ok := qCtx.GetValue(query_context.KeyUpstream); ok {
+			upstream, _ = v.(string)
+		}
+		s.l.Warn(
+			"slow query",
+			qCtx.InfoField(),
+			zap.Duration("took", elapsed),
+			zap.String("upstream", upstream),
+			zap.Array("stages", stages),
+			zap.Error(err),
+		)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package split_dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+const PluginType = "split_dns"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Rules are lines of "<domain suffix> <group>", e.g. "corp.example internal".
+	// Suffix "." matches all domains and can be used as the default.
+	Rules []string `yaml:"rules"`
+	// Files contain rules, one per line. "#" starts a comment.
+	Files []string `yaml:"files"`
+	// Groups maps group names to tags of executable plugins,
+	// typically forward plugins or sequences.
+	Groups map[string]string `yaml:"groups"`
+	// Default group of domains that do not match any rule. Optional.
+	Default string `yaml:"default"`
+}
+
+var _ sequence.Executable = (*SplitDNS)(nil)
+
+// SplitDNS forwards queries to upstream groups by the longest matched
+// domain suffix. All rules are compiled into a single label trie.
+type SplitDNS struct {
+	m      *domain.SubDomainMatcher[int] // -> index of groups
+	groups []sequence.Executable
+	names  []string
+	logger *zap.Logger
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewSplitDNS(bp, args.(*Args))
+}
+
+func NewSplitDNS(bq sequence.BQ, args *Args) (*SplitDNS, error) {
+	if len(args.Groups) == 0 {
+		return nil, errors.New("no group is configured")
+	}
+
+	s := &SplitDNS{
+		m:      domain.NewSubDomainMatcher[int](),
+		logger: bq.L(),
+	}
+	// Sort group names to keep indexes stable.
+	index := make(map[string]int, len(args.Groups))
+	for name := range args.Groups {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	for i, name := range s.names {
+		tag := strings.TrimPrefix(args.Groups[name], "$")
+		e := sequence.ToExecutable(bq.M().GetPlugin(tag))
+		if e == nil {
+			return nil, fmt.Errorf("can not find executable %s of group %s", tag, name)
+		}
+		s.groups = append(s.groups, e)
+		index[name] = i
+	}
+
+	parse := func(line string) (string, int, error) {
+		fs := strings.Fields(line)
+		if len(fs) != 2 {
+			return "", 0, fmt.Errorf("invalid rule [%s], want <domain suffix> <group>", line)
+		}
+		i, ok := index[fs[1]]
+		if !ok {
+			return "", 0, fmt.Errorf("undefined group %s", fs[1])
+		}
+		return fs[0], i, nil
+	}
+
+	if len(args.Default) > 0 {
+		if err := domain.Load[int](s.m, ". "+args.Default, parse); err != nil {
+			return nil, fmt.Errorf("invalid default group, %w", err)
+		}
+	}
+	for i, rule := range args.Rules {
+		if err := domain.Load[int](s.m, rule, parse); err != nil {
+			return nil, fmt.Errorf("failed to load rule #%d, %w", i, err)
+		}
+	}
+	for _, f := range args.Files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s, %w", f, err)
+		}
+		if err := domain.LoadFromTextReader[int](s.m, bytes.NewReader(b), parse); err != nil {
+			return nil, fmt.Errorf("failed to load file %s, %w", f, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *SplitDNS) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	q := qCtx.Q()
+	if len(q.Question) != 1 {
+		return nil
+	}
+	i, ok := s.m.Match(q.Question[0].Name)
+	if !ok {
+		return nil
+	}
+	return s.groups[i].Exec(ctx, qCtx)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package split_dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+type markExec struct {
+	mark uint32
+}
+
+func (e *markExec) Exec(_ context.Context, qCtx *query_context.Context) error {
+	qCtx.SetMark(e.mark)
+	return nil
+}
+
+func TestSplitDNS(t *testing.T) {
+	m := coremain.NewTestMosdnsWithPlugins(map[string]any{
+		"fwd_internal": &markExec{mark: 1},
+		"fwd_domestic": &markExec{mark: 2},
+		"fwd_overseas": &markExec{mark: 3},
+	})
+	f := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(f, []byte("# comment\ncn domestic\nexample.cn overseas\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSplitDNS(coremain.NewBP("test", m), &Args{
+		Rules: []string{"corp.example internal"},
+		Files: []string{f},
+		Groups: map[string]string{
+			"internal": "fwd_internal",
+			"domestic": "$fwd_domestic",
+			"overseas": "fwd_overseas",
+		},
+		Default: "overseas",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		qname string
+		mark  uint32
+	}{
+		{"a.corp.example.", 1},
+		{"corp.example.", 1},
+		{"xcorp.example.", 3},
+		{"www.baidu.cn.", 2},
+		{"www.example.cn.", 3},
+		{"google.com.", 3},
+	}
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(tt.qname, dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		if err := s.Exec(context.Background(), qCtx); err != nil {
+			t.Fatal(err)
+		}
+		if !qCtx.HasMark(tt.mark) {
+			t.Errorf("%s: want group mark %d", tt.qname, tt.mark)
+		}
+	}
+
+	if _, err := NewSplitDNS(coremain.NewBP("test", m), &Args{
+		Rules:  []string{"cn undefined"},
+		Groups: map[string]string{"domestic": "fwd_domestic"},
+	}); err == nil {
+		t.Fatal("undefined group should be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// status is a subset of the response of tailscaled's local api
+// "/localapi/v0/status".
+type status struct {
+	BackendState   string
+	MagicDNSSuffix string
+	CurrentTailnet *struct {
+		MagicDNSSuffix string
+	}
+	Self *peerStatus
+	Peer map[string]*peerStatus
+}
+
+type peerStatus struct {
+	HostName     string
+	DNSName      string
+	TailscaleIPs []netip.Addr
+	Online       bool
+}
+
+// peers is a read-only index of tailnet nodes.
+type peers struct {
+	suffix string                  // magic dns suffix, fqdn, lower case. Maybe empty.
+	names  map[string][]netip.Addr // fqdn -> ips
+	addrs  map[netip.Addr]string   // ip -> fqdn
+}
+
+func newPeers(s *status) *peers {
+	p := &peers{
+		names: make(map[string][]netip.Addr),
+		addrs: make(map[netip.Addr]string),
+	}
+	suffix := s.MagicDNSSuffix
+	if s.CurrentTailnet != nil && len(s.CurrentTailnet.MagicDNSSuffix) > 0 {
+		suffix = s.CurrentTailnet.MagicDNSSuffix
+	}
+	if len(suffix) > 0 {
+		p.suffix = strings.ToLower(strings.TrimSuffix(suffix, ".") + ".")
+	}
+
+	add := func(ps *peerStatus) {
+		if ps == nil || len(ps.DNSName) == 0 {
+			return
+		}
+		fqdn := strings.ToLower(strings.TrimSuffix(ps.DNSName, ".") + ".")
+		for _, addr := range ps.TailscaleIPs {
+			p.names[fqdn] = append(p.names[fqdn], addr)
+			p.addrs[addr] = fqdn
+		}
+	}
+	add(s.Self)
+	for _, ps := range s.Peer {
+		add(ps)
+	}
+	return p
+}
+
+// localClient talks to tailscaled's local api over its unix socket.
+type localClient struct {
+	c *http.Client
+}
+
+func newLocalClient(socket string) *localClient {
+	d := net.Dialer{}
+	return &localClient{c: &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+func (lc *localClient) status(ctx context.Context) (*status, error) {
+	// The host is required by tailscaled.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://local-tailscaled.sock/localapi/v0/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lc.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	s := new(status)
+	if err := json.NewDecoder(resp.Body).Decode(s); err != nil {
+		return nil, fmt.Errorf("failed to decode status, %w", err)
+	}
+	return s, nil
+}
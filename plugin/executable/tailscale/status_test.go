@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tailscale
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+func Test_newPeers(t *testing.T) {
+	raw := `{
+  "BackendState": "Running",
+  "MagicDNSSuffix": "tail1234.ts.net",
+  "CurrentTailnet": {"MagicDNSSuffix": "tail1234.ts.net"},
+  "Self": {"HostName": "router", "DNSName": "router.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.1", "fd7a:115c:a1e0::1"]},
+  "Peer": {
+    "nodekey:1": {"HostName": "Laptop", "DNSName": "laptop.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.2"]},
+    "nodekey:2": {"HostName": "nodns", "DNSName": "", "TailscaleIPs": ["100.64.0.3"]}
+  }
+}`
+	s := new(status)
+	if err := json.Unmarshal([]byte(raw), s); err != nil {
+		t.Fatal(err)
+	}
+	p := newPeers(s)
+	if p.suffix != "tail1234.ts.net." {
+		t.Fatalf("unexpected suffix %s", p.suffix)
+	}
+	if len(p.names["router.tail1234.ts.net."]) != 2 {
+		t.Fatalf("unexpected router addrs %v", p.names)
+	}
+	if p.addrs[netip.MustParseAddr("100.64.0.2")] != "laptop.tail1234.ts.net." {
+		t.Fatalf("unexpected addrs %v", p.addrs)
+	}
+	if _, ok := p.addrs[netip.MustParseAddr("100.64.0.3")]; ok {
+		t.Fatal("peer without dns name should be ignored")
+	}
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "tailscale"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const tsNetSuffix = "ts.net."
+
+type Args struct {
+	// Socket of tailscaled's local api.
+	// Default is "/var/run/tailscale/tailscaled.sock".
+	Socket string `yaml:"socket"`
+	// Resolver is the address of tailscale's MagicDNS resolver.
+	// Default is "100.100.100.100".
+	Resolver string `yaml:"resolver"`
+	// Suffixes are extra domain suffixes that will be forwarded to
+	// the resolver. "ts.net" and the tailnet's MagicDNS suffix are
+	// always forwarded.
+	Suffixes []string `yaml:"suffixes"`
+	// Interval in seconds to refresh tailnet status. Default is 30.
+	Interval int `yaml:"interval"`
+	// TTL of peer records. Default is 60.
+	TTL int `yaml:"ttl"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultString(&a.Socket, "/var/run/tailscale/tailscaled.sock")
+	utils.SetDefaultString(&a.Resolver, "100.100.100.100")
+	utils.SetDefaultUnsignNum(&a.Interval, 30)
+	utils.SetDefaultUnsignNum(&a.TTL, 60)
+}
+
+var _ sequence.Executable = (*Tailscale)(nil)
+var _ data_provider.HostnameProvider = (*Tailscale)(nil)
+
+// Tailscale answers queries of tailnet peers from tailscaled's status
+// and forwards other MagicDNS queries to tailscale's resolver.
+// If tailscaled is not running, it does nothing.
+type Tailscale struct {
+	args             *Args
+	logger           *zap.Logger
+	recursionAllowed func(addr netip.Addr) bool // maybe nil
+	lc               *localClient
+	u                upstream.Upstream
+	suffixes         []string
+
+	peers       atomic.Pointer[peers] // nil if tailscaled is not available
+	closeNotify chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewTailscale(args.(*Args), Opts{Logger: bp.L(), RecursionAllowed: bp.M().RecursionAllowed})
+}
+
+type Opts struct {
+	Logger *zap.Logger
+
+	// RecursionAllowed reports whether the client is allowed to get
+	// answers from the MagicDNS resolver. Disallowed clients get REFUSED.
+	// Peer records are always answered. Nil means all clients are allowed.
+	RecursionAllowed func(addr netip.Addr) bool
+}
+
+func NewTailscale(args *Args, opts Opts) (*Tailscale, error) {
+	args.init()
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	u, err := upstream.NewUpstream(args.Resolver, upstream.Opt{Logger: logger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init resolver, %w", err)
+	}
+	t := &Tailscale{
+		args:             args,
+		logger:           logger,
+		recursionAllowed: opts.RecursionAllowed,
+		lc:               newLocalClient(args.Socket),
+		u:                u,
+		suffixes:         []string{tsNetSuffix},
+		closeNotify:      make(chan struct{}),
+	}
+	for _, s := range args.Suffixes {
+		t.suffixes = append(t.suffixes, strings.ToLower(dns.Fqdn(s)))
+	}
+
+	t.refresh()
+	go t.refreshLoop()
+	return t, nil
+}
+
+func (t *Tailscale) refreshLoop() {
+	ticker := time.NewTicker(time.Duration(t.args.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.refresh()
+		case <-t.closeNotify:
+			return
+		}
+	}
+}
+
+func (t *Tailscale) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	s, err := t.lc.status(ctx)
+	if err != nil || s.BackendState != "Running" {
+		if t.peers.Swap(nil) != nil {
+			t.logger.Warn("tailscaled is unavailable", zap.Error(err))
+		}
+		return
+	}
+	p := newPeers(s)
+	if t.peers.Swap(p) == nil {
+		t.logger.Info("tailscaled detected", zap.String("suffix", p.suffix), zap.Int("peers", len(p.names)))
+	}
+}
+
+// LookupHostname implements data_provider.HostnameProvider.
+func (t *Tailscale) LookupHostname(addr netip.Addr) (string, bool) {
+	p := t.peers.Load()
+	if p == nil {
+		return "", false
+	}
+	fqdn, ok := p.addrs[addr.Unmap()]
+	return fqdn, ok
+}
+
+func (t *Tailscale) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	p := t.peers.Load()
+	if p == nil {
+		return nil
+	}
+	q := qCtx.Q()
+	if len(q.Question) != 1 {
+		return nil
+	}
+	if r := t.response(p, q); r != nil {
+		qCtx.SetResponse(r)
+		return nil
+	}
+	if !t.shouldForward(p, q.Question[0].Name) {
+		return nil
+	}
+	if t.recursionAllowed != nil && !t.recursionAllowed(qCtx.ServerMeta.ClientAddr) {
+		r := new(dns.Msg)
+		r.SetRcode(q, dns.RcodeRefused)
+		qCtx.SetResponse(r)
+		return nil
+	}
+	r, err := t.forward(ctx, q)
+	if err != nil {
+		t.logger.Warn("failed to forward query to tailscale resolver", qCtx.InfoField(), zap.Error(err))
+		return nil
+	}
+	qCtx.SetResponse(r)
+	return nil
+}
+
+// response answers queries of peers' names and ips.
+func (t *Tailscale) response(p *peers, q *dns.Msg) *dns.Msg {
+	question := q.Question[0]
+	if question.Qclass != dns.ClassINET {
+		return nil
+	}
+	hdr := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(t.args.TTL),
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Authoritative = true
+
+	if question.Qtype == dns.TypePTR {
+		addr, err := dnsutils.ParsePTRQName(question.Name)
+		if err != nil {
+			return nil
+		}
+		fqdn, ok := p.addrs[addr]
+		if !ok {
+			return nil
+		}
+		r.Answer = append(r.Answer, &dns.PTR{Hdr: hdr, Ptr: fqdn})
+		return r
+	}
+
+	addrs, ok := p.names[strings.ToLower(question.Name)]
+	if !ok {
+		return nil
+	}
+	for _, addr := range addrs {
+		switch {
+		case question.Qtype == dns.TypeA && addr.Is4():
+			r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case question.Qtype == dns.TypeAAAA && addr.Is6():
+			r.Answer = append(r.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+	if len(r.Answer) == 0 {
+		r.Ns = []dns.RR{dnsutils.FakeSOA(question.Name)}
+	}
+	return r
+}
+
+func (t *Tailscale) shouldForward(p *peers, name string) bool {
+	name = strings.ToLower(name)
+	if len(p.suffix) > 0 && dns.IsSubDomain(p.suffix, name) {
+		return true
+	}
+	for _, s := range t.suffixes {
+		if dns.IsSubDomain(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tailscale) forward(ctx context.Context, q *dns.Msg) (*dns.Msg, error) {
+	b, err := pool.PackBuffer(q)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseBuf(b)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	rb, err := t.u.ExchangeContext(ctx, *b)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseBuf(rb)
+	r := new(dns.Msg)
+	if err := r.Unpack(*rb); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (t *Tailscale) Close() error {
+	close(t.closeNotify)
+	return t.u.Close()
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tailscale
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// echoUpstream answers every query with NOERROR.
+type echoUpstream struct{}
+
+func (echoUpstream) ExchangeContext(_ context.Context, b []byte) (*[]byte, error) {
+	q := new(dns.Msg)
+	if err := q.Unpack(b); err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	return pool.PackBuffer(r)
+}
+
+func (echoUpstream) Close() error { return nil }
+
+func TestTailscale_Exec_recursionAllowed(t *testing.T) {
+	ts := &Tailscale{
+		args:             &Args{TTL: 60},
+		logger:           zap.NewNop(),
+		recursionAllowed: func(addr netip.Addr) bool { return addr == netip.MustParseAddr("127.0.0.1") },
+		u:                echoUpstream{},
+		suffixes:         []string{tsNetSuffix},
+	}
+	ts.peers.Store(&peers{
+		suffix: "tail1234.ts.net.",
+		names:  map[string][]netip.Addr{"router.tail1234.ts.net.": {netip.MustParseAddr("100.64.0.1")}},
+		addrs:  map[netip.Addr]string{netip.MustParseAddr("100.64.0.1"): "router.tail1234.ts.net."},
+	})
+
+	tests := []struct {
+		name   string
+		qName  string
+		client string
+		want   int
+	}{
+		{"peer, allowed", "router.tail1234.ts.net.", "127.0.0.1", dns.RcodeSuccess},
+		{"peer, disallowed", "router.tail1234.ts.net.", "192.0.2.1", dns.RcodeSuccess},
+		{"forward, allowed", "other.tail1234.ts.net.", "127.0.0.1", dns.RcodeSuccess},
+		{"forward, disallowed", "other.tail1234.ts.net.", "192.0.2.1", dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.SetQuestion(tt.qName, dns.TypeA)
+			qCtx := query_context.NewContext(q)
+			qCtx.ServerMeta.ClientAddr = netip.MustParseAddr(tt.client)
+			if err := ts.Exec(context.Background(), qCtx); err != nil {
+				t.Fatal(err)
+			}
+			r := qCtx.R()
+			if r == nil || r.Rcode != tt.want {
+				t.Fatalf("want rcode %d, got %v", tt.want, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const PluginType = "webhook"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// BatchSize is the maximum number of events in one request. Default is 100.
+	BatchSize int `yaml:"batch_size"`
+	// FlushInterval in milliseconds. Default is 1000.
+	FlushInterval int `yaml:"flush_interval"`
+	// QueueSize is the maximum number of pending events. New events will be
+	// dropped if the queue is full. Default is 4096.
+	QueueSize int `yaml:"queue_size"`
+	// Timeout in seconds for each request. Default is 5.
+	Timeout int `yaml:"timeout"`
+	// MaxRetries is the maximum number of retries of a failed request.
+	// Retries are delayed with exponential backoff. Default is 3.
+	MaxRetries int `yaml:"max_retries"`
+
+	// Format of the request body. Can be
+	// "json": a json array of events (default),
+	// "ndjson": one json event per line, for generic bulk endpoints,
+	// "clickhouse": same as "ndjson", but inserts events into Table
+	// via ClickHouse http interface ("INSERT ... FORMAT JSONEachRow").
+	Format string `yaml:"format"`
+	// Table is the ClickHouse table for "clickhouse" format, e.g.
+	// "db.table". It can only contain letters, digits, "_" and ".".
+	Table string `yaml:"table"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.BatchSize, 100)
+	utils.SetDefaultUnsignNum(&a.FlushInterval, 1000)
+	utils.SetDefaultUnsignNum(&a.QueueSize, 4096)
+	utils.SetDefaultUnsignNum(&a.Timeout, 5)
+	utils.SetDefaultUnsignNum(&a.MaxRetries, 3)
+	utils.SetDefaultString(&a.Format, formatJSON)
+}
+
+const (
+	formatJSON       = "json"
+	formatNDJSON     = "ndjson"
+	formatClickHouse = "clickhouse"
+)
+
+// retryBackoff is the delay before the first retry.
+const retryBackoff = time.Millisecond * 500
+
+// Event is a summary of a query that will be posted to the webhook.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Id        uint32    `json:"id"`
+	Client    string    `json:"client,omitempty"`
+	Qname     string    `json:"qname"`
+	Qtype     uint16    `json:"qtype"`
+	Qclass    uint16    `json:"qclass"`
+	Rcode     int       `json:"rcode"`
+	Answers   []string  `json:"answers,omitempty"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	Err       string    `json:"err,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Upstream  string    `json:"upstream,omitempty"`
+}
+
+var _ sequence.RecursiveExecutable = (*Webhook)(nil)
+
+// Webhook posts query events to a http endpoint in batches.
+// Events are sent asynchronously and never block queries.
+type Webhook struct {
+	args   *Args
+	reqURL string
+	logger *zap.Logger
+	client *http.Client
+
+	queue     chan Event
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+
+	sentTotal    prometheus.Counter
+	droppedTotal prometheus.Counter
+	errTotal     prometheus.Counter
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	w, err := NewWebhook(args.(*Args), bp.L(), bp.Tag())
+	if err != nil {
+		return nil, err
+	}
+	if err := w.RegMetricsTo(prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg())); err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	return w, nil
+}
+
+// NewWebhook creates a Webhook and starts its sending loop.
+func NewWebhook(args *Args, logger *zap.Logger, metricsTag string) (*Webhook, error) {
+	args.init()
+	if len(args.URL) == 0 {
+		return nil, errors.New("missing url")
+	}
+	reqURL := args.URL
+	switch args.Format {
+	case formatJSON, formatNDJSON:
+	case formatClickHouse:
+		if !validTable(args.Table) {
+			return nil, fmt.Errorf("invalid table [%s] for clickhouse format", args.Table)
+		}
+		u, err := url.Parse(args.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url, %w", err)
+		}
+		q := u.Query()
+		q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", args.Table))
+		q.Set("date_time_input_format", "best_effort")
+		u.RawQuery = q.Encode()
+		reqURL = u.String()
+	default:
+		return nil, fmt.Errorf("invalid format %s", args.Format)
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	lb := map[string]string{"tag": metricsTag}
+	w := &Webhook{
+		args:    args,
+		reqURL:  reqURL,
+		logger:  logger,
+		client:  &http.Client{Timeout: time.Duration(args.Timeout) * time.Second},
+		queue:   make(chan Event, args.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		sentTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "sent_total",
+			Help:        "The total number of events that were sent",
+			ConstLabels: lb,
+		}),
+		droppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "dropped_total",
+			Help:        "The total number of events that were dropped because the queue was full",
+			ConstLabels: lb,
+		}),
+		errTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "err_total",
+			Help:        "The total number of failed requests",
+			ConstLabels: lb,
+		}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+// validTable checks that t is a non-empty table name that only contains
+// letters, digits, "_" and ".", so it can be put into a query as is.
+func validTable(t string) bool {
+	if len(t) == 0 {
+		return false
+	}
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Webhook) RegMetricsTo(r prometheus.Registerer) error {
+	for _, collector := range [...]prometheus.Collector{w.sentTotal, w.droppedTotal, w.errTotal} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	w.push(newEvent(qCtx, err))
+	return err
+}
+
+func newEvent(qCtx *query_context.Context, err error) Event {
+	question := qCtx.QQuestion()
+	e := Event{
+		Time:      qCtx.StartTime(),
+		Id:        qCtx.Id(),
+		Qname:     question.Name,
+		Qtype:     question.Qtype,
+		Qclass:    question.Qclass,
+		Rcode:     -1,
+		ElapsedMs: time.Since(qCtx.StartTime()).Milliseconds(),
+	}
+	if addr := qCtx.ServerMeta.ClientAddr; addr.IsValid() {
+		e.Client = addr.String()
+	}
+	if r := qCtx.R(); r != nil {
+		e.Rcode = r.Rcode
+		for _, rr := range r.Answer {
+			e.Answers = append(e.Answers, rrValue(rr))
+		}
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	e.ClientID = qCtx.ServerMeta.ClientID
+	if v, ok := qCtx.GetValue(query_context.KeyUpstream); ok {
+		e.Upstream, _ = v.(string)
+	}
+	return e
+}
+
+// rrValue returns the rdata part of rr.
+func rrValue(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.String()
+	case *dns.AAAA:
+		return rr.AAAA.String()
+	case *dns.CNAME:
+		return rr.Target
+	default:
+		return rr.String()
+	}
+}
+
+// push adds e to the queue. It never blocks.
+func (w *Webhook) push(e Event) {
+	select {
+	case w.queue <- e:
+	default:
+		w.droppedTotal.Inc()
+	}
+}
+
+func (w *Webhook) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(time.Duration(w.args.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, w.args.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.postWithRetry(batch); err != nil {
+			w.errTotal.Inc()
+			w.logger.Warn("failed to post events", zap.Int("events", len(batch)), zap.Error(err))
+		} else {
+			w.sentTotal.Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e := <-w.queue:
+			batch = append(batch, e)
+			if len(batch) >= w.args.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.closing:
+			// Drain the queue. This is the only consumer.
+			for len(w.queue) > 0 {
+				batch = append(batch, <-w.queue)
+				if len(batch) >= w.args.BatchSize {
+					flush()
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// postWithRetry posts events and retries on errors. Retries will be
+// stopped once the Webhook is closing.
+func (w *Webhook) postWithRetry(events []Event) error {
+	b, err := w.encode(events)
+	if err != nil {
+		return err
+	}
+	backoff := retryBackoff
+	for i := 0; ; i++ {
+		err = w.post(b)
+		if err == nil || i >= w.args.MaxRetries {
+			return err
+		}
+		w.errTotal.Inc()
+		w.logger.Debug("failed to post events, retrying", zap.Int("retry", i+1), zap.Error(err))
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.closing:
+			return err
+		}
+	}
+}
+
+func (w *Webhook) encode(events []Event) ([]byte, error) {
+	if w.args.Format == formatJSON {
+		return json.Marshal(events)
+	}
+	b := new(bytes.Buffer)
+	enc := json.NewEncoder(b) // Encode appends a "\n" after each event.
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func (w *Webhook) post(b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.reqURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if w.args.Format == formatJSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	for k, v := range w.args.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close flushes pending events and stops the sending loop.
+func (w *Webhook) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+	})
+	<-w.done
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+func TestWebhook_clickhouse(t *testing.T) {
+	var (
+		m        sync.Mutex
+		requests int
+		queries  []string
+		events   []Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		requests++
+		if requests == 1 { // fail the first request to test retry
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		queries = append(queries, req.URL.Query().Get("query"))
+		s := bufio.NewScanner(req.Body)
+		for s.Scan() {
+			var e Event
+			if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+				t.Errorf("invalid line %q, %v", s.Text(), err)
+			}
+			events = append(events, e)
+		}
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhook(&Args{URL: srv.URL, Format: "clickhouse", Table: "dns.queries", FlushInterval: 10}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := sequence.NewChainWalker(nil, nil)
+	for _, name := range []string{"a.com.", "b.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		if err := w.Exec(context.Background(), query_context.NewContext(q), cw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		m.Lock()
+		n := len(events)
+		m.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_ = w.Close()
+
+	m.Lock()
+	defer m.Unlock()
+	if requests != 2 {
+		t.Fatalf("want 2 requests, got %d", requests)
+	}
+	if len(queries) != 1 || queries[0] != "INSERT INTO dns.queries FORMAT JSONEachRow" {
+		t.Fatalf("unexpected queries %v", queries)
+	}
+	if len(events) != 2 || events[0].Qname != "a.com." || events[1].Qname != "b.com." {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestNewWebhook_table(t *testing.T) {
+	tests := []struct {
+		table   string
+		wantErr bool
+	}{
+		{"queries", false},
+		{"dns.queries_2", false},
+		{"", true},
+		{"q; DROP TABLE q", true},
+		{"`q`", true},
+		{"q FORMAT CSV", true},
+	}
+	for _, tt := range tests {
+		w, err := NewWebhook(&Args{URL: "http://127.0.0.1:8123", Format: "clickhouse", Table: tt.table}, nil, "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("table %q: error = %v, wantErr %v", tt.table, err, tt.wantErr)
+		}
+		if w != nil {
+			w.Close()
+		}
+	}
+}
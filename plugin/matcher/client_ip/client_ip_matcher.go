@@ -29,7 +29,7 @@ import (
 const PluginType = "client_ip"
 
 func init() {
-	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
+	sequence.MustRegMatchQuickSetup(PluginType, sequence.WireSafeMatchQuickSetup(QuickSetup))
 }
 
 type Args = base_ip.Args
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package client_name
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	base "github.com/IrineSistiana/mosdns/v5/plugin/matcher/base_domain"
+)
+
+const PluginType = "client_name"
+
+func init() {
+	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
+}
+
+// QuickSetup format: "provider_tag [exp|$domain_set|&file]..."
+// provider_tag is the tag of a plugin that can resolve client addresses
+// to hostnames (e.g. dhcp_leases).
+func QuickSetup(bq sequence.BQ, s string) (sequence.Matcher, error) {
+	tag, exps, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if len(tag) == 0 {
+		return nil, fmt.Errorf("missing hostname provider tag")
+	}
+	hp, _ := bq.M().GetPlugin(tag).(data_provider.HostnameProvider)
+	if hp == nil {
+		return nil, fmt.Errorf("cannot find hostname provider %s", tag)
+	}
+
+	return base.NewMatcher(bq, base.ParseQuickSetupArgs(exps), func(qCtx *query_context.Context, m domain.Matcher[struct{}]) (bool, error) {
+		return matchClientName(qCtx, hp, m)
+	})
+}
+
+func matchClientName(qCtx *query_context.Context, hp data_provider.HostnameProvider, m domain.Matcher[struct{}]) (bool, error) {
+	addr := qCtx.ServerMeta.ClientAddr
+	if !addr.IsValid() {
+		return false, nil
+	}
+	name, ok := hp.LookupHostname(addr)
+	if !ok {
+		return false, nil
+	}
+	_, ok = m.Match(name)
+	return ok, nil
+}
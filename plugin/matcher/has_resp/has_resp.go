@@ -34,9 +34,11 @@ func init() {
 type haveResp struct{}
 
 func (h haveResp) Match(_ context.Context, qCtx *query_context.Context) (bool, error) {
-	return qCtx.R() != nil, nil
+	return qCtx.HasResponse(), nil
 }
 
+func (h haveResp) WireSafe() bool { return true }
+
 func QuickSetup(_ sequence.BQ, _ string) (sequence.Matcher, error) {
 	return haveResp{}, nil
 }
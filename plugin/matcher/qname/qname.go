@@ -29,7 +29,7 @@ import (
 const PluginType = "qname"
 
 func init() {
-	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
+	sequence.MustRegMatchQuickSetup(PluginType, sequence.WireSafeMatchQuickSetup(QuickSetup))
 }
 
 type Args = base.Args
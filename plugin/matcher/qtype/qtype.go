@@ -28,7 +28,7 @@ import (
 const PluginType = "qtype"
 
 func init() {
-	sequence.MustRegMatchQuickSetup(PluginType, base_int.QuickSetup(matchQType))
+	sequence.MustRegMatchQuickSetup(PluginType, sequence.WireSafeMatchQuickSetup(base_int.QuickSetup(matchQType)))
 }
 
 func matchQType(qCtx *query_context.Context, m base_int.IntMatcher) (bool, error) {
@@ -121,6 +121,8 @@ func QuickSetupFromStr(s string) (sequence.Matcher, error) {
 			gf = getUrlPath
 		case "server_name":
 			gf = getServerName
+		case "client_id":
+			gf = getClientID
 		default:
 			return nil, fmt.Errorf("invalid src string name %s", srcStrName)
 		}
@@ -182,3 +184,7 @@ func getUrlPath(qCtx *query_context.Context) string {
 func getServerName(qCtx *query_context.Context) string {
 	return qCtx.ServerMeta.ServerName
 }
+
+func getClientID(qCtx *query_context.Context) string {
+	return qCtx.ServerMeta.ClientID
+}
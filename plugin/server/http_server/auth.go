@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tcp_server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type AuthArgs struct {
+	// Tokens are accepted in the header "Authorization: Bearer <token>",
+	// or as a secret path segment after the entry path, e.g.
+	// "/dns-query/<token>", for clients that cannot set headers.
+	// Tokens can only contain letters, digits and "-._~".
+	Tokens []string `yaml:"tokens"`
+	// Users are accepted basic auth credentials in "user:password" format.
+	Users []string `yaml:"users"`
+}
+
+func (a *AuthArgs) enabled() bool {
+	return len(a.Tokens)+len(a.Users) > 0
+}
+
+func (a *AuthArgs) validate() error {
+	for _, t := range a.Tokens {
+		if !validToken(t) {
+			return errors.New("token must be a non-empty string of letters, digits and \"-._~\"")
+		}
+	}
+	for _, u := range a.Users {
+		if !strings.Contains(u, ":") {
+			return errors.New("user must be in \"user:password\" format")
+		}
+	}
+	return nil
+}
+
+// validToken checks that t only contains unreserved characters of
+// RFC 3986, so it is a single path segment as is.
+func validToken(t string) bool {
+	if len(t) == 0 {
+		return false
+	}
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte("-._~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requireAuth returns a handler that rejects requests that have no
+// valid bearer token or basic auth credential of args before calling next.
+// Requests with a token as the first path segment after base are also
+// accepted. The segment is removed from the path before calling next,
+// so next sees the same path as if the token was in the header.
+func requireAuth(next http.Handler, args *AuthArgs, base string) http.Handler {
+	var tokens, bearers, users [][]byte
+	for _, t := range args.Tokens {
+		tokens = append(tokens, []byte(t))
+		bearers = append(bearers, []byte("Bearer "+t))
+	}
+	for _, u := range args.Users {
+		users = append(users, []byte(u))
+	}
+	challenge := "Bearer"
+	if len(users) > 0 {
+		challenge = `Basic realm="dns"`
+	}
+	base = strings.TrimSuffix(base, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if rest, ok := strings.CutPrefix(req.URL.Path, base+"/"); ok {
+			token, sub, hasSub := strings.Cut(rest, "/")
+			if containsSecret(tokens, []byte(token)) {
+				p := base
+				if hasSub {
+					p += "/" + sub
+				}
+				next.ServeHTTP(w, withPath(req, p))
+				return
+			}
+		}
+		if !authenticated(req, bearers, users) {
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// withPath returns a shallow copy of req with its url path replaced by p.
+func withPath(req *http.Request, p string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Path = p
+	u.RawPath = ""
+	r.URL = &u
+	return r
+}
+
+func authenticated(req *http.Request, bearers, users [][]byte) bool {
+	if user, password, ok := req.BasicAuth(); ok {
+		return containsSecret(users, []byte(user+":"+password))
+	}
+	return containsSecret(bearers, []byte(req.Header.Get("Authorization")))
+}
+
+// containsSecret reports whether s is in secrets, in constant time for
+// secrets of the same length.
+func containsSecret(secrets [][]byte, s []byte) bool {
+	found := 0
+	for _, secret := range secrets {
+		found |= subtle.ConstantTimeCompare(secret, s)
+	}
+	return found == 1
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tcp_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_requireAuth(t *testing.T) {
+	var gotPath string
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { gotPath = req.URL.Path })
+	h := requireAuth(ok, &AuthArgs{Tokens: []string{"t1", "t2"}, Users: []string{"alice:secret"}}, "/dns-query")
+
+	tests := []struct {
+		name     string
+		path     string
+		setup    func(req *http.Request)
+		want     int
+		wantPath string
+	}{
+		{"no auth", "/dns-query", func(req *http.Request) {}, http.StatusUnauthorized, ""},
+		{"secret path", "/dns-query/t1", func(req *http.Request) {}, http.StatusOK, "/dns-query"},
+		{"secret path with client id", "/dns-query/t2/phone", func(req *http.Request) {}, http.StatusOK, "/dns-query/phone"},
+		{"wrong secret path", "/dns-query/t3", func(req *http.Request) {}, http.StatusUnauthorized, ""},
+		{"client id with bearer", "/dns-query/phone", func(req *http.Request) { req.Header.Set("Authorization", "Bearer t1") }, http.StatusOK, "/dns-query/phone"},
+		{"token in other segment", "/dns-query/phone/t1", func(req *http.Request) {}, http.StatusUnauthorized, ""},
+		{"bearer", "/dns-query", func(req *http.Request) { req.Header.Set("Authorization", "Bearer t2") }, http.StatusOK, "/dns-query"},
+		{"wrong bearer", "/dns-query", func(req *http.Request) { req.Header.Set("Authorization", "Bearer t3") }, http.StatusUnauthorized, ""},
+		{"basic", "/dns-query", func(req *http.Request) { req.SetBasicAuth("alice", "secret") }, http.StatusOK, "/dns-query"},
+		{"wrong basic", "/dns-query", func(req *http.Request) { req.SetBasicAuth("alice", "t1") }, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath = ""
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			tt.setup(req)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("want status %d, got %d", tt.want, w.Code)
+			}
+			if gotPath != tt.wantPath {
+				t.Fatalf("want path %q, got %q", tt.wantPath, gotPath)
+			}
+			if w.Code == http.StatusUnauthorized && len(w.Header().Get("WWW-Authenticate")) == 0 {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAuthArgs_validate(t *testing.T) {
+	for _, a := range []AuthArgs{
+		{Tokens: []string{""}},
+		{Tokens: []string{"a/b"}},
+		{Tokens: []string{"a b"}},
+		{Tokens: []string{"a{b}"}},
+		{Tokens: []string{"{x}"}},
+		{Tokens: []string{"a%2Fb"}},
+		{Users: []string{"alice"}},
+	} {
+		if err := a.validate(); err == nil {
+			t.Fatalf("%+v should be invalid", a)
+		}
+	}
+	a := AuthArgs{Tokens: []string{"Az09-._~"}}
+	if err := a.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
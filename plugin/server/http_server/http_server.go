@@ -21,6 +21,8 @@ package tcp_server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -29,6 +31,7 @@ import (
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
 	"go.uber.org/zap"
@@ -51,6 +54,22 @@ type Args struct {
 	Cert        string `yaml:"cert"`
 	Key         string `yaml:"key"`
 	IdleTimeout int    `yaml:"idle_timeout"`
+
+	// ClientIDFromPath enables device ids in path suffixes,
+	// e.g. "/dns-query/phone-abc".
+	ClientIDFromPath bool `yaml:"client_id_from_path"`
+	// ClientIDDomain enables device ids in TLS server names,
+	// e.g. "phone-abc.dns.example.com" with domain "dns.example.com".
+	ClientIDDomain string `yaml:"client_id_domain"`
+	// ClientEntries are entries (device id -> executable tag) for queries
+	// of those devices, e.g. {"tv": "seq_tv"} handles queries to
+	// "tv.dns.example.com" or "/dns-query/tv" by "seq_tv". Queries of other devices are
+	// handled by the exec of their path.
+	ClientEntries map[string]string `yaml:"client_entries"`
+
+	// Auth rejects unauthenticated requests before they are read.
+	// Optional.
+	Auth AuthArgs `yaml:"auth"`
 }
 
 func (a *Args) init() {
@@ -60,10 +79,22 @@ func (a *Args) init() {
 type HttpServer struct {
 	args *Args
 
+	l      net.Listener
 	server *http.Server
 }
 
+// drainTimeout is the maximum time that Close waits for requests in flight.
+const drainTimeout = time.Second * 5
+
+// Close closes the listener and waits for requests in flight.
 func (s *HttpServer) Close() error {
+	if s.server == nil { // dry run
+		return nil
+	}
+	shared_listener.Release(s.l)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	_ = s.server.Shutdown(ctx)
 	return s.server.Close()
 }
 
@@ -72,18 +103,50 @@ func Init(bp *coremain.BP, args any) (any, error) {
 }
 
 func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
+	if err := args.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth args, %w", err)
+	}
+
+	if len(args.ClientEntries) > 0 && len(args.ClientIDDomain) == 0 && !args.ClientIDFromPath {
+		return nil, errors.New("client_entries requires client_id_domain or client_id_from_path")
+	}
+
 	mux := http.NewServeMux()
 	for _, entry := range args.Entries {
 		dh, err := server_utils.NewHandler(bp, entry.Exec)
 		if err != nil {
 			return nil, fmt.Errorf("failed to init dns handler, %w", err)
 		}
+		if err := dh.SetClientEntries(bp, args.ClientEntries); err != nil {
+			return nil, fmt.Errorf("failed to init dns handler, %w", err)
+		}
+		h := server_utils.WithClientID(dh, args.ClientIDDomain)
 		hhOpts := server.HttpHandlerOpts{
 			GetSrcIPFromHeader: args.SrcIPHeader,
 			Logger:             bp.L(),
 		}
-		hh := server.NewHttpHandler(dh, hhOpts)
+		if args.ClientIDFromPath {
+			hhOpts.ClientIDPath = entry.Path
+		}
+		var hh http.Handler = server.NewHttpHandler(h, hhOpts)
+		if args.Auth.enabled() {
+			// Secret paths are checked by requireAuth, tokens are never
+			// used in patterns of the mux.
+			hh = requireAuth(hh, &args.Auth, entry.Path)
+		}
 		mux.Handle(entry.Path, hh)
+		if (args.ClientIDFromPath || args.Auth.enabled()) && !strings.HasSuffix(entry.Path, "/") {
+			mux.Handle(entry.Path+"/", hh)
+		}
+	}
+
+	if bp.M().DryRun() {
+		if len(args.Key)+len(args.Cert) > 0 {
+			if _, err := tls.LoadX509KeyPair(args.Cert, args.Key); err != nil {
+				return nil, fmt.Errorf("failed to read tls cert, %w", err)
+			}
+		}
+		return &HttpServer{args: args}, nil
 	}
 
 	socketOpt := server_utils.ListenerSocketOpts{
@@ -96,7 +159,7 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 	if strings.HasPrefix(args.Listen, "@") {
 		listenerNetwork = "unix"
 	}
-	l, err := lc.Listen(context.Background(), listenerNetwork, args.Listen)
+	l, err := shared_listener.Listen(&lc, listenerNetwork, args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen socket, %w", err)
 	}
@@ -114,6 +177,8 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 		MaxUploadBufferPerConnection: 65535,
 		MaxUploadBufferPerStream:     65535,
 	}); err != nil {
+		shared_listener.Release(l)
+		l.Close()
 		return nil, fmt.Errorf("failed to setup http2 server, %w", err)
 	}
 
@@ -128,6 +193,7 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 	}()
 	return &HttpServer{
 		args:   args,
+		l:      l,
 		server: hs,
 	}, nil
 }
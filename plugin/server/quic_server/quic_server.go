@@ -28,6 +28,7 @@ import (
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
 	"github.com/quic-go/quic-go"
@@ -46,6 +47,15 @@ type Args struct {
 	Cert        string `yaml:"cert"`
 	Key         string `yaml:"key"`
 	IdleTimeout int    `yaml:"idle_timeout"`
+
+	// ClientIDDomain enables device ids in TLS server names,
+	// e.g. "phone-abc.dns.example.com" with domain "dns.example.com".
+	ClientIDDomain string `yaml:"client_id_domain"`
+	// ClientEntries are entries (device id -> executable tag) for queries
+	// of those devices, e.g. {"tv": "seq_tv"} handles queries to
+	// "tv.dns.example.com" by "seq_tv". Queries of other devices are
+	// handled by Entry.
+	ClientEntries map[string]string `yaml:"client_entries"`
 }
 
 func (a *Args) init() {
@@ -55,11 +65,24 @@ func (a *Args) init() {
 type QuicServer struct {
 	args *Args
 
-	l *quic.Listener
+	dh *server_utils.Handler
+	uc *net.UDPConn
+	qt *quic.Transport
+	l  *quic.Listener
 }
 
+// Close closes the listener, waits for queries in flight and closes
+// the transport.
 func (s *QuicServer) Close() error {
-	return s.l.Close()
+	if s.l == nil { // dry run
+		return nil
+	}
+	shared_listener.Release(s.uc)
+	err := s.l.Close()
+	s.dh.WaitDrained()
+	_ = s.qt.Close()
+	_ = s.uc.Close()
+	return err
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
@@ -73,6 +96,13 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
+	if len(args.ClientEntries) > 0 && len(args.ClientIDDomain) == 0 {
+		return nil, errors.New("client_entries requires client_id_domain")
+	}
+	if err := dh.SetClientEntries(bp, args.ClientEntries); err != nil {
+		return nil, fmt.Errorf("failed to init dns handler, %w", err)
+	}
+	h := server_utils.WithClientID(dh, args.ClientIDDomain)
 
 	// Init tls
 	if len(args.Key) == 0 || len(args.Cert) == 0 {
@@ -84,7 +114,11 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 	}
 	tlsConfig.NextProtos = []string{"doq"}
 
-	uc, err := net.ListenPacket("udp", args.Listen)
+	if bp.M().DryRun() {
+		return &QuicServer{args: args}, nil
+	}
+
+	uc, err := shared_listener.ListenUDP(new(net.ListenConfig), "udp", args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen socket, %w", err)
 	}
@@ -115,18 +149,22 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 	quicListener, err := qt.Listen(tlsConfig, quicConfig)
 	if err != nil {
 		qt.Close()
+		shared_listener.Release(uc)
+		uc.Close()
 		return nil, fmt.Errorf("failed to listen quic, %w", err)
 	}
 	bp.L().Info("quic server started", zap.Stringer("addr", quicListener.Addr()))
 
 	go func() {
-		defer quicListener.Close()
 		serverOpts := server.DoQServerOpts{Logger: bp.L(), IdleTimeout: idleTimeout}
-		err := server.ServeDoQ(quicListener, dh, serverOpts)
+		err := server.ServeDoQ(quicListener, h, serverOpts)
 		bp.M().GetSafeClose().SendCloseSignal(err)
 	}()
 	return &QuicServer{
 		args: args,
+		dh:   dh,
+		uc:   uc,
+		qt:   qt,
 		l:    quicListener,
 	}, nil
 }
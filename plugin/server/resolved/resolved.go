@@ -0,0 +1,277 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package resolved
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/godbus/dbus/v5"
+	"go.uber.org/zap"
+)
+
+const PluginType = "resolved"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const (
+	resolve1Dest      = "org.freedesktop.resolve1"
+	resolve1Path      = dbus.ObjectPath("/org/freedesktop/resolve1")
+	resolve1Interface = "org.freedesktop.resolve1.Manager"
+
+	afInet  = 2
+	afInet6 = 10
+
+	busTimeout = time.Second * 5
+)
+
+type Args struct {
+	// Links are names of network interfaces, e.g. "eth0", whose dns
+	// servers are set to mosdns. Loopback interfaces are not accepted
+	// by systemd-resolved.
+	Links []string `yaml:"links"`
+	// Servers are addresses ("ip" or "ip:port") of mosdns servers.
+	// Default is "127.0.0.1".
+	Servers []string `yaml:"servers"`
+	// Domains of the links. Domains with a "~" prefix are only used to
+	// route queries, not to search. Default is "~.", which routes all
+	// queries to mosdns.
+	Domains []string `yaml:"domains"`
+	// Socket of the system bus. Default is "/run/dbus/system_bus_socket".
+	Socket string `yaml:"socket"`
+	// Interval in seconds to register again, in case the links were
+	// reconfigured, e.g. by NetworkManager. Default is 60.
+	Interval int `yaml:"interval"`
+}
+
+func (a *Args) init() {
+	if len(a.Servers) == 0 {
+		a.Servers = []string{"127.0.0.1"}
+	}
+	if len(a.Domains) == 0 {
+		a.Domains = []string{"~."}
+	}
+	utils.SetDefaultString(&a.Socket, "/run/dbus/system_bus_socket")
+	utils.SetDefaultUnsignNum(&a.Interval, 60)
+}
+
+var (
+	ownersM sync.Mutex
+	owners  = make(map[string]*Resolved) // link name -> the latest instance
+)
+
+// Resolved registers mosdns as the dns servers of links to
+// systemd-resolved over D-Bus, so /etc/resolv.conf can still point to
+// resolved's stub. The links are reverted once the plugin is closed,
+// unless a newer instance (e.g. after a hot reload) took them over.
+// mosdns needs to be root or authorized by polkit to configure links.
+// If resolved is not running, it only logs warnings.
+type Resolved struct {
+	args    *Args
+	logger  *zap.Logger
+	servers []netip.AddrPort
+
+	closeNotify chan struct{}
+	loopDone    chan struct{}
+	closeOnce   sync.Once
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return NewResolved(args.(*Args), bp.L())
+}
+
+func NewResolved(args *Args, logger *zap.Logger) (*Resolved, error) {
+	args.init()
+	if len(args.Links) == 0 {
+		return nil, errors.New("no link is configured")
+	}
+	r := &Resolved{
+		args:        args,
+		logger:      logger,
+		closeNotify: make(chan struct{}),
+		loopDone:    make(chan struct{}),
+	}
+	for _, s := range args.Servers {
+		addr, err := parseServer(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server %s, %w", s, err)
+		}
+		r.servers = append(r.servers, addr)
+	}
+
+	ownersM.Lock()
+	for _, link := range args.Links {
+		owners[link] = r
+	}
+	ownersM.Unlock()
+
+	r.register()
+	go r.registerLoop()
+	return r, nil
+}
+
+// parseServer parses "ip" or "ip:port".
+func parseServer(s string) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(addr, 53), nil
+	}
+	return netip.ParseAddrPort(s)
+}
+
+func (r *Resolved) registerLoop() {
+	defer close(r.loopDone)
+	ticker := time.NewTicker(time.Duration(r.args.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.register()
+		case <-r.closeNotify:
+			return
+		}
+	}
+}
+
+// register configures all links.
+func (r *Resolved) register() {
+	ctx, cancel := context.WithTimeout(context.Background(), busTimeout)
+	defer cancel()
+	conn, err := dbus.Connect("unix:path="+r.args.Socket, dbus.WithContext(ctx))
+	if err != nil {
+		r.logger.Warn("failed to connect to system bus", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	obj := conn.Object(resolve1Dest, resolve1Path)
+	for _, link := range r.args.Links {
+		if err := r.setLink(ctx, obj, link); err != nil {
+			r.logger.Warn("failed to configure link", zap.String("link", link), zap.Error(err))
+		}
+	}
+}
+
+// linkDNS is the (iayqs) struct of SetLinkDNSEx.
+type linkDNS struct {
+	Family     int32
+	Address    []byte
+	Port       uint16
+	ServerName string
+}
+
+// linkDomain is the (sb) struct of SetLinkDomains.
+type linkDomain struct {
+	Domain    string
+	RouteOnly bool
+}
+
+func (r *Resolved) setLink(ctx context.Context, obj dbus.BusObject, link string) error {
+	idx, err := linkIndex(link)
+	if err != nil {
+		return err
+	}
+	servers := make([]linkDNS, 0, len(r.servers))
+	for _, s := range r.servers {
+		addr := s.Addr().Unmap()
+		family := int32(afInet)
+		if addr.Is6() {
+			family = afInet6
+		}
+		servers = append(servers, linkDNS{Family: family, Address: addr.AsSlice(), Port: s.Port()})
+	}
+	domains := make([]linkDomain, 0, len(r.args.Domains))
+	for _, d := range r.args.Domains {
+		domains = append(domains, linkDomain{Domain: strings.TrimPrefix(d, "~"), RouteOnly: strings.HasPrefix(d, "~")})
+	}
+
+	if err := obj.CallWithContext(ctx, resolve1Interface+".SetLinkDNSEx", 0, idx, servers).Err; err != nil {
+		return err
+	}
+	if err := obj.CallWithContext(ctx, resolve1Interface+".SetLinkDomains", 0, idx, domains).Err; err != nil {
+		return err
+	}
+	return obj.CallWithContext(ctx, resolve1Interface+".SetLinkDefaultRoute", 0, idx, true).Err
+}
+
+func linkIndex(link string) (int32, error) {
+	iface, err := net.InterfaceByName(link)
+	if err != nil {
+		return 0, err
+	}
+	return int32(iface.Index), nil
+}
+
+// Close stops registering and reverts the links. Links that were
+// registered by a newer instance (e.g. after a hot reload) are kept.
+func (r *Resolved) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closeNotify)
+		<-r.loopDone
+		r.revert(r.release())
+	})
+	return nil
+}
+
+// release releases the links that are still owned by r and returns them.
+func (r *Resolved) release() []string {
+	ownersM.Lock()
+	defer ownersM.Unlock()
+	var links []string
+	for _, link := range r.args.Links {
+		if owners[link] == r {
+			delete(owners, link)
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func (r *Resolved) revert(links []string) {
+	if len(links) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), busTimeout)
+	defer cancel()
+	conn, err := dbus.Connect("unix:path="+r.args.Socket, dbus.WithContext(ctx))
+	if err != nil {
+		r.logger.Warn("failed to connect to system bus", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	obj := conn.Object(resolve1Dest, resolve1Path)
+	for _, link := range links {
+		idx, err := linkIndex(link)
+		if err == nil {
+			err = obj.CallWithContext(ctx, resolve1Interface+".RevertLink", 0, idx).Err
+		}
+		if err != nil {
+			r.logger.Warn("failed to revert link", zap.String("link", link), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package resolved
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"go.uber.org/zap"
+)
+
+// fakeBus is a bus that replies all method calls with empty returns.
+type fakeBus struct {
+	sync.Mutex
+	calls []*dbus.Message
+}
+
+func (b *fakeBus) serve(t *testing.T, l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			if err := b.auth(c, r); err != nil {
+				t.Errorf("auth failed, %v", err)
+				return
+			}
+			for {
+				m, err := dbus.DecodeMessage(r)
+				if err != nil {
+					return
+				}
+				b.Lock()
+				b.calls = append(b.calls, m)
+				b.Unlock()
+				reply := &dbus.Message{
+					Type:    dbus.TypeMethodReply,
+					Headers: map[dbus.HeaderField]dbus.Variant{dbus.FieldReplySerial: dbus.MakeVariant(m.Serial())},
+				}
+				if m.Headers[dbus.FieldMember].Value() == "Hello" {
+					reply.Headers[dbus.FieldSignature] = dbus.MakeVariant(dbus.SignatureOf(""))
+					reply.Body = []any{":1.1"}
+				}
+				if reply.EncodeTo(c, binary.LittleEndian) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// auth accepts any EXTERNAL auth.
+func (b *fakeBus) auth(c net.Conn, r *bufio.Reader) error {
+	if _, err := r.ReadByte(); err != nil { // null byte
+		return err
+	}
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case l == "AUTH\r\n":
+			_, err = c.Write([]byte("REJECTED EXTERNAL\r\n"))
+		case strings.HasPrefix(l, "AUTH EXTERNAL"):
+			_, err = c.Write([]byte("OK 0123456789abcdef0123456789abcdef\r\n"))
+		case l == "NEGOTIATE_UNIX_FD\r\n":
+			_, err = c.Write([]byte("ERROR\r\n"))
+		case l == "BEGIN\r\n":
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *fakeBus) members() []string {
+	b.Lock()
+	defer b.Unlock()
+	var s []string
+	for _, m := range b.calls {
+		s = append(s, m.Headers[dbus.FieldMember].Value().(string))
+	}
+	return s
+}
+
+func (b *fakeBus) reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.calls = nil
+}
+
+func newFakeBus(t *testing.T) (*fakeBus, string) {
+	socket := filepath.Join(t.TempDir(), "bus")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	bus := new(fakeBus)
+	go bus.serve(t, l)
+	return bus, socket
+}
+
+func TestResolved(t *testing.T) {
+	bus, socket := newFakeBus(t)
+
+	r, err := NewResolved(&Args{Links: []string{"lo"}, Servers: []string{"127.0.0.1", "[::1]:5353"}, Socket: socket}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hello,SetLinkDNSEx,SetLinkDomains,SetLinkDefaultRoute"
+	if got := strings.Join(bus.members(), ","); got != want {
+		t.Fatalf("want calls %s, got %s", want, got)
+	}
+
+	bus.Lock()
+	dnsCall, domainsCall := bus.calls[1], bus.calls[2]
+	bus.Unlock()
+	if sig := dnsCall.Headers[dbus.FieldSignature].Value().(dbus.Signature).String(); sig != "ia(iayqs)" {
+		t.Fatalf("unexpected signature %s", sig)
+	}
+	lo, _ := net.InterfaceByName("lo")
+	if idx := dnsCall.Body[0].(int32); idx != int32(lo.Index) {
+		t.Fatalf("unexpected link index %d", idx)
+	}
+	var servers []linkDNS
+	if err := dbus.Store(dnsCall.Body[1:], &servers); err != nil {
+		t.Fatal(err)
+	}
+	wantServers := []linkDNS{
+		{Family: afInet, Address: []byte{127, 0, 0, 1}, Port: 53},
+		{Family: afInet6, Address: net.IPv6loopback, Port: 5353},
+	}
+	if !slices.EqualFunc(servers, wantServers, func(a, b linkDNS) bool {
+		return a.Family == b.Family && string(a.Address) == string(b.Address) && a.Port == b.Port
+	}) {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+
+	if sig := domainsCall.Headers[dbus.FieldSignature].Value().(dbus.Signature).String(); sig != "ia(sb)" {
+		t.Fatalf("unexpected signature %s", sig)
+	}
+	var domains []linkDomain
+	if err := dbus.Store(domainsCall.Body[1:], &domains); err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || domains[0] != (linkDomain{Domain: ".", RouteOnly: true}) {
+		t.Fatalf("unexpected domains %v", domains)
+	}
+
+	r.Close()
+	if got := bus.members(); got[len(got)-1] != "RevertLink" {
+		t.Fatalf("link is not reverted, %v", got)
+	}
+}
+
+func TestResolved_reload(t *testing.T) {
+	bus, socket := newFakeBus(t)
+	args := func() *Args { return &Args{Links: []string{"lo"}, Socket: socket} }
+
+	r1, err := NewResolved(args(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := NewResolved(args(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old instance is closed after the new one registered the link.
+	bus.reset()
+	r1.Close()
+	if got := bus.members(); slices.Contains(got, "RevertLink") {
+		t.Fatalf("link registered by the new instance was reverted, %v", got)
+	}
+	r2.Close()
+	if got := bus.members(); !slices.Contains(got, "RevertLink") {
+		t.Fatalf("link is not reverted, %v", got)
+	}
+}
@@ -20,15 +20,115 @@
 package server_utils
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server_handler"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
 )
 
-func NewHandler(bp *coremain.BP, entry string) (server.Handler, error) {
+// drainTimeout is the maximum time that a closing server waits for
+// its queries in flight.
+const drainTimeout = time.Second * 5
+
+// Handler is a server.Handler that tracks queries in flight, so a closing
+// server can wait for them. See Handler.WaitDrained.
+// Queries from client ids in SetClientEntries are handled by their own
+// entries.
+type Handler struct {
+	next     server.Handler
+	clients  map[string]server.Handler // client id -> entry handler
+	inflight atomic.Int64
+}
+
+// nextOf returns the entry handler of the query.
+func (h *Handler) nextOf(meta server.QueryMeta) server.Handler {
+	if len(h.clients) > 0 {
+		if next, ok := h.clients[strings.ToLower(meta.ClientID)]; ok {
+			return next
+		}
+	}
+	return h.next
+}
+
+// Handle implements server.Handler.
+// Queries in flight are not canceled when the server is closing. They
+// are still limited by the query timeout of the entry handler, and are
+// canceled if the client is gone. See server.WithoutServerCancel.
+func (h *Handler) Handle(ctx context.Context, q *dns.Msg, meta server.QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	ctx, cancel := server.WithoutServerCancel(ctx)
+	defer cancel()
+	return h.nextOf(meta).Handle(ctx, q, meta, packMsgPayload)
+}
+
+// HandleWire implements server.WireHandler.
+func (h *Handler) HandleWire(
+	ctx context.Context,
+	q []byte,
+	meta server.QueryMeta,
+	packMsgPayload func(m *dns.Msg) (*[]byte, error),
+	copyPayload func(b []byte) (*[]byte, error),
+) (*[]byte, bool) {
+	next, ok := h.nextOf(meta).(server.WireHandler)
+	if !ok {
+		return nil, false
+	}
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	ctx, cancel := server.WithoutServerCancel(ctx)
+	defer cancel()
+	return next.HandleWire(ctx, q, meta, packMsgPayload, copyPayload)
+}
+
+// WaitDrained waits until there is no query in flight, or up to
+// drainTimeout. Servers call it after they stop reading new queries.
+func (h *Handler) WaitDrained() {
+	const interval = time.Millisecond * 10
+	deadline := time.Now().Add(drainTimeout)
+	for h.inflight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(interval)
+	}
+}
+
+func NewHandler(bp *coremain.BP, entry string) (*Handler, error) {
+	next, err := newEntryHandler(bp, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{next: next}, nil
+}
+
+// SetClientEntries sets entries (client id -> entry tag) for queries of
+// those clients, e.g. devices that use distinct DoT server names under
+// a wildcard certificate. Other queries are handled by the default
+// entry. Ids are case-insensitive. It must be called before the
+// Handler is used.
+func (h *Handler) SetClientEntries(bp *coremain.BP, entries map[string]string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	clients := make(map[string]server.Handler, len(entries))
+	for id, entry := range entries {
+		next, err := newEntryHandler(bp, entry)
+		if err != nil {
+			return fmt.Errorf("invalid entry of client %s, %w", id, err)
+		}
+		clients[strings.ToLower(id)] = next
+	}
+	h.clients = clients
+	return nil
+}
+
+func newEntryHandler(bp *coremain.BP, entry string) (server.Handler, error) {
 	p := bp.M().GetPlugin(entry)
 	exec := sequence.ToExecutable(p)
 	if exec == nil {
@@ -36,8 +136,21 @@ func NewHandler(bp *coremain.BP, entry string) (server.Handler, error) {
 	}
 
 	handlerOpts := server_handler.EntryHandlerOpts{
-		Logger: bp.L(),
-		Entry:  exec,
+		Logger:      bp.L(),
+		Entry:       exec,
+		Passthrough: sequence.IsWireSafe(p),
+	}
+	if handlerOpts.Passthrough {
+		bp.L().Info("entry is pure forwarding, queries will be relayed in wire format", zap.String("entry", entry))
 	}
 	return server_handler.NewEntryHandler(handlerOpts), nil
 }
+
+// WithClientID wraps h to parse client ids from the tls server name.
+// It returns h if domain is empty.
+func WithClientID(h server.Handler, domain string) server.Handler {
+	if len(domain) == 0 {
+		return h
+	}
+	return &server.ClientIDHandler{Next: h, Domain: domain}
+}
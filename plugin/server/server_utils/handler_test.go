@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_utils
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// answer returns an executable that answers A queries with ip.
+func answer(ip net.IP) sequence.ExecutableFunc {
+	return func(ctx context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: qCtx.QQuestion().Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: ip})
+		qCtx.SetResponse(r)
+		return nil
+	}
+}
+
+func TestHandler_ClientEntries(t *testing.T) {
+	m := coremain.NewTestMosdnsWithPlugins(map[string]any{
+		"default": answer(net.IPv4(1, 1, 1, 1)),
+		"tv":      answer(net.IPv4(2, 2, 2, 2)),
+	})
+	bp := coremain.NewBP("server", m)
+	dh, err := NewHandler(bp, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dh.SetClientEntries(bp, map[string]string{"TV": "tv"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dh.SetClientEntries(bp, map[string]string{"phone": "not_exist"}); err == nil {
+		t.Fatal("want error for unknown entry")
+	}
+	if err := dh.SetClientEntries(bp, map[string]string{"tv": "tv"}); err != nil {
+		t.Fatal(err)
+	}
+	h := WithClientID(dh, "dns.home.arpa")
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"tv.dns.home.arpa", "2.2.2.2"},
+		{"TV.dns.home.arpa", "2.2.2.2"},
+		{"phone.dns.home.arpa", "1.1.1.1"},
+		{"dns.home.arpa", "1.1.1.1"},
+		{"", "1.1.1.1"},
+	}
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", dns.TypeA)
+		b := h.Handle(context.Background(), q, server.QueryMeta{ServerName: tt.serverName}, pool.PackBuffer)
+		if b == nil {
+			t.Fatalf("%s: no response", tt.serverName)
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(*b); err != nil {
+			t.Fatal(err)
+		}
+		pool.ReleaseBuf(b)
+		if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != tt.want {
+			t.Fatalf("%s: want %s, got %v", tt.serverName, tt.want, r.Answer)
+		}
+	}
+}
@@ -9,6 +9,7 @@ func NopControlFunc(network, address string, c syscall.RawConn) error {
 }
 
 type ListenerSocketOpts struct {
+	SO_REUSEADDR bool
 	SO_REUSEPORT bool
 	SO_RCVBUF    int
 	SO_SNDBUF    int
@@ -16,6 +16,13 @@ func ListenerControl(opt ListenerSocketOpts) ControlFunc {
 		)
 
 		errControl = c.Control(func(fd uintptr) {
+			if opt.SO_REUSEADDR {
+				errSyscall = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+				if errSyscall != nil {
+					return
+				}
+			}
+
 			if opt.SO_REUSEPORT {
 				errSyscall = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
 				if errSyscall != nil {
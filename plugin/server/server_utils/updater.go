@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/miekg/dns"
+)
+
+// Updater handles dns UPDATE messages (RFC 2136) in wire format, which
+// are needed to verify their TSIG. It returns the response in wire
+// format, or nil if no response should be sent.
+type Updater interface {
+	Update(ctx context.Context, q []byte, meta server.QueryMeta) []byte
+}
+
+// WithUpdater wraps h to handle UPDATE messages by the Updater plugin
+// tag. Other messages are passed to h. It returns h if tag is empty.
+func WithUpdater(bp *coremain.BP, h server.Handler, tag string) (server.Handler, error) {
+	if len(tag) == 0 {
+		return h, nil
+	}
+	u, _ := bp.M().GetPlugin(tag).(Updater)
+	if u == nil {
+		return nil, fmt.Errorf("%s is not an Updater", tag)
+	}
+	return &updateHandler{next: h, u: u}, nil
+}
+
+type updateHandler struct {
+	next server.Handler
+	u    Updater
+}
+
+var _ server.WireHandler = (*updateHandler)(nil)
+
+// Handle implements server.Handler. UPDATE messages are handled by
+// HandleWire, so it just passes q to the next Handler.
+func (h *updateHandler) Handle(ctx context.Context, q *dns.Msg, meta server.QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	return h.next.Handle(ctx, q, meta, packMsgPayload)
+}
+
+// HandleWire implements server.WireHandler.
+func (h *updateHandler) HandleWire(
+	ctx context.Context,
+	q []byte,
+	meta server.QueryMeta,
+	packMsgPayload func(m *dns.Msg) (*[]byte, error),
+	copyPayload func(b []byte) (*[]byte, error),
+) (*[]byte, bool) {
+	if len(q) >= 12 && q[2]&0x80 == 0 && int(q[2]>>3&0xf) == dns.OpcodeUpdate {
+		r := h.u.Update(ctx, q, meta)
+		if r == nil {
+			return nil, true
+		}
+		payload, err := copyPayload(r)
+		if err != nil {
+			return nil, true
+		}
+		return payload, true
+	}
+	next, ok := h.next.(server.WireHandler)
+	if !ok {
+		return nil, false
+	}
+	return next.HandleWire(ctx, q, meta, packMsgPayload, copyPayload)
+}
@@ -20,8 +20,8 @@
 package tcp_server
 
 import (
-	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -29,6 +29,7 @@ import (
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
 	"go.uber.org/zap"
@@ -46,6 +47,19 @@ type Args struct {
 	Cert        string `yaml:"cert"`
 	Key         string `yaml:"key"`
 	IdleTimeout int    `yaml:"idle_timeout"`
+
+	// ClientIDDomain enables device ids in TLS server names,
+	// e.g. "phone-abc.dns.example.com" with domain "dns.example.com".
+	ClientIDDomain string `yaml:"client_id_domain"`
+	// ClientEntries are entries (device id -> executable tag) for queries
+	// of those devices, e.g. {"tv": "seq_tv"} handles queries to
+	// "tv.dns.example.com" by "seq_tv". Queries of other devices are
+	// handled by Entry.
+	ClientEntries map[string]string `yaml:"client_entries"`
+
+	// Update is the tag of a plugin that handles dns UPDATE messages,
+	// e.g. a dyn_update. Default is no UPDATE support.
+	Update string `yaml:"update"`
 }
 
 func (a *Args) init() {
@@ -56,11 +70,19 @@ func (a *Args) init() {
 type TcpServer struct {
 	args *Args
 
-	l net.Listener
+	dh *server_utils.Handler
+	l  net.Listener // raw listener, without tls.
 }
 
+// Close closes the listener and waits for queries in flight.
 func (s *TcpServer) Close() error {
-	return s.l.Close()
+	if s.l == nil { // dry run
+		return nil
+	}
+	shared_listener.Release(s.l)
+	err := s.l.Close()
+	s.dh.WaitDrained()
+	return err
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
@@ -72,6 +94,16 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
+	if len(args.ClientEntries) > 0 && len(args.ClientIDDomain) == 0 {
+		return nil, errors.New("client_entries requires client_id_domain")
+	}
+	if err := dh.SetClientEntries(bp, args.ClientEntries); err != nil {
+		return nil, fmt.Errorf("failed to init dns handler, %w", err)
+	}
+	h, err := server_utils.WithUpdater(bp, server_utils.WithClientID(dh, args.ClientIDDomain), args.Update)
+	if err != nil {
+		return nil, err
+	}
 
 	// Init tls
 	var tc *tls.Config
@@ -82,6 +114,10 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 		}
 	}
 
+	if bp.M().DryRun() {
+		return &TcpServer{args: args}, nil
+	}
+
 	socketOpt := server_utils.ListenerSocketOpts{
 		SO_REUSEPORT: true,
 		SO_RCVBUF:    64 * 1024,
@@ -91,23 +127,24 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 	if strings.HasPrefix(args.Listen, "@") {
 		listenerNetwork = "unix"
 	}
-	l, err := lc.Listen(context.Background(), listenerNetwork, args.Listen)
+	rl, err := shared_listener.Listen(&lc, listenerNetwork, args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen socket, %w", err)
 	}
+	l := rl
 	if tc != nil {
-		l = tls.NewListener(l, tc)
+		l = tls.NewListener(rl, tc)
 	}
 	bp.L().Info("tcp server started", zap.Stringer("addr", l.Addr()), zap.Bool("tls", tc != nil))
 
 	go func() {
-		defer l.Close()
 		serverOpts := server.TCPServerOpts{Logger: bp.L(), IdleTimeout: time.Duration(args.IdleTimeout) * time.Second}
-		err := server.ServeTCP(l, dh, serverOpts)
+		err := server.ServeTCP(l, h, serverOpts)
 		bp.M().GetSafeClose().SendCloseSignal(err)
 	}()
 	return &TcpServer{
 		args: args,
-		l:    l,
+		dh:   dh,
+		l:    rl,
 	}, nil
 }
@@ -20,12 +20,13 @@
 package udp_server
 
 import (
-	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/shared_listener"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/server/server_utils"
 	"go.uber.org/zap"
@@ -40,6 +41,10 @@ func init() {
 type Args struct {
 	Entry  string `yaml:"entry"`
 	Listen string `yaml:"listen"`
+
+	// Update is the tag of a plugin that handles dns UPDATE messages,
+	// e.g. a dyn_update. Default is no UPDATE support.
+	Update string `yaml:"update"`
 }
 
 func (a *Args) init() {
@@ -49,10 +54,19 @@ func (a *Args) init() {
 type UdpServer struct {
 	args *Args
 
-	c net.PacketConn
+	dh *server_utils.Handler
+	c  *net.UDPConn
 }
 
+// Close stops reading new queries, waits for queries in flight and
+// closes the socket.
 func (s *UdpServer) Close() error {
+	if s.c == nil { // dry run
+		return nil
+	}
+	shared_listener.Release(s.c)
+	_ = s.c.SetReadDeadline(time.Now())
+	s.dh.WaitDrained()
 	return s.c.Close()
 }
 
@@ -65,25 +79,33 @@ func StartServer(bp *coremain.BP, args *Args) (*UdpServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
+	h, err := server_utils.WithUpdater(bp, dh, args.Update)
+	if err != nil {
+		return nil, err
+	}
+
+	if bp.M().DryRun() {
+		return &UdpServer{args: args}, nil
+	}
 
 	socketOpt := server_utils.ListenerSocketOpts{
 		SO_REUSEPORT: true,
 		SO_RCVBUF:    64 * 1024,
 	}
 	lc := net.ListenConfig{Control: server_utils.ListenerControl(socketOpt)}
-	c, err := lc.ListenPacket(context.Background(), "udp", args.Listen)
+	c, err := shared_listener.ListenUDP(&lc, "udp", args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create socket, %w", err)
 	}
 	bp.L().Info("udp server started", zap.Stringer("addr", c.LocalAddr()))
 
 	go func() {
-		defer c.Close()
-		err := server.ServeUDP(c.(*net.UDPConn), dh, server.UDPServerOpts{Logger: bp.L()})
+		err := server.ServeUDP(c, h, server.UDPServerOpts{Logger: bp.L()})
 		bp.M().GetSafeClose().SendCloseSignal(err)
 	}()
 	return &UdpServer{
 		args: args,
+		dh:   dh,
 		c:    c,
 	}, nil
 }
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "audit",
+		Short: "Tools for the audit log of the admin api.",
+	}
+	c.AddCommand(&cobra.Command{
+		Use:   "verify audit_log_file",
+		Args:  cobra.ExactArgs(1),
+		Short: "Verify the hash chain of an audit log.",
+		Long: `Verify the hash chain of an audit log and print the hash of its last record.
+
+Modified, inserted or removed records break the chain. Removed records at
+the end of the log can only be detected by comparing the last hash with
+a copy that was kept elsewhere.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runAuditVerify(args[0]); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	})
+	return c
+}
+
+func runAuditVerify(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, last, err := coremain.VerifyAuditLog(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d records verified, last hash %s\n", n, last)
+	return nil
+}
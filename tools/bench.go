@@ -0,0 +1,313 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
+	fastforward "github.com/IrineSistiana/mosdns/v5/plugin/executable/forward"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+type benchOpts struct {
+	config      string
+	dir         string
+	upstreams   []string
+	queries     []string
+	rounds      int
+	concurrency int
+	timeout     time.Duration
+}
+
+// defaultBenchQueries are used if no query is specified.
+var defaultBenchQueries = []string{
+	"example.com",
+	"example.com AAAA",
+	"google.com",
+	"cloudflare.com",
+	"github.com",
+	"wikipedia.org",
+}
+
+func newBenchCmd() *cobra.Command {
+	opts := new(benchOpts)
+	c := &cobra.Command{
+		Use:   "bench [-c config_file | -u upstream_addr...] [-q \"domain [type]\"]...",
+		Args:  cobra.NoArgs,
+		Short: "Benchmark upstreams.",
+		Long: `Send the test queries to each upstream and report latency percentiles, error
+rates and protocol fallbacks (e.g. truncated udp responses that were retried
+over tcp). Upstreams are sorted by error rate, then by median latency.
+
+By default, all upstreams of forward plugins in the config are benchmarked,
+one after another. Use -u to benchmark upstream addresses directly.
+Responses with SERVFAIL or REFUSED rcode are counted as errors.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runBench(opts, os.Stdout); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	fs := c.Flags()
+	fs.StringVarP(&opts.config, "config", "c", "", "config file")
+	fs.StringVarP(&opts.dir, "dir", "d", "", "working dir")
+	fs.StringArrayVarP(&opts.upstreams, "upstream", "u", nil, "upstream address, e.g. \"tls://1.1.1.1\"")
+	fs.StringArrayVarP(&opts.queries, "query", "q", nil, "test query, e.g. \"example.com AAAA\", type defaults to A")
+	fs.IntVarP(&opts.rounds, "rounds", "n", 10, "number of times that each query is sent to each upstream")
+	fs.IntVar(&opts.concurrency, "concurrency", 1, "number of concurrent queries")
+	fs.DurationVar(&opts.timeout, "timeout", time.Second*5, "timeout of each query")
+	c.MarkFlagFilename("config")
+	c.MarkFlagDirname("dir")
+	return c
+}
+
+// benchTarget is an upstream to be benchmarked.
+type benchTarget struct {
+	name string
+	cfg  fastforward.UpstreamConfig
+}
+
+type benchResult struct {
+	name      string
+	queries   int
+	errs      int
+	fallbacks int64
+	latencies []time.Duration // of successful queries, sorted.
+}
+
+func (r *benchResult) errRate() float64 {
+	if r.queries == 0 {
+		return 0
+	}
+	return float64(r.errs) / float64(r.queries)
+}
+
+// percentile returns the p-th (0 < p <= 100) percentile of latencies by
+// the nearest-rank method.
+func (r *benchResult) percentile(p int) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := (len(r.latencies)*p + 99) / 100
+	return r.latencies[max(i, 1)-1]
+}
+
+func runBench(opts *benchOpts, w io.Writer) error {
+	if opts.rounds <= 0 || opts.concurrency <= 0 {
+		return errors.New("rounds and concurrency must be positive")
+	}
+	queries := opts.queries
+	if len(queries) == 0 {
+		queries = defaultBenchQueries
+	}
+	qs, err := parseSampleQueries(queries)
+	if err != nil {
+		return err
+	}
+
+	var targets []benchTarget
+	if len(opts.upstreams) > 0 {
+		for _, addr := range opts.upstreams {
+			targets = append(targets, benchTarget{name: addr, cfg: fastforward.UpstreamConfig{Addr: addr}})
+		}
+	} else {
+		targets, err = benchTargetsFromConfig(opts)
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return errors.New("no upstream is configured in forward plugins")
+		}
+	}
+
+	var results []*benchResult
+	for _, t := range targets {
+		fmt.Fprintf(os.Stderr, "benchmarking %s\n", t.name)
+		u, ob, err := newBenchUpstream(t.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to init upstream %s, %w", t.name, err)
+		}
+		r := benchUpstream(u, qs, opts.rounds, opts.concurrency, opts.timeout)
+		_ = u.Close()
+		r.name = t.name
+		r.fallbacks = ob.fallbacks.Load()
+		results = append(results, r)
+	}
+
+	slices.SortStableFunc(results, func(a, b *benchResult) int {
+		if c := cmp.Compare(a.errRate(), b.errRate()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.percentile(50), b.percentile(50))
+	})
+	printBenchResults(w, results)
+	return nil
+}
+
+func benchTargetsFromConfig(opts *benchOpts) ([]benchTarget, error) {
+	if len(opts.dir) > 0 {
+		if err := os.Chdir(opts.dir); err != nil {
+			return nil, fmt.Errorf("failed to change the current working directory, %w", err)
+		}
+	}
+	m, err := coremain.NewDryRunMosdns(opts.config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config, %w", err)
+	}
+	defer func() {
+		m.CloseWithErr(nil)
+		_ = m.GetSafeClose().WaitClosed()
+	}()
+
+	var targets []benchTarget
+	for _, tag := range m.PluginTags() {
+		f, ok := m.GetPlugin(tag).(*fastforward.Forward)
+		if !ok {
+			continue
+		}
+		for _, c := range f.UpstreamConfigs() {
+			name := c.Tag
+			if len(name) == 0 {
+				name = c.Addr
+			}
+			targets = append(targets, benchTarget{name: tag + "/" + name, cfg: c})
+		}
+	}
+	return targets, nil
+}
+
+// benchObserver counts fallback events of an upstream.
+type benchObserver struct {
+	fallbacks atomic.Int64
+}
+
+func (o *benchObserver) OnEvent(typ upstream.Event) {
+	if typ == upstream.EventFallback {
+		o.fallbacks.Add(1)
+	}
+}
+
+func newBenchUpstream(c fastforward.UpstreamConfig) (upstream.Upstream, *benchObserver, error) {
+	ob := new(benchObserver)
+	u, err := fastforward.NewUpstream(c, mlog.Nop(), ob)
+	return u, ob, err
+}
+
+// benchUpstream sends each query in qs to u for rounds times, with at most
+// concurrency queries at the same time.
+func benchUpstream(u upstream.Upstream, qs []*dns.Msg, rounds, concurrency int, timeout time.Duration) *benchResult {
+	jobs := make(chan *dns.Msg)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < rounds; i++ {
+			for _, q := range qs {
+				jobs <- q
+			}
+		}
+	}()
+
+	r := new(benchResult)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range jobs {
+				d, err := benchExchange(u, q, timeout)
+				mu.Lock()
+				r.queries++
+				if err != nil {
+					r.errs++
+				} else {
+					r.latencies = append(r.latencies, d)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	slices.Sort(r.latencies)
+	return r
+}
+
+func benchExchange(u upstream.Upstream, q *dns.Msg, timeout time.Duration) (time.Duration, error) {
+	q = q.Copy()
+	q.Id = dns.Id()
+	b, err := q.Pack()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	rb, err := u.ExchangeContext(ctx, b)
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	defer pool.ReleaseBuf(rb)
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(*rb); err != nil {
+		return 0, err
+	}
+	if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+		return 0, fmt.Errorf("rcode %s", dns.RcodeToString[resp.Rcode])
+	}
+	return elapsed, nil
+}
+
+func printBenchResults(w io.Writer, results []*benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UPSTREAM\tQUERIES\tERRORS\tP50\tP90\tP99\tFALLBACKS")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d (%.1f%%)\t%s\t%s\t%s\t%d\n",
+			r.name, r.queries, r.errs, r.errRate()*100,
+			benchDuration(r.percentile(50)), benchDuration(r.percentile(90)), benchDuration(r.percentile(99)),
+			r.fallbacks,
+		)
+	}
+	_ = tw.Flush()
+}
+
+func benchDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Microsecond).String()
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	fastforward "github.com/IrineSistiana/mosdns/v5/plugin/executable/forward"
+	"github.com/miekg/dns"
+)
+
+func Test_benchUpstream(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(truncate bool) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, q *dns.Msg) {
+			r := new(dns.Msg)
+			r.SetReply(q)
+			switch q.Question[0].Name {
+			case "fail.example.":
+				r.Rcode = dns.RcodeServerFailure
+			case "big.example.":
+				r.Truncated = truncate
+			}
+			_ = w.WriteMsg(r)
+		}
+	}
+	us := &dns.Server{PacketConn: pc, Handler: handler(true)}
+	ts := &dns.Server{Listener: l, Handler: handler(false)}
+	go us.ActivateAndServe()
+	go ts.ActivateAndServe()
+	defer us.Shutdown()
+	defer ts.Shutdown()
+
+	qs, err := parseSampleQueries([]string{"ok.example", "big.example", "fail.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, ob, err := newBenchUpstream(fastforward.UpstreamConfig{Addr: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	r := benchUpstream(u, qs, 4, 2, time.Second)
+	if r.queries != 12 || r.errs != 4 || len(r.latencies) != 8 {
+		t.Fatalf("unexpected result, queries %d, errs %d, latencies %d", r.queries, r.errs, len(r.latencies))
+	}
+	if n := ob.fallbacks.Load(); n != 4 {
+		t.Fatalf("want 4 fallbacks, got %d", n)
+	}
+	if p50, p99 := r.percentile(50), r.percentile(99); p50 <= 0 || p99 < p50 || p99 != r.latencies[7] {
+		t.Fatalf("invalid percentiles p50 %s, p99 %s", p50, p99)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+type checkOpts struct {
+	config  string
+	dir     string
+	entry   string
+	queries []string
+	deps    bool
+	timeout time.Duration
+}
+
+func newCheckCmd() *cobra.Command {
+	opts := new(checkOpts)
+	c := &cobra.Command{
+		Use:   "check [-c config_file] [-d working_dir] [--entry tag -q \"domain [type]\"]...",
+		Args:  cobra.NoArgs,
+		Short: "Validate the config and optionally run sample queries through it.",
+		Long: `Validate the config by initializing all plugins from it, without starting servers.
+Plugins can only use the plugins that are defined before them, so a config
+that passed the check has no missing tag nor dependency cycle.
+
+Sample queries are executed by the entry plugin directly. Upstreams are
+still contacted if the entry forwards them.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCheck(opts); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	fs := c.Flags()
+	fs.StringVarP(&opts.config, "config", "c", "", "config file")
+	fs.StringVarP(&opts.dir, "dir", "d", "", "working dir")
+	fs.StringVar(&opts.entry, "entry", "", "entry plugin of sample queries")
+	fs.StringArrayVarP(&opts.queries, "query", "q", nil, "sample query, e.g. \"example.com AAAA\", type defaults to A")
+	fs.BoolVar(&opts.deps, "deps", false, "print plugin dependencies")
+	fs.DurationVar(&opts.timeout, "timeout", time.Second*5, "timeout of each sample query")
+	c.MarkFlagFilename("config")
+	c.MarkFlagDirname("dir")
+	return c
+}
+
+func runCheck(opts *checkOpts) error {
+	qs, err := parseSampleQueries(opts.queries)
+	if err != nil {
+		return err
+	}
+	if len(qs) > 0 && len(opts.entry) == 0 {
+		return errors.New("sample queries require an entry")
+	}
+
+	if len(opts.dir) > 0 {
+		if err := os.Chdir(opts.dir); err != nil {
+			return fmt.Errorf("failed to change the current working directory, %w", err)
+		}
+	}
+
+	m, err := coremain.NewDryRunMosdns(opts.config)
+	if err != nil {
+		return fmt.Errorf("invalid config, %w", err)
+	}
+	defer func() {
+		m.CloseWithErr(nil)
+		_ = m.GetSafeClose().WaitClosed()
+	}()
+	fmt.Println("config is valid")
+
+	if opts.deps {
+		printDeps(m.PluginDeps())
+	}
+
+	if len(qs) == 0 {
+		return nil
+	}
+	entry := sequence.ToExecutable(m.GetPlugin(opts.entry))
+	if entry == nil {
+		return fmt.Errorf("cannot find executable entry by tag %s", opts.entry)
+	}
+	failed := 0
+	for _, q := range qs {
+		if err := runSampleQuery(entry, q, opts.timeout); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sample queries failed", failed, len(qs))
+	}
+	return nil
+}
+
+// parseSampleQueries parses queries in "domain [type]" format.
+func parseSampleQueries(ss []string) ([]*dns.Msg, error) {
+	var qs []*dns.Msg
+	for _, s := range ss {
+		fs := strings.Fields(s)
+		if len(fs) == 0 || len(fs) > 2 {
+			return nil, fmt.Errorf("invalid sample query %q", s)
+		}
+		qt := dns.TypeA
+		if len(fs) == 2 {
+			t, ok := dns.StringToType[strings.ToUpper(fs[1])]
+			if !ok {
+				return nil, fmt.Errorf("invalid query type in %q", s)
+			}
+			qt = t
+		}
+		q := new(dns.Msg)
+		q.SetQuestion(dns.Fqdn(fs[0]), qt)
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+func runSampleQuery(entry sequence.Executable, q *dns.Msg, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	question := q.Question[0]
+	qCtx := query_context.NewContext(q)
+	start := time.Now()
+	err := entry.Exec(ctx, qCtx)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Printf("%s %s: error: %v (%s)\n", question.Name, dns.TypeToString[question.Qtype], err, elapsed)
+		return err
+	}
+
+	r := qCtx.R()
+	if r == nil {
+		fmt.Printf("%s %s: no response (%s)\n", question.Name, dns.TypeToString[question.Qtype], elapsed)
+		return nil
+	}
+	fmt.Printf("%s %s: %s, %d answer(s) (%s)\n", question.Name, dns.TypeToString[question.Qtype], dns.RcodeToString[r.Rcode], len(r.Answer), elapsed)
+	for _, rr := range r.Answer {
+		fmt.Printf("\t%s\n", rr)
+	}
+	return nil
+}
+
+func printDeps(deps map[string][]string) {
+	tags := make([]string, 0, len(deps))
+	for tag := range deps {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		fmt.Printf("%s -> %s\n", tag, strings.Join(deps[tag], ", "))
+	}
+}
@@ -0,0 +1,367 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newConvertCmd() *cobra.Command {
+	var (
+		from string
+		out  string
+	)
+	c := &cobra.Command{
+		Use:   "convert [--from dnsmasq|smartdns|adguard] [-o output_cfg] input_cfg",
+		Args:  cobra.ExactArgs(1),
+		Short: "Convert a dnsmasq, SmartDNS or AdGuard Home config to a mosdns config.",
+		Long: `Convert a dnsmasq, SmartDNS or AdGuard Home config to a mosdns config.
+
+Listen addresses, upstreams, per domain upstreams, static addresses, blocked
+domains, ipset/nftset rules and the cache are converted. Blocked domains are
+answered with NXDOMAIN. Per domain rules are checked in the order that they
+appear in the input, not by the longest match.
+Options that cannot be converted are reported as warnings.
+
+If --from is omitted, it is guessed from the input file name. The output
+format is selected by the extension of the output file. Default is printing
+yaml to stdout.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := convert(args[0], from, out); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	c.Flags().StringVar(&from, "from", "", "input config type, dnsmasq, smartdns or adguard")
+	c.Flags().StringVarP(&out, "out", "o", "", "output config")
+	c.MarkFlagFilename("out")
+	return c
+}
+
+func convert(in, from, out string) error {
+	if len(from) == 0 {
+		from = guessConfigType(in)
+	}
+
+	var (
+		m   *migration
+		err error
+	)
+	switch from {
+	case "dnsmasq":
+		m, err = parseDnsmasq(in)
+	case "smartdns":
+		m, err = parseSmartDNS(in)
+	case "adguard":
+		m, err = parseAdGuard(in)
+	default:
+		return fmt.Errorf("unknown input config type %s", from)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s config, %w", from, err)
+	}
+	for _, w := range m.warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+
+	v := viper.New()
+	for k, e := range m.config() {
+		v.Set(k, e)
+	}
+	if len(out) == 0 {
+		v.SetConfigType("yaml")
+		return v.WriteConfigTo(os.Stdout)
+	}
+	return v.SafeWriteConfigAs(out)
+}
+
+func guessConfigType(in string) string {
+	name := strings.ToLower(filepath.Base(in))
+	switch {
+	case strings.Contains(name, "smartdns"):
+		return "smartdns"
+	case strings.Contains(name, "adguard"), strings.HasSuffix(name, ".yaml"), strings.HasSuffix(name, ".yml"):
+		return "adguard"
+	default:
+		return "dnsmasq"
+	}
+}
+
+// migration is the part of a dns forwarder config that can be converted.
+type migration struct {
+	listenUDP []string
+	listenTCP []string
+	upstreams []string // default upstreams.
+
+	// domains and their upstreams in order. Empty upstreams means the
+	// default upstreams.
+	domains         []string
+	domainUpstreams map[string][]string
+
+	hostDomains []string
+	hosts       map[string][]string // domain -> ips
+
+	blocked   []string // domains answered with NXDOMAIN.
+	sets      []*setRule
+	cache     bool
+	cacheSize int // 0 means the default size.
+
+	warnings []string
+}
+
+// setRule adds the addresses of domains into a set by an ipset or nftset
+// plugin.
+type setRule struct {
+	typ     string
+	args    map[string]any
+	domains []string
+}
+
+func newMigration() *migration {
+	return &migration{
+		domainUpstreams: make(map[string][]string),
+		hosts:           make(map[string][]string),
+	}
+}
+
+func (m *migration) warnf(format string, a ...any) {
+	w := fmt.Sprintf(format, a...)
+	if !slices.Contains(m.warnings, w) {
+		m.warnings = append(m.warnings, w)
+	}
+}
+
+func (m *migration) addUpstream(addr string) {
+	if !slices.Contains(m.upstreams, addr) {
+		m.upstreams = append(m.upstreams, addr)
+	}
+}
+
+// addDomainUpstream routes domain to upstream addr. Empty addr means the
+// default upstreams.
+func (m *migration) addDomainUpstream(domain, addr string) {
+	ups, ok := m.domainUpstreams[domain]
+	if !ok {
+		m.domains = append(m.domains, domain)
+	}
+	if len(addr) > 0 && !slices.Contains(ups, addr) {
+		ups = append(ups, addr)
+	}
+	m.domainUpstreams[domain] = ups
+}
+
+func (m *migration) addHost(domain, ip string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("invalid address %s of %s", ip, domain)
+	}
+	ips, ok := m.hosts[domain]
+	if !ok {
+		m.hostDomains = append(m.hostDomains, domain)
+	}
+	if !slices.Contains(ips, addr.String()) {
+		m.hosts[domain] = append(ips, addr.String())
+	}
+	return nil
+}
+
+func (m *migration) block(domain string) {
+	if !slices.Contains(m.blocked, domain) {
+		m.blocked = append(m.blocked, domain)
+	}
+}
+
+func (m *migration) addSet(typ string, args map[string]any, domain string) {
+	for _, r := range m.sets {
+		if r.typ == typ && fmt.Sprint(r.args) == fmt.Sprint(args) {
+			if !slices.Contains(r.domains, domain) {
+				r.domains = append(r.domains, domain)
+			}
+			return
+		}
+	}
+	m.sets = append(m.sets, &setRule{typ: typ, args: args, domains: []string{domain}})
+}
+
+// splitDomains splits dnsmasq style domains "/a.com/b.com/value" into
+// domains and value. "#" matches all domains, it is returned as "".
+func splitDomains(s string) ([]string, string, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, "", fmt.Errorf("invalid domain rule %s", s)
+	}
+	i := strings.LastIndexByte(s, '/')
+	if i == 0 {
+		return nil, "", fmt.Errorf("invalid domain rule %s", s)
+	}
+	var domains []string
+	for _, d := range strings.Split(s[1:i], "/") {
+		d = strings.TrimPrefix(strings.TrimSuffix(d, "."), ".")
+		if d == "#" {
+			d = ""
+		}
+		domains = append(domains, d)
+	}
+	return domains, s[i+1:], nil
+}
+
+// upstreamAddr converts "ip#port" and "ip:port" to a mosdns upstream addr.
+// Addresses that have a scheme are returned as is.
+func upstreamAddr(s string) (string, error) {
+	if strings.Contains(s, "://") {
+		return s, nil
+	}
+	host, port, ok := strings.Cut(s, "#")
+	if !ok {
+		if h, p, err := net.SplitHostPort(s); err == nil {
+			host, port = h, p
+		} else {
+			host = strings.Trim(s, "[]")
+		}
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid upstream %s", s)
+	}
+	if len(port) == 0 {
+		return addr.String(), nil
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid upstream port %s", s)
+	}
+	return net.JoinHostPort(addr.String(), port), nil
+}
+
+// config returns the mosdns config.
+func (m *migration) config() map[string]any {
+	var (
+		plugins []any
+		rules   []any
+	)
+	addPlugin := func(tag, typ string, args any) {
+		plugins = append(plugins, map[string]any{"tag": tag, "type": typ, "args": args})
+	}
+	addRule := func(matches, exec string) {
+		r := map[string]any{"exec": exec}
+		if len(matches) > 0 {
+			r["matches"] = matches
+		}
+		rules = append(rules, r)
+	}
+	forwardArgs := func(ups []string) map[string]any {
+		var us []any
+		for _, u := range ups {
+			us = append(us, map[string]any{"addr": u})
+		}
+		return map[string]any{"upstreams": us}
+	}
+
+	if m.cache {
+		args := map[string]any{}
+		if m.cacheSize > 0 {
+			args["size"] = m.cacheSize
+		}
+		addPlugin("cache", "cache", args)
+		addRule("", "$cache")
+		addRule("has_resp", "accept")
+	}
+	if len(m.hostDomains) > 0 {
+		var entries []string
+		for _, d := range m.hostDomains {
+			entries = append(entries, d+" "+strings.Join(m.hosts[d], " "))
+		}
+		addPlugin("hosts", "hosts", map[string]any{"entries": entries})
+		addRule("", "$hosts")
+		addRule("has_resp", "accept")
+	}
+	if len(m.blocked) > 0 {
+		addPlugin("blocked", "domain_set", map[string]any{"exps": m.blocked})
+		addRule("qname $blocked", "reject 3")
+	}
+
+	if len(m.upstreams) > 0 {
+		addPlugin("forward_default", "forward", forwardArgs(m.upstreams))
+	}
+	// Group domains that have the same upstreams.
+	var groups [][]string
+	groupIdx := make(map[string]int)
+	for _, d := range m.domains {
+		ups := m.domainUpstreams[d]
+		key := strings.Join(ups, " ")
+		i, ok := groupIdx[key]
+		if !ok {
+			i = len(groups)
+			groupIdx[key] = i
+			groups = append(groups, []string{})
+		}
+		groups[i] = append(groups[i], d)
+	}
+	for i, domains := range groups {
+		ups := m.domainUpstreams[domains[0]]
+		setTag := fmt.Sprintf("domains_%d", i+1)
+		addPlugin(setTag, "domain_set", map[string]any{"exps": domains})
+		fwdTag := "forward_default"
+		if len(ups) > 0 {
+			fwdTag = fmt.Sprintf("forward_%d", i+1)
+			addPlugin(fwdTag, "forward", forwardArgs(ups))
+		} else if len(m.upstreams) == 0 {
+			m.warnf("domains %s use default upstreams, but there is no default upstream", strings.Join(domains, ", "))
+			continue
+		}
+		addRule("qname $"+setTag, "$"+fwdTag)
+	}
+	if len(m.upstreams) > 0 {
+		addRule("!has_resp", "$forward_default")
+	} else {
+		m.warnf("no default upstream is configured")
+	}
+
+	for i, r := range m.sets {
+		setTag := fmt.Sprintf("%s_domains_%d", r.typ, i+1)
+		tag := fmt.Sprintf("%s_%d", r.typ, i+1)
+		addPlugin(setTag, "domain_set", map[string]any{"exps": r.domains})
+		addPlugin(tag, r.typ, r.args)
+		addRule("qname $"+setTag, "$"+tag)
+	}
+
+	addPlugin("main", "sequence", rules)
+
+	for i, l := range m.listenUDP {
+		addPlugin(fmt.Sprintf("udp_server_%d", i+1), "udp_server", map[string]any{"entry": "main", "listen": l})
+	}
+	for i, l := range m.listenTCP {
+		addPlugin(fmt.Sprintf("tcp_server_%d", i+1), "tcp_server", map[string]any{"entry": "main", "listen": l})
+	}
+	return map[string]any{
+		"log":     map[string]any{"level": "info"},
+		"plugins": plugins,
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// adGuardConfig is the part of AdGuardHome.yaml that can be converted.
+type adGuardConfig struct {
+	DNS struct {
+		BindHosts    []string         `yaml:"bind_hosts"`
+		Port         int              `yaml:"port"`
+		UpstreamDNS  []string         `yaml:"upstream_dns"`
+		CacheEnabled *bool            `yaml:"cache_enabled"`
+		Rewrites     []adGuardRewrite `yaml:"rewrites"` // Before v0.107.
+	} `yaml:"dns"`
+	Filtering struct {
+		Rewrites []adGuardRewrite `yaml:"rewrites"`
+	} `yaml:"filtering"`
+	Filters   []any    `yaml:"filters"`
+	UserRules []string `yaml:"user_rules"`
+}
+
+type adGuardRewrite struct {
+	Domain string `yaml:"domain"`
+	Answer string `yaml:"answer"`
+}
+
+// parseAdGuard parses an AdGuardHome.yaml.
+func parseAdGuard(file string) (*migration, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	cfg := new(adGuardConfig)
+	if err := v.Unmarshal(cfg, func(c *mapstructure.DecoderConfig) { c.TagName = "yaml" }); err != nil {
+		return nil, err
+	}
+
+	m := newMigration()
+	port := cfg.DNS.Port
+	if port == 0 {
+		port = 53
+	}
+	hosts := cfg.DNS.BindHosts
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	for _, h := range hosts {
+		if h == "0.0.0.0" || h == "::" {
+			h = ""
+		}
+		l := net.JoinHostPort(h, strconv.Itoa(port))
+		m.listenUDP = append(m.listenUDP, l)
+		m.listenTCP = append(m.listenTCP, l)
+	}
+
+	for _, s := range cfg.DNS.UpstreamDNS {
+		if err := adGuardUpstream(m, s); err != nil {
+			return nil, err
+		}
+	}
+	m.cache = cfg.DNS.CacheEnabled == nil || *cfg.DNS.CacheEnabled
+
+	for _, r := range slices.Concat(cfg.DNS.Rewrites, cfg.Filtering.Rewrites) {
+		domain := strings.TrimPrefix(r.Domain, "*.")
+		if err := m.addHost(domain, r.Answer); err != nil {
+			m.warnf("rewrite %s -> %s is not converted", r.Domain, r.Answer)
+		}
+	}
+
+	for _, rule := range cfg.UserRules {
+		adGuardRule(m, rule)
+	}
+	if n := len(cfg.Filters); n > 0 {
+		m.warnf("%d filter lists are not converted, download them and load them by a domain_set", n)
+	}
+	return m, nil
+}
+
+// adGuardUpstream parses an upstream_dns entry "[/a.com/b.com/]u1 u2".
+func adGuardUpstream(m *migration, s string) error {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || strings.HasPrefix(s, "#") {
+		return nil
+	}
+	var domains []string
+	if strings.HasPrefix(s, "[/") {
+		i := strings.Index(s, "/]")
+		if i < 0 {
+			return fmt.Errorf("invalid upstream %s", s)
+		}
+		ds, _, err := splitDomains(s[1 : i+1])
+		if err != nil {
+			return err
+		}
+		domains, s = ds, s[i+2:]
+	}
+
+	for _, u := range strings.Fields(s) {
+		if u == "#" {
+			// Default upstreams.
+			for _, d := range domains {
+				m.addDomainUpstream(d, "")
+			}
+			continue
+		}
+		if strings.HasPrefix(u, "sdns://") {
+			m.warnf("dns stamp upstream %s is not converted", u)
+			continue
+		}
+		addr, err := upstreamAddr(u)
+		if err != nil {
+			return err
+		}
+		if len(domains) == 0 {
+			m.addUpstream(addr)
+			continue
+		}
+		for _, d := range domains {
+			m.addDomainUpstream(d, addr)
+		}
+	}
+	return nil
+}
+
+// adGuardRule converts a user rule. Only "||domain^" blocking rules and
+// hosts style rules are supported.
+func adGuardRule(m *migration, rule string) {
+	rule = strings.TrimSpace(rule)
+	if len(rule) == 0 || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "#") {
+		return
+	}
+	if strings.HasPrefix(rule, "||") && strings.HasSuffix(rule, "^") {
+		d := strings.TrimSuffix(strings.TrimPrefix(rule, "||"), "^")
+		if !strings.ContainsAny(d, "*/|$") {
+			m.block(d)
+			return
+		}
+	}
+	if fs := strings.Fields(rule); len(fs) >= 2 {
+		if _, err := netip.ParseAddr(fs[0]); err == nil {
+			for _, d := range fs[1:] {
+				if fs[0] == "0.0.0.0" || fs[0] == "::" {
+					m.block(d)
+				} else if err := m.addHost(d, fs[0]); err != nil {
+					m.warnf("user rule %s is not converted", rule)
+				}
+			}
+			return
+		}
+	}
+	m.warnf("user rule %s is not converted", rule)
+}
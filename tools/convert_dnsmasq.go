@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseDnsmasq parses a dnsmasq.conf.
+func parseDnsmasq(file string) (*migration, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := newMigration()
+	m.cache = true // dnsmasq caches by default.
+	var (
+		port       = "53"
+		listenAddr []string
+	)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		key, value, _ := strings.Cut(s, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "server", "local":
+			err = dnsmasqServer(m, key, value)
+		case "address":
+			err = dnsmasqAddress(m, value)
+		case "ipset":
+			err = dnsmasqIpset(m, value)
+		case "nftset":
+			err = dnsmasqNftset(m, value)
+		case "port":
+			port = value
+		case "listen-address":
+			listenAddr = append(listenAddr, strings.Split(value, ",")...)
+		case "cache-size":
+			var n int
+			n, err = strconv.Atoi(value)
+			m.cache, m.cacheSize = n > 0, n
+		case "no-resolv", "no-hosts", "no-poll", "domain-needed", "bogus-priv", "strict-order":
+		case "conf-file", "conf-dir", "addn-hosts", "servers-file", "resolv-file":
+			m.warnf("%s is not converted, convert the files it refers to separately", key)
+		default:
+			m.warnf("option %s is ignored", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if port != "0" {
+		if len(listenAddr) == 0 {
+			listenAddr = []string{""}
+		}
+		for _, a := range listenAddr {
+			l := net.JoinHostPort(strings.TrimSpace(a), port)
+			m.listenUDP = append(m.listenUDP, l)
+			m.listenTCP = append(m.listenTCP, l)
+		}
+	}
+	return m, nil
+}
+
+// dnsmasqServer parses "server=[/domains/][ip[#port][@source]]" and
+// "local=/domains/".
+func dnsmasqServer(m *migration, key, v string) error {
+	var (
+		domains []string
+		target  = v
+	)
+	if strings.HasPrefix(v, "/") {
+		var err error
+		domains, target, err = splitDomains(v)
+		if err != nil {
+			return err
+		}
+	}
+	if key == "local" || (len(domains) > 0 && len(target) == 0) {
+		for _, d := range domains {
+			if len(d) == 0 {
+				m.warnf("local=/#/ is not converted")
+				continue
+			}
+			m.block(d)
+		}
+		return nil
+	}
+
+	addr := ""
+	if target != "#" {
+		if src, _, ok := strings.Cut(target, "@"); ok {
+			m.warnf("source address or interface of server %s is ignored", target)
+			target = src
+		}
+		var err error
+		addr, err = upstreamAddr(target)
+		if err != nil {
+			return err
+		}
+	}
+	if len(domains) == 0 {
+		if len(addr) > 0 {
+			m.addUpstream(addr)
+		}
+		return nil
+	}
+	for _, d := range domains {
+		if len(d) == 0 { // "/#/"
+			if len(addr) > 0 {
+				m.addUpstream(addr)
+			}
+			continue
+		}
+		m.addDomainUpstream(d, addr)
+	}
+	return nil
+}
+
+// dnsmasqAddress parses "address=/domains/[ip]".
+func dnsmasqAddress(m *migration, v string) error {
+	domains, ip, err := splitDomains(v)
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		switch {
+		case len(d) == 0:
+			m.warnf("address=/#/ is not converted")
+		case len(ip) == 0 || ip == "#":
+			m.block(d)
+		default:
+			if err := m.addHost(d, ip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dnsmasqIpset parses "ipset=/domains/set[,set]".
+func dnsmasqIpset(m *migration, v string) error {
+	domains, sets, err := splitDomains(v)
+	if err != nil {
+		return err
+	}
+	for _, set := range strings.Split(sets, ",") {
+		args := map[string]any{"set_name4": set, "mask4": 32, "set_name6": set, "mask6": 128}
+		for _, d := range domains {
+			if len(d) == 0 {
+				m.warnf("ipset=/#/ is not converted")
+				continue
+			}
+			m.addSet("ipset", args, d)
+		}
+	}
+	return nil
+}
+
+// dnsmasqNftset parses "nftset=/domains/[(4|6)#]family#table#set[,...]".
+func dnsmasqNftset(m *migration, v string) error {
+	domains, sets, err := splitDomains(v)
+	if err != nil {
+		return err
+	}
+	args := make(map[string]any)
+	for _, set := range strings.Split(sets, ",") {
+		fs := strings.Split(set, "#")
+		ver := ""
+		if len(fs) == 4 {
+			ver, fs = fs[0], fs[1:]
+		}
+		if len(fs) != 3 {
+			return fmt.Errorf("invalid nftset %s", set)
+		}
+		setArgs := map[string]any{"table_family": fs[0], "table_name": fs[1], "set_name": fs[2]}
+		switch ver {
+		case "4":
+			setArgs["mask"] = 32
+			args["ipv4"] = setArgs
+		case "6":
+			setArgs["mask"] = 128
+			args["ipv6"] = setArgs
+		case "":
+			m.warnf("nftset %s without 4# or 6# prefix is converted to an ipv4 set", set)
+			setArgs["mask"] = 32
+			args["ipv4"] = setArgs
+		default:
+			return fmt.Errorf("invalid nftset %s", set)
+		}
+	}
+	for _, d := range domains {
+		if len(d) == 0 {
+			m.warnf("nftset=/#/ is not converted")
+			continue
+		}
+		m.addSet("nftset", args, d)
+	}
+	return nil
+}
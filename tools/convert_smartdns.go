@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseSmartDNS parses a smartdns.conf.
+func parseSmartDNS(file string) (*migration, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := newMigration()
+	m.cache = true                      // smartdns caches by default.
+	groups := make(map[string][]string) // group -> upstreams
+	var nameservers [][2]string         // domain, group
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		fs := strings.Fields(s)
+		key, args := fs[0], fs[1:]
+		if len(args) == 0 {
+			m.warnf("option %s is ignored", key)
+			continue
+		}
+
+		var err error
+		switch key {
+		case "bind", "bind-tcp":
+			l := smartDNSListen(m, args[0])
+			if key == "bind" {
+				m.listenUDP = append(m.listenUDP, l)
+			} else {
+				m.listenTCP = append(m.listenTCP, l)
+			}
+		case "bind-tls", "bind-https":
+			m.warnf("%s is not converted, add a tcp_server or http_server with a certificate", key)
+		case "server", "server-tcp", "server-tls", "server-https", "server-quic":
+			err = smartDNSServer(m, groups, key, args)
+		case "nameserver":
+			var domains []string
+			var group string
+			domains, group, err = splitDomains(args[0])
+			for _, d := range domains {
+				nameservers = append(nameservers, [2]string{d, group})
+			}
+		case "address":
+			err = smartDNSAddress(m, args[0])
+		case "ipset", "nftset":
+			err = smartDNSSet(m, key, args[0])
+		case "cache-size":
+			var n int
+			n, err = strconv.Atoi(args[0])
+			m.cache, m.cacheSize = n != 0, max(n, 0)
+		case "conf-file", "domain-set", "domain-rules":
+			m.warnf("%s is not converted", key)
+		default:
+			m.warnf("option %s is ignored", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ns := range nameservers {
+		domain, group := ns[0], ns[1]
+		if group == "-" || group == "default" {
+			group = ""
+		}
+		ups, ok := groups[group]
+		if len(group) > 0 && !ok {
+			return nil, fmt.Errorf("nameserver /%s/ uses undefined group %s", domain, group)
+		}
+		if len(domain) == 0 {
+			for _, u := range ups {
+				m.addUpstream(u)
+			}
+			continue
+		}
+		if len(group) == 0 {
+			m.addDomainUpstream(domain, "")
+			continue
+		}
+		for _, u := range ups {
+			m.addDomainUpstream(domain, u)
+		}
+	}
+	return m, nil
+}
+
+// smartDNSListen converts "[ip]:port[@device]".
+func smartDNSListen(m *migration, s string) string {
+	if l, dev, ok := strings.Cut(s, "@"); ok {
+		m.warnf("bind device %s is ignored", dev)
+		s = l
+	}
+	if strings.HasPrefix(s, "[::]") {
+		return strings.TrimPrefix(s, "[::]")
+	}
+	return s
+}
+
+func smartDNSServer(m *migration, groups map[string][]string, key string, args []string) error {
+	addr := args[0]
+	if !strings.Contains(addr, "://") {
+		scheme := map[string]string{
+			"server-tcp":   "tcp://",
+			"server-tls":   "tls://",
+			"server-https": "https://",
+			"server-quic":  "quic://",
+		}[key]
+		if len(scheme) == 0 {
+			a, err := upstreamAddr(addr)
+			if err != nil {
+				return err
+			}
+			addr = a
+		} else {
+			addr = scheme + addr
+		}
+	}
+
+	var inGroups []string
+	excludeDefault := false
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-group", "-g":
+			if i+1 < len(args) {
+				i++
+				inGroups = append(inGroups, args[i])
+			}
+		case "-exclude-default-group", "-e":
+			excludeDefault = true
+		}
+	}
+	for _, g := range inGroups {
+		groups[g] = append(groups[g], addr)
+	}
+	if !excludeDefault {
+		m.addUpstream(addr)
+	}
+	return nil
+}
+
+// smartDNSAddress parses "address /domain/[ip|#|#4|#6|-]".
+func smartDNSAddress(m *migration, s string) error {
+	domains, target, err := splitDomains(s)
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		switch {
+		case len(d) == 0:
+			m.warnf("address /#/ is not converted")
+		case target == "#":
+			m.block(d)
+		case target == "-":
+		case strings.HasPrefix(target, "#"), strings.HasPrefix(target, "-"):
+			m.warnf("address /%s/%s is not converted", d, target)
+		default:
+			for _, ip := range strings.Split(target, ",") {
+				if err := m.addHost(d, ip); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// smartDNSSet parses "ipset /domain/[#4:]set[,#6:set]" and
+// "nftset /domain/#4:family#table#set[,#6:family#table#set]".
+func smartDNSSet(m *migration, key, s string) error {
+	domains, sets, err := splitDomains(s)
+	if err != nil {
+		return err
+	}
+	if sets == "-" {
+		return nil
+	}
+	args := make(map[string]any)
+	for _, set := range strings.Split(sets, ",") {
+		ver := ""
+		if strings.HasPrefix(set, "#4:") || strings.HasPrefix(set, "#6:") {
+			ver, set = set[1:2], set[3:]
+		}
+		if key == "ipset" {
+			if ver != "6" {
+				args["set_name4"], args["mask4"] = set, 32
+			}
+			if ver != "4" {
+				args["set_name6"], args["mask6"] = set, 128
+			}
+			continue
+		}
+
+		fs := strings.Split(set, "#")
+		if len(fs) != 3 || len(ver) == 0 {
+			return fmt.Errorf("invalid nftset %s", set)
+		}
+		setArgs := map[string]any{"table_family": fs[0], "table_name": fs[1], "set_name": fs[2]}
+		if ver == "4" {
+			setArgs["mask"] = 32
+			args["ipv4"] = setArgs
+		} else {
+			setArgs["mask"] = 128
+			args["ipv6"] = setArgs
+		}
+	}
+	for _, d := range domains {
+		if len(d) == 0 {
+			m.warnf("%s /#/ is not converted", key)
+			continue
+		}
+		m.addSet(key, args, d)
+	}
+	return nil
+}
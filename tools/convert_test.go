@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTemp(t *testing.T, name, s string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func Test_parseDnsmasq(t *testing.T) {
+	p := writeTemp(t, "dnsmasq.conf", `
+port=5353
+server=8.8.8.8
+server=/corp.example/10.0.0.1#5353
+server=/lan/#
+address=/ads.example/
+address=/router.lan/192.168.1.1
+nftset=/gfw.example/4#inet#fw#s4,6#inet#fw#s6
+`)
+	m, err := parseDnsmasq(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{":5353"}; !reflect.DeepEqual(m.listenUDP, want) {
+		t.Fatalf("listen: want %v, got %v", want, m.listenUDP)
+	}
+	if want := []string{"8.8.8.8"}; !reflect.DeepEqual(m.upstreams, want) {
+		t.Fatalf("upstreams: want %v, got %v", want, m.upstreams)
+	}
+	if want := []string{"10.0.0.1:5353"}; !reflect.DeepEqual(m.domainUpstreams["corp.example"], want) {
+		t.Fatalf("corp.example: want %v, got %v", want, m.domainUpstreams["corp.example"])
+	}
+	if ups, ok := m.domainUpstreams["lan"]; !ok || len(ups) != 0 {
+		t.Fatalf("lan should use default upstreams, got %v", ups)
+	}
+	if want := []string{"ads.example"}; !reflect.DeepEqual(m.blocked, want) {
+		t.Fatalf("blocked: want %v, got %v", want, m.blocked)
+	}
+	if want := []string{"192.168.1.1"}; !reflect.DeepEqual(m.hosts["router.lan"], want) {
+		t.Fatalf("hosts: want %v, got %v", want, m.hosts["router.lan"])
+	}
+	if len(m.sets) != 1 || m.sets[0].args["ipv4"] == nil || m.sets[0].args["ipv6"] == nil {
+		t.Fatalf("nftset should be converted to one plugin with both families, got %v", m.sets)
+	}
+}
+
+func Test_parseSmartDNS(t *testing.T) {
+	p := writeTemp(t, "smartdns.conf", `
+bind [::]:5354
+server 223.5.5.5 -group cn -exclude-default-group
+server-tls 8.8.8.8
+nameserver /baidu.com/cn
+address /ads.example/#
+`)
+	m, err := parseSmartDNS(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tls://8.8.8.8"}; !reflect.DeepEqual(m.upstreams, want) {
+		t.Fatalf("upstreams: want %v, got %v", want, m.upstreams)
+	}
+	if want := []string{"223.5.5.5"}; !reflect.DeepEqual(m.domainUpstreams["baidu.com"], want) {
+		t.Fatalf("baidu.com: want %v, got %v", want, m.domainUpstreams["baidu.com"])
+	}
+	if want := []string{"ads.example"}; !reflect.DeepEqual(m.blocked, want) {
+		t.Fatalf("blocked: want %v, got %v", want, m.blocked)
+	}
+}
+
+func Test_parseAdGuard(t *testing.T) {
+	p := writeTemp(t, "AdGuardHome.yaml", `
+dns:
+  bind_hosts: [127.0.0.1]
+  port: 5355
+  upstream_dns:
+    - 8.8.8.8
+    - "[/corp.example/]10.0.0.1"
+  cache_enabled: false
+filtering:
+  rewrites:
+    - domain: nas.lan
+      answer: 192.168.1.3
+user_rules:
+  - "||ads.example^"
+  - "@@||ok.example^"
+`)
+	m, err := parseAdGuard(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.1:5355"}; !reflect.DeepEqual(m.listenTCP, want) {
+		t.Fatalf("listen: want %v, got %v", want, m.listenTCP)
+	}
+	if m.cache {
+		t.Fatal("cache should be disabled")
+	}
+	if want := []string{"10.0.0.1"}; !reflect.DeepEqual(m.domainUpstreams["corp.example"], want) {
+		t.Fatalf("corp.example: want %v, got %v", want, m.domainUpstreams["corp.example"])
+	}
+	if want := []string{"192.168.1.3"}; !reflect.DeepEqual(m.hosts["nas.lan"], want) {
+		t.Fatalf("hosts: want %v, got %v", want, m.hosts["nas.lan"])
+	}
+	if want := []string{"ads.example"}; !reflect.DeepEqual(m.blocked, want) {
+		t.Fatalf("blocked: want %v, got %v", want, m.blocked)
+	}
+	if len(m.warnings) != 1 {
+		t.Fatalf("want 1 warning, got %v", m.warnings)
+	}
+}
@@ -0,0 +1,444 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/spf13/cobra"
+)
+
+type graphOpts struct {
+	config string
+	dir    string
+	format string
+}
+
+func newGraphCmd() *cobra.Command {
+	opts := new(graphOpts)
+	c := &cobra.Command{
+		Use:   "graph [-c config_file] [-d working_dir] [--format dot|mermaid]",
+		Args:  cobra.NoArgs,
+		Short: "Print the plugin graph of the config.",
+		Long: `Print the plugin graph of the config as a Graphviz DOT or Mermaid flowchart.
+
+Each sequence is drawn as a cluster of its rules. Rules link to the plugins
+they execute and match, and to the sequences they jump or go to. Other
+plugins link to the plugins they use. Forward plugins list their upstreams.
+
+Rules that can never be reached, e.g. rules after an unconditional accept,
+and sequences that are not used by any plugin are drawn in red and reported
+to stderr.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runGraph(opts, os.Stdout); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	fs := c.Flags()
+	fs.StringVarP(&opts.config, "config", "c", "", "config file")
+	fs.StringVarP(&opts.dir, "dir", "d", "", "working dir")
+	fs.StringVar(&opts.format, "format", "dot", "output format, dot or mermaid")
+	c.MarkFlagFilename("config")
+	c.MarkFlagDirname("dir")
+	return c
+}
+
+func runGraph(opts *graphOpts, w io.Writer) error {
+	var write func(g *pluginGraph, w io.Writer) error
+	switch opts.format {
+	case "dot":
+		write = (*pluginGraph).writeDot
+	case "mermaid":
+		write = (*pluginGraph).writeMermaid
+	default:
+		return fmt.Errorf("invalid format %s", opts.format)
+	}
+
+	if len(opts.dir) > 0 {
+		if err := os.Chdir(opts.dir); err != nil {
+			return fmt.Errorf("failed to change the current working directory, %w", err)
+		}
+	}
+	m, err := coremain.NewDryRunMosdns(opts.config)
+	if err != nil {
+		return fmt.Errorf("invalid config, %w", err)
+	}
+	defer func() {
+		m.CloseWithErr(nil)
+		_ = m.GetSafeClose().WaitClosed()
+	}()
+
+	g := buildGraph(m)
+	for _, s := range g.warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", s)
+	}
+	return write(g, w)
+}
+
+type pluginGraph struct {
+	nodes    []*graphNode
+	keys     map[string]*graphNode
+	edges    []graphEdge
+	warnings []string
+}
+
+type nodeKind int
+
+const (
+	nodePlugin nodeKind = iota
+	nodeSequence
+	nodeRule
+)
+
+type graphNode struct {
+	id          string
+	label       string
+	kind        nodeKind
+	seq         string // tag of the sequence that a rule belongs to.
+	unreachable bool
+}
+
+type graphEdge struct {
+	from, to *graphNode
+	label    string
+	dashed   bool
+}
+
+// upstreamLister is implemented by plugins that have upstreams, e.g.
+// forward.
+type upstreamLister interface {
+	Upstreams() []string
+}
+
+func buildGraph(m *coremain.Mosdns) *pluginGraph {
+	g := &pluginGraph{keys: make(map[string]*graphNode)}
+	deps := m.PluginDeps()
+	used := make(map[string]bool)
+	for _, uses := range deps {
+		for _, tag := range uses {
+			used[tag] = true
+		}
+	}
+
+	// Nodes of all plugins first, so edges can refer to any of them.
+	// Preset plugins are only drawn if they are used.
+	tags := m.PluginTags()
+	for _, tag := range tags {
+		typ := m.PluginType(tag)
+		if len(typ) == 0 && !used[tag] {
+			continue
+		}
+		switch p := m.GetPlugin(tag).(type) {
+		case *sequence.Sequence:
+			n := g.addNode(tag, tag, nodeSequence)
+			if !used[tag] {
+				n.unreachable = true
+				g.warnf("sequence %s is not used by any plugin", tag)
+			}
+		case upstreamLister:
+			g.addNode(tag, pluginLabel(tag, typ)+"\n"+strings.Join(p.Upstreams(), "\n"), nodePlugin)
+		default:
+			g.addNode(tag, pluginLabel(tag, typ), nodePlugin)
+		}
+	}
+
+	for _, tag := range tags {
+		n := g.keys[tag]
+		if n == nil {
+			continue
+		}
+		if s, ok := m.GetPlugin(tag).(*sequence.Sequence); ok {
+			g.addSequence(tag, s.Rules(), deps[tag])
+			continue
+		}
+		for _, dep := range deps[tag] {
+			g.addEdge(n, g.keys[dep], "", false)
+		}
+	}
+	return g
+}
+
+func pluginLabel(tag, typ string) string {
+	if len(typ) == 0 {
+		return tag
+	}
+	return tag + "\n(" + typ + ")"
+}
+
+func (g *pluginGraph) warnf(format string, a ...any) {
+	g.warnings = append(g.warnings, fmt.Sprintf(format, a...))
+}
+
+func (g *pluginGraph) addNode(key, label string, kind nodeKind) *graphNode {
+	n := &graphNode{id: fmt.Sprintf("n%d", len(g.nodes)), label: label, kind: kind}
+	g.nodes = append(g.nodes, n)
+	g.keys[key] = n
+	return n
+}
+
+func (g *pluginGraph) addEdge(from, to *graphNode, label string, dashed bool) {
+	if from == nil || to == nil {
+		return
+	}
+	g.edges = append(g.edges, graphEdge{from: from, to: to, label: label, dashed: dashed})
+}
+
+// addSequence adds the rules of sequence tag. deps are the plugins that
+// the sequence uses.
+func (g *pluginGraph) addSequence(tag string, rules []sequence.RuleConfig, deps []string) {
+	entry := g.keys[tag]
+	prev, prevLabel := entry, ""
+	endAt := -1 // index of the rule that always ends the sequence.
+	linked := make(map[string]bool)
+	for i, r := range rules {
+		n := g.addNode(fmt.Sprintf("%s#%d", tag, i), fmt.Sprintf("r%d: %s", i, ruleString(r)), nodeRule)
+		n.seq = tag
+		n.unreachable = entry.unreachable || endAt >= 0
+		if endAt < 0 {
+			g.addEdge(prev, n, prevLabel, false)
+		}
+		if neverMatches(r) && endAt < 0 {
+			n.unreachable = true
+			g.warnf("sequence %s: rule r%d never matches", tag, i)
+		}
+
+		for _, mc := range r.Matches {
+			for _, t := range refTags(mc.Tag, mc.Args) {
+				g.addEdge(n, g.keys[t], "match", true)
+				linked[t] = true
+			}
+		}
+		switch {
+		case len(r.Tag) > 0:
+			label := ""
+			if g.keys[r.Tag] != nil && g.keys[r.Tag].kind == nodeSequence {
+				label = "call"
+			}
+			g.addEdge(n, g.keys[r.Tag], label, false)
+			linked[r.Tag] = true
+		case r.Type == "jump" || r.Type == "goto":
+			g.addEdge(n, g.keys[r.Args], r.Type, false)
+			linked[r.Args] = true
+		}
+		for _, t := range refTags("", r.Args) {
+			if !linked[t] {
+				g.addEdge(n, g.keys[t], "", true)
+				linked[t] = true
+			}
+		}
+
+		ends := slices.Contains([]string{"accept", "reject", "return", "goto"}, r.Type)
+		switch {
+		case ends && len(r.Matches) == 0 && endAt < 0:
+			endAt = i
+		case ends:
+			prevLabel = "no match"
+		default:
+			prevLabel = ""
+		}
+		prev = n
+	}
+	if endAt >= 0 && endAt < len(rules)-1 {
+		g.warnf("sequence %s: rules after r%d are unreachable, r%d always ends the sequence", tag, endAt, endAt)
+	}
+
+	// Plugins that are used in other ways, e.g. by quick setup args that
+	// this graph does not understand.
+	for _, dep := range deps {
+		if !linked[dep] {
+			g.addEdge(entry, g.keys[dep], "", true)
+		}
+	}
+}
+
+// ruleString formats r in the config syntax.
+func ruleString(r sequence.RuleConfig) string {
+	var b strings.Builder
+	for _, mc := range r.Matches {
+		if mc.Reverse {
+			b.WriteString("!")
+		}
+		if len(mc.Tag) > 0 {
+			b.WriteString("$" + mc.Tag)
+		} else {
+			b.WriteString(mc.Type)
+		}
+		if len(mc.Args) > 0 {
+			b.WriteString(" " + mc.Args)
+		}
+		b.WriteString("\n")
+	}
+	if len(r.Matches) > 0 {
+		b.WriteString("=> ")
+	}
+	if len(r.Tag) > 0 {
+		b.WriteString("$" + r.Tag)
+	} else {
+		b.WriteString(r.Type)
+	}
+	if len(r.Args) > 0 {
+		b.WriteString(" " + r.Args)
+	}
+	return b.String()
+}
+
+func neverMatches(r sequence.RuleConfig) bool {
+	return slices.ContainsFunc(r.Matches, func(mc sequence.MatchConfig) bool {
+		return len(mc.Tag) == 0 && (mc.Type == "_false" && !mc.Reverse || mc.Type == "_true" && mc.Reverse)
+	})
+}
+
+// refTags returns tag and the "$tag" references in args.
+func refTags(tag, args string) []string {
+	var tags []string
+	if len(tag) > 0 {
+		tags = append(tags, tag)
+	}
+	for _, f := range strings.Fields(args) {
+		if t, ok := strings.CutPrefix(f, "$"); ok && len(t) > 0 {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func (g *pluginGraph) writeDot(w io.Writer) error {
+	b := new(strings.Builder)
+	b.WriteString("digraph mosdns {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	node := func(indent string, n *graphNode) {
+		attrs := fmt.Sprintf("label=%q", n.label)
+		switch n.kind {
+		case nodePlugin:
+			attrs += ", style=rounded"
+		case nodeSequence:
+			attrs += ", shape=oval"
+		}
+		if n.unreachable {
+			attrs += ", color=red, fontcolor=red"
+		}
+		fmt.Fprintf(b, "%s%s [%s];\n", indent, n.id, attrs)
+	}
+	for _, n := range g.nodes {
+		switch n.kind {
+		case nodeRule:
+			continue
+		case nodeSequence:
+			fmt.Fprintf(b, "\tsubgraph cluster_%s {\n", n.id)
+			fmt.Fprintf(b, "\t\tlabel=%q;\n", "sequence "+n.label)
+			node("\t\t", n)
+			for _, r := range g.nodes {
+				if r.kind == nodeRule && g.keys[r.seq] == n {
+					node("\t\t", r)
+				}
+			}
+			b.WriteString("\t}\n")
+		default:
+			node("\t", n)
+		}
+	}
+	for _, e := range g.edges {
+		var attrs []string
+		if len(e.label) > 0 {
+			attrs = append(attrs, fmt.Sprintf("label=%q", e.label))
+		}
+		if e.dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(b, "\t%s -> %s [%s];\n", e.from.id, e.to.id, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(b, "\t%s -> %s;\n", e.from.id, e.to.id)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (g *pluginGraph) writeMermaid(w io.Writer) error {
+	b := new(strings.Builder)
+	b.WriteString("flowchart LR\n")
+	node := func(indent string, n *graphNode) {
+		label := mermaidText(n.label)
+		switch n.kind {
+		case nodePlugin:
+			fmt.Fprintf(b, "%s%s(\"%s\")\n", indent, n.id, label)
+		case nodeSequence:
+			fmt.Fprintf(b, "%s%s([\"%s\"])\n", indent, n.id, label)
+		default:
+			fmt.Fprintf(b, "%s%s[\"%s\"]\n", indent, n.id, label)
+		}
+	}
+	var unreachable []string
+	for _, n := range g.nodes {
+		if n.unreachable {
+			unreachable = append(unreachable, n.id)
+		}
+		switch n.kind {
+		case nodeRule:
+			continue
+		case nodeSequence:
+			fmt.Fprintf(b, "\tsubgraph %s_seq [\"sequence %s\"]\n", n.id, mermaidText(n.label))
+			node("\t\t", n)
+			for _, r := range g.nodes {
+				if r.kind == nodeRule && g.keys[r.seq] == n {
+					node("\t\t", r)
+				}
+			}
+			b.WriteString("\tend\n")
+		default:
+			node("\t", n)
+		}
+	}
+	for _, e := range g.edges {
+		arrow := "-->"
+		if e.dashed {
+			arrow = "-.->"
+		}
+		if len(e.label) > 0 {
+			fmt.Fprintf(b, "\t%s %s|\"%s\"| %s\n", e.from.id, arrow, mermaidText(e.label), e.to.id)
+		} else {
+			fmt.Fprintf(b, "\t%s %s %s\n", e.from.id, arrow, e.to.id)
+		}
+	}
+	if len(unreachable) > 0 {
+		b.WriteString("\tclassDef unreachable stroke:#f00,color:#f00,stroke-dasharray:5 5\n")
+		fmt.Fprintf(b, "\tclass %s unreachable\n", strings.Join(unreachable, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidText escapes s for a quoted mermaid label.
+func mermaidText(s string) string {
+	s = strings.ReplaceAll(s, "\"", "#quot;")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
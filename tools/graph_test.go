@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+)
+
+func Test_buildGraph(t *testing.T) {
+	p := writeTemp(t, "config.yaml", `
+log:
+  level: error
+plugins:
+  - tag: sub
+    type: sequence
+    args:
+      - exec: accept
+  - tag: main
+    type: sequence
+    args:
+      - matches: _false
+        exec: jump sub
+      - exec: goto sub
+      - exec: accept
+  - tag: entry
+    type: sequence
+    args:
+      - exec: $main
+`)
+	m, err := coremain.NewDryRunMosdns(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseWithErr(nil)
+
+	g := buildGraph(m)
+	wantWarnings := []string{
+		"sequence entry is not used by any plugin",
+		"sequence main: rule r0 never matches",
+		"sequence main: rules after r1 are unreachable, r1 always ends the sequence",
+	}
+	if !reflect.DeepEqual(g.warnings, wantWarnings) {
+		t.Fatalf("want warnings %v, got %v", wantWarnings, g.warnings)
+	}
+	for key, want := range map[string]bool{"sub#0": false, "main#0": true, "main#1": false, "main#2": true} {
+		if got := g.keys[key].unreachable; got != want {
+			t.Errorf("%s: want unreachable %v, got %v", key, want, got)
+		}
+	}
+
+	for _, format := range []string{"dot", "mermaid"} {
+		b := new(bytes.Buffer)
+		write := (*pluginGraph).writeDot
+		if format == "mermaid" {
+			write = (*pluginGraph).writeMermaid
+		}
+		if err := write(g, b); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), "goto") {
+			t.Errorf("%s: missing goto edge:\n%s", format, b)
+		}
+	}
+}
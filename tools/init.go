@@ -25,10 +25,7 @@ import (
 )
 
 func init() {
-	probeCmd := &cobra.Command{
-		Use:   "probe",
-		Short: "Run some server tests.",
-	}
+	probeCmd := newProbeCmd()
 	probeCmd.AddCommand(
 		newConnReuseCmd(),
 		newIdleTimeoutCmd(),
@@ -42,4 +39,11 @@ func init() {
 	}
 	configCmd.AddCommand(newGenCmd(), newConvCmd())
 	coremain.AddSubCmd(configCmd)
+
+	coremain.AddSubCmd(newCheckCmd())
+	coremain.AddSubCmd(newConvertCmd())
+	coremain.AddSubCmd(newGraphCmd())
+	coremain.AddSubCmd(newBenchCmd())
+	coremain.AddSubCmd(newAuditCmd())
+	coremain.AddSubCmd(newUbusCmd())
 }
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+type probeOpts struct {
+	config  string
+	dir     string
+	server  string
+	token   string
+	entry   string
+	timeout time.Duration
+}
+
+func newProbeCmd() *cobra.Command {
+	opts := new(probeOpts)
+	c := &cobra.Command{
+		Use:   "probe name [type] --entry tag [-c config_file | -s api_addr]",
+		Args:  cobra.RangeArgs(1, 2),
+		Short: "Trace a query through the pipeline, or run some server tests.",
+		Long: `Execute a query by the entry sequence and print the matchers and plugins that
+were executed, the upstream and the final answer. Type defaults to A.
+
+By default, the query is executed by the pipeline of the config, which is
+loaded without starting servers. With -s, it is executed by a running instance
+through its admin api (see api.admin_token).
+
+Note that the query is real. Upstreams are contacted, and caches and sets
+may be updated.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runProbe(opts, strings.Join(args, " ")); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+	}
+	fs := c.Flags()
+	fs.StringVarP(&opts.config, "config", "c", "", "config file")
+	fs.StringVarP(&opts.dir, "dir", "d", "", "working dir")
+	fs.StringVarP(&opts.server, "server", "s", "", "api address of a running instance, e.g. http://127.0.0.1:8080")
+	fs.StringVar(&opts.token, "token", "", "admin token of the running instance")
+	fs.StringVar(&opts.entry, "entry", "", "tag of the entry sequence")
+	fs.DurationVar(&opts.timeout, "timeout", time.Second*5, "query timeout")
+	c.MarkFlagRequired("entry")
+	c.MarkFlagFilename("config")
+	c.MarkFlagDirname("dir")
+	return c
+}
+
+func runProbe(opts *probeOpts, query string) error {
+	qs, err := parseSampleQueries([]string{query})
+	if err != nil {
+		return err
+	}
+	q := qs[0]
+
+	var res *sequence.ProbeResult
+	if len(opts.server) > 0 {
+		res, err = probeRemote(opts, q)
+	} else {
+		res, err = probeLocal(opts, q)
+	}
+	if err != nil {
+		return err
+	}
+	printProbeResult(os.Stdout, q, res)
+	if len(res.Error) > 0 {
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+func probeLocal(opts *probeOpts, q *dns.Msg) (*sequence.ProbeResult, error) {
+	if len(opts.dir) > 0 {
+		if err := os.Chdir(opts.dir); err != nil {
+			return nil, fmt.Errorf("failed to change the current working directory, %w", err)
+		}
+	}
+	m, err := coremain.NewDryRunMosdns(opts.config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config, %w", err)
+	}
+	defer func() {
+		m.CloseWithErr(nil)
+		_ = m.GetSafeClose().WaitClosed()
+	}()
+
+	entry := sequence.ToExecutable(m.GetPlugin(opts.entry))
+	if entry == nil {
+		return nil, fmt.Errorf("cannot find executable entry by tag %s", opts.entry)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	return sequence.Probe(ctx, entry, q), nil
+}
+
+func probeRemote(opts *probeOpts, q *dns.Msg) (*sequence.ProbeResult, error) {
+	server := opts.server
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	v := url.Values{}
+	v.Set("name", q.Question[0].Name)
+	v.Set("type", dns.TypeToString[q.Question[0].Qtype])
+	u := strings.TrimSuffix(server, "/") + "/admin/plugins/" + url.PathEscape(opts.entry) + "/probe?" + v.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout+time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("api returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	res := new(sequence.ProbeResult)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("invalid api response, %w", err)
+	}
+	return res, nil
+}
+
+func printProbeResult(w io.Writer, q *dns.Msg, res *sequence.ProbeResult) {
+	question := q.Question[0]
+	fmt.Fprintf(w, ";; %s %s\n", question.Name, dns.TypeToString[question.Qtype])
+	fmt.Fprintln(w, ";; stages:")
+	for _, s := range res.Stages {
+		var result string
+		switch {
+		case len(s.Error) > 0:
+			result = "error: " + s.Error
+		case s.Matched == nil:
+			result = "executed"
+		case *s.Matched:
+			result = "matched"
+		default:
+			result = "not matched"
+		}
+		fmt.Fprintf(w, ";;   +%-10s %-40s %-12s %s\n", s.Start.Round(time.Microsecond), s.Name, result, s.Duration.Round(time.Microsecond))
+	}
+	if len(res.Upstream) > 0 {
+		fmt.Fprintf(w, ";; upstream: %s\n", res.Upstream)
+	}
+	switch {
+	case len(res.Error) > 0:
+		fmt.Fprintf(w, ";; error: %s, took %s\n", res.Error, res.Took.Round(time.Microsecond))
+	case len(res.Rcode) == 0:
+		fmt.Fprintf(w, ";; no response, took %s\n", res.Took.Round(time.Microsecond))
+	default:
+		fmt.Fprintf(w, ";; %s, %d answer(s), took %s\n", res.Rcode, len(res.Answer), res.Took.Round(time.Microsecond))
+	}
+	for _, rr := range res.Answer {
+		fmt.Fprintln(w, rr)
+	}
+}
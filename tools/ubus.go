@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/spf13/cobra"
+)
+
+type ubusOpts struct {
+	pidFile string
+	api     string
+}
+
+func newUbusCmd() *cobra.Command {
+	o := new(ubusOpts)
+	c := &cobra.Command{
+		Use:   "ubus list | call method",
+		Args:  cobra.RangeArgs(1, 2),
+		Short: "A rpcd plugin that exposes mosdns as an ubus object on OpenWrt.",
+		Long: `A rpcd plugin that exposes mosdns as the ubus object "mosdns".
+
+It is installed to /usr/libexec/rpcd/mosdns by "mosdns service install"
+on OpenWrt. Methods:
+  status: whether mosdns is running and, if --api is set, ready.
+  reload: reload mosdns by SIGHUP.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runUbus(os.Stdout, o, args); err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	c.Flags().StringVar(&o.pidFile, "pid-file", "/var/run/mosdns.pid", "pid file of mosdns")
+	c.Flags().StringVar(&o.api, "api", "", "address of the http api of mosdns")
+	return c
+}
+
+// runUbus implements the protocol of rpcd plugins. "list" prints the
+// methods and "call" prints the result of a method, both in json.
+func runUbus(w io.Writer, o *ubusOpts, args []string) error {
+	methods := map[string]func() (any, error){
+		"status": o.status,
+		"reload": o.reload,
+	}
+	var out any
+	switch {
+	case args[0] == "list":
+		l := make(map[string]struct{})
+		for name := range methods {
+			l[name] = struct{}{}
+		}
+		out = l
+	case args[0] == "call" && len(args) == 2:
+		f, ok := methods[args[1]]
+		if !ok {
+			return fmt.Errorf("unknown method %s", args[1])
+		}
+		res, err := f()
+		if err != nil {
+			res = map[string]string{"error": err.Error()}
+		}
+		out = res
+	default:
+		return errors.New("usage: ubus list | call method")
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+type ubusStatus struct {
+	Running bool   `json:"running"`
+	Pid     int    `json:"pid,omitempty"`
+	Ready   *bool  `json:"ready,omitempty"` // nil if the api is unknown.
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (o *ubusOpts) status() (any, error) {
+	s := new(ubusStatus)
+	if p, err := o.process(); err == nil {
+		s.Running = true
+		s.Pid = p.Pid
+	}
+	if len(o.api) > 0 {
+		ready, reason := o.ready()
+		s.Ready = &ready
+		s.Reason = reason
+	}
+	return s, nil
+}
+
+func (o *ubusOpts) reload() (any, error) {
+	p, err := o.process()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		return nil, err
+	}
+	return map[string]bool{"reloading": true}, nil
+}
+
+// process returns the running mosdns from the pid file.
+func (o *ubusOpts) process() (*os.Process, error) {
+	b, err := os.ReadFile(o.pidFile)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pid file, %w", err)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil {
+		return nil, fmt.Errorf("mosdns is not running, %w", err)
+	}
+	return p, nil
+}
+
+// ready checks the "/readyz" api. If mosdns is not ready, reason is
+// the error.
+func (o *ubusOpts) ready() (ready bool, reason string) {
+	u := o.api
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	c := http.Client{Timeout: time.Second * 5}
+	resp, err := c.Get(strings.TrimSuffix(u, "/") + "/readyz")
+	if err != nil {
+		return false, err.Error()
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return false, strings.TrimSpace(string(b))
+	}
+	return true, ""
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_runUbus(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "mosdns.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "plugins are loading", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	call := func(o *ubusOpts, args ...string) map[string]any {
+		t.Helper()
+		b := new(bytes.Buffer)
+		if err := runUbus(b, o, args); err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]any)
+		if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	o := &ubusOpts{pidFile: pidFile, api: api.Listener.Addr().String()}
+	if m := call(o, "list"); len(m) != 2 || m["status"] == nil || m["reload"] == nil {
+		t.Fatalf("unexpected methods %v", m)
+	}
+	m := call(o, "call", "status")
+	if m["running"] != true || m["pid"] != float64(os.Getpid()) || m["ready"] != false || m["reason"] != "plugins are loading" {
+		t.Fatalf("unexpected status %v", m)
+	}
+
+	o = &ubusOpts{pidFile: filepath.Join(t.TempDir(), "missing.pid")}
+	if m := call(o, "call", "status"); m["running"] != false || m["ready"] != nil {
+		t.Fatalf("unexpected status %v", m)
+	}
+	if m := call(o, "call", "reload"); m["error"] == nil {
+		t.Fatalf("reload should fail if mosdns is not running, got %v", m)
+	}
+	if err := runUbus(new(bytes.Buffer), o, []string{"call", "missing"}); err == nil {
+		t.Fatal("unknown method should fail")
+	}
+}